@@ -31,6 +31,8 @@ external_rpm_cache                   = $(imggen_config_dir)/external_package_rep
 image_fetcher_tmp_dir                = $(imggen_config_dir)/fetcher_tmp
 image_roaster_tmp_dir                = $(imggen_config_dir)/roaster_tmp
 validate-config                      = $(STATUS_FLAGS_DIR)/validate-image-config-$(config_name).flag
+imager_disk_output_flag              = $(STATUS_FLAGS_DIR)/imager_disk_output-$(config_name).flag
+batch_base_rootfs_dir                = $(IMAGEGEN_DIR)/batch_base_rootfs
 meta_user_data_tmp_dir               = $(IMAGEGEN_DIR)/meta-user-data_tmp
 image_package_cache_summary          = $(imggen_config_dir)/image_deps.json
 image_external_package_cache_summary = $(imggen_config_dir)/image_external_deps.json
@@ -51,12 +53,12 @@ $(call create_folder,$(imager_disk_output_dir))
 $(call create_folder,$(artifact_dir))
 $(call create_folder,$(meta_user_data_tmp_dir))
 
-.PHONY: fetch-image-packages fetch-external-image-packages make-raw-image image iso initrd validate-image-config clean-imagegen
+.PHONY: fetch-image-packages fetch-external-image-packages make-raw-image image image-batch iso initrd validate-image-config clean-imagegen
 
 clean: clean-imagegen
 clean-imagegen:
 	rm -rf $(STATUS_FLAGS_DIR)/build_srpms.flag
-	rm -rf $(STATUS_FLAGS_DIR)/imager_disk_output.flag
+	rm -rf $(STATUS_FLAGS_DIR)/imager_disk_output-*.flag
 	rm -rf $(STATUS_FLAGS_DIR)/validate-image-config-*
 	rm -rf $(artifact_dir)
 	rm -rf $(IMAGES_DIR)
@@ -70,9 +72,11 @@ fetch-external-image-packages: $(image_external_package_cache_summary)
 # Validate the selected config file if any changes occur in the image config base directory.
 # Changes to files located outside the base directory will not be detected.
 validate-image-config: $(validate-config)
-$(STATUS_FLAGS_DIR)/validate-image-config%.flag: $(go-imageconfigvalidator) $(depend_CONFIG_FILE) $(CONFIG_FILE) $(config_other_files)
+$(STATUS_FLAGS_DIR)/validate-image-config%.flag: $(go-imageconfigvalidator) $(depend_CONFIG_FILE) $(depend_CONFIG_VARS) $(CONFIG_FILE) $(CONFIG_OVERLAYS) $(config_other_files)
 	$(go-imageconfigvalidator) \
 		--input=$(CONFIG_FILE) \
+		$(foreach overlay,$(CONFIG_OVERLAYS),--config-overlay="$(overlay)") \
+		$(foreach var,$(CONFIG_VARS),--config-var="$(var)") \
 		--dir=$(CONFIG_BASE_DIR) && \
 	touch $@
 
@@ -90,10 +94,12 @@ ifeq ($(USE_PREVIEW_REPO),y)
 imagepkgfetcher_extra_flags += --use-preview-repo
 endif
 
-$(image_package_cache_summary): $(go-imagepkgfetcher) $(chroot_worker) $(imggen_local_repo) $(depend_REPO_LIST) $(REPO_LIST) $(depend_CONFIG_FILE) $(CONFIG_FILE) $(validate-config) $(packagelist_files) $(RPMS_DIR) $(imggen_rpms)
+$(image_package_cache_summary): $(go-imagepkgfetcher) $(chroot_worker) $(imggen_local_repo) $(depend_REPO_LIST) $(REPO_LIST) $(depend_CONFIG_FILE) $(depend_CONFIG_VARS) $(CONFIG_FILE) $(CONFIG_OVERLAYS) $(validate-config) $(packagelist_files) $(RPMS_DIR) $(imggen_rpms)
 	$(if $(CONFIG_FILE),,$(error Must set CONFIG_FILE=))
 	$(go-imagepkgfetcher) \
 		--input=$(CONFIG_FILE) \
+		$(foreach overlay,$(CONFIG_OVERLAYS),--config-overlay="$(overlay)") \
+		$(foreach var,$(CONFIG_VARS),--config-var="$(var)") \
 		--base-dir=$(CONFIG_BASE_DIR) \
 		--log-level=$(LOG_LEVEL) \
 		--log-file=$(LOGS_DIR)/imggen/imagepkgfetcher.log \
@@ -108,48 +114,96 @@ $(image_package_cache_summary): $(go-imagepkgfetcher) $(chroot_worker) $(imggen_
 		--output-summary-file=$@ \
 		--output-dir=$(local_and_external_rpm_cache)
 
+# Lower the priority of qemu-img and mkfs on shared build machines
+image_resource_limit_flags = \
+	$(if $(IMAGE_NICE),--nice=$(IMAGE_NICE)) \
+	$(if $(IMAGE_IONICE_CLASS),--ionice-class=$(IMAGE_IONICE_CLASS)) \
+	$(if $(IMAGE_IONICE_LEVEL),--ionice-level=$(IMAGE_IONICE_LEVEL))
+
 make-raw-image: $(imager_disk_output_dir)
-$(imager_disk_output_dir): $(STATUS_FLAGS_DIR)/imager_disk_output.flag
+$(imager_disk_output_dir): $(imager_disk_output_flag)
 	@touch $@
 	@echo Finished updating $@
 
-$(STATUS_FLAGS_DIR)/imager_disk_output.flag: $(go-imager) $(image_package_cache_summary) $(imggen_local_repo) $(depend_CONFIG_FILE) $(CONFIG_FILE) $(validate-config) $(packagelist_files) $(assets_files) $(imggen_packagelist_files)
+# Each config has its own flag, so building one image never skips the imager of another
+$(imager_disk_output_flag): $(go-imager) $(image_package_cache_summary) $(imggen_local_repo) $(depend_CONFIG_FILE) $(depend_CONFIG_VARS) $(CONFIG_FILE) $(CONFIG_OVERLAYS) $(validate-config) $(packagelist_files) $(assets_files) $(imggen_packagelist_files) $(BASE_ROOTFS_TARBALL) $(BASE_ROOTFS_DIR)
 	$(if $(CONFIG_FILE),,$(error Must set CONFIG_FILE=))
 	mkdir -p $(imager_disk_output_dir) && \
 	rm -rf $(imager_disk_output_dir)/* && \
 	$(go-imager) \
 		--build-dir $(workspace_dir) \
 		--input $(CONFIG_FILE) \
+		$(foreach overlay,$(CONFIG_OVERLAYS),--config-overlay="$(overlay)") \
+		$(foreach var,$(CONFIG_VARS),--config-var="$(var)") \
+		$(if $(CONFIG_OVERLAYS)$(CONFIG_VARS),--effective-config=$(LOGS_DIR)/imggen/effective-config.json) \
 		--base-dir=$(CONFIG_BASE_DIR) \
 		--log-level=$(LOG_LEVEL) \
 		--log-file=$(LOGS_DIR)/imggen/imager.log \
 		--local-repo $(local_and_external_rpm_cache) \
 		--tdnf-worker $(BUILD_DIR)/worker/worker_chroot.tar.gz \
 		--repo-file=$(imggen_local_repo) \
+		$(foreach repo,$(IMAGER_EXTRA_LOCAL_REPOS),--extra-local-repo="$(repo)") \
 		--assets $(assets_dir) \
+		$(if $(filter y,$(SKIP_FS_CHECK)),--skip-fs-check) \
+		$(if $(IMAGER_DEBUG_HOOK),--debug-hook='$(IMAGER_DEBUG_HOOK)') \
+		$(if $(filter y,$(IMAGER_DEBUG_PAUSE)),--debug-pause) \
+		$(if $(IMAGER_CHECKPOINT_DIR),--checkpoint-dir=$(IMAGER_CHECKPOINT_DIR)) \
+		$(foreach partition,$(IMAGE_PARTITIONS),--partition="$(partition)") \
+		$(if $(BASE_ROOTFS_TARBALL),--base-rootfs-tarball=$(BASE_ROOTFS_TARBALL)) \
+		$(if $(BASE_ROOTFS_DIR),--base-rootfs-dir=$(BASE_ROOTFS_DIR)) \
+		$(image_resource_limit_flags) \
 		--output-dir $(imager_disk_output_dir) && \
 	touch $@
 
 # Sometimes files will have been deleted, that is fine so long as we were able to detect the change
 $(imager_disk_output_dir)/%: ;
 
-image: $(imager_disk_output_dir) $(imager_disk_output_files) $(go-roast) $(depend_CONFIG_FILE) $(CONFIG_FILE) $(validate-config)
+image: $(imager_disk_output_dir) $(imager_disk_output_files) $(go-roast) $(depend_CONFIG_FILE) $(depend_CONFIG_VARS) $(CONFIG_FILE) $(CONFIG_OVERLAYS) $(validate-config)
 	$(if $(CONFIG_FILE),,$(error Must set CONFIG_FILE=))
 	VMXTEMPLATE=$(ova_vmxtemplate) OVFINFO=$(ova_ovfinfo) \
 	$(go-roast) \
 		--dir=$(imager_disk_output_dir) \
 		--config $(CONFIG_FILE) \
+		$(foreach overlay,$(CONFIG_OVERLAYS),--config-overlay="$(overlay)") \
+		$(foreach var,$(CONFIG_VARS),--config-var="$(var)") \
 		--output-dir $(artifact_dir) \
 		--tmp-dir $(image_roaster_tmp_dir) \
 		--release-version $(RELEASE_VERSION) \
 		--log-level=$(LOG_LEVEL) \
 		--log-file=$(LOGS_DIR)/imggen/roast.log \
+		$(foreach partition,$(IMAGE_PARTITIONS),--partition="$(partition)") \
+		$(foreach checksum,$(IMAGE_CHECKSUMS),--checksum=$(checksum)) \
+		$(image_resource_limit_flags) \
+		$(if $(QEMU_IMG_COROUTINES),--qemu-img-coroutines=$(QEMU_IMG_COROUTINES)) \
+		$(if $(OVA_OS_TYPE),--ova-os-type="$(OVA_OS_TYPE)") \
+		$(if $(OVA_NETWORK),--ova-network="$(OVA_NETWORK)") \
+		$(if $(OVA_FIRMWARE),--ova-firmware=$(OVA_FIRMWARE)) \
+		$(if $(OVA_CPUS),--ova-cpus=$(OVA_CPUS)) \
+		$(if $(OVA_MEMORY),--ova-memory=$(OVA_MEMORY)) \
 		--image-tag=$(IMAGE_TAG)
 
-$(image_external_package_cache_summary): $(cached_file) $(go-imagepkgfetcher) $(depend_CONFIG_FILE) $(CONFIG_FILE) $(validate-config)
+# Build the shared base once and extract its rootfs tarball once, then build every variant on
+# top of the extracted rootfs, so the packages common to all variants are only installed once.
+# The imager clones the base into each variant's install root, copy-on-write where supported.
+image-batch:
+	$(if $(BATCH_BASE_CONFIG_FILE),,$(error Must set BATCH_BASE_CONFIG_FILE=))
+	$(if $(BATCH_CONFIG_FILES),,$(error Must set BATCH_CONFIG_FILES=))
+	$(MAKE) image CONFIG_FILE=$(BATCH_BASE_CONFIG_FILE) CONFIG_BASE_DIR=$(dir $(BATCH_BASE_CONFIG_FILE)) && \
+	base_rootfs=$$(find $(IMAGES_DIR)/$(notdir $(BATCH_BASE_CONFIG_FILE:%.json=%)) -maxdepth 1 -name '*.tar.gz' | head -n 1) && \
+	if [ -z "$$base_rootfs" ]; then echo "Base config $(BATCH_BASE_CONFIG_FILE) must produce a tar.gz rootfs artifact"; exit 1; fi && \
+	rm -rf $(batch_base_rootfs_dir) && \
+	mkdir -p $(batch_base_rootfs_dir) && \
+	tar --xattrs --xattrs-include='*' --numeric-owner -xpf $$base_rootfs -C $(batch_base_rootfs_dir) && \
+	for config in $(BATCH_CONFIG_FILES); do \
+		$(MAKE) image CONFIG_FILE=$$config CONFIG_BASE_DIR=$$(dirname $$config) BASE_ROOTFS_DIR=$(batch_base_rootfs_dir) || exit 1; \
+	done
+
+$(image_external_package_cache_summary): $(cached_file) $(go-imagepkgfetcher) $(depend_CONFIG_FILE) $(depend_CONFIG_VARS) $(CONFIG_FILE) $(CONFIG_OVERLAYS) $(validate-config)
 	$(if $(CONFIG_FILE),,$(error Must set CONFIG_FILE=))
 	$(go-imagepkgfetcher) \
 		--input=$(CONFIG_FILE) \
+		$(foreach overlay,$(CONFIG_OVERLAYS),--config-overlay="$(overlay)") \
+		$(foreach var,$(CONFIG_VARS),--config-var="$(var)") \
 		--base-dir=$(CONFIG_BASE_DIR) \
 		--log-level=$(LOG_LEVEL) \
 		--log-file=$(LOGS_DIR)/imggen/externalimagepkgfetcher.log \
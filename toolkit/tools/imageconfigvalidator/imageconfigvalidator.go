@@ -24,8 +24,10 @@ var (
 	logFile  = exe.LogFileFlag(app)
 	logLevel = exe.LogLevelFlag(app)
 
-	input       = exe.InputStringFlag(app, "Path to the image config file.")
-	baseDirPath = exe.InputDirFlag(app, "Base directory for relative file paths from the config.")
+	input          = exe.InputStringFlag(app, "Path to the image config file.")
+	configOverlays = app.Flag("config-overlay", "Path to a config file deep merged over the image config, in order. May be repeated.").ExistingFiles()
+	configVars     = exe.ConfigVarsFlag(app)
+	baseDirPath    = exe.InputDirFlag(app, "Base directory for relative file paths from the config.")
 )
 
 func main() {
@@ -41,7 +43,19 @@ func main() {
 	logger.PanicOnError(err, "Error when calculating input directory")
 
 	logger.Log.Infof("Reading configuration file (%s)", inPath)
-	config, err := configuration.LoadWithAbsolutePaths(inPath, baseDir)
+	configFiles := append([]string{inPath}, *configOverlays...)
+	configBytes, err := configuration.MergeConfigFiles(configFiles, *configVars)
+	if err != nil {
+		logger.Log.Fatalf("Failed to read image configuration '%s': %s", inPath, err)
+	}
+
+	// Catch structural problems, such as misspelled keys, which are silently ignored while loading.
+	_, err = configuration.ValidateConfigBytes(configBytes)
+	if err != nil {
+		logger.Log.Fatalf("Invalid configuration '%s': %s", inPath, err)
+	}
+
+	config, _, err := configuration.LoadLayeredWithAbsolutePaths(configFiles, baseDir, *configVars)
 	if err != nil {
 		logger.Log.Fatalf("Failed while loading image configuration '%s': %s", inPath, err)
 	}
@@ -63,6 +77,7 @@ func ValidateConfiguration(config configuration.Config) (err error) {
 	if err != nil {
 		return
 	}
+
 	err = validatePackages(config)
 	return
 }
@@ -81,6 +96,19 @@ func validatePackages(config configuration.Config) (err error) {
 		if err != nil {
 			return fmt.Errorf("%s: %w", validateError, err)
 		}
+
+		packageListPackages := make(map[string]bool)
+		for _, pkg := range packageList {
+			packageListPackages[pkg] = true
+		}
+		groupPackages := systemConfig.PackageInstallGroupPackages()
+		for _, pkg := range groupPackages {
+			if packageListPackages[pkg] {
+				return fmt.Errorf("%s: package '%s' is included in both the package lists and [PackageInstallGroups]", validateError, pkg)
+			}
+		}
+		packageList = append(packageList, groupPackages...)
+
 		foundSELinuxPackage := false
 		foundVerityInitramfsPackage := false
 		foundVerityInitramfsDebugPackage := false
@@ -105,6 +105,31 @@ func TestShouldFailDeeplyNestedParsingError(t *testing.T) {
 	assert.Failf(t, "Could not find config", "Could not find image config file '%s' to test", filepath.Join(configDirectory, targetPackage))
 }
 
+func TestShouldFailTooManyMbrPartitions(t *testing.T) {
+	const (
+		configDirectory string = "../../imageconfigs/"
+		targetPackage          = "core-legacy.json"
+	)
+
+	configPath := filepath.Join(configDirectory, targetPackage)
+	config, err := configuration.LoadWithAbsolutePaths(configPath, configDirectory)
+	assert.NoError(t, err)
+
+	// Move the legacy image onto an MBR disk with one partition more than it can hold
+	config.Disks[0].PartitionTableType = configuration.PartitionTableTypeMbr
+	config.Disks[0].Partitions = []configuration.Partition{
+		{ID: "boot", Start: 1, End: 9, FsType: "ext4"},
+		{ID: "data1", Start: 9, End: 10, FsType: "ext4"},
+		{ID: "data2", Start: 10, End: 11, FsType: "ext4"},
+		{ID: "data3", Start: 11, End: 12, FsType: "ext4"},
+		{ID: "rootfs", Start: 12, End: 0, FsType: "ext4"},
+	}
+
+	err = ValidateConfiguration(config)
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [Disks]: [PartitionTableType] 'mbr' supports at most 4 partitions, found 5; use 'gpt' instead", err.Error())
+}
+
 func TestShouldFailMissingVerityPackageWithVerityRoot(t *testing.T) {
 	const (
 		configDirectory       string = "../../imageconfigs/"
@@ -39,9 +39,9 @@ func main() {
 				KernelOptions: map[string]string{
 					"default": "kernel",
 				},
-				AdditionalFiles: map[string]string{
-					"/etc/resolv.conf": "/etc/resolv.conf",
-					"/root/.bashrc":    "/root/.bashrc",
+				AdditionalFiles: map[string]configuration.AdditionalFile{
+					"/etc/resolv.conf": configuration.AdditionalFile{Path: "/etc/resolv.conf"},
+					"/root/.bashrc":    configuration.AdditionalFile{Path: "/root/.bashrc"},
 				},
 				PostInstallScripts: []configuration.PostInstallScript{
 					configuration.PostInstallScript{
@@ -63,9 +63,9 @@ func main() {
 				KernelOptions: map[string]string{
 					"default": "kernel",
 				},
-				AdditionalFiles: map[string]string{
-					"/etc/resolv.conf": "/etc/resolv.conf",
-					"/root/.bashrc":    "/root/.bashrc",
+				AdditionalFiles: map[string]configuration.AdditionalFile{
+					"/etc/resolv.conf": configuration.AdditionalFile{Path: "/etc/resolv.conf"},
+					"/root/.bashrc":    configuration.AdditionalFile{Path: "/root/.bashrc"},
 				},
 				PostInstallScripts: []configuration.PostInstallScript{
 					configuration.PostInstallScript{
@@ -87,9 +87,9 @@ func main() {
 				KernelOptions: map[string]string{
 					"default": "kernel",
 				},
-				AdditionalFiles: map[string]string{
-					"/etc/resolv.conf": "/etc/resolv.conf",
-					"/root/.bashrc":    "/root/.bashrc",
+				AdditionalFiles: map[string]configuration.AdditionalFile{
+					"/etc/resolv.conf": configuration.AdditionalFile{Path: "/etc/resolv.conf"},
+					"/root/.bashrc":    configuration.AdditionalFile{Path: "/root/.bashrc"},
 				},
 				ReadOnlyVerityRoot: verityConfig,
 				PostInstallScripts: []configuration.PostInstallScript{
@@ -0,0 +1,95 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Parser for the image builder's configuration schemas.
+
+package configuration
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+const (
+	// AdditionalFilesCheckWarn logs a warning for each additional file replacing a file of an installed package
+	AdditionalFilesCheckWarn = "warn"
+	// AdditionalFilesCheckError fails the build if an additional file replaces a file of an installed package
+	AdditionalFilesCheckError = "error"
+)
+
+var (
+	// Octal permission bits, optionally including the setuid/setgid/sticky digit, ie "644" or "0600"
+	additionalFileModeRegex = regexp.MustCompile(`^0?[0-7]{3,4}$`)
+	// Owners and groups may be given as a numeric ID or a user/group name
+	additionalFileOwnerRegex = regexp.MustCompile(`^([0-9]+|[a-z_][a-z0-9_-]*[$]?)$`)
+)
+
+// AdditionalFile describes where a local file is placed in the image and, optionally,
+// the ownership and permissions it should end up with.
+// An AdditionalFile may be given in the config either as a plain destination path string,
+// or as an object with "Path", "Owner", "Group", "Mode" and "ReplacesPackageFile" fields.
+// ReplacesPackageFile marks a file meant to replace a file of an installed package, which
+// the AdditionalFilesCheck then skips.
+type AdditionalFile struct {
+	Path                string `json:"Path"`
+	Owner               string `json:"Owner"`
+	Group               string `json:"Group"`
+	Mode                string `json:"Mode"`
+	ReplacesPackageFile bool   `json:"ReplacesPackageFile"`
+}
+
+// HasPermissions returns true if the file's ownership or mode should be changed after copying it.
+func (a *AdditionalFile) HasPermissions() bool {
+	return a.Owner != "" || a.Group != "" || a.Mode != ""
+}
+
+// FileMode returns the parsed "Mode" of the file.
+func (a *AdditionalFile) FileMode() (mode uint64, err error) {
+	return strconv.ParseUint(a.Mode, 8, 32)
+}
+
+// IsValid returns an error if the AdditionalFile is not valid
+func (a *AdditionalFile) IsValid() (err error) {
+	if a.Path == "" {
+		return fmt.Errorf("missing [Path] field")
+	}
+
+	if a.Owner != "" && !additionalFileOwnerRegex.MatchString(a.Owner) {
+		return fmt.Errorf("invalid [Owner] (%s), must be a numeric ID or a user name", a.Owner)
+	}
+
+	if a.Group != "" && !additionalFileOwnerRegex.MatchString(a.Group) {
+		return fmt.Errorf("invalid [Group] (%s), must be a numeric ID or a group name", a.Group)
+	}
+
+	if a.Mode != "" && !additionalFileModeRegex.MatchString(a.Mode) {
+		return fmt.Errorf("invalid [Mode] (%s), must be an octal file mode such as '0644'", a.Mode)
+	}
+
+	return
+}
+
+// UnmarshalJSON Unmarshals an AdditionalFile entry
+func (a *AdditionalFile) UnmarshalJSON(b []byte) (err error) {
+	// Support the original format, where only the destination path is given
+	var path string
+	if json.Unmarshal(b, &path) == nil {
+		*a = AdditionalFile{Path: path}
+	} else {
+		// Use an intermediate type which will use the default JSON unmarshal implementation
+		type IntermediateTypeAdditionalFile AdditionalFile
+		err = json.Unmarshal(b, (*IntermediateTypeAdditionalFile)(a))
+		if err != nil {
+			return fmt.Errorf("failed to parse [AdditionalFile]: %w", err)
+		}
+	}
+
+	// Now validate the resulting unmarshaled object
+	err = a.IsValid()
+	if err != nil {
+		return fmt.Errorf("failed to parse [AdditionalFile]: %w", err)
+	}
+	return
+}
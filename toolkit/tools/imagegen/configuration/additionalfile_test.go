@@ -0,0 +1,80 @@
+// Copyright Microsoft Corporation.
+// Licensed under the MIT License.
+
+package configuration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//TestMain found in configuration_test.go.
+
+var (
+	validAdditionalFile AdditionalFile = AdditionalFile{
+		Path:  "/etc/secret.conf",
+		Owner: "root",
+		Group: "0",
+		Mode:  "0600",
+	}
+	validAdditionalFilePathJSON = `"/final/system/path"`
+	invalidAdditionalFileJSON   = `{"Path": "/etc/secret.conf", "Mode": "rw-------"}`
+)
+
+func TestShouldSucceedParsingValidAdditionalFile_AdditionalFile(t *testing.T) {
+	var checkedAdditionalFile AdditionalFile
+
+	assert.NoError(t, validAdditionalFile.IsValid())
+	err := remarshalJSON(validAdditionalFile, &checkedAdditionalFile)
+	assert.NoError(t, err)
+	assert.Equal(t, validAdditionalFile, checkedAdditionalFile)
+	assert.True(t, checkedAdditionalFile.HasPermissions())
+
+	mode, err := checkedAdditionalFile.FileMode()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0600), mode)
+}
+
+func TestShouldSucceedParsingPathString_AdditionalFile(t *testing.T) {
+	var checkedAdditionalFile AdditionalFile
+
+	err := marshalJSONString(validAdditionalFilePathJSON, &checkedAdditionalFile)
+	assert.NoError(t, err)
+	assert.Equal(t, AdditionalFile{Path: "/final/system/path"}, checkedAdditionalFile)
+	assert.False(t, checkedAdditionalFile.HasPermissions())
+}
+
+func TestShouldFailParsingInvalidMode_AdditionalFile(t *testing.T) {
+	var checkedAdditionalFile AdditionalFile
+
+	err := marshalJSONString(invalidAdditionalFileJSON, &checkedAdditionalFile)
+	assert.Error(t, err)
+	assert.Equal(t, "failed to parse [AdditionalFile]: invalid [Mode] (rw-------), must be an octal file mode such as '0644'", err.Error())
+}
+
+func TestShouldFailParsingInvalidOwner_AdditionalFile(t *testing.T) {
+	invalidAdditionalFile := validAdditionalFile
+	invalidAdditionalFile.Owner = "Bad Owner"
+
+	err := invalidAdditionalFile.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [Owner] (Bad Owner), must be a numeric ID or a user name", err.Error())
+}
+
+func TestShouldFailParsingInvalidGroup_AdditionalFile(t *testing.T) {
+	invalidAdditionalFile := validAdditionalFile
+	invalidAdditionalFile.Group = "bad:group"
+
+	err := invalidAdditionalFile.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [Group] (bad:group), must be a numeric ID or a group name", err.Error())
+}
+
+func TestShouldFailParsingMissingPath_AdditionalFile(t *testing.T) {
+	var checkedAdditionalFile AdditionalFile
+
+	err := marshalJSONString(`{"Mode": "0644"}`, &checkedAdditionalFile)
+	assert.Error(t, err)
+	assert.Equal(t, "failed to parse [AdditionalFile]: missing [Path] field", err.Error())
+}
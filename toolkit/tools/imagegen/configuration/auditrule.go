@@ -0,0 +1,110 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Parser for the image builder's configuration schemas.
+
+package configuration
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	// auditRuleNameRegex matches the file names augenrules loads from /etc/audit/rules.d
+	auditRuleNameRegex = regexp.MustCompile(`^[A-Za-z0-9_.-]+\.rules$`)
+	// auditRuleOptions are the auditctl options a rules file may use, mapped to whether they take an argument
+	auditRuleOptions = map[string]bool{
+		"-a": true, "-A": true, "-d": true, "-w": true, "-W": true, "-p": true, "-k": true, "-F": true, "-S": true, "-C": true,
+		"-b": true, "-f": true, "-e": true, "-r": true, "-D": false, "-i": false, "-c": false,
+		"--backlog_wait_time": true, "--reset-lost": false, "--loginuid-immutable": false,
+	}
+	// auditRuleSectionOptions are the options a rule has to start with
+	auditRuleSectionOptions = map[string]bool{
+		"-a": true, "-A": true, "-d": true, "-w": true, "-W": true, "-b": true, "-f": true, "-e": true, "-r": true,
+		"-D": true, "-i": true, "-c": true, "--backlog_wait_time": true, "--reset-lost": true, "--loginuid-immutable": true,
+	}
+)
+
+// AuditRule installs an audit rules file under /etc/audit/rules.d.
+//   - Name: The file name, which must end in ".rules"
+//   - Path: Path of a rules file to install
+//   - Rules: Rules to install, one per entry, instead of a file
+type AuditRule struct {
+	Name  string   `json:"Name"`
+	Path  string   `json:"Path"`
+	Rules []string `json:"Rules"`
+}
+
+// IsValid returns an error if the AuditRule is not valid
+func (a *AuditRule) IsValid() (err error) {
+	if !auditRuleNameRegex.MatchString(a.Name) {
+		return fmt.Errorf("invalid [Name] (%s), must be a file name ending in '.rules'", a.Name)
+	}
+
+	if (a.Path == "") == (len(a.Rules) == 0) {
+		return fmt.Errorf("exactly one of [Path] and [Rules] must be set for (%s)", a.Name)
+	}
+
+	if len(a.Rules) != 0 {
+		err = ValidateAuditRules(strings.Join(a.Rules, "\n"))
+		if err != nil {
+			return fmt.Errorf("invalid [Rules] for (%s): %w", a.Name, err)
+		}
+	}
+
+	return
+}
+
+// ValidateAuditRules checks the syntax of the contents of an audit rules file. Every rule must be a
+// list of auditctl options, as augenrules passes them to "auditctl -R".
+func ValidateAuditRules(rules string) (err error) {
+	const commentPrefix = "#"
+
+	for i, line := range strings.Split(rules, "\n") {
+		lineNumber := i + 1
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], commentPrefix) {
+			continue
+		}
+
+		if !auditRuleSectionOptions[fields[0]] {
+			return fmt.Errorf("line %d: rule must start with an auditctl option such as -a, -w or -e, not (%s)", lineNumber, fields[0])
+		}
+
+		for j := 0; j < len(fields); j++ {
+			option := fields[j]
+			takesArgument, known := auditRuleOptions[option]
+			if !known {
+				return fmt.Errorf("line %d: unknown auditctl option (%s)", lineNumber, option)
+			}
+			if takesArgument {
+				if j+1 == len(fields) || strings.HasPrefix(fields[j+1], "-") {
+					return fmt.Errorf("line %d: option (%s) requires an argument", lineNumber, option)
+				}
+				j++
+			}
+		}
+	}
+
+	return
+}
+
+// UnmarshalJSON Unmarshals an AuditRule entry
+func (a *AuditRule) UnmarshalJSON(b []byte) (err error) {
+	// Use an intermediate type which will use the default JSON unmarshal implementation
+	type IntermediateTypeAuditRule AuditRule
+	err = json.Unmarshal(b, (*IntermediateTypeAuditRule)(a))
+	if err != nil {
+		return fmt.Errorf("failed to parse [AuditRule]: %w", err)
+	}
+
+	// Now validate the resulting unmarshaled object
+	err = a.IsValid()
+	if err != nil {
+		return fmt.Errorf("failed to parse [AuditRule]: %w", err)
+	}
+	return
+}
@@ -0,0 +1,70 @@
+// Copyright Microsoft Corporation.
+// Licensed under the MIT License.
+
+package configuration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//TestMain found in configuration_test.go.
+
+var (
+	validAuditRule AuditRule = AuditRule{
+		Name: "50-identity.rules",
+		Rules: []string{
+			"# Record changes to the account databases",
+			"-w /etc/passwd -p wa -k identity",
+			"-w /etc/shadow -p wa -k identity",
+			"-a always,exit -F arch=b64 -S sethostname,setdomainname -F auid>=1000 -F auid!=unset -k system-locale",
+		},
+	}
+	invalidAuditRuleJSON = `{"Name": "50-identity", "Path": "files/50-identity.rules"}`
+)
+
+func TestShouldSucceedParsingValidAuditRule_AuditRule(t *testing.T) {
+	var checkedAuditRule AuditRule
+	err := remarshalJSON(validAuditRule, &checkedAuditRule)
+	assert.NoError(t, err)
+	assert.Equal(t, validAuditRule, checkedAuditRule)
+}
+
+func TestShouldSucceedParsingControlRules_AuditRule(t *testing.T) {
+	assert.NoError(t, ValidateAuditRules("-D\n-b 8192\n--backlog_wait_time 60000\n-f 1\n\n-e 2\n"))
+}
+
+func TestShouldFailParsingNameWithoutSuffix_AuditRule(t *testing.T) {
+	var checkedAuditRule AuditRule
+	err := marshalJSONString(invalidAuditRuleJSON, &checkedAuditRule)
+	assert.Error(t, err)
+	assert.Equal(t, "failed to parse [AuditRule]: invalid [Name] (50-identity), must be a file name ending in '.rules'", err.Error())
+}
+
+func TestShouldFailParsingPathAndRules_AuditRule(t *testing.T) {
+	invalidAuditRule := validAuditRule
+	invalidAuditRule.Path = "files/50-identity.rules"
+
+	err := invalidAuditRule.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "exactly one of [Path] and [Rules] must be set for (50-identity.rules)", err.Error())
+}
+
+func TestShouldFailParsingRuleWithoutOption_AuditRule(t *testing.T) {
+	err := ValidateAuditRules("-w /etc/passwd -p wa\nalways,exit -S open")
+	assert.Error(t, err)
+	assert.Equal(t, "line 2: rule must start with an auditctl option such as -a, -w or -e, not (always,exit)", err.Error())
+}
+
+func TestShouldFailParsingMissingArgument_AuditRule(t *testing.T) {
+	err := ValidateAuditRules("-w /etc/passwd -p -k identity")
+	assert.Error(t, err)
+	assert.Equal(t, "line 1: option (-p) requires an argument", err.Error())
+}
+
+func TestShouldFailParsingUnknownOption_AuditRule(t *testing.T) {
+	err := ValidateAuditRules("-w /etc/passwd -x wa")
+	assert.Error(t, err)
+	assert.Equal(t, "line 1: unknown auditctl option (-x)", err.Error())
+}
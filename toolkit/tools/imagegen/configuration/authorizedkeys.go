@@ -0,0 +1,118 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Parser for the image builder's configuration schemas.
+
+package configuration
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// sshPublicKeyTypes are the key types sshd accepts in an authorized_keys file
+var sshPublicKeyTypes = map[string]bool{
+	"ssh-ed25519":                        true,
+	"ssh-rsa":                            true,
+	"ssh-dss":                            true,
+	"ecdsa-sha2-nistp256":                true,
+	"ecdsa-sha2-nistp384":                true,
+	"ecdsa-sha2-nistp521":                true,
+	"sk-ssh-ed25519@openssh.com":         true,
+	"sk-ecdsa-sha2-nistp256@openssh.com": true,
+}
+
+// AuthorizedKeys maps the name of a user to the SSH public keys written to the user's ~/.ssh/authorized_keys.
+// Each key is a line of an authorized_keys file, optionally starting with options such as from="10.0.0.0/8".
+type AuthorizedKeys map[string][]string
+
+// Users returns the names of the users with authorized keys in sorted order
+func (a AuthorizedKeys) Users() (users []string) {
+	users = make([]string, 0, len(a))
+	for user := range a {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+	return
+}
+
+// IsValid returns an error if the AuthorizedKeys are not valid
+func (a AuthorizedKeys) IsValid() (err error) {
+	for _, user := range a.Users() {
+		if strings.TrimSpace(user) == "" {
+			return fmt.Errorf("user names cannot be empty")
+		}
+		if len(a[user]) == 0 {
+			return fmt.Errorf("no keys given for user (%s)", user)
+		}
+		for i, key := range a[user] {
+			if err = ValidateSSHPublicKey(key); err != nil {
+				return fmt.Errorf("key %d of user (%s) is not valid: %w", i, user, err)
+			}
+		}
+	}
+	return
+}
+
+// ValidateSSHPublicKey checks a line of an authorized_keys file holds a public key of a known type,
+// and that the base64 encoded key data is of the same type.
+func ValidateSSHPublicKey(key string) (err error) {
+	const keyTypeLengthBytes = 4
+
+	if strings.ContainsAny(key, "\r\n") {
+		return fmt.Errorf("must be a single line")
+	}
+
+	fields := strings.Fields(key)
+	typeIndex := -1
+	for i, field := range fields {
+		if sshPublicKeyTypes[field] {
+			typeIndex = i
+			break
+		}
+	}
+	if typeIndex < 0 {
+		return fmt.Errorf("no known key type, such as ssh-ed25519 or ssh-rsa, found")
+	}
+	if typeIndex+1 == len(fields) {
+		return fmt.Errorf("(%s) key data is missing", fields[typeIndex])
+	}
+
+	keyType := fields[typeIndex]
+	blob, decodeErr := base64.StdEncoding.DecodeString(fields[typeIndex+1])
+	if decodeErr != nil {
+		return fmt.Errorf("(%s) key data is not valid base64: %w", keyType, decodeErr)
+	}
+
+	// The key data starts with its own type as a length-prefixed string
+	if len(blob) < keyTypeLengthBytes {
+		return fmt.Errorf("(%s) key data is too short", keyType)
+	}
+	typeLength := binary.BigEndian.Uint32(blob)
+	if uint64(len(blob)-keyTypeLengthBytes) < uint64(typeLength) || string(blob[keyTypeLengthBytes:keyTypeLengthBytes+int(typeLength)]) != keyType {
+		return fmt.Errorf("key data does not hold a (%s) key", keyType)
+	}
+
+	return
+}
+
+// UnmarshalJSON Unmarshals an AuthorizedKeys entry
+func (a *AuthorizedKeys) UnmarshalJSON(b []byte) (err error) {
+	// Use an intermediate type which will use the default JSON unmarshal implementation
+	type IntermediateTypeAuthorizedKeys AuthorizedKeys
+	err = json.Unmarshal(b, (*IntermediateTypeAuthorizedKeys)(a))
+	if err != nil {
+		return fmt.Errorf("failed to parse [AuthorizedKeys]: %w", err)
+	}
+
+	// Now validate the resulting unmarshaled object
+	err = a.IsValid()
+	if err != nil {
+		return fmt.Errorf("failed to parse [AuthorizedKeys]: %w", err)
+	}
+	return
+}
@@ -0,0 +1,46 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Parser for the image builder's configuration schemas.
+
+package configuration
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// ChangeReport writes a JSON report of what the build changed on top of the base image
+// next to the output image.
+//   - Enable: Report the packages added, removed and changed
+//   - IncludeFiles: Also report the files added, removed and changed, which requires
+//     reading every file in the image twice
+type ChangeReport struct {
+	Enable       bool `json:"Enable"`
+	IncludeFiles bool `json:"IncludeFiles"`
+}
+
+// IsValid returns an error if the ChangeReport is not valid
+func (c *ChangeReport) IsValid() (err error) {
+	if c.IncludeFiles && !c.Enable {
+		return fmt.Errorf("[IncludeFiles] requires [Enable] to be set")
+	}
+	return
+}
+
+// UnmarshalJSON Unmarshals a ChangeReport entry
+func (c *ChangeReport) UnmarshalJSON(b []byte) (err error) {
+	// Use an intermediate type which will use the default JSON unmarshal implementation
+	type IntermediateTypeChangeReport ChangeReport
+	err = json.Unmarshal(b, (*IntermediateTypeChangeReport)(c))
+	if err != nil {
+		return fmt.Errorf("failed to parse [ChangeReport]: %w", err)
+	}
+
+	// Now validate the resulting unmarshaled object
+	err = c.IsValid()
+	if err != nil {
+		return fmt.Errorf("failed to parse [ChangeReport]: %w", err)
+	}
+	return
+}
@@ -0,0 +1,34 @@
+// Copyright Microsoft Corporation.
+// Licensed under the MIT License.
+
+package configuration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//TestMain found in configuration_test.go.
+
+var (
+	validChangeReport ChangeReport = ChangeReport{
+		Enable:       true,
+		IncludeFiles: true,
+	}
+	invalidChangeReportJSON = `{"IncludeFiles": true}`
+)
+
+func TestShouldSucceedParsingValidChangeReport_ChangeReport(t *testing.T) {
+	var checkedChangeReport ChangeReport
+	err := remarshalJSON(validChangeReport, &checkedChangeReport)
+	assert.NoError(t, err)
+	assert.Equal(t, validChangeReport, checkedChangeReport)
+}
+
+func TestShouldFailParsingFilesWithoutEnable_ChangeReport(t *testing.T) {
+	var checkedChangeReport ChangeReport
+	err := marshalJSONString(invalidChangeReportJSON, &checkedChangeReport)
+	assert.Error(t, err)
+	assert.Equal(t, "failed to parse [ChangeReport]: [IncludeFiles] requires [Enable] to be set", err.Error())
+}
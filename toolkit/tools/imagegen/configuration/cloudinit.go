@@ -0,0 +1,106 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Parser for the image builder's configuration schemas.
+
+package configuration
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	// CloudInitConfigName is the cloud.cfg.d file the Datasources and Config settings are written to
+	CloudInitConfigName = "90-imager.cfg"
+
+	cloudInitDatasourceListKey = "datasource_list"
+	cloudInitConfigSuffix      = ".cfg"
+)
+
+var (
+	// cloudInitDatasourceRegex matches cloud-init datasource names, e.g. "Azure", "NoCloud" or "None"
+	cloudInitDatasourceRegex = regexp.MustCompile(`^[A-Za-z0-9]+$`)
+)
+
+// CloudInit customizes cloud-init through files in /etc/cloud/cloud.cfg.d.
+//   - Datasources: The datasources cloud-init tries, in order
+//   - Config: cloud-init settings, written out as a cloud.cfg.d file
+//   - ConfigFiles: Paths of cloud.cfg.d files to install, which must end in ".cfg"
+type CloudInit struct {
+	Datasources []string               `json:"Datasources"`
+	Config      map[string]interface{} `json:"Config"`
+	ConfigFiles []string               `json:"ConfigFiles"`
+}
+
+// IsEmpty returns true if no cloud-init setting is set
+func (c *CloudInit) IsEmpty() bool {
+	return len(c.Datasources) == 0 && len(c.Config) == 0 && len(c.ConfigFiles) == 0
+}
+
+// IsValid returns an error if the CloudInit is not valid
+func (c *CloudInit) IsValid() (err error) {
+	for _, datasource := range c.Datasources {
+		if !cloudInitDatasourceRegex.MatchString(datasource) {
+			return fmt.Errorf("invalid [Datasources] entry (%s), must be a cloud-init datasource name such as 'Azure' or 'NoCloud'", datasource)
+		}
+	}
+
+	if _, found := c.Config[cloudInitDatasourceListKey]; found && len(c.Datasources) != 0 {
+		return fmt.Errorf("[Config] may not set '%s' together with [Datasources]", cloudInitDatasourceListKey)
+	}
+
+	names := make(map[string]bool)
+	for _, configFile := range c.ConfigFiles {
+		name := filepath.Base(configFile)
+		if !strings.HasSuffix(name, cloudInitConfigSuffix) {
+			return fmt.Errorf("invalid [ConfigFiles] entry (%s), cloud-init only reads files ending in '%s'", configFile, cloudInitConfigSuffix)
+		}
+		if name == CloudInitConfigName || names[name] {
+			return fmt.Errorf("invalid [ConfigFiles] entry (%s), the file name '%s' is already used", configFile, name)
+		}
+		names[name] = true
+	}
+
+	return
+}
+
+// GetConfigDocument returns the Datasources and Config settings as a cloud-init config document.
+// JSON is a subset of YAML, so cloud-init reads it as is.
+func (c *CloudInit) GetConfigDocument() (document []byte, err error) {
+	config := make(map[string]interface{}, len(c.Config)+1)
+	for key, value := range c.Config {
+		config[key] = value
+	}
+	if len(c.Datasources) != 0 {
+		config[cloudInitDatasourceListKey] = c.Datasources
+	}
+
+	document, err = json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return
+	}
+
+	document = append(document, '\n')
+	return
+}
+
+// UnmarshalJSON Unmarshals a CloudInit entry
+func (c *CloudInit) UnmarshalJSON(b []byte) (err error) {
+	// Use an intermediate type which will use the default JSON unmarshal implementation
+	type IntermediateTypeCloudInit CloudInit
+	err = json.Unmarshal(b, (*IntermediateTypeCloudInit)(c))
+	if err != nil {
+		return fmt.Errorf("failed to parse [CloudInit]: %w", err)
+	}
+
+	// Now validate the resulting unmarshaled object
+	err = c.IsValid()
+	if err != nil {
+		return fmt.Errorf("failed to parse [CloudInit]: %w", err)
+	}
+	return
+}
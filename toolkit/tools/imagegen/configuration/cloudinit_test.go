@@ -0,0 +1,68 @@
+// Copyright Microsoft Corporation.
+// Licensed under the MIT License.
+
+package configuration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//TestMain found in configuration_test.go.
+
+var (
+	validCloudInit CloudInit = CloudInit{
+		Datasources: []string{"Azure", "None"},
+		Config: map[string]interface{}{
+			"disable_root": true,
+		},
+		ConfigFiles: []string{"cloudinit/10-network.cfg"},
+	}
+	invalidCloudInitJSON = `{"Datasources": ["Azure", "no cloud"]}`
+)
+
+func TestShouldSucceedParsingDefaultCloudInit_CloudInit(t *testing.T) {
+	var checkedCloudInit CloudInit
+	err := marshalJSONString("{}", &checkedCloudInit)
+	assert.NoError(t, err)
+	assert.True(t, checkedCloudInit.IsEmpty())
+}
+
+func TestShouldSucceedParsingValidCloudInit_CloudInit(t *testing.T) {
+	var checkedCloudInit CloudInit
+	err := remarshalJSON(validCloudInit, &checkedCloudInit)
+	assert.NoError(t, err)
+	assert.Equal(t, validCloudInit, checkedCloudInit)
+}
+
+func TestShouldRenderConfigDocument_CloudInit(t *testing.T) {
+	document, err := validCloudInit.GetConfigDocument()
+	assert.NoError(t, err)
+	assert.Equal(t, "{\n  \"datasource_list\": [\n    \"Azure\",\n    \"None\"\n  ],\n  \"disable_root\": true\n}\n", string(document))
+}
+
+func TestShouldFailParsingInvalidDatasource_CloudInit(t *testing.T) {
+	var checkedCloudInit CloudInit
+	err := marshalJSONString(invalidCloudInitJSON, &checkedCloudInit)
+	assert.Error(t, err)
+	assert.Equal(t, "failed to parse [CloudInit]: invalid [Datasources] entry (no cloud), must be a cloud-init datasource name such as 'Azure' or 'NoCloud'", err.Error())
+}
+
+func TestShouldFailParsingDatasourceListInConfig_CloudInit(t *testing.T) {
+	invalidCloudInit := validCloudInit
+	invalidCloudInit.Config = map[string]interface{}{"datasource_list": []string{"Ec2"}}
+
+	err := invalidCloudInit.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "[Config] may not set 'datasource_list' together with [Datasources]", err.Error())
+}
+
+func TestShouldFailParsingConfigFileWithoutCfgSuffix_CloudInit(t *testing.T) {
+	invalidCloudInit := validCloudInit
+	invalidCloudInit.ConfigFiles = []string{"cloudinit/10-network.yaml"}
+
+	err := invalidCloudInit.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [ConfigFiles] entry (cloudinit/10-network.yaml), cloud-init only reads files ending in '.cfg'", err.Error())
+}
@@ -11,16 +11,33 @@ import (
 	"path/filepath"
 
 	"microsoft.com/pkggen/internal/file"
-	"microsoft.com/pkggen/internal/jsonutils"
 	"microsoft.com/pkggen/internal/logger"
 )
 
 // Artifact [non-ISO image building only] defines the name, type
 // and optional compression of the output Mariner image.
+// MaxSize and MaxVirtualSize optionally limit, in MiB, the size of the
+// artifact file and of the image it was converted from.
 type Artifact struct {
-	Compression string `json:"Compression"`
-	Name        string `json:"Name"`
-	Type        string `json:"Type"`
+	Compression    string `json:"Compression"`
+	Name           string `json:"Name"`
+	Type           string `json:"Type"`
+	MaxSize        uint64 `json:"MaxSize"`
+	MaxVirtualSize uint64 `json:"MaxVirtualSize"`
+}
+
+// CheckSize returns an error if the artifact's size, or the virtual size of the image
+// it was converted from, exceeds its MaxSize or MaxVirtualSize. Sizes are in bytes.
+func (a *Artifact) CheckSize(size, virtualSize uint64) (err error) {
+	if a.MaxVirtualSize != 0 && virtualSize > a.MaxVirtualSize*mibSize {
+		return fmt.Errorf("artifact (%s) has a virtual size of %d bytes, which exceeds its [MaxVirtualSize] of %d MiB (%d bytes)", a.Name, virtualSize, a.MaxVirtualSize, a.MaxVirtualSize*mibSize)
+	}
+
+	if a.MaxSize != 0 && size > a.MaxSize*mibSize {
+		return fmt.Errorf("artifact (%s) is %d bytes, which exceeds its [MaxSize] of %d MiB (%d bytes)", a.Name, size, a.MaxSize, a.MaxSize*mibSize)
+	}
+
+	return
 }
 
 // RawBinary allow the users to specify a binary they would
@@ -38,13 +55,6 @@ type TargetDisk struct {
 	Value string `json:"Value"`
 }
 
-// PostInstallScript defines a script to be ran after other installation
-// steps are finished and provides a way to pass parameters to it.
-type PostInstallScript struct {
-	Args string `json:"Args"`
-	Path string `json:"Path"`
-}
-
 // Group defines a single group to be created on the new system.
 type Group struct {
 	Name string `json:"Name"`
@@ -80,6 +90,16 @@ func (c *Config) GetDiskPartByID(ID string) (disk *Partition) {
 	return nil
 }
 
+// FilterPartitionArtifacts drops the artifacts of every partition of every disk that is not matched by one
+// of filters, see Disk.FilterPartitionArtifacts. A filter must match a partition of at least one of the disks.
+func (c *Config) FilterPartitionArtifacts(partitionSettings []PartitionSetting, filters []string) (err error) {
+	disks := make([]*Disk, 0, len(c.Disks))
+	for i := range c.Disks {
+		disks = append(disks, &c.Disks[i])
+	}
+	return filterPartitionArtifacts(disks, partitionSettings, filters)
+}
+
 // checkDeviceMapperFlags checks if Encryption and read-only roots have the required 'dmroot' flag.
 // They need the root partition to have a specific flag so we can find the partition and handle it
 // before we mount it.
@@ -122,6 +142,115 @@ func checkDeviceMapperFlags(config *Config) (err error) {
 	return
 }
 
+// checkPartitionTableBootTypes checks that UEFI, hybrid and read-only verity root systems are only installed
+// onto GPT disks. MBR disks are only supported for legacy BIOS boot.
+func checkPartitionTableBootTypes(config *Config) (err error) {
+	for _, sysConfig := range config.SystemConfigs {
+		if sysConfig.ReadOnlyVerityRoot.Enable {
+			for _, disk := range config.Disks {
+				if diskHasSystemPartitions(disk, sysConfig) && disk.PartitionTableType == PartitionTableTypeMbr {
+					return fmt.Errorf("[SystemConfig] '%s' enables [ReadOnlyVerityRoot], which requires a '%s' [PartitionTableType], but its partitions are on a '%s' disk", sysConfig.Name, PartitionTableTypeGpt, PartitionTableTypeMbr)
+				}
+			}
+		}
+
+		if sysConfig.BootType != BootTypeEfi && sysConfig.BootType != BootTypeHybrid {
+			continue
+		}
+		for _, disk := range config.Disks {
+			if !diskHasSystemPartitions(disk, sysConfig) {
+				continue
+			}
+			if disk.PartitionTableType == PartitionTableTypeMbr {
+				return fmt.Errorf("[SystemConfig] '%s' uses [BootType] '%s', which requires a '%s' [PartitionTableType], but its partitions are on a '%s' disk", sysConfig.Name, sysConfig.BootType, PartitionTableTypeGpt, PartitionTableTypeMbr)
+			}
+			if sysConfig.BootType == BootTypeHybrid {
+				err = checkHybridBootPartitions(disk, sysConfig)
+				if err != nil {
+					return
+				}
+			}
+		}
+	}
+	return
+}
+
+// checkHybridBootPartitions checks that a disk booted with BootType hybrid has both a BIOS boot partition,
+// where grub's core image is embedded for BIOS firmware, and an EFI system partition for UEFI firmware.
+func checkHybridBootPartitions(disk Disk, sysConfig SystemConfig) (err error) {
+	hasBiosBoot, hasEsp := false, false
+	for _, partition := range disk.Partitions {
+		if partition.HasFlag(PartitionFlagBiosGrub) || partition.HasFlag(PartitionFlagBiosGrubLegacy) || partition.HasFlag(PartitionFlagGrub) {
+			hasBiosBoot = true
+		}
+		if partition.HasFlag(PartitionFlagESP) {
+			hasEsp = true
+		}
+	}
+
+	if !hasBiosBoot {
+		return fmt.Errorf("[SystemConfig] '%s' uses [BootType] '%s', which requires a partition with the '%s' flag on its disk", sysConfig.Name, BootTypeHybrid, PartitionFlagBiosGrub)
+	}
+	if !hasEsp {
+		return fmt.Errorf("[SystemConfig] '%s' uses [BootType] '%s', which requires a partition with the '%s' flag on its disk", sysConfig.Name, BootTypeHybrid, PartitionFlagESP)
+	}
+	return
+}
+
+// diskHasSystemPartitions returns true if one of the disk's partitions is used by the system config
+func diskHasSystemPartitions(disk Disk, sysConfig SystemConfig) bool {
+	for _, partSetting := range sysConfig.PartitionSettings {
+		for _, partition := range disk.Partitions {
+			if partition.ID == partSetting.ID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkGrowFsOnBootPartitions checks the file systems grown on first boot can be resized while mounted
+func checkGrowFsOnBootPartitions(config *Config) (err error) {
+	for _, sysConfig := range config.SystemConfigs {
+		for _, partSetting := range sysConfig.PartitionSettings {
+			if !partSetting.GrowFsOnBoot {
+				continue
+			}
+
+			part := config.GetDiskPartByID(partSetting.ID)
+			if part == nil {
+				continue
+			}
+			if part.FsType != "ext3" && part.FsType != "ext4" {
+				return fmt.Errorf("[PartitionSetting] '%s' of [SystemConfig] '%s' sets [GrowFsOnBoot], which is only supported for ext3 and ext4 file systems, not (%s)", partSetting.ID, sysConfig.Name, part.FsType)
+			}
+			if part.HasFlag(PartitionFlagDeviceMapperRoot) {
+				return fmt.Errorf("[PartitionSetting] '%s' of [SystemConfig] '%s' may not use [GrowFsOnBoot] together with the '%s' flag", partSetting.ID, sysConfig.Name, PartitionFlagDeviceMapperRoot)
+			}
+		}
+	}
+	return
+}
+
+// checkSourceImagePartitions checks the partitions copied from a [SourceImage] are not mounted, since the build
+// writes into every mounted partition, which would change the imported file system and break its signatures
+func checkSourceImagePartitions(config *Config) (err error) {
+	for _, sysConfig := range config.SystemConfigs {
+		for _, partSetting := range sysConfig.PartitionSettings {
+			if partSetting.MountPoint == "" {
+				continue
+			}
+
+			part := config.GetDiskPartByID(partSetting.ID)
+			if part == nil || part.SourceImage == "" {
+				continue
+			}
+			return fmt.Errorf("[PartitionSetting] '%s' of [SystemConfig] '%s' may not set a [MountPoint] (%s), its partition is copied from a [SourceImage] which the build must not write into", partSetting.ID, sysConfig.Name, partSetting.MountPoint)
+		}
+	}
+	return
+}
+
 // IsValid returns an error if the Config is not valid
 func (c *Config) IsValid() (err error) {
 	for _, disk := range c.Disks {
@@ -138,6 +267,18 @@ func (c *Config) IsValid() (err error) {
 	if len(c.SystemConfigs) == 0 {
 		return fmt.Errorf("config file must provide at least one system configuration inside the [SystemConfigs] field")
 	}
+	err = checkPartitionTableBootTypes(c)
+	if err != nil {
+		return
+	}
+	err = checkGrowFsOnBootPartitions(c)
+	if err != nil {
+		return
+	}
+	err = checkSourceImagePartitions(c)
+	if err != nil {
+		return
+	}
 	for _, sysConfig := range c.SystemConfigs {
 		if err = sysConfig.IsValid(); err != nil {
 			return fmt.Errorf("invalid [SystemConfigs]: %w", err)
@@ -172,17 +313,41 @@ func (c *Config) UnmarshalJSON(b []byte) (err error) {
 	return
 }
 
-// Load loads the config schema from a JSON file found under the 'configFilePath'.
+// Load loads the config schema from a JSON file found under the 'configFilePath'. The variables it
+// references are looked up in the environment.
 func Load(configFilePath string) (config Config, err error) {
 	logger.Log.Debugf("Reading config file from '%s'.", configFilePath)
 
-	err = jsonutils.ReadJSONFile(configFilePath, &config)
+	data, err := readConfigFile(configFilePath, nil)
+	if err != nil {
+		return
+	}
+
+	return parseConfig(data)
+}
+
+// parseConfig unmarshals the config JSON, selects the default system config and resolves the partition sizes.
+func parseConfig(data []byte) (config Config, err error) {
+	err = json.Unmarshal(data, &config)
 	if err != nil {
 		return
 	}
 
 	config.setDefaultConfig()
 
+	err = config.resolvePartitionSizes()
+
+	return
+}
+
+// resolvePartitionSizes converts relative partition sizes into absolute offsets on every disk.
+func (c *Config) resolvePartitionSizes() (err error) {
+	for i := range c.Disks {
+		err = c.Disks[i].ResolvePartitionSizes()
+		if err != nil {
+			return fmt.Errorf("failed to resolve partition sizes for [Disk] (%d): %w", i, err)
+		}
+	}
 	return
 }
 
@@ -195,13 +360,28 @@ func LoadWithAbsolutePaths(configFilePath, baseDirPath string) (config Config, e
 		return
 	}
 
+	err = config.resolveAbsolutePaths(baseDirPath, configFilePath)
+
+	return
+}
+
+// resolveAbsolutePaths converts the config's relative paths into absolute ones using 'baseDirPath', or the
+// directory of the config file under 'configFilePath' if it is empty.
+func (c *Config) resolveAbsolutePaths(baseDirPath, configFilePath string) (err error) {
 	baseDirPath, err = resolveBaseDirPath(baseDirPath, configFilePath)
 	if err != nil {
 		logger.Log.Errorf("Failed to resolve base directory path (%s) for config under (%s)", baseDirPath, configFilePath)
 		return
 	}
 
-	config.convertToAbsolutePaths(baseDirPath)
+	c.convertToAbsolutePaths(baseDirPath)
+
+	for _, systemConfig := range c.SystemConfigs {
+		err = systemConfig.GrubTheme.checkThemeFile()
+		if err != nil {
+			return fmt.Errorf("invalid [GrubTheme] of [SystemConfig] (%s): %w", systemConfig.Name, err)
+		}
+	}
 
 	return
 }
@@ -211,6 +391,7 @@ func (c *Config) convertToAbsolutePaths(baseDirPath string) {
 	for i := range c.Disks {
 		diskConfig := &c.Disks[i]
 		convertRawBinariesPath(baseDirPath, diskConfig)
+		convertPartitionSourceImagePaths(baseDirPath, diskConfig)
 	}
 
 	for i := range c.SystemConfigs {
@@ -218,8 +399,23 @@ func (c *Config) convertToAbsolutePaths(baseDirPath string) {
 
 		convertAdditionalFilesPath(baseDirPath, systemConfig)
 		convertPackageListPaths(baseDirPath, systemConfig)
+		convertLocalPackagePaths(baseDirPath, systemConfig)
 		convertPostInstallScriptsPaths(baseDirPath, systemConfig)
 		convertSSHPubKeys(baseDirPath, systemConfig)
+		convertBaseRootfsTarballPath(baseDirPath, systemConfig)
+		convertBaseRootfsDirPath(baseDirPath, systemConfig)
+		convertVeritySigningPaths(baseDirPath, systemConfig)
+		convertGrubCfgTemplatePath(baseDirPath, systemConfig)
+		convertGrubThemePaths(baseDirPath, systemConfig)
+		convertFirmwarePaths(baseDirPath, systemConfig)
+		convertInitramfsCustomizationPaths(baseDirPath, systemConfig)
+		convertEspPaths(baseDirPath, systemConfig)
+		convertSecureBootPaths(baseDirPath, systemConfig)
+		convertUdevRulePaths(baseDirPath, systemConfig)
+		convertAuditRulePaths(baseDirPath, systemConfig)
+		convertSystemdDropInPaths(baseDirPath, systemConfig)
+		convertFirewallRulesetPath(baseDirPath, systemConfig)
+		convertCloudInitConfigFilePaths(baseDirPath, systemConfig)
 	}
 }
 
@@ -229,11 +425,19 @@ func convertRawBinariesPath(baseDirPath string, diskConfig *Disk) {
 	}
 }
 
+func convertPartitionSourceImagePaths(baseDirPath string, diskConfig *Disk) {
+	for i, partition := range diskConfig.Partitions {
+		if partition.SourceImage != "" {
+			diskConfig.Partitions[i].SourceImage = file.GetAbsPathWithBase(baseDirPath, partition.SourceImage)
+		}
+	}
+}
+
 func convertAdditionalFilesPath(baseDirPath string, systemConfig *SystemConfig) {
-	absAdditionalFiles := make(map[string]string)
-	for localFilePath, targetFilePath := range systemConfig.AdditionalFiles {
+	absAdditionalFiles := make(map[string]AdditionalFile)
+	for localFilePath, targetFile := range systemConfig.AdditionalFiles {
 		localFilePath = file.GetAbsPathWithBase(baseDirPath, localFilePath)
-		absAdditionalFiles[localFilePath] = targetFilePath
+		absAdditionalFiles[localFilePath] = targetFile
 	}
 	systemConfig.AdditionalFiles = absAdditionalFiles
 }
@@ -244,8 +448,18 @@ func convertPackageListPaths(baseDirPath string, systemConfig *SystemConfig) {
 	}
 }
 
+func convertLocalPackagePaths(baseDirPath string, systemConfig *SystemConfig) {
+	for i, localPackagePath := range systemConfig.LocalPackages {
+		systemConfig.LocalPackages[i] = file.GetAbsPathWithBase(baseDirPath, localPackagePath)
+	}
+}
+
 func convertPostInstallScriptsPaths(baseDirPath string, systemConfig *SystemConfig) {
 	for i, postInstallScript := range systemConfig.PostInstallScripts {
+		// Inline scripts have no file to resolve
+		if postInstallScript.Path == "" {
+			continue
+		}
 		systemConfig.PostInstallScripts[i].Path = file.GetAbsPathWithBase(baseDirPath, postInstallScript.Path)
 	}
 }
@@ -258,6 +472,109 @@ func convertSSHPubKeys(baseDirPath string, systemConfig *SystemConfig) {
 	}
 }
 
+func convertBaseRootfsTarballPath(baseDirPath string, systemConfig *SystemConfig) {
+	if systemConfig.BaseRootfsTarball != "" {
+		systemConfig.BaseRootfsTarball = file.GetAbsPathWithBase(baseDirPath, systemConfig.BaseRootfsTarball)
+	}
+}
+
+func convertBaseRootfsDirPath(baseDirPath string, systemConfig *SystemConfig) {
+	if systemConfig.BaseRootfsDir != "" {
+		systemConfig.BaseRootfsDir = file.GetAbsPathWithBase(baseDirPath, systemConfig.BaseRootfsDir)
+	}
+}
+
+func convertGrubCfgTemplatePath(baseDirPath string, systemConfig *SystemConfig) {
+	if systemConfig.GrubCfgTemplate != "" {
+		systemConfig.GrubCfgTemplate = file.GetAbsPathWithBase(baseDirPath, systemConfig.GrubCfgTemplate)
+	}
+}
+
+func convertGrubThemePaths(baseDirPath string, systemConfig *SystemConfig) {
+	if systemConfig.GrubTheme.Directory != "" {
+		systemConfig.GrubTheme.Directory = file.GetAbsPathWithBase(baseDirPath, systemConfig.GrubTheme.Directory)
+	}
+	if systemConfig.GrubTheme.Background != "" {
+		systemConfig.GrubTheme.Background = file.GetAbsPathWithBase(baseDirPath, systemConfig.GrubTheme.Background)
+	}
+}
+
+func convertFirmwarePaths(baseDirPath string, systemConfig *SystemConfig) {
+	for i, firmwareFile := range systemConfig.Firmware.Files {
+		systemConfig.Firmware.Files[i].Source = file.GetAbsPathWithBase(baseDirPath, firmwareFile.Source)
+	}
+}
+
+func convertInitramfsCustomizationPaths(baseDirPath string, systemConfig *SystemConfig) {
+	for i, initramfsFile := range systemConfig.InitramfsCustomization.Files {
+		systemConfig.InitramfsCustomization.Files[i].Source = file.GetAbsPathWithBase(baseDirPath, initramfsFile.Source)
+	}
+	if systemConfig.InitramfsCustomization.Script != "" {
+		systemConfig.InitramfsCustomization.Script = file.GetAbsPathWithBase(baseDirPath, systemConfig.InitramfsCustomization.Script)
+	}
+}
+
+func convertEspPaths(baseDirPath string, systemConfig *SystemConfig) {
+	for i, espFile := range systemConfig.Esp.Files {
+		systemConfig.Esp.Files[i].Source = file.GetAbsPathWithBase(baseDirPath, espFile.Source)
+	}
+}
+
+func convertSecureBootPaths(baseDirPath string, systemConfig *SystemConfig) {
+	if systemConfig.SecureBoot.ShimSource != "" {
+		systemConfig.SecureBoot.ShimSource = file.GetAbsPathWithBase(baseDirPath, systemConfig.SecureBoot.ShimSource)
+	}
+	if systemConfig.SecureBoot.GrubSource != "" {
+		systemConfig.SecureBoot.GrubSource = file.GetAbsPathWithBase(baseDirPath, systemConfig.SecureBoot.GrubSource)
+	}
+	for i, certificate := range systemConfig.SecureBoot.MokCertificates {
+		systemConfig.SecureBoot.MokCertificates[i] = file.GetAbsPathWithBase(baseDirPath, certificate)
+	}
+}
+
+func convertUdevRulePaths(baseDirPath string, systemConfig *SystemConfig) {
+	for i, rule := range systemConfig.UdevRules {
+		if rule.Path != "" {
+			systemConfig.UdevRules[i].Path = file.GetAbsPathWithBase(baseDirPath, rule.Path)
+		}
+	}
+}
+
+func convertSystemdDropInPaths(baseDirPath string, systemConfig *SystemConfig) {
+	for i, dropIn := range systemConfig.SystemdDropIns {
+		if dropIn.Path != "" {
+			systemConfig.SystemdDropIns[i].Path = file.GetAbsPathWithBase(baseDirPath, dropIn.Path)
+		}
+	}
+}
+
+func convertAuditRulePaths(baseDirPath string, systemConfig *SystemConfig) {
+	for i, rule := range systemConfig.AuditRules {
+		if rule.Path != "" {
+			systemConfig.AuditRules[i].Path = file.GetAbsPathWithBase(baseDirPath, rule.Path)
+		}
+	}
+}
+
+func convertFirewallRulesetPath(baseDirPath string, systemConfig *SystemConfig) {
+	if systemConfig.Firewall.Ruleset != "" {
+		systemConfig.Firewall.Ruleset = file.GetAbsPathWithBase(baseDirPath, systemConfig.Firewall.Ruleset)
+	}
+}
+
+func convertCloudInitConfigFilePaths(baseDirPath string, systemConfig *SystemConfig) {
+	for i, configFile := range systemConfig.CloudInit.ConfigFiles {
+		systemConfig.CloudInit.ConfigFiles[i] = file.GetAbsPathWithBase(baseDirPath, configFile)
+	}
+}
+
+func convertVeritySigningPaths(baseDirPath string, systemConfig *SystemConfig) {
+	if systemConfig.ReadOnlyVerityRoot.RootHashSigningKey != "" {
+		systemConfig.ReadOnlyVerityRoot.RootHashSigningKey = file.GetAbsPathWithBase(baseDirPath, systemConfig.ReadOnlyVerityRoot.RootHashSigningKey)
+		systemConfig.ReadOnlyVerityRoot.RootHashSigningCert = file.GetAbsPathWithBase(baseDirPath, systemConfig.ReadOnlyVerityRoot.RootHashSigningCert)
+	}
+}
+
 // resolveBaseDirPath returns an absolute path to the base directory or
 // the absolute path to the config file directory if `baseDirPath` is empty.
 func resolveBaseDirPath(baseDirPath, configFilePath string) (absoluteBaseDirPath string, err error) {
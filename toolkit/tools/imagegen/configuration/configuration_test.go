@@ -158,11 +158,35 @@ func TestShouldFailDeviceMapperWithMultipleRoots(t *testing.T) {
 
 }
 
+func TestShouldFailPartitionInBackupGptHeader(t *testing.T) {
+	var checkedConfig Config
+	testConfig := expectedConfiguration
+
+	ExtraPartition := Partition{
+		ID:     "MyExtra",
+		Start:  uint64(1024),
+		End:    uint64(4096),
+		FsType: "ext4",
+	}
+
+	// Copy the disks, then add a partition ending at the end of the first one
+	testConfig.Disks = append([]Disk{}, expectedConfiguration.Disks...)
+	testConfig.Disks[0].Partitions = append(append([]Partition{}, testConfig.Disks[0].Partitions...), ExtraPartition)
+
+	err := testConfig.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [Disks]: [Partition] 'MyExtra' ends at 4096 MiB, inside the last 1 MiB of the disk's [MaxSize] of 4096 MiB, which are reserved for the backup partition table", err.Error())
+
+	err = remarshalJSON(testConfig, &checkedConfig)
+	assert.Error(t, err)
+	assert.Equal(t, "failed to parse [Config]: failed to parse [Disk]: [Partition] 'MyExtra' ends at 4096 MiB, inside the last 1 MiB of the disk's [MaxSize] of 4096 MiB, which are reserved for the backup partition table", err.Error())
+}
+
 var expectedConfiguration Config = Config{
 	Disks: []Disk{
 		{
 			PartitionTableType: "gpt",
-			MaxSize:            uint64(1024),
+			MaxSize:            uint64(4096),
 			TargetDisk: TargetDisk{
 				Type:  "path",
 				Value: "/dev/sda",
@@ -254,12 +278,6 @@ var expectedConfiguration Config = Config{
 						"dmroot",
 					},
 				},
-				{
-					ID:     "SharedData",
-					Start:  uint64(2048),
-					End:    uint64(0),
-					FsType: "ext4",
-				},
 			},
 		},
 	},
@@ -289,9 +307,10 @@ var expectedConfiguration Config = Config{
 				"default": "kernel",
 				"hyperv":  "kernel-hyperv",
 			},
-			AdditionalFiles: map[string]string{
-				"local/path/file1": "/final/system/path",
-				"local/path/file2": "/final/system/path/renamedfile2",
+			AdditionalFiles: map[string]AdditionalFile{
+				"local/path/file1": {Path: "/final/system/path"},
+				"local/path/file2": {Path: "/final/system/path/renamedfile2"},
+				"local/path/file3": {Path: "/final/system/path/secretfile3", Owner: "root", Group: "root", Mode: "0600"},
 			},
 			Hostname: "Mariner-Test",
 			BootType: "efi",
@@ -368,11 +387,6 @@ var expectedConfiguration Config = Config{
 					MountPoint: "/",
 					RemoveDocs: true,
 				},
-				{
-					ID:           "SharedData",
-					MountPoint:   "/some/path/to/data",
-					MountOptions: "ro,noexec",
-				},
 			},
 			PackageLists: []string{
 				"path/to/packages.json",
@@ -401,11 +415,6 @@ var expectedConfiguration Config = Config{
 					MountPoint: "/",
 					RemoveDocs: true,
 				},
-				{
-					ID:           "SharedData",
-					MountPoint:   "/some/path/to/data",
-					MountOptions: "ro,noexec",
-				},
 			},
 			PackageLists: []string{
 				"path/to/packages.json",
@@ -424,3 +433,157 @@ var expectedConfiguration Config = Config{
 		},
 	},
 }
+
+// mbrLegacyConfig returns a config installing a legacy BIOS system onto an MBR disk
+func mbrLegacyConfig() Config {
+	return Config{
+		Disks: []Disk{
+			{
+				PartitionTableType: PartitionTableTypeMbr,
+				MaxSize:            uint64(1024),
+				Partitions: []Partition{
+					{ID: "MyBoot", Flags: []PartitionFlag{PartitionFlagBoot}, Start: uint64(1), End: uint64(9), FsType: "ext4"},
+					{ID: "MyRootfs", Flags: []PartitionFlag{PartitionFlagDeviceMapperRoot}, Start: uint64(9), End: uint64(0), FsType: "ext4"},
+				},
+			},
+		},
+		SystemConfigs: []SystemConfig{
+			{
+				Name:         "LegacyMbr",
+				IsDefault:    true,
+				BootType:     BootTypeLegacy,
+				PackageLists: []string{"path/to/packages.json"},
+				KernelOptions: map[string]string{
+					"default": "kernel",
+				},
+				PartitionSettings: []PartitionSetting{
+					{ID: "MyBoot", MountPoint: "/boot"},
+					{ID: "MyRootfs", MountPoint: "/"},
+				},
+			},
+		},
+	}
+}
+
+func TestShouldSucceedLegacyBootOnMbrDisk(t *testing.T) {
+	testConfig := mbrLegacyConfig()
+
+	err := testConfig.IsValid()
+	assert.NoError(t, err)
+}
+
+func TestShouldFailEfiBootOnMbrDisk(t *testing.T) {
+	testConfig := mbrLegacyConfig()
+	testConfig.SystemConfigs[0].BootType = BootTypeEfi
+
+	err := testConfig.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "[SystemConfig] 'LegacyMbr' uses [BootType] 'efi', which requires a 'gpt' [PartitionTableType], but its partitions are on a 'mbr' disk", err.Error())
+}
+
+func TestShouldFailReadOnlyVerityRootOnMbrDisk(t *testing.T) {
+	testConfig := mbrLegacyConfig()
+	testConfig.SystemConfigs[0].ReadOnlyVerityRoot = ReadOnlyVerityRoot{
+		Enable: true,
+		Name:   "verity_root_fs",
+	}
+
+	err := testConfig.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "[SystemConfig] 'LegacyMbr' enables [ReadOnlyVerityRoot], which requires a 'gpt' [PartitionTableType], but its partitions are on a 'mbr' disk", err.Error())
+}
+
+func TestShouldFailMountedSourceImagePartition(t *testing.T) {
+	testConfig := mbrLegacyConfig()
+	testConfig.Disks[0].Partitions[0].SourceImage = "images/boot.img"
+
+	err := testConfig.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "[PartitionSetting] 'MyBoot' of [SystemConfig] 'LegacyMbr' may not set a [MountPoint] (/boot), its partition is copied from a [SourceImage] which the build must not write into", err.Error())
+}
+
+func TestShouldSucceedUnmountedSourceImagePartition(t *testing.T) {
+	testConfig := mbrLegacyConfig()
+	testConfig.Disks[0].Partitions[0].SourceImage = "images/boot.img"
+	testConfig.SystemConfigs[0].PartitionSettings = testConfig.SystemConfigs[0].PartitionSettings[1:]
+
+	err := testConfig.IsValid()
+	assert.NoError(t, err)
+}
+
+func TestShouldFailHybridBootWithoutBiosBootPartition(t *testing.T) {
+	testConfig := expectedConfiguration
+	testConfig.SystemConfigs = append([]SystemConfig{}, expectedConfiguration.SystemConfigs...)
+	testConfig.SystemConfigs[0].BootType = BootTypeHybrid
+
+	err := testConfig.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "[SystemConfig] 'SmallerDisk' uses [BootType] 'hybrid', which requires a partition with the 'bios_grub' flag on its disk", err.Error())
+}
+
+func TestShouldSucceedHybridBootWithBiosBootAndEsp(t *testing.T) {
+	testConfig := expectedConfiguration
+	testConfig.SystemConfigs = append([]SystemConfig{}, expectedConfiguration.SystemConfigs...)
+	testConfig.SystemConfigs[0].BootType = BootTypeHybrid
+
+	// Add a BIOS boot partition in front of the first disk's ESP
+	testConfig.Disks = append([]Disk{}, expectedConfiguration.Disks...)
+	testConfig.Disks[0].Partitions = append([]Partition{{
+		ID:    "MyBiosBoot",
+		Flags: []PartitionFlag{PartitionFlagBiosGrub},
+		Start: uint64(1),
+		End:   uint64(3),
+	}}, expectedConfiguration.Disks[0].Partitions...)
+
+	err := testConfig.IsValid()
+	assert.NoError(t, err)
+}
+
+func TestShouldFailGrowFsOnBootForVfat(t *testing.T) {
+	testConfig := expectedConfiguration
+	testConfig.SystemConfigs = append([]SystemConfig{}, expectedConfiguration.SystemConfigs...)
+	testConfig.SystemConfigs[0].PartitionSettings = append([]PartitionSetting{}, expectedConfiguration.SystemConfigs[0].PartitionSettings...)
+	testConfig.SystemConfigs[0].PartitionSettings[0].GrowFsOnBoot = true
+
+	err := testConfig.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "[PartitionSetting] 'MyBoot' of [SystemConfig] 'SmallerDisk' sets [GrowFsOnBoot], which is only supported for ext3 and ext4 file systems, not (fat32)", err.Error())
+}
+
+func TestShouldFilterPartitionArtifactsAcrossDisks(t *testing.T) {
+	testConfig := Config{
+		Disks: []Disk{
+			{Partitions: []Partition{
+				{ID: "MyBoot", Artifacts: []Artifact{{Name: "boot", Type: "raw"}}},
+				{ID: "MyRootfs", Artifacts: []Artifact{{Name: "rootfs", Type: "raw"}}},
+			}},
+			{Partitions: []Partition{
+				{ID: "MyData", Artifacts: []Artifact{{Name: "data", Type: "raw"}}},
+			}},
+		},
+	}
+	partitionSettings := []PartitionSetting{
+		{ID: "MyRootfs", MountPoint: "/"},
+		{ID: "MyData", MountPoint: "/data"},
+	}
+
+	err := testConfig.FilterPartitionArtifacts(partitionSettings, []string{"/", "/data"})
+	assert.NoError(t, err)
+	assert.Empty(t, testConfig.Disks[0].Partitions[0].Artifacts)
+	assert.Len(t, testConfig.Disks[0].Partitions[1].Artifacts, 1)
+	assert.Len(t, testConfig.Disks[1].Partitions[0].Artifacts, 1)
+}
+
+func TestShouldFailUnmatchedPartitionFilterAcrossDisks(t *testing.T) {
+	testConfig := Config{
+		Disks: []Disk{
+			{Partitions: []Partition{{ID: "MyRootfs", Artifacts: []Artifact{{Name: "rootfs", Type: "raw"}}}}},
+			{Partitions: []Partition{{ID: "MyData"}}},
+		},
+	}
+
+	err := testConfig.FilterPartitionArtifacts(nil, []string{"MyData", "/home"})
+	assert.Error(t, err)
+	assert.Equal(t, "partition filter '/home' does not match any partition, available partitions are: disk 0: 0 (ID: 'MyRootfs', Name: '', MountPoint: ''); disk 1: 0 (ID: 'MyData', Name: '', MountPoint: '')", err.Error())
+	assert.Len(t, testConfig.Disks[0].Partitions[0].Artifacts, 1)
+}
@@ -8,6 +8,27 @@ package configuration
 import (
 	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
+
+	"microsoft.com/pkggen/internal/logger"
+)
+
+const (
+	// maxMbrPartitions is the number of primary partitions an MBR partition table can hold
+	maxMbrPartitions = 4
+	// diskSectorSize is the logical sector size partition alignments must be a multiple of
+	diskSectorSize = 512
+	// mibSize is the number of bytes in a MiB, the unit of partition offsets
+	mibSize = 1024 * 1024
+	// maxAlignmentWastePercent is the share of the disk which may be lost to alignment padding before warning
+	maxAlignmentWastePercent = 1
+	// gptHeaderSectors holds the protective MBR, the GPT header and the partition entries at the start of a GPT disk
+	gptHeaderSectors = 34
+	// mbrHeaderSectors holds the MBR at the start of an MBR disk
+	mbrHeaderSectors = 1
+	// gptBackupReserveMiB is left free at the end of a GPT disk for the backup partition entries and GPT header
+	gptBackupReserveMiB = 1
 )
 
 // Disk holds the disk partitioning, formatting and size information.
@@ -15,6 +36,7 @@ import (
 type Disk struct {
 	PartitionTableType PartitionTableType `json:"PartitionTableType"`
 	MaxSize            uint64             `json:"MaxSize"`
+	PartitionAlignment uint64             `json:"PartitionAlignment"`
 	TargetDisk         TargetDisk         `json:"TargetDisk"`
 	Artifacts          []Artifact         `json:"Artifacts"`
 	Partitions         []Partition        `json:"Partitions"`
@@ -42,6 +64,26 @@ func (d *Disk) IsValid() (err error) {
 			return
 		}
 	}
+
+	if err = d.relativePartitionSizesAreValid(); err != nil {
+		return
+	}
+
+	if err = d.partitionAlignmentIsValid(); err != nil {
+		return
+	}
+
+	if err = d.partitionLayoutIsValid(); err != nil {
+		return
+	}
+
+	if err = d.partitionTableTypeIsCompatible(); err != nil {
+		return
+	}
+
+	if err = d.artifactSizesAreValid(); err != nil {
+		return
+	}
 	// for _, rawBinary := range disk.RawBinaries {
 	// 	if err = rawBinary.IsValid(); err != nil {
 	// 		return
@@ -50,6 +92,375 @@ func (d *Disk) IsValid() (err error) {
 	return
 }
 
+// artifactSizesAreValid checks the disk artifacts' [MaxVirtualSize] against the disk's [MaxSize], the
+// virtual size of every disk artifact, so an image which can never fit fails before it is built.
+func (d *Disk) artifactSizesAreValid() (err error) {
+	if d.MaxSize == 0 {
+		return
+	}
+
+	for _, artifact := range d.Artifacts {
+		if artifact.MaxVirtualSize != 0 && d.MaxSize > artifact.MaxVirtualSize {
+			return fmt.Errorf("[MaxSize] of %d MiB exceeds the [MaxVirtualSize] of %d MiB of artifact (%s)", d.MaxSize, artifact.MaxVirtualSize, artifact.Name)
+		}
+	}
+
+	return
+}
+
+// HasRelativePartitionSizes returns true if any of the disk's partitions is sized relative to the disk.
+func (d *Disk) HasRelativePartitionSizes() bool {
+	for _, partition := range d.Partitions {
+		if partition.HasRelativeSize() {
+			return true
+		}
+	}
+	return false
+}
+
+// relativePartitionSizesAreValid checks that the partitions sized relative to the disk fit
+// on the disk along with all the fixed size partitions.
+func (d *Disk) relativePartitionSizesAreValid() (err error) {
+	if !d.HasRelativePartitionSizes() {
+		return
+	}
+
+	if d.MaxSize == 0 {
+		return fmt.Errorf("[MaxSize] must be set when a [Partition] uses a relative [Size]")
+	}
+
+	growPartitions := 0
+	for i, partition := range d.Partitions {
+		if partition.IsGrow() {
+			growPartitions++
+		}
+		if !partition.HasRelativeSize() && partition.End == 0 && i != len(d.Partitions)-1 {
+			return fmt.Errorf("[Partition] '%s' must set [End] or [Size] when other partitions use a relative [Size]", partition.ID)
+		}
+		if !partition.HasRelativeSize() && partition.End == 0 && growPartitions > 0 {
+			return fmt.Errorf("[Partition] '%s' with an [End] of 0 conflicts with the '%s' partition", partition.ID, PartitionSizeGrow)
+		}
+	}
+	if growPartitions > 1 {
+		return fmt.Errorf("only one [Partition] may use a [Size] of '%s', found %d", PartitionSizeGrow, growPartitions)
+	}
+
+	_, _, err = d.partitionSizes()
+	return
+}
+
+// backupTableReserve returns the space, in MiB, left free at the end of the disk for its backup partition table
+func (d *Disk) backupTableReserve() uint64 {
+	if d.PartitionTableType == PartitionTableTypeGpt {
+		return gptBackupReserveMiB
+	}
+	return 0
+}
+
+// partitionSizes returns the size of each partition in MiB. The fixed size partitions are placed first, and
+// a relative size is a share of the free space they leave on the disk once the backup partition table is
+// reserved. The size of a partition which grows to fill the disk, or which has an "End" of 0, is not known
+// ahead of time and is returned as 0. freeSize is the space the fixed size partitions leave.
+func (d *Disk) partitionSizes() (sizes []uint64, freeSize uint64, err error) {
+	sizes = make([]uint64, len(d.Partitions))
+
+	fixedSize := d.Partitions[0].Start + d.backupTableReserve()
+	for i, partition := range d.Partitions {
+		switch {
+		case partition.HasRelativeSize() || partition.End == 0:
+			sizes[i] = 0
+		case partition.End < partition.Start:
+			err = fmt.Errorf("[Partition] '%s' has an [End] (%d) before its [Start] (%d)", partition.ID, partition.End, partition.Start)
+			return
+		default:
+			sizes[i] = partition.End - partition.Start
+		}
+		fixedSize += sizes[i]
+	}
+	if fixedSize > d.MaxSize {
+		err = fmt.Errorf("[Partitions] require %d MiB, which exceeds the disk's [MaxSize] of %d MiB", fixedSize, d.MaxSize)
+		return
+	}
+	freeSize = d.MaxSize - fixedSize
+
+	var totalPercent uint64
+	for i, partition := range d.Partitions {
+		if !partition.HasRelativeSize() || partition.IsGrow() {
+			continue
+		}
+
+		var percent uint64
+		percent, err = partition.SizePercent()
+		if err != nil {
+			return
+		}
+		totalPercent += percent
+		sizes[i] = freeSize * percent / 100
+	}
+	if totalPercent > 100 {
+		err = fmt.Errorf("relative [Size] of the [Partitions] adds up to %d%% of the %d MiB the fixed size partitions leave free, which may not exceed 100%%", totalPercent, freeSize)
+		return
+	}
+
+	return
+}
+
+// ResolvePartitionSizes converts any partition sized relative to the disk into absolute
+// "Start" and "End" offsets. Partitions using a relative size are placed directly after the
+// previous partition, or at their own "Start" offset if they are the first partition on the disk.
+// The resolved layout is checked again, since relative partitions may run into a fixed one.
+func (d *Disk) ResolvePartitionSizes() (err error) {
+	if !d.HasRelativePartitionSizes() {
+		return
+	}
+
+	err = d.relativePartitionSizesAreValid()
+	if err != nil {
+		return
+	}
+
+	sizes, growSize, err := d.partitionSizes()
+	if err != nil {
+		return
+	}
+
+	for i, partition := range d.Partitions {
+		if partition.HasRelativeSize() {
+			growSize -= sizes[i]
+		}
+	}
+
+	offset := d.Partitions[0].Start
+	for i := range d.Partitions {
+		partition := &d.Partitions[i]
+		isLast := i == len(d.Partitions)-1
+
+		if !partition.HasRelativeSize() {
+			offset = partition.End
+			continue
+		}
+
+		partition.Start = offset
+		switch {
+		case partition.IsGrow() && isLast:
+			// An "End" of 0 fills the rest of the disk
+			partition.End = 0
+		case partition.IsGrow():
+			partition.End = partition.Start + growSize
+		default:
+			partition.End = partition.Start + sizes[i]
+		}
+		partition.Size = ""
+		offset = partition.End
+	}
+
+	return d.IsValid()
+}
+
+// partitionTableTypeIsCompatible checks the partitions can be created on the disk's partition table.
+// MBR tables only hold four primary partitions and can't mark GPT specific partition types.
+func (d *Disk) partitionTableTypeIsCompatible() (err error) {
+	if d.PartitionTableType != PartitionTableTypeMbr {
+		return
+	}
+
+	if len(d.Partitions) > maxMbrPartitions {
+		return fmt.Errorf("[PartitionTableType] '%s' supports at most %d partitions, found %d; use '%s' instead", PartitionTableTypeMbr, maxMbrPartitions, len(d.Partitions), PartitionTableTypeGpt)
+	}
+
+	for _, partition := range d.Partitions {
+		for _, flag := range partition.Flags {
+			switch flag {
+			case PartitionFlagESP, PartitionFlagGrub, PartitionFlagBiosGrub, PartitionFlagBiosGrubLegacy:
+				return fmt.Errorf("[Partition] '%s' uses the '%s' flag, which requires a '%s' [PartitionTableType]", partition.ID, flag, PartitionTableTypeGpt)
+			}
+		}
+	}
+
+	return
+}
+
+// AlignedPartitionStart returns the offset, in bytes, of a partition configured to start at
+// startMiB once the disk's partition alignment is applied.
+func (d *Disk) AlignedPartitionStart(startMiB uint64) (startBytes uint64) {
+	startBytes = startMiB * mibSize
+	if d.PartitionAlignment == 0 {
+		return
+	}
+
+	if remainder := startBytes % d.PartitionAlignment; remainder != 0 {
+		startBytes += d.PartitionAlignment - remainder
+	}
+	return
+}
+
+// FilterPartitionArtifacts drops the artifacts of every partition that is not matched by one of
+// filters, so only the selected partitions are extracted. A filter matches a partition by its index
+// on the disk, its ID, its Name (label) or the MountPoint its PartitionSetting gives it. An empty
+// filter list keeps every partition artifact.
+func (d *Disk) FilterPartitionArtifacts(partitionSettings []PartitionSetting, filters []string) (err error) {
+	return filterPartitionArtifacts([]*Disk{d}, partitionSettings, filters)
+}
+
+// filterPartitionArtifacts checks every filter matches a partition of one of the disks before
+// dropping the artifacts of the partitions no filter matches, so a filter may name a partition of any disk.
+func filterPartitionArtifacts(disks []*Disk, partitionSettings []PartitionSetting, filters []string) (err error) {
+	if len(filters) == 0 {
+		return
+	}
+
+	mountPoints := make(map[string]string)
+	for _, setting := range partitionSettings {
+		mountPoints[setting.ID] = setting.MountPoint
+	}
+
+	selected := make([][]bool, len(disks))
+	for i, disk := range disks {
+		selected[i] = make([]bool, len(disk.Partitions))
+	}
+
+	for _, filter := range filters {
+		matched := false
+		for i, disk := range disks {
+			for j, partition := range disk.Partitions {
+				if partitionMatchesFilter(j, partition, mountPoints[partition.ID], filter) {
+					selected[i][j] = true
+					matched = true
+				}
+			}
+		}
+
+		if !matched {
+			return fmt.Errorf("partition filter '%s' does not match any partition, available partitions are: %s", filter, describeDisksPartitions(disks, mountPoints))
+		}
+	}
+
+	for i, disk := range disks {
+		for j := range disk.Partitions {
+			if !selected[i][j] {
+				disk.Partitions[j].Artifacts = nil
+			} else if len(disk.Partitions[j].Artifacts) == 0 {
+				logger.Log.Warnf("Selected partition '%s' has no [Artifacts], nothing will be extracted for it", disk.Partitions[j].ID)
+			}
+		}
+	}
+
+	return
+}
+
+// partitionMatchesFilter returns true if filter names the partition at index by index, ID, Name or mount point.
+func partitionMatchesFilter(index int, partition Partition, mountPoint, filter string) bool {
+	if filterIndex, err := strconv.Atoi(filter); err == nil && filterIndex == index {
+		return true
+	}
+
+	return filter == partition.ID || (partition.Name != "" && filter == partition.Name) || (mountPoint != "" && filter == mountPoint)
+}
+
+// describePartitions lists the disk's partitions for error messages.
+func (d *Disk) describePartitions(mountPoints map[string]string) string {
+	descriptions := make([]string, 0, len(d.Partitions))
+	for i, partition := range d.Partitions {
+		descriptions = append(descriptions, fmt.Sprintf("%d (ID: '%s', Name: '%s', MountPoint: '%s')", i, partition.ID, partition.Name, mountPoints[partition.ID]))
+	}
+	return strings.Join(descriptions, ", ")
+}
+
+// describeDisksPartitions lists the partitions of every disk for error messages, naming the disk once there are several.
+func describeDisksPartitions(disks []*Disk, mountPoints map[string]string) string {
+	if len(disks) == 1 {
+		return disks[0].describePartitions(mountPoints)
+	}
+
+	descriptions := make([]string, 0, len(disks))
+	for i, disk := range disks {
+		descriptions = append(descriptions, fmt.Sprintf("disk %d: %s", i, disk.describePartitions(mountPoints)))
+	}
+	return strings.Join(descriptions, "; ")
+}
+
+// partitionAlignmentIsValid checks that the partition alignment is a power of two multiple of the
+// sector size, and that aligning the partitions does not push any of them past their end.
+func (d *Disk) partitionAlignmentIsValid() (err error) {
+	alignment := d.PartitionAlignment
+	if alignment == 0 {
+		return
+	}
+
+	if alignment%diskSectorSize != 0 || alignment&(alignment-1) != 0 {
+		return fmt.Errorf("invalid [PartitionAlignment] (%d), must be a power of two multiple of the %d byte sector size", alignment, diskSectorSize)
+	}
+
+	var (
+		wastedBytes uint64
+		diskBytes   = d.MaxSize * mibSize
+	)
+	for _, partition := range d.Partitions {
+		alignedStart := d.AlignedPartitionStart(partition.Start)
+		if partition.End != 0 && alignedStart >= partition.End*mibSize {
+			return fmt.Errorf("[Partition] '%s' is empty once its [Start] is aligned to %d bytes", partition.ID, alignment)
+		}
+		wastedBytes += alignedStart - partition.Start*mibSize
+
+		if partition.End*mibSize > diskBytes {
+			diskBytes = partition.End * mibSize
+		}
+	}
+
+	if diskBytes != 0 && wastedBytes*100 > diskBytes*maxAlignmentWastePercent {
+		logger.Log.Warnf("[PartitionAlignment] of %d bytes leaves %d bytes of the disk unused between partitions", alignment, wastedBytes)
+	}
+
+	return
+}
+
+// partitionLayoutIsValid checks that the partitions fit on a disk of [MaxSize] once aligned, without
+// overlapping each other or the partition tables at the start and, for GPT, the end of the disk, so an
+// oversized layout fails before the disk is created instead of in parted. Relative partition sizes are
+// checked by relativePartitionSizesAreValid.
+func (d *Disk) partitionLayoutIsValid() (err error) {
+	if d.MaxSize == 0 || d.HasRelativePartitionSizes() {
+		return
+	}
+
+	headerBytes := uint64(gptHeaderSectors * diskSectorSize)
+	if d.PartitionTableType == PartitionTableTypeMbr {
+		headerBytes = mbrHeaderSectors * diskSectorSize
+	}
+	diskBytes := d.MaxSize * mibSize
+
+	var previousEnd uint64
+	for i, partition := range d.Partitions {
+		start := d.AlignedPartitionStart(partition.Start)
+		if start < headerBytes {
+			return fmt.Errorf("[Partition] '%s' starts at byte %d, inside the %d bytes of partition table at the start of the disk", partition.ID, start, headerBytes)
+		}
+		if i > 0 && start < previousEnd {
+			return fmt.Errorf("[Partition] '%s' starts at byte %d, before the end of [Partition] '%s' at byte %d", partition.ID, start, d.Partitions[i-1].ID, previousEnd)
+		}
+
+		if start >= diskBytes {
+			return fmt.Errorf("[Partition] '%s' starts at %d MiB, past the end of the disk's [MaxSize] of %d MiB", partition.ID, start/mibSize, d.MaxSize)
+		}
+
+		// An "End" of 0 fills the space up to the next partition, or the rest of the disk, so it can't overflow
+		end := partition.End * mibSize
+		if partition.End == 0 {
+			previousEnd = start
+			continue
+		}
+		if end > diskBytes {
+			return fmt.Errorf("[Partitions] require %d MiB, which exceeds the disk's [MaxSize] of %d MiB by %d MiB: [Partition] '%s' ends at %d MiB", partition.End, d.MaxSize, partition.End-d.MaxSize, partition.ID, partition.End)
+		}
+		if partition.End > d.MaxSize-d.backupTableReserve() {
+			return fmt.Errorf("[Partition] '%s' ends at %d MiB, inside the last %d MiB of the disk's [MaxSize] of %d MiB, which are reserved for the backup partition table", partition.ID, partition.End, d.backupTableReserve(), d.MaxSize)
+		}
+		previousEnd = end
+	}
+
+	return
+}
+
 // UnmarshalJSON Unmarshals a Disk entry
 func (d *Disk) UnmarshalJSON(b []byte) (err error) {
 	// Use an intermediate type which will use the default JSON unmarshal implementation
@@ -56,7 +56,7 @@ var (
 			{
 				ID:     "MyRootfs",
 				Start:  uint64(9),
-				End:    uint64(1024),
+				End:    uint64(1023),
 				FsType: "ext4",
 			},
 		},
@@ -101,3 +101,330 @@ func TestShouldFailParsingInvalidDisk_Disk(t *testing.T) {
 	assert.Error(t, err)
 	assert.Equal(t, "failed to parse [Disk]: failed to parse [PartitionTableType]: invalid value for PartitionTableType (not_a_partition_type)", err.Error())
 }
+
+func TestShouldSucceedResolvingRelativePartitionSizes_Disk(t *testing.T) {
+	sizedDisk := validDisk
+	sizedDisk.Partitions = []Partition{
+		{ID: "MyBoot", Start: 1, End: 9, FsType: "fat32"},
+		{ID: "MyRootfs", Size: "50%", FsType: "ext4"},
+		{ID: "MyVar", Size: PartitionSizeGrow, FsType: "ext4"},
+		{ID: "MyHome", Size: "10%", FsType: "ext4"},
+	}
+
+	assert.NoError(t, sizedDisk.IsValid())
+	assert.NoError(t, sizedDisk.ResolvePartitionSizes())
+
+	// 1014 MiB are left once MyBoot, the 1 MiB before it and the backup GPT header are placed
+	assert.Equal(t, uint64(9), sizedDisk.Partitions[1].Start)
+	assert.Equal(t, uint64(516), sizedDisk.Partitions[1].End)
+	assert.Equal(t, uint64(516), sizedDisk.Partitions[2].Start)
+	assert.Equal(t, uint64(922), sizedDisk.Partitions[2].End)
+	assert.Equal(t, uint64(922), sizedDisk.Partitions[3].Start)
+	assert.Equal(t, uint64(1023), sizedDisk.Partitions[3].End)
+	assert.False(t, sizedDisk.HasRelativePartitionSizes())
+}
+
+func TestShouldSucceedResolvingTrailingGrowPartition_Disk(t *testing.T) {
+	sizedDisk := validDisk
+	sizedDisk.Partitions = []Partition{
+		{ID: "MyBoot", Start: 1, End: 9, FsType: "fat32"},
+		{ID: "MyRootfs", Size: PartitionSizeGrow, FsType: "ext4"},
+	}
+
+	assert.NoError(t, sizedDisk.ResolvePartitionSizes())
+	assert.Equal(t, uint64(9), sizedDisk.Partitions[1].Start)
+	assert.Equal(t, uint64(0), sizedDisk.Partitions[1].End)
+}
+
+func TestShouldFailMultipleGrowPartitions_Disk(t *testing.T) {
+	invalidDisk := validDisk
+	invalidDisk.Partitions = []Partition{
+		{ID: "MyBoot", Start: 1, Size: PartitionSizeGrow, FsType: "fat32"},
+		{ID: "MyRootfs", Size: PartitionSizeGrow, FsType: "ext4"},
+	}
+
+	err := invalidDisk.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "only one [Partition] may use a [Size] of 'grow', found 2", err.Error())
+}
+
+func TestShouldFailOversizedRelativePartitions_Disk(t *testing.T) {
+	invalidDisk := validDisk
+	invalidDisk.Partitions = []Partition{
+		{ID: "MyBoot", Start: 1, End: 100, FsType: "fat32"},
+		{ID: "MyRootfs", Size: "95%", FsType: "ext4"},
+		{ID: "MyHome", Size: "10%", FsType: "ext4"},
+	}
+
+	err := invalidDisk.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "relative [Size] of the [Partitions] adds up to 105% of the 923 MiB the fixed size partitions leave free, which may not exceed 100%", err.Error())
+}
+
+func TestShouldFailOversizedFixedPartitionsWithRelativeSize_Disk(t *testing.T) {
+	invalidDisk := validDisk
+	invalidDisk.Partitions = []Partition{
+		{ID: "MyBoot", Start: 1, End: 1024, FsType: "fat32"},
+		{ID: "MyRootfs", Size: "10%", FsType: "ext4"},
+	}
+
+	err := invalidDisk.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "[Partitions] require 1025 MiB, which exceeds the disk's [MaxSize] of 1024 MiB", err.Error())
+}
+
+func TestShouldReserveBackupGptHeaderForRelativePartitions_Disk(t *testing.T) {
+	sizedDisk := validDisk
+	sizedDisk.Partitions = []Partition{
+		{ID: "MyBoot", Start: 1, End: 9, FsType: "fat32"},
+		{ID: "MyRootfs", Size: "60%", FsType: "ext4"},
+		{ID: "MyHome", Size: "40%", FsType: "ext4"},
+	}
+
+	// The shares of the 1014 MiB left free are rounded down, and stop short of the backup GPT header
+	assert.NoError(t, sizedDisk.ResolvePartitionSizes())
+	assert.Equal(t, uint64(617), sizedDisk.Partitions[1].End)
+	assert.Equal(t, uint64(1022), sizedDisk.Partitions[2].End)
+
+	mbrDisk := validDisk
+	mbrDisk.PartitionTableType = PartitionTableTypeMbr
+	mbrDisk.Partitions = []Partition{
+		{ID: "MyBoot", Start: 1, End: 9, FsType: "fat32"},
+		{ID: "MyRootfs", Size: "100%", FsType: "ext4"},
+	}
+
+	assert.NoError(t, mbrDisk.ResolvePartitionSizes())
+	assert.Equal(t, uint64(1024), mbrDisk.Partitions[1].End)
+}
+
+func TestShouldFailRelativePartitionOverlappingFixedPartition_Disk(t *testing.T) {
+	invalidDisk := validDisk
+	invalidDisk.Partitions = []Partition{
+		{ID: "MyBoot", Start: 1, End: 9, FsType: "fat32"},
+		{ID: "MyRootfs", Size: "50%", FsType: "ext4"},
+		{ID: "MyData", Start: 100, End: 200, FsType: "ext4"},
+	}
+
+	// The relative size can only be placed once the sizes are resolved
+	assert.NoError(t, invalidDisk.IsValid())
+
+	err := invalidDisk.ResolvePartitionSizes()
+	assert.Error(t, err)
+	assert.Equal(t, "[Partition] 'MyData' starts at byte 104857600, before the end of [Partition] 'MyRootfs' at byte 488636416", err.Error())
+}
+
+func TestShouldFailRelativePartitionsWithoutMaxSize_Disk(t *testing.T) {
+	invalidDisk := validDisk
+	invalidDisk.MaxSize = 0
+	invalidDisk.Partitions = []Partition{
+		{ID: "MyRootfs", Start: 1, Size: "50%", FsType: "ext4"},
+	}
+
+	err := invalidDisk.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "[MaxSize] must be set when a [Partition] uses a relative [Size]", err.Error())
+}
+
+func TestShouldSucceedAligningPartitionStart_Disk(t *testing.T) {
+	alignedDisk := validDisk
+	alignedDisk.PartitionAlignment = 4 * 1024 * 1024
+
+	assert.NoError(t, alignedDisk.IsValid())
+	assert.Equal(t, uint64(4*1024*1024), alignedDisk.AlignedPartitionStart(3))
+	assert.Equal(t, uint64(12*1024*1024), alignedDisk.AlignedPartitionStart(9))
+	assert.Equal(t, uint64(8*1024*1024), alignedDisk.AlignedPartitionStart(8))
+}
+
+func TestShouldFailNonPowerOfTwoPartitionAlignment_Disk(t *testing.T) {
+	invalidDisk := validDisk
+	invalidDisk.PartitionAlignment = 3 * 512
+
+	err := invalidDisk.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [PartitionAlignment] (1536), must be a power of two multiple of the 512 byte sector size", err.Error())
+}
+
+func TestShouldFailSubSectorPartitionAlignment_Disk(t *testing.T) {
+	invalidDisk := validDisk
+	invalidDisk.PartitionAlignment = 256
+
+	err := invalidDisk.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [PartitionAlignment] (256), must be a power of two multiple of the 512 byte sector size", err.Error())
+}
+
+func TestShouldFailPartitionEmptiedByAlignment_Disk(t *testing.T) {
+	invalidDisk := validDisk
+	invalidDisk.PartitionAlignment = 16 * 1024 * 1024
+
+	err := invalidDisk.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "[Partition] 'MyBoot' is empty once its [Start] is aligned to 16777216 bytes", err.Error())
+}
+
+func TestShouldSucceedParsingLegacyMbrDisk_Disk(t *testing.T) {
+	mbrDisk := validDisk
+	mbrDisk.PartitionTableType = PartitionTableTypeMbr
+	mbrDisk.Partitions = []Partition{
+		{ID: "MyBoot", Flags: []PartitionFlag{"boot"}, Start: 1, End: 9, FsType: "ext4"},
+		{ID: "MyRootfs", Start: 9, End: 1024, FsType: "ext4"},
+	}
+
+	assert.NoError(t, mbrDisk.IsValid())
+}
+
+func TestShouldFailTooManyMbrPartitions_Disk(t *testing.T) {
+	invalidDisk := validDisk
+	invalidDisk.PartitionTableType = PartitionTableTypeMbr
+	invalidDisk.Partitions = []Partition{
+		{ID: "Part1", Start: 1, End: 2, FsType: "ext4"},
+		{ID: "Part2", Start: 2, End: 3, FsType: "ext4"},
+		{ID: "Part3", Start: 3, End: 4, FsType: "ext4"},
+		{ID: "Part4", Start: 4, End: 5, FsType: "ext4"},
+		{ID: "Part5", Start: 5, End: 6, FsType: "ext4"},
+	}
+
+	err := invalidDisk.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "[PartitionTableType] 'mbr' supports at most 4 partitions, found 5; use 'gpt' instead", err.Error())
+}
+
+func TestShouldFailMbrEspPartition_Disk(t *testing.T) {
+	invalidDisk := validDisk
+	invalidDisk.PartitionTableType = PartitionTableTypeMbr
+
+	err := invalidDisk.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "[Partition] 'MyBoot' uses the 'esp' flag, which requires a 'gpt' [PartitionTableType]", err.Error())
+}
+
+func TestShouldKeepSelectedPartitionArtifacts_Disk(t *testing.T) {
+	filteredDisk := validDisk
+	filteredDisk.Partitions = []Partition{
+		{ID: "MyBoot", Artifacts: []Artifact{{Name: "boot", Type: "raw"}}},
+		{ID: "MyRootfs", Name: "rootfs", Artifacts: []Artifact{{Name: "rootfs", Type: "raw"}}},
+		{ID: "MyData", Artifacts: []Artifact{{Name: "data", Type: "raw"}}},
+	}
+	partitionSettings := []PartitionSetting{
+		{ID: "MyBoot", MountPoint: "/boot/efi"},
+		{ID: "MyRootfs", MountPoint: "/"},
+		{ID: "MyData", MountPoint: "/data"},
+	}
+
+	err := filteredDisk.FilterPartitionArtifacts(partitionSettings, []string{"0", "/data"})
+	assert.NoError(t, err)
+	assert.Len(t, filteredDisk.Partitions[0].Artifacts, 1)
+	assert.Empty(t, filteredDisk.Partitions[1].Artifacts)
+	assert.Len(t, filteredDisk.Partitions[2].Artifacts, 1)
+}
+
+func TestShouldMatchPartitionFilterByName_Disk(t *testing.T) {
+	filteredDisk := validDisk
+	filteredDisk.Partitions = []Partition{
+		{ID: "MyBoot", Artifacts: []Artifact{{Name: "boot", Type: "raw"}}},
+		{ID: "MyRootfs", Name: "rootfs", Artifacts: []Artifact{{Name: "rootfs", Type: "raw"}}},
+	}
+
+	err := filteredDisk.FilterPartitionArtifacts(nil, []string{"rootfs"})
+	assert.NoError(t, err)
+	assert.Empty(t, filteredDisk.Partitions[0].Artifacts)
+	assert.Len(t, filteredDisk.Partitions[1].Artifacts, 1)
+}
+
+func TestShouldFailUnmatchedPartitionFilter_Disk(t *testing.T) {
+	filteredDisk := validDisk
+	filteredDisk.Partitions = []Partition{
+		{ID: "MyBoot"},
+		{ID: "MyRootfs", Name: "rootfs"},
+	}
+	partitionSettings := []PartitionSetting{
+		{ID: "MyRootfs", MountPoint: "/"},
+	}
+
+	err := filteredDisk.FilterPartitionArtifacts(partitionSettings, []string{"/home"})
+	assert.Error(t, err)
+	assert.Equal(t, "partition filter '/home' does not match any partition, available partitions are: 0 (ID: 'MyBoot', Name: '', MountPoint: ''), 1 (ID: 'MyRootfs', Name: 'rootfs', MountPoint: '/')", err.Error())
+}
+
+func TestShouldFailPartitionPastMaxSize_Disk(t *testing.T) {
+	invalidDisk := validDisk
+	invalidDisk.Partitions = []Partition{
+		{ID: "MyBoot", Start: 1, End: 9, FsType: "fat32"},
+		{ID: "MyRootfs", Start: 9, End: 1100, FsType: "ext4"},
+	}
+
+	err := invalidDisk.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "[Partitions] require 1100 MiB, which exceeds the disk's [MaxSize] of 1024 MiB by 76 MiB: [Partition] 'MyRootfs' ends at 1100 MiB", err.Error())
+}
+
+func TestShouldFailPartitionInBackupGptHeader_Disk(t *testing.T) {
+	invalidDisk := validDisk
+	invalidDisk.Partitions = []Partition{
+		{ID: "MyBoot", Start: 1, End: 9, FsType: "fat32"},
+		{ID: "MyRootfs", Start: 9, End: 1024, FsType: "ext4"},
+	}
+
+	err := invalidDisk.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "[Partition] 'MyRootfs' ends at 1024 MiB, inside the last 1 MiB of the disk's [MaxSize] of 1024 MiB, which are reserved for the backup partition table", err.Error())
+
+	// An MBR partition table has no backup copy, so the last partition may end at the end of the disk
+	invalidDisk.PartitionTableType = PartitionTableTypeMbr
+	assert.NoError(t, invalidDisk.IsValid())
+}
+
+func TestShouldFailOverlappingPartitions_Disk(t *testing.T) {
+	invalidDisk := validDisk
+	invalidDisk.Partitions = []Partition{
+		{ID: "MyBoot", Start: 1, End: 9, FsType: "fat32"},
+		{ID: "MyRootfs", Start: 8, End: 1024, FsType: "ext4"},
+	}
+
+	err := invalidDisk.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "[Partition] 'MyRootfs' starts at byte 8388608, before the end of [Partition] 'MyBoot' at byte 9437184", err.Error())
+}
+
+func TestShouldFailPartitionInsidePartitionTable_Disk(t *testing.T) {
+	invalidDisk := validDisk
+	invalidDisk.Partitions = []Partition{
+		{ID: "MyBoot", Start: 0, End: 9, FsType: "fat32"},
+		{ID: "MyRootfs", Start: 9, End: 0, FsType: "ext4"},
+	}
+
+	err := invalidDisk.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "[Partition] 'MyBoot' starts at byte 0, inside the 17408 bytes of partition table at the start of the disk", err.Error())
+}
+
+func TestShouldFailArtifactVirtualSizeBelowMaxSize_Disk(t *testing.T) {
+	invalidDisk := validDisk
+	invalidDisk.Artifacts = []Artifact{
+		{Name: "SmallVHD", Type: "vhd", MaxVirtualSize: 512},
+	}
+
+	err := invalidDisk.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "[MaxSize] of 1024 MiB exceeds the [MaxVirtualSize] of 512 MiB of artifact (SmallVHD)", err.Error())
+
+	invalidDisk.Artifacts[0].MaxVirtualSize = 1024
+	assert.NoError(t, invalidDisk.IsValid())
+}
+
+func TestShouldCheckArtifactSize_Disk(t *testing.T) {
+	artifact := Artifact{Name: "image", Type: "vhdx", MaxSize: 1, MaxVirtualSize: 2}
+
+	assert.NoError(t, artifact.CheckSize(1024*1024, 2*1024*1024))
+
+	err := artifact.CheckSize(1024*1024+1, 0)
+	assert.Error(t, err)
+	assert.Equal(t, "artifact (image) is 1048577 bytes, which exceeds its [MaxSize] of 1 MiB (1048576 bytes)", err.Error())
+
+	err = artifact.CheckSize(0, 2*1024*1024+1)
+	assert.Error(t, err)
+	assert.Equal(t, "artifact (image) has a virtual size of 2097153 bytes, which exceeds its [MaxVirtualSize] of 2 MiB (2097152 bytes)", err.Error())
+
+	unlimitedArtifact := Artifact{Name: "unlimited"}
+	assert.NoError(t, unlimitedArtifact.CheckSize(1<<40, 1<<40))
+}
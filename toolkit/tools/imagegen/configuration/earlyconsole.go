@@ -0,0 +1,67 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Parser for the image builder's configuration schemas.
+
+package configuration
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// EarlyConsole sets up the console from the first initramfs stage, instead of only once the root file system
+// is mounted.
+//   - Keymap: Name of the console keymap, ie "us" or "de-latin1"
+//   - Font: Name of the console font, ie "eurlatgr" or "ter-v16n"
+type EarlyConsole struct {
+	Keymap string `json:"Keymap"`
+	Font   string `json:"Font"`
+}
+
+// IsEmpty returns true if no early console setting is set
+func (e *EarlyConsole) IsEmpty() bool {
+	return e.Keymap == "" && e.Font == ""
+}
+
+// GetKernelArgs returns the kernel arguments setting up the console in the initramfs
+func (e *EarlyConsole) GetKernelArgs() (args []string) {
+	if e.Keymap != "" {
+		args = append(args, fmt.Sprintf("rd.vconsole.keymap=%s", e.Keymap))
+	}
+	if e.Font != "" {
+		args = append(args, fmt.Sprintf("rd.vconsole.font=%s", e.Font))
+	}
+	return
+}
+
+// IsValid returns an error if the EarlyConsole is not valid
+func (e *EarlyConsole) IsValid() (err error) {
+	if e.Keymap != "" && !keymapRegex.MatchString(e.Keymap) {
+		return fmt.Errorf("invalid [Keymap] (%s), must be the name of a console keymap such as 'us'", e.Keymap)
+	}
+
+	// Console fonts are named like keymaps, after their kbd files
+	if e.Font != "" && !keymapRegex.MatchString(e.Font) {
+		return fmt.Errorf("invalid [Font] (%s), must be the name of a console font such as 'eurlatgr'", e.Font)
+	}
+
+	return
+}
+
+// UnmarshalJSON Unmarshals an EarlyConsole entry
+func (e *EarlyConsole) UnmarshalJSON(b []byte) (err error) {
+	// Use an intermediate type which will use the default JSON unmarshal implementation
+	type IntermediateTypeEarlyConsole EarlyConsole
+	err = json.Unmarshal(b, (*IntermediateTypeEarlyConsole)(e))
+	if err != nil {
+		return fmt.Errorf("failed to parse [EarlyConsole]: %w", err)
+	}
+
+	// Now validate the resulting unmarshaled object
+	err = e.IsValid()
+	if err != nil {
+		return fmt.Errorf("failed to parse [EarlyConsole]: %w", err)
+	}
+	return
+}
@@ -0,0 +1,43 @@
+// Copyright Microsoft Corporation.
+// Licensed under the MIT License.
+
+package configuration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//TestMain found in configuration_test.go.
+
+var (
+	validEarlyConsole EarlyConsole = EarlyConsole{
+		Keymap: "de-latin1",
+		Font:   "eurlatgr",
+	}
+	invalidEarlyConsoleJSON = `{"Font": "../fonts/evil"}`
+)
+
+func TestShouldSucceedParsingDefaultEarlyConsole_EarlyConsole(t *testing.T) {
+	var checkedEarlyConsole EarlyConsole
+	err := marshalJSONString("{}", &checkedEarlyConsole)
+	assert.NoError(t, err)
+	assert.True(t, checkedEarlyConsole.IsEmpty())
+	assert.Empty(t, checkedEarlyConsole.GetKernelArgs())
+}
+
+func TestShouldSucceedParsingValidEarlyConsole_EarlyConsole(t *testing.T) {
+	var checkedEarlyConsole EarlyConsole
+	err := remarshalJSON(validEarlyConsole, &checkedEarlyConsole)
+	assert.NoError(t, err)
+	assert.Equal(t, validEarlyConsole, checkedEarlyConsole)
+	assert.Equal(t, []string{"rd.vconsole.keymap=de-latin1", "rd.vconsole.font=eurlatgr"}, checkedEarlyConsole.GetKernelArgs())
+}
+
+func TestShouldFailParsingInvalidFont_EarlyConsole(t *testing.T) {
+	var checkedEarlyConsole EarlyConsole
+	err := marshalJSONString(invalidEarlyConsoleJSON, &checkedEarlyConsole)
+	assert.Error(t, err)
+	assert.Equal(t, "failed to parse [EarlyConsole]: invalid [Font] (../fonts/evil), must be the name of a console font such as 'eurlatgr'", err.Error())
+}
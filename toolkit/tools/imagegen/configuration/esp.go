@@ -0,0 +1,84 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Parser for the image builder's configuration schemas.
+
+package configuration
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	// espLabelRegex matches the FAT volume labels accepted for the ESP: up to 11 upper case letters, digits, '_' and '-'
+	espLabelRegex = regexp.MustCompile(`^[A-Z0-9_-]{1,11}$`)
+)
+
+// EspFile is a file copied from the build host into the EFI system partition.
+//   - Source: Path of the file on the build host
+//   - Path: Where the file is installed, relative to the root of the ESP, ie "EFI/contoso/loader.efi"
+type EspFile struct {
+	Source string `json:"Source"`
+	Path   string `json:"Path"`
+}
+
+// Esp customizes the EFI system partition of an "efi" image.
+//   - Label: The FAT volume label of the ESP
+//   - Files: Files merged into the ESP, next to the installed bootloaders
+type Esp struct {
+	Label string    `json:"Label"`
+	Files []EspFile `json:"Files"`
+}
+
+// IsEmpty returns true if the ESP is not customized
+func (e *Esp) IsEmpty() bool {
+	return e.Label == "" && len(e.Files) == 0
+}
+
+// IsValid returns an error if the Esp is not valid
+func (e *Esp) IsValid() (err error) {
+	if e.Label != "" && !espLabelRegex.MatchString(e.Label) {
+		return fmt.Errorf("invalid [Label] (%s), must be 1 to 11 upper case letters, digits, '_' or '-'", e.Label)
+	}
+
+	paths := make(map[string]bool)
+	for _, espFile := range e.Files {
+		if espFile.Source == "" {
+			return fmt.Errorf("invalid [Files]: file (%s) has no [Source]", espFile.Path)
+		}
+
+		path := espFile.Path
+		if path == "" || filepath.IsAbs(path) || filepath.Clean(path) != path || path == ".." || strings.HasPrefix(path, "../") {
+			return fmt.Errorf("invalid [Files]: path (%s) must be a clean path relative to the root of the ESP, ie 'EFI/contoso/loader.efi'", path)
+		}
+
+		// FAT file names are case insensitive
+		if paths[strings.ToLower(path)] {
+			return fmt.Errorf("invalid [Files]: (%s) is installed more than once", path)
+		}
+		paths[strings.ToLower(path)] = true
+	}
+
+	return
+}
+
+// UnmarshalJSON Unmarshals an Esp entry
+func (e *Esp) UnmarshalJSON(b []byte) (err error) {
+	// Use an intermediate type which will use the default JSON unmarshal implementation
+	type IntermediateTypeEsp Esp
+	err = json.Unmarshal(b, (*IntermediateTypeEsp)(e))
+	if err != nil {
+		return fmt.Errorf("failed to parse [Esp]: %w", err)
+	}
+
+	// Now validate the resulting unmarshaled object
+	err = e.IsValid()
+	if err != nil {
+		return fmt.Errorf("failed to parse [Esp]: %w", err)
+	}
+	return
+}
@@ -0,0 +1,66 @@
+// Copyright Microsoft Corporation.
+// Licensed under the MIT License.
+
+package configuration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//TestMain found in configuration_test.go.
+
+var (
+	validEsp Esp = Esp{
+		Label: "CONTOSO-ESP",
+		Files: []EspFile{
+			{Source: "loaders/contoso.efi", Path: "EFI/contoso/contoso.efi"},
+		},
+	}
+	invalidEspJSON = `{"Label": "efi system partition"}`
+)
+
+func TestShouldSucceedParsingDefaultEsp_Esp(t *testing.T) {
+	var checkedEsp Esp
+	err := marshalJSONString("{}", &checkedEsp)
+	assert.NoError(t, err)
+	assert.True(t, checkedEsp.IsEmpty())
+}
+
+func TestShouldSucceedParsingValidEsp_Esp(t *testing.T) {
+	var checkedEsp Esp
+	err := remarshalJSON(validEsp, &checkedEsp)
+	assert.NoError(t, err)
+	assert.Equal(t, validEsp, checkedEsp)
+}
+
+func TestShouldFailParsingInvalidLabel_Esp(t *testing.T) {
+	var checkedEsp Esp
+	err := marshalJSONString(invalidEspJSON, &checkedEsp)
+	assert.Error(t, err)
+	assert.Equal(t, "failed to parse [Esp]: invalid [Label] (efi system partition), must be 1 to 11 upper case letters, digits, '_' or '-'", err.Error())
+}
+
+func TestShouldFailParsingAbsolutePath_Esp(t *testing.T) {
+	invalidEsp := Esp{
+		Files: []EspFile{{Source: "loaders/contoso.efi", Path: "/boot/efi/EFI/contoso/contoso.efi"}},
+	}
+
+	err := invalidEsp.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [Files]: path (/boot/efi/EFI/contoso/contoso.efi) must be a clean path relative to the root of the ESP, ie 'EFI/contoso/loader.efi'", err.Error())
+}
+
+func TestShouldFailParsingCaseInsensitiveDuplicate_Esp(t *testing.T) {
+	invalidEsp := Esp{
+		Files: []EspFile{
+			{Source: "loaders/a.efi", Path: "EFI/contoso/loader.efi"},
+			{Source: "loaders/b.efi", Path: "EFI/Contoso/LOADER.EFI"},
+		},
+	}
+
+	err := invalidEsp.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [Files]: (EFI/Contoso/LOADER.EFI) is installed more than once", err.Error())
+}
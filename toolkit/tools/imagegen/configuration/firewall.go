@@ -0,0 +1,100 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Parser for the image builder's configuration schemas.
+
+package configuration
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+const (
+	maxPortNumber = 65535
+)
+
+var (
+	// firewallPortRegex matches a port or port range and its protocol, e.g. "22/tcp" or "60000-61000/udp"
+	firewallPortRegex = regexp.MustCompile(`^([0-9]+)(-([0-9]+))?/(tcp|udp|sctp)$`)
+	// firewallZoneRegex matches firewalld zone names
+	firewallZoneRegex = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+)
+
+// Firewall sets up a baseline firewall with firewalld or nftables, whichever is installed.
+//   - AllowedPorts: Incoming ports to open, such as "22/tcp", everything else is dropped
+//   - Zone: The firewalld zone made the default and opened the ports in
+//   - Ruleset: Path of an nftables ruleset to install instead of generating one
+type Firewall struct {
+	AllowedPorts []string `json:"AllowedPorts"`
+	Zone         string   `json:"Zone"`
+	Ruleset      string   `json:"Ruleset"`
+}
+
+// IsEmpty returns true if no firewall setting is set
+func (f *Firewall) IsEmpty() bool {
+	return len(f.AllowedPorts) == 0 && f.Zone == "" && f.Ruleset == ""
+}
+
+// IsValid returns an error if the Firewall is not valid
+func (f *Firewall) IsValid() (err error) {
+	if f.Ruleset != "" && (len(f.AllowedPorts) != 0 || f.Zone != "") {
+		return fmt.Errorf("[Ruleset] replaces the generated firewall and can't be combined with [AllowedPorts] or [Zone]")
+	}
+
+	if f.Zone != "" && !firewallZoneRegex.MatchString(f.Zone) {
+		return fmt.Errorf("invalid [Zone] (%s), must be a firewalld zone name such as 'public'", f.Zone)
+	}
+
+	for _, port := range f.AllowedPorts {
+		if err = firewallPortIsValid(port); err != nil {
+			return fmt.Errorf("invalid [AllowedPorts] entry (%s): %w", port, err)
+		}
+	}
+
+	return
+}
+
+// firewallPortIsValid checks a port or port range and its protocol.
+func firewallPortIsValid(port string) (err error) {
+	const (
+		firstPortGroup = 1
+		lastPortGroup  = 3
+	)
+
+	matches := firewallPortRegex.FindStringSubmatch(port)
+	if matches == nil {
+		return fmt.Errorf("must be a port or port range and a protocol such as '22/tcp' or '60000-61000/udp'")
+	}
+
+	first, _ := strconv.Atoi(matches[firstPortGroup])
+	last := first
+	if matches[lastPortGroup] != "" {
+		last, _ = strconv.Atoi(matches[lastPortGroup])
+	}
+
+	if first < 1 || last > maxPortNumber || first > last {
+		return fmt.Errorf("ports must be in the range 1-%d, with the range's first port before its last", maxPortNumber)
+	}
+
+	return
+}
+
+// UnmarshalJSON Unmarshals a Firewall entry
+func (f *Firewall) UnmarshalJSON(b []byte) (err error) {
+	// Use an intermediate type which will use the default JSON unmarshal implementation
+	type IntermediateTypeFirewall Firewall
+	err = json.Unmarshal(b, (*IntermediateTypeFirewall)(f))
+	if err != nil {
+		return fmt.Errorf("failed to parse [Firewall]: %w", err)
+	}
+
+	// Now validate the resulting unmarshaled object
+	err = f.IsValid()
+	if err != nil {
+		return fmt.Errorf("failed to parse [Firewall]: %w", err)
+	}
+	return
+}
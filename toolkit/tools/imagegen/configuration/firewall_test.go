@@ -0,0 +1,60 @@
+// Copyright Microsoft Corporation.
+// Licensed under the MIT License.
+
+package configuration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//TestMain found in configuration_test.go.
+
+var (
+	validFirewall Firewall = Firewall{
+		AllowedPorts: []string{"22/tcp", "60000-61000/udp"},
+		Zone:         "public",
+	}
+	invalidFirewallJSON = `{"AllowedPorts": ["ssh"]}`
+)
+
+func TestShouldSucceedParsingDefaultFirewall_Firewall(t *testing.T) {
+	var checkedFirewall Firewall
+	err := marshalJSONString("{}", &checkedFirewall)
+	assert.NoError(t, err)
+	assert.True(t, checkedFirewall.IsEmpty())
+}
+
+func TestShouldSucceedParsingValidFirewall_Firewall(t *testing.T) {
+	var checkedFirewall Firewall
+	err := remarshalJSON(validFirewall, &checkedFirewall)
+	assert.NoError(t, err)
+	assert.Equal(t, validFirewall, checkedFirewall)
+}
+
+func TestShouldFailParsingInvalidPort_Firewall(t *testing.T) {
+	var checkedFirewall Firewall
+	err := marshalJSONString(invalidFirewallJSON, &checkedFirewall)
+	assert.Error(t, err)
+	assert.Equal(t, "failed to parse [Firewall]: invalid [AllowedPorts] entry (ssh): must be a port or port range and a protocol such as '22/tcp' or '60000-61000/udp'", err.Error())
+}
+
+func TestShouldFailParsingOutOfRangePorts_Firewall(t *testing.T) {
+	for _, port := range []string{"0/tcp", "65536/udp", "2000-1000/tcp"} {
+		invalidFirewall := Firewall{AllowedPorts: []string{port}}
+
+		err := invalidFirewall.IsValid()
+		assert.Error(t, err)
+		assert.Equal(t, "invalid [AllowedPorts] entry ("+port+"): ports must be in the range 1-65535, with the range's first port before its last", err.Error())
+	}
+}
+
+func TestShouldFailParsingRulesetWithPorts_Firewall(t *testing.T) {
+	invalidFirewall := validFirewall
+	invalidFirewall.Ruleset = "firewall/nftables.conf"
+
+	err := invalidFirewall.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "[Ruleset] replaces the generated firewall and can't be combined with [AllowedPorts] or [Zone]", err.Error())
+}
@@ -0,0 +1,92 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Parser for the image builder's configuration schemas.
+
+package configuration
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// FirmwareFile is a firmware blob copied from the build host into the image.
+//   - Source: Path of the file on the build host
+//   - Path: Where the file is installed, relative to /lib/firmware, ie "iwlwifi-cc-a0-77.ucode"
+type FirmwareFile struct {
+	Source string `json:"Source"`
+	Path   string `json:"Path"`
+}
+
+// Firmware adds firmware blobs to the image and to its initramfs.
+//   - Packages: Packages providing firmware, installed along with the image's other packages
+//   - Files: Firmware files copied from the build host into /lib/firmware
+//   - Initramfs: Firmware, relative to /lib/firmware, which is included in the initramfs
+type Firmware struct {
+	Packages  []string       `json:"Packages"`
+	Files     []FirmwareFile `json:"Files"`
+	Initramfs []string       `json:"Initramfs"`
+}
+
+// IsEmpty returns true if no firmware is configured
+func (f *Firmware) IsEmpty() bool {
+	return len(f.Packages) == 0 && len(f.Files) == 0 && len(f.Initramfs) == 0
+}
+
+// IsValid returns an error if the Firmware is not valid
+func (f *Firmware) IsValid() (err error) {
+	for _, pkg := range f.Packages {
+		if strings.TrimSpace(pkg) == "" {
+			return fmt.Errorf("invalid [Packages]: package names may not be empty")
+		}
+	}
+
+	paths := make(map[string]bool)
+	for _, firmwareFile := range f.Files {
+		if firmwareFile.Source == "" {
+			return fmt.Errorf("invalid [Files]: firmware file (%s) has no [Source]", firmwareFile.Path)
+		}
+		if err = firmwarePathIsValid(firmwareFile.Path); err != nil {
+			return fmt.Errorf("invalid [Files]: %w", err)
+		}
+		if paths[firmwareFile.Path] {
+			return fmt.Errorf("invalid [Files]: firmware (%s) is installed more than once", firmwareFile.Path)
+		}
+		paths[firmwareFile.Path] = true
+	}
+
+	for _, path := range f.Initramfs {
+		if err = firmwarePathIsValid(path); err != nil {
+			return fmt.Errorf("invalid [Initramfs]: %w", err)
+		}
+	}
+
+	return
+}
+
+// firmwarePathIsValid checks a firmware path is a file under /lib/firmware
+func firmwarePathIsValid(path string) (err error) {
+	if path == "" || filepath.IsAbs(path) || filepath.Clean(path) != path || path == ".." || strings.HasPrefix(path, "../") {
+		return fmt.Errorf("firmware path (%s) must be a clean path relative to /lib/firmware, ie 'amd-ucode/microcode_amd.bin'", path)
+	}
+	return
+}
+
+// UnmarshalJSON Unmarshals a Firmware entry
+func (f *Firmware) UnmarshalJSON(b []byte) (err error) {
+	// Use an intermediate type which will use the default JSON unmarshal implementation
+	type IntermediateTypeFirmware Firmware
+	err = json.Unmarshal(b, (*IntermediateTypeFirmware)(f))
+	if err != nil {
+		return fmt.Errorf("failed to parse [Firmware]: %w", err)
+	}
+
+	// Now validate the resulting unmarshaled object
+	err = f.IsValid()
+	if err != nil {
+		return fmt.Errorf("failed to parse [Firmware]: %w", err)
+	}
+	return
+}
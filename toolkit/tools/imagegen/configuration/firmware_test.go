@@ -0,0 +1,68 @@
+// Copyright Microsoft Corporation.
+// Licensed under the MIT License.
+
+package configuration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//TestMain found in configuration_test.go.
+
+var (
+	validFirmware Firmware = Firmware{
+		Packages: []string{"linux-firmware"},
+		Files: []FirmwareFile{
+			{Source: "firmware/iwlwifi-cc-a0-77.ucode", Path: "iwlwifi-cc-a0-77.ucode"},
+		},
+		Initramfs: []string{"iwlwifi-cc-a0-77.ucode", "amd-ucode/microcode_amd.bin"},
+	}
+	invalidFirmwareJSON = `{"Initramfs": ["/lib/firmware/amd-ucode/microcode_amd.bin"]}`
+)
+
+func TestShouldSucceedParsingDefaultFirmware_Firmware(t *testing.T) {
+	var checkedFirmware Firmware
+	err := marshalJSONString("{}", &checkedFirmware)
+	assert.NoError(t, err)
+	assert.True(t, checkedFirmware.IsEmpty())
+}
+
+func TestShouldSucceedParsingValidFirmware_Firmware(t *testing.T) {
+	var checkedFirmware Firmware
+	err := remarshalJSON(validFirmware, &checkedFirmware)
+	assert.NoError(t, err)
+	assert.Equal(t, validFirmware, checkedFirmware)
+	assert.False(t, checkedFirmware.IsEmpty())
+}
+
+func TestShouldFailParsingAbsoluteInitramfsPath_Firmware(t *testing.T) {
+	var checkedFirmware Firmware
+	err := marshalJSONString(invalidFirmwareJSON, &checkedFirmware)
+	assert.Error(t, err)
+	assert.Equal(t, "failed to parse [Firmware]: invalid [Initramfs]: firmware path (/lib/firmware/amd-ucode/microcode_amd.bin) must be a clean path relative to /lib/firmware, ie 'amd-ucode/microcode_amd.bin'", err.Error())
+}
+
+func TestShouldFailParsingEscapingFilePath_Firmware(t *testing.T) {
+	invalidFirmware := Firmware{
+		Files: []FirmwareFile{{Source: "firmware/blob.bin", Path: "../modules/blob.bin"}},
+	}
+
+	err := invalidFirmware.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [Files]: firmware path (../modules/blob.bin) must be a clean path relative to /lib/firmware, ie 'amd-ucode/microcode_amd.bin'", err.Error())
+}
+
+func TestShouldFailParsingDuplicateFilePath_Firmware(t *testing.T) {
+	invalidFirmware := Firmware{
+		Files: []FirmwareFile{
+			{Source: "firmware/a/blob.bin", Path: "blob.bin"},
+			{Source: "firmware/b/blob.bin", Path: "blob.bin"},
+		},
+	}
+
+	err := invalidFirmware.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [Files]: firmware (blob.bin) is installed more than once", err.Error())
+}
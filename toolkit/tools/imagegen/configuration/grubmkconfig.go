@@ -0,0 +1,69 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Parser for the image builder's configuration schemas.
+
+package configuration
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// GrubCmdlineLinuxVar is the /etc/default/grub variable the kernel command line is written to
+	GrubCmdlineLinuxVar = "GRUB_CMDLINE_LINUX"
+)
+
+// grubDefaultsVarRegex matches the name of an /etc/default/grub variable
+var grubDefaultsVarRegex = regexp.MustCompile(`^GRUB_[A-Z0-9_]+$`)
+
+// GrubMkconfig generates grub.cfg with grub2-mkconfig from /etc/default/grub, instead of editing the
+// toolkit's grub.cfg template, so the configuration survives a later grub2-mkconfig run.
+//   - Enable: Write /etc/default/grub and regenerate grub.cfg with grub2-mkconfig
+//   - Defaults: Variables set in /etc/default/grub, ie "GRUB_TIMEOUT": "5"
+type GrubMkconfig struct {
+	Enable   bool              `json:"Enable"`
+	Defaults map[string]string `json:"Defaults"`
+}
+
+// IsValid returns an error if the GrubMkconfig is not valid
+func (g *GrubMkconfig) IsValid() (err error) {
+	if !g.Enable && len(g.Defaults) != 0 {
+		return fmt.Errorf("[Defaults] require [Enable] to be set")
+	}
+
+	for name, value := range g.Defaults {
+		if !grubDefaultsVarRegex.MatchString(name) {
+			return fmt.Errorf("invalid [Defaults] variable (%s), must be an upper case name starting with 'GRUB_'", name)
+		}
+		if name == GrubCmdlineLinuxVar {
+			return fmt.Errorf("invalid [Defaults] variable (%s), the kernel command line is set through [KernelCommandLine]", name)
+		}
+		// Values are written between double quotes
+		if strings.ContainsAny(value, "\"\\$`\n") {
+			return fmt.Errorf("invalid [Defaults] value (%s) for (%s), may not contain quotes, '\\', '$', '`' or line breaks", value, name)
+		}
+	}
+
+	return
+}
+
+// UnmarshalJSON Unmarshals a GrubMkconfig entry
+func (g *GrubMkconfig) UnmarshalJSON(b []byte) (err error) {
+	// Use an intermediate type which will use the default JSON unmarshal implementation
+	type IntermediateTypeGrubMkconfig GrubMkconfig
+	err = json.Unmarshal(b, (*IntermediateTypeGrubMkconfig)(g))
+	if err != nil {
+		return fmt.Errorf("failed to parse [GrubMkconfig]: %w", err)
+	}
+
+	// Now validate the resulting unmarshaled object
+	err = g.IsValid()
+	if err != nil {
+		return fmt.Errorf("failed to parse [GrubMkconfig]: %w", err)
+	}
+	return
+}
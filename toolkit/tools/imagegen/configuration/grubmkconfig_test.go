@@ -0,0 +1,68 @@
+// Copyright Microsoft Corporation.
+// Licensed under the MIT License.
+
+package configuration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//TestMain found in configuration_test.go.
+
+var (
+	validGrubMkconfig GrubMkconfig = GrubMkconfig{
+		Enable: true,
+		Defaults: map[string]string{
+			"GRUB_TIMEOUT":               "5",
+			"GRUB_CMDLINE_LINUX_DEFAULT": "quiet",
+		},
+	}
+	invalidGrubMkconfigJSON = `{"Enable": true, "Defaults": {"GRUB_CMDLINE_LINUX": "console=ttyS0"}}`
+)
+
+func TestShouldSucceedParsingDefaultGrubMkconfig_GrubMkconfig(t *testing.T) {
+	var checkedGrubMkconfig GrubMkconfig
+	err := marshalJSONString("{}", &checkedGrubMkconfig)
+	assert.NoError(t, err)
+	assert.Equal(t, GrubMkconfig{}, checkedGrubMkconfig)
+}
+
+func TestShouldSucceedParsingValidGrubMkconfig_GrubMkconfig(t *testing.T) {
+	var checkedGrubMkconfig GrubMkconfig
+	err := remarshalJSON(validGrubMkconfig, &checkedGrubMkconfig)
+	assert.NoError(t, err)
+	assert.Equal(t, validGrubMkconfig, checkedGrubMkconfig)
+}
+
+func TestShouldFailParsingCmdlineLinux_GrubMkconfig(t *testing.T) {
+	var checkedGrubMkconfig GrubMkconfig
+	err := marshalJSONString(invalidGrubMkconfigJSON, &checkedGrubMkconfig)
+	assert.Error(t, err)
+	assert.Equal(t, "failed to parse [GrubMkconfig]: invalid [Defaults] variable (GRUB_CMDLINE_LINUX), the kernel command line is set through [KernelCommandLine]", err.Error())
+}
+
+func TestShouldFailParsingLowerCaseVariable_GrubMkconfig(t *testing.T) {
+	invalidGrubMkconfig := GrubMkconfig{Enable: true, Defaults: map[string]string{"grub_timeout": "5"}}
+
+	err := invalidGrubMkconfig.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [Defaults] variable (grub_timeout), must be an upper case name starting with 'GRUB_'", err.Error())
+}
+
+func TestShouldFailParsingQuotedValue_GrubMkconfig(t *testing.T) {
+	invalidGrubMkconfig := GrubMkconfig{Enable: true, Defaults: map[string]string{"GRUB_DISTRIBUTOR": "\"Contoso\""}}
+
+	err := invalidGrubMkconfig.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [Defaults] value (\"Contoso\") for (GRUB_DISTRIBUTOR), may not contain quotes, '\\', '$', '`' or line breaks", err.Error())
+}
+
+func TestShouldFailParsingDefaultsWithoutEnable_GrubMkconfig(t *testing.T) {
+	invalidGrubMkconfig := GrubMkconfig{Defaults: map[string]string{"GRUB_TIMEOUT": "5"}}
+
+	err := invalidGrubMkconfig.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "[Defaults] require [Enable] to be set", err.Error())
+}
@@ -0,0 +1,72 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Parser for the image builder's configuration schemas.
+
+package configuration
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+var (
+	// grubUsernameRegex matches the user names grub accepts in its superusers list
+	grubUsernameRegex = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`)
+	// grubPbkdf2HashRegex matches the output of grub-mkpasswd-pbkdf2:
+	// grub.pbkdf2.sha512.<iterations>.<salt>.<hash>, with the salt and hash in hex
+	grubPbkdf2HashRegex = regexp.MustCompile(`^grub\.pbkdf2\.sha512\.[1-9][0-9]*\.[0-9A-Fa-f]+\.[0-9A-Fa-f]+$`)
+)
+
+// GrubPassword protects the grub menu with a superuser password, so the menu entries can
+// be booted but not edited, and the grub shell can't be opened, without it.
+//   - Username: The grub superuser
+//   - PasswordHash: The superuser's password, hashed with grub-mkpasswd-pbkdf2
+type GrubPassword struct {
+	Username     string `json:"Username"`
+	PasswordHash string `json:"PasswordHash"`
+}
+
+// IsEnabled returns true if a grub superuser is configured.
+func (g *GrubPassword) IsEnabled() bool {
+	return g.Username != ""
+}
+
+// IsValid returns an error if the GrubPassword is not valid
+func (g *GrubPassword) IsValid() (err error) {
+	if g.Username == "" && g.PasswordHash == "" {
+		return
+	}
+
+	if g.Username == "" || g.PasswordHash == "" {
+		return fmt.Errorf("[Username] and [PasswordHash] must be set together")
+	}
+
+	if !grubUsernameRegex.MatchString(g.Username) {
+		return fmt.Errorf("invalid [Username] (%s), may only contain letters, digits, '_', '.' and '-'", g.Username)
+	}
+
+	if !grubPbkdf2HashRegex.MatchString(g.PasswordHash) {
+		return fmt.Errorf("invalid [PasswordHash], must be a 'grub.pbkdf2.sha512.<iterations>.<salt>.<hash>' value generated by grub-mkpasswd-pbkdf2")
+	}
+
+	return
+}
+
+// UnmarshalJSON Unmarshals a GrubPassword entry
+func (g *GrubPassword) UnmarshalJSON(b []byte) (err error) {
+	// Use an intermediate type which will use the default JSON unmarshal implementation
+	type IntermediateTypeGrubPassword GrubPassword
+	err = json.Unmarshal(b, (*IntermediateTypeGrubPassword)(g))
+	if err != nil {
+		return fmt.Errorf("failed to parse [GrubPassword]: %w", err)
+	}
+
+	// Now validate the resulting unmarshaled object
+	err = g.IsValid()
+	if err != nil {
+		return fmt.Errorf("failed to parse [GrubPassword]: %w", err)
+	}
+	return
+}
@@ -0,0 +1,68 @@
+// Copyright Microsoft Corporation.
+// Licensed under the MIT License.
+
+package configuration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//TestMain found in configuration_test.go.
+
+var (
+	validGrubPassword GrubPassword = GrubPassword{
+		Username:     "kioskadmin",
+		PasswordHash: "grub.pbkdf2.sha512.10000.7D8D5F1C0E6A3B2F.0A1B2C3D4E5F60718293A4B5C6D7E8F9",
+	}
+	invalidGrubPasswordJSON = `{"Username": "kioskadmin"}`
+)
+
+func TestShouldSucceedParsingValidGrubPassword_GrubPassword(t *testing.T) {
+	var checkedGrubPassword GrubPassword
+	err := remarshalJSON(validGrubPassword, &checkedGrubPassword)
+	assert.NoError(t, err)
+	assert.Equal(t, validGrubPassword, checkedGrubPassword)
+	assert.True(t, checkedGrubPassword.IsEnabled())
+}
+
+func TestShouldSucceedParsingEmptyGrubPassword_GrubPassword(t *testing.T) {
+	var checkedGrubPassword GrubPassword
+	err := marshalJSONString(`{}`, &checkedGrubPassword)
+	assert.NoError(t, err)
+	assert.False(t, checkedGrubPassword.IsEnabled())
+}
+
+func TestShouldFailParsingUsernameWithoutHash_GrubPassword(t *testing.T) {
+	var checkedGrubPassword GrubPassword
+	err := marshalJSONString(invalidGrubPasswordJSON, &checkedGrubPassword)
+	assert.Error(t, err)
+	assert.Equal(t, "failed to parse [GrubPassword]: [Username] and [PasswordHash] must be set together", err.Error())
+}
+
+func TestShouldFailParsingInvalidUsername_GrubPassword(t *testing.T) {
+	invalidGrubPassword := validGrubPassword
+	invalidGrubPassword.Username = "kiosk admin"
+
+	err := invalidGrubPassword.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [Username] (kiosk admin), may only contain letters, digits, '_', '.' and '-'", err.Error())
+}
+
+func TestShouldFailParsingPlainTextPassword_GrubPassword(t *testing.T) {
+	invalidGrubPassword := validGrubPassword
+	invalidGrubPassword.PasswordHash = "hunter2"
+
+	err := invalidGrubPassword.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [PasswordHash], must be a 'grub.pbkdf2.sha512.<iterations>.<salt>.<hash>' value generated by grub-mkpasswd-pbkdf2", err.Error())
+}
+
+func TestShouldFailParsingNonHexHash_GrubPassword(t *testing.T) {
+	invalidGrubPassword := validGrubPassword
+	invalidGrubPassword.PasswordHash = "grub.pbkdf2.sha512.10000.SALT.HASH"
+
+	err := invalidGrubPassword.IsValid()
+	assert.Error(t, err)
+}
@@ -0,0 +1,96 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Parser for the image builder's configuration schemas.
+
+package configuration
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"microsoft.com/pkggen/internal/file"
+)
+
+const (
+	// GrubThemeFile is the file describing a grub theme, which must be at the top of the theme directory
+	GrubThemeFile = "theme.txt"
+)
+
+// grubBackgroundFormats are the image formats grub can load as a background, by file extension
+var grubBackgroundFormats = map[string]string{".png": "png", ".jpg": "jpeg", ".jpeg": "jpeg", ".tga": "tga"}
+
+// GrubTheme gives the grub menu a graphical theme and/or a background image.
+//   - Directory: A grub theme directory with a theme.txt, installed under /boot/grub2/themes
+//   - Background: An image shown behind the menu, in PNG, JPEG or TGA format, installed under /boot/grub2
+type GrubTheme struct {
+	Directory  string `json:"Directory"`
+	Background string `json:"Background"`
+}
+
+// IsEnabled returns true if a theme or a background is configured
+func (g *GrubTheme) IsEnabled() bool {
+	return g.Directory != "" || g.Background != ""
+}
+
+// GetThemeName returns the name of the theme, which is the name of its directory
+func (g *GrubTheme) GetThemeName() string {
+	return filepath.Base(filepath.Clean(g.Directory))
+}
+
+// GetBackgroundModule returns the grub module which loads the background image
+func (g *GrubTheme) GetBackgroundModule() string {
+	return grubBackgroundFormats[strings.ToLower(filepath.Ext(g.Background))]
+}
+
+// IsValid returns an error if the GrubTheme is not valid
+func (g *GrubTheme) IsValid() (err error) {
+	if g.Directory != "" {
+		name := g.GetThemeName()
+		if name == "/" || name == "." || name == ".." {
+			return fmt.Errorf("invalid [Directory] (%s), must be a theme directory", g.Directory)
+		}
+	}
+
+	if g.Background != "" && g.GetBackgroundModule() == "" {
+		return fmt.Errorf("invalid [Background] (%s), must be a .png, .jpg, .jpeg or .tga image", g.Background)
+	}
+
+	return
+}
+
+// checkThemeFile returns an error if the theme directory has no theme.txt at its top. It runs once the
+// relative paths are resolved, as IsValid runs before.
+func (g *GrubTheme) checkThemeFile() (err error) {
+	if g.Directory == "" {
+		return
+	}
+
+	exists, err := file.PathExists(filepath.Join(g.Directory, GrubThemeFile))
+	if err != nil {
+		return
+	}
+	if !exists {
+		return fmt.Errorf("invalid [Directory] (%s), the grub theme directory has no %s", g.Directory, GrubThemeFile)
+	}
+	return
+}
+
+// UnmarshalJSON Unmarshals a GrubTheme entry
+func (g *GrubTheme) UnmarshalJSON(b []byte) (err error) {
+	// Use an intermediate type which will use the default JSON unmarshal implementation
+	type IntermediateTypeGrubTheme GrubTheme
+	err = json.Unmarshal(b, (*IntermediateTypeGrubTheme)(g))
+	if err != nil {
+		return fmt.Errorf("failed to parse [GrubTheme]: %w", err)
+	}
+
+	// Now validate the resulting unmarshaled object
+	err = g.IsValid()
+	if err != nil {
+		return fmt.Errorf("failed to parse [GrubTheme]: %w", err)
+	}
+	return
+}
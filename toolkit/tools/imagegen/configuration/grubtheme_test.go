@@ -0,0 +1,66 @@
+// Copyright Microsoft Corporation.
+// Licensed under the MIT License.
+
+package configuration
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//TestMain found in configuration_test.go.
+
+var (
+	validGrubTheme GrubTheme = GrubTheme{
+		Directory:  "branding/contoso/",
+		Background: "branding/splash.JPG",
+	}
+	invalidGrubThemeJSON = `{"Background": "branding/splash.bmp"}`
+)
+
+func TestShouldSucceedParsingDefaultGrubTheme_GrubTheme(t *testing.T) {
+	var checkedGrubTheme GrubTheme
+	err := marshalJSONString("{}", &checkedGrubTheme)
+	assert.NoError(t, err)
+	assert.False(t, checkedGrubTheme.IsEnabled())
+}
+
+func TestShouldSucceedParsingValidGrubTheme_GrubTheme(t *testing.T) {
+	var checkedGrubTheme GrubTheme
+	err := remarshalJSON(validGrubTheme, &checkedGrubTheme)
+	assert.NoError(t, err)
+	assert.Equal(t, validGrubTheme, checkedGrubTheme)
+	assert.True(t, checkedGrubTheme.IsEnabled())
+	assert.Equal(t, "contoso", checkedGrubTheme.GetThemeName())
+	assert.Equal(t, "jpeg", checkedGrubTheme.GetBackgroundModule())
+}
+
+func TestShouldFailParsingUnsupportedBackground_GrubTheme(t *testing.T) {
+	var checkedGrubTheme GrubTheme
+	err := marshalJSONString(invalidGrubThemeJSON, &checkedGrubTheme)
+	assert.Error(t, err)
+	assert.Equal(t, "failed to parse [GrubTheme]: invalid [Background] (branding/splash.bmp), must be a .png, .jpg, .jpeg or .tga image", err.Error())
+}
+
+func TestShouldFailParsingRootDirectory_GrubTheme(t *testing.T) {
+	invalidGrubTheme := GrubTheme{Directory: "/"}
+
+	err := invalidGrubTheme.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [Directory] (/), must be a theme directory", err.Error())
+}
+
+func TestShouldCheckThemeFile_GrubTheme(t *testing.T) {
+	themeDir := t.TempDir()
+	grubTheme := GrubTheme{Directory: themeDir}
+
+	err := grubTheme.checkThemeFile()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [Directory] ("+themeDir+"), the grub theme directory has no theme.txt", err.Error())
+
+	assert.NoError(t, os.WriteFile(filepath.Join(themeDir, GrubThemeFile), []byte("title-text: \"\"\n"), 0644))
+	assert.NoError(t, grubTheme.checkThemeFile())
+}
@@ -0,0 +1,72 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Parser for the image builder's configuration schemas.
+
+package configuration
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+)
+
+// InitramfsFile is a file copied from the build host into the initramfs.
+//   - Source: Path of the file on the build host
+//   - Path: Absolute path of the file in the initramfs, ie "/usr/lib/firmware/contoso.bin"
+type InitramfsFile struct {
+	Source string `json:"Source"`
+	Path   string `json:"Path"`
+}
+
+// InitramfsCustomization edits the initramfs of every installed kernel without rebuilding it with dracut.
+// Each initramfs is unpacked, modified and repacked in place.
+//   - Files: Files added to the initramfs, replacing existing ones
+//   - Script: Script run in the install chroot with the path of the unpacked initramfs as its argument
+type InitramfsCustomization struct {
+	Files  []InitramfsFile `json:"Files"`
+	Script string          `json:"Script"`
+}
+
+// IsEmpty returns true if the initramfs is not customized
+func (i *InitramfsCustomization) IsEmpty() bool {
+	return len(i.Files) == 0 && i.Script == ""
+}
+
+// IsValid returns an error if the InitramfsCustomization is not valid
+func (i *InitramfsCustomization) IsValid() (err error) {
+	paths := make(map[string]bool)
+	for _, initramfsFile := range i.Files {
+		if initramfsFile.Source == "" {
+			return fmt.Errorf("invalid [Files]: file (%s) has no [Source]", initramfsFile.Path)
+		}
+
+		path := initramfsFile.Path
+		if !filepath.IsAbs(path) || filepath.Clean(path) != path || path == "/" {
+			return fmt.Errorf("invalid [Files]: path (%s) must be a clean absolute path of a file in the initramfs, ie '/usr/lib/firmware/contoso.bin'", path)
+		}
+		if paths[path] {
+			return fmt.Errorf("invalid [Files]: (%s) is added more than once", path)
+		}
+		paths[path] = true
+	}
+
+	return
+}
+
+// UnmarshalJSON Unmarshals an InitramfsCustomization entry
+func (i *InitramfsCustomization) UnmarshalJSON(b []byte) (err error) {
+	// Use an intermediate type which will use the default JSON unmarshal implementation
+	type IntermediateTypeInitramfsCustomization InitramfsCustomization
+	err = json.Unmarshal(b, (*IntermediateTypeInitramfsCustomization)(i))
+	if err != nil {
+		return fmt.Errorf("failed to parse [InitramfsCustomization]: %w", err)
+	}
+
+	// Now validate the resulting unmarshaled object
+	err = i.IsValid()
+	if err != nil {
+		return fmt.Errorf("failed to parse [InitramfsCustomization]: %w", err)
+	}
+	return
+}
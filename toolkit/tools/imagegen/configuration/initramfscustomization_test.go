@@ -0,0 +1,56 @@
+// Copyright Microsoft Corporation.
+// Licensed under the MIT License.
+
+package configuration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//TestMain found in configuration_test.go.
+
+var (
+	validInitramfsCustomization InitramfsCustomization = InitramfsCustomization{
+		Files: []InitramfsFile{
+			{Source: "firmware/contoso.bin", Path: "/usr/lib/firmware/contoso.bin"},
+		},
+		Script: "scripts/tweak-initramfs.sh",
+	}
+	invalidInitramfsCustomizationJSON = `{"Files": [{"Source": "firmware/contoso.bin", "Path": "usr/lib/firmware/contoso.bin"}]}`
+)
+
+func TestShouldSucceedParsingDefaultInitramfsCustomization_InitramfsCustomization(t *testing.T) {
+	var checkedInitramfsCustomization InitramfsCustomization
+	err := marshalJSONString("{}", &checkedInitramfsCustomization)
+	assert.NoError(t, err)
+	assert.True(t, checkedInitramfsCustomization.IsEmpty())
+}
+
+func TestShouldSucceedParsingValidInitramfsCustomization_InitramfsCustomization(t *testing.T) {
+	var checkedInitramfsCustomization InitramfsCustomization
+	err := remarshalJSON(validInitramfsCustomization, &checkedInitramfsCustomization)
+	assert.NoError(t, err)
+	assert.Equal(t, validInitramfsCustomization, checkedInitramfsCustomization)
+}
+
+func TestShouldFailParsingRelativePath_InitramfsCustomization(t *testing.T) {
+	var checkedInitramfsCustomization InitramfsCustomization
+	err := marshalJSONString(invalidInitramfsCustomizationJSON, &checkedInitramfsCustomization)
+	assert.Error(t, err)
+	assert.Equal(t, "failed to parse [InitramfsCustomization]: invalid [Files]: path (usr/lib/firmware/contoso.bin) must be a clean absolute path of a file in the initramfs, ie '/usr/lib/firmware/contoso.bin'", err.Error())
+}
+
+func TestShouldFailParsingDuplicatePath_InitramfsCustomization(t *testing.T) {
+	invalidInitramfsCustomization := InitramfsCustomization{
+		Files: []InitramfsFile{
+			{Source: "a.bin", Path: "/etc/contoso.conf"},
+			{Source: "b.bin", Path: "/etc/contoso.conf"},
+		},
+	}
+
+	err := invalidInitramfsCustomization.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [Files]: (/etc/contoso.conf) is added more than once", err.Error())
+}
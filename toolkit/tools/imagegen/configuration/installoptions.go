@@ -0,0 +1,54 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Parser for the image builder's configuration schemas.
+
+package configuration
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	// installWeakDepsOption is the tdnf option selecting whether weak dependencies are installed
+	installWeakDepsOption = "install_weak_deps"
+	// tsflagsOption is the tdnf option holding the rpm transaction flags
+	tsflagsOption = "tsflags"
+)
+
+// InstallOptions holds toggles for how every package of the image is installed.
+//   - NoWeakDeps: Don't install the weak dependencies (Recommends) of the packages
+//   - NoDocs: Don't install the documentation files of the packages
+type InstallOptions struct {
+	NoWeakDeps bool `json:"NoWeakDeps"`
+	NoDocs     bool `json:"NoDocs"`
+}
+
+// IsEmpty returns true if no install option is set
+func (i *InstallOptions) IsEmpty() bool {
+	return !i.NoWeakDeps && !i.NoDocs
+}
+
+// GetTdnfOptions returns the tdnf options the install options translate to
+func (i *InstallOptions) GetTdnfOptions() (options map[string]string) {
+	options = make(map[string]string)
+	if i.NoWeakDeps {
+		options[installWeakDepsOption] = "False"
+	}
+	if i.NoDocs {
+		options[tsflagsOption] = "nodocs"
+	}
+	return
+}
+
+// UnmarshalJSON Unmarshals an InstallOptions entry
+func (i *InstallOptions) UnmarshalJSON(b []byte) (err error) {
+	// Use an intermediate type which will use the default JSON unmarshal implementation
+	type IntermediateTypeInstallOptions InstallOptions
+	err = json.Unmarshal(b, (*IntermediateTypeInstallOptions)(i))
+	if err != nil {
+		return fmt.Errorf("failed to parse [InstallOptions]: %w", err)
+	}
+	return
+}
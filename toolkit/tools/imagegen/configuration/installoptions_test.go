@@ -0,0 +1,44 @@
+// Copyright Microsoft Corporation.
+// Licensed under the MIT License.
+
+package configuration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//TestMain found in configuration_test.go.
+
+var (
+	validInstallOptions InstallOptions = InstallOptions{
+		NoWeakDeps: true,
+		NoDocs:     true,
+	}
+	invalidInstallOptionsJSON = `{"NoDocs": "yes"}`
+)
+
+func TestShouldSucceedParsingDefaultInstallOptions_InstallOptions(t *testing.T) {
+	var checkedInstallOptions InstallOptions
+	err := marshalJSONString("{}", &checkedInstallOptions)
+	assert.NoError(t, err)
+	assert.True(t, checkedInstallOptions.IsEmpty())
+	assert.Empty(t, checkedInstallOptions.GetTdnfOptions())
+}
+
+func TestShouldSucceedParsingValidInstallOptions_InstallOptions(t *testing.T) {
+	var checkedInstallOptions InstallOptions
+	err := remarshalJSON(validInstallOptions, &checkedInstallOptions)
+	assert.NoError(t, err)
+	assert.Equal(t, validInstallOptions, checkedInstallOptions)
+	assert.False(t, checkedInstallOptions.IsEmpty())
+	assert.Equal(t, map[string]string{"install_weak_deps": "False", "tsflags": "nodocs"}, checkedInstallOptions.GetTdnfOptions())
+}
+
+func TestShouldFailParsingInvalidInstallOptions_InstallOptions(t *testing.T) {
+	var checkedInstallOptions InstallOptions
+	err := marshalJSONString(invalidInstallOptionsJSON, &checkedInstallOptions)
+	assert.Error(t, err)
+	assert.Equal(t, "failed to parse [InstallOptions]: json: cannot unmarshal string into Go struct field IntermediateTypeInstallOptions.NoDocs of type bool", err.Error())
+}
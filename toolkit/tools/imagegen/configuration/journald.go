@@ -0,0 +1,70 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Parser for the image builder's configuration schemas.
+
+package configuration
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+var (
+	// journaldSizeRegex matches a size in bytes with an optional base 1024 suffix, as journald.conf accepts
+	journaldSizeRegex = regexp.MustCompile(`^[1-9][0-9]*[KMGTPE]?$`)
+	// journaldStorageModes are the values journald.conf accepts for Storage
+	journaldStorageModes = map[string]bool{"volatile": true, "persistent": true, "auto": true, "none": true}
+)
+
+// Journald holds the journal storage settings written to a journald.conf drop-in.
+//   - Storage: Where the journal is kept, "volatile" keeps it in memory under /run
+//   - SystemMaxUse: Disk space the journal may use under /var/log/journal, such as "200M"
+//   - RuntimeMaxUse: Memory the journal may use under /run/log/journal
+type Journald struct {
+	Storage       string `json:"Storage"`
+	SystemMaxUse  string `json:"SystemMaxUse"`
+	RuntimeMaxUse string `json:"RuntimeMaxUse"`
+}
+
+// IsEmpty returns true if no journald setting is set
+func (j *Journald) IsEmpty() bool {
+	return j.Storage == "" && j.SystemMaxUse == "" && j.RuntimeMaxUse == ""
+}
+
+// IsValid returns an error if the Journald is not valid
+func (j *Journald) IsValid() (err error) {
+	if j.Storage != "" && !journaldStorageModes[j.Storage] {
+		return fmt.Errorf("invalid [Storage] (%s), must be one of 'volatile', 'persistent', 'auto' or 'none'", j.Storage)
+	}
+
+	for name, size := range map[string]string{"SystemMaxUse": j.SystemMaxUse, "RuntimeMaxUse": j.RuntimeMaxUse} {
+		if size != "" && !journaldSizeRegex.MatchString(size) {
+			return fmt.Errorf("invalid [%s] (%s), must be a size in bytes with an optional K, M, G, T, P or E suffix", name, size)
+		}
+	}
+
+	if j.SystemMaxUse != "" && (j.Storage == "volatile" || j.Storage == "none") {
+		return fmt.Errorf("[SystemMaxUse] has no effect when [Storage] is (%s)", j.Storage)
+	}
+
+	return
+}
+
+// UnmarshalJSON Unmarshals a Journald entry
+func (j *Journald) UnmarshalJSON(b []byte) (err error) {
+	// Use an intermediate type which will use the default JSON unmarshal implementation
+	type IntermediateTypeJournald Journald
+	err = json.Unmarshal(b, (*IntermediateTypeJournald)(j))
+	if err != nil {
+		return fmt.Errorf("failed to parse [Journald]: %w", err)
+	}
+
+	// Now validate the resulting unmarshaled object
+	err = j.IsValid()
+	if err != nil {
+		return fmt.Errorf("failed to parse [Journald]: %w", err)
+	}
+	return
+}
@@ -0,0 +1,61 @@
+// Copyright Microsoft Corporation.
+// Licensed under the MIT License.
+
+package configuration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//TestMain found in configuration_test.go.
+
+var (
+	validJournald Journald = Journald{
+		Storage:       "persistent",
+		SystemMaxUse:  "200M",
+		RuntimeMaxUse: "32M",
+	}
+	invalidJournaldJSON = `{"Storage": "disk"}`
+)
+
+func TestShouldSucceedParsingDefaultJournald_Journald(t *testing.T) {
+	var checkedJournald Journald
+	err := marshalJSONString("{}", &checkedJournald)
+	assert.NoError(t, err)
+	assert.True(t, checkedJournald.IsEmpty())
+}
+
+func TestShouldSucceedParsingValidJournald_Journald(t *testing.T) {
+	var checkedJournald Journald
+	err := remarshalJSON(validJournald, &checkedJournald)
+	assert.NoError(t, err)
+	assert.Equal(t, validJournald, checkedJournald)
+	assert.False(t, checkedJournald.IsEmpty())
+}
+
+func TestShouldFailParsingInvalidStorage_Journald(t *testing.T) {
+	var checkedJournald Journald
+	err := marshalJSONString(invalidJournaldJSON, &checkedJournald)
+	assert.Error(t, err)
+	assert.Equal(t, "failed to parse [Journald]: invalid [Storage] (disk), must be one of 'volatile', 'persistent', 'auto' or 'none'", err.Error())
+}
+
+func TestShouldFailParsingInvalidSize_Journald(t *testing.T) {
+	invalidJournald := validJournald
+	invalidJournald.SystemMaxUse = "200MB"
+
+	err := invalidJournald.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [SystemMaxUse] (200MB), must be a size in bytes with an optional K, M, G, T, P or E suffix", err.Error())
+}
+
+func TestShouldFailParsingSystemMaxUseWithVolatileStorage_Journald(t *testing.T) {
+	invalidJournald := validJournald
+	invalidJournald.Storage = "volatile"
+
+	err := invalidJournald.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "[SystemMaxUse] has no effect when [Storage] is (volatile)", err.Error())
+}
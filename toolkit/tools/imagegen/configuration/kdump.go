@@ -0,0 +1,207 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Parser for the image builder's configuration schemas.
+
+package configuration
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// KdumpPackage provides kdump.service and /etc/kdump.conf
+	KdumpPackage = "kexec-tools"
+
+	// minCrashKernelReservation is the smallest reservation a kdump kernel and initramfs boot in
+	minCrashKernelReservation = 64 * 1024 * 1024
+)
+
+var (
+	// kernelMemSizeRegex matches a size in the kernel's memparse syntax, ie "256M"
+	kernelMemSizeRegex = regexp.MustCompile(`^(\d+)([KMGT]?)$`)
+
+	// kdumpTargetTypes are the dump target types of /etc/kdump.conf
+	kdumpTargetTypes = map[string]bool{
+		"raw":      true,
+		"nfs":      true,
+		"ssh":      true,
+		"ext2":     true,
+		"ext3":     true,
+		"ext4":     true,
+		"xfs":      true,
+		"btrfs":    true,
+		"virtiofs": true,
+	}
+)
+
+// Kdump configures the kernel crash dump service.
+//   - Enable: Install kexec-tools, reserve memory for the crash kernel and enable kdump.service
+//   - CrashKernel: Value of the crashkernel kernel argument, ie "256M" or "1G-4G:192M,4G-:256M"
+//   - ExpectedMemory: RAM of the machines the image runs on, ie "8G". If set, CrashKernel is checked
+//     to reserve a plausible amount of it.
+//   - Path: Directory the dumps are written to, relative to the dump target. Defaults to /var/crash.
+//   - Target: Dump target line of /etc/kdump.conf, ie "nfs server:/export/crash". Defaults to the root file system.
+type Kdump struct {
+	Enable         bool   `json:"Enable"`
+	CrashKernel    string `json:"CrashKernel"`
+	ExpectedMemory string `json:"ExpectedMemory"`
+	Path           string `json:"Path"`
+	Target         string `json:"Target"`
+}
+
+// GetKernelArg returns the crashkernel kernel argument
+func (k *Kdump) GetKernelArg() string {
+	return fmt.Sprintf("crashkernel=%s", k.CrashKernel)
+}
+
+// IsValid returns an error if the Kdump is not valid
+func (k *Kdump) IsValid() (err error) {
+	if !k.Enable {
+		if k.CrashKernel != "" || k.ExpectedMemory != "" || k.Path != "" || k.Target != "" {
+			return fmt.Errorf("[CrashKernel], [ExpectedMemory], [Path] and [Target] require [Enable] to be set")
+		}
+		return
+	}
+
+	if k.CrashKernel == "" {
+		return fmt.Errorf("missing [CrashKernel]")
+	}
+	// Parse the reservation of a machine with no memory to validate the format only
+	_, err = CrashKernelReservation(k.CrashKernel, 0)
+	if err != nil {
+		return fmt.Errorf("invalid [CrashKernel] (%s): %w", k.CrashKernel, err)
+	}
+
+	if k.ExpectedMemory != "" {
+		memory, parseErr := parseKernelMemSize(k.ExpectedMemory)
+		if parseErr != nil {
+			return fmt.Errorf("invalid [ExpectedMemory] (%s): %w", k.ExpectedMemory, parseErr)
+		}
+
+		reservation, _ := CrashKernelReservation(k.CrashKernel, memory)
+		if reservation == 0 {
+			return fmt.Errorf("invalid [CrashKernel] (%s): reserves no memory on a system with %s of RAM", k.CrashKernel, k.ExpectedMemory)
+		}
+		if reservation < minCrashKernelReservation {
+			return fmt.Errorf("invalid [CrashKernel] (%s): reserves %dM on a system with %s of RAM, kdump needs at least %dM", k.CrashKernel, reservation>>20, k.ExpectedMemory, minCrashKernelReservation>>20)
+		}
+		if reservation > memory/2 {
+			return fmt.Errorf("invalid [CrashKernel] (%s): reserves %dM, more than half of the %s of RAM", k.CrashKernel, reservation>>20, k.ExpectedMemory)
+		}
+	}
+
+	if k.Path != "" && (!filepath.IsAbs(k.Path) || strings.ContainsAny(k.Path, " \n")) {
+		return fmt.Errorf("invalid [Path] (%s), must be an absolute path without spaces", k.Path)
+	}
+
+	if k.Target != "" {
+		fields := strings.Fields(k.Target)
+		if len(fields) != 2 || strings.Contains(k.Target, "\n") || !kdumpTargetTypes[fields[0]] {
+			return fmt.Errorf("invalid [Target] (%s), must be '<type> <target>' with a type supported by /etc/kdump.conf, ie 'nfs server:/export/crash'", k.Target)
+		}
+	}
+
+	return
+}
+
+// IsKdumpTargetType returns true if directive is one of the dump target types of /etc/kdump.conf
+func IsKdumpTargetType(directive string) bool {
+	return kdumpTargetTypes[directive]
+}
+
+// CrashKernelReservation returns how many bytes a crashkernel value reserves on a system with the given
+// amount of memory. It accepts the "<size>[@<offset>]" and "<start>-[<end>]:<size>[,...]" forms,
+// optionally followed by ",high" or ",low".
+func CrashKernelReservation(crashKernel string, memory uint64) (reservation uint64, err error) {
+	value := strings.TrimSuffix(strings.TrimSuffix(crashKernel, ",high"), ",low")
+
+	if !strings.Contains(value, ":") {
+		sizeAndOffset := strings.SplitN(value, "@", 2)
+		if len(sizeAndOffset) == 2 {
+			if _, err = parseKernelMemSize(sizeAndOffset[1]); err != nil {
+				return 0, fmt.Errorf("invalid offset: %w", err)
+			}
+		}
+		return parseKernelMemSize(sizeAndOffset[0])
+	}
+
+	rangesAndOffset := strings.SplitN(value, "@", 2)
+	if len(rangesAndOffset) == 2 {
+		if _, err = parseKernelMemSize(rangesAndOffset[1]); err != nil {
+			return 0, fmt.Errorf("invalid offset: %w", err)
+		}
+	}
+
+	for _, memRange := range strings.Split(rangesAndOffset[0], ",") {
+		boundsAndSize := strings.SplitN(memRange, ":", 2)
+		bounds := strings.SplitN(boundsAndSize[0], "-", 2)
+		if len(boundsAndSize) != 2 || len(bounds) != 2 {
+			return 0, fmt.Errorf("range (%s) must be '<start>-[<end>]:<size>'", memRange)
+		}
+
+		start, parseErr := parseKernelMemSize(bounds[0])
+		if parseErr != nil {
+			return 0, parseErr
+		}
+		end := ^uint64(0)
+		if bounds[1] != "" {
+			end, parseErr = parseKernelMemSize(bounds[1])
+			if parseErr != nil {
+				return 0, parseErr
+			}
+			if end <= start {
+				return 0, fmt.Errorf("range (%s) ends before it starts", memRange)
+			}
+		}
+		size, parseErr := parseKernelMemSize(boundsAndSize[1])
+		if parseErr != nil {
+			return 0, parseErr
+		}
+
+		// Keep parsing the remaining ranges to validate them
+		if reservation == 0 && memory >= start && memory < end {
+			reservation = size
+		}
+	}
+
+	return
+}
+
+// parseKernelMemSize parses a size in the kernel's memparse syntax, where K, M, G and T are binary units
+func parseKernelMemSize(size string) (bytes uint64, err error) {
+	match := kernelMemSizeRegex.FindStringSubmatch(size)
+	if match == nil {
+		return 0, fmt.Errorf("size (%s) must be a number with an optional K, M, G or T suffix", size)
+	}
+
+	bytes, err = strconv.ParseUint(match[1], 10, 64)
+	if err != nil {
+		return
+	}
+
+	shift := map[string]uint{"": 0, "K": 10, "M": 20, "G": 30, "T": 40}[match[2]]
+	return bytes << shift, nil
+}
+
+// UnmarshalJSON Unmarshals a Kdump entry
+func (k *Kdump) UnmarshalJSON(b []byte) (err error) {
+	// Use an intermediate type which will use the default JSON unmarshal implementation
+	type IntermediateTypeKdump Kdump
+	err = json.Unmarshal(b, (*IntermediateTypeKdump)(k))
+	if err != nil {
+		return fmt.Errorf("failed to parse [Kdump]: %w", err)
+	}
+
+	// Now validate the resulting unmarshaled object
+	err = k.IsValid()
+	if err != nil {
+		return fmt.Errorf("failed to parse [Kdump]: %w", err)
+	}
+	return
+}
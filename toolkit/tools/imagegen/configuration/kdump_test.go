@@ -0,0 +1,95 @@
+// Copyright Microsoft Corporation.
+// Licensed under the MIT License.
+
+package configuration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//TestMain found in configuration_test.go.
+
+var (
+	validKdump Kdump = Kdump{
+		Enable:         true,
+		CrashKernel:    "1G-4G:192M,4G-64G:256M,64G-:512M",
+		ExpectedMemory: "8G",
+		Path:           "/var/crash",
+		Target:         "nfs server:/export/crash",
+	}
+	invalidKdumpJSON = `{"Enable": true, "CrashKernel": "256MB"}`
+)
+
+func TestShouldSucceedParsingDefaultKdump_Kdump(t *testing.T) {
+	var checkedKdump Kdump
+	err := marshalJSONString("{}", &checkedKdump)
+	assert.NoError(t, err)
+	assert.Equal(t, Kdump{}, checkedKdump)
+}
+
+func TestShouldSucceedParsingValidKdump_Kdump(t *testing.T) {
+	var checkedKdump Kdump
+	err := remarshalJSON(validKdump, &checkedKdump)
+	assert.NoError(t, err)
+	assert.Equal(t, validKdump, checkedKdump)
+	assert.Equal(t, "crashkernel=1G-4G:192M,4G-64G:256M,64G-:512M", checkedKdump.GetKernelArg())
+}
+
+func TestShouldFailParsingInvalidCrashKernel_Kdump(t *testing.T) {
+	var checkedKdump Kdump
+	err := marshalJSONString(invalidKdumpJSON, &checkedKdump)
+	assert.Error(t, err)
+	assert.Equal(t, "failed to parse [Kdump]: invalid [CrashKernel] (256MB): size (256MB) must be a number with an optional K, M, G or T suffix", err.Error())
+}
+
+func TestShouldFailParsingSettingsWithoutEnable_Kdump(t *testing.T) {
+	invalidKdump := Kdump{CrashKernel: "256M"}
+
+	err := invalidKdump.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "[CrashKernel], [ExpectedMemory], [Path] and [Target] require [Enable] to be set", err.Error())
+}
+
+func TestShouldFailParsingReservationOutsideRanges_Kdump(t *testing.T) {
+	invalidKdump := validKdump
+	invalidKdump.ExpectedMemory = "512M"
+
+	err := invalidKdump.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [CrashKernel] (1G-4G:192M,4G-64G:256M,64G-:512M): reserves no memory on a system with 512M of RAM", err.Error())
+}
+
+func TestShouldFailParsingOversizedReservation_Kdump(t *testing.T) {
+	invalidKdump := validKdump
+	invalidKdump.CrashKernel = "1G,high"
+	invalidKdump.ExpectedMemory = "1536M"
+
+	err := invalidKdump.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [CrashKernel] (1G,high): reserves 1024M, more than half of the 1536M of RAM", err.Error())
+}
+
+func TestShouldFailParsingUnknownTarget_Kdump(t *testing.T) {
+	invalidKdump := validKdump
+	invalidKdump.Target = "ftp server"
+
+	err := invalidKdump.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [Target] (ftp server), must be '<type> <target>' with a type supported by /etc/kdump.conf, ie 'nfs server:/export/crash'", err.Error())
+}
+
+func TestShouldComputeCrashKernelReservation_Kdump(t *testing.T) {
+	reservation, err := CrashKernelReservation("256M@16M", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(256<<20), reservation)
+
+	reservation, err = CrashKernelReservation("1G-4G:192M,4G-:256M", 16<<30)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(256<<20), reservation)
+
+	_, err = CrashKernelReservation("4G-1G:192M", 0)
+	assert.Error(t, err)
+	assert.Equal(t, "range (4G-1G:192M) ends before it starts", err.Error())
+}
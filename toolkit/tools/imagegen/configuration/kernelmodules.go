@@ -0,0 +1,86 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Parser for the image builder's configuration schemas.
+
+package configuration
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+var (
+	// Kernel module names are made of alphanumeric characters, '_' and '-'
+	kernelModuleNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+)
+
+// KernelModules holds the kernel modules which should be configured on the image.
+//   - Load: Modules which will be loaded at boot through /etc/modules-load.d
+//   - Blacklist: Modules which will be prevented from loading through /etc/modprobe.d
+//   - Include: The only modules, besides the ones needed to mount the partitions, which are put in the initramfs
+//   - Prune: Remove the modules of /lib/modules which are not included, loaded or needed by one of them
+type KernelModules struct {
+	Load      []string `json:"Load"`
+	Blacklist []string `json:"Blacklist"`
+	Include   []string `json:"Include"`
+	Prune     bool     `json:"Prune"`
+}
+
+// IsValid returns an error if the KernelModules is not valid
+func (k *KernelModules) IsValid() (err error) {
+	blacklisted := make(map[string]bool)
+	for _, module := range k.Blacklist {
+		if !kernelModuleNameRegex.MatchString(module) {
+			return fmt.Errorf("invalid kernel module name in [Blacklist] (%s)", module)
+		}
+		blacklisted[module] = true
+	}
+
+	included := make(map[string]bool)
+	for _, module := range k.Include {
+		if !kernelModuleNameRegex.MatchString(module) {
+			return fmt.Errorf("invalid kernel module name in [Include] (%s)", module)
+		}
+		if blacklisted[module] {
+			return fmt.Errorf("kernel module (%s) may not be both included and blacklisted", module)
+		}
+		if included[module] {
+			return fmt.Errorf("kernel module (%s) is listed more than once in [Include]", module)
+		}
+		included[module] = true
+	}
+
+	if k.Prune && len(k.Include) == 0 {
+		return fmt.Errorf("[Prune] requires [Include]")
+	}
+
+	for _, module := range k.Load {
+		if !kernelModuleNameRegex.MatchString(module) {
+			return fmt.Errorf("invalid kernel module name in [Load] (%s)", module)
+		}
+		if blacklisted[module] {
+			return fmt.Errorf("kernel module (%s) may not be both loaded and blacklisted", module)
+		}
+	}
+
+	return
+}
+
+// UnmarshalJSON Unmarshals a KernelModules entry
+func (k *KernelModules) UnmarshalJSON(b []byte) (err error) {
+	// Use an intermediate type which will use the default JSON unmarshal implementation
+	type IntermediateTypeKernelModules KernelModules
+	err = json.Unmarshal(b, (*IntermediateTypeKernelModules)(k))
+	if err != nil {
+		return fmt.Errorf("failed to parse [KernelModules]: %w", err)
+	}
+
+	// Now validate the resulting unmarshaled object
+	err = k.IsValid()
+	if err != nil {
+		return fmt.Errorf("failed to parse [KernelModules]: %w", err)
+	}
+	return
+}
@@ -0,0 +1,110 @@
+// Copyright Microsoft Corporation.
+// Licensed under the MIT License.
+
+package configuration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//TestMain found in configuration_test.go.
+
+var (
+	validKernelModules KernelModules = KernelModules{
+		Load:      []string{"vfio-pci", "br_netfilter"},
+		Blacklist: []string{"nouveau"},
+	}
+	invalidKernelModulesJSON = `{"Load": ["bad/module"]}`
+)
+
+func TestShouldSucceedParsingDefaultKernelModules_KernelModules(t *testing.T) {
+	var checkedModules KernelModules
+	err := marshalJSONString("{}", &checkedModules)
+	assert.NoError(t, err)
+	assert.Equal(t, KernelModules{}, checkedModules)
+}
+
+func TestShouldSucceedParsingValidKernelModules_KernelModules(t *testing.T) {
+	var checkedModules KernelModules
+
+	assert.NoError(t, validKernelModules.IsValid())
+	err := remarshalJSON(validKernelModules, &checkedModules)
+	assert.NoError(t, err)
+	assert.Equal(t, validKernelModules, checkedModules)
+}
+
+func TestShouldFailParsingInvalidModuleName_KernelModules(t *testing.T) {
+	var checkedModules KernelModules
+
+	err := marshalJSONString(invalidKernelModulesJSON, &checkedModules)
+	assert.Error(t, err)
+	assert.Equal(t, "failed to parse [KernelModules]: invalid kernel module name in [Load] (bad/module)", err.Error())
+}
+
+func TestShouldFailParsingInvalidBlacklistName_KernelModules(t *testing.T) {
+	invalidModules := validKernelModules
+	invalidModules.Blacklist = []string{"bad module"}
+
+	err := invalidModules.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid kernel module name in [Blacklist] (bad module)", err.Error())
+}
+
+func TestShouldFailParsingLoadedAndBlacklisted_KernelModules(t *testing.T) {
+	invalidModules := validKernelModules
+	invalidModules.Blacklist = []string{"vfio-pci"}
+
+	err := invalidModules.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "kernel module (vfio-pci) may not be both loaded and blacklisted", err.Error())
+}
+
+func TestShouldSucceedParsingIncludedModules_KernelModules(t *testing.T) {
+	var checkedModules KernelModules
+
+	includedModules := validKernelModules
+	includedModules.Include = []string{"virtio_blk", "virtio-net"}
+	includedModules.Prune = true
+
+	assert.NoError(t, includedModules.IsValid())
+	err := remarshalJSON(includedModules, &checkedModules)
+	assert.NoError(t, err)
+	assert.Equal(t, includedModules, checkedModules)
+}
+
+func TestShouldFailParsingInvalidIncludeName_KernelModules(t *testing.T) {
+	invalidModules := validKernelModules
+	invalidModules.Include = []string{"virtio_blk.ko"}
+
+	err := invalidModules.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid kernel module name in [Include] (virtio_blk.ko)", err.Error())
+}
+
+func TestShouldFailParsingIncludedAndBlacklisted_KernelModules(t *testing.T) {
+	invalidModules := validKernelModules
+	invalidModules.Include = []string{"nouveau"}
+
+	err := invalidModules.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "kernel module (nouveau) may not be both included and blacklisted", err.Error())
+}
+
+func TestShouldFailParsingDuplicateInclude_KernelModules(t *testing.T) {
+	invalidModules := validKernelModules
+	invalidModules.Include = []string{"virtio_blk", "virtio_blk"}
+
+	err := invalidModules.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "kernel module (virtio_blk) is listed more than once in [Include]", err.Error())
+}
+
+func TestShouldFailParsingPruneWithoutInclude_KernelModules(t *testing.T) {
+	var checkedModules KernelModules
+
+	err := marshalJSONString(`{"Prune": true}`, &checkedModules)
+	assert.Error(t, err)
+	assert.Equal(t, "failed to parse [KernelModules]: [Prune] requires [Include]", err.Error())
+}
@@ -0,0 +1,112 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Parser for the image builder's configuration schemas.
+
+package configuration
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+const (
+	// maxLoginDefsDays is the largest number of days shadow-utils treats as a real password aging limit
+	maxLoginDefsDays = 99999
+)
+
+var (
+	// loginDefsUmaskRegex matches an octal umask, such as "027" or "0077"
+	loginDefsUmaskRegex = regexp.MustCompile(`^0?[0-7]{3}$`)
+	// loginDefsEncryptMethods are the password hashing methods shadow-utils and PAM both accept
+	loginDefsEncryptMethods = map[string]bool{"SHA256": true, "SHA512": true, "YESCRYPT": true}
+)
+
+// LoginDefs holds the defaults written to /etc/login.defs.
+//   - Umask: The default file creation mask, such as "027"
+//   - PassMaxDays: Days a password may be used before it must be changed
+//   - PassMinDays: Days a password must be kept before it may be changed
+//   - PassWarnAge: Days before the password expires that users are warned
+//   - EncryptMethod: How new passwords are hashed, "SHA256", "SHA512" or "YESCRYPT"
+type LoginDefs struct {
+	Umask         string  `json:"Umask"`
+	PassMaxDays   *uint64 `json:"PassMaxDays"`
+	PassMinDays   *uint64 `json:"PassMinDays"`
+	PassWarnAge   *uint64 `json:"PassWarnAge"`
+	EncryptMethod string  `json:"EncryptMethod"`
+}
+
+// IsEmpty returns true if no login.defs setting is set
+func (l *LoginDefs) IsEmpty() bool {
+	return l.Umask == "" && l.PassMaxDays == nil && l.PassMinDays == nil && l.PassWarnAge == nil && l.EncryptMethod == ""
+}
+
+// Settings returns the login.defs keys and values to set, in the order they are written
+func (l *LoginDefs) Settings() (keys, values []string) {
+	if l.Umask != "" {
+		keys, values = append(keys, "UMASK"), append(values, l.Umask)
+	}
+	for _, setting := range []struct {
+		key   string
+		value *uint64
+	}{
+		{"PASS_MAX_DAYS", l.PassMaxDays},
+		{"PASS_MIN_DAYS", l.PassMinDays},
+		{"PASS_WARN_AGE", l.PassWarnAge},
+	} {
+		if setting.value != nil {
+			keys, values = append(keys, setting.key), append(values, fmt.Sprintf("%d", *setting.value))
+		}
+	}
+	if l.EncryptMethod != "" {
+		keys, values = append(keys, "ENCRYPT_METHOD"), append(values, l.EncryptMethod)
+	}
+	return
+}
+
+// IsValid returns an error if the LoginDefs is not valid
+func (l *LoginDefs) IsValid() (err error) {
+	if l.Umask != "" && !loginDefsUmaskRegex.MatchString(l.Umask) {
+		return fmt.Errorf("invalid [Umask] (%s), must be an octal mask such as '027'", l.Umask)
+	}
+
+	if l.PassMaxDays != nil && (*l.PassMaxDays < 1 || *l.PassMaxDays > maxLoginDefsDays) {
+		return fmt.Errorf("invalid [PassMaxDays] (%d), must be in the range 1-%d", *l.PassMaxDays, maxLoginDefsDays)
+	}
+
+	for name, days := range map[string]*uint64{"PassMinDays": l.PassMinDays, "PassWarnAge": l.PassWarnAge} {
+		if days == nil {
+			continue
+		}
+		if *days > maxLoginDefsDays {
+			return fmt.Errorf("invalid [%s] (%d), must be in the range 0-%d", name, *days, maxLoginDefsDays)
+		}
+		if l.PassMaxDays != nil && *days > *l.PassMaxDays {
+			return fmt.Errorf("invalid [%s] (%d), must not be more than [PassMaxDays] (%d)", name, *days, *l.PassMaxDays)
+		}
+	}
+
+	if l.EncryptMethod != "" && !loginDefsEncryptMethods[l.EncryptMethod] {
+		return fmt.Errorf("invalid [EncryptMethod] (%s), must be 'SHA256', 'SHA512' or 'YESCRYPT'", l.EncryptMethod)
+	}
+
+	return
+}
+
+// UnmarshalJSON Unmarshals a LoginDefs entry
+func (l *LoginDefs) UnmarshalJSON(b []byte) (err error) {
+	// Use an intermediate type which will use the default JSON unmarshal implementation
+	type IntermediateTypeLoginDefs LoginDefs
+	err = json.Unmarshal(b, (*IntermediateTypeLoginDefs)(l))
+	if err != nil {
+		return fmt.Errorf("failed to parse [LoginDefs]: %w", err)
+	}
+
+	// Now validate the resulting unmarshaled object
+	err = l.IsValid()
+	if err != nil {
+		return fmt.Errorf("failed to parse [LoginDefs]: %w", err)
+	}
+	return
+}
@@ -0,0 +1,77 @@
+// Copyright Microsoft Corporation.
+// Licensed under the MIT License.
+
+package configuration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//TestMain found in configuration_test.go.
+
+var (
+	validPassMaxDays uint64 = 90
+	validPassMinDays uint64 = 0
+
+	validLoginDefs LoginDefs = LoginDefs{
+		Umask:         "027",
+		PassMaxDays:   &validPassMaxDays,
+		PassMinDays:   &validPassMinDays,
+		EncryptMethod: "SHA512",
+	}
+	invalidLoginDefsJSON = `{"Umask": "u=rwx,g=rx"}`
+)
+
+func TestShouldSucceedParsingDefaultLoginDefs_LoginDefs(t *testing.T) {
+	var checkedLoginDefs LoginDefs
+	err := marshalJSONString("{}", &checkedLoginDefs)
+	assert.NoError(t, err)
+	assert.True(t, checkedLoginDefs.IsEmpty())
+}
+
+func TestShouldSucceedParsingValidLoginDefs_LoginDefs(t *testing.T) {
+	var checkedLoginDefs LoginDefs
+	err := remarshalJSON(validLoginDefs, &checkedLoginDefs)
+	assert.NoError(t, err)
+	assert.Equal(t, validLoginDefs, checkedLoginDefs)
+
+	keys, values := checkedLoginDefs.Settings()
+	assert.Equal(t, []string{"UMASK", "PASS_MAX_DAYS", "PASS_MIN_DAYS", "ENCRYPT_METHOD"}, keys)
+	assert.Equal(t, []string{"027", "90", "0", "SHA512"}, values)
+}
+
+func TestShouldFailParsingInvalidUmask_LoginDefs(t *testing.T) {
+	var checkedLoginDefs LoginDefs
+	err := marshalJSONString(invalidLoginDefsJSON, &checkedLoginDefs)
+	assert.Error(t, err)
+	assert.Equal(t, "failed to parse [LoginDefs]: invalid [Umask] (u=rwx,g=rx), must be an octal mask such as '027'", err.Error())
+}
+
+func TestShouldFailParsingZeroPassMaxDays_LoginDefs(t *testing.T) {
+	var zeroDays uint64 = 0
+	invalidLoginDefs := LoginDefs{PassMaxDays: &zeroDays}
+
+	err := invalidLoginDefs.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [PassMaxDays] (0), must be in the range 1-99999", err.Error())
+}
+
+func TestShouldFailParsingPassMinDaysOverMax_LoginDefs(t *testing.T) {
+	var minDays uint64 = 100
+	invalidLoginDefs := validLoginDefs
+	invalidLoginDefs.PassMinDays = &minDays
+
+	err := invalidLoginDefs.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [PassMinDays] (100), must not be more than [PassMaxDays] (90)", err.Error())
+}
+
+func TestShouldFailParsingInvalidEncryptMethod_LoginDefs(t *testing.T) {
+	invalidLoginDefs := LoginDefs{EncryptMethod: "MD5"}
+
+	err := invalidLoginDefs.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [EncryptMethod] (MD5), must be 'SHA256', 'SHA512' or 'YESCRYPT'", err.Error())
+}
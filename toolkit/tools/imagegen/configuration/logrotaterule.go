@@ -0,0 +1,110 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Parser for the image builder's configuration schemas.
+
+package configuration
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	// logrotateRuleNameRegex matches the file names logrotate includes from /etc/logrotate.d. logrotate skips
+	// files with some extensions, such as ".rpmsave" or ".disabled", so dots are not accepted.
+	logrotateRuleNameRegex = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	// logrotateSizeRegex matches a size in bytes with an optional k, M or G suffix, as logrotate accepts
+	logrotateSizeRegex = regexp.MustCompile(`^[1-9][0-9]*[kMG]?$`)
+	// logrotateFrequencies are the rotation intervals logrotate accepts
+	logrotateFrequencies = map[string]bool{"hourly": true, "daily": true, "weekly": true, "monthly": true, "yearly": true}
+	// logrotateDirectives are the logrotate directives which may be given in [Options]. Script blocks,
+	// such as "postrotate", span several lines and are not accepted.
+	logrotateDirectives = map[string]bool{
+		"compress": true, "nocompress": true, "compresscmd": true, "compressext": true, "compressoptions": true,
+		"copy": true, "nocopy": true, "copytruncate": true, "nocopytruncate": true, "create": true, "nocreate": true,
+		"createolddir": true, "nocreateolddir": true, "dateext": true, "nodateext": true, "dateformat": true,
+		"dateyesterday": true, "delaycompress": true, "nodelaycompress": true, "extension": true, "ifempty": true,
+		"notifempty": true, "maxage": true, "maxsize": true, "minage": true, "minsize": true, "missingok": true,
+		"nomissingok": true, "olddir": true, "noolddir": true, "rotate": true, "sharedscripts": true,
+		"nosharedscripts": true, "shred": true, "noshred": true, "shredcycles": true, "size": true, "start": true,
+		"su": true, "tabooext": true, "taboopat": true,
+	}
+)
+
+// LogrotateRule installs a logrotate policy as /etc/logrotate.d/<Name>.
+//   - Name: The file name
+//   - Paths: Absolute paths or glob patterns of the log files to rotate
+//   - Frequency: How often the logs are rotated, "hourly", "daily", "weekly", "monthly" or "yearly"
+//   - Rotate: How many rotated logs are kept, 0 keeps the default from /etc/logrotate.conf
+//   - MaxSize: Rotate a log once it grows larger than this, such as "100M"
+//   - Compress, MissingOk, NotIfEmpty, CopyTruncate: Set the logrotate directive of the same name
+//   - Options: Other logrotate directives, one per entry, such as "dateext"
+type LogrotateRule struct {
+	Name         string   `json:"Name"`
+	Paths        []string `json:"Paths"`
+	Frequency    string   `json:"Frequency"`
+	Rotate       uint     `json:"Rotate"`
+	MaxSize      string   `json:"MaxSize"`
+	Compress     bool     `json:"Compress"`
+	MissingOk    bool     `json:"MissingOk"`
+	NotIfEmpty   bool     `json:"NotIfEmpty"`
+	CopyTruncate bool     `json:"CopyTruncate"`
+	Options      []string `json:"Options"`
+}
+
+// IsValid returns an error if the LogrotateRule is not valid
+func (l *LogrotateRule) IsValid() (err error) {
+	if !logrotateRuleNameRegex.MatchString(l.Name) {
+		return fmt.Errorf("invalid [Name] (%s), must be a file name made of letters, digits, '_' and '-'", l.Name)
+	}
+
+	if len(l.Paths) == 0 {
+		return fmt.Errorf("[Paths] of (%s) must list at least one log file", l.Name)
+	}
+	for _, path := range l.Paths {
+		if !filepath.IsAbs(path) || strings.ContainsAny(path, " \t\n{}\"") {
+			return fmt.Errorf("invalid [Paths] entry (%s) for (%s), must be an absolute path or glob pattern without spaces, quotes or braces", path, l.Name)
+		}
+	}
+
+	if l.Frequency != "" && !logrotateFrequencies[l.Frequency] {
+		return fmt.Errorf("invalid [Frequency] (%s) for (%s), must be one of 'hourly', 'daily', 'weekly', 'monthly' or 'yearly'", l.Frequency, l.Name)
+	}
+
+	if l.MaxSize != "" && !logrotateSizeRegex.MatchString(l.MaxSize) {
+		return fmt.Errorf("invalid [MaxSize] (%s) for (%s), must be a size in bytes with an optional k, M or G suffix", l.MaxSize, l.Name)
+	}
+
+	for _, option := range l.Options {
+		fields := strings.Fields(option)
+		if len(fields) == 0 || strings.ContainsAny(option, "{}\n") {
+			return fmt.Errorf("invalid [Options] entry (%s) for (%s), must be a single logrotate directive", option, l.Name)
+		}
+		if !logrotateDirectives[fields[0]] {
+			return fmt.Errorf("unknown logrotate directive (%s) in [Options] for (%s)", fields[0], l.Name)
+		}
+	}
+
+	return
+}
+
+// UnmarshalJSON Unmarshals a LogrotateRule entry
+func (l *LogrotateRule) UnmarshalJSON(b []byte) (err error) {
+	// Use an intermediate type which will use the default JSON unmarshal implementation
+	type IntermediateTypeLogrotateRule LogrotateRule
+	err = json.Unmarshal(b, (*IntermediateTypeLogrotateRule)(l))
+	if err != nil {
+		return fmt.Errorf("failed to parse [LogrotateRule]: %w", err)
+	}
+
+	// Now validate the resulting unmarshaled object
+	err = l.IsValid()
+	if err != nil {
+		return fmt.Errorf("failed to parse [LogrotateRule]: %w", err)
+	}
+	return
+}
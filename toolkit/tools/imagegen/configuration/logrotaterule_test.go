@@ -0,0 +1,68 @@
+// Copyright Microsoft Corporation.
+// Licensed under the MIT License.
+
+package configuration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//TestMain found in configuration_test.go.
+
+var (
+	validLogrotateRule LogrotateRule = LogrotateRule{
+		Name:       "contoso-agent",
+		Paths:      []string{"/var/log/contoso/*.log"},
+		Frequency:  "daily",
+		Rotate:     7,
+		MaxSize:    "100M",
+		Compress:   true,
+		MissingOk:  true,
+		NotIfEmpty: true,
+		Options:    []string{"dateext", "create 0640 root root"},
+	}
+	invalidLogrotateRuleJSON = `{"Name": "contoso-agent", "Paths": ["/var/log/contoso/*.log"], "Frequency": "fortnightly"}`
+)
+
+func TestShouldSucceedParsingValidLogrotateRule_LogrotateRule(t *testing.T) {
+	var checkedLogrotateRule LogrotateRule
+	err := remarshalJSON(validLogrotateRule, &checkedLogrotateRule)
+	assert.NoError(t, err)
+	assert.Equal(t, validLogrotateRule, checkedLogrotateRule)
+}
+
+func TestShouldFailParsingInvalidFrequency_LogrotateRule(t *testing.T) {
+	var checkedLogrotateRule LogrotateRule
+	err := marshalJSONString(invalidLogrotateRuleJSON, &checkedLogrotateRule)
+	assert.Error(t, err)
+	assert.Equal(t, "failed to parse [LogrotateRule]: invalid [Frequency] (fortnightly) for (contoso-agent), must be one of 'hourly', 'daily', 'weekly', 'monthly' or 'yearly'", err.Error())
+}
+
+func TestShouldFailParsingRelativePath_LogrotateRule(t *testing.T) {
+	invalidLogrotateRule := validLogrotateRule
+	invalidLogrotateRule.Paths = []string{"contoso/*.log"}
+
+	err := invalidLogrotateRule.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [Paths] entry (contoso/*.log) for (contoso-agent), must be an absolute path or glob pattern without spaces, quotes or braces", err.Error())
+}
+
+func TestShouldFailParsingInvalidMaxSize_LogrotateRule(t *testing.T) {
+	invalidLogrotateRule := validLogrotateRule
+	invalidLogrotateRule.MaxSize = "100MB"
+
+	err := invalidLogrotateRule.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [MaxSize] (100MB) for (contoso-agent), must be a size in bytes with an optional k, M or G suffix", err.Error())
+}
+
+func TestShouldFailParsingScriptOption_LogrotateRule(t *testing.T) {
+	invalidLogrotateRule := validLogrotateRule
+	invalidLogrotateRule.Options = []string{"postrotate"}
+
+	err := invalidLogrotateRule.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "unknown logrotate directive (postrotate) in [Options] for (contoso-agent)", err.Error())
+}
@@ -0,0 +1,199 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Parser for the image builder's configuration schemas.
+
+package configuration
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"microsoft.com/pkggen/internal/logger"
+)
+
+// mergeIdentityKeys are the keys which identify the objects of a list, in order of preference.
+// Lists of objects sharing one of them are merged element by element.
+var mergeIdentityKeys = []string{"ID", "Name"}
+
+// MergeConfigFiles deep merges config JSON files in order, each one overriding the ones before it,
+// and returns the merged JSON. The rules are:
+//   - Objects are merged key by key.
+//   - A null value removes the key.
+//   - Lists of objects which all have an "ID", or all have a "Name", are merged by it. Objects
+//     not found in the earlier files are appended.
+//   - Lists of strings, numbers or booleans are appended to, skipping values already present.
+//   - Any other value, including any other list, replaces the earlier one.
+//
+// The variables each file references are substituted before it is merged, from the map or else from
+// the environment.
+func MergeConfigFiles(configFilePaths []string, variables map[string]string) (merged []byte, err error) {
+	if len(configFilePaths) == 0 {
+		return nil, fmt.Errorf("no config files to merge")
+	}
+
+	var mergedValue interface{}
+	for _, configFilePath := range configFilePaths {
+		logger.Log.Debugf("Merging config file '%s'.", configFilePath)
+
+		data, readErr := readConfigFile(configFilePath, variables)
+		if readErr != nil {
+			return nil, readErr
+		}
+
+		// Keep numbers as they are written, large sizes and offsets don't fit a float64
+		var layer interface{}
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		err = decoder.Decode(&layer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse config file (%s): %w", configFilePath, err)
+		}
+		if _, isObject := layer.(map[string]interface{}); !isObject {
+			return nil, fmt.Errorf("config file (%s) is not a JSON object", configFilePath)
+		}
+
+		if mergedValue == nil {
+			mergedValue = layer
+		} else {
+			mergedValue = mergeConfigValues(mergedValue, layer)
+		}
+	}
+
+	return json.MarshalIndent(mergedValue, "", "  ")
+}
+
+// LoadLayered merges the config files in order with MergeConfigFiles and loads the result like Load.
+// The merged JSON is returned alongside the config, so it can be inspected.
+func LoadLayered(configFilePaths []string, variables map[string]string) (config Config, merged []byte, err error) {
+	merged, err = MergeConfigFiles(configFilePaths, variables)
+	if err != nil {
+		return
+	}
+	logger.Log.Debugf("Effective config:\n%s", merged)
+
+	config, err = parseConfig(merged)
+
+	return
+}
+
+// LoadLayeredWithAbsolutePaths loads the config files like LoadLayered and resolves all relative paths
+// like LoadWithAbsolutePaths, against 'baseDirPath' or the directory of the first config file.
+func LoadLayeredWithAbsolutePaths(configFilePaths []string, baseDirPath string, variables map[string]string) (config Config, merged []byte, err error) {
+	config, merged, err = LoadLayered(configFilePaths, variables)
+	if err != nil {
+		return
+	}
+
+	err = config.resolveAbsolutePaths(baseDirPath, configFilePaths[0])
+
+	return
+}
+
+// mergeConfigValues merges a generic JSON value of an overlay config into the value of the configs before it
+func mergeConfigValues(base, overlay interface{}) interface{} {
+	switch typedOverlay := overlay.(type) {
+	case map[string]interface{}:
+		typedBase, ok := base.(map[string]interface{})
+		if !ok {
+			return overlay
+		}
+		for key, value := range typedOverlay {
+			if value == nil {
+				delete(typedBase, key)
+				continue
+			}
+			if baseValue, exists := typedBase[key]; exists {
+				typedBase[key] = mergeConfigValues(baseValue, value)
+			} else {
+				typedBase[key] = value
+			}
+		}
+		return typedBase
+	case []interface{}:
+		typedBase, ok := base.([]interface{})
+		if !ok {
+			return overlay
+		}
+		if identityKey := listIdentityKey(typedBase, typedOverlay); identityKey != "" {
+			return mergeIdentifiedLists(typedBase, typedOverlay, identityKey)
+		}
+		if isScalarList(typedBase) && isScalarList(typedOverlay) {
+			return appendScalarList(typedBase, typedOverlay)
+		}
+		return overlay
+	default:
+		return overlay
+	}
+}
+
+// listIdentityKey returns the first of mergeIdentityKeys every object of both lists has as a string,
+// or an empty string if there is none
+func listIdentityKey(base, overlay []interface{}) string {
+	for _, key := range mergeIdentityKeys {
+		identified := true
+		for _, element := range append(append([]interface{}{}, base...), overlay...) {
+			object, isObject := element.(map[string]interface{})
+			if !isObject {
+				return ""
+			}
+			if _, isString := object[key].(string); !isString {
+				identified = false
+				break
+			}
+		}
+		if identified {
+			return key
+		}
+	}
+	return ""
+}
+
+// mergeIdentifiedLists merges the objects of overlay into the objects of base with the same identity
+// and appends the rest
+func mergeIdentifiedLists(base, overlay []interface{}, identityKey string) []interface{} {
+	indexes := make(map[string]int, len(base))
+	for i, element := range base {
+		indexes[element.(map[string]interface{})[identityKey].(string)] = i
+	}
+
+	for _, element := range overlay {
+		identity := element.(map[string]interface{})[identityKey].(string)
+		if i, exists := indexes[identity]; exists {
+			base[i] = mergeConfigValues(base[i], element)
+		} else {
+			indexes[identity] = len(base)
+			base = append(base, element)
+		}
+	}
+	return base
+}
+
+// isScalarList returns true if a list only holds strings, numbers and booleans
+func isScalarList(list []interface{}) bool {
+	for _, element := range list {
+		switch element.(type) {
+		case string, json.Number, bool:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// appendScalarList appends the values of overlay which base doesn't hold yet
+func appendScalarList(base, overlay []interface{}) []interface{} {
+	present := make(map[interface{}]bool, len(base))
+	for _, element := range base {
+		present[element] = true
+	}
+
+	for _, element := range overlay {
+		if !present[element] {
+			present[element] = true
+			base = append(base, element)
+		}
+	}
+	return base
+}
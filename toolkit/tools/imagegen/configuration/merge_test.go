@@ -0,0 +1,115 @@
+// Copyright Microsoft Corporation.
+// Licensed under the MIT License.
+
+package configuration
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//TestMain found in configuration_test.go.
+
+func writeMergeLayers(t *testing.T, layers ...string) (paths []string) {
+	dir := t.TempDir()
+	for i, layer := range layers {
+		path := filepath.Join(dir, fmt.Sprintf("layer%d.json", i))
+		err := os.WriteFile(path, []byte(layer), 0644)
+		assert.NoError(t, err)
+		paths = append(paths, path)
+	}
+	return
+}
+
+func TestShouldMergeConfigFilesInOrder_Merge(t *testing.T) {
+	paths := writeMergeLayers(t,
+		`{"SystemConfigs": [{"Name": "Standard", "Hostname": "base", "PackageLists": ["core.json"], "KernelCommandLine": {"ExtraCommandLine": "quiet"}}]}`,
+		`{"SystemConfigs": [{"Name": "Standard", "Hostname": "prod", "PackageLists": ["core.json", "prod.json"]}, {"Name": "Extra", "PackageLists": ["extra.json"]}]}`,
+		`{"SystemConfigs": [{"Name": "Standard", "KernelCommandLine": null}]}`,
+	)
+
+	merged, err := MergeConfigFiles(paths, nil)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"SystemConfigs": [
+		{"Name": "Standard", "Hostname": "prod", "PackageLists": ["core.json", "prod.json"]},
+		{"Name": "Extra", "PackageLists": ["extra.json"]}
+	]}`, string(merged))
+}
+
+func TestShouldMergeListsByID_Merge(t *testing.T) {
+	base := []interface{}{
+		map[string]interface{}{"ID": "boot", "End": json.Number("9")},
+		map[string]interface{}{"ID": "rootfs", "End": json.Number("0")},
+	}
+	overlay := []interface{}{
+		map[string]interface{}{"ID": "boot", "End": json.Number("20")},
+	}
+
+	merged := mergeConfigValues(base, overlay)
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"ID": "boot", "End": json.Number("20")},
+		map[string]interface{}{"ID": "rootfs", "End": json.Number("0")},
+	}, merged)
+}
+
+func TestShouldReplaceUnidentifiedLists_Merge(t *testing.T) {
+	base := []interface{}{map[string]interface{}{"Path": "a.sh"}}
+	overlay := []interface{}{map[string]interface{}{"Path": "b.sh"}}
+
+	assert.Equal(t, overlay, mergeConfigValues(base, overlay))
+}
+
+func TestShouldReplaceMismatchedTypes_Merge(t *testing.T) {
+	assert.Equal(t, "value", mergeConfigValues(map[string]interface{}{"a": "b"}, "value"))
+	assert.Equal(t, []interface{}{"a"}, mergeConfigValues(map[string]interface{}{"a": "b"}, []interface{}{"a"}))
+}
+
+func TestShouldFailMergingNoConfigFiles_Merge(t *testing.T) {
+	_, err := MergeConfigFiles(nil, nil)
+	assert.Error(t, err)
+	assert.Equal(t, "no config files to merge", err.Error())
+}
+
+func TestShouldFailMergingNonObjectConfigFile_Merge(t *testing.T) {
+	paths := writeMergeLayers(t, `{"SystemConfigs": []}`, `["not", "an", "object"]`)
+
+	_, err := MergeConfigFiles(paths, nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "is not a JSON object")
+}
+
+func TestShouldLoadLayeredConfig_Merge(t *testing.T) {
+	paths := writeMergeLayers(t,
+		`{"Disks": [{"MaxSize": 1024, "PartitionTableType": "gpt", "Partitions": [{"ID": "rootfs", "Start": 1, "End": 0, "FsType": "ext4"}]}],
+		  "SystemConfigs": [{"Name": "Standard", "PackageLists": ["core.json"], "KernelOptions": {"default": "kernel"}, "PartitionSettings": [{"ID": "rootfs", "MountPoint": "/"}]}]}`,
+		`{"SystemConfigs": [{"Name": "Standard", "Hostname": "overlay", "PackageLists": ["extra.json"]}]}`,
+	)
+
+	config, merged, err := LoadLayeredWithAbsolutePaths(paths, "", nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NotEmpty(t, merged)
+	assert.Equal(t, "overlay", config.DefaultSystemConfig.Hostname)
+	assert.Equal(t, []string{
+		filepath.Join(filepath.Dir(paths[0]), "core.json"),
+		filepath.Join(filepath.Dir(paths[0]), "extra.json"),
+	}, config.SystemConfigs[0].PackageLists)
+}
+
+func TestShouldKeepLargeNumbers_Merge(t *testing.T) {
+	paths := writeMergeLayers(t,
+		`{"Disks": [{"ID": "disk", "RawBinaries": [{"BinPath": "a.bin", "Seek": 18446744073709551615}]}]}`,
+		`{"Disks": [{"ID": "disk", "MaxSize": 4096}]}`,
+	)
+
+	merged, err := MergeConfigFiles(paths, nil)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"Disks": [{"ID": "disk", "MaxSize": 4096, "RawBinaries": [{"BinPath": "a.bin", "Seek": 18446744073709551615}]}]}`, string(merged))
+	assert.Contains(t, string(merged), "18446744073709551615")
+}
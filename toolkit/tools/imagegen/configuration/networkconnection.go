@@ -0,0 +1,214 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Parser for the image builder's configuration schemas.
+
+package configuration
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"regexp"
+)
+
+const (
+	// NetworkConnectionTypeEthernet configures a physical interface
+	NetworkConnectionTypeEthernet = "ethernet"
+	// NetworkConnectionTypeVlan configures a VLAN on top of another interface
+	NetworkConnectionTypeVlan = "vlan"
+	// NetworkConnectionTypeBond configures a bond of several interfaces
+	NetworkConnectionTypeBond = "bond"
+
+	minVlanID = 1
+	maxVlanID = 4094
+)
+
+var (
+	// networkInterfaceNameRegex matches Linux interface names, which are at most 15 characters long
+	networkInterfaceNameRegex = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,15}$`)
+	// bondModes are the bonding modes both systemd-networkd and NetworkManager accept
+	bondModes = map[string]bool{
+		"balance-rr": true, "active-backup": true, "balance-xor": true, "broadcast": true,
+		"802.3ad": true, "balance-tlb": true, "balance-alb": true,
+	}
+)
+
+// NetworkConnection configures a network interface with systemd-networkd or NetworkManager, whichever is installed.
+//   - Name: The interface name, such as "eth0", "eth0.100" or "bond0"
+//   - Type: "ethernet" (the default), "vlan" or "bond"
+//   - MACAddress: [ethernet only] Match the interface by MAC address instead of by name
+//   - Dhcp: Get IPv4 and IPv6 addresses with DHCP and router advertisements
+//   - Addresses: Static addresses in CIDR notation, such as "192.168.1.10/24"
+//   - Gateway: The default gateway of the static addresses
+//   - Dns: DNS servers
+//   - VlanID and Parent: [vlan only] The VLAN ID and the interface the VLAN is on
+//   - BondMode and Members: [bond only] The bonding mode, such as "active-backup", and the interfaces in the bond
+type NetworkConnection struct {
+	Name       string   `json:"Name"`
+	Type       string   `json:"Type"`
+	MACAddress string   `json:"MACAddress"`
+	Dhcp       bool     `json:"Dhcp"`
+	Addresses  []string `json:"Addresses"`
+	Gateway    string   `json:"Gateway"`
+	Dns        []string `json:"Dns"`
+	VlanID     int      `json:"VlanID"`
+	Parent     string   `json:"Parent"`
+	BondMode   string   `json:"BondMode"`
+	Members    []string `json:"Members"`
+}
+
+// GetType returns the connection type, defaulting to ethernet
+func (n *NetworkConnection) GetType() string {
+	if n.Type == "" {
+		return NetworkConnectionTypeEthernet
+	}
+	return n.Type
+}
+
+// IsValid returns an error if the NetworkConnection is not valid
+func (n *NetworkConnection) IsValid() (err error) {
+	if !networkInterfaceNameRegex.MatchString(n.Name) {
+		return fmt.Errorf("invalid [Name] (%s), must be an interface name of at most 15 letters, digits, '_', '.' or '-'", n.Name)
+	}
+
+	connectionType := n.GetType()
+	switch connectionType {
+	case NetworkConnectionTypeEthernet, NetworkConnectionTypeVlan, NetworkConnectionTypeBond:
+	default:
+		return fmt.Errorf("invalid [Type] (%s) of (%s), must be '%s', '%s' or '%s'", n.Type, n.Name, NetworkConnectionTypeEthernet, NetworkConnectionTypeVlan, NetworkConnectionTypeBond)
+	}
+
+	if !n.Dhcp && len(n.Addresses) == 0 {
+		return fmt.Errorf("(%s) must enable [Dhcp] or set [Addresses]", n.Name)
+	}
+
+	hasIPv4, hasIPv6 := false, false
+	for _, address := range n.Addresses {
+		ip, _, parseErr := net.ParseCIDR(address)
+		if parseErr != nil {
+			return fmt.Errorf("invalid [Addresses] entry (%s) of (%s), must be an address in CIDR notation such as '192.168.1.10/24'", address, n.Name)
+		}
+		if ip.To4() != nil {
+			hasIPv4 = true
+		} else {
+			hasIPv6 = true
+		}
+	}
+
+	if n.Gateway != "" {
+		gateway := net.ParseIP(n.Gateway)
+		if gateway == nil {
+			return fmt.Errorf("invalid [Gateway] (%s) of (%s), must be an IP address", n.Gateway, n.Name)
+		}
+		isIPv4 := gateway.To4() != nil
+		if (isIPv4 && !hasIPv4) || (!isIPv4 && !hasIPv6) {
+			return fmt.Errorf("invalid [Gateway] (%s) of (%s), requires a static address of the same IP version in [Addresses]", n.Gateway, n.Name)
+		}
+	}
+
+	for _, server := range n.Dns {
+		if net.ParseIP(server) == nil {
+			return fmt.Errorf("invalid [Dns] entry (%s) of (%s), must be an IP address", server, n.Name)
+		}
+	}
+
+	if n.MACAddress != "" {
+		if connectionType != NetworkConnectionTypeEthernet {
+			return fmt.Errorf("[MACAddress] of (%s) may only be set on '%s' connections", n.Name, NetworkConnectionTypeEthernet)
+		}
+		if _, parseErr := net.ParseMAC(n.MACAddress); parseErr != nil {
+			return fmt.Errorf("invalid [MACAddress] (%s) of (%s)", n.MACAddress, n.Name)
+		}
+	}
+
+	if connectionType == NetworkConnectionTypeVlan {
+		if n.VlanID < minVlanID || n.VlanID > maxVlanID {
+			return fmt.Errorf("invalid [VlanID] (%d) of (%s), must be in the range %d-%d", n.VlanID, n.Name, minVlanID, maxVlanID)
+		}
+		if !networkInterfaceNameRegex.MatchString(n.Parent) {
+			return fmt.Errorf("invalid [Parent] (%s) of (%s), must be an interface name", n.Parent, n.Name)
+		}
+		if n.Parent == n.Name {
+			return fmt.Errorf("(%s) can't be its own [Parent]", n.Name)
+		}
+	} else if n.VlanID != 0 || n.Parent != "" {
+		return fmt.Errorf("[VlanID] and [Parent] of (%s) may only be set on '%s' connections", n.Name, NetworkConnectionTypeVlan)
+	}
+
+	if connectionType == NetworkConnectionTypeBond {
+		if !bondModes[n.BondMode] {
+			return fmt.Errorf("invalid [BondMode] (%s) of (%s), must be a bonding mode such as 'active-backup' or '802.3ad'", n.BondMode, n.Name)
+		}
+		if len(n.Members) == 0 {
+			return fmt.Errorf("bond (%s) must list its [Members]", n.Name)
+		}
+		members := make(map[string]bool)
+		for _, member := range n.Members {
+			if !networkInterfaceNameRegex.MatchString(member) || member == n.Name {
+				return fmt.Errorf("invalid [Members] entry (%s) of (%s), must be the name of another interface", member, n.Name)
+			}
+			if members[member] {
+				return fmt.Errorf("[Members] entry (%s) of (%s) is listed more than once", member, n.Name)
+			}
+			members[member] = true
+		}
+	} else if n.BondMode != "" || len(n.Members) != 0 {
+		return fmt.Errorf("[BondMode] and [Members] of (%s) may only be set on '%s' connections", n.Name, NetworkConnectionTypeBond)
+	}
+
+	return
+}
+
+// networkConnectionsAreValid checks the connections against each other: names must be unique,
+// and an interface can't both be in a bond and be configured on its own.
+func networkConnectionsAreValid(connections []NetworkConnection) (err error) {
+	names := make(map[string]bool)
+	for _, connection := range connections {
+		if err = connection.IsValid(); err != nil {
+			return
+		}
+		if names[connection.Name] {
+			return fmt.Errorf("(%s) is listed more than once", connection.Name)
+		}
+		names[connection.Name] = true
+	}
+
+	bondOf := make(map[string]string)
+	for _, connection := range connections {
+		for _, member := range connection.Members {
+			if bond, exists := bondOf[member]; exists {
+				return fmt.Errorf("(%s) is a member of both bond (%s) and bond (%s)", member, bond, connection.Name)
+			}
+			if names[member] {
+				return fmt.Errorf("(%s) is a member of bond (%s) and can't be configured on its own", member, connection.Name)
+			}
+			bondOf[member] = connection.Name
+		}
+	}
+
+	for _, connection := range connections {
+		if bond, exists := bondOf[connection.Parent]; exists {
+			return fmt.Errorf("VLAN (%s) is on (%s), which is a member of bond (%s), use the bond as the [Parent]", connection.Name, connection.Parent, bond)
+		}
+	}
+
+	return
+}
+
+// UnmarshalJSON Unmarshals a NetworkConnection entry
+func (n *NetworkConnection) UnmarshalJSON(b []byte) (err error) {
+	// Use an intermediate type which will use the default JSON unmarshal implementation
+	type IntermediateTypeNetworkConnection NetworkConnection
+	err = json.Unmarshal(b, (*IntermediateTypeNetworkConnection)(n))
+	if err != nil {
+		return fmt.Errorf("failed to parse [NetworkConnection]: %w", err)
+	}
+
+	// Now validate the resulting unmarshaled object
+	err = n.IsValid()
+	if err != nil {
+		return fmt.Errorf("failed to parse [NetworkConnection]: %w", err)
+	}
+	return
+}
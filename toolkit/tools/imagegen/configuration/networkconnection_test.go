@@ -0,0 +1,124 @@
+// Copyright Microsoft Corporation.
+// Licensed under the MIT License.
+
+package configuration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//TestMain found in configuration_test.go.
+
+var (
+	validNetworkConnection NetworkConnection = NetworkConnection{
+		Name:      "eth0",
+		Addresses: []string{"192.168.1.10/24", "fd00::10/64"},
+		Gateway:   "192.168.1.1",
+		Dns:       []string{"192.168.1.1", "fd00::1"},
+	}
+	invalidNetworkConnectionJSON = `{"Name": "eth0", "Addresses": ["192.168.1.10"]}`
+)
+
+func TestShouldSucceedParsingValidNetworkConnection_NetworkConnection(t *testing.T) {
+	var checkedNetworkConnection NetworkConnection
+	err := remarshalJSON(validNetworkConnection, &checkedNetworkConnection)
+	assert.NoError(t, err)
+	assert.Equal(t, validNetworkConnection, checkedNetworkConnection)
+	assert.Equal(t, NetworkConnectionTypeEthernet, checkedNetworkConnection.GetType())
+}
+
+func TestShouldFailParsingAddressWithoutPrefix_NetworkConnection(t *testing.T) {
+	var checkedNetworkConnection NetworkConnection
+	err := marshalJSONString(invalidNetworkConnectionJSON, &checkedNetworkConnection)
+	assert.Error(t, err)
+	assert.Equal(t, "failed to parse [NetworkConnection]: invalid [Addresses] entry (192.168.1.10) of (eth0), must be an address in CIDR notation such as '192.168.1.10/24'", err.Error())
+}
+
+func TestShouldFailParsingNoAddressing_NetworkConnection(t *testing.T) {
+	invalidNetworkConnection := NetworkConnection{Name: "eth0"}
+
+	err := invalidNetworkConnection.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "(eth0) must enable [Dhcp] or set [Addresses]", err.Error())
+}
+
+func TestShouldFailParsingGatewayOfOtherFamily_NetworkConnection(t *testing.T) {
+	invalidNetworkConnection := validNetworkConnection
+	invalidNetworkConnection.Addresses = []string{"fd00::10/64"}
+
+	err := invalidNetworkConnection.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [Gateway] (192.168.1.1) of (eth0), requires a static address of the same IP version in [Addresses]", err.Error())
+}
+
+func TestShouldFailParsingInvalidDns_NetworkConnection(t *testing.T) {
+	invalidNetworkConnection := validNetworkConnection
+	invalidNetworkConnection.Dns = []string{"dns.contoso.com"}
+
+	err := invalidNetworkConnection.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [Dns] entry (dns.contoso.com) of (eth0), must be an IP address", err.Error())
+}
+
+func TestShouldFailParsingLongName_NetworkConnection(t *testing.T) {
+	invalidNetworkConnection := validNetworkConnection
+	invalidNetworkConnection.Name = "averyveryverylongname"
+
+	err := invalidNetworkConnection.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [Name] (averyveryverylongname), must be an interface name of at most 15 letters, digits, '_', '.' or '-'", err.Error())
+}
+
+func TestShouldSucceedParsingVlanAndBond_NetworkConnection(t *testing.T) {
+	connections := []NetworkConnection{
+		{Name: "bond0", Type: NetworkConnectionTypeBond, Dhcp: true, BondMode: "active-backup", Members: []string{"eth0", "eth1"}},
+		{Name: "bond0.100", Type: NetworkConnectionTypeVlan, Addresses: []string{"10.0.100.2/24"}, VlanID: 100, Parent: "bond0"},
+	}
+
+	err := networkConnectionsAreValid(connections)
+	assert.NoError(t, err)
+}
+
+func TestShouldFailParsingVlanIDOutOfRange_NetworkConnection(t *testing.T) {
+	invalidNetworkConnection := NetworkConnection{Name: "eth0.5000", Type: NetworkConnectionTypeVlan, Dhcp: true, VlanID: 5000, Parent: "eth0"}
+
+	err := invalidNetworkConnection.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [VlanID] (5000) of (eth0.5000), must be in the range 1-4094", err.Error())
+}
+
+func TestShouldFailParsingVlanFieldsOnEthernet_NetworkConnection(t *testing.T) {
+	invalidNetworkConnection := validNetworkConnection
+	invalidNetworkConnection.VlanID = 100
+
+	err := invalidNetworkConnection.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "[VlanID] and [Parent] of (eth0) may only be set on 'vlan' connections", err.Error())
+}
+
+func TestShouldFailParsingInvalidBondMode_NetworkConnection(t *testing.T) {
+	invalidNetworkConnection := NetworkConnection{Name: "bond0", Type: NetworkConnectionTypeBond, Dhcp: true, BondMode: "lacp", Members: []string{"eth0"}}
+
+	err := invalidNetworkConnection.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [BondMode] (lacp) of (bond0), must be a bonding mode such as 'active-backup' or '802.3ad'", err.Error())
+}
+
+func TestShouldFailParsingDuplicateNames_NetworkConnection(t *testing.T) {
+	err := networkConnectionsAreValid([]NetworkConnection{validNetworkConnection, validNetworkConnection})
+	assert.Error(t, err)
+	assert.Equal(t, "(eth0) is listed more than once", err.Error())
+}
+
+func TestShouldFailParsingConfiguredBondMember_NetworkConnection(t *testing.T) {
+	connections := []NetworkConnection{
+		validNetworkConnection,
+		{Name: "bond0", Type: NetworkConnectionTypeBond, Dhcp: true, BondMode: "802.3ad", Members: []string{"eth0", "eth1"}},
+	}
+
+	err := networkConnectionsAreValid(connections)
+	assert.Error(t, err)
+	assert.Equal(t, "(eth0) is a member of bond (bond0) and can't be configured on its own", err.Error())
+}
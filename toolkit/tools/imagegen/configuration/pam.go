@@ -0,0 +1,103 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Parser for the image builder's configuration schemas.
+
+package configuration
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// pwqualityIntegerOptions are the pwquality.conf options which take an integer value
+var pwqualityIntegerOptions = map[string]bool{
+	"difok":          true,
+	"minlen":         true,
+	"dcredit":        true,
+	"ucredit":        true,
+	"lcredit":        true,
+	"ocredit":        true,
+	"minclass":       true,
+	"maxrepeat":      true,
+	"maxsequence":    true,
+	"maxclassrepeat": true,
+	"gecoscheck":     true,
+	"dictcheck":      true,
+	"usercheck":      true,
+	"usersubstr":     true,
+	"enforcing":      true,
+	"retry":          true,
+}
+
+// Pam holds the PAM hardening settings applied to /etc/pam.d and /etc/security.
+//   - PasswordQuality: pwquality.conf options checked when a password is changed, such as "minlen": "14"
+//   - Faillock: Locks accounts after repeated failed logins
+type Pam struct {
+	PasswordQuality map[string]string `json:"PasswordQuality"`
+	Faillock        PamFaillock       `json:"Faillock"`
+}
+
+// PamFaillock holds the pam_faillock settings. root is never locked out.
+//   - Deny: Number of failed logins after which an account is locked, 0 disables faillock
+//   - UnlockTime: Seconds after which a locked account is unlocked, 0 keeps faillock's default
+//   - FailInterval: Seconds within which the failed logins must occur, 0 keeps faillock's default
+type PamFaillock struct {
+	Deny         uint `json:"Deny"`
+	UnlockTime   uint `json:"UnlockTime"`
+	FailInterval uint `json:"FailInterval"`
+}
+
+// IsEmpty returns true if no PAM setting is set
+func (p *Pam) IsEmpty() bool {
+	return len(p.PasswordQuality) == 0 && !p.Faillock.IsEnabled()
+}
+
+// IsEnabled returns true if accounts are locked after failed logins
+func (f *PamFaillock) IsEnabled() bool {
+	return f.Deny != 0
+}
+
+// IsValid returns an error if the Pam is not valid
+func (p *Pam) IsValid() (err error) {
+	options := make([]string, 0, len(p.PasswordQuality))
+	for option := range p.PasswordQuality {
+		options = append(options, option)
+	}
+	sort.Strings(options)
+
+	for _, option := range options {
+		if !pwqualityIntegerOptions[option] {
+			return fmt.Errorf("invalid [PasswordQuality] option (%s), must be one of the integer options of pwquality.conf", option)
+		}
+		value := p.PasswordQuality[option]
+		if _, convertErr := strconv.Atoi(value); convertErr != nil {
+			return fmt.Errorf("invalid [PasswordQuality] value (%s) for (%s), must be an integer", value, option)
+		}
+	}
+
+	if !p.Faillock.IsEnabled() && (p.Faillock.UnlockTime != 0 || p.Faillock.FailInterval != 0) {
+		return fmt.Errorf("invalid [Faillock]: [UnlockTime] and [FailInterval] require [Deny]")
+	}
+
+	return
+}
+
+// UnmarshalJSON Unmarshals a Pam entry
+func (p *Pam) UnmarshalJSON(b []byte) (err error) {
+	// Use an intermediate type which will use the default JSON unmarshal implementation
+	type IntermediateTypePam Pam
+	err = json.Unmarshal(b, (*IntermediateTypePam)(p))
+	if err != nil {
+		return fmt.Errorf("failed to parse [Pam]: %w", err)
+	}
+
+	// Now validate the resulting unmarshaled object
+	err = p.IsValid()
+	if err != nil {
+		return fmt.Errorf("failed to parse [Pam]: %w", err)
+	}
+	return
+}
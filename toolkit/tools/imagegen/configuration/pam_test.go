@@ -0,0 +1,65 @@
+// Copyright Microsoft Corporation.
+// Licensed under the MIT License.
+
+package configuration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//TestMain found in configuration_test.go.
+
+var (
+	validPam Pam = Pam{
+		PasswordQuality: map[string]string{
+			"minlen":   "14",
+			"minclass": "3",
+		},
+		Faillock: PamFaillock{
+			Deny:       5,
+			UnlockTime: 900,
+		},
+	}
+	invalidPamJSON = `{"PasswordQuality": {"minlen": "long"}}`
+)
+
+func TestShouldSucceedParsingDefaultPam_Pam(t *testing.T) {
+	var checkedPam Pam
+	err := marshalJSONString("{}", &checkedPam)
+	assert.NoError(t, err)
+	assert.True(t, checkedPam.IsEmpty())
+}
+
+func TestShouldSucceedParsingValidPam_Pam(t *testing.T) {
+	var checkedPam Pam
+	err := remarshalJSON(validPam, &checkedPam)
+	assert.NoError(t, err)
+	assert.Equal(t, validPam, checkedPam)
+	assert.False(t, checkedPam.IsEmpty())
+	assert.True(t, checkedPam.Faillock.IsEnabled())
+}
+
+func TestShouldFailParsingInvalidValue_Pam(t *testing.T) {
+	var checkedPam Pam
+	err := marshalJSONString(invalidPamJSON, &checkedPam)
+	assert.Error(t, err)
+	assert.Equal(t, "failed to parse [Pam]: invalid [PasswordQuality] value (long) for (minlen), must be an integer", err.Error())
+}
+
+func TestShouldFailParsingUnknownOption_Pam(t *testing.T) {
+	invalidPam := Pam{PasswordQuality: map[string]string{"dictpath": "/usr/share/cracklib/pw_dict"}}
+
+	err := invalidPam.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [PasswordQuality] option (dictpath), must be one of the integer options of pwquality.conf", err.Error())
+}
+
+func TestShouldFailParsingUnlockTimeWithoutDeny_Pam(t *testing.T) {
+	invalidPam := Pam{Faillock: PamFaillock{UnlockTime: 600}}
+
+	err := invalidPam.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [Faillock]: [UnlockTime] and [FailInterval] require [Deny]", err.Error())
+}
@@ -8,6 +8,49 @@ package configuration
 import (
 	"encoding/json"
 	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// PartitionSizeGrow makes a partition consume all the disk space left over by the other partitions
+	PartitionSizeGrow = "grow"
+
+	// maxReservedBlocksPercent is the largest share of an ext2/3/4 file system which may be reserved for root
+	maxReservedBlocksPercent = 50
+)
+
+var (
+	// A relative partition size is given as a percentage of the disk's "MaxSize", ie "25%"
+	partitionSizePercentRegex = regexp.MustCompile(`^(\d+)%$`)
+
+	// extFsFeatures are the ext2/3/4 features mke2fs accepts through "-O"
+	extFsFeatures = map[string]bool{
+		"64bit": true, "bigalloc": true, "casefold": true, "dir_index": true, "dir_nlink": true,
+		"ea_inode": true, "encrypt": true, "ext_attr": true, "extent": true, "extents": true,
+		"extra_isize": true, "filetype": true, "flex_bg": true, "has_journal": true, "huge_file": true,
+		"inline_data": true, "large_dir": true, "large_file": true, "meta_bg": true, "metadata_csum": true,
+		"metadata_csum_seed": true, "mmp": true, "orphan_file": true, "project": true, "quota": true,
+		"resize_inode": true, "sparse_super": true, "sparse_super2": true, "stable_inodes": true,
+		"uninit_bg": true, "verity": true,
+	}
+
+	// dangerousMkfsArgs are the mkfs options which may not be passed through [ExtraMkfsArgs], by file system.
+	// They change the file system type, skip mkfs's safety checks or don't create a usable file system.
+	dangerousMkfsArgs = map[string]map[string]string{
+		"ext": {
+			"-t": "the file system type is set by [FsType]",
+			"-F": "it skips mke2fs's safety checks",
+			"-n": "it doesn't create a file system",
+			"-S": "it only writes the superblock and group descriptors",
+		},
+		"vfat": {
+			"-t": "the file system type is set by [FsType]",
+			"-C": "it creates a new file instead of formatting the partition",
+			"-I": "it skips mkfs.fat's safety checks",
+		},
+	}
 )
 
 // Partition defines the size, name and file system type
@@ -16,14 +59,38 @@ import (
 // An "End" value of 0 will determine the size of the partition using the next
 // partition's start offset or the value defined by "MaxSize", if this is the last
 // partition on the disk.
+// "Size" may be used instead of "End" to give the size relative to the disk: either
+// a percentage of "MaxSize" ("25%") or "grow" to consume the remaining space.
+// "FsSize" optionally resizes an ext2/3/4 file system to the given size in MBs once the image
+// is built, leaving the rest of the partition unused.
+// "FsPadding" optionally shrinks an ext2/3/4 file system to its minimum size plus the given
+// number of MBs once the image is built, leaving the rest of the partition unused.
+// "FsFeatures" turns ext2/3/4 features on or off when the partition is formatted, a feature
+// prefixed with "^" is turned off, e.g. "^64bit".
+// "ReservedBlocksPercent" sets the share of an ext2/3/4 file system reserved for root, instead
+// of the 5% mke2fs reserves by default. It is a pointer so an explicit 0 can be told apart from unset.
+// "ExtraMkfsArgs" are appended to the mkfs command which formats the partition, for tuning
+// the file system beyond the other settings.
+// "SourceImage" is an optional path to a raw partition image which is copied verbatim into
+// the partition instead of formatting it. It may not be mounted, so the build does not write into it.
+// "RegenerateUUID" gives the file system copied from "SourceImage" a new random UUID, so
+// images built from the same source image do not share it.
 type Partition struct {
-	FsType    string          `json:"FsType"`
-	ID        string          `json:"ID"`
-	Name      string          `json:"Name"`
-	End       uint64          `json:"End"`
-	Start     uint64          `json:"Start"`
-	Flags     []PartitionFlag `json:"Flags"`
-	Artifacts []Artifact      `json:"Artifacts"`
+	FsType                string          `json:"FsType"`
+	FsSize                uint64          `json:"FsSize"`
+	FsPadding             uint64          `json:"FsPadding"`
+	FsFeatures            []string        `json:"FsFeatures"`
+	ReservedBlocksPercent *uint64         `json:"ReservedBlocksPercent"`
+	ExtraMkfsArgs         []string        `json:"ExtraMkfsArgs"`
+	ID                    string          `json:"ID"`
+	Name                  string          `json:"Name"`
+	End                   uint64          `json:"End"`
+	Start                 uint64          `json:"Start"`
+	Size                  string          `json:"Size"`
+	SourceImage           string          `json:"SourceImage"`
+	RegenerateUUID        bool            `json:"RegenerateUUID"`
+	Flags                 []PartitionFlag `json:"Flags"`
+	Artifacts             []Artifact      `json:"Artifacts"`
 }
 
 // HasFlag returns true if a given partition has a specific flag set.
@@ -36,6 +103,27 @@ func (p *Partition) HasFlag(flag PartitionFlag) bool {
 	return false
 }
 
+// HasRelativeSize returns true if the partition's size is given relative to the disk's size.
+func (p *Partition) HasRelativeSize() bool {
+	return p.Size != ""
+}
+
+// IsGrow returns true if the partition should consume the remaining space on the disk.
+func (p *Partition) IsGrow() bool {
+	return p.Size == PartitionSizeGrow
+}
+
+// SizePercent returns the percentage of the disk requested by the partition, 0 if the
+// partition's size is not given as a percentage.
+func (p *Partition) SizePercent() (percent uint64, err error) {
+	matches := partitionSizePercentRegex.FindStringSubmatch(p.Size)
+	if len(matches) == 0 {
+		return
+	}
+
+	return strconv.ParseUint(matches[1], 10, 64)
+}
+
 // IsValid returns an error if the Partition is not valid
 func (p *Partition) IsValid() (err error) {
 	for _, f := range p.Flags {
@@ -43,9 +131,222 @@ func (p *Partition) IsValid() (err error) {
 			return
 		}
 	}
+
+	if p.HasRelativeSize() && !p.IsGrow() {
+		if !partitionSizePercentRegex.MatchString(p.Size) {
+			return fmt.Errorf("invalid value for Size (%s), must be either '%s' or a percentage of the disk (1%%-100%%)", p.Size, PartitionSizeGrow)
+		}
+		percent, convertErr := p.SizePercent()
+		if convertErr != nil {
+			return fmt.Errorf("invalid value for Size (%s), could not convert percentage to integer: %w", p.Size, convertErr)
+		}
+		if percent == 0 || percent > 100 {
+			return fmt.Errorf("invalid value for Size (%s), percentage must be in the range 0 < percentage <= 100", p.Size)
+		}
+	}
+
+	if p.SourceImage != "" && p.HasFlag(PartitionFlagDeviceMapperRoot) {
+		return fmt.Errorf("[Partition] '%s' may not use a [SourceImage] together with the '%s' flag", p.ID, PartitionFlagDeviceMapperRoot)
+	}
+
+	if p.HasRelativeSize() && p.End != 0 {
+		return fmt.Errorf("[Partition] '%s' may not set both [Size] and [End]", p.ID)
+	}
+
+	if err = p.fsPaddingIsValid(); err != nil {
+		return
+	}
+
+	if err = p.fsSizeIsValid(); err != nil {
+		return
+	}
+
+	if err = p.fsFeaturesAreValid(); err != nil {
+		return
+	}
+
+	if err = p.regenerateUUIDIsValid(); err != nil {
+		return
+	}
+
+	if err = p.reservedBlocksPercentIsValid(); err != nil {
+		return
+	}
+
+	if err = p.extraMkfsArgsAreValid(); err != nil {
+		return
+	}
+
 	return nil
 }
 
+// regenerateUUIDIsValid checks a new UUID is only requested for an imported file system whose UUID can be changed.
+func (p *Partition) regenerateUUIDIsValid() (err error) {
+	if !p.RegenerateUUID {
+		return
+	}
+
+	if p.SourceImage == "" {
+		return fmt.Errorf("[Partition] '%s' sets [RegenerateUUID] without a [SourceImage], formatted partitions always get a new UUID", p.ID)
+	}
+
+	switch p.FsType {
+	case "ext2", "ext3", "ext4", "xfs", "fat16", "fat32", "vfat":
+	default:
+		return fmt.Errorf("[Partition] '%s' sets [RegenerateUUID], which is not supported for (%s) file systems", p.ID, p.FsType)
+	}
+
+	return
+}
+
+// reservedBlocksPercentIsValid checks a reserved blocks percentage is only set for ext2/3/4 file systems, and is in range.
+func (p *Partition) reservedBlocksPercentIsValid() (err error) {
+	if p.ReservedBlocksPercent == nil {
+		return
+	}
+
+	switch p.FsType {
+	case "ext2", "ext3", "ext4":
+	default:
+		return fmt.Errorf("[Partition] '%s' sets [ReservedBlocksPercent], which is only supported for ext2, ext3 and ext4 file systems, not (%s)", p.ID, p.FsType)
+	}
+
+	if *p.ReservedBlocksPercent > maxReservedBlocksPercent {
+		return fmt.Errorf("[Partition] '%s' has an invalid [ReservedBlocksPercent] (%d), must be in the range 0-%d", p.ID, *p.ReservedBlocksPercent, maxReservedBlocksPercent)
+	}
+
+	return
+}
+
+// extraMkfsArgsAreValid checks extra mkfs arguments are only given to formatted partitions, and don't
+// include an option which would break the build or the partition.
+func (p *Partition) extraMkfsArgsAreValid() (err error) {
+	if len(p.ExtraMkfsArgs) == 0 {
+		return
+	}
+
+	var dangerousArgs map[string]string
+	switch p.FsType {
+	case "ext2", "ext3", "ext4":
+		dangerousArgs = dangerousMkfsArgs["ext"]
+	case "fat16", "fat32", "vfat":
+		dangerousArgs = dangerousMkfsArgs["vfat"]
+	default:
+		return fmt.Errorf("[Partition] '%s' sets [ExtraMkfsArgs], which are only supported for ext2, ext3, ext4 and FAT file systems, not (%s)", p.ID, p.FsType)
+	}
+
+	if p.SourceImage != "" {
+		return fmt.Errorf("[Partition] '%s' may not set [ExtraMkfsArgs] together with a [SourceImage], which is not formatted", p.ID)
+	}
+
+	for _, arg := range p.ExtraMkfsArgs {
+		if arg == "" {
+			return fmt.Errorf("[Partition] '%s' has an empty argument in [ExtraMkfsArgs]", p.ID)
+		}
+		if strings.HasPrefix(arg, "/dev/") {
+			return fmt.Errorf("[Partition] '%s' may not pass a device (%s) in [ExtraMkfsArgs], the partition is added by the build", p.ID, arg)
+		}
+		// Short options may be given together with their value, ie "-tvfat"
+		for option, reason := range dangerousArgs {
+			if arg == option || (strings.HasPrefix(arg, option) && !strings.HasPrefix(arg, "--")) {
+				return fmt.Errorf("[Partition] '%s' may not pass (%s) in [ExtraMkfsArgs], %s", p.ID, arg, reason)
+			}
+		}
+		if arg == "--type" || strings.HasPrefix(arg, "--type=") {
+			return fmt.Errorf("[Partition] '%s' may not pass (%s) in [ExtraMkfsArgs], %s", p.ID, arg, dangerousArgs["-t"])
+		}
+	}
+
+	return
+}
+
+// GetReservedBlocksPercentArg returns the reserved blocks percentage in the form mke2fs and tune2fs "-m" expect,
+// or an empty string if it is not set.
+func (p *Partition) GetReservedBlocksPercentArg() string {
+	if p.ReservedBlocksPercent == nil {
+		return ""
+	}
+	return strconv.FormatUint(*p.ReservedBlocksPercent, 10)
+}
+
+// fsFeaturesAreValid checks the requested file system features are known to mke2fs.
+func (p *Partition) fsFeaturesAreValid() (err error) {
+	if len(p.FsFeatures) == 0 {
+		return
+	}
+
+	switch p.FsType {
+	case "ext2", "ext3", "ext4":
+	default:
+		return fmt.Errorf("[Partition] '%s' sets [FsFeatures], which are only supported for ext2, ext3 and ext4 file systems, not (%s)", p.ID, p.FsType)
+	}
+
+	if p.SourceImage != "" {
+		return fmt.Errorf("[Partition] '%s' may not set [FsFeatures] together with a [SourceImage], which is not formatted", p.ID)
+	}
+
+	for _, feature := range p.FsFeatures {
+		if !extFsFeatures[strings.TrimPrefix(feature, "^")] {
+			return fmt.Errorf("[Partition] '%s' has an unknown %s feature (%s) in [FsFeatures]", p.ID, p.FsType, feature)
+		}
+	}
+
+	return
+}
+
+// GetFsFeaturesArg returns the file system features in the form mke2fs "-O" expects.
+func (p *Partition) GetFsFeaturesArg() string {
+	return strings.Join(p.FsFeatures, ",")
+}
+
+// fsSizeIsValid checks a requested file system size can be applied to the partition. Partitions
+// sized relative to the disk, or filling the rest of it, are checked once the image is built.
+func (p *Partition) fsSizeIsValid() (err error) {
+	if p.FsSize == 0 {
+		return
+	}
+
+	switch p.FsType {
+	case "ext2", "ext3", "ext4":
+	default:
+		return fmt.Errorf("[Partition] '%s' sets [FsSize], which is only supported for ext2, ext3 and ext4 file systems, not (%s)", p.ID, p.FsType)
+	}
+
+	// A verity hash tree covers the file system as it was built, so it can't be resized afterwards
+	if p.HasFlag(PartitionFlagDeviceMapperRoot) {
+		return fmt.Errorf("[Partition] '%s' may not use [FsSize] together with the '%s' flag", p.ID, PartitionFlagDeviceMapperRoot)
+	}
+
+	if !p.HasRelativeSize() && p.End != 0 && p.FsSize > p.End-p.Start {
+		return fmt.Errorf("[Partition] '%s' has an [FsSize] of %d MiB, which exceeds its size of %d MiB", p.ID, p.FsSize, p.End-p.Start)
+	}
+
+	return
+}
+
+// fsPaddingIsValid checks the file system can be shrunk to its minimum size plus [FsPadding]
+func (p *Partition) fsPaddingIsValid() (err error) {
+	if p.FsPadding == 0 {
+		return
+	}
+
+	if p.FsSize != 0 {
+		return fmt.Errorf("[Partition] '%s' may not use both [FsSize] and [FsPadding]", p.ID)
+	}
+
+	switch p.FsType {
+	case "ext2", "ext3", "ext4":
+	default:
+		return fmt.Errorf("[Partition] '%s' sets [FsPadding], which is only supported for ext2, ext3 and ext4 file systems, not (%s)", p.ID, p.FsType)
+	}
+
+	if p.HasFlag(PartitionFlagDeviceMapperRoot) {
+		return fmt.Errorf("[Partition] '%s' may not use [FsPadding] together with the '%s' flag", p.ID, PartitionFlagDeviceMapperRoot)
+	}
+
+	return
+}
+
 // UnmarshalJSON Unmarshals a Partition entry
 func (p *Partition) UnmarshalJSON(b []byte) (err error) {
 	// Use an intermediate type which will use the default JSON unmarshal implementation
@@ -68,3 +68,290 @@ func TestShouldFailParsingInvalidJSON_Partition(t *testing.T) {
 	assert.Error(t, err)
 	assert.Equal(t, "failed to parse [Partition]: json: cannot unmarshal string into Go struct field IntermediateTypePartition.End of type uint64", err.Error())
 }
+
+func TestShouldSucceedParsingPercentSize_Partition(t *testing.T) {
+	var checkedPartition Partition
+	sizedPartition := validPartition
+	sizedPartition.Size = "25%"
+
+	assert.NoError(t, sizedPartition.IsValid())
+	err := remarshalJSON(sizedPartition, &checkedPartition)
+	assert.NoError(t, err)
+	assert.Equal(t, sizedPartition, checkedPartition)
+
+	percent, err := checkedPartition.SizePercent()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(25), percent)
+}
+
+func TestShouldSucceedParsingGrowSize_Partition(t *testing.T) {
+	sizedPartition := validPartition
+	sizedPartition.Size = PartitionSizeGrow
+
+	assert.NoError(t, sizedPartition.IsValid())
+	assert.True(t, sizedPartition.IsGrow())
+}
+
+func TestShouldFailParsingInvalidSize_Partition(t *testing.T) {
+	var checkedPartition Partition
+	invalidPartition := validPartition
+	invalidPartition.Size = "10MiB"
+
+	err := invalidPartition.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid value for Size (10MiB), must be either 'grow' or a percentage of the disk (1%-100%)", err.Error())
+
+	err = remarshalJSON(invalidPartition, &checkedPartition)
+	assert.Error(t, err)
+	assert.Equal(t, "failed to parse [Partition]: invalid value for Size (10MiB), must be either 'grow' or a percentage of the disk (1%-100%)", err.Error())
+}
+
+func TestShouldFailParsingOutOfRangePercentSize_Partition(t *testing.T) {
+	invalidPartition := validPartition
+	invalidPartition.Size = "101%"
+
+	err := invalidPartition.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid value for Size (101%), percentage must be in the range 0 < percentage <= 100", err.Error())
+}
+
+func TestShouldFailParsingSizeAndEnd_Partition(t *testing.T) {
+	invalidPartition := validPartition
+	invalidPartition.Size = "10%"
+	invalidPartition.End = 200
+
+	err := invalidPartition.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "[Partition] 'MyPartID' may not set both [Size] and [End]", err.Error())
+}
+
+func TestShouldSucceedParsingSourceImage_Partition(t *testing.T) {
+	var checkedPartition Partition
+	imagePartition := validPartition
+	imagePartition.Flags = []PartitionFlag{PartitionFlagESP}
+	imagePartition.SourceImage = "images/esp.img"
+
+	assert.NoError(t, imagePartition.IsValid())
+	err := remarshalJSON(imagePartition, &checkedPartition)
+	assert.NoError(t, err)
+	assert.Equal(t, imagePartition, checkedPartition)
+}
+
+func TestShouldFailParsingSourceImageOnDeviceMapperRoot_Partition(t *testing.T) {
+	invalidPartition := validPartition
+	invalidPartition.SourceImage = "images/rootfs.img"
+
+	err := invalidPartition.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "[Partition] 'MyPartID' may not use a [SourceImage] together with the 'dmroot' flag", err.Error())
+}
+
+func TestShouldSucceedParsingFsSize_Partition(t *testing.T) {
+	sizedPartition := validPartition
+	sizedPartition.Flags = []PartitionFlag{}
+	sizedPartition.FsType = "ext4"
+	sizedPartition.Start = 1
+	sizedPartition.End = 1024
+	sizedPartition.FsSize = 768
+
+	assert.NoError(t, sizedPartition.IsValid())
+}
+
+func TestShouldFailParsingOversizedFsSize_Partition(t *testing.T) {
+	sizedPartition := validPartition
+	sizedPartition.Flags = []PartitionFlag{}
+	sizedPartition.FsType = "ext4"
+	sizedPartition.Start = 1
+	sizedPartition.End = 1024
+	sizedPartition.FsSize = 2048
+
+	err := sizedPartition.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "[Partition] '"+sizedPartition.ID+"' has an [FsSize] of 2048 MiB, which exceeds its size of 1023 MiB", err.Error())
+}
+
+func TestShouldFailParsingFsSizeForVfat_Partition(t *testing.T) {
+	sizedPartition := validPartition
+	sizedPartition.Flags = []PartitionFlag{}
+	sizedPartition.FsType = "fat32"
+	sizedPartition.FsSize = 8
+
+	err := sizedPartition.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "[Partition] '"+sizedPartition.ID+"' sets [FsSize], which is only supported for ext2, ext3 and ext4 file systems, not (fat32)", err.Error())
+}
+
+func TestShouldSucceedParsingFsPadding_Partition(t *testing.T) {
+	paddedPartition := validPartition
+	paddedPartition.Flags = []PartitionFlag{}
+	paddedPartition.FsType = "ext4"
+	paddedPartition.FsPadding = 256
+
+	assert.NoError(t, paddedPartition.IsValid())
+}
+
+func TestShouldFailParsingFsPaddingWithFsSize_Partition(t *testing.T) {
+	paddedPartition := validPartition
+	paddedPartition.Flags = []PartitionFlag{}
+	paddedPartition.FsType = "ext4"
+	paddedPartition.Start = 1
+	paddedPartition.End = 1024
+	paddedPartition.FsSize = 768
+	paddedPartition.FsPadding = 256
+
+	err := paddedPartition.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "[Partition] '"+paddedPartition.ID+"' may not use both [FsSize] and [FsPadding]", err.Error())
+}
+
+func TestShouldFailParsingFsPaddingOnDeviceMapperRoot_Partition(t *testing.T) {
+	paddedPartition := validPartition
+	paddedPartition.FsType = "ext4"
+	paddedPartition.FsPadding = 256
+
+	err := paddedPartition.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "[Partition] '"+paddedPartition.ID+"' may not use [FsPadding] together with the 'dmroot' flag", err.Error())
+}
+
+func TestShouldSucceedParsingFsFeatures_Partition(t *testing.T) {
+	featurePartition := validPartition
+	featurePartition.FsType = "ext4"
+	featurePartition.FsFeatures = []string{"^64bit", "^metadata_csum", "has_journal"}
+
+	assert.NoError(t, featurePartition.IsValid())
+	assert.Equal(t, "^64bit,^metadata_csum,has_journal", featurePartition.GetFsFeaturesArg())
+}
+
+func TestShouldFailParsingUnknownFsFeature_Partition(t *testing.T) {
+	featurePartition := validPartition
+	featurePartition.FsType = "ext4"
+	featurePartition.FsFeatures = []string{"^64bits"}
+
+	err := featurePartition.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "[Partition] '"+featurePartition.ID+"' has an unknown ext4 feature (^64bits) in [FsFeatures]", err.Error())
+}
+
+func TestShouldFailParsingFsFeaturesForVfat_Partition(t *testing.T) {
+	featurePartition := validPartition
+	featurePartition.FsType = "fat32"
+	featurePartition.FsFeatures = []string{"^64bit"}
+
+	err := featurePartition.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "[Partition] '"+featurePartition.ID+"' sets [FsFeatures], which are only supported for ext2, ext3 and ext4 file systems, not (fat32)", err.Error())
+}
+
+func TestShouldSucceedParsingRegenerateUUID_Partition(t *testing.T) {
+	var checkedPartition Partition
+	imagePartition := validPartition
+	imagePartition.Flags = []PartitionFlag{PartitionFlagESP}
+	imagePartition.FsType = "fat32"
+	imagePartition.SourceImage = "images/esp.img"
+	imagePartition.RegenerateUUID = true
+
+	assert.NoError(t, imagePartition.IsValid())
+	err := remarshalJSON(imagePartition, &checkedPartition)
+	assert.NoError(t, err)
+	assert.Equal(t, imagePartition, checkedPartition)
+}
+
+func TestShouldFailParsingRegenerateUUIDWithoutSourceImage_Partition(t *testing.T) {
+	invalidPartition := validPartition
+	invalidPartition.Flags = []PartitionFlag{}
+	invalidPartition.RegenerateUUID = true
+
+	err := invalidPartition.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "[Partition] '"+invalidPartition.ID+"' sets [RegenerateUUID] without a [SourceImage], formatted partitions always get a new UUID", err.Error())
+}
+
+func TestShouldFailParsingRegenerateUUIDForUnsupportedFs_Partition(t *testing.T) {
+	invalidPartition := validPartition
+	invalidPartition.Flags = []PartitionFlag{}
+	invalidPartition.FsType = "linux-swap"
+	invalidPartition.SourceImage = "images/swap.img"
+	invalidPartition.RegenerateUUID = true
+
+	err := invalidPartition.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "[Partition] '"+invalidPartition.ID+"' sets [RegenerateUUID], which is not supported for (linux-swap) file systems", err.Error())
+}
+
+func TestShouldSucceedParsingZeroReservedBlocksPercent_Partition(t *testing.T) {
+	var checkedPartition Partition
+	dataPartition := validPartition
+	dataPartition.Flags = []PartitionFlag{}
+	dataPartition.FsType = "ext4"
+	reservedBlocksPercent := uint64(0)
+	dataPartition.ReservedBlocksPercent = &reservedBlocksPercent
+
+	assert.NoError(t, dataPartition.IsValid())
+	err := remarshalJSON(dataPartition, &checkedPartition)
+	assert.NoError(t, err)
+	assert.Equal(t, dataPartition, checkedPartition)
+	assert.Equal(t, "0", checkedPartition.GetReservedBlocksPercentArg())
+}
+
+func TestShouldFailParsingOutOfRangeReservedBlocksPercent_Partition(t *testing.T) {
+	invalidPartition := validPartition
+	invalidPartition.Flags = []PartitionFlag{}
+	invalidPartition.FsType = "ext4"
+	reservedBlocksPercent := uint64(51)
+	invalidPartition.ReservedBlocksPercent = &reservedBlocksPercent
+
+	err := invalidPartition.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "[Partition] '"+invalidPartition.ID+"' has an invalid [ReservedBlocksPercent] (51), must be in the range 0-50", err.Error())
+}
+
+func TestShouldFailParsingReservedBlocksPercentForUnsupportedFs_Partition(t *testing.T) {
+	invalidPartition := validPartition
+	invalidPartition.Flags = []PartitionFlag{}
+	invalidPartition.FsType = "xfs"
+	reservedBlocksPercent := uint64(1)
+	invalidPartition.ReservedBlocksPercent = &reservedBlocksPercent
+
+	err := invalidPartition.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "[Partition] '"+invalidPartition.ID+"' sets [ReservedBlocksPercent], which is only supported for ext2, ext3 and ext4 file systems, not (xfs)", err.Error())
+}
+
+func TestShouldSucceedParsingExtraMkfsArgs_Partition(t *testing.T) {
+	var checkedPartition Partition
+	dataPartition := validPartition
+	dataPartition.Flags = []PartitionFlag{}
+	dataPartition.ExtraMkfsArgs = []string{"-E", "stride=16,stripe_width=64", "-O", "bigalloc", "-C", "65536"}
+
+	assert.NoError(t, dataPartition.IsValid())
+	err := remarshalJSON(dataPartition, &checkedPartition)
+	assert.NoError(t, err)
+	assert.Equal(t, dataPartition, checkedPartition)
+}
+
+func TestShouldFailParsingDangerousExtraMkfsArgs_Partition(t *testing.T) {
+	invalidPartition := validPartition
+	invalidPartition.Flags = []PartitionFlag{}
+	invalidPartition.ExtraMkfsArgs = []string{"-E", "lazy_itable_init=0", "-F"}
+
+	err := invalidPartition.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "[Partition] '"+invalidPartition.ID+"' may not pass (-F) in [ExtraMkfsArgs], it skips mke2fs's safety checks", err.Error())
+
+	invalidPartition.ExtraMkfsArgs = []string{"--type=xfs"}
+	err = invalidPartition.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "[Partition] '"+invalidPartition.ID+"' may not pass (--type=xfs) in [ExtraMkfsArgs], the file system type is set by [FsType]", err.Error())
+}
+
+func TestShouldFailParsingExtraMkfsArgsForSourceImage_Partition(t *testing.T) {
+	invalidPartition := validPartition
+	invalidPartition.Flags = []PartitionFlag{}
+	invalidPartition.SourceImage = "images/data.img"
+	invalidPartition.ExtraMkfsArgs = []string{"-i", "65536"}
+
+	err := invalidPartition.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "[Partition] '"+invalidPartition.ID+"' may not set [ExtraMkfsArgs] together with a [SourceImage], which is not formatted", err.Error())
+}
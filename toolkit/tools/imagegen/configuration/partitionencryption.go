@@ -0,0 +1,95 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Parser for the image builder's configuration schemas.
+
+package configuration
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	// Cipher specifications are of the form cipher-chainmode-ivmode, e.g. "aes-xts-plain64"
+	luksCipherRegex = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9:]+)*$`)
+)
+
+// PartitionEncryption holds the LUKS settings for a non-root data partition.
+//   - Enable: Format the partition as a LUKS2 volume
+//   - Password: Passphrase used to format the volume, also usable to unlock it
+//   - Cipher: The cryptsetup cipher, defaults to "aes-xts-plain64"
+//   - KeySize: The key size in bits, defaults to 512
+//   - Tpm2Unlock: Configure the crypttab entry to unlock the volume with a TPM2
+//     device. The TPM2 key must still be enrolled on the target machine.
+//   - DetachedHeader: Keep the LUKS header out of the partition and write it to this file
+//     in the imager's output directory instead
+//   - HeaderPath: Where the detached header is found on the running system, referenced by crypttab
+type PartitionEncryption struct {
+	Enable         bool   `json:"Enable"`
+	Password       string `json:"Password"`
+	Cipher         string `json:"Cipher"`
+	KeySize        uint64 `json:"KeySize"`
+	Tpm2Unlock     bool   `json:"Tpm2Unlock"`
+	DetachedHeader string `json:"DetachedHeader"`
+	HeaderPath     string `json:"HeaderPath"`
+}
+
+// IsValid returns an error if the PartitionEncryption is not valid
+func (p *PartitionEncryption) IsValid() (err error) {
+	if !p.Enable {
+		if p.Password != "" || p.Cipher != "" || p.KeySize != 0 || p.Tpm2Unlock || p.DetachedHeader != "" || p.HeaderPath != "" {
+			return fmt.Errorf("encryption settings provided but [Enable] is false")
+		}
+		return
+	}
+
+	if p.Password == "" {
+		return fmt.Errorf("[Password] must be set to format the encrypted partition")
+	}
+
+	if p.Cipher != "" && !luksCipherRegex.MatchString(p.Cipher) {
+		return fmt.Errorf("invalid [Cipher] (%s)", p.Cipher)
+	}
+
+	if p.KeySize%8 != 0 {
+		return fmt.Errorf("invalid [KeySize] (%d), must be a multiple of 8", p.KeySize)
+	}
+
+	// The partition can't be unlocked at boot unless crypttab knows where to find its header
+	if (p.DetachedHeader == "") != (p.HeaderPath == "") {
+		return fmt.Errorf("[DetachedHeader] and [HeaderPath] must be set together")
+	}
+
+	if p.DetachedHeader != "" {
+		if strings.ContainsRune(p.DetachedHeader, filepath.Separator) || p.DetachedHeader == "." || p.DetachedHeader == ".." {
+			return fmt.Errorf("invalid [DetachedHeader] (%s), must be a file name", p.DetachedHeader)
+		}
+
+		if !filepath.IsAbs(p.HeaderPath) || filepath.Clean(p.HeaderPath) != p.HeaderPath || strings.ContainsAny(p.HeaderPath, ", \t") {
+			return fmt.Errorf("invalid [HeaderPath] (%s), must be a clean absolute path without commas or whitespace", p.HeaderPath)
+		}
+	}
+
+	return
+}
+
+// UnmarshalJSON Unmarshals a PartitionEncryption entry
+func (p *PartitionEncryption) UnmarshalJSON(b []byte) (err error) {
+	// Use an intermediate type which will use the default JSON unmarshal implementation
+	type IntermediateTypePartitionEncryption PartitionEncryption
+	err = json.Unmarshal(b, (*IntermediateTypePartitionEncryption)(p))
+	if err != nil {
+		return fmt.Errorf("failed to parse [PartitionEncryption]: %w", err)
+	}
+
+	// Now validate the resulting unmarshaled object
+	err = p.IsValid()
+	if err != nil {
+		return fmt.Errorf("failed to parse [PartitionEncryption]: %w", err)
+	}
+	return
+}
@@ -0,0 +1,111 @@
+// Copyright Microsoft Corporation.
+// Licensed under the MIT License.
+
+package configuration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//TestMain found in configuration_test.go.
+
+var (
+	validPartitionEncryption PartitionEncryption = PartitionEncryption{
+		Enable:     true,
+		Password:   "EncryptPassphrase123",
+		Cipher:     "aes-xts-plain64",
+		KeySize:    512,
+		Tpm2Unlock: true,
+	}
+	invalidPartitionEncryptionJSON = `{"Enable": true, "Password": "EncryptPassphrase123", "Cipher": "AES XTS"}`
+)
+
+func TestShouldSucceedParsingDefaultPartitionEncryption_PartitionEncryption(t *testing.T) {
+	var checkedPartitionEncryption PartitionEncryption
+	err := marshalJSONString("{}", &checkedPartitionEncryption)
+	assert.NoError(t, err)
+	assert.Equal(t, PartitionEncryption{}, checkedPartitionEncryption)
+}
+
+func TestShouldSucceedParsingValidPartitionEncryption_PartitionEncryption(t *testing.T) {
+	var checkedPartitionEncryption PartitionEncryption
+
+	assert.NoError(t, validPartitionEncryption.IsValid())
+	err := remarshalJSON(validPartitionEncryption, &checkedPartitionEncryption)
+	assert.NoError(t, err)
+	assert.Equal(t, validPartitionEncryption, checkedPartitionEncryption)
+}
+
+func TestShouldFailParsingInvalidCipher_PartitionEncryption(t *testing.T) {
+	var checkedPartitionEncryption PartitionEncryption
+
+	err := marshalJSONString(invalidPartitionEncryptionJSON, &checkedPartitionEncryption)
+	assert.Error(t, err)
+	assert.Equal(t, "failed to parse [PartitionEncryption]: invalid [Cipher] (AES XTS)", err.Error())
+}
+
+func TestShouldFailParsingMissingPassword_PartitionEncryption(t *testing.T) {
+	invalidPartitionEncryption := validPartitionEncryption
+	invalidPartitionEncryption.Password = ""
+
+	err := invalidPartitionEncryption.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "[Password] must be set to format the encrypted partition", err.Error())
+}
+
+func TestShouldFailParsingInvalidKeySize_PartitionEncryption(t *testing.T) {
+	invalidPartitionEncryption := validPartitionEncryption
+	invalidPartitionEncryption.KeySize = 257
+
+	err := invalidPartitionEncryption.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [KeySize] (257), must be a multiple of 8", err.Error())
+}
+
+func TestShouldFailParsingSettingsWhileDisabled_PartitionEncryption(t *testing.T) {
+	invalidPartitionEncryption := validPartitionEncryption
+	invalidPartitionEncryption.Enable = false
+
+	err := invalidPartitionEncryption.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "encryption settings provided but [Enable] is false", err.Error())
+}
+
+func TestShouldSucceedParsingDetachedHeader_PartitionEncryption(t *testing.T) {
+	detachedPartitionEncryption := validPartitionEncryption
+	detachedPartitionEncryption.DetachedHeader = "data.header"
+	detachedPartitionEncryption.HeaderPath = "/etc/luks/data.header"
+
+	assert.NoError(t, detachedPartitionEncryption.IsValid())
+}
+
+func TestShouldFailParsingDetachedHeaderWithoutHeaderPath_PartitionEncryption(t *testing.T) {
+	invalidPartitionEncryption := validPartitionEncryption
+	invalidPartitionEncryption.DetachedHeader = "data.header"
+
+	err := invalidPartitionEncryption.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "[DetachedHeader] and [HeaderPath] must be set together", err.Error())
+}
+
+func TestShouldFailParsingDetachedHeaderWithDirectory_PartitionEncryption(t *testing.T) {
+	invalidPartitionEncryption := validPartitionEncryption
+	invalidPartitionEncryption.DetachedHeader = "../data.header"
+	invalidPartitionEncryption.HeaderPath = "/etc/luks/data.header"
+
+	err := invalidPartitionEncryption.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [DetachedHeader] (../data.header), must be a file name", err.Error())
+}
+
+func TestShouldFailParsingRelativeHeaderPath_PartitionEncryption(t *testing.T) {
+	invalidPartitionEncryption := validPartitionEncryption
+	invalidPartitionEncryption.DetachedHeader = "data.header"
+	invalidPartitionEncryption.HeaderPath = "etc/luks/data.header"
+
+	err := invalidPartitionEncryption.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [HeaderPath] (etc/luks/data.header), must be a clean absolute path without commas or whitespace", err.Error())
+}
@@ -0,0 +1,70 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Parser for the image builder's configuration schemas.
+
+package configuration
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	// DefaultIntegrityAlgorithm is the checksum used when [Algorithm] is not set
+	DefaultIntegrityAlgorithm = "crc32c"
+)
+
+var (
+	// integrityAlgorithms are the keyless checksums both integritysetup and systemd-integritysetup support
+	integrityAlgorithms = map[string]bool{"crc32c": true, "crc32": true, "sha1": true, "sha256": true}
+)
+
+// PartitionIntegrity holds the dm-integrity settings for a writable, non-root data partition.
+//   - Enable: Format the partition with dm-integrity, so corrupted or tampered blocks fail to read
+//   - Algorithm: The per-sector checksum, one of "crc32c" (default), "crc32", "sha1" or "sha256"
+type PartitionIntegrity struct {
+	Enable    bool   `json:"Enable"`
+	Algorithm string `json:"Algorithm"`
+}
+
+// GetAlgorithm returns the per-sector checksum, applying the default
+func (p *PartitionIntegrity) GetAlgorithm() string {
+	if p.Algorithm == "" {
+		return DefaultIntegrityAlgorithm
+	}
+	return p.Algorithm
+}
+
+// IsValid returns an error if the PartitionIntegrity is not valid
+func (p *PartitionIntegrity) IsValid() (err error) {
+	if !p.Enable {
+		if p.Algorithm != "" {
+			return fmt.Errorf("integrity settings provided but [Enable] is false")
+		}
+		return
+	}
+
+	if !integrityAlgorithms[p.GetAlgorithm()] {
+		return fmt.Errorf("invalid [Algorithm] (%s), must be one of 'crc32c', 'crc32', 'sha1' or 'sha256'", p.Algorithm)
+	}
+
+	return
+}
+
+// UnmarshalJSON Unmarshals a PartitionIntegrity entry
+func (p *PartitionIntegrity) UnmarshalJSON(b []byte) (err error) {
+	// Use an intermediate type which will use the default JSON unmarshal implementation
+	type IntermediateTypePartitionIntegrity PartitionIntegrity
+	err = json.Unmarshal(b, (*IntermediateTypePartitionIntegrity)(p))
+	if err != nil {
+		return fmt.Errorf("failed to parse [PartitionIntegrity]: %w", err)
+	}
+
+	// Now validate the resulting unmarshaled object
+	err = p.IsValid()
+	if err != nil {
+		return fmt.Errorf("failed to parse [PartitionIntegrity]: %w", err)
+	}
+	return
+}
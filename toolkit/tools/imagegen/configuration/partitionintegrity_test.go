@@ -0,0 +1,50 @@
+// Copyright Microsoft Corporation.
+// Licensed under the MIT License.
+
+package configuration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//TestMain found in configuration_test.go.
+
+var (
+	validPartitionIntegrity PartitionIntegrity = PartitionIntegrity{
+		Enable:    true,
+		Algorithm: "sha256",
+	}
+	invalidPartitionIntegrityJSON = `{"Enable": true, "Algorithm": "md5"}`
+)
+
+func TestShouldSucceedParsingDefaultPartitionIntegrity_PartitionIntegrity(t *testing.T) {
+	var checkedPartitionIntegrity PartitionIntegrity
+	err := marshalJSONString(`{"Enable": true}`, &checkedPartitionIntegrity)
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultIntegrityAlgorithm, checkedPartitionIntegrity.GetAlgorithm())
+}
+
+func TestShouldSucceedParsingValidPartitionIntegrity_PartitionIntegrity(t *testing.T) {
+	var checkedPartitionIntegrity PartitionIntegrity
+	err := remarshalJSON(validPartitionIntegrity, &checkedPartitionIntegrity)
+	assert.NoError(t, err)
+	assert.Equal(t, validPartitionIntegrity, checkedPartitionIntegrity)
+}
+
+func TestShouldFailParsingInvalidAlgorithm_PartitionIntegrity(t *testing.T) {
+	var checkedPartitionIntegrity PartitionIntegrity
+	err := marshalJSONString(invalidPartitionIntegrityJSON, &checkedPartitionIntegrity)
+	assert.Error(t, err)
+	assert.Equal(t, "failed to parse [PartitionIntegrity]: invalid [Algorithm] (md5), must be one of 'crc32c', 'crc32', 'sha1' or 'sha256'", err.Error())
+}
+
+func TestShouldFailParsingSettingsWhileDisabled_PartitionIntegrity(t *testing.T) {
+	invalidPartitionIntegrity := validPartitionIntegrity
+	invalidPartitionIntegrity.Enable = false
+
+	err := invalidPartitionIntegrity.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "integrity settings provided but [Enable] is false", err.Error())
+}
@@ -8,20 +8,88 @@ package configuration
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 )
 
+var (
+	// earlyBootMountPoints are needed before systemd mounts the partitions listed in fstab,
+	// so they can't be separate partitions
+	earlyBootMountPoints = []string{"/bin", "/dev", "/etc", "/lib", "/lib64", "/proc", "/run", "/sbin", "/sys", "/usr"}
+	// defaultMountOptions are the fstab options of common separate partitions which don't set [MountOptions]
+	defaultMountOptions = map[string]string{
+		"/home":    "defaults,nodev,nosuid",
+		"/tmp":     "defaults,nodev,nosuid",
+		"/var":     "defaults,nodev",
+		"/var/log": "defaults,nodev,nosuid",
+		"/var/tmp": "defaults,nodev,nosuid",
+	}
+)
+
+// DefaultMountOptions returns the fstab options of a common separate partition, such as /var or /home,
+// or an empty string for any other mount point
+func DefaultMountOptions(mountPoint string) string {
+	return defaultMountOptions[mountPoint]
+}
+
 // PartitionSetting holds the mounting information for each partition.
 type PartitionSetting struct {
-	RemoveDocs       bool   `json:"RemoveDocs"`
-	ID               string `json:"ID"`
-	MountOptions     string `json:"MountOptions"`
-	MountPoint       string `json:"MountPoint"`
-	OverlayBaseImage string `json:"OverlayBaseImage"`
-	RdiffBaseImage   string `json:"RdiffBaseImage"`
+	RemoveDocs       bool                `json:"RemoveDocs"`
+	GrowFsOnBoot     bool                `json:"GrowFsOnBoot"`
+	ID               string              `json:"ID"`
+	MountOptions     string              `json:"MountOptions"`
+	MountPoint       string              `json:"MountPoint"`
+	OverlayBaseImage string              `json:"OverlayBaseImage"`
+	RdiffBaseImage   string              `json:"RdiffBaseImage"`
+	DeltaBaseImage   string              `json:"DeltaBaseImage"`
+	Encryption       PartitionEncryption `json:"Encryption"`
+	Integrity        PartitionIntegrity  `json:"Integrity"`
 }
 
 // IsValid returns an error if the PartitionSetting is not valid
 func (p *PartitionSetting) IsValid() (err error) {
+	for _, earlyBootMountPoint := range earlyBootMountPoints {
+		if p.MountPoint == earlyBootMountPoint || strings.HasPrefix(p.MountPoint, earlyBootMountPoint+"/") {
+			return fmt.Errorf("invalid [MountPoint] (%s): (%s) is needed early in boot, before the partitions are mounted, and must be on the root partition", p.MountPoint, earlyBootMountPoint)
+		}
+	}
+
+	if p.GrowFsOnBoot && p.MountPoint == "" {
+		return fmt.Errorf("invalid [GrowFsOnBoot]: requires a [MountPoint]")
+	}
+
+	if p.DeltaBaseImage != "" && (p.RdiffBaseImage != "" || p.OverlayBaseImage != "") {
+		return fmt.Errorf("invalid [DeltaBaseImage]: can't be used together with [RdiffBaseImage] or [OverlayBaseImage]")
+	}
+
+	if err = p.Encryption.IsValid(); err != nil {
+		return fmt.Errorf("invalid [Encryption]: %w", err)
+	}
+
+	// The root partition is encrypted through the system config's root [Encryption] settings instead
+	if p.Encryption.Enable && p.MountPoint == "/" {
+		return fmt.Errorf("invalid [Encryption]: the root partition must use the system config's [Encryption] settings")
+	}
+
+	// A header stored on the volume it unlocks can never be read
+	headerPath := p.Encryption.HeaderPath
+	if headerPath != "" && p.MountPoint != "" && (headerPath == p.MountPoint || strings.HasPrefix(headerPath, p.MountPoint+"/")) {
+		return fmt.Errorf("invalid [Encryption]: [HeaderPath] (%s) can't be on the encrypted partition itself (%s)", headerPath, p.MountPoint)
+	}
+
+	if err = p.Integrity.IsValid(); err != nil {
+		return fmt.Errorf("invalid [Integrity]: %w", err)
+	}
+
+	if p.Integrity.Enable {
+		// The root partition is protected through the system config's [ReadOnlyVerityRoot] settings instead
+		if p.MountPoint == "/" {
+			return fmt.Errorf("invalid [Integrity]: the root partition can't use dm-integrity")
+		}
+		if p.Encryption.Enable {
+			return fmt.Errorf("invalid [Integrity]: a partition can't use both [Integrity] and [Encryption]")
+		}
+	}
+
 	return nil
 }
 
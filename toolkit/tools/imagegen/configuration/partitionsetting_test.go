@@ -40,3 +40,89 @@ func TestShouldFailParsingInvalidJSON_PartitionSetting(t *testing.T) {
 	assert.Error(t, err)
 	assert.Equal(t, "failed to parse [PartitionSetting]: json: cannot unmarshal number into Go struct field IntermediateTypePartitionSetting.RemoveDocs of type bool", err.Error())
 }
+
+func TestShouldSucceedParsingEncryptedDataPartition_PartitionSetting(t *testing.T) {
+	var checkedPartitionSetting PartitionSetting
+
+	encryptedPartitionSetting := validPartitionSetting
+	encryptedPartitionSetting.MountPoint = "/data"
+	encryptedPartitionSetting.Encryption = validPartitionEncryption
+
+	err := remarshalJSON(encryptedPartitionSetting, &checkedPartitionSetting)
+	assert.NoError(t, err)
+	assert.Equal(t, encryptedPartitionSetting, checkedPartitionSetting)
+}
+
+func TestShouldFailParsingEncryptedRootPartition_PartitionSetting(t *testing.T) {
+	encryptedPartitionSetting := validPartitionSetting
+	encryptedPartitionSetting.Encryption = validPartitionEncryption
+
+	err := encryptedPartitionSetting.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [Encryption]: the root partition must use the system config's [Encryption] settings", err.Error())
+}
+
+func TestShouldFailParsingHeaderPathOnEncryptedPartition_PartitionSetting(t *testing.T) {
+	encryptedPartitionSetting := validPartitionSetting
+	encryptedPartitionSetting.MountPoint = "/data"
+	encryptedPartitionSetting.Encryption = validPartitionEncryption
+	encryptedPartitionSetting.Encryption.DetachedHeader = "data.header"
+	encryptedPartitionSetting.Encryption.HeaderPath = "/data/luks/data.header"
+
+	err := encryptedPartitionSetting.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [Encryption]: [HeaderPath] (/data/luks/data.header) can't be on the encrypted partition itself (/data)", err.Error())
+}
+
+func TestShouldFailParsingIntegrityWithEncryption_PartitionSetting(t *testing.T) {
+	integrityPartitionSetting := validPartitionSetting
+	integrityPartitionSetting.MountPoint = "/data"
+	integrityPartitionSetting.Encryption = validPartitionEncryption
+	integrityPartitionSetting.Integrity = validPartitionIntegrity
+
+	err := integrityPartitionSetting.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [Integrity]: a partition can't use both [Integrity] and [Encryption]", err.Error())
+}
+
+func TestShouldFailParsingIntegrityRootPartition_PartitionSetting(t *testing.T) {
+	integrityPartitionSetting := validPartitionSetting
+	integrityPartitionSetting.Integrity = validPartitionIntegrity
+
+	err := integrityPartitionSetting.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [Integrity]: the root partition can't use dm-integrity", err.Error())
+}
+
+func TestShouldFailParsingDeltaWithRdiffBaseImage_PartitionSetting(t *testing.T) {
+	invalidPartitionSetting := validPartitionSetting
+	invalidPartitionSetting.DeltaBaseImage = "../out/images/core-efi/core-efi-rootfs-1.0.ext4"
+	invalidPartitionSetting.RdiffBaseImage = "../out/images/core-efi/core-efi-rootfs-1.0.ext4"
+
+	err := invalidPartitionSetting.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [DeltaBaseImage]: can't be used together with [RdiffBaseImage] or [OverlayBaseImage]", err.Error())
+}
+
+func TestShouldFailParsingEarlyBootMountPoint_PartitionSetting(t *testing.T) {
+	for _, mountPoint := range []string{"/usr", "/etc", "/usr/local"} {
+		invalidPartitionSetting := PartitionSetting{ID: "usr", MountPoint: mountPoint}
+
+		err := invalidPartitionSetting.IsValid()
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "is needed early in boot, before the partitions are mounted, and must be on the root partition")
+	}
+}
+
+func TestShouldReturnDefaultMountOptions_PartitionSetting(t *testing.T) {
+	assert.Equal(t, "defaults,nodev", DefaultMountOptions("/var"))
+	assert.Equal(t, "defaults,nodev,nosuid", DefaultMountOptions("/home"))
+	assert.Equal(t, "", DefaultMountOptions("/data"))
+}
+
+func TestShouldFailParsingGrowFsOnBootWithoutMountPoint_PartitionSetting(t *testing.T) {
+	var checkedPartitionSetting PartitionSetting
+	err := marshalJSONString(`{"ID": "MyData", "GrowFsOnBoot": true}`, &checkedPartitionSetting)
+	assert.Error(t, err)
+	assert.Equal(t, "failed to parse [PartitionSetting]: invalid [GrowFsOnBoot]: requires a [MountPoint]", err.Error())
+}
@@ -0,0 +1,69 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Parser for the image builder's configuration schemas.
+
+package configuration
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// PostConditions are checked against the finished image before it is closed, failing the
+// build if any of them is not met.
+//   - FilesExist: Absolute paths which must exist in the image
+//   - Packages: Packages which must be installed, as a name or name-version[-release]
+//   - ServicesEnabled: systemd units which must be enabled
+type PostConditions struct {
+	FilesExist      []string `json:"FilesExist"`
+	Packages        []string `json:"Packages"`
+	ServicesEnabled []string `json:"ServicesEnabled"`
+}
+
+// IsEmpty returns true if no post-conditions are configured.
+func (p *PostConditions) IsEmpty() bool {
+	return len(p.FilesExist) == 0 && len(p.Packages) == 0 && len(p.ServicesEnabled) == 0
+}
+
+// IsValid returns an error if the PostConditions are not valid
+func (p *PostConditions) IsValid() (err error) {
+	for _, path := range p.FilesExist {
+		if !filepath.IsAbs(path) {
+			return fmt.Errorf("invalid [FilesExist] entry (%s), must be an absolute path", path)
+		}
+	}
+
+	for _, pkg := range p.Packages {
+		if pkg == "" || strings.ContainsAny(pkg, " \t\n") {
+			return fmt.Errorf("invalid [Packages] entry (%s), must be a package name or name-version without whitespace", pkg)
+		}
+	}
+
+	for _, service := range p.ServicesEnabled {
+		if service == "" || strings.ContainsAny(service, " \t\n/") {
+			return fmt.Errorf("invalid [ServicesEnabled] entry (%s), must be a systemd unit name", service)
+		}
+	}
+
+	return
+}
+
+// UnmarshalJSON Unmarshals a PostConditions entry
+func (p *PostConditions) UnmarshalJSON(b []byte) (err error) {
+	// Use an intermediate type which will use the default JSON unmarshal implementation
+	type IntermediateTypePostConditions PostConditions
+	err = json.Unmarshal(b, (*IntermediateTypePostConditions)(p))
+	if err != nil {
+		return fmt.Errorf("failed to parse [Validate]: %w", err)
+	}
+
+	// Now validate the resulting unmarshaled object
+	err = p.IsValid()
+	if err != nil {
+		return fmt.Errorf("failed to parse [Validate]: %w", err)
+	}
+	return
+}
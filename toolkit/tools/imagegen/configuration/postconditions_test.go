@@ -0,0 +1,54 @@
+// Copyright Microsoft Corporation.
+// Licensed under the MIT License.
+
+package configuration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//TestMain found in configuration_test.go.
+
+var (
+	validPostConditions PostConditions = PostConditions{
+		FilesExist:      []string{"/etc/kiosk/app.conf", "/usr/bin/kiosk"},
+		Packages:        []string{"openssh-server", "kiosk-app-1.2.0"},
+		ServicesEnabled: []string{"sshd", "kiosk.service"},
+	}
+	invalidPostConditionsJSON = `{"FilesExist": ["etc/kiosk/app.conf"]}`
+)
+
+func TestShouldSucceedParsingValidPostConditions_PostConditions(t *testing.T) {
+	var checkedPostConditions PostConditions
+	err := remarshalJSON(validPostConditions, &checkedPostConditions)
+	assert.NoError(t, err)
+	assert.Equal(t, validPostConditions, checkedPostConditions)
+	assert.False(t, checkedPostConditions.IsEmpty())
+}
+
+func TestShouldFailParsingRelativeFile_PostConditions(t *testing.T) {
+	var checkedPostConditions PostConditions
+	err := marshalJSONString(invalidPostConditionsJSON, &checkedPostConditions)
+	assert.Error(t, err)
+	assert.Equal(t, "failed to parse [Validate]: invalid [FilesExist] entry (etc/kiosk/app.conf), must be an absolute path", err.Error())
+}
+
+func TestShouldFailParsingPackageWithSpace_PostConditions(t *testing.T) {
+	invalidPostConditions := validPostConditions
+	invalidPostConditions.Packages = []string{"openssh-server >= 8.8"}
+
+	err := invalidPostConditions.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [Packages] entry (openssh-server >= 8.8), must be a package name or name-version without whitespace", err.Error())
+}
+
+func TestShouldFailParsingServicePath_PostConditions(t *testing.T) {
+	invalidPostConditions := validPostConditions
+	invalidPostConditions.ServicesEnabled = []string{"/usr/lib/systemd/system/sshd.service"}
+
+	err := invalidPostConditions.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [ServicesEnabled] entry (/usr/lib/systemd/system/sshd.service), must be a systemd unit name", err.Error())
+}
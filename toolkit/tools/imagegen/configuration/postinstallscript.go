@@ -0,0 +1,88 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Parser for the image builder's configuration schemas.
+
+package configuration
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	// ScriptNetworkModeHost runs the script with the network of the build environment (default)
+	ScriptNetworkModeHost = "host"
+	// ScriptNetworkModeNone runs the script in a new, empty network namespace
+	ScriptNetworkModeNone = "none"
+
+	// defaultInlineInterpreter runs inline scripts which don't start with a shebang
+	defaultInlineInterpreter = "#!/bin/sh"
+)
+
+// PostInstallScript defines a script to be ran after other installation
+// steps are finished and provides a way to pass parameters to it.
+//   - Path: The script file on the build machine
+//   - Inline: The script's contents, used instead of Path for short commands
+//   - NetworkMode: "host" (default) or "none". A script run with "none" has no
+//     network access at all, not even loopback.
+type PostInstallScript struct {
+	Args        string `json:"Args"`
+	Path        string `json:"Path"`
+	Inline      string `json:"Inline"`
+	NetworkMode string `json:"NetworkMode"`
+}
+
+// InlineScript returns the contents of an inline script, run by /bin/sh unless it starts with a shebang.
+func (p *PostInstallScript) InlineScript() string {
+	script := p.Inline
+	if !strings.HasPrefix(script, "#!") {
+		script = defaultInlineInterpreter + "\n" + script
+	}
+	if !strings.HasSuffix(script, "\n") {
+		script += "\n"
+	}
+	return script
+}
+
+// NetworkIsolated returns true if the script must be run without network access.
+func (p *PostInstallScript) NetworkIsolated() bool {
+	return p.NetworkMode == ScriptNetworkModeNone
+}
+
+// IsValid returns an error if the PostInstallScript is not valid
+func (p *PostInstallScript) IsValid() (err error) {
+	if (p.Path == "") == (p.Inline == "") {
+		return fmt.Errorf("exactly one of [Path] or [Inline] must be set")
+	}
+
+	if p.Inline != "" && strings.TrimSpace(strings.TrimPrefix(p.Inline, "#!")) == "" {
+		return fmt.Errorf("[Inline] must contain a command")
+	}
+
+	switch p.NetworkMode {
+	case "", ScriptNetworkModeHost, ScriptNetworkModeNone:
+	default:
+		return fmt.Errorf("invalid value for [NetworkMode] (%s), must be one of '%s' or '%s'", p.NetworkMode, ScriptNetworkModeHost, ScriptNetworkModeNone)
+	}
+
+	return
+}
+
+// UnmarshalJSON Unmarshals a PostInstallScript entry
+func (p *PostInstallScript) UnmarshalJSON(b []byte) (err error) {
+	// Use an intermediate type which will use the default JSON unmarshal implementation
+	type IntermediateTypePostInstallScript PostInstallScript
+	err = json.Unmarshal(b, (*IntermediateTypePostInstallScript)(p))
+	if err != nil {
+		return fmt.Errorf("failed to parse [PostInstallScript]: %w", err)
+	}
+
+	// Now validate the resulting unmarshaled object
+	err = p.IsValid()
+	if err != nil {
+		return fmt.Errorf("failed to parse [PostInstallScript]: %w", err)
+	}
+	return
+}
@@ -0,0 +1,72 @@
+// Copyright Microsoft Corporation.
+// Licensed under the MIT License.
+
+package configuration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//TestMain found in configuration_test.go.
+
+var (
+	validPostInstallScript = PostInstallScript{
+		Path:        "scripts/postinstall.sh",
+		Args:        "--verbose",
+		NetworkMode: ScriptNetworkModeNone,
+	}
+	invalidPostInstallScriptJSON = `{"Path": "scripts/postinstall.sh", "NetworkMode": "bridge"}`
+)
+
+func TestShouldSucceedParsingDefaultNetworkMode_PostInstallScript(t *testing.T) {
+	var checkedScript PostInstallScript
+	err := marshalJSONString(`{"Path": "scripts/postinstall.sh"}`, &checkedScript)
+	assert.NoError(t, err)
+	assert.False(t, checkedScript.NetworkIsolated())
+}
+
+func TestShouldSucceedParsingValidPostInstallScript_PostInstallScript(t *testing.T) {
+	var checkedScript PostInstallScript
+	err := remarshalJSON(validPostInstallScript, &checkedScript)
+	assert.NoError(t, err)
+	assert.Equal(t, validPostInstallScript, checkedScript)
+	assert.True(t, checkedScript.NetworkIsolated())
+}
+
+func TestShouldFailParsingInvalidNetworkMode_PostInstallScript(t *testing.T) {
+	var checkedScript PostInstallScript
+	err := marshalJSONString(invalidPostInstallScriptJSON, &checkedScript)
+	assert.Error(t, err)
+	assert.Equal(t, "failed to parse [PostInstallScript]: invalid value for [NetworkMode] (bridge), must be one of 'host' or 'none'", err.Error())
+}
+
+func TestShouldSucceedParsingInlineScript_PostInstallScript(t *testing.T) {
+	var checkedScript PostInstallScript
+	err := marshalJSONString(`{"Inline": "systemctl mask kdump.service"}`, &checkedScript)
+	assert.NoError(t, err)
+	assert.Equal(t, "#!/bin/sh\nsystemctl mask kdump.service\n", checkedScript.InlineScript())
+}
+
+func TestShouldKeepInlineScriptShebang_PostInstallScript(t *testing.T) {
+	script := PostInstallScript{Inline: "#!/bin/bash\nset -euo pipefail\necho done\n"}
+	assert.NoError(t, script.IsValid())
+	assert.Equal(t, script.Inline, script.InlineScript())
+}
+
+func TestShouldFailParsingPathAndInline_PostInstallScript(t *testing.T) {
+	invalidScript := validPostInstallScript
+	invalidScript.Inline = "echo done"
+
+	err := invalidScript.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "exactly one of [Path] or [Inline] must be set", err.Error())
+}
+
+func TestShouldFailParsingNoScript_PostInstallScript(t *testing.T) {
+	var checkedScript PostInstallScript
+	err := marshalJSONString(`{"Args": "--verbose"}`, &checkedScript)
+	assert.Error(t, err)
+	assert.Equal(t, "failed to parse [PostInstallScript]: exactly one of [Path] or [Inline] must be set", err.Error())
+}
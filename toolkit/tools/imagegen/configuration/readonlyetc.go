@@ -0,0 +1,107 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Parser for the image builder's configuration schemas.
+
+package configuration
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	etcDir = "/etc"
+	// growFsMarkerDir holds the marker GrowFsOnBoot's first boot service removes once it ran
+	growFsMarkerDir = "/etc/imager"
+)
+
+// ReadOnlyEtc makes /etc read-only on an otherwise writable root, with writable overlays on chosen
+// paths under it for machine specific state.
+//   - Enable: Mount /etc read-only
+//   - WritableDirs: Directories under /etc, or /etc itself, to place a writable overlay on
+//   - OverlaySize: Size of the tmpfs holding the overlays' changes, which are lost at reboot
+//   - PersistentDir: Keep the overlays' changes under this directory instead of a tmpfs
+type ReadOnlyEtc struct {
+	Enable        bool     `json:"Enable"`
+	WritableDirs  []string `json:"WritableDirs"`
+	OverlaySize   string   `json:"OverlaySize"`
+	PersistentDir string   `json:"PersistentDir"`
+}
+
+// IsValid returns an error if the ReadOnlyEtc is not valid
+func (r *ReadOnlyEtc) IsValid() (err error) {
+	if !r.Enable {
+		if len(r.WritableDirs) != 0 || r.OverlaySize != "" || r.PersistentDir != "" {
+			return fmt.Errorf("[WritableDirs], [OverlaySize] and [PersistentDir] require [Enable] to be set")
+		}
+		return
+	}
+
+	for i, dir := range r.WritableDirs {
+		if err = overlayDirIsValid(dir); err != nil {
+			return fmt.Errorf("invalid [WritableDirs] entry (%s): %w", dir, err)
+		}
+		if !pathsOverlap(dir, etcDir) {
+			return fmt.Errorf("invalid [WritableDirs] entry (%s): must be (%s) or a directory under it", dir, etcDir)
+		}
+
+		for _, otherDir := range r.WritableDirs[i+1:] {
+			if pathsOverlap(dir, otherDir) {
+				return fmt.Errorf("invalid [WritableDirs]: (%s) and (%s) overlap", dir, otherDir)
+			}
+		}
+	}
+
+	if r.OverlaySize != "" {
+		if r.PersistentDir != "" {
+			return fmt.Errorf("[OverlaySize] only applies to tmpfs overlays and can't be used with [PersistentDir]")
+		}
+		if !overlaySizeRegex.MatchString(r.OverlaySize) {
+			return fmt.Errorf("invalid [OverlaySize] (%s), must be a size such as '512m' or a percentage of memory such as '20%%'", r.OverlaySize)
+		}
+	}
+
+	if r.PersistentDir != "" {
+		if err = overlayDirIsValid(r.PersistentDir); err != nil {
+			return fmt.Errorf("invalid [PersistentDir] (%s): %w", r.PersistentDir, err)
+		}
+		if pathsOverlap(r.PersistentDir, etcDir) {
+			return fmt.Errorf("invalid [PersistentDir] (%s): can't be on the read-only (%s)", r.PersistentDir, etcDir)
+		}
+	}
+
+	return
+}
+
+// IsWritable returns true if the path is writable at runtime, because it is under one of the WritableDirs.
+func (r *ReadOnlyEtc) IsWritable(path string) bool {
+	if !r.Enable {
+		return true
+	}
+
+	for _, dir := range r.WritableDirs {
+		if path == dir || strings.HasPrefix(path, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// UnmarshalJSON Unmarshals a ReadOnlyEtc entry
+func (r *ReadOnlyEtc) UnmarshalJSON(b []byte) (err error) {
+	// Use an intermediate type which will use the default JSON unmarshal implementation
+	type IntermediateTypeReadOnlyEtc ReadOnlyEtc
+	err = json.Unmarshal(b, (*IntermediateTypeReadOnlyEtc)(r))
+	if err != nil {
+		return fmt.Errorf("failed to parse [ReadOnlyEtc]: %w", err)
+	}
+
+	// Now validate the resulting unmarshaled object
+	err = r.IsValid()
+	if err != nil {
+		return fmt.Errorf("failed to parse [ReadOnlyEtc]: %w", err)
+	}
+	return
+}
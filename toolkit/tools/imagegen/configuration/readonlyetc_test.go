@@ -0,0 +1,65 @@
+// Copyright Microsoft Corporation.
+// Licensed under the MIT License.
+
+package configuration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//TestMain found in configuration_test.go.
+
+var (
+	validReadOnlyEtc ReadOnlyEtc = ReadOnlyEtc{
+		Enable:        true,
+		WritableDirs:  []string{"/etc/ssh", "/etc/NetworkManager"},
+		PersistentDir: "/var/lib/etc-overlays",
+	}
+	invalidReadOnlyEtcJSON = `{"Enable": true, "WritableDirs": ["/var/lib"]}`
+)
+
+func TestShouldSucceedParsingDefaultReadOnlyEtc_ReadOnlyEtc(t *testing.T) {
+	var checkedReadOnlyEtc ReadOnlyEtc
+	err := marshalJSONString("{}", &checkedReadOnlyEtc)
+	assert.NoError(t, err)
+	assert.False(t, checkedReadOnlyEtc.Enable)
+	assert.True(t, checkedReadOnlyEtc.IsWritable("/etc/ssh"))
+}
+
+func TestShouldSucceedParsingValidReadOnlyEtc_ReadOnlyEtc(t *testing.T) {
+	var checkedReadOnlyEtc ReadOnlyEtc
+	err := remarshalJSON(validReadOnlyEtc, &checkedReadOnlyEtc)
+	assert.NoError(t, err)
+	assert.Equal(t, validReadOnlyEtc, checkedReadOnlyEtc)
+
+	assert.True(t, checkedReadOnlyEtc.IsWritable("/etc/ssh"))
+	assert.True(t, checkedReadOnlyEtc.IsWritable("/etc/ssh/sshd_config.d"))
+	assert.False(t, checkedReadOnlyEtc.IsWritable("/etc/sshd"))
+	assert.False(t, checkedReadOnlyEtc.IsWritable("/etc"))
+}
+
+func TestShouldFailParsingDirOutsideEtc_ReadOnlyEtc(t *testing.T) {
+	var checkedReadOnlyEtc ReadOnlyEtc
+	err := marshalJSONString(invalidReadOnlyEtcJSON, &checkedReadOnlyEtc)
+	assert.Error(t, err)
+	assert.Equal(t, "failed to parse [ReadOnlyEtc]: invalid [WritableDirs] entry (/var/lib): must be (/etc) or a directory under it", err.Error())
+}
+
+func TestShouldFailParsingPersistentDirInEtc_ReadOnlyEtc(t *testing.T) {
+	invalidReadOnlyEtc := validReadOnlyEtc
+	invalidReadOnlyEtc.PersistentDir = "/etc/overlays"
+
+	err := invalidReadOnlyEtc.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [PersistentDir] (/etc/overlays): can't be on the read-only (/etc)", err.Error())
+}
+
+func TestShouldFailParsingSettingsWithoutEnable_ReadOnlyEtc(t *testing.T) {
+	invalidReadOnlyEtc := ReadOnlyEtc{WritableDirs: []string{"/etc/ssh"}}
+
+	err := invalidReadOnlyEtc.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "[WritableDirs], [OverlaySize] and [PersistentDir] require [Enable] to be set", err.Error())
+}
@@ -0,0 +1,121 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Parser for the image builder's configuration schemas.
+
+package configuration
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	// overlaySizeRegex matches the tmpfs size option, in bytes, with a k, m or g suffix, or as a percentage of memory
+	overlaySizeRegex = regexp.MustCompile(`^[1-9][0-9]*[kKmMgG%]?$`)
+	// overlayUnsupportedDirs are already writable pseudo filesystems
+	overlayUnsupportedDirs = []string{"/dev", "/proc", "/run", "/sys"}
+)
+
+// ReadOnlyRoot mounts the root filesystem read-only and places writable overlays on top of
+// chosen directories. Unlike ReadOnlyVerityRoot the root is not protected by a hash tree.
+//   - Enable: Mount the root read-only
+//   - WritableDirs: Directories to place a writable overlay on
+//   - OverlaySize: Size of the tmpfs holding the overlays' changes, which are lost at reboot
+//   - PersistentDir: Keep the overlays' changes under this mount point instead of a tmpfs
+type ReadOnlyRoot struct {
+	Enable        bool     `json:"Enable"`
+	WritableDirs  []string `json:"WritableDirs"`
+	OverlaySize   string   `json:"OverlaySize"`
+	PersistentDir string   `json:"PersistentDir"`
+}
+
+// IsValid returns an error if the ReadOnlyRoot is not valid
+func (r *ReadOnlyRoot) IsValid() (err error) {
+	if !r.Enable {
+		if len(r.WritableDirs) != 0 || r.OverlaySize != "" || r.PersistentDir != "" {
+			return fmt.Errorf("[WritableDirs], [OverlaySize] and [PersistentDir] require [Enable] to be set")
+		}
+		return
+	}
+
+	for i, dir := range r.WritableDirs {
+		if err = overlayDirIsValid(dir); err != nil {
+			return fmt.Errorf("invalid [WritableDirs] entry (%s): %w", dir, err)
+		}
+
+		for _, otherDir := range r.WritableDirs[i+1:] {
+			if pathsOverlap(dir, otherDir) {
+				return fmt.Errorf("invalid [WritableDirs]: (%s) and (%s) overlap", dir, otherDir)
+			}
+		}
+	}
+
+	if r.OverlaySize != "" {
+		if r.PersistentDir != "" {
+			return fmt.Errorf("[OverlaySize] only applies to tmpfs overlays and can't be used with [PersistentDir]")
+		}
+		if !overlaySizeRegex.MatchString(r.OverlaySize) {
+			return fmt.Errorf("invalid [OverlaySize] (%s), must be a size such as '512m' or a percentage of memory such as '20%%'", r.OverlaySize)
+		}
+	}
+
+	if r.PersistentDir != "" {
+		if err = overlayDirIsValid(r.PersistentDir); err != nil {
+			return fmt.Errorf("invalid [PersistentDir] (%s): %w", r.PersistentDir, err)
+		}
+
+		for _, dir := range r.WritableDirs {
+			if pathsOverlap(dir, r.PersistentDir) {
+				return fmt.Errorf("invalid [PersistentDir]: (%s) overlaps the writable directory (%s)", r.PersistentDir, dir)
+			}
+		}
+	}
+
+	return
+}
+
+// overlayDirIsValid checks a directory can be used in the overlay mount options.
+func overlayDirIsValid(dir string) (err error) {
+	if !filepath.IsAbs(dir) || filepath.Clean(dir) != dir || dir == "/" {
+		return fmt.Errorf("must be a clean absolute path other than '/'")
+	}
+
+	// The overlay mount options are separated by commas and colons
+	if strings.ContainsAny(dir, ",: \t\n\"'\\") {
+		return fmt.Errorf("may not contain commas, colons, quotes, backslashes or whitespace")
+	}
+
+	for _, unsupportedDir := range overlayUnsupportedDirs {
+		if pathsOverlap(dir, unsupportedDir) {
+			return fmt.Errorf("is on the already writable (%s)", unsupportedDir)
+		}
+	}
+
+	return
+}
+
+// pathsOverlap returns true if the paths are the same or one contains the other.
+func pathsOverlap(first, second string) bool {
+	return first == second || strings.HasPrefix(first, second+"/") || strings.HasPrefix(second, first+"/")
+}
+
+// UnmarshalJSON Unmarshals a ReadOnlyRoot entry
+func (r *ReadOnlyRoot) UnmarshalJSON(b []byte) (err error) {
+	// Use an intermediate type which will use the default JSON unmarshal implementation
+	type IntermediateTypeReadOnlyRoot ReadOnlyRoot
+	err = json.Unmarshal(b, (*IntermediateTypeReadOnlyRoot)(r))
+	if err != nil {
+		return fmt.Errorf("failed to parse [ReadOnlyRoot]: %w", err)
+	}
+
+	// Now validate the resulting unmarshaled object
+	err = r.IsValid()
+	if err != nil {
+		return fmt.Errorf("failed to parse [ReadOnlyRoot]: %w", err)
+	}
+	return
+}
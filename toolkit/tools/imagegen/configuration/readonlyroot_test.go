@@ -0,0 +1,89 @@
+// Copyright Microsoft Corporation.
+// Licensed under the MIT License.
+
+package configuration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//TestMain found in configuration_test.go.
+
+var (
+	validReadOnlyRoot ReadOnlyRoot = ReadOnlyRoot{
+		Enable:       true,
+		WritableDirs: []string{"/etc", "/var", "/home"},
+		OverlaySize:  "20%",
+	}
+	invalidReadOnlyRootJSON = `{"WritableDirs": ["/var"]}`
+)
+
+func TestShouldSucceedParsingValidReadOnlyRoot_ReadOnlyRoot(t *testing.T) {
+	var checkedReadOnlyRoot ReadOnlyRoot
+	err := remarshalJSON(validReadOnlyRoot, &checkedReadOnlyRoot)
+	assert.NoError(t, err)
+	assert.Equal(t, validReadOnlyRoot, checkedReadOnlyRoot)
+}
+
+func TestShouldSucceedParsingPersistentReadOnlyRoot_ReadOnlyRoot(t *testing.T) {
+	persistentReadOnlyRoot := validReadOnlyRoot
+	persistentReadOnlyRoot.OverlaySize = ""
+	persistentReadOnlyRoot.PersistentDir = "/data"
+
+	assert.NoError(t, persistentReadOnlyRoot.IsValid())
+}
+
+func TestShouldFailParsingSettingsWithoutEnable_ReadOnlyRoot(t *testing.T) {
+	var checkedReadOnlyRoot ReadOnlyRoot
+	err := marshalJSONString(invalidReadOnlyRootJSON, &checkedReadOnlyRoot)
+	assert.Error(t, err)
+	assert.Equal(t, "failed to parse [ReadOnlyRoot]: [WritableDirs], [OverlaySize] and [PersistentDir] require [Enable] to be set", err.Error())
+}
+
+func TestShouldFailParsingRelativeWritableDir_ReadOnlyRoot(t *testing.T) {
+	invalidReadOnlyRoot := validReadOnlyRoot
+	invalidReadOnlyRoot.WritableDirs = []string{"var/lib"}
+
+	err := invalidReadOnlyRoot.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [WritableDirs] entry (var/lib): must be a clean absolute path other than '/'", err.Error())
+}
+
+func TestShouldFailParsingOverlappingWritableDirs_ReadOnlyRoot(t *testing.T) {
+	invalidReadOnlyRoot := validReadOnlyRoot
+	invalidReadOnlyRoot.WritableDirs = []string{"/var", "/etc", "/var/lib/docker"}
+
+	err := invalidReadOnlyRoot.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [WritableDirs]: (/var) and (/var/lib/docker) overlap", err.Error())
+}
+
+func TestShouldFailParsingPseudoFilesystemWritableDir_ReadOnlyRoot(t *testing.T) {
+	invalidReadOnlyRoot := validReadOnlyRoot
+	invalidReadOnlyRoot.WritableDirs = []string{"/run/user"}
+
+	err := invalidReadOnlyRoot.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [WritableDirs] entry (/run/user): is on the already writable (/run)", err.Error())
+}
+
+func TestShouldFailParsingOverlaySizeWithPersistentDir_ReadOnlyRoot(t *testing.T) {
+	invalidReadOnlyRoot := validReadOnlyRoot
+	invalidReadOnlyRoot.PersistentDir = "/data"
+
+	err := invalidReadOnlyRoot.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "[OverlaySize] only applies to tmpfs overlays and can't be used with [PersistentDir]", err.Error())
+}
+
+func TestShouldFailParsingPersistentDirInsideWritableDir_ReadOnlyRoot(t *testing.T) {
+	invalidReadOnlyRoot := validReadOnlyRoot
+	invalidReadOnlyRoot.OverlaySize = ""
+	invalidReadOnlyRoot.PersistentDir = "/var/persistent"
+
+	err := invalidReadOnlyRoot.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [PersistentDir]: (/var/persistent) overlaps the writable directory (/var)", err.Error())
+}
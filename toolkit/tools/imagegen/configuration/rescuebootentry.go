@@ -0,0 +1,87 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Parser for the image builder's configuration schemas.
+
+package configuration
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	// DefaultRescueTitle is the grub menu title used when RescueBootEntry.Title is not set
+	DefaultRescueTitle = "CBL-Mariner (rescue)"
+	// DefaultRescueCommandLine is used when RescueBootEntry.CommandLine is not set
+	DefaultRescueCommandLine = "systemd.unit=emergency.target"
+)
+
+// RescueBootEntry adds a second grub menu entry which boots the same kernel
+// and initramfs with additional kernel parameters, for servicing the system.
+//   - Enable: Add the rescue entry after the default entry
+//   - Title: The grub menu title of the entry
+//   - CommandLine: Parameters appended to the default entry's command line
+//   - MenuTimeout: Seconds to show the grub menu for, so the entry can be selected
+type RescueBootEntry struct {
+	Enable      bool   `json:"Enable"`
+	Title       string `json:"Title"`
+	CommandLine string `json:"CommandLine"`
+	MenuTimeout uint   `json:"MenuTimeout"`
+}
+
+// GetTitle returns the menu title of the entry, or the default title.
+func (r *RescueBootEntry) GetTitle() string {
+	if r.Title == "" {
+		return DefaultRescueTitle
+	}
+	return r.Title
+}
+
+// GetCommandLine returns the extra kernel parameters of the entry, or the default parameters.
+func (r *RescueBootEntry) GetCommandLine() string {
+	if r.CommandLine == "" {
+		return DefaultRescueCommandLine
+	}
+	return r.CommandLine
+}
+
+// IsValid returns an error if the RescueBootEntry is not valid
+func (r *RescueBootEntry) IsValid() (err error) {
+	if !r.Enable {
+		if r.Title != "" || r.CommandLine != "" || r.MenuTimeout != 0 {
+			return fmt.Errorf("[Title], [CommandLine] and [MenuTimeout] require [Enable] to be set")
+		}
+		return
+	}
+
+	if strings.ContainsAny(r.Title, "\"\n") {
+		return fmt.Errorf("invalid [Title] (%s), may not contain quotes or line breaks", r.Title)
+	}
+
+	// The command line is placed in grub.cfg, which is later edited with sed
+	var cmdline KernelCommandLine
+	if strings.ContainsAny(r.CommandLine, "\n"+cmdline.GetSedDelimeter()) {
+		return fmt.Errorf("invalid [CommandLine] (%s), may not contain line breaks or the character %s", r.CommandLine, cmdline.GetSedDelimeter())
+	}
+
+	return
+}
+
+// UnmarshalJSON Unmarshals a RescueBootEntry entry
+func (r *RescueBootEntry) UnmarshalJSON(b []byte) (err error) {
+	// Use an intermediate type which will use the default JSON unmarshal implementation
+	type IntermediateTypeRescueBootEntry RescueBootEntry
+	err = json.Unmarshal(b, (*IntermediateTypeRescueBootEntry)(r))
+	if err != nil {
+		return fmt.Errorf("failed to parse [RescueBootEntry]: %w", err)
+	}
+
+	// Now validate the resulting unmarshaled object
+	err = r.IsValid()
+	if err != nil {
+		return fmt.Errorf("failed to parse [RescueBootEntry]: %w", err)
+	}
+	return
+}
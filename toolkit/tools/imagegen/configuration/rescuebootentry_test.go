@@ -0,0 +1,62 @@
+// Copyright Microsoft Corporation.
+// Licensed under the MIT License.
+
+package configuration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//TestMain found in configuration_test.go.
+
+var (
+	validRescueBootEntry RescueBootEntry = RescueBootEntry{
+		Enable:      true,
+		Title:       "Appliance recovery",
+		CommandLine: "rd.break=pre-mount console=ttyS0",
+		MenuTimeout: 5,
+	}
+	invalidRescueBootEntryJSON = `{"Title": "Appliance recovery"}`
+)
+
+func TestShouldSucceedParsingDefaultRescueBootEntry_RescueBootEntry(t *testing.T) {
+	var checkedRescueBootEntry RescueBootEntry
+	err := marshalJSONString(`{"Enable": true}`, &checkedRescueBootEntry)
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultRescueTitle, checkedRescueBootEntry.GetTitle())
+	assert.Equal(t, DefaultRescueCommandLine, checkedRescueBootEntry.GetCommandLine())
+}
+
+func TestShouldSucceedParsingValidRescueBootEntry_RescueBootEntry(t *testing.T) {
+	var checkedRescueBootEntry RescueBootEntry
+	err := remarshalJSON(validRescueBootEntry, &checkedRescueBootEntry)
+	assert.NoError(t, err)
+	assert.Equal(t, validRescueBootEntry, checkedRescueBootEntry)
+}
+
+func TestShouldFailParsingSettingsWithoutEnable_RescueBootEntry(t *testing.T) {
+	var checkedRescueBootEntry RescueBootEntry
+	err := marshalJSONString(invalidRescueBootEntryJSON, &checkedRescueBootEntry)
+	assert.Error(t, err)
+	assert.Equal(t, "failed to parse [RescueBootEntry]: [Title], [CommandLine] and [MenuTimeout] require [Enable] to be set", err.Error())
+}
+
+func TestShouldFailParsingQuotedTitle_RescueBootEntry(t *testing.T) {
+	invalidRescueBootEntry := validRescueBootEntry
+	invalidRescueBootEntry.Title = `Say "rescue"`
+
+	err := invalidRescueBootEntry.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, `invalid [Title] (Say "rescue"), may not contain quotes or line breaks`, err.Error())
+}
+
+func TestShouldFailParsingSedDelimiterInCommandLine_RescueBootEntry(t *testing.T) {
+	invalidRescueBootEntry := validRescueBootEntry
+	invalidRescueBootEntry.CommandLine = "init=`sh`"
+
+	err := invalidRescueBootEntry.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [CommandLine] (init=`sh`), may not contain line breaks or the character `", err.Error())
+}
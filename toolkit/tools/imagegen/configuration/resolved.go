@@ -0,0 +1,128 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Parser for the image builder's configuration schemas.
+
+package configuration
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// ResolvConfStub points /etc/resolv.conf at systemd-resolved's local stub resolver
+	ResolvConfStub = "stub"
+	// ResolvConfUplink points /etc/resolv.conf at the upstream DNS servers systemd-resolved knows about
+	ResolvConfUplink = "uplink"
+)
+
+var (
+	// resolvedDNSSECModes are the values resolved.conf accepts for DNSSEC
+	resolvedDNSSECModes = map[string]bool{"yes": true, "no": true, "allow-downgrade": true}
+	// resolvedDNSOverTLSModes are the values resolved.conf accepts for DNSOverTLS
+	resolvedDNSOverTLSModes = map[string]bool{"yes": true, "no": true, "opportunistic": true}
+	// resolvedDomainRegex matches a search domain, or a routing-only domain when prefixed with '~'
+	resolvedDomainRegex = regexp.MustCompile(`^~?([A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?)*|\.)$`)
+)
+
+// Resolved holds the systemd-resolved settings written to a resolved.conf drop-in.
+//   - DNS: DNS servers, as an IP address with an optional ":port" and "#name" for DNS over TLS
+//   - FallbackDNS: DNS servers used when no other server is known, in the same format as DNS
+//   - Domains: Search domains, a '~' prefix only routes the domain's queries to the DNS servers
+//   - DNSSEC: "yes", "no" or "allow-downgrade"
+//   - DNSOverTLS: "yes", "no" or "opportunistic"
+//   - ResolvConf: Where /etc/resolv.conf points, "stub" or "uplink". Left as is when unset
+type Resolved struct {
+	DNS         []string `json:"DNS"`
+	FallbackDNS []string `json:"FallbackDNS"`
+	Domains     []string `json:"Domains"`
+	DNSSEC      string   `json:"DNSSEC"`
+	DNSOverTLS  string   `json:"DNSOverTLS"`
+	ResolvConf  string   `json:"ResolvConf"`
+}
+
+// IsEmpty returns true if no systemd-resolved setting is set
+func (r *Resolved) IsEmpty() bool {
+	return len(r.DNS) == 0 && len(r.FallbackDNS) == 0 && len(r.Domains) == 0 && r.DNSSEC == "" && r.DNSOverTLS == "" && r.ResolvConf == ""
+}
+
+// IsValid returns an error if the Resolved is not valid
+func (r *Resolved) IsValid() (err error) {
+	for name, servers := range map[string][]string{"DNS": r.DNS, "FallbackDNS": r.FallbackDNS} {
+		for _, server := range servers {
+			if err = resolvedServerIsValid(server); err != nil {
+				return fmt.Errorf("invalid [%s] server (%s): %w", name, server, err)
+			}
+		}
+	}
+
+	for _, domain := range r.Domains {
+		if !resolvedDomainRegex.MatchString(domain) {
+			return fmt.Errorf("invalid [Domains] entry (%s), must be a domain name with an optional '~' prefix", domain)
+		}
+	}
+
+	if r.DNSSEC != "" && !resolvedDNSSECModes[r.DNSSEC] {
+		return fmt.Errorf("invalid [DNSSEC] (%s), must be one of 'yes', 'no' or 'allow-downgrade'", r.DNSSEC)
+	}
+
+	if r.DNSOverTLS != "" && !resolvedDNSOverTLSModes[r.DNSOverTLS] {
+		return fmt.Errorf("invalid [DNSOverTLS] (%s), must be one of 'yes', 'no' or 'opportunistic'", r.DNSOverTLS)
+	}
+
+	if r.ResolvConf != "" && r.ResolvConf != ResolvConfStub && r.ResolvConf != ResolvConfUplink {
+		return fmt.Errorf("invalid [ResolvConf] (%s), must be one of '%s' or '%s'", r.ResolvConf, ResolvConfStub, ResolvConfUplink)
+	}
+
+	return
+}
+
+// resolvedServerIsValid checks a DNS server in the "address[:port][#name]" format resolved.conf accepts.
+// IPv6 addresses with a port must be written in brackets, such as "[2001:db8::1]:853".
+func resolvedServerIsValid(server string) (err error) {
+	address := server
+	if i := strings.Index(server, "#"); i != -1 {
+		address = server[:i]
+		if !resolvedDomainRegex.MatchString(server[i+1:]) || strings.HasPrefix(server[i+1:], "~") {
+			return fmt.Errorf("(%s) is not a valid server name", server[i+1:])
+		}
+	}
+
+	if net.ParseIP(address) != nil {
+		return
+	}
+
+	host, port, splitErr := net.SplitHostPort(address)
+	if splitErr != nil || net.ParseIP(host) == nil {
+		return fmt.Errorf("must be an IP address")
+	}
+
+	portNumber, convErr := strconv.Atoi(port)
+	if convErr != nil || portNumber < 1 || portNumber > 65535 {
+		return fmt.Errorf("(%s) is not a valid port", port)
+	}
+
+	return
+}
+
+// UnmarshalJSON Unmarshals a Resolved entry
+func (r *Resolved) UnmarshalJSON(b []byte) (err error) {
+	// Use an intermediate type which will use the default JSON unmarshal implementation
+	type IntermediateTypeResolved Resolved
+	err = json.Unmarshal(b, (*IntermediateTypeResolved)(r))
+	if err != nil {
+		return fmt.Errorf("failed to parse [Resolved]: %w", err)
+	}
+
+	// Now validate the resulting unmarshaled object
+	err = r.IsValid()
+	if err != nil {
+		return fmt.Errorf("failed to parse [Resolved]: %w", err)
+	}
+	return
+}
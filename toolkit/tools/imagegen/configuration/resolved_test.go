@@ -0,0 +1,77 @@
+// Copyright Microsoft Corporation.
+// Licensed under the MIT License.
+
+package configuration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//TestMain found in configuration_test.go.
+
+var (
+	validResolved Resolved = Resolved{
+		DNS:         []string{"10.0.0.53", "2001:db8::53", "1.1.1.1:853#cloudflare-dns.com", "[2001:db8::54]:853"},
+		FallbackDNS: []string{"9.9.9.9"},
+		Domains:     []string{"corp.example.com", "~."},
+		DNSSEC:      "allow-downgrade",
+		DNSOverTLS:  "opportunistic",
+		ResolvConf:  "stub",
+	}
+	invalidResolvedJSON = `{"DNSSEC": "strict"}`
+)
+
+func TestShouldSucceedParsingDefaultResolved_Resolved(t *testing.T) {
+	var checkedResolved Resolved
+	err := marshalJSONString("{}", &checkedResolved)
+	assert.NoError(t, err)
+	assert.True(t, checkedResolved.IsEmpty())
+}
+
+func TestShouldSucceedParsingValidResolved_Resolved(t *testing.T) {
+	var checkedResolved Resolved
+	err := remarshalJSON(validResolved, &checkedResolved)
+	assert.NoError(t, err)
+	assert.Equal(t, validResolved, checkedResolved)
+	assert.False(t, checkedResolved.IsEmpty())
+}
+
+func TestShouldFailParsingInvalidDNSSEC_Resolved(t *testing.T) {
+	var checkedResolved Resolved
+	err := marshalJSONString(invalidResolvedJSON, &checkedResolved)
+	assert.Error(t, err)
+	assert.Equal(t, "failed to parse [Resolved]: invalid [DNSSEC] (strict), must be one of 'yes', 'no' or 'allow-downgrade'", err.Error())
+}
+
+func TestShouldFailParsingInvalidServers_Resolved(t *testing.T) {
+	invalidResolved := Resolved{DNS: []string{"dns.example.com"}}
+	err := invalidResolved.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [DNS] server (dns.example.com): must be an IP address", err.Error())
+
+	invalidResolved.DNS = []string{"10.0.0.53:99999"}
+	err = invalidResolved.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [DNS] server (10.0.0.53:99999): (99999) is not a valid port", err.Error())
+
+	invalidResolved.DNS = []string{"10.0.0.53#bad name"}
+	err = invalidResolved.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [DNS] server (10.0.0.53#bad name): (bad name) is not a valid server name", err.Error())
+}
+
+func TestShouldFailParsingInvalidDomain_Resolved(t *testing.T) {
+	invalidResolved := Resolved{Domains: []string{"-corp.example.com"}}
+	err := invalidResolved.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [Domains] entry (-corp.example.com), must be a domain name with an optional '~' prefix", err.Error())
+}
+
+func TestShouldFailParsingInvalidResolvConf_Resolved(t *testing.T) {
+	invalidResolved := Resolved{ResolvConf: "static"}
+	err := invalidResolved.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [ResolvConf] (static), must be one of 'stub' or 'uplink'", err.Error())
+}
@@ -0,0 +1,64 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Parser for the image builder's configuration schemas.
+
+package configuration
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// SbomFormat sets the format of the software bill of materials generated for the image
+type SbomFormat string
+
+const (
+	// SbomFormatNone does not generate a software bill of materials
+	SbomFormatNone SbomFormat = ""
+	// SbomFormatSPDX generates an SPDX 2.3 JSON document
+	SbomFormatSPDX SbomFormat = "spdx"
+	// SbomFormatCycloneDX generates a CycloneDX 1.4 JSON document
+	SbomFormatCycloneDX SbomFormat = "cyclonedx"
+)
+
+func (s SbomFormat) String() string {
+	return fmt.Sprint(string(s))
+}
+
+// GetValidSbomFormats returns a list of all the supported
+// software bill of materials formats
+func (s *SbomFormat) GetValidSbomFormats() (types []SbomFormat) {
+	return []SbomFormat{
+		SbomFormatNone,
+		SbomFormatSPDX,
+		SbomFormatCycloneDX,
+	}
+}
+
+// IsValid returns an error if the SbomFormat is not valid
+func (s *SbomFormat) IsValid() (err error) {
+	for _, valid := range s.GetValidSbomFormats() {
+		if *s == valid {
+			return
+		}
+	}
+	return fmt.Errorf("invalid value for SbomFormat (%s)", s)
+}
+
+// UnmarshalJSON Unmarshals an SbomFormat entry
+func (s *SbomFormat) UnmarshalJSON(b []byte) (err error) {
+	// Use an intermediate type which will use the default JSON unmarshal implementation
+	type IntermediateTypeSbomFormat SbomFormat
+	err = json.Unmarshal(b, (*IntermediateTypeSbomFormat)(s))
+	if err != nil {
+		return fmt.Errorf("failed to parse [SbomFormat]: %w", err)
+	}
+
+	// Now validate the resulting unmarshaled object
+	err = s.IsValid()
+	if err != nil {
+		return fmt.Errorf("failed to parse [SbomFormat]: %w", err)
+	}
+	return
+}
@@ -0,0 +1,77 @@
+// Copyright Microsoft Corporation.
+// Licensed under the MIT License.
+
+package configuration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMain found in configuration_test.go.
+
+var (
+	validSbomFormats = []SbomFormat{
+		SbomFormat(""),
+		SbomFormat("spdx"),
+		SbomFormat("cyclonedx"),
+	}
+	invalidSbomFormat     = SbomFormat("swid")
+	validSbomFormatJSON   = `"spdx"`
+	invalidSbomFormatJSON = `1234`
+)
+
+func TestShouldSucceedValidSbomFormatMatch_SbomFormat(t *testing.T) {
+	var s SbomFormat
+	assert.Equal(t, len(validSbomFormats), len(s.GetValidSbomFormats()))
+
+	for _, format := range validSbomFormats {
+		found := false
+		for _, validFormat := range s.GetValidSbomFormats() {
+			if format == validFormat {
+				found = true
+			}
+		}
+		assert.True(t, found)
+	}
+}
+
+func TestShouldSucceedParsingValidSbomFormat_SbomFormat(t *testing.T) {
+	for _, validFormat := range validSbomFormats {
+		var checkedFormat SbomFormat
+
+		assert.NoError(t, validFormat.IsValid())
+		err := remarshalJSON(validFormat, &checkedFormat)
+		assert.NoError(t, err)
+		assert.Equal(t, validFormat, checkedFormat)
+	}
+}
+
+func TestShouldFailParsingInvalidSbomFormat_SbomFormat(t *testing.T) {
+	var checkedFormat SbomFormat
+
+	err := invalidSbomFormat.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid value for SbomFormat (swid)", err.Error())
+
+	err = remarshalJSON(invalidSbomFormat, &checkedFormat)
+	assert.Error(t, err)
+	assert.Equal(t, "failed to parse [SbomFormat]: invalid value for SbomFormat (swid)", err.Error())
+}
+
+func TestShouldSucceedParsingValidJSON_SbomFormat(t *testing.T) {
+	var checkedFormat SbomFormat
+
+	err := marshalJSONString(validSbomFormatJSON, &checkedFormat)
+	assert.NoError(t, err)
+	assert.Equal(t, SbomFormatSPDX, checkedFormat)
+}
+
+func TestShouldFailParsingInvalidJSON_SbomFormat(t *testing.T) {
+	var checkedFormat SbomFormat
+
+	err := marshalJSONString(invalidSbomFormatJSON, &checkedFormat)
+	assert.Error(t, err)
+	assert.Equal(t, "failed to parse [SbomFormat]: json: cannot unmarshal number into Go value of type configuration.IntermediateTypeSbomFormat", err.Error())
+}
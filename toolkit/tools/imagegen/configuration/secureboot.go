@@ -0,0 +1,99 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Parser for the image builder's configuration schemas.
+
+package configuration
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	// ShimPackage installs shim onto the ESP as the first stage UEFI bootloader
+	ShimPackage = "shim"
+	// GrubEfiPackage installs the grub UEFI binary shim loads
+	GrubEfiPackage = "grub2-efi-binary"
+	// MokutilPackage provides mokutil, which requests the enrollment of Machine Owner Keys
+	MokutilPackage = "mokutil"
+)
+
+// SecureBoot prepares an "efi" image for UEFI Secure Boot: the firmware verifies shim against its db,
+// then shim verifies grub and the kernel against its own keys and the Machine Owner Keys (MOK).
+//   - Enable: Install shim and grub onto the ESP
+//   - ShimSource: Signed shim binary on the build host, replacing the one of the shim package
+//   - GrubSource: Signed grub binary on the build host, replacing the one of the grub2-efi-binary package
+//   - MokCertificates: DER or PEM certificates on the build host, staged for enrollment as MOKs at first boot
+//   - MokPassword: One-time password confirming the enrollment in MokManager, root's password if empty
+type SecureBoot struct {
+	Enable          bool     `json:"Enable"`
+	ShimSource      string   `json:"ShimSource"`
+	GrubSource      string   `json:"GrubSource"`
+	MokCertificates []string `json:"MokCertificates"`
+	MokPassword     string   `json:"MokPassword"`
+}
+
+// GetPackages returns the packages installed into the image for Secure Boot
+func (s *SecureBoot) GetPackages() (packages []string) {
+	if !s.Enable {
+		return
+	}
+
+	packages = []string{ShimPackage, GrubEfiPackage}
+	if len(s.MokCertificates) != 0 {
+		packages = append(packages, MokutilPackage)
+	}
+	return
+}
+
+// IsValid returns an error if the SecureBoot is not valid
+func (s *SecureBoot) IsValid() (err error) {
+	if !s.Enable {
+		if s.ShimSource != "" || s.GrubSource != "" || len(s.MokCertificates) != 0 || s.MokPassword != "" {
+			return fmt.Errorf("[ShimSource], [GrubSource], [MokCertificates] and [MokPassword] require [Enable] to be set")
+		}
+		return
+	}
+
+	if s.MokPassword != "" {
+		if len(s.MokCertificates) == 0 {
+			return fmt.Errorf("[MokPassword] requires [MokCertificates]")
+		}
+		// MokManager only accepts passwords of 1 to 256 characters
+		if len(s.MokPassword) > 256 || strings.ContainsAny(s.MokPassword, "\r\n") {
+			return fmt.Errorf("invalid [MokPassword], must be at most 256 characters on a single line")
+		}
+	}
+
+	certificates := make(map[string]bool)
+	for _, certificate := range s.MokCertificates {
+		if certificate == "" {
+			return fmt.Errorf("invalid [MokCertificates]: certificate path may not be empty")
+		}
+		if certificates[certificate] {
+			return fmt.Errorf("invalid [MokCertificates]: (%s) is listed more than once", certificate)
+		}
+		certificates[certificate] = true
+	}
+
+	return
+}
+
+// UnmarshalJSON Unmarshals a SecureBoot entry
+func (s *SecureBoot) UnmarshalJSON(b []byte) (err error) {
+	// Use an intermediate type which will use the default JSON unmarshal implementation
+	type IntermediateTypeSecureBoot SecureBoot
+	err = json.Unmarshal(b, (*IntermediateTypeSecureBoot)(s))
+	if err != nil {
+		return fmt.Errorf("failed to parse [SecureBoot]: %w", err)
+	}
+
+	// Now validate the resulting unmarshaled object
+	err = s.IsValid()
+	if err != nil {
+		return fmt.Errorf("failed to parse [SecureBoot]: %w", err)
+	}
+	return
+}
@@ -0,0 +1,72 @@
+// Copyright Microsoft Corporation.
+// Licensed under the MIT License.
+
+package configuration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//TestMain found in configuration_test.go.
+
+var (
+	validSecureBoot SecureBoot = SecureBoot{
+		Enable:          true,
+		GrubSource:      "signed/grubx64.efi",
+		MokCertificates: []string{"keys/contoso.der"},
+		MokPassword:     "enroll-me",
+	}
+	invalidSecureBootJSON = `{"MokCertificates": ["keys/contoso.der"]}`
+)
+
+func TestShouldSucceedParsingDefaultSecureBoot_SecureBoot(t *testing.T) {
+	var checkedSecureBoot SecureBoot
+	err := marshalJSONString("{}", &checkedSecureBoot)
+	assert.NoError(t, err)
+	assert.Empty(t, checkedSecureBoot.GetPackages())
+}
+
+func TestShouldSucceedParsingValidSecureBoot_SecureBoot(t *testing.T) {
+	var checkedSecureBoot SecureBoot
+	err := remarshalJSON(validSecureBoot, &checkedSecureBoot)
+	assert.NoError(t, err)
+	assert.Equal(t, validSecureBoot, checkedSecureBoot)
+}
+
+func TestShouldFailParsingSettingsWithoutEnable_SecureBoot(t *testing.T) {
+	var checkedSecureBoot SecureBoot
+	err := marshalJSONString(invalidSecureBootJSON, &checkedSecureBoot)
+	assert.Error(t, err)
+	assert.Equal(t, "failed to parse [SecureBoot]: [ShimSource], [GrubSource], [MokCertificates] and [MokPassword] require [Enable] to be set", err.Error())
+}
+
+func TestShouldFailParsingPasswordWithoutCertificates_SecureBoot(t *testing.T) {
+	invalidSecureBoot := SecureBoot{
+		Enable:      true,
+		MokPassword: "enroll-me",
+	}
+
+	err := invalidSecureBoot.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "[MokPassword] requires [MokCertificates]", err.Error())
+}
+
+func TestShouldFailParsingDuplicateCertificate_SecureBoot(t *testing.T) {
+	invalidSecureBoot := SecureBoot{
+		Enable:          true,
+		MokCertificates: []string{"keys/contoso.der", "keys/contoso.der"},
+	}
+
+	err := invalidSecureBoot.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [MokCertificates]: (keys/contoso.der) is listed more than once", err.Error())
+}
+
+func TestShouldGetPackages_SecureBoot(t *testing.T) {
+	secureBoot := SecureBoot{Enable: true}
+	assert.Equal(t, []string{ShimPackage, GrubEfiPackage}, secureBoot.GetPackages())
+
+	assert.Equal(t, []string{ShimPackage, GrubEfiPackage, MokutilPackage}, validSecureBoot.GetPackages())
+}
@@ -0,0 +1,102 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Parser for the image builder's configuration schemas.
+
+package configuration
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	// sudoersAll grants every command, or allows running as any user
+	sudoersAll = "ALL"
+)
+
+var (
+	// sudoersNameRegex matches the user and group names sudoers accepts without quoting
+	sudoersNameRegex = regexp.MustCompile(`^[a-z_][a-z0-9_.-]*\$?$`)
+)
+
+// SudoersRule grants a user or group the use of sudo, written into a drop-in under /etc/sudoers.d.
+//   - User: The user the rule applies to
+//   - Group: The group the rule applies to, instead of a user
+//   - RunAs: The user the commands may be run as, defaults to ALL
+//   - Commands: Absolute paths of the commands, with optional arguments, defaults to ALL
+//   - NoPassword: Don't ask for the user's password
+type SudoersRule struct {
+	User       string   `json:"User"`
+	Group      string   `json:"Group"`
+	RunAs      string   `json:"RunAs"`
+	Commands   []string `json:"Commands"`
+	NoPassword bool     `json:"NoPassword"`
+}
+
+// GetRunAs returns the user the commands may be run as, or ALL.
+func (s *SudoersRule) GetRunAs() string {
+	if s.RunAs == "" {
+		return sudoersAll
+	}
+	return s.RunAs
+}
+
+// GetCommands returns the commands the rule grants, or ALL.
+func (s *SudoersRule) GetCommands() []string {
+	if len(s.Commands) == 0 {
+		return []string{sudoersAll}
+	}
+	return s.Commands
+}
+
+// IsValid returns an error if the SudoersRule is not valid
+func (s *SudoersRule) IsValid() (err error) {
+	if (s.User == "") == (s.Group == "") {
+		return fmt.Errorf("exactly one of [User] and [Group] must be set")
+	}
+
+	for _, name := range []string{s.User, s.Group} {
+		if name != "" && !sudoersNameRegex.MatchString(name) {
+			return fmt.Errorf("invalid user or group name (%s)", name)
+		}
+	}
+
+	if s.RunAs != "" && s.RunAs != sudoersAll && !sudoersNameRegex.MatchString(s.RunAs) {
+		return fmt.Errorf("invalid [RunAs] (%s), must be a user name or ALL", s.RunAs)
+	}
+
+	for _, command := range s.Commands {
+		// Commas separate commands in a sudoers rule, and a line break would start a new rule
+		if strings.ContainsAny(command, ",\n\\") {
+			return fmt.Errorf("invalid [Commands] entry (%s), may not contain commas, backslashes or line breaks", command)
+		}
+
+		path := strings.Fields(command)
+		if command != sudoersAll && (len(path) == 0 || !filepath.IsAbs(path[0])) {
+			return fmt.Errorf("invalid [Commands] entry (%s), must start with an absolute path or be ALL", command)
+		}
+	}
+
+	return
+}
+
+// UnmarshalJSON Unmarshals a SudoersRule entry
+func (s *SudoersRule) UnmarshalJSON(b []byte) (err error) {
+	// Use an intermediate type which will use the default JSON unmarshal implementation
+	type IntermediateTypeSudoersRule SudoersRule
+	err = json.Unmarshal(b, (*IntermediateTypeSudoersRule)(s))
+	if err != nil {
+		return fmt.Errorf("failed to parse [SudoersRule]: %w", err)
+	}
+
+	// Now validate the resulting unmarshaled object
+	err = s.IsValid()
+	if err != nil {
+		return fmt.Errorf("failed to parse [SudoersRule]: %w", err)
+	}
+	return
+}
@@ -0,0 +1,71 @@
+// Copyright Microsoft Corporation.
+// Licensed under the MIT License.
+
+package configuration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//TestMain found in configuration_test.go.
+
+var (
+	validSudoersRule SudoersRule = SudoersRule{
+		User:       "operator",
+		RunAs:      "root",
+		Commands:   []string{"/usr/bin/systemctl restart kiosk.service", "/usr/sbin/reboot"},
+		NoPassword: true,
+	}
+	invalidSudoersRuleJSON = `{"User": "operator", "Group": "wheel"}`
+)
+
+func TestShouldSucceedParsingValidSudoersRule_SudoersRule(t *testing.T) {
+	var checkedSudoersRule SudoersRule
+	err := remarshalJSON(validSudoersRule, &checkedSudoersRule)
+	assert.NoError(t, err)
+	assert.Equal(t, validSudoersRule, checkedSudoersRule)
+}
+
+func TestShouldDefaultToAll_SudoersRule(t *testing.T) {
+	var checkedSudoersRule SudoersRule
+	err := marshalJSONString(`{"Group": "wheel"}`, &checkedSudoersRule)
+	assert.NoError(t, err)
+	assert.Equal(t, "ALL", checkedSudoersRule.GetRunAs())
+	assert.Equal(t, []string{"ALL"}, checkedSudoersRule.GetCommands())
+}
+
+func TestShouldFailParsingUserAndGroup_SudoersRule(t *testing.T) {
+	var checkedSudoersRule SudoersRule
+	err := marshalJSONString(invalidSudoersRuleJSON, &checkedSudoersRule)
+	assert.Error(t, err)
+	assert.Equal(t, "failed to parse [SudoersRule]: exactly one of [User] and [Group] must be set", err.Error())
+}
+
+func TestShouldFailParsingRelativeCommand_SudoersRule(t *testing.T) {
+	invalidSudoersRule := validSudoersRule
+	invalidSudoersRule.Commands = []string{"systemctl restart kiosk.service"}
+
+	err := invalidSudoersRule.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [Commands] entry (systemctl restart kiosk.service), must start with an absolute path or be ALL", err.Error())
+}
+
+func TestShouldFailParsingCommandWithComma_SudoersRule(t *testing.T) {
+	invalidSudoersRule := validSudoersRule
+	invalidSudoersRule.Commands = []string{"/usr/bin/ls, ALL"}
+
+	err := invalidSudoersRule.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [Commands] entry (/usr/bin/ls, ALL), may not contain commas, backslashes or line breaks", err.Error())
+}
+
+func TestShouldFailParsingInvalidUser_SudoersRule(t *testing.T) {
+	invalidSudoersRule := validSudoersRule
+	invalidSudoersRule.User = "ALL"
+
+	err := invalidSudoersRule.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid user or group name (ALL)", err.Error())
+}
@@ -8,29 +8,129 @@ package configuration
 import (
 	"encoding/json"
 	"fmt"
+	"net"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 
 	"microsoft.com/pkggen/internal/logger"
 )
 
+var (
+	// Timezones are zoneinfo paths such as "UTC", "America/New_York" or "Etc/GMT+5"
+	timezoneRegex = regexp.MustCompile(`^[A-Za-z0-9_+-]+(/[A-Za-z0-9_+-]+)*$`)
+	// Locales are of the form language[_territory][.charset][@modifier], e.g. "en_US.UTF-8"
+	localeRegex = regexp.MustCompile(`^[A-Za-z]+(_[A-Za-z]+)?(\.[A-Za-z0-9-]+)?(@[A-Za-z0-9]+)?$`)
+	// Keymaps are the names of the kbd keymap files, e.g. "us" or "de-latin1"
+	keymapRegex = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+	// tdnf option names, passed to tdnf as "--setopt=<name>=<value>"
+	tdnfOptionNameRegex = regexp.MustCompile(`^[a-z_]+$`)
+	// Repository IDs as used in the "[<id>]" section headers of .repo files
+	repoIDRegex = regexp.MustCompile(`^[A-Za-z0-9_.:-]+$`)
+	// sysctl keys are dot separated /proc/sys paths, e.g. "net.ipv4.ip_forward" or "net.ipv4.conf.br-lan.forwarding"
+	sysctlKeyRegex = regexp.MustCompile(`^[a-z0-9_]+(\.[A-Za-z0-9_-]+)+$`)
+	// systemd targets are unit names, with or without the ".target" suffix, e.g. "multi-user" or "graphical.target"
+	defaultTargetRegex = regexp.MustCompile(`^[A-Za-z0-9_.@:-]+$`)
+	// Default kernels are kernel versions or package names, e.g. "5.15.80.1-1.cm2" or "kernel-hyperv"
+	defaultKernelRegex = regexp.MustCompile(`^[A-Za-z0-9_.+-]+$`)
+	// os-release keys are upper case shell variable names
+	osReleaseKeyRegex = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+	// NTP servers given by name are DNS host names, e.g. "time.windows.com"
+	ntpServerNameRegex = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?)*$`)
+	// grubenv variables are grub variable names, e.g. "boot_success" or "boot_counter"
+	grubEnvNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	// grubEnvReservedNames are set by grub.cfg or loaded from /boot/mariner.cfg and /boot/systemd.cfg
+	grubEnvReservedNames = map[string]bool{"bootprefix": true, "rootdevice": true, "mariner_linux": true, "mariner_initrd": true, "mariner_cmdline": true, "systemd_cmdline": true}
+)
+
+const (
+	// BootTypeEfi boots the image with UEFI firmware
+	BootTypeEfi = "efi"
+	// BootTypeLegacy boots the image with BIOS firmware
+	BootTypeLegacy = "legacy"
+	// BootTypeHybrid boots the same GPT disk with either BIOS or UEFI firmware, for images written to USB drives
+	BootTypeHybrid = "hybrid"
+	// BootTypeNone installs no bootloader
+	BootTypeNone = "none"
+)
+
 // SystemConfig defines how each system present on the image is supposed to be configured.
 type SystemConfig struct {
-	IsDefault          bool                `json:"IsDefault"`
-	BootType           string              `json:"BootType"`
-	Hostname           string              `json:"Hostname"`
-	Name               string              `json:"Name"`
-	PackageLists       []string            `json:"PackageLists"`
-	KernelOptions      map[string]string   `json:"KernelOptions"`
-	KernelCommandLine  KernelCommandLine   `json:"KernelCommandLine"`
-	AdditionalFiles    map[string]string   `json:"AdditionalFiles"`
-	PartitionSettings  []PartitionSetting  `json:"PartitionSettings"`
-	PostInstallScripts []PostInstallScript `json:"PostInstallScripts"`
-	Groups             []Group             `json:"Groups"`
-	Users              []User              `json:"Users"`
-	Encryption         RootEncryption      `json:"Encryption"`
-	RemoveRpmDb        bool                `json:"RemoveRpmDb"`
-	ReadOnlyVerityRoot ReadOnlyVerityRoot  `json:"ReadOnlyVerityRoot"`
-	HidepidDisabled    bool                `json:"HidepidDisabled"`
+	IsDefault              bool                      `json:"IsDefault"`
+	BootType               string                    `json:"BootType"`
+	Hostname               string                    `json:"Hostname"`
+	Name                   string                    `json:"Name"`
+	PackageLists           []string                  `json:"PackageLists"`
+	PackageInstallGroups   [][]string                `json:"PackageInstallGroups"`
+	LocalPackages          []string                  `json:"LocalPackages"`
+	BaseRootfsTarball      string                    `json:"BaseRootfsTarball"`
+	BaseRootfsDir          string                    `json:"BaseRootfsDir"`
+	KernelOptions          map[string]string         `json:"KernelOptions"`
+	KernelCommandLine      KernelCommandLine         `json:"KernelCommandLine"`
+	DefaultKernel          string                    `json:"DefaultKernel"`
+	AdditionalFiles        map[string]AdditionalFile `json:"AdditionalFiles"`
+	AdditionalFilesCheck   string                    `json:"AdditionalFilesCheck"`
+	PartitionSettings      []PartitionSetting        `json:"PartitionSettings"`
+	PostInstallScripts     []PostInstallScript       `json:"PostInstallScripts"`
+	Groups                 []Group                   `json:"Groups"`
+	Users                  []User                    `json:"Users"`
+	RemoveUsers            []string                  `json:"RemoveUsers"`
+	RemoveGroups           []string                  `json:"RemoveGroups"`
+	AuthorizedKeys         AuthorizedKeys            `json:"AuthorizedKeys"`
+	SudoersRules           []SudoersRule             `json:"SudoersRules"`
+	Pam                    Pam                       `json:"Pam"`
+	LoginDefs              LoginDefs                 `json:"LoginDefs"`
+	Encryption             RootEncryption            `json:"Encryption"`
+	RemoveRpmDb            bool                      `json:"RemoveRpmDb"`
+	ReadOnlyVerityRoot     ReadOnlyVerityRoot        `json:"ReadOnlyVerityRoot"`
+	ReadOnlyRoot           ReadOnlyRoot              `json:"ReadOnlyRoot"`
+	ReadOnlyEtc            ReadOnlyEtc               `json:"ReadOnlyEtc"`
+	HidepidDisabled        bool                      `json:"HidepidDisabled"`
+	KernelModules          KernelModules             `json:"KernelModules"`
+	Kdump                  Kdump                     `json:"Kdump"`
+	Firmware               Firmware                  `json:"Firmware"`
+	InitramfsCustomization InitramfsCustomization    `json:"InitramfsCustomization"`
+	SystemdBoot            SystemdBoot               `json:"SystemdBoot"`
+	Esp                    Esp                       `json:"Esp"`
+	SecureBoot             SecureBoot                `json:"SecureBoot"`
+	RescueBootEntry        RescueBootEntry           `json:"RescueBootEntry"`
+	GrubPassword           GrubPassword              `json:"GrubPassword"`
+	GrubTheme              GrubTheme                 `json:"GrubTheme"`
+	GrubCfgTemplate        string                    `json:"GrubCfgTemplate"`
+	GrubMkconfig           GrubMkconfig              `json:"GrubMkconfig"`
+	GrubEnv                map[string]string         `json:"GrubEnv"`
+	SbomFormat             SbomFormat                `json:"SbomFormat"`
+	ChangeReport           ChangeReport              `json:"ChangeReport"`
+	BuildMetadata          bool                      `json:"BuildMetadata"`
+	Timezone               string                    `json:"Timezone"`
+	NtpServers             []string                  `json:"NtpServers"`
+	NetworkConnections     []NetworkConnection       `json:"NetworkConnections"`
+	Locale                 string                    `json:"Locale"`
+	Keymap                 string                    `json:"Keymap"`
+	EarlyConsole           EarlyConsole              `json:"EarlyConsole"`
+	DefaultTarget          string                    `json:"DefaultTarget"`
+	MinimizeImage          bool                      `json:"MinimizeImage"`
+	Reproducible           bool                      `json:"Reproducible"`
+	TdnfOptions            map[string]string         `json:"TdnfOptions"`
+	InstallOptions         InstallOptions            `json:"InstallOptions"`
+	OsRelease              map[string]string         `json:"OsRelease"`
+	UpdateExistingPackages bool                      `json:"UpdateExistingPackages"`
+	UpdateRepo             string                    `json:"UpdateRepo"`
+	RequireRepoGpgCheck    bool                      `json:"RequireRepoGpgCheck"`
+	StrictPackageVersions  bool                      `json:"StrictPackageVersions"`
+	Sysctl                 map[string]string         `json:"Sysctl"`
+	Journald               Journald                  `json:"Journald"`
+	Resolved               Resolved                  `json:"Resolved"`
+	LogrotateRules         []LogrotateRule           `json:"LogrotateRules"`
+	Firewall               Firewall                  `json:"Firewall"`
+	CloudInit              CloudInit                 `json:"CloudInit"`
+	UdevRules              []UdevRule                `json:"UdevRules"`
+	AuditRules             []AuditRule               `json:"AuditRules"`
+	SystemdDropIns         []SystemdDropIn           `json:"SystemdDropIns"`
+	DataOnly               bool                      `json:"DataOnly"`
+	Validate               PostConditions            `json:"Validate"`
 }
 
 // GetRootPartitionSetting returns a pointer to the partition setting describing the disk which
@@ -45,6 +145,172 @@ func (s *SystemConfig) GetRootPartitionSetting() (rootPartitionSetting *Partitio
 	return nil
 }
 
+// GetGrowFsOnBootMountPoints returns the mount points of the partitions whose file systems are grown
+// to fill their partition on first boot
+func (s *SystemConfig) GetGrowFsOnBootMountPoints() (mountPoints []string) {
+	for _, p := range s.PartitionSettings {
+		if p.GrowFsOnBoot {
+			mountPoints = append(mountPoints, p.MountPoint)
+		}
+	}
+	return
+}
+
+// PackageInstallGroupPackages returns the packages from all the install groups, in installation order.
+func (s *SystemConfig) PackageInstallGroupPackages() (packages []string) {
+	for _, group := range s.PackageInstallGroups {
+		packages = append(packages, group...)
+	}
+	return
+}
+
+// HasEncryptedPartitions returns true if any non-root partition setting requests encryption.
+func (s *SystemConfig) HasEncryptedPartitions() bool {
+	for _, p := range s.PartitionSettings {
+		if p.Encryption.Enable {
+			return true
+		}
+	}
+	return false
+}
+
+// HasIntegrityPartitions returns true if any non-root partition setting requests dm-integrity.
+func (s *SystemConfig) HasIntegrityPartitions() bool {
+	for _, p := range s.PartitionSettings {
+		if p.Integrity.Enable {
+			return true
+		}
+	}
+	return false
+}
+
+// GetDefaultTargetUnit returns the systemd unit the image boots into, adding the ".target" suffix
+// to short names such as "multi-user". An empty string means the distribution's default is kept.
+func (s *SystemConfig) GetDefaultTargetUnit() string {
+	const targetSuffix = ".target"
+
+	if s.DefaultTarget == "" || strings.HasSuffix(s.DefaultTarget, targetSuffix) {
+		return s.DefaultTarget
+	}
+	return s.DefaultTarget + targetSuffix
+}
+
+// GetTdnfOptions returns the TdnfOptions along with the options the InstallOptions translate to.
+func (s *SystemConfig) GetTdnfOptions() (options map[string]string) {
+	options = s.InstallOptions.GetTdnfOptions()
+	for name, value := range s.TdnfOptions {
+		options[name] = value
+	}
+	return
+}
+
+// GetKernelCommandLine returns the KernelCommandLine with the arguments other settings need appended
+// to its ExtraCommandLine, such as the crashkernel reservation of Kdump.
+func (s *SystemConfig) GetKernelCommandLine() (kernelCommandLine KernelCommandLine) {
+	kernelCommandLine = s.KernelCommandLine
+
+	var extraArgs []string
+	if kernelCommandLine.ExtraCommandLine != "" {
+		extraArgs = append(extraArgs, kernelCommandLine.ExtraCommandLine)
+	}
+	if s.Kdump.Enable {
+		extraArgs = append(extraArgs, s.Kdump.GetKernelArg())
+	}
+	extraArgs = append(extraArgs, s.EarlyConsole.GetKernelArgs()...)
+
+	kernelCommandLine.ExtraCommandLine = strings.Join(extraArgs, " ")
+	return
+}
+
+// removedAccountsAreValid checks the users and groups to remove are named and are not root.
+// Whether they are system accounts can only be checked against the image.
+func (s *SystemConfig) removedAccountsAreValid() (err error) {
+	const rootAccount = "root"
+
+	for _, user := range s.RemoveUsers {
+		if strings.TrimSpace(user) == "" {
+			return fmt.Errorf("invalid [RemoveUsers]: user names cannot be empty")
+		}
+		if user == rootAccount {
+			return fmt.Errorf("invalid [RemoveUsers]: the root user can't be removed")
+		}
+	}
+
+	for _, group := range s.RemoveGroups {
+		if strings.TrimSpace(group) == "" {
+			return fmt.Errorf("invalid [RemoveGroups]: group names cannot be empty")
+		}
+		if group == rootAccount {
+			return fmt.Errorf("invalid [RemoveGroups]: the root group can't be removed")
+		}
+	}
+
+	return
+}
+
+// dataOnlyIsValid checks a data-only system config only requests partitions and files, since no
+// operating system is installed to apply any other setting.
+func (s *SystemConfig) dataOnlyIsValid() (err error) {
+	if s.BootType != "" && s.BootType != "none" {
+		return fmt.Errorf("[BootType] must be 'none' or unset, found '%s'", s.BootType)
+	}
+
+	if s.GetRootPartitionSetting() == nil {
+		return fmt.Errorf("a partition must be mounted at '/', it is the root of the data volume")
+	}
+
+	// Every other setting needs an operating system, so only the ones a data volume supports may be set
+	dataOnlySettings := map[string]bool{
+		"Name":              true,
+		"IsDefault":         true,
+		"BootType":          true,
+		"DataOnly":          true,
+		"PartitionSettings": true,
+		"AdditionalFiles":   true,
+		"Reproducible":      true,
+		"Validate":          true,
+	}
+	var unsupportedSettings []string
+	configValue := reflect.ValueOf(*s)
+	for i := 0; i < configValue.NumField(); i++ {
+		name := strings.Split(configValue.Type().Field(i).Tag.Get("json"), ",")[0]
+		if !dataOnlySettings[name] && !configValue.Field(i).IsZero() {
+			unsupportedSettings = append(unsupportedSettings, name)
+		}
+	}
+
+	// Some settings are made per partition
+	if s.HasEncryptedPartitions() {
+		unsupportedSettings = append(unsupportedSettings, "Encryption")
+	}
+	if s.HasIntegrityPartitions() {
+		unsupportedSettings = append(unsupportedSettings, "Integrity")
+	}
+	if len(s.GetGrowFsOnBootMountPoints()) != 0 {
+		unsupportedSettings = append(unsupportedSettings, "GrowFsOnBoot")
+	}
+
+	if len(unsupportedSettings) != 0 {
+		sort.Strings(unsupportedSettings)
+		return fmt.Errorf("[%s] requires an operating system and may not be set", unsupportedSettings[0])
+	}
+
+	// There is no /etc/passwd or /etc/group to resolve names against
+	for _, additionalFile := range s.AdditionalFiles {
+		for _, id := range []string{additionalFile.Owner, additionalFile.Group} {
+			if _, convErr := strconv.ParseUint(id, 10, 32); id != "" && convErr != nil {
+				return fmt.Errorf("[AdditionalFiles] '%s' must use a numeric [Owner] and [Group], found '%s'", additionalFile.Path, id)
+			}
+		}
+	}
+
+	if len(s.Validate.Packages) != 0 || len(s.Validate.ServicesEnabled) != 0 {
+		return fmt.Errorf("[Validate] may only check [FilesExist] without an operating system")
+	}
+
+	return
+}
+
 // IsValid returns an error if the SystemConfig is not valid
 func (s *SystemConfig) IsValid() (err error) {
 	// IsDefault must be validated by a parent struct
@@ -57,14 +323,47 @@ func (s *SystemConfig) IsValid() (err error) {
 		return fmt.Errorf("missing [Name] field")
 	}
 
-	if len(s.PackageLists) == 0 {
+	if s.DataOnly {
+		if err = s.dataOnlyIsValid(); err != nil {
+			return fmt.Errorf("invalid [DataOnly]: %w", err)
+		}
+	}
+
+	if s.BaseRootfsTarball != "" && s.BaseRootfsDir != "" {
+		return fmt.Errorf("invalid [BaseRootfsDir]: can't be used together with [BaseRootfsTarball]")
+	}
+
+	for _, localPackage := range s.LocalPackages {
+		if !strings.HasSuffix(localPackage, ".rpm") {
+			return fmt.Errorf("invalid [LocalPackages]: (%s) must be the path of an .rpm file", localPackage)
+		}
+	}
+
+	// A base rootfs already provides the packages, so additional package lists are optional
+	if len(s.PackageLists) == 0 && s.BaseRootfsTarball == "" && s.BaseRootfsDir == "" && !s.DataOnly {
 		return fmt.Errorf("system configuration must provide at least one package list inside the [PackageLists] field")
 	}
 	// Additional package list validation must be done via the imageconfigvalidator tool since there is no guranatee that
 	// the paths are valid at this point.
 
+	groupedPackages := make(map[string]int)
+	for i, group := range s.PackageInstallGroups {
+		if len(group) == 0 {
+			return fmt.Errorf("invalid [PackageInstallGroups]: group %d is empty", i)
+		}
+		for _, pkg := range group {
+			if strings.TrimSpace(pkg) == "" {
+				return fmt.Errorf("invalid [PackageInstallGroups]: group %d contains an empty package name", i)
+			}
+			if previousGroup, found := groupedPackages[pkg]; found {
+				return fmt.Errorf("invalid [PackageInstallGroups]: package '%s' is listed in both group %d and group %d", pkg, previousGroup, i)
+			}
+			groupedPackages[pkg] = i
+		}
+	}
+
 	// Enforce that any non-rootfs configuration has a default kernel.
-	if len(s.PartitionSettings) != 0 {
+	if len(s.PartitionSettings) != 0 && !s.DataOnly {
 		// Ensure that default option is always present
 		if _, ok := s.KernelOptions["default"]; !ok {
 			return fmt.Errorf("system configuration must always provide default kernel inside the [KernelOptions] field; remember that kernels are FORBIDDEN from appearing in any of the [PackageLists]")
@@ -86,10 +385,17 @@ func (s *SystemConfig) IsValid() (err error) {
 
 	// Validate the partitions this system config will be including
 	mountPointUsed := make(map[string]bool)
+	detachedHeaderUsed := make(map[string]bool)
 	for _, partitionSetting := range s.PartitionSettings {
 		if err = partitionSetting.IsValid(); err != nil {
 			return fmt.Errorf("invalid [PartitionSettings]: %w", err)
 		}
+		if detachedHeader := partitionSetting.Encryption.DetachedHeader; detachedHeader != "" {
+			if detachedHeaderUsed[detachedHeader] {
+				return fmt.Errorf("invalid [PartitionSettings]: duplicate detached LUKS header file '%s'", detachedHeader)
+			}
+			detachedHeaderUsed[detachedHeader] = true
+		}
 		if mountPointUsed[partitionSetting.MountPoint] {
 			return fmt.Errorf("invalid [PartitionSettings]: duplicate mount point found at '%s'", partitionSetting.MountPoint)
 		}
@@ -119,11 +425,234 @@ func (s *SystemConfig) IsValid() (err error) {
 		return fmt.Errorf("invalid [ReadOnlyVerityRoot]: %w", err)
 	}
 
+	if err = s.ReadOnlyRoot.IsValid(); err != nil {
+		return fmt.Errorf("invalid [ReadOnlyRoot]: %w", err)
+	}
+
+	if s.ReadOnlyRoot.Enable {
+		if s.ReadOnlyVerityRoot.Enable {
+			return fmt.Errorf("invalid [ReadOnlyRoot]: can't be used together with [ReadOnlyVerityRoot]")
+		}
+		// The marker telling the first boot service to grow the file systems is removed from /etc once it ran
+		if len(s.GetGrowFsOnBootMountPoints()) != 0 {
+			return fmt.Errorf("invalid [ReadOnlyRoot]: can't be used together with [GrowFsOnBoot]")
+		}
+		if s.ReadOnlyRoot.PersistentDir != "" && !mountPointUsed[s.ReadOnlyRoot.PersistentDir] {
+			return fmt.Errorf("invalid [ReadOnlyRoot]: [PersistentDir] (%s) must be the mount point of a writable partition", s.ReadOnlyRoot.PersistentDir)
+		}
+	}
+
+	if err = s.ReadOnlyEtc.IsValid(); err != nil {
+		return fmt.Errorf("invalid [ReadOnlyEtc]: %w", err)
+	}
+
+	if s.ReadOnlyEtc.Enable {
+		if s.ReadOnlyRoot.Enable || s.ReadOnlyVerityRoot.Enable {
+			return fmt.Errorf("invalid [ReadOnlyEtc]: can't be used together with [ReadOnlyRoot] or [ReadOnlyVerityRoot], which already make /etc read-only")
+		}
+		// The first boot service removes its marker from /etc/imager once it ran
+		if len(s.GetGrowFsOnBootMountPoints()) != 0 && !s.ReadOnlyEtc.IsWritable(growFsMarkerDir) {
+			return fmt.Errorf("invalid [ReadOnlyEtc]: [GrowFsOnBoot] needs (%s) in [WritableDirs]", growFsMarkerDir)
+		}
+	}
+
 	if err = s.KernelCommandLine.IsValid(); err != nil {
 		return fmt.Errorf("invalid [KernelCommandLine]: %w", err)
 	}
 
-	//Validate PostInstallScripts
+	if err = s.KernelModules.IsValid(); err != nil {
+		return fmt.Errorf("invalid [KernelModules]: %w", err)
+	}
+
+	if err = s.Firmware.IsValid(); err != nil {
+		return fmt.Errorf("invalid [Firmware]: %w", err)
+	}
+
+	if err = s.InitramfsCustomization.IsValid(); err != nil {
+		return fmt.Errorf("invalid [InitramfsCustomization]: %w", err)
+	}
+
+	if err = s.Kdump.IsValid(); err != nil {
+		return fmt.Errorf("invalid [Kdump]: %w", err)
+	}
+	if s.Kdump.Enable && strings.Contains(" "+s.KernelCommandLine.ExtraCommandLine, " crashkernel=") {
+		return fmt.Errorf("invalid [Kdump]: [KernelCommandLine] already sets crashkernel, set it through [Kdump] only")
+	}
+
+	if err = s.SbomFormat.IsValid(); err != nil {
+		return fmt.Errorf("invalid [SbomFormat]: %w", err)
+	}
+
+	if err = s.ChangeReport.IsValid(); err != nil {
+		return fmt.Errorf("invalid [ChangeReport]: %w", err)
+	}
+
+	if err = s.SystemdBoot.IsValid(); err != nil {
+		return fmt.Errorf("invalid [SystemdBoot]: %w", err)
+	}
+
+	if err = s.Esp.IsValid(); err != nil {
+		return fmt.Errorf("invalid [Esp]: %w", err)
+	}
+	if !s.Esp.IsEmpty() && s.BootType != BootTypeEfi && s.BootType != BootTypeHybrid {
+		return fmt.Errorf("invalid [Esp]: requires [BootType] 'efi' or 'hybrid', found '%s'", s.BootType)
+	}
+
+	if err = s.SecureBoot.IsValid(); err != nil {
+		return fmt.Errorf("invalid [SecureBoot]: %w", err)
+	}
+	if s.SecureBoot.Enable && s.BootType != BootTypeEfi && s.BootType != BootTypeHybrid {
+		return fmt.Errorf("invalid [SecureBoot]: requires [BootType] 'efi' or 'hybrid', found '%s'", s.BootType)
+	}
+
+	if err = s.RescueBootEntry.IsValid(); err != nil {
+		return fmt.Errorf("invalid [RescueBootEntry]: %w", err)
+	}
+
+	if err = s.GrubPassword.IsValid(); err != nil {
+		return fmt.Errorf("invalid [GrubPassword]: %w", err)
+	}
+
+	if err = s.GrubTheme.IsValid(); err != nil {
+		return fmt.Errorf("invalid [GrubTheme]: %w", err)
+	}
+
+	if err = s.GrubMkconfig.IsValid(); err != nil {
+		return fmt.Errorf("invalid [GrubMkconfig]: %w", err)
+	}
+	if s.GrubMkconfig.Enable {
+		// These edit grub.cfg directly, grub2-mkconfig would overwrite them
+		if s.RescueBootEntry.Enable || s.GrubPassword.IsEnabled() || s.GrubCfgTemplate != "" {
+			return fmt.Errorf("invalid [GrubMkconfig]: can't be used together with [RescueBootEntry], [GrubPassword] or [GrubCfgTemplate]")
+		}
+	}
+
+	if err = s.grubEnvIsValid(); err != nil {
+		return fmt.Errorf("invalid [GrubEnv]: %w", err)
+	}
+
+	for _, server := range s.NtpServers {
+		if net.ParseIP(server) == nil && !ntpServerNameRegex.MatchString(server) {
+			return fmt.Errorf("invalid [NtpServers]: (%s) must be an IP address or a host name such as 'time.windows.com'", server)
+		}
+	}
+
+	if s.Timezone != "" && !timezoneRegex.MatchString(s.Timezone) {
+		return fmt.Errorf("invalid [Timezone] (%s), must be a path relative to /usr/share/zoneinfo such as 'America/New_York'", s.Timezone)
+	}
+
+	if s.DefaultKernel != "" && !defaultKernelRegex.MatchString(s.DefaultKernel) {
+		return fmt.Errorf("invalid [DefaultKernel] (%s), must be a kernel version or kernel package name", s.DefaultKernel)
+	}
+
+	if s.DefaultTarget != "" && !defaultTargetRegex.MatchString(s.DefaultTarget) {
+		return fmt.Errorf("invalid [DefaultTarget] (%s), must be a systemd target such as 'multi-user' or 'graphical.target'", s.DefaultTarget)
+	}
+
+	if s.Locale != "" && !localeRegex.MatchString(s.Locale) {
+		return fmt.Errorf("invalid [Locale] (%s), must be of the form language[_territory][.charset][@modifier] such as 'en_US.UTF-8'", s.Locale)
+	}
+
+	if s.Keymap != "" && !keymapRegex.MatchString(s.Keymap) {
+		return fmt.Errorf("invalid [Keymap] (%s), must be the name of a console keymap such as 'us'", s.Keymap)
+	}
+
+	if err = s.EarlyConsole.IsValid(); err != nil {
+		return fmt.Errorf("invalid [EarlyConsole]: %w", err)
+	}
+	// Both are written to /etc/vconsole.conf, which the initramfs copies
+	if s.Keymap != "" && s.EarlyConsole.Keymap != "" && s.Keymap != s.EarlyConsole.Keymap {
+		return fmt.Errorf("invalid [EarlyConsole]: [Keymap] (%s) differs from the system config's [Keymap] (%s)", s.EarlyConsole.Keymap, s.Keymap)
+	}
+	if strings.Contains(" "+s.KernelCommandLine.ExtraCommandLine, " rd.vconsole.") && !s.EarlyConsole.IsEmpty() {
+		return fmt.Errorf("invalid [EarlyConsole]: [KernelCommandLine] already sets rd.vconsole arguments, set them through [EarlyConsole] only")
+	}
+
+	for name, value := range s.TdnfOptions {
+		if !tdnfOptionNameRegex.MatchString(name) {
+			return fmt.Errorf("invalid [TdnfOptions]: invalid option name (%s)", name)
+		}
+		if value == "" || strings.ContainsAny(value, " \t\n") {
+			return fmt.Errorf("invalid [TdnfOptions]: value of option (%s) must be non-empty and may not contain whitespace", name)
+		}
+	}
+
+	for name := range s.InstallOptions.GetTdnfOptions() {
+		if _, ok := s.TdnfOptions[name]; ok {
+			return fmt.Errorf("invalid [TdnfOptions]: option (%s) is already set by [InstallOptions]", name)
+		}
+	}
+
+	if err = s.osReleaseIsValid(); err != nil {
+		return fmt.Errorf("invalid [OsRelease]: %w", err)
+	}
+
+	for key, value := range s.Sysctl {
+		if !sysctlKeyRegex.MatchString(key) {
+			return fmt.Errorf("invalid [Sysctl]: invalid key (%s), must be a dot separated path such as 'vm.swappiness'", key)
+		}
+		if strings.TrimSpace(value) == "" || strings.ContainsAny(value, "\n\r") {
+			return fmt.Errorf("invalid [Sysctl]: value of key (%s) must be non-empty and may not contain line breaks", key)
+		}
+	}
+
+	if err = s.Journald.IsValid(); err != nil {
+		return fmt.Errorf("invalid [Journald]: %w", err)
+	}
+
+	if err = s.Resolved.IsValid(); err != nil {
+		return fmt.Errorf("invalid [Resolved]: %w", err)
+	}
+
+	if err = networkConnectionsAreValid(s.NetworkConnections); err != nil {
+		return fmt.Errorf("invalid [NetworkConnections]: %w", err)
+	}
+
+	if err = s.Firewall.IsValid(); err != nil {
+		return fmt.Errorf("invalid [Firewall]: %w", err)
+	}
+
+	if err = s.CloudInit.IsValid(); err != nil {
+		return fmt.Errorf("invalid [CloudInit]: %w", err)
+	}
+
+	if err = s.Pam.IsValid(); err != nil {
+		return fmt.Errorf("invalid [Pam]: %w", err)
+	}
+
+	if err = s.LoginDefs.IsValid(); err != nil {
+		return fmt.Errorf("invalid [LoginDefs]: %w", err)
+	}
+
+	if err = s.Validate.IsValid(); err != nil {
+		return fmt.Errorf("invalid [Validate]: %w", err)
+	}
+
+	if s.RemoveRpmDb && len(s.Validate.Packages) != 0 {
+		return fmt.Errorf("invalid [Validate]: [Packages] can't be checked once [RemoveRpmDb] removes the RPM database")
+	}
+
+	if s.UpdateRepo != "" {
+		if !s.UpdateExistingPackages {
+			return fmt.Errorf("invalid [UpdateRepo]: requires [UpdateExistingPackages] to be enabled")
+		}
+		if !repoIDRegex.MatchString(s.UpdateRepo) {
+			return fmt.Errorf("invalid [UpdateRepo] (%s), must be a repository ID", s.UpdateRepo)
+		}
+	}
+
+	switch s.AdditionalFilesCheck {
+	case "", AdditionalFilesCheckWarn, AdditionalFilesCheckError:
+	default:
+		return fmt.Errorf("invalid [AdditionalFilesCheck] (%s), must be '%s' or '%s'", s.AdditionalFilesCheck, AdditionalFilesCheckWarn, AdditionalFilesCheckError)
+	}
+
+	for _, script := range s.PostInstallScripts {
+		if err = script.IsValid(); err != nil {
+			return fmt.Errorf("invalid [PostInstallScripts]: %w", err)
+		}
+	}
+
 	//Validate Groups
 	//Validate Users
 	for _, b := range s.Users {
@@ -132,6 +661,75 @@ func (s *SystemConfig) IsValid() (err error) {
 		}
 	}
 
+	if err = s.removedAccountsAreValid(); err != nil {
+		return
+	}
+
+	if err = s.AuthorizedKeys.IsValid(); err != nil {
+		return fmt.Errorf("invalid [AuthorizedKeys]: %w", err)
+	}
+
+	addedUsers := make(map[string]bool)
+	for _, user := range s.Users {
+		addedUsers[user.Name] = true
+	}
+	for _, user := range s.RemoveUsers {
+		if len(s.AuthorizedKeys[user]) != 0 && !addedUsers[user] {
+			return fmt.Errorf("invalid [AuthorizedKeys]: user (%s) is removed by [RemoveUsers]", user)
+		}
+	}
+
+	udevRuleNames := make(map[string]bool)
+	for _, rule := range s.UdevRules {
+		if err = rule.IsValid(); err != nil {
+			return fmt.Errorf("invalid [UdevRules]: %w", err)
+		}
+		if udevRuleNames[rule.Name] {
+			return fmt.Errorf("invalid [UdevRules]: (%s) is listed more than once", rule.Name)
+		}
+		udevRuleNames[rule.Name] = true
+	}
+
+	auditRuleNames := make(map[string]bool)
+	for _, rule := range s.AuditRules {
+		if err = rule.IsValid(); err != nil {
+			return fmt.Errorf("invalid [AuditRules]: %w", err)
+		}
+		if auditRuleNames[rule.Name] {
+			return fmt.Errorf("invalid [AuditRules]: (%s) is listed more than once", rule.Name)
+		}
+		auditRuleNames[rule.Name] = true
+	}
+
+	systemdDropInPaths := make(map[string]bool)
+	for _, dropIn := range s.SystemdDropIns {
+		if err = dropIn.IsValid(); err != nil {
+			return fmt.Errorf("invalid [SystemdDropIns]: %w", err)
+		}
+		dropInPath := fmt.Sprintf("%s.d/%s", dropIn.Unit, dropIn.GetName())
+		if systemdDropInPaths[dropInPath] {
+			return fmt.Errorf("invalid [SystemdDropIns]: (%s) is listed more than once", dropInPath)
+		}
+		systemdDropInPaths[dropInPath] = true
+	}
+
+	logrotateRuleNames := make(map[string]bool)
+	for _, rule := range s.LogrotateRules {
+		if err = rule.IsValid(); err != nil {
+			return fmt.Errorf("invalid [LogrotateRules]: %w", err)
+		}
+		if logrotateRuleNames[rule.Name] {
+			return fmt.Errorf("invalid [LogrotateRules]: (%s) is listed more than once", rule.Name)
+		}
+		logrotateRuleNames[rule.Name] = true
+	}
+
+	for _, rule := range s.SudoersRules {
+		if err = rule.IsValid(); err != nil {
+			return fmt.Errorf("invalid [SudoersRules]: %w", err)
+		}
+	}
+
 	//Validate Encryption
 
 	//Validate HidepidDisabled
@@ -139,6 +737,69 @@ func (s *SystemConfig) IsValid() (err error) {
 	return
 }
 
+// osReleaseIsValid checks the os-release overrides identify the operating system and can be
+// written as single line shell variable assignments.
+func (s *SystemConfig) osReleaseIsValid() (err error) {
+	var requiredKeys = []string{"ID", "VERSION_ID"}
+
+	if len(s.OsRelease) == 0 {
+		return
+	}
+
+	for _, key := range requiredKeys {
+		if strings.TrimSpace(s.OsRelease[key]) == "" {
+			return fmt.Errorf("missing required key (%s)", key)
+		}
+	}
+
+	for key, value := range s.OsRelease {
+		if !osReleaseKeyRegex.MatchString(key) {
+			return fmt.Errorf("invalid key (%s), must be upper case letters, digits and underscores", key)
+		}
+		if strings.ContainsAny(value, "\n\r") {
+			return fmt.Errorf("value of key (%s) may not contain line breaks", key)
+		}
+	}
+
+	return
+}
+
+// grubEnvIsValid checks the GrubEnv variable names and that they fit in the 1024 byte grubenv block.
+func (s *SystemConfig) grubEnvIsValid() (err error) {
+	const (
+		grubEnvBlockSize = 1024
+		grubEnvHeader    = "# GRUB Environment Block\n"
+	)
+
+	if len(s.GrubEnv) == 0 {
+		return
+	}
+
+	if s.BootType == BootTypeNone {
+		return fmt.Errorf("can't be used with a [BootType] of '%s'", BootTypeNone)
+	}
+
+	envSize := len(grubEnvHeader)
+	for name, value := range s.GrubEnv {
+		if !grubEnvNameRegex.MatchString(name) {
+			return fmt.Errorf("invalid variable name (%s), must be letters, digits and underscores, not starting with a digit", name)
+		}
+		if grubEnvReservedNames[name] {
+			return fmt.Errorf("variable (%s) is set by the image's grub configuration and can't be overridden", name)
+		}
+		if strings.ContainsAny(value, "\n\r") {
+			return fmt.Errorf("value of variable (%s) may not contain line breaks", name)
+		}
+		envSize += len(name) + len("=") + len(value) + len("\n")
+	}
+
+	if envSize > grubEnvBlockSize {
+		return fmt.Errorf("variables need %d bytes, which exceeds the %d bytes of the grubenv block", envSize, grubEnvBlockSize)
+	}
+
+	return
+}
+
 // UnmarshalJSON Unmarshals a Disk entry
 func (s *SystemConfig) UnmarshalJSON(b []byte) (err error) {
 	// Use an intermediate type which will use the default JSON unmarshal implementation
@@ -4,6 +4,7 @@
 package configuration
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -303,3 +304,625 @@ func TestShouldFailToParseInvalidJSON_SystemConfig(t *testing.T) {
 func TestShouldSetRemoveRpmDbToFalse(t *testing.T) {
 	assert.Equal(t, validSystemConfig.RemoveRpmDb, false)
 }
+
+func TestShouldSucceedParsingPackageInstallGroups_SystemConfig(t *testing.T) {
+	var checkedSystemConfig SystemConfig
+
+	groupedConfig := validSystemConfig
+	groupedConfig.PackageInstallGroups = [][]string{
+		{"first-package"},
+		{"second-package", "third-package"},
+	}
+
+	assert.NoError(t, groupedConfig.IsValid())
+	err := remarshalJSON(groupedConfig, &checkedSystemConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, groupedConfig, checkedSystemConfig)
+	assert.Equal(t, []string{"first-package", "second-package", "third-package"}, checkedSystemConfig.PackageInstallGroupPackages())
+}
+
+func TestShouldFailParsingDuplicatePackageInstallGroups_SystemConfig(t *testing.T) {
+	var checkedSystemConfig SystemConfig
+
+	badGroupConfig := validSystemConfig
+	badGroupConfig.PackageInstallGroups = [][]string{
+		{"first-package"},
+		{"second-package", "first-package"},
+	}
+
+	err := badGroupConfig.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [PackageInstallGroups]: package 'first-package' is listed in both group 0 and group 1", err.Error())
+
+	err = remarshalJSON(badGroupConfig, &checkedSystemConfig)
+	assert.Error(t, err)
+	assert.Equal(t, "failed to parse [SystemConfig]: invalid [PackageInstallGroups]: package 'first-package' is listed in both group 0 and group 1", err.Error())
+}
+
+func TestShouldFailParsingEmptyPackageInstallGroup_SystemConfig(t *testing.T) {
+	badGroupConfig := validSystemConfig
+	badGroupConfig.PackageInstallGroups = [][]string{
+		{"first-package"},
+		{},
+	}
+
+	err := badGroupConfig.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [PackageInstallGroups]: group 1 is empty", err.Error())
+}
+
+func TestShouldSucceedParsingLocalPackages_SystemConfig(t *testing.T) {
+	var checkedSystemConfig SystemConfig
+
+	localConfig := validSystemConfig
+	localConfig.LocalPackages = []string{"rpms/my-agent-1.0-1.x86_64.rpm"}
+
+	assert.NoError(t, localConfig.IsValid())
+	err := remarshalJSON(localConfig, &checkedSystemConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, localConfig, checkedSystemConfig)
+}
+
+func TestShouldFailParsingLocalPackageWithoutRpmSuffix_SystemConfig(t *testing.T) {
+	var checkedSystemConfig SystemConfig
+
+	badLocalConfig := validSystemConfig
+	badLocalConfig.LocalPackages = []string{"my-agent"}
+
+	err := badLocalConfig.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [LocalPackages]: (my-agent) must be the path of an .rpm file", err.Error())
+
+	err = remarshalJSON(badLocalConfig, &checkedSystemConfig)
+	assert.Error(t, err)
+	assert.Equal(t, "failed to parse [SystemConfig]: invalid [LocalPackages]: (my-agent) must be the path of an .rpm file", err.Error())
+}
+
+func TestShouldSucceedParsingBaseRootfsWithoutPackageLists_SystemConfig(t *testing.T) {
+	var checkedSystemConfig SystemConfig
+
+	tarballConfig := validSystemConfig
+	tarballConfig.PackageLists = []string{}
+	tarballConfig.BaseRootfsTarball = "rootfs/base.tar.gz"
+
+	assert.NoError(t, tarballConfig.IsValid())
+	err := remarshalJSON(tarballConfig, &checkedSystemConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, tarballConfig, checkedSystemConfig)
+}
+
+func TestShouldSucceedParsingBaseRootfsDir_SystemConfig(t *testing.T) {
+	var checkedSystemConfig SystemConfig
+
+	containerConfig := validSystemConfig
+	containerConfig.PackageLists = []string{}
+	containerConfig.PartitionSettings = []PartitionSetting{}
+	containerConfig.KernelOptions = map[string]string{}
+	containerConfig.BaseRootfsDir = "rootfs/container"
+
+	assert.NoError(t, containerConfig.IsValid())
+	err := remarshalJSON(containerConfig, &checkedSystemConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, containerConfig, checkedSystemConfig)
+}
+
+func TestShouldSucceedParsingRemovedAccounts_SystemConfig(t *testing.T) {
+	var checkedSystemConfig SystemConfig
+
+	accountsConfig := validSystemConfig
+	accountsConfig.BaseRootfsTarball = "rootfs/vendor.tar.gz"
+	accountsConfig.RemoveUsers = []string{"vendor"}
+	accountsConfig.RemoveGroups = []string{"vendor", "support"}
+
+	assert.NoError(t, accountsConfig.IsValid())
+	err := remarshalJSON(accountsConfig, &checkedSystemConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, accountsConfig, checkedSystemConfig)
+}
+
+func TestShouldFailParsingRemovedRootUser_SystemConfig(t *testing.T) {
+	invalidConfig := validSystemConfig
+	invalidConfig.RemoveUsers = []string{"vendor", "root"}
+
+	err := invalidConfig.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [RemoveUsers]: the root user can't be removed", err.Error())
+}
+
+func TestShouldFailParsingBaseRootfsDirWithTarball_SystemConfig(t *testing.T) {
+	invalidConfig := validSystemConfig
+	invalidConfig.BaseRootfsTarball = "rootfs/base.tar.gz"
+	invalidConfig.BaseRootfsDir = "rootfs/container"
+
+	err := invalidConfig.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [BaseRootfsDir]: can't be used together with [BaseRootfsTarball]", err.Error())
+}
+
+func TestShouldSucceedParsingTimezone_SystemConfig(t *testing.T) {
+	timezoneConfig := validSystemConfig
+
+	for _, timezone := range []string{"UTC", "America/New_York", "Etc/GMT+5", "America/Port-au-Prince"} {
+		timezoneConfig.Timezone = timezone
+		assert.NoError(t, timezoneConfig.IsValid())
+	}
+}
+
+func TestShouldFailParsingInvalidTimezone_SystemConfig(t *testing.T) {
+	badTimezoneConfig := validSystemConfig
+	badTimezoneConfig.Timezone = "../../etc/passwd"
+
+	err := badTimezoneConfig.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [Timezone] (../../etc/passwd), must be a path relative to /usr/share/zoneinfo such as 'America/New_York'", err.Error())
+}
+
+func TestShouldSucceedParsingLocaleAndKeymap_SystemConfig(t *testing.T) {
+	localeConfig := validSystemConfig
+	localeConfig.Locale = "de_DE.UTF-8@euro"
+	localeConfig.Keymap = "de-latin1"
+
+	assert.NoError(t, localeConfig.IsValid())
+}
+
+func TestShouldFailParsingInvalidLocale_SystemConfig(t *testing.T) {
+	badLocaleConfig := validSystemConfig
+	badLocaleConfig.Locale = "en US"
+
+	err := badLocaleConfig.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [Locale] (en US), must be of the form language[_territory][.charset][@modifier] such as 'en_US.UTF-8'", err.Error())
+}
+
+func TestShouldFailParsingInvalidKeymap_SystemConfig(t *testing.T) {
+	badKeymapConfig := validSystemConfig
+	badKeymapConfig.Keymap = "../us"
+
+	err := badKeymapConfig.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [Keymap] (../us), must be the name of a console keymap such as 'us'", err.Error())
+}
+
+func TestShouldFailParsingInvalidTdnfOptionValue_SystemConfig(t *testing.T) {
+	badTdnfConfig := validSystemConfig
+	badTdnfConfig.TdnfOptions = map[string]string{"timeout": "60 --nogpgcheck"}
+
+	err := badTdnfConfig.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [TdnfOptions]: value of option (timeout) must be non-empty and may not contain whitespace", err.Error())
+}
+
+func TestShouldFailParsingInvalidTdnfOptionName_SystemConfig(t *testing.T) {
+	badTdnfConfig := validSystemConfig
+	badTdnfConfig.TdnfOptions = map[string]string{"--installroot": "/"}
+
+	err := badTdnfConfig.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [TdnfOptions]: invalid option name (--installroot)", err.Error())
+}
+
+func TestShouldFailParsingOsReleaseWithoutVersionID_SystemConfig(t *testing.T) {
+	badOsReleaseConfig := validSystemConfig
+	badOsReleaseConfig.OsRelease = map[string]string{"ID": "contoso"}
+
+	err := badOsReleaseConfig.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [OsRelease]: missing required key (VERSION_ID)", err.Error())
+}
+
+func TestShouldFailParsingInvalidOsReleaseKey_SystemConfig(t *testing.T) {
+	badOsReleaseConfig := validSystemConfig
+	badOsReleaseConfig.OsRelease = map[string]string{"ID": "contoso", "VERSION_ID": "2.1", "pretty_name": "Contoso"}
+
+	err := badOsReleaseConfig.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [OsRelease]: invalid key (pretty_name), must be upper case letters, digits and underscores", err.Error())
+}
+
+func TestShouldSucceedParsingScopedUpdateRepo_SystemConfig(t *testing.T) {
+	var checkedSystemConfig SystemConfig
+
+	updateConfig := validSystemConfig
+	updateConfig.UpdateExistingPackages = true
+	updateConfig.UpdateRepo = "security-delta"
+
+	err := remarshalJSON(updateConfig, &checkedSystemConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, updateConfig, checkedSystemConfig)
+}
+
+func TestShouldFailParsingUpdateRepoWithoutUpdate_SystemConfig(t *testing.T) {
+	badUpdateConfig := validSystemConfig
+	badUpdateConfig.UpdateRepo = "security-delta"
+
+	err := badUpdateConfig.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [UpdateRepo]: requires [UpdateExistingPackages] to be enabled", err.Error())
+}
+
+func TestShouldFailParsingInvalidUpdateRepo_SystemConfig(t *testing.T) {
+	badUpdateConfig := validSystemConfig
+	badUpdateConfig.UpdateExistingPackages = true
+	badUpdateConfig.UpdateRepo = "[security]"
+
+	err := badUpdateConfig.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [UpdateRepo] ([security]), must be a repository ID", err.Error())
+}
+
+func TestShouldSucceedParsingSysctl_SystemConfig(t *testing.T) {
+	var checkedSystemConfig SystemConfig
+
+	sysctlConfig := validSystemConfig
+	sysctlConfig.Sysctl = map[string]string{
+		"vm.swappiness":                   "10",
+		"net.ipv4.conf.br-lan.forwarding": "1",
+		"net.ipv4.ip_local_port_range":    "32768 60999",
+	}
+
+	err := remarshalJSON(sysctlConfig, &checkedSystemConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, sysctlConfig, checkedSystemConfig)
+}
+
+func TestShouldFailParsingInvalidSysctlKey_SystemConfig(t *testing.T) {
+	badSysctlConfig := validSystemConfig
+	badSysctlConfig.Sysctl = map[string]string{"/proc/sys/vm/swappiness": "10"}
+
+	err := badSysctlConfig.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [Sysctl]: invalid key (/proc/sys/vm/swappiness), must be a dot separated path such as 'vm.swappiness'", err.Error())
+}
+
+func TestShouldFailParsingEmptySysctlValue_SystemConfig(t *testing.T) {
+	badSysctlConfig := validSystemConfig
+	badSysctlConfig.Sysctl = map[string]string{"vm.swappiness": " "}
+
+	err := badSysctlConfig.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [Sysctl]: value of key (vm.swappiness) must be non-empty and may not contain line breaks", err.Error())
+}
+
+func TestShouldSucceedParsingDataOnly_SystemConfig(t *testing.T) {
+	var checkedSystemConfig SystemConfig
+
+	dataOnlyConfig := SystemConfig{
+		Name:     "DataVolume",
+		DataOnly: true,
+		PartitionSettings: []PartitionSetting{
+			{ID: "Data", MountPoint: "/"},
+		},
+		AdditionalFiles: map[string]AdditionalFile{
+			"files/data.bin": {Path: "/data.bin", Owner: "1000", Group: "1000", Mode: "0640"},
+		},
+	}
+
+	err := remarshalJSON(dataOnlyConfig, &checkedSystemConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, dataOnlyConfig, checkedSystemConfig)
+}
+
+func TestShouldFailParsingDataOnlyWithPackages_SystemConfig(t *testing.T) {
+	badDataOnlyConfig := validSystemConfig
+	badDataOnlyConfig.DataOnly = true
+	badDataOnlyConfig.BootType = ""
+
+	err := badDataOnlyConfig.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [DataOnly]: [Encryption] requires an operating system and may not be set", err.Error())
+}
+
+func TestShouldFailParsingDataOnlyWithIncludedKernelModules_SystemConfig(t *testing.T) {
+	badDataOnlyConfig := SystemConfig{
+		Name:     "DataVolume",
+		DataOnly: true,
+		PartitionSettings: []PartitionSetting{
+			{ID: "Data", MountPoint: "/"},
+		},
+		KernelModules: KernelModules{Include: []string{"virtio_blk"}},
+	}
+
+	err := badDataOnlyConfig.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [DataOnly]: [KernelModules] requires an operating system and may not be set", err.Error())
+}
+
+func TestShouldFailParsingDataOnlyWithOperatingSystemSettings_SystemConfig(t *testing.T) {
+	tests := []struct {
+		setting string
+		modify  func(config *SystemConfig)
+	}{
+		{"Timezone", func(config *SystemConfig) { config.Timezone = "UTC" }},
+		{"Hostname", func(config *SystemConfig) { config.Hostname = "data" }},
+		{"Sysctl", func(config *SystemConfig) { config.Sysctl = map[string]string{"vm.swappiness": "10"} }},
+		{"MinimizeImage", func(config *SystemConfig) { config.MinimizeImage = true }},
+		{"RemoveRpmDb", func(config *SystemConfig) { config.RemoveRpmDb = true }},
+		{"KernelModules", func(config *SystemConfig) { config.KernelModules = KernelModules{Blacklist: []string{"floppy"}} }},
+	}
+
+	for _, test := range tests {
+		badDataOnlyConfig := SystemConfig{
+			Name:     "DataVolume",
+			DataOnly: true,
+			PartitionSettings: []PartitionSetting{
+				{ID: "Data", MountPoint: "/"},
+			},
+		}
+		test.modify(&badDataOnlyConfig)
+
+		err := badDataOnlyConfig.IsValid()
+		assert.Error(t, err)
+		assert.Equal(t, "invalid [DataOnly]: ["+test.setting+"] requires an operating system and may not be set", err.Error())
+	}
+}
+
+func TestShouldFailParsingDataOnlyWithNamedOwner_SystemConfig(t *testing.T) {
+	badDataOnlyConfig := SystemConfig{
+		Name:     "DataVolume",
+		DataOnly: true,
+		PartitionSettings: []PartitionSetting{
+			{ID: "Data", MountPoint: "/"},
+		},
+		AdditionalFiles: map[string]AdditionalFile{
+			"files/data.bin": {Path: "/data.bin", Owner: "root"},
+		},
+	}
+
+	err := badDataOnlyConfig.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [DataOnly]: [AdditionalFiles] '/data.bin' must use a numeric [Owner] and [Group], found 'root'", err.Error())
+}
+
+func TestShouldFailParsingPackagePostConditionsWithoutRpmDb_SystemConfig(t *testing.T) {
+	badPostConditionsConfig := validSystemConfig
+	badPostConditionsConfig.RemoveRpmDb = true
+	badPostConditionsConfig.Validate = PostConditions{
+		Packages: []string{"openssh-server"},
+	}
+
+	err := badPostConditionsConfig.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [Validate]: [Packages] can't be checked once [RemoveRpmDb] removes the RPM database", err.Error())
+}
+
+func TestShouldAddTargetSuffixToDefaultTarget_SystemConfig(t *testing.T) {
+	targetConfig := validSystemConfig
+
+	targetConfig.DefaultTarget = "multi-user"
+	assert.NoError(t, targetConfig.IsValid())
+	assert.Equal(t, "multi-user.target", targetConfig.GetDefaultTargetUnit())
+
+	targetConfig.DefaultTarget = "graphical.target"
+	assert.NoError(t, targetConfig.IsValid())
+	assert.Equal(t, "graphical.target", targetConfig.GetDefaultTargetUnit())
+}
+
+func TestShouldFailParsingInvalidDefaultTarget_SystemConfig(t *testing.T) {
+	badTargetConfig := validSystemConfig
+	badTargetConfig.DefaultTarget = "../graphical.target"
+
+	err := badTargetConfig.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [DefaultTarget] (../graphical.target), must be a systemd target such as 'multi-user' or 'graphical.target'", err.Error())
+}
+
+func TestShouldFailParsingInvalidDefaultKernel_SystemConfig(t *testing.T) {
+	badKernelConfig := validSystemConfig
+	badKernelConfig.DefaultKernel = "/boot/vmlinuz-5.15.80.1-1.cm2"
+
+	err := badKernelConfig.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [DefaultKernel] (/boot/vmlinuz-5.15.80.1-1.cm2), must be a kernel version or kernel package name", err.Error())
+}
+
+func TestShouldFailParsingReadOnlyRootWithVerityRoot_SystemConfig(t *testing.T) {
+	badReadOnlyConfig := validSystemConfig
+	badReadOnlyConfig.ReadOnlyRoot = ReadOnlyRoot{Enable: true, WritableDirs: []string{"/var"}}
+	badReadOnlyConfig.ReadOnlyVerityRoot = validSystemConfig.ReadOnlyVerityRoot
+	badReadOnlyConfig.ReadOnlyVerityRoot.Enable = true
+	badReadOnlyConfig.Encryption = RootEncryption{}
+
+	err := badReadOnlyConfig.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [ReadOnlyRoot]: can't be used together with [ReadOnlyVerityRoot]", err.Error())
+}
+
+func TestShouldFailParsingReadOnlyRootPersistentDirWithoutPartition_SystemConfig(t *testing.T) {
+	badReadOnlyConfig := validSystemConfig
+	badReadOnlyConfig.ReadOnlyRoot = ReadOnlyRoot{Enable: true, WritableDirs: []string{"/var"}, PersistentDir: "/persistent"}
+
+	err := badReadOnlyConfig.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [ReadOnlyRoot]: [PersistentDir] (/persistent) must be the mount point of a writable partition", err.Error())
+}
+
+func TestShouldSucceedParsingNtpServers_SystemConfig(t *testing.T) {
+	ntpConfig := validSystemConfig
+	ntpConfig.NtpServers = []string{"time.windows.com", "10.0.0.1", "fd00::1"}
+
+	assert.NoError(t, ntpConfig.IsValid())
+}
+
+func TestShouldFailParsingInvalidNtpServer_SystemConfig(t *testing.T) {
+	badNtpConfig := validSystemConfig
+	badNtpConfig.NtpServers = []string{"time.windows.com iburst"}
+
+	err := badNtpConfig.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [NtpServers]: (time.windows.com iburst) must be an IP address or a host name such as 'time.windows.com'", err.Error())
+}
+
+func TestShouldFailParsingEspWithoutEfiBootType_SystemConfig(t *testing.T) {
+	badEspConfig := validSystemConfig
+	badEspConfig.BootType = "legacy"
+	badEspConfig.Esp = validEsp
+
+	err := badEspConfig.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [Esp]: requires [BootType] 'efi' or 'hybrid', found 'legacy'", err.Error())
+}
+
+func TestShouldFailParsingSecureBootWithoutEfiBootType_SystemConfig(t *testing.T) {
+	badSecureBootConfig := validSystemConfig
+	badSecureBootConfig.BootType = "legacy"
+	badSecureBootConfig.SecureBoot = validSecureBoot
+
+	err := badSecureBootConfig.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [SecureBoot]: requires [BootType] 'efi' or 'hybrid', found 'legacy'", err.Error())
+}
+
+func TestShouldFailParsingDuplicateSystemdDropIn_SystemConfig(t *testing.T) {
+	badDropInConfig := validSystemConfig
+	namedDropIn := validSystemdDropIn
+	namedDropIn.Name = DefaultSystemdDropInName
+	badDropInConfig.SystemdDropIns = []SystemdDropIn{validSystemdDropIn, namedDropIn}
+
+	err := badDropInConfig.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [SystemdDropIns]: (sshd.service.d/override.conf) is listed more than once", err.Error())
+}
+
+func TestShouldAppendCrashKernelToKernelCommandLine_SystemConfig(t *testing.T) {
+	kdumpConfig := validSystemConfig
+	kdumpConfig.KernelCommandLine.ExtraCommandLine = "console=ttyS0"
+	kdumpConfig.Kdump = Kdump{Enable: true, CrashKernel: "256M"}
+
+	assert.NoError(t, kdumpConfig.IsValid())
+	assert.Equal(t, "console=ttyS0 crashkernel=256M", kdumpConfig.GetKernelCommandLine().ExtraCommandLine)
+	assert.Equal(t, "console=ttyS0", kdumpConfig.KernelCommandLine.ExtraCommandLine)
+}
+
+func TestShouldAppendEarlyConsoleToKernelCommandLine_SystemConfig(t *testing.T) {
+	consoleConfig := validSystemConfig
+	consoleConfig.KernelCommandLine.ExtraCommandLine = "console=ttyS0"
+	consoleConfig.EarlyConsole = EarlyConsole{Keymap: "us", Font: "eurlatgr"}
+
+	assert.NoError(t, consoleConfig.IsValid())
+	assert.Equal(t, "console=ttyS0 rd.vconsole.keymap=us rd.vconsole.font=eurlatgr", consoleConfig.GetKernelCommandLine().ExtraCommandLine)
+}
+
+func TestShouldFailParsingEarlyConsoleWithDifferentKeymap_SystemConfig(t *testing.T) {
+	badConsoleConfig := validSystemConfig
+	badConsoleConfig.Keymap = "us"
+	badConsoleConfig.EarlyConsole = EarlyConsole{Keymap: "de"}
+
+	err := badConsoleConfig.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [EarlyConsole]: [Keymap] (de) differs from the system config's [Keymap] (us)", err.Error())
+}
+
+func TestShouldFailParsingKdumpWithCrashKernelArgument_SystemConfig(t *testing.T) {
+	badKdumpConfig := validSystemConfig
+	badKdumpConfig.KernelCommandLine.ExtraCommandLine = "crashkernel=128M"
+	badKdumpConfig.Kdump = Kdump{Enable: true, CrashKernel: "256M"}
+
+	err := badKdumpConfig.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [Kdump]: [KernelCommandLine] already sets crashkernel, set it through [Kdump] only", err.Error())
+}
+
+func TestShouldFailParsingGrubMkconfigWithRescueBootEntry_SystemConfig(t *testing.T) {
+	badGrubConfig := validSystemConfig
+	badGrubConfig.GrubMkconfig = GrubMkconfig{Enable: true}
+	badGrubConfig.RescueBootEntry = RescueBootEntry{Enable: true}
+
+	err := badGrubConfig.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [GrubMkconfig]: can't be used together with [RescueBootEntry], [GrubPassword] or [GrubCfgTemplate]", err.Error())
+}
+
+func TestShouldFailParsingInvalidAdditionalFilesCheck_SystemConfig(t *testing.T) {
+	badCheckConfig := validSystemConfig
+	badCheckConfig.AdditionalFilesCheck = "ignore"
+
+	err := badCheckConfig.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [AdditionalFilesCheck] (ignore), must be 'warn' or 'error'", err.Error())
+}
+
+func TestShouldSucceedParsingGrubEnv_SystemConfig(t *testing.T) {
+	var checkedSystemConfig SystemConfig
+
+	grubEnvConfig := validSystemConfig
+	grubEnvConfig.GrubEnv = map[string]string{"boot_success": "0", "boot_counter": "3"}
+
+	assert.NoError(t, grubEnvConfig.IsValid())
+	err := remarshalJSON(grubEnvConfig, &checkedSystemConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, grubEnvConfig, checkedSystemConfig)
+}
+
+func TestShouldFailParsingInvalidGrubEnv_SystemConfig(t *testing.T) {
+	badGrubEnvConfig := validSystemConfig
+
+	badGrubEnvConfig.GrubEnv = map[string]string{"1st_boot": "1"}
+	err := badGrubEnvConfig.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [GrubEnv]: invalid variable name (1st_boot), must be letters, digits and underscores, not starting with a digit", err.Error())
+
+	badGrubEnvConfig.GrubEnv = map[string]string{"mariner_cmdline": "quiet"}
+	err = badGrubEnvConfig.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [GrubEnv]: variable (mariner_cmdline) is set by the image's grub configuration and can't be overridden", err.Error())
+
+	badGrubEnvConfig.GrubEnv = map[string]string{"boot_counter": "3\n"}
+	err = badGrubEnvConfig.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [GrubEnv]: value of variable (boot_counter) may not contain line breaks", err.Error())
+
+	badGrubEnvConfig.GrubEnv = map[string]string{"payload": strings.Repeat("x", 1000)}
+	err = badGrubEnvConfig.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [GrubEnv]: variables need 1034 bytes, which exceeds the 1024 bytes of the grubenv block", err.Error())
+
+	badGrubEnvConfig.GrubEnv = map[string]string{"boot_counter": "3"}
+	badGrubEnvConfig.BootType = "none"
+	err = badGrubEnvConfig.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [GrubEnv]: can't be used with a [BootType] of 'none'", err.Error())
+}
+
+func TestShouldMergeInstallOptionsIntoTdnfOptions_SystemConfig(t *testing.T) {
+	installOptionsConfig := validSystemConfig
+	installOptionsConfig.InstallOptions = InstallOptions{NoDocs: true}
+	installOptionsConfig.TdnfOptions = map[string]string{"timeout": "120"}
+
+	assert.NoError(t, installOptionsConfig.IsValid())
+	assert.Equal(t, map[string]string{"timeout": "120", "tsflags": "nodocs"}, installOptionsConfig.GetTdnfOptions())
+}
+
+func TestShouldFailParsingInstallOptionsAlsoInTdnfOptions_SystemConfig(t *testing.T) {
+	badInstallOptionsConfig := validSystemConfig
+	badInstallOptionsConfig.InstallOptions = InstallOptions{NoWeakDeps: true}
+	badInstallOptionsConfig.TdnfOptions = map[string]string{"install_weak_deps": "True"}
+
+	err := badInstallOptionsConfig.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [TdnfOptions]: option (install_weak_deps) is already set by [InstallOptions]", err.Error())
+}
+
+func TestShouldFailParsingReadOnlyEtcWithReadOnlyRoot_SystemConfig(t *testing.T) {
+	badReadOnlyEtcConfig := validSystemConfig
+	badReadOnlyEtcConfig.ReadOnlyEtc = ReadOnlyEtc{Enable: true}
+	badReadOnlyEtcConfig.ReadOnlyRoot = ReadOnlyRoot{Enable: true}
+
+	err := badReadOnlyEtcConfig.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [ReadOnlyEtc]: can't be used together with [ReadOnlyRoot] or [ReadOnlyVerityRoot], which already make /etc read-only", err.Error())
+}
+
+func TestShouldRequireGrowFsMarkerWritableWithReadOnlyEtc_SystemConfig(t *testing.T) {
+	growFsConfig := validSystemConfig
+	growFsConfig.PartitionSettings = []PartitionSetting{
+		{ID: "MyRootfs", MountPoint: "/", GrowFsOnBoot: true},
+	}
+	growFsConfig.ReadOnlyEtc = ReadOnlyEtc{Enable: true}
+
+	err := growFsConfig.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [ReadOnlyEtc]: [GrowFsOnBoot] needs (/etc/imager) in [WritableDirs]", err.Error())
+
+	growFsConfig.ReadOnlyEtc.WritableDirs = []string{"/etc/imager"}
+	assert.NoError(t, growFsConfig.IsValid())
+}
@@ -0,0 +1,63 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Parser for the image builder's configuration schemas.
+
+package configuration
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	// loader.conf accepts a timeout in seconds or one of the special menu values
+	systemdBootTimeoutRegex = regexp.MustCompile(`^(\d+|menu-force|menu-hidden|menu-disabled)$`)
+)
+
+// SystemdBoot holds the settings written into the systemd-boot loader.conf
+// file for images which boot through systemd-boot rather than grub.
+//   - DefaultEntry: The boot entry (or glob pattern) selected by default
+//   - Timeout: Seconds to show the boot menu for, or one of "menu-force",
+//     "menu-hidden", "menu-disabled"
+type SystemdBoot struct {
+	DefaultEntry string `json:"DefaultEntry"`
+	Timeout      string `json:"Timeout"`
+}
+
+// IsEnabled returns true if any loader.conf setting was requested.
+func (s *SystemdBoot) IsEnabled() bool {
+	return s.DefaultEntry != "" || s.Timeout != ""
+}
+
+// IsValid returns an error if the SystemdBoot is not valid
+func (s *SystemdBoot) IsValid() (err error) {
+	if strings.ContainsAny(s.DefaultEntry, " \t\n") {
+		return fmt.Errorf("invalid [DefaultEntry] (%s), may not contain whitespace", s.DefaultEntry)
+	}
+
+	if s.Timeout != "" && !systemdBootTimeoutRegex.MatchString(s.Timeout) {
+		return fmt.Errorf("invalid [Timeout] (%s), must be a number of seconds or one of 'menu-force', 'menu-hidden', 'menu-disabled'", s.Timeout)
+	}
+
+	return
+}
+
+// UnmarshalJSON Unmarshals a SystemdBoot entry
+func (s *SystemdBoot) UnmarshalJSON(b []byte) (err error) {
+	// Use an intermediate type which will use the default JSON unmarshal implementation
+	type IntermediateTypeSystemdBoot SystemdBoot
+	err = json.Unmarshal(b, (*IntermediateTypeSystemdBoot)(s))
+	if err != nil {
+		return fmt.Errorf("failed to parse [SystemdBoot]: %w", err)
+	}
+
+	// Now validate the resulting unmarshaled object
+	err = s.IsValid()
+	if err != nil {
+		return fmt.Errorf("failed to parse [SystemdBoot]: %w", err)
+	}
+	return
+}
@@ -0,0 +1,62 @@
+// Copyright Microsoft Corporation.
+// Licensed under the MIT License.
+
+package configuration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//TestMain found in configuration_test.go.
+
+var (
+	validSystemdBoot SystemdBoot = SystemdBoot{
+		DefaultEntry: "mariner-*.conf",
+		Timeout:      "5",
+	}
+	invalidSystemdBootJSON = `{"Timeout": "five"}`
+)
+
+func TestShouldSucceedParsingDefaultSystemdBoot_SystemdBoot(t *testing.T) {
+	var checkedSystemdBoot SystemdBoot
+	err := marshalJSONString("{}", &checkedSystemdBoot)
+	assert.NoError(t, err)
+	assert.Equal(t, SystemdBoot{}, checkedSystemdBoot)
+	assert.False(t, checkedSystemdBoot.IsEnabled())
+}
+
+func TestShouldSucceedParsingValidSystemdBoot_SystemdBoot(t *testing.T) {
+	var checkedSystemdBoot SystemdBoot
+
+	assert.NoError(t, validSystemdBoot.IsValid())
+	err := remarshalJSON(validSystemdBoot, &checkedSystemdBoot)
+	assert.NoError(t, err)
+	assert.Equal(t, validSystemdBoot, checkedSystemdBoot)
+	assert.True(t, checkedSystemdBoot.IsEnabled())
+}
+
+func TestShouldSucceedParsingMenuTimeout_SystemdBoot(t *testing.T) {
+	menuSystemdBoot := validSystemdBoot
+	menuSystemdBoot.Timeout = "menu-force"
+
+	assert.NoError(t, menuSystemdBoot.IsValid())
+}
+
+func TestShouldFailParsingInvalidTimeout_SystemdBoot(t *testing.T) {
+	var checkedSystemdBoot SystemdBoot
+
+	err := marshalJSONString(invalidSystemdBootJSON, &checkedSystemdBoot)
+	assert.Error(t, err)
+	assert.Equal(t, "failed to parse [SystemdBoot]: invalid [Timeout] (five), must be a number of seconds or one of 'menu-force', 'menu-hidden', 'menu-disabled'", err.Error())
+}
+
+func TestShouldFailParsingInvalidDefaultEntry_SystemdBoot(t *testing.T) {
+	invalidSystemdBoot := validSystemdBoot
+	invalidSystemdBoot.DefaultEntry = "two entries"
+
+	err := invalidSystemdBoot.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [DefaultEntry] (two entries), may not contain whitespace", err.Error())
+}
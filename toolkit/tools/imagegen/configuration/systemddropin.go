@@ -0,0 +1,142 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Parser for the image builder's configuration schemas.
+
+package configuration
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// DefaultSystemdDropInName is the file name of a drop-in which doesn't set one, as used by "systemctl edit"
+	DefaultSystemdDropInName = "override.conf"
+)
+
+var (
+	// systemdUnitNameRegex matches the name of a unit, or of a unit template such as "getty@.service"
+	systemdUnitNameRegex = regexp.MustCompile(`^[A-Za-z0-9:_.\\-]+(@[A-Za-z0-9:_.\\-]*)?\.(service|socket|device|mount|automount|swap|target|path|timer|slice|scope)$`)
+	// systemdDropInNameRegex matches the file names systemd loads from a unit's drop-in directory
+	systemdDropInNameRegex = regexp.MustCompile(`^[A-Za-z0-9_.@-]+\.conf$`)
+	// systemdSectionRegex matches a section header, ie "[Service]"
+	systemdSectionRegex = regexp.MustCompile(`^\[([A-Za-z][A-Za-z0-9-]*)\]$`)
+	// systemdKeyRegex matches the key of a setting, ie "ExecStart" or "X-Custom"
+	systemdKeyRegex = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9-]*$`)
+)
+
+// SystemdDropIn installs a drop-in overriding settings of a systemd unit, under /etc/systemd/system/<Unit>.d.
+//   - Unit: The unit the drop-in applies to, ie "sshd.service"
+//   - Name: The file name of the drop-in, which must end in ".conf" (default is "override.conf")
+//   - Path: Path of a drop-in file to install
+//   - Lines: Lines of the drop-in to install, one per entry, instead of a file
+type SystemdDropIn struct {
+	Unit  string   `json:"Unit"`
+	Name  string   `json:"Name"`
+	Path  string   `json:"Path"`
+	Lines []string `json:"Lines"`
+}
+
+// GetName returns the file name of the drop-in
+func (s *SystemdDropIn) GetName() string {
+	if s.Name == "" {
+		return DefaultSystemdDropInName
+	}
+	return s.Name
+}
+
+// IsValid returns an error if the SystemdDropIn is not valid
+func (s *SystemdDropIn) IsValid() (err error) {
+	if !systemdUnitNameRegex.MatchString(s.Unit) {
+		return fmt.Errorf("invalid [Unit] (%s), must be the name of a unit such as 'sshd.service'", s.Unit)
+	}
+
+	if s.Name != "" && !systemdDropInNameRegex.MatchString(s.Name) {
+		return fmt.Errorf("invalid [Name] (%s) for (%s), must be a file name ending in '.conf'", s.Name, s.Unit)
+	}
+
+	if (s.Path == "") == (len(s.Lines) == 0) {
+		return fmt.Errorf("exactly one of [Path] and [Lines] must be set for (%s)", s.Unit)
+	}
+
+	if len(s.Lines) != 0 {
+		err = ValidateSystemdDropIn(strings.Join(s.Lines, "\n"))
+		if err != nil {
+			return fmt.Errorf("invalid [Lines] for (%s): %w", s.Unit, err)
+		}
+	}
+
+	return
+}
+
+// ValidateSystemdDropIn checks the contents of a drop-in parse as a systemd unit file: every setting must be a
+// "Key=value" line in a section, and there must be at least one setting.
+func ValidateSystemdDropIn(contents string) (err error) {
+	const lineContinuation = "\\"
+
+	section := ""
+	hasSetting := false
+	lines := strings.Split(contents, "\n")
+	for i := 0; i < len(lines); i++ {
+		lineNumber := i + 1
+		line := strings.TrimSpace(lines[i])
+
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			match := systemdSectionRegex.FindStringSubmatch(line)
+			if match == nil {
+				return fmt.Errorf("line %d: (%s) is not a valid section header such as '[Service]'", lineNumber, line)
+			}
+			section = match[1]
+			continue
+		}
+
+		equalIndex := strings.Index(line, "=")
+		if equalIndex < 0 {
+			return fmt.Errorf("line %d: (%s) is not a 'Key=value' setting", lineNumber, line)
+		}
+		key := strings.TrimSpace(line[:equalIndex])
+		if !systemdKeyRegex.MatchString(key) {
+			return fmt.Errorf("line %d: (%s) is not a valid setting name", lineNumber, key)
+		}
+		if section == "" {
+			return fmt.Errorf("line %d: setting (%s) must be in a section such as '[Service]'", lineNumber, key)
+		}
+		hasSetting = true
+
+		// A trailing backslash continues the value on the next line
+		for strings.HasSuffix(line, lineContinuation) && i+1 < len(lines) {
+			i++
+			line = strings.TrimSpace(lines[i])
+		}
+	}
+
+	if !hasSetting {
+		return fmt.Errorf("drop-in has no settings")
+	}
+
+	return
+}
+
+// UnmarshalJSON Unmarshals a SystemdDropIn entry
+func (s *SystemdDropIn) UnmarshalJSON(b []byte) (err error) {
+	// Use an intermediate type which will use the default JSON unmarshal implementation
+	type IntermediateTypeSystemdDropIn SystemdDropIn
+	err = json.Unmarshal(b, (*IntermediateTypeSystemdDropIn)(s))
+	if err != nil {
+		return fmt.Errorf("failed to parse [SystemdDropIn]: %w", err)
+	}
+
+	// Now validate the resulting unmarshaled object
+	err = s.IsValid()
+	if err != nil {
+		return fmt.Errorf("failed to parse [SystemdDropIn]: %w", err)
+	}
+	return
+}
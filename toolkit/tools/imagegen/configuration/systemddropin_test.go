@@ -0,0 +1,94 @@
+// Copyright Microsoft Corporation.
+// Licensed under the MIT License.
+
+package configuration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//TestMain found in configuration_test.go.
+
+var (
+	validSystemdDropIn SystemdDropIn = SystemdDropIn{
+		Unit: "sshd.service",
+		Lines: []string{
+			"[Service]",
+			"EnvironmentFile=-/etc/contoso/sshd.env",
+			"ExecStart=",
+			"ExecStart=/usr/sbin/sshd -D $OPTIONS",
+		},
+	}
+	invalidSystemdDropInJSON = `{"Unit": "sshd", "Lines": ["[Service]", "Restart=always"]}`
+)
+
+func TestShouldSucceedParsingValidSystemdDropIn_SystemdDropIn(t *testing.T) {
+	var checkedSystemdDropIn SystemdDropIn
+	err := remarshalJSON(validSystemdDropIn, &checkedSystemdDropIn)
+	assert.NoError(t, err)
+	assert.Equal(t, validSystemdDropIn, checkedSystemdDropIn)
+	assert.Equal(t, DefaultSystemdDropInName, checkedSystemdDropIn.GetName())
+}
+
+func TestShouldFailParsingInvalidUnit_SystemdDropIn(t *testing.T) {
+	var checkedSystemdDropIn SystemdDropIn
+	err := marshalJSONString(invalidSystemdDropInJSON, &checkedSystemdDropIn)
+	assert.Error(t, err)
+	assert.Equal(t, "failed to parse [SystemdDropIn]: invalid [Unit] (sshd), must be the name of a unit such as 'sshd.service'", err.Error())
+}
+
+func TestShouldSucceedParsingTemplateUnit_SystemdDropIn(t *testing.T) {
+	for _, unit := range []string{"getty@.service", "getty@tty1.service", "dev-disk-by\\x2dlabel-data.mount"} {
+		dropIn := SystemdDropIn{Unit: unit, Name: "10-timeout.conf", Lines: []string{"[Unit]", "JobTimeoutSec=30"}}
+		assert.NoError(t, dropIn.IsValid(), unit)
+	}
+}
+
+func TestShouldFailParsingInvalidName_SystemdDropIn(t *testing.T) {
+	invalidSystemdDropIn := validSystemdDropIn
+	invalidSystemdDropIn.Name = "override"
+
+	err := invalidSystemdDropIn.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [Name] (override) for (sshd.service), must be a file name ending in '.conf'", err.Error())
+}
+
+func TestShouldFailParsingPathAndLines_SystemdDropIn(t *testing.T) {
+	invalidSystemdDropIn := validSystemdDropIn
+	invalidSystemdDropIn.Path = "dropins/sshd.conf"
+
+	err := invalidSystemdDropIn.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "exactly one of [Path] and [Lines] must be set for (sshd.service)", err.Error())
+}
+
+func TestShouldFailParsingSettingOutsideSection_SystemdDropIn(t *testing.T) {
+	invalidSystemdDropIn := SystemdDropIn{Unit: "sshd.service", Lines: []string{"# Restart on failure", "Restart=always"}}
+
+	err := invalidSystemdDropIn.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [Lines] for (sshd.service): line 2: setting (Restart) must be in a section such as '[Service]'", err.Error())
+}
+
+func TestShouldValidateSystemdDropIn(t *testing.T) {
+	err := ValidateSystemdDropIn("[Service]\nExecStart=\nExecStart=/usr/bin/agent \\\n    --verbose\n; comment\n")
+	assert.NoError(t, err)
+
+	err = ValidateSystemdDropIn("[Service\nRestart=always\n")
+	assert.Error(t, err)
+	assert.Equal(t, "line 1: ([Service) is not a valid section header such as '[Service]'", err.Error())
+
+	err = ValidateSystemdDropIn("[Service]\nRestart always\n")
+	assert.Error(t, err)
+	assert.Equal(t, "line 2: (Restart always) is not a 'Key=value' setting", err.Error())
+
+	err = ValidateSystemdDropIn("[Service]\nRe start=always\n")
+	assert.Error(t, err)
+	assert.Equal(t, "line 2: (Re start) is not a valid setting name", err.Error())
+
+	err = ValidateSystemdDropIn("[Service]\n# no settings\n")
+	assert.Error(t, err)
+	assert.Equal(t, "drop-in has no settings", err.Error())
+}
@@ -0,0 +1,133 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Parser for the image builder's configuration schemas.
+
+package configuration
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	// udevRuleNameRegex matches the file names udev loads from /etc/udev/rules.d
+	udevRuleNameRegex = regexp.MustCompile(`^[A-Za-z0-9_.-]+\.rules$`)
+	// udevKeyValueRegex matches a single key, operator and quoted value of a udev rule, e.g. ATTR{address}=="00:11:22:33:44:55"
+	udevKeyValueRegex = regexp.MustCompile(`^([A-Za-z_]+(\{[^}"]*\})?)\s*(==|!=|\+=|-=|:=|=)\s*"(?:[^"\\]|\\.)*"$`)
+)
+
+// UdevRule installs a udev rules file under /etc/udev/rules.d.
+//   - Name: The file name, which must end in ".rules"
+//   - Path: Path of a rules file to install
+//   - Rules: Rules to install, one per entry, instead of a file
+type UdevRule struct {
+	Name  string   `json:"Name"`
+	Path  string   `json:"Path"`
+	Rules []string `json:"Rules"`
+}
+
+// IsValid returns an error if the UdevRule is not valid
+func (u *UdevRule) IsValid() (err error) {
+	if !udevRuleNameRegex.MatchString(u.Name) {
+		return fmt.Errorf("invalid [Name] (%s), must be a file name ending in '.rules'", u.Name)
+	}
+
+	if (u.Path == "") == (len(u.Rules) == 0) {
+		return fmt.Errorf("exactly one of [Path] and [Rules] must be set for (%s)", u.Name)
+	}
+
+	if len(u.Rules) != 0 {
+		err = ValidateUdevRules(strings.Join(u.Rules, "\n"))
+		if err != nil {
+			return fmt.Errorf("invalid [Rules] for (%s): %w", u.Name, err)
+		}
+	}
+
+	return
+}
+
+// ValidateUdevRules checks the syntax of the contents of a udev rules file. Every rule must be a comma
+// separated list of KEY<operator>"value" pairs and assign at least one key.
+func ValidateUdevRules(rules string) (err error) {
+	const (
+		commentPrefix      = "#"
+		lineContinuation   = "\\"
+		matchOperatorEqual = "=="
+		matchOperatorNot   = "!="
+	)
+
+	lines := strings.Split(rules, "\n")
+	for i := 0; i < len(lines); i++ {
+		lineNumber := i + 1
+		rule := strings.TrimSpace(lines[i])
+
+		// A trailing backslash continues the rule on the next line
+		for strings.HasSuffix(rule, lineContinuation) && i+1 < len(lines) {
+			i++
+			rule = strings.TrimSuffix(rule, lineContinuation) + strings.TrimSpace(lines[i])
+		}
+
+		if rule == "" || strings.HasPrefix(rule, commentPrefix) {
+			continue
+		}
+
+		hasAssignment := false
+		for _, pair := range splitUdevRule(rule) {
+			match := udevKeyValueRegex.FindStringSubmatch(strings.TrimSpace(pair))
+			if match == nil {
+				return fmt.Errorf("line %d: (%s) is not a KEY==\"value\" or KEY=\"value\" pair", lineNumber, strings.TrimSpace(pair))
+			}
+
+			if operator := match[3]; operator != matchOperatorEqual && operator != matchOperatorNot {
+				hasAssignment = true
+			}
+		}
+
+		if !hasAssignment {
+			return fmt.Errorf("line %d: rule only matches keys and never assigns one", lineNumber)
+		}
+	}
+
+	return
+}
+
+// splitUdevRule splits a rule on the commas between its key-value pairs, ignoring commas inside quoted values.
+func splitUdevRule(rule string) (pairs []string) {
+	inQuotes := false
+	escaped := false
+	start := 0
+	for i, char := range rule {
+		switch {
+		case escaped:
+			escaped = false
+		case char == '\\':
+			escaped = true
+		case char == '"':
+			inQuotes = !inQuotes
+		case char == ',' && !inQuotes:
+			pairs = append(pairs, rule[start:i])
+			start = i + 1
+		}
+	}
+	return append(pairs, rule[start:])
+}
+
+// UnmarshalJSON Unmarshals a UdevRule entry
+func (u *UdevRule) UnmarshalJSON(b []byte) (err error) {
+	// Use an intermediate type which will use the default JSON unmarshal implementation
+	type IntermediateTypeUdevRule UdevRule
+	err = json.Unmarshal(b, (*IntermediateTypeUdevRule)(u))
+	if err != nil {
+		return fmt.Errorf("failed to parse [UdevRule]: %w", err)
+	}
+
+	// Now validate the resulting unmarshaled object
+	err = u.IsValid()
+	if err != nil {
+		return fmt.Errorf("failed to parse [UdevRule]: %w", err)
+	}
+	return
+}
@@ -0,0 +1,80 @@
+// Copyright Microsoft Corporation.
+// Licensed under the MIT License.
+
+package configuration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//TestMain found in configuration_test.go.
+
+var (
+	validUdevRule UdevRule = UdevRule{
+		Name: "70-persistent-net.rules",
+		Rules: []string{
+			"# Name the onboard NIC by its MAC address",
+			`SUBSYSTEM=="net", ACTION=="add", ATTR{address}=="00:11:22:33:44:55", NAME="lan0"`,
+			`KERNEL=="ttyUSB*", GROUP="dialout", MODE="0660"`,
+		},
+	}
+	invalidUdevRuleJSON = `{"Name": "70-persistent-net", "Path": "files/70-persistent-net.rules"}`
+)
+
+func TestShouldSucceedParsingValidUdevRule_UdevRule(t *testing.T) {
+	var checkedUdevRule UdevRule
+	err := remarshalJSON(validUdevRule, &checkedUdevRule)
+	assert.NoError(t, err)
+	assert.Equal(t, validUdevRule, checkedUdevRule)
+}
+
+func TestShouldSucceedParsingRuleFile_UdevRule(t *testing.T) {
+	var checkedUdevRule UdevRule
+	err := marshalJSONString(`{"Name": "99-kiosk.rules", "Path": "files/99-kiosk.rules"}`, &checkedUdevRule)
+	assert.NoError(t, err)
+}
+
+func TestShouldFailParsingNameWithoutSuffix_UdevRule(t *testing.T) {
+	var checkedUdevRule UdevRule
+	err := marshalJSONString(invalidUdevRuleJSON, &checkedUdevRule)
+	assert.Error(t, err)
+	assert.Equal(t, "failed to parse [UdevRule]: invalid [Name] (70-persistent-net), must be a file name ending in '.rules'", err.Error())
+}
+
+func TestShouldFailParsingPathAndRules_UdevRule(t *testing.T) {
+	invalidUdevRule := validUdevRule
+	invalidUdevRule.Path = "files/70-persistent-net.rules"
+
+	err := invalidUdevRule.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "exactly one of [Path] and [Rules] must be set for (70-persistent-net.rules)", err.Error())
+}
+
+func TestShouldFailParsingUnquotedValue_UdevRule(t *testing.T) {
+	invalidUdevRule := validUdevRule
+	invalidUdevRule.Rules = []string{`SUBSYSTEM=="net", NAME=lan0`}
+
+	err := invalidUdevRule.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, `invalid [Rules] for (70-persistent-net.rules): line 1: (NAME=lan0) is not a KEY=="value" or KEY="value" pair`, err.Error())
+}
+
+func TestShouldFailParsingMatchOnlyRule_UdevRule(t *testing.T) {
+	invalidUdevRule := validUdevRule
+	invalidUdevRule.Rules = []string{`SUBSYSTEM=="net", ATTR{address}=="00:11:22:33:44:55"`}
+
+	err := invalidUdevRule.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [Rules] for (70-persistent-net.rules): line 1: rule only matches keys and never assigns one", err.Error())
+}
+
+func TestShouldValidateContinuedRules_UdevRule(t *testing.T) {
+	rules := "SUBSYSTEM==\"net\", \\\n  ATTR{address}==\"00:11:22:33:44:55\", \\\n  NAME=\"lan0\"\n"
+	assert.NoError(t, ValidateUdevRules(rules))
+
+	err := ValidateUdevRules("KERNEL==\"sda\", SYMLINK+=\"disk0\"\nKERNEL==\"sdb\" SYMLINK+=\"disk1\"\n")
+	assert.Error(t, err)
+	assert.Equal(t, `line 2: (KERNEL=="sdb" SYMLINK+="disk1") is not a KEY=="value" or KEY="value" pair`, err.Error())
+}
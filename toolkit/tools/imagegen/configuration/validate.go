@@ -0,0 +1,125 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Parser for the image builder's configuration schemas.
+
+package configuration
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ValidateConfigBytes parses and validates the contents of a config JSON file without needing
+// any of the files it references. In addition to the checks run while loading a config, it
+// rejects any fields which are not part of the config schema, such as misspelled keys.
+// Keys starting with an underscore are treated as comments and ignored.
+// The returned error names the offending field.
+func ValidateConfigBytes(data []byte) (config Config, err error) {
+	var rawConfig interface{}
+	err = json.Unmarshal(data, &rawConfig)
+	if err != nil {
+		err = fmt.Errorf("failed to parse [Config]: %w", err)
+		return
+	}
+
+	err = checkUnknownFields(rawConfig, reflect.TypeOf(config), "")
+	if err != nil {
+		err = fmt.Errorf("failed to parse [Config]: %w", err)
+		return
+	}
+
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		return
+	}
+
+	config.setDefaultConfig()
+	err = config.resolvePartitionSizes()
+
+	return
+}
+
+// checkUnknownFields walks a generic JSON value alongside the Go type it should be decoded into
+// and returns an error for the first JSON object key which has no matching struct field.
+func checkUnknownFields(value interface{}, valueType reflect.Type, fieldPath string) (err error) {
+	for valueType.Kind() == reflect.Ptr {
+		valueType = valueType.Elem()
+	}
+
+	switch typedValue := value.(type) {
+	case map[string]interface{}:
+		switch valueType.Kind() {
+		case reflect.Struct:
+			knownFields := jsonFieldsOfStruct(valueType)
+			// Walk the keys in order so the reported error is stable
+			keys := make([]string, 0, len(typedValue))
+			for key := range typedValue {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+
+			for _, key := range keys {
+				if strings.HasPrefix(key, "_") {
+					continue
+				}
+				fieldType, ok := knownFields[strings.ToLower(key)]
+				if !ok {
+					return fmt.Errorf("unknown field %s[%s]", fieldPath, key)
+				}
+				err = checkUnknownFields(typedValue[key], fieldType, fmt.Sprintf("%s[%s]", fieldPath, key))
+				if err != nil {
+					return
+				}
+			}
+		case reflect.Map:
+			for key, element := range typedValue {
+				err = checkUnknownFields(element, valueType.Elem(), fmt.Sprintf("%s[%s]", fieldPath, key))
+				if err != nil {
+					return
+				}
+			}
+		}
+	case []interface{}:
+		if valueType.Kind() != reflect.Slice && valueType.Kind() != reflect.Array {
+			return
+		}
+		for i, element := range typedValue {
+			err = checkUnknownFields(element, valueType.Elem(), fmt.Sprintf("%s[%d]", fieldPath, i))
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	return
+}
+
+// jsonFieldsOfStruct returns the JSON keys accepted by a struct type, lower cased to match
+// encoding/json's case insensitive field matching.
+func jsonFieldsOfStruct(structType reflect.Type) (fields map[string]reflect.Type) {
+	fields = make(map[string]reflect.Type)
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		if field.PkgPath != "" {
+			// Unexported field
+			continue
+		}
+
+		name := field.Name
+		if tag, ok := field.Tag.Lookup("json"); ok {
+			tagName := strings.Split(tag, ",")[0]
+			if tagName == "-" {
+				continue
+			}
+			if tagName != "" {
+				name = tagName
+			}
+		}
+		fields[strings.ToLower(name)] = field.Type
+	}
+	return
+}
@@ -0,0 +1,58 @@
+// Copyright Microsoft Corporation.
+// Licensed under the MIT License.
+
+package configuration
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//TestMain found in configuration_test.go.
+
+func TestShouldSucceedValidatingConfigBytes_Validate(t *testing.T) {
+	data, err := os.ReadFile("testdata/test_configuration.json")
+	assert.NoError(t, err)
+
+	config, err := ValidateConfigBytes(data)
+	assert.NoError(t, err)
+	assert.Equal(t, expectedConfiguration, config)
+}
+
+func TestShouldFailValidatingUnknownField_Validate(t *testing.T) {
+	const configJSON = `{"SystemConfigs": [{"Name": "Test", "PackageLists": ["a.json"], "Hostnme": "typo"}]}`
+
+	_, err := ValidateConfigBytes([]byte(configJSON))
+	assert.Error(t, err)
+	assert.Equal(t, "failed to parse [Config]: unknown field [SystemConfigs][0][Hostnme]", err.Error())
+}
+
+func TestShouldFailValidatingNestedUnknownField_Validate(t *testing.T) {
+	const configJSON = `{"Disks": [{"MaxSize": 10, "Partitions": [{"ID": "a", "Start": 1, "Ende": 2}]}], "SystemConfigs": [{"Name": "Test", "PackageLists": ["a.json"]}]}`
+
+	_, err := ValidateConfigBytes([]byte(configJSON))
+	assert.Error(t, err)
+	assert.Equal(t, "failed to parse [Config]: unknown field [Disks][0][Partitions][0][Ende]", err.Error())
+}
+
+func TestShouldFailValidatingInvalidValue_Validate(t *testing.T) {
+	const configJSON = `{"SystemConfigs": [{"Name": "", "PackageLists": ["a.json"]}]}`
+
+	_, err := ValidateConfigBytes([]byte(configJSON))
+	assert.Error(t, err)
+	assert.Equal(t, "failed to parse [Config]: failed to parse [SystemConfig]: missing [Name] field", err.Error())
+}
+
+func TestShouldFailValidatingMalformedJSON_Validate(t *testing.T) {
+	_, err := ValidateConfigBytes([]byte(`{"SystemConfigs": [`))
+	assert.Error(t, err)
+}
+
+func TestShouldSucceedValidatingCommentField_Validate(t *testing.T) {
+	const configJSON = `{"SystemConfigs": [{"_comment": "A comment", "Name": "Test", "PackageLists": ["a.json"]}]}`
+
+	_, err := ValidateConfigBytes([]byte(configJSON))
+	assert.NoError(t, err)
+}
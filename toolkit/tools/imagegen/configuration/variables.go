@@ -0,0 +1,91 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Parser for the image builder's configuration schemas.
+
+package configuration
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// variableRegex matches "${NAME}" and "${NAME:-default}" references to config variables,
+// and "$${" which escapes a literal "${"
+var variableRegex = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// readConfigFile reads a config file and substitutes the variables it references. Variables not
+// in the map are looked up in the environment.
+func readConfigFile(configFilePath string, variables map[string]string) (data []byte, err error) {
+	data, err = os.ReadFile(configFilePath)
+	if err != nil {
+		return
+	}
+
+	lookup := func(name string) (string, bool) {
+		return lookupVariable(variables, name)
+	}
+	substituted, err := substituteVariables(string(data), lookup)
+	if err != nil {
+		return nil, fmt.Errorf("config file (%s): %w", configFilePath, err)
+	}
+	return []byte(substituted), nil
+}
+
+// lookupVariable returns the value of a variable in the map, or else from the environment
+func lookupVariable(variables map[string]string, name string) (value string, found bool) {
+	value, found = variables[name]
+	if found {
+		return
+	}
+	return os.LookupEnv(name)
+}
+
+// substituteVariables replaces every "${NAME}" in the config JSON with the variable's value, or the
+// default of "${NAME:-default}" when the variable is not defined. Values are escaped for use inside a
+// JSON string, and are also valid as a bare number or boolean. "$${" is replaced with a literal "${".
+// All the undefined variables without a default are reported at once.
+func substituteVariables(configJSON string, lookup func(name string) (string, bool)) (substituted string, err error) {
+	undefined := map[string]bool{}
+
+	substituted = variableRegex.ReplaceAllStringFunc(configJSON, func(reference string) string {
+		if reference == "$${" {
+			return "${"
+		}
+
+		match := variableRegex.FindStringSubmatch(reference)
+		name, hasDefault, defaultValue := match[1], match[2] != "", match[3]
+
+		value, found := lookup(name)
+		if !found {
+			if !hasDefault {
+				undefined[name] = true
+				return reference
+			}
+			// The default is written in the config, so it is already escaped
+			return defaultValue
+		}
+		return escapeJSONString(value)
+	})
+
+	if len(undefined) != 0 {
+		names := make([]string, 0, len(undefined))
+		for name := range undefined {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return "", fmt.Errorf("undefined config variable(s) without a default: %s. Set them with --config-var or in the environment", strings.Join(names, ", "))
+	}
+	return
+}
+
+// escapeJSONString escapes a value for use between the quotes of a JSON string
+func escapeJSONString(value string) string {
+	// Marshaling a string can't fail
+	quoted, _ := json.Marshal(value)
+	return string(quoted[1 : len(quoted)-1])
+}
@@ -0,0 +1,63 @@
+// Copyright Microsoft Corporation.
+// Licensed under the MIT License.
+
+package configuration
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//TestMain found in configuration_test.go.
+
+func testVariableLookup(values map[string]string) func(name string) (string, bool) {
+	return func(name string) (value string, found bool) {
+		value, found = values[name]
+		return
+	}
+}
+
+func TestShouldSubstituteVariables_Variables(t *testing.T) {
+	lookup := testVariableLookup(map[string]string{"VERSION": "2.0", "SIZE": "4096", "QUOTED": `say "hi"`})
+
+	substituted, err := substituteVariables(`{"Name": "core-${VERSION}", "MaxSize": ${SIZE}, "Text": "${QUOTED}"}`, lookup)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"Name": "core-2.0", "MaxSize": 4096, "Text": "say \"hi\""}`, substituted)
+}
+
+func TestShouldUseVariableDefaults_Variables(t *testing.T) {
+	lookup := testVariableLookup(map[string]string{"VERSION": "2.0"})
+
+	substituted, err := substituteVariables(`{"Name": "core-${VERSION:-1.0}", "Repo": "${REPO:-https://example.com/repo}", "Empty": "${EMPTY:-}"}`, lookup)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"Name": "core-2.0", "Repo": "https://example.com/repo", "Empty": ""}`, substituted)
+}
+
+func TestShouldKeepEscapedReferences_Variables(t *testing.T) {
+	substituted, err := substituteVariables(`{"Script": "echo $${HOME} $HOME"}`, testVariableLookup(nil))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"Script": "echo ${HOME} $HOME"}`, substituted)
+}
+
+func TestShouldFailUndefinedVariables_Variables(t *testing.T) {
+	_, err := substituteVariables(`{"Name": "${NAME}-${VERSION}-${NAME}"}`, testVariableLookup(nil))
+	assert.Error(t, err)
+	assert.Equal(t, "undefined config variable(s) without a default: NAME, VERSION. Set them with --config-var or in the environment", err.Error())
+}
+
+func TestShouldPreferVariablesOverEnvironment_Variables(t *testing.T) {
+	t.Setenv("IMAGER_TEST_VERSION", "from-env")
+	t.Setenv("IMAGER_TEST_REPO", "env-repo")
+	variables := map[string]string{"IMAGER_TEST_VERSION": "from-flag"}
+
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(configPath, []byte(`{"A": "${IMAGER_TEST_VERSION}", "B": "${IMAGER_TEST_REPO}"}`), 0644)
+	assert.NoError(t, err)
+
+	data, err := readConfigFile(configPath, variables)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"A": "from-flag", "B": "env-repo"}`, string(data))
+}
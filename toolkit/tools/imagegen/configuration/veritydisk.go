@@ -30,7 +30,11 @@ import (
 //     system keyring. The signature file should be called "<Name>.p7" and must be stored in
 //     the initramfs. This signature WILL NOT BE included automatically in the initramfs. It must
 //     be included via an out of band build step (extract initramfs, create signature from root,
-//     add signature file, recompress).
+//     add signature file, recompress), or generated by setting RootHashSigningKey.
+//   - RootHashSigningKey: Path to a PEM private key used to sign the root hash. The detached
+//     PKCS#7 signature is added to the initramfs as "<Name>.p7". Requires RootHashSignatureEnable.
+//   - RootHashSigningCert: Path to the PEM certificate matching RootHashSigningKey. It must be
+//     trusted by the kernel's keyring for the signature to be accepted at boot.
 //   - ValidateOnBoot: Run a validation of the full disk at boot time, normally blocks are validated
 //     only as needed. This can take several minutes if the disk is corrupted.
 //   - VerityErrorBehavior: System behavior when encountering an unrecoverable verity corruption. One
@@ -41,17 +45,27 @@ import (
 //     writable partitions as normal.
 //   - TmpfsOverlayDebugEnabled: Make the tmpfs overlay mounts easily accessible for debugging
 //     purposes. They can be found in /mnt/verity_overlay_debug_tmpfs
+//   - HashAlgorithm: Hash used for the hash tree, one of 'sha1', 'sha256', 'sha512'
+//     (default is 'sha256')
+//   - DataBlockSize, HashBlockSize: Block sizes in bytes of the data and hash devices, a power of
+//     two from 512 to 4096 (default is 4096). These are recorded in the hash tree superblock, so no
+//     extra kernel arguments are needed to open the device at boot.
 type ReadOnlyVerityRoot struct {
 	Enable                       bool                `json:"Enable"`
 	Name                         string              `json:"Name"`
 	ErrorCorrectionEnable        bool                `json:"ErrorCorrectionEnable"`
 	ErrorCorrectionEncodingRoots int                 `json:"ErrorCorrectionEncodingRoots"`
 	RootHashSignatureEnable      bool                `json:"RootHashSignatureEnable"`
+	RootHashSigningKey           string              `json:"RootHashSigningKey"`
+	RootHashSigningCert          string              `json:"RootHashSigningCert"`
 	ValidateOnBoot               bool                `json:"ValidateOnBoot"`
 	VerityErrorBehavior          VerityErrorBehavior `json:"VerityErrorBehavior"`
 	TmpfsOverlays                []string            `json:"TmpfsOverlays"`
 	TmpfsOverlaySize             string              `json:"TmpfsOverlaySize"`
 	TmpfsOverlayDebugEnabled     bool                `json:"TmpfsOverlayDebugEnabled"`
+	HashAlgorithm                string              `json:"HashAlgorithm"`
+	DataBlockSize                uint32              `json:"DataBlockSize"`
+	HashBlockSize                uint32              `json:"HashBlockSize"`
 }
 
 const (
@@ -61,6 +75,8 @@ const (
 	maxErrorCorrectionEncodingRoots = 24
 	minErrorCorrectionEncodingRoots = 2
 	defaultOverlaySize              = "20%"
+	minVerityBlockSize              = 512
+	maxVerityBlockSize              = 4096
 )
 
 var (
@@ -71,6 +87,8 @@ var (
 		ErrorCorrectionEncodingRoots: defaultErrorCorrectionEncodingN,
 		TmpfsOverlaySize:             defaultOverlaySize,
 	}
+	// Hash algorithms supported by both veritysetup and the dm-verity kernel module
+	supportedVerityHashAlgorithms = []string{"sha1", "sha256", "sha512"}
 	// The tmpfs overlay size must be of the form: 1234, 1234(k,m,g), or 20%
 	tmpfsOverlaySizeRegex = regexp.MustCompile(`^(\d+)([kmg%]?)$`)
 )
@@ -99,6 +117,17 @@ func (v *ReadOnlyVerityRoot) IsValid() (err error) {
 		return
 	}
 
+	if err = v.hashSettingsAreValid(); err != nil {
+		return
+	}
+
+	if (v.RootHashSigningKey == "") != (v.RootHashSigningCert == "") {
+		return fmt.Errorf("[RootHashSigningKey] and [RootHashSigningCert] must be set together")
+	}
+	if v.RootHashSigningKey != "" && !v.RootHashSignatureEnable {
+		return fmt.Errorf("[RootHashSigningKey] requires [RootHashSignatureEnable] so the signature is checked at boot")
+	}
+
 	for i, overlayA := range v.TmpfsOverlays {
 		for j, overlayB := range v.TmpfsOverlays {
 			if i == j {
@@ -137,6 +166,34 @@ func (v *ReadOnlyVerityRoot) IsValid() (err error) {
 	return
 }
 
+func (v *ReadOnlyVerityRoot) hashSettingsAreValid() (err error) {
+	if v.HashAlgorithm != "" {
+		supported := false
+		for _, algorithm := range supportedVerityHashAlgorithms {
+			if v.HashAlgorithm == algorithm {
+				supported = true
+				break
+			}
+		}
+		if !supported {
+			return fmt.Errorf("invalid [HashAlgorithm] (%s), must be one of %v", v.HashAlgorithm, supportedVerityHashAlgorithms)
+		}
+	}
+
+	blockSizes := map[string]uint32{"DataBlockSize": v.DataBlockSize, "HashBlockSize": v.HashBlockSize}
+	for _, name := range []string{"DataBlockSize", "HashBlockSize"} {
+		size := blockSizes[name]
+		if size == 0 {
+			continue
+		}
+		if size < minVerityBlockSize || size > maxVerityBlockSize || size&(size-1) != 0 {
+			return fmt.Errorf("invalid [%s] (%d), must be a power of two from %d to %d", name, size, minVerityBlockSize, maxVerityBlockSize)
+		}
+	}
+
+	return
+}
+
 // UnmarshalJSON Unmarshals a ReadOnlyVerityRoot entry
 func (v *ReadOnlyVerityRoot) UnmarshalJSON(b []byte) (err error) {
 	// Use an intermediate type which will use the default JSON unmarshal implementation
@@ -221,3 +221,82 @@ func TestShouldFailParsingInvalidReadOnlyVerityRootBehaviorJSON_ReadOnlyVerityRo
 	assert.Error(t, err)
 	assert.Equal(t, "failed to parse [ReadOnlyVerityRoot]: failed to parse [VerityErrorBehavior]: invalid value for VerityErrorBehavior (not_a_behavior)", err.Error())
 }
+
+func TestShouldSucceedParsingSigningKeyAndCert_ReadOnlyVerityRoot(t *testing.T) {
+	var checkedReadOnlyVerityRoot ReadOnlyVerityRoot
+	signedReadOnlyVerityRoot := validReadOnlyVerityRoot
+	signedReadOnlyVerityRoot.RootHashSignatureEnable = true
+	signedReadOnlyVerityRoot.RootHashSigningKey = "keys/verity.key"
+	signedReadOnlyVerityRoot.RootHashSigningCert = "keys/verity.crt"
+
+	err := remarshalJSON(signedReadOnlyVerityRoot, &checkedReadOnlyVerityRoot)
+	assert.NoError(t, err)
+	assert.Equal(t, signedReadOnlyVerityRoot, checkedReadOnlyVerityRoot)
+}
+
+func TestShouldFailSigningKeyWithoutCert_ReadOnlyVerityRoot(t *testing.T) {
+	var checkedReadOnlyVerityRoot ReadOnlyVerityRoot
+	invalidReadOnlyVerityRoot := validReadOnlyVerityRoot
+	invalidReadOnlyVerityRoot.RootHashSignatureEnable = true
+	invalidReadOnlyVerityRoot.RootHashSigningKey = "keys/verity.key"
+
+	err := invalidReadOnlyVerityRoot.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "[RootHashSigningKey] and [RootHashSigningCert] must be set together", err.Error())
+
+	err = remarshalJSON(invalidReadOnlyVerityRoot, &checkedReadOnlyVerityRoot)
+	assert.Error(t, err)
+	assert.Equal(t, "failed to parse [ReadOnlyVerityRoot]: [RootHashSigningKey] and [RootHashSigningCert] must be set together", err.Error())
+}
+
+func TestShouldFailSigningKeyWithoutSignatureEnable_ReadOnlyVerityRoot(t *testing.T) {
+	invalidReadOnlyVerityRoot := validReadOnlyVerityRoot
+	invalidReadOnlyVerityRoot.RootHashSigningKey = "keys/verity.key"
+	invalidReadOnlyVerityRoot.RootHashSigningCert = "keys/verity.crt"
+
+	err := invalidReadOnlyVerityRoot.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "[RootHashSigningKey] requires [RootHashSignatureEnable] so the signature is checked at boot", err.Error())
+}
+
+func TestShouldSucceedParsingHashSettings_ReadOnlyVerityRoot(t *testing.T) {
+	var checkedReadOnlyVerityRoot ReadOnlyVerityRoot
+	hashReadOnlyVerityRoot := validReadOnlyVerityRoot
+	hashReadOnlyVerityRoot.HashAlgorithm = "sha512"
+	hashReadOnlyVerityRoot.DataBlockSize = 512
+	hashReadOnlyVerityRoot.HashBlockSize = 4096
+
+	err := remarshalJSON(hashReadOnlyVerityRoot, &checkedReadOnlyVerityRoot)
+	assert.NoError(t, err)
+	assert.Equal(t, hashReadOnlyVerityRoot, checkedReadOnlyVerityRoot)
+}
+
+func TestShouldFailInvalidHashAlgorithm_ReadOnlyVerityRoot(t *testing.T) {
+	var checkedReadOnlyVerityRoot ReadOnlyVerityRoot
+	invalidReadOnlyVerityRoot := validReadOnlyVerityRoot
+	invalidReadOnlyVerityRoot.HashAlgorithm = "md5"
+
+	err := invalidReadOnlyVerityRoot.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [HashAlgorithm] (md5), must be one of [sha1 sha256 sha512]", err.Error())
+
+	err = remarshalJSON(invalidReadOnlyVerityRoot, &checkedReadOnlyVerityRoot)
+	assert.Error(t, err)
+	assert.Equal(t, "failed to parse [ReadOnlyVerityRoot]: invalid [HashAlgorithm] (md5), must be one of [sha1 sha256 sha512]", err.Error())
+}
+
+func TestShouldFailInvalidBlockSizes_ReadOnlyVerityRoot(t *testing.T) {
+	invalidReadOnlyVerityRoot := validReadOnlyVerityRoot
+	invalidReadOnlyVerityRoot.DataBlockSize = 1000
+
+	err := invalidReadOnlyVerityRoot.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [DataBlockSize] (1000), must be a power of two from 512 to 4096", err.Error())
+
+	invalidReadOnlyVerityRoot.DataBlockSize = 512
+	invalidReadOnlyVerityRoot.HashBlockSize = 8192
+
+	err = invalidReadOnlyVerityRoot.IsValid()
+	assert.Error(t, err)
+	assert.Equal(t, "invalid [HashBlockSize] (8192), must be a power of two from 512 to 4096", err.Error())
+}
@@ -6,8 +6,13 @@
 package diskutils
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
+	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strconv"
@@ -311,11 +316,22 @@ func DetachLoopbackDevice(diskDevPath string) (err error) {
 }
 
 // CreatePartitions creates partitions on the specified disk according to the disk config
-func CreatePartitions(diskDevPath string, disk configuration.Disk, rootEncryption configuration.RootEncryption, readOnlyRootConfig configuration.ReadOnlyVerityRoot) (partDevPathMap map[string]string, partIDToFsTypeMap map[string]string, encryptedRoot EncryptedRootDevice, readOnlyRoot VerityDevice, err error) {
+func CreatePartitions(diskDevPath string, disk configuration.Disk, rootEncryption configuration.RootEncryption, readOnlyRootConfig configuration.ReadOnlyVerityRoot, partitionSettings []configuration.PartitionSetting, limits shell.ResourceLimits) (partDevPathMap map[string]string, partIDToFsTypeMap map[string]string, encryptedRoot EncryptedRootDevice, readOnlyRoot VerityDevice, err error) {
 	const timeoutInSeconds = "5"
 	partDevPathMap = make(map[string]string)
 	partIDToFsTypeMap = make(map[string]string)
 
+	openedIntegrity := false
+	defer func() {
+		// Close the dm-integrity mappings on failure, the caller only closes them once this succeeded
+		if err != nil && openedIntegrity {
+			closeErr := CloseIntegrityPartitions()
+			if closeErr != nil {
+				logger.Log.Errorf("Failed to close integrity partitions on failed initialization. Error: %s", closeErr)
+			}
+		}
+	}()
+
 	// Clear any old partition table info to prevent errors during partition creation
 	_, stderr, err := shell.Execute("sfdisk", "--delete", diskDevPath)
 	if err != nil {
@@ -339,20 +355,55 @@ func CreatePartitions(diskDevPath string, disk configuration.Disk, rootEncryptio
 	// Partitions assumed to be defined in sorted order
 	for idx, partition := range disk.Partitions {
 		partitionNumber := idx + 1
-		partDevPath, err := CreateSinglePartition(diskDevPath, partitionNumber, partitionTableType.String(), partition)
+		partDevPath, err := CreateSinglePartition(diskDevPath, partitionNumber, partitionTableType.String(), partition, disk.AlignedPartitionStart(partition.Start))
 		if err != nil {
 			logger.Log.Warnf("Failed to create single partition")
 			return partDevPathMap, partIDToFsTypeMap, encryptedRoot, readOnlyRoot, err
 		}
 
-		partFsType, err := FormatSinglePartition(partDevPath, partition)
-		if err != nil {
-			logger.Log.Warnf("Failed to format partition")
-			return partDevPathMap, partIDToFsTypeMap, encryptedRoot, readOnlyRoot, err
+		partEncryption := partitionEncryptionByID(partitionSettings, partition.ID)
+		partIntegrity := partitionIntegrityByID(partitionSettings, partition.ID)
+
+		var partFsType string
+		if partEncryption.Enable {
+			if partition.SourceImage != "" || partition.HasFlag(configuration.PartitionFlagDeviceMapperRoot) {
+				err = fmt.Errorf("partition (%s) can not be encrypted: encryption is not supported for imported or device mapper root partitions", partition.ID)
+				return partDevPathMap, partIDToFsTypeMap, encryptedRoot, readOnlyRoot, err
+			}
+			partFsType = partition.FsType
+			partDevPathMap[partition.ID], err = encryptDataPartition(partDevPath, partition, partEncryption, limits)
+			if err != nil {
+				logger.Log.Warnf("Failed to initialize encrypted partition")
+				return partDevPathMap, partIDToFsTypeMap, encryptedRoot, readOnlyRoot, err
+			}
+		} else if partIntegrity.Enable {
+			if partition.SourceImage != "" || partition.HasFlag(configuration.PartitionFlagDeviceMapperRoot) {
+				err = fmt.Errorf("partition (%s) can not use dm-integrity: it is not supported for imported or device mapper root partitions", partition.ID)
+				return partDevPathMap, partIDToFsTypeMap, encryptedRoot, readOnlyRoot, err
+			}
+			partFsType = partition.FsType
+			openedIntegrity = true
+			partDevPathMap[partition.ID], err = formatIntegrityPartition(partDevPath, partition, partIntegrity, limits)
+			if err != nil {
+				logger.Log.Warnf("Failed to initialize integrity partition")
+				return partDevPathMap, partIDToFsTypeMap, encryptedRoot, readOnlyRoot, err
+			}
+		} else if partition.SourceImage != "" {
+			partFsType, err = ImportPartitionImage(partDevPath, partition)
+			if err != nil {
+				logger.Log.Warnf("Failed to import partition image")
+				return partDevPathMap, partIDToFsTypeMap, encryptedRoot, readOnlyRoot, err
+			}
+		} else {
+			partFsType, err = FormatSinglePartition(partDevPath, partition, limits)
+			if err != nil {
+				logger.Log.Warnf("Failed to format partition")
+				return partDevPathMap, partIDToFsTypeMap, encryptedRoot, readOnlyRoot, err
+			}
 		}
 
 		if rootEncryption.Enable && partition.HasFlag(configuration.PartitionFlagDeviceMapperRoot) {
-			encryptedRoot, err = encryptRootPartition(partDevPath, partition, rootEncryption)
+			encryptedRoot, err = encryptRootPartition(partDevPath, partition, rootEncryption, limits)
 			if err != nil {
 				logger.Log.Warnf("Failed to initialize encrypted root")
 				return partDevPathMap, partIDToFsTypeMap, encryptedRoot, readOnlyRoot, err
@@ -365,7 +416,7 @@ func CreatePartitions(diskDevPath string, disk configuration.Disk, rootEncryptio
 				return partDevPathMap, partIDToFsTypeMap, encryptedRoot, readOnlyRoot, err
 			}
 			partDevPathMap[partition.ID] = readOnlyRoot.MappedDevice
-		} else {
+		} else if !partEncryption.Enable && !partIntegrity.Enable {
 			partDevPathMap[partition.ID] = partDevPath
 		}
 
@@ -374,31 +425,58 @@ func CreatePartitions(diskDevPath string, disk configuration.Disk, rootEncryptio
 	return
 }
 
+// partitionEncryptionByID returns the encryption settings of the partition setting matching partID
+func partitionEncryptionByID(partitionSettings []configuration.PartitionSetting, partID string) (encryption configuration.PartitionEncryption) {
+	for _, partitionSetting := range partitionSettings {
+		if partitionSetting.ID == partID {
+			return partitionSetting.Encryption
+		}
+	}
+	return
+}
+
+// partitionIntegrityByID returns the integrity settings of the partition setting matching partID
+func partitionIntegrityByID(partitionSettings []configuration.PartitionSetting, partID string) (integrity configuration.PartitionIntegrity) {
+	for _, partitionSetting := range partitionSettings {
+		if partitionSetting.ID == partID {
+			return partitionSetting.Integrity
+		}
+	}
+	return
+}
+
 // CreateSinglePartition creates a single partition based on the partition config
-func CreateSinglePartition(diskDevPath string, partitionNumber int, partitionTableType string, partition configuration.Partition) (partDevPath string, err error) {
+// - alignedStart is the partition's start offset in bytes once the disk's [PartitionAlignment] is applied
+func CreateSinglePartition(diskDevPath string, partitionNumber int, partitionTableType string, partition configuration.Partition, alignedStart uint64) (partDevPath string, err error) {
 	const (
 		fillToEndOption  = "100%"
 		mibFmt           = "%dMiB"
+		sectorFmt        = "%ds"
+		sectorSize       = 512
 		timeoutInSeconds = "5"
+		// An aligned start offset must not be moved by parted
+		minimalAlignment = "minimal"
 	)
-	start := partition.Start
-	end := partition.End
+	start := fmt.Sprintf(mibFmt, partition.Start)
+	end := fillToEndOption
+	if partition.End != 0 {
+		end = fmt.Sprintf(mibFmt, partition.End)
+	}
 
 	fsType := partition.FsType
 
+	partedArgs := []string{"--timeout", timeoutInSeconds, diskDevPath, "parted", diskDevPath, "--script"}
+	if alignedStart != partition.Start*MiB {
+		start = fmt.Sprintf(sectorFmt, alignedStart/sectorSize)
+		partedArgs = append(partedArgs, "--align", minimalAlignment)
+	}
+
 	// Currently assumes we only make primary partitions.
-	if end == 0 {
-		_, stderr, err := shell.Execute("flock", "--timeout", timeoutInSeconds, diskDevPath, "parted", diskDevPath, "--script", "mkpart", "primary", fsType, fmt.Sprintf(mibFmt, start), fillToEndOption)
-		if err != nil {
-			logger.Log.Warnf("Failed to create partition using parted: %v", stderr)
-			return "", err
-		}
-	} else {
-		_, stderr, err := shell.Execute("flock", "--timeout", timeoutInSeconds, diskDevPath, "parted", diskDevPath, "--script", "mkpart", "primary", fsType, fmt.Sprintf(mibFmt, start), fmt.Sprintf(mibFmt, end))
-		if err != nil {
-			logger.Log.Warnf("Failed to create partition using parted: %v", stderr)
-			return "", err
-		}
+	partedArgs = append(partedArgs, "mkpart", "primary", fsType, start, end)
+	_, stderr, err := shell.Execute("flock", partedArgs...)
+	if err != nil {
+		logger.Log.Warnf("Failed to create partition using parted: %v", stderr)
+		return "", err
 	}
 	// Update kernel partition table information
 	//
@@ -517,7 +595,7 @@ func InitializeSinglePartition(diskDevPath string, partitionNumber int, partitio
 }
 
 // FormatSinglePartition formats the given partition to the type specified in the partition configuration
-func FormatSinglePartition(partDevPath string, partition configuration.Partition) (fsType string, err error) {
+func FormatSinglePartition(partDevPath string, partition configuration.Partition, limits shell.ResourceLimits) (fsType string, err error) {
 	const (
 		totalAttempts = 5
 		retryDuration = time.Second
@@ -533,17 +611,29 @@ func FormatSinglePartition(partDevPath string, partition configuration.Partition
 		if fsType == "fat32" || fsType == "fat16" {
 			fsType = "vfat"
 		}
+		mkfsArgs := []string{"-t", fsType}
+		if len(partition.FsFeatures) != 0 {
+			mkfsArgs = append(mkfsArgs, "-O", partition.GetFsFeaturesArg())
+		}
+		if partition.ReservedBlocksPercent != nil {
+			mkfsArgs = append(mkfsArgs, "-m", partition.GetReservedBlocksPercentArg())
+		}
+		mkfsArgs = append(mkfsArgs, partition.ExtraMkfsArgs...)
+		mkfsArgs = append(mkfsArgs, partDevPath)
+
+		var mkfsStderr string
 		err = retry.Run(func() error {
-			_, stderr, err := shell.Execute("mkfs", "-t", fsType, partDevPath)
+			_, stderr, err := shell.ExecuteLimited(limits, "mkfs", mkfsArgs...)
 			if err != nil {
 				logger.Log.Warnf("Failed to format partition using mkfs: %v", stderr)
+				mkfsStderr = strings.TrimSpace(stderr)
 				return err
 			}
 
 			return err
 		}, totalAttempts, retryDuration)
 		if err != nil {
-			err = fmt.Errorf("could not format partition with type %v after %v retries", fsType, totalAttempts)
+			err = fmt.Errorf("could not format partition (%s) with type %v after %v retries: %s", partition.ID, fsType, totalAttempts, mkfsStderr)
 		}
 	case "":
 		logger.Log.Debugf("No filesystem type specified. Ignoring for partition: %v", partDevPath)
@@ -554,6 +644,246 @@ func FormatSinglePartition(partDevPath string, partition configuration.Partition
 	return
 }
 
+// CheckSinglePartition runs a read-only filesystem check on the given partition.
+// Filesystem types without a supported checker are skipped.
+func CheckSinglePartition(partDevPath, fsType string) (err error) {
+	var fsckArgs []string
+	switch fsType {
+	case "ext2", "ext3", "ext4":
+		// -f forces a full check even if the filesystem looks clean, -n never modifies it
+		fsckArgs = []string{"-f", "-n", partDevPath}
+	case "fat32", "fat16", "vfat":
+		fsType = "vfat"
+		fsckArgs = []string{"-n", partDevPath}
+	default:
+		logger.Log.Debugf("No filesystem check available for type (%s), skipping (%s)", fsType, partDevPath)
+		return
+	}
+
+	logger.Log.Debugf("Checking %s filesystem on (%s)", fsType, partDevPath)
+	stdout, stderr, err := shell.Execute(fmt.Sprintf("fsck.%s", fsType), fsckArgs...)
+	if err != nil {
+		logger.Log.Warn(stdout)
+		logger.Log.Warn(stderr)
+		err = fmt.Errorf("filesystem check of (%s) failed: %w", partDevPath, err)
+	}
+	return
+}
+
+// ResizeSinglePartitionFileSystem resizes the ext2/3/4 file system on the given partition to sizeMiB.
+// The size must be at least the file system's minimum size and at most the size of the partition.
+func ResizeSinglePartitionFileSystem(partDevPath string, sizeMiB uint64) (err error) {
+	minimumSize, err := getExtMinimumSize(partDevPath)
+	if err != nil {
+		return
+	}
+
+	stdout, stderr, err := shell.Execute("blockdev", "--getsize64", partDevPath)
+	if err != nil {
+		logger.Log.Warnf("Failed to get size of partition (%s): %v", partDevPath, stderr)
+		return
+	}
+	partitionSize, err := strconv.ParseUint(strings.TrimSpace(stdout), 10, 64)
+	if err != nil {
+		return fmt.Errorf("failed to parse size of partition (%s): %w", partDevPath, err)
+	}
+
+	targetSize := sizeMiB * MiB
+	if targetSize > partitionSize {
+		return fmt.Errorf("requested file system size of %d MiB exceeds the size of partition (%s) of %d bytes", sizeMiB, partDevPath, partitionSize)
+	}
+	if targetSize < minimumSize {
+		return fmt.Errorf("requested file system size of %d MiB is below the minimum size of the file system on (%s) of %d bytes", sizeMiB, partDevPath, minimumSize)
+	}
+
+	logger.Log.Infof("Resizing file system on (%s) to %d MiB", partDevPath, sizeMiB)
+	_, stderr, err = shell.Execute("resize2fs", partDevPath, fmt.Sprintf("%dM", sizeMiB))
+	if err != nil {
+		logger.Log.Warnf("Failed to resize file system: %v", stderr)
+	}
+	return
+}
+
+// GetPaddedFileSystemSize returns the minimum size of the ext2/3/4 file system on the given partition,
+// rounded up to whole MiBs, plus paddingMiB.
+func GetPaddedFileSystemSize(partDevPath string, paddingMiB uint64) (sizeMiB uint64, err error) {
+	minimumSize, err := getExtMinimumSize(partDevPath)
+	if err != nil {
+		return
+	}
+
+	sizeMiB = (minimumSize+MiB-1)/MiB + paddingMiB
+	return
+}
+
+// checkExtFileSystem forces a check of the ext2/3/4 file system on the given device, repairing what
+// e2fsck can fix without asking. e2fsck exits with 1 once it corrected errors, which is not a failure.
+func checkExtFileSystem(partDevPath string) (stderr string, err error) {
+	const fileSystemErrorsCorrected = 1
+
+	_, stderr, err = shell.Execute("e2fsck", "-f", "-p", partDevPath)
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == fileSystemErrorsCorrected {
+		logger.Log.Infof("Corrected file system errors on (%s)", partDevPath)
+		err = nil
+	}
+	return
+}
+
+// getExtMinimumSize returns the size in bytes the ext2/3/4 file system on the given device can be shrunk to
+func getExtMinimumSize(partDevPath string) (minimumSize uint64, err error) {
+	const minimumSizePrefix = "Estimated minimum size of the filesystem:"
+
+	// resize2fs refuses to resize a file system which has not been freshly checked
+	stderr, err := checkExtFileSystem(partDevPath)
+	if err != nil {
+		logger.Log.Warnf("Failed to check file system before resizing: %v", stderr)
+		return 0, fmt.Errorf("failed to check file system on (%s): %w", partDevPath, err)
+	}
+
+	blockSize, err := getExtBlockSize(partDevPath)
+	if err != nil {
+		return
+	}
+
+	stdout, stderr, err := shell.Execute("resize2fs", "-P", partDevPath)
+	if err != nil {
+		logger.Log.Warnf("Failed to query minimum file system size: %v", stderr)
+		return
+	}
+	var minimumBlocks uint64
+	for _, line := range strings.Split(stdout, "\n") {
+		if strings.HasPrefix(line, minimumSizePrefix) {
+			minimumBlocks, err = strconv.ParseUint(strings.TrimSpace(strings.TrimPrefix(line, minimumSizePrefix)), 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("failed to parse minimum file system size of (%s): %w", partDevPath, err)
+			}
+		}
+	}
+
+	return minimumBlocks * blockSize, nil
+}
+
+// getExtBlockSize returns the block size of the ext2/3/4 file system on the given device
+func getExtBlockSize(partDevPath string) (blockSize uint64, err error) {
+	const blockSizePrefix = "Block size:"
+
+	stdout, stderr, err := shell.Execute("dumpe2fs", "-h", partDevPath)
+	if err != nil {
+		logger.Log.Warnf("Failed to read file system superblock: %v", stderr)
+		return
+	}
+
+	for _, line := range strings.Split(stdout, "\n") {
+		if strings.HasPrefix(line, blockSizePrefix) {
+			return strconv.ParseUint(strings.TrimSpace(strings.TrimPrefix(line, blockSizePrefix)), 10, 64)
+		}
+	}
+
+	err = fmt.Errorf("no block size found in the superblock of (%s)", partDevPath)
+	return
+}
+
+// ImportPartitionImage copies the raw partition image referenced by the partition configuration
+// verbatim into the given partition. The image must fit inside the partition.
+func ImportPartitionImage(partDevPath string, partition configuration.Partition) (fsType string, err error) {
+	fsType = partition.FsType
+	if fsType == "fat32" || fsType == "fat16" {
+		fsType = "vfat"
+	}
+
+	imageInfo, err := os.Stat(partition.SourceImage)
+	if err != nil {
+		err = fmt.Errorf("failed to read partition image (%s): %w", partition.SourceImage, err)
+		return
+	}
+
+	stdout, stderr, err := shell.Execute("blockdev", "--getsize64", partDevPath)
+	if err != nil {
+		logger.Log.Warnf("Failed to get size of partition (%s): %v", partDevPath, stderr)
+		return
+	}
+	partitionSize, err := strconv.ParseUint(strings.TrimSpace(stdout), 10, 64)
+	if err != nil {
+		err = fmt.Errorf("failed to parse size of partition (%s): %w", partDevPath, err)
+		return
+	}
+
+	imageSize := uint64(imageInfo.Size())
+	if imageSize > partitionSize {
+		err = fmt.Errorf("partition image (%s) is %s, which does not fit in partition '%s' of %s", partition.SourceImage, BytesToSizeAndUnit(imageSize), partition.ID, BytesToSizeAndUnit(partitionSize))
+		return
+	}
+
+	logger.Log.Infof("Importing partition image (%s) into partition '%s'", partition.SourceImage, partition.ID)
+	ddArgs := []string{
+		fmt.Sprintf("if=%s", partition.SourceImage), // Input file.
+		fmt.Sprintf("of=%s", partDevPath),           // Output file.
+		fmt.Sprintf("bs=%d", MiB),                   // Size of one copied block.
+		"conv=notrunc,fsync",                        // Prevent truncation and flush the data to the device.
+	}
+	_, stderr, err = shell.Execute("dd", ddArgs...)
+	if err != nil {
+		logger.Log.Warnf("Failed to import partition image with dd: %v", stderr)
+		return
+	}
+
+	if partition.RegenerateUUID {
+		err = regenerateFsUUID(partDevPath, fsType)
+		if err != nil {
+			return
+		}
+	}
+
+	// The imported file system keeps its own reservation unless one is requested
+	if partition.ReservedBlocksPercent != nil {
+		logger.Log.Infof("Setting reserved blocks of partition '%s' to %s%%", partition.ID, partition.GetReservedBlocksPercentArg())
+		_, stderr, err = shell.Execute("tune2fs", "-m", partition.GetReservedBlocksPercentArg(), partDevPath)
+		if err != nil {
+			err = fmt.Errorf("failed to set reserved blocks of partition '%s': %v: %w", partition.ID, stderr, err)
+		}
+	}
+
+	return
+}
+
+// regenerateFsUUID gives the unmounted file system on a partition a new random UUID.
+// FAT file systems have a 32-bit volume ID instead, which is replaced the same way.
+func regenerateFsUUID(partDevPath, fsType string) (err error) {
+	const fatVolumeIDBytes = 4
+
+	logger.Log.Infof("Regenerating the file system UUID of (%s)", partDevPath)
+
+	var stderr string
+	switch fsType {
+	case "ext2", "ext3", "ext4":
+		// tune2fs refuses to change the UUID of a file system with checksums until it has been checked
+		stderr, err = checkExtFileSystem(partDevPath)
+		if err != nil {
+			return fmt.Errorf("failed to check file system on (%s) before changing its UUID: %v: %w", partDevPath, stderr, err)
+		}
+		_, stderr, err = shell.Execute("tune2fs", "-U", "random", partDevPath)
+	case "xfs":
+		_, stderr, err = shell.Execute("xfs_admin", "-U", "generate", partDevPath)
+	case "vfat":
+		volumeID := make([]byte, fatVolumeIDBytes)
+		_, err = rand.Read(volumeID)
+		if err != nil {
+			return fmt.Errorf("failed to generate a volume ID for (%s): %w", partDevPath, err)
+		}
+		_, stderr, err = shell.Execute("fatlabel", "-i", partDevPath, hex.EncodeToString(volumeID))
+	default:
+		return fmt.Errorf("regenerating the UUID of (%s) file systems is not supported", fsType)
+	}
+
+	if err != nil {
+		err = fmt.Errorf("failed to regenerate the file system UUID of (%s): %v: %w", partDevPath, stderr, err)
+	}
+
+	return
+}
+
 // SystemBlockDevices returns all block devices on the host system.
 func SystemBlockDevices() (systemDevices []SystemBlockDevice, err error) {
 	const (
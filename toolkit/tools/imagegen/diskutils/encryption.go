@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"microsoft.com/pkggen/imagegen/configuration"
@@ -19,6 +20,9 @@ import (
 const (
 	// DefaultKeyFilePath points to the initramfs keyfile for the install chroot
 	DefaultKeyFilePath = "/etc/default.keyfile"
+	// DetachedHeaderDir is where detached LUKS headers are written on the build machine,
+	// the imager moves them next to the output image once the build is complete
+	DetachedHeaderDir = "/tmp/luksheaders"
 )
 
 const (
@@ -118,7 +122,8 @@ func GetLuksMappingName(uuid string) (mappingName string) {
 // - partDevPath is the path of the root partition
 // - partition is the configuration
 // - encrypt is the root encryption settings
-func encryptRootPartition(partDevPath string, partition configuration.Partition, encrypt configuration.RootEncryption) (encryptedRoot EncryptedRootDevice, err error) {
+// - limits are the resource limits of mkfs
+func encryptRootPartition(partDevPath string, partition configuration.Partition, encrypt configuration.RootEncryption, limits shell.ResourceLimits) (encryptedRoot EncryptedRootDevice, err error) {
 	const (
 		defaultCipher  = "aes-xts-plain64"
 		defaultKeySize = "256"
@@ -174,7 +179,7 @@ func encryptRootPartition(partDevPath string, partition configuration.Partition,
 	}
 
 	// Create the file system
-	_, stderr, err = shell.Execute("mkfs", "-t", partition.FsType, fullMappedPath)
+	_, stderr, err = shell.ExecuteLimited(limits, "mkfs", "-t", partition.FsType, fullMappedPath)
 	if err != nil {
 		logger.Log.Warnf("Failed to mkfs for partition %v. Error: %v", partDevPath, stderr)
 	}
@@ -182,6 +187,142 @@ func encryptRootPartition(partDevPath string, partition configuration.Partition,
 	return
 }
 
+// encryptDataPartition formats a non-root partition as a LUKS2 volume, opens it and creates
+// its file system on the mapped device.
+// - partDevPath is the path of the partition
+// - partition is the configuration
+// - encrypt is the partition's encryption settings
+// - limits are the resource limits of mkfs
+func encryptDataPartition(partDevPath string, partition configuration.Partition, encrypt configuration.PartitionEncryption, limits shell.ResourceLimits) (mappedPath string, err error) {
+	const (
+		defaultCipher  = "aes-xts-plain64"
+		defaultKeySize = 512
+		defaultHash    = "sha256"
+		defaultLuks    = "luks2"
+	)
+
+	cipher := encrypt.Cipher
+	if cipher == "" {
+		cipher = defaultCipher
+	}
+	keySize := encrypt.KeySize
+	if keySize == 0 {
+		keySize = defaultKeySize
+	}
+
+	// A detached header keeps only ciphertext on the partition, cryptsetup creates the header file itself
+	var headerArgs []string
+	headerDevice := partDevPath
+	if encrypt.DetachedHeader != "" {
+		headerDevice, err = prepareDetachedHeader(encrypt.DetachedHeader)
+		if err != nil {
+			return
+		}
+		headerArgs = []string{"--header", headerDevice}
+	}
+
+	cryptsetupArgs := []string{
+		"-q",
+		"--cipher", cipher,
+		"--key-size", strconv.FormatUint(keySize, 10),
+		"--hash", defaultHash,
+		"--type", defaultLuks,
+	}
+	cryptsetupArgs = append(cryptsetupArgs, headerArgs...)
+	cryptsetupArgs = append(cryptsetupArgs, "luksFormat", partDevPath)
+	_, stderr, err := shell.ExecuteWithStdin(encrypt.Password, "cryptsetup", cryptsetupArgs...)
+	if err != nil {
+		logger.Log.Warnf("Unable to encrypt partition %v. Error: %v.", partDevPath, stderr)
+		return
+	}
+
+	logger.Log.Infof("Encrypted partition %v", partition.ID)
+
+	// Name the mapping after the LUKS header UUID so it matches the crypttab entry written into the image
+	stdout, stderr, err := shell.Execute("cryptsetup", "luksUUID", headerDevice)
+	if err != nil {
+		logger.Log.Warnf("Unable to get LUKS UUID for partition %v. Error: %v", partDevPath, stderr)
+		return
+	}
+	blockDevice := GetLuksMappingName(strings.TrimSpace(stdout))
+
+	openArgs := append([]string{"-q"}, headerArgs...)
+	openArgs = append(openArgs, "open", partDevPath, blockDevice)
+	_, stderr, err = shell.ExecuteWithStdin(encrypt.Password, "cryptsetup", openArgs...)
+	if err != nil {
+		logger.Log.Warnf("Failed to open encrypted partition %v. Error: %v", partDevPath, stderr)
+		return
+	}
+	mappedPath = filepath.Join(mappingFilePath, blockDevice)
+
+	_, err = FormatSinglePartition(mappedPath, partition, limits)
+	if err != nil {
+		logger.Log.Warnf("Failed to format encrypted partition %v", partition.ID)
+	}
+
+	return
+}
+
+// prepareDetachedHeader returns the path the named detached header is written to, removing any
+// header left behind by an earlier build.
+func prepareDetachedHeader(headerName string) (headerPath string, err error) {
+	err = os.MkdirAll(DetachedHeaderDir, os.ModePerm)
+	if err != nil {
+		return
+	}
+
+	headerPath = filepath.Join(DetachedHeaderDir, headerName)
+	err = os.Remove(headerPath)
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	return
+}
+
+// GetMappedBackingDevice returns the block device under a device mapper device, such as the
+// partition an open LUKS volume was created on.
+func GetMappedBackingDevice(mappedPath string) (device string, err error) {
+	const (
+		sysBlockDir = "/sys/block"
+		slavesDir   = "slaves"
+		devDir      = "/dev"
+	)
+
+	dmDevice, err := filepath.EvalSymlinks(mappedPath)
+	if err != nil {
+		return
+	}
+
+	slaves, err := os.ReadDir(filepath.Join(sysBlockDir, filepath.Base(dmDevice), slavesDir))
+	if err != nil {
+		return
+	}
+
+	if len(slaves) != 1 {
+		err = fmt.Errorf("expected one device under (%s), found %d", mappedPath, len(slaves))
+		return
+	}
+
+	device = filepath.Join(devDir, slaves[0].Name())
+	return
+}
+
+// GetLuksUUIDFromMappedPath returns the LUKS UUID encoded in the name of a mapped
+// encrypted device, or an empty string if the path is not a LUKS mapping.
+func GetLuksUUIDFromMappedPath(mappedPath string) (uuid string) {
+	luksPrefix := filepath.Join(mappingFilePath, mappingEncryptedPrefix)
+	if strings.HasPrefix(mappedPath, luksPrefix) {
+		uuid = strings.TrimPrefix(mappedPath, luksPrefix)
+	}
+	return
+}
+
+// CloseEncryptedPartitions closes all opened LUKS mappings. It is used to clean up encrypted
+// data partitions when the root partition is not encrypted.
+func CloseEncryptedPartitions() (err error) {
+	return closeEncryptedDisks()
+}
+
 func createDefaultKeyFile(keyFileDir string) (fullPath string, err error) {
 	const (
 		defaultBs     = "bs=512"
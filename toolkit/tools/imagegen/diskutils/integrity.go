@@ -0,0 +1,88 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Utility to protect partitions with dm-integrity
+
+package diskutils
+
+import (
+	"path/filepath"
+	"strings"
+
+	"microsoft.com/pkggen/imagegen/configuration"
+	"microsoft.com/pkggen/internal/logger"
+	"microsoft.com/pkggen/internal/shell"
+)
+
+const (
+	mappingIntegrityPrefix = "integrity-"
+)
+
+// GetIntegrityMappingName returns the device name under /dev/mapper of a partition's dm-integrity volume
+func GetIntegrityMappingName(partID string) (mappingName string) {
+	return mappingIntegrityPrefix + partID
+}
+
+// GetIntegrityNameFromMappedPath returns the volume name of a mapped dm-integrity device,
+// or an empty string if the path is not a dm-integrity mapping.
+func GetIntegrityNameFromMappedPath(mappedPath string) (name string) {
+	integrityPrefix := filepath.Join(mappingFilePath, mappingIntegrityPrefix)
+	if strings.HasPrefix(mappedPath, integrityPrefix) {
+		name = strings.TrimPrefix(mappedPath, mappingFilePath)
+	}
+	return
+}
+
+// CloseIntegrityPartitions closes all opened dm-integrity mappings.
+func CloseIntegrityPartitions() (err error) {
+	stdout, stderr, err := shell.Execute("dmsetup", "info", "-c", "-o", "Name", "--noheadings")
+	if err != nil {
+		logger.Log.Warnf("Unable to run dmsetup: %v", stderr)
+		return
+	}
+
+	for _, device := range strings.Split(stdout, "\n") {
+		if strings.HasPrefix(device, mappingIntegrityPrefix) {
+			logger.Log.Infof("Closing integrity device: %v", device)
+			_, stderr, err = shell.Execute("integritysetup", "close", device)
+			if err != nil {
+				logger.Log.Warnf("Unable to close integrity device: %v", stderr)
+				return
+			}
+		}
+	}
+
+	return
+}
+
+// formatIntegrityPartition formats a non-root partition with dm-integrity, opens it and creates
+// its file system on the mapped device.
+// - partDevPath is the path of the partition
+// - partition is the configuration
+// - integrity is the partition's integrity settings
+// - limits are the resource limits of mkfs
+func formatIntegrityPartition(partDevPath string, partition configuration.Partition, integrity configuration.PartitionIntegrity, limits shell.ResourceLimits) (mappedPath string, err error) {
+	// Formatting wipes the partition, so every sector has a valid checksum before the file system is created
+	_, stderr, err := shell.Execute("integritysetup", "format", "-q", "--integrity", integrity.GetAlgorithm(), partDevPath)
+	if err != nil {
+		logger.Log.Warnf("Unable to format partition %v with dm-integrity. Error: %v", partDevPath, stderr)
+		return
+	}
+
+	logger.Log.Infof("Formatted partition %v with dm-integrity", partition.ID)
+
+	blockDevice := GetIntegrityMappingName(partition.ID)
+	_, stderr, err = shell.Execute("integritysetup", "open", "--integrity", integrity.GetAlgorithm(), partDevPath, blockDevice)
+	if err != nil {
+		logger.Log.Warnf("Failed to open integrity partition %v. Error: %v", partDevPath, stderr)
+		return
+	}
+	mappedPath = filepath.Join(mappingFilePath, blockDevice)
+
+	_, err = FormatSinglePartition(mappedPath, partition, limits)
+	if err != nil {
+		logger.Log.Warnf("Failed to format integrity partition %v", partition.ID)
+	}
+
+	return
+}
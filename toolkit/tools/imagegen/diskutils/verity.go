@@ -38,6 +38,8 @@ var (
 // - FecRoots is the number of error correcting roots, 0 to omit error correction
 // - ValidateOnBoot will cause a full, user-mode analysis of the verity disk during boot (good for debugging)
 // - UseRootHashSignature indicates a signature file has been included with the verity disk and should be checked
+// - HashAlgorithm, DataBlockSize and HashBlockSize are passed to veritysetup when set
+// - RootHashSigningKey and RootHashSigningCert sign the root hash when generating the verity disk, if set
 // - ErrorBehavior is what dm-verity should do in the event of corruption (ignore, panic, restart)
 // - TmpfsOverlays is a list of tmpfs overlays which will be created after the verity partition is mounted
 // - TmpfsOverlaySize is the size argument to pass to the tmpfs mount command (1234, 1234<k,m,g>, 20%)
@@ -49,16 +51,21 @@ type VerityDevice struct {
 	FecRoots                int
 	ValidateOnBoot          bool
 	UseRootHashSignature    bool
+	RootHashSigningKey      string
+	RootHashSigningCert     string
+	HashAlgorithm           string
+	DataBlockSize           uint32
+	HashBlockSize           uint32
 	ErrorBehavior           string
 	TmpfsOverlays           []string
 	TmpfsOverlaySize        string
 	TmpfsOverlaysDebugMount string
 }
 
-// AddRootVerityFilesToInitramfs adds files needed for a verity root to the initramfs
+// AddRootVerityFilesToInitramfs adds files needed for a verity root to each initramfs
 // - workingFolder is a temporary folder to extract the initramfs to
-// - initramfsPath is the path to the initramfs
-func (v *VerityDevice) AddRootVerityFilesToInitramfs(workingFolder, initramfsPath string) (err error) {
+// - initramfsPaths are the paths to the initramfs of every installed kernel
+func (v *VerityDevice) AddRootVerityFilesToInitramfs(workingFolder string, initramfsPaths []string) (err error) {
 	verityWorkingDirectory := filepath.Join(workingFolder, v.MappedName)
 
 	// Measure the disk and generate the hash and fec files
@@ -67,17 +74,28 @@ func (v *VerityDevice) AddRootVerityFilesToInitramfs(workingFolder, initramfsPat
 		return fmt.Errorf("failed while generating a verity disk: %w", err)
 	}
 
-	// Now place them in the initramfs
-	logger.Log.Info("Adding dm-verity read-only root files into initramfs")
-	initramfs, err := OpenInitramfs(initramfsPath)
-	defer initramfs.Close()
+	verityFiles, err := ioutil.ReadDir(verityWorkingDirectory)
 	if err != nil {
-		return fmt.Errorf("failed to open the initramfs: %w", err)
+		return
 	}
 
-	verityFiles, err := ioutil.ReadDir(verityWorkingDirectory)
+	// Now place them in each initramfs, the hash is only generated once so every kernel boots the same root
+	for _, initramfsPath := range initramfsPaths {
+		err = addVerityFilesToInitramfs(verityWorkingDirectory, verityFiles, initramfsPath)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func addVerityFilesToInitramfs(verityWorkingDirectory string, verityFiles []os.FileInfo, initramfsPath string) (err error) {
+	logger.Log.Infof("Adding dm-verity read-only root files into initramfs (%s)", initramfsPath)
+	initramfs, err := OpenInitramfs(initramfsPath)
+	defer initramfs.Close()
 	if err != nil {
-		return
+		return fmt.Errorf("failed to open the initramfs: %w", err)
 	}
 
 	for _, file := range verityFiles {
@@ -132,17 +150,31 @@ func (v *VerityDevice) createVerityDisk(verityDirectory string) (err error) {
 		}
 	}
 
+	selectedHashAlg := hashAlg
+	if v.HashAlgorithm != "" {
+		selectedHashAlg = v.HashAlgorithm
+	}
+
 	verityArgs = []string{
 		"--salt",
 		salt,
 		"--hash",
-		hashAlg,
+		selectedHashAlg,
+	}
+	// The block sizes are stored in the hash tree superblock, so opening the device at boot does not need them
+	if v.DataBlockSize != 0 {
+		verityArgs = append(verityArgs, fmt.Sprintf("--data-block-size=%d", v.DataBlockSize))
+	}
+	if v.HashBlockSize != 0 {
+		verityArgs = append(verityArgs, fmt.Sprintf("--hash-block-size=%d", v.HashBlockSize))
+	}
+	verityArgs = append(verityArgs,
 		"--verbose",
 		"--debug",
 		"format",
 		v.MappedDevice,
 		hashtreePath,
-	}
+	)
 
 	logger.Log.Info("Generating a dm-verity read-only partition")
 	verityOutput, stderr, err := shell.Execute("veritysetup", append(verityFecArgs, verityArgs...)...)
@@ -166,6 +198,13 @@ func (v *VerityDevice) createVerityDisk(verityDirectory string) (err error) {
 		return
 	}
 
+	if v.RootHashSigningKey != "" {
+		err = v.signRootHash(rootHashPath, fmt.Sprintf("%s.p7", fileBase))
+		if err != nil {
+			return
+		}
+	}
+
 	//Verify the disk was created correctly:
 	verityVerifyArgs = []string{
 		"--verbose",
@@ -186,6 +225,27 @@ func (v *VerityDevice) createVerityDisk(verityDirectory string) (err error) {
 	return
 }
 
+// signRootHash creates a detached DER encoded PKCS#7 signature over the root hash file, in the
+// format the kernel expects for dm-verity root hash signatures.
+func (v *VerityDevice) signRootHash(rootHashPath, signaturePath string) (err error) {
+	opensslArgs := []string{
+		"smime", "-sign",
+		"-nocerts", "-noattr", "-binary",
+		"-in", rootHashPath,
+		"-inkey", v.RootHashSigningKey,
+		"-signer", v.RootHashSigningCert,
+		"-outform", "der",
+		"-out", signaturePath,
+	}
+
+	logger.Log.Info("Signing the verity root hash")
+	_, stderr, err := shell.Execute("openssl", opensslArgs...)
+	if err != nil {
+		err = fmt.Errorf("unable to sign verity root hash '%s': %w", stderr, err)
+	}
+	return
+}
+
 // PrepReadOnlyDevice sets up a device mapper linear map.
 // This map will have the correct name of the final verity disk, and can be
 // switched to read-only when the final image is ready for measurement.
@@ -222,6 +282,11 @@ func PrepReadOnlyDevice(partDevPath string, partition configuration.Partition, r
 		readOnlyDevice.TmpfsOverlaysDebugMount = debugMountPoint
 	}
 	readOnlyDevice.UseRootHashSignature = readOnlyConfig.RootHashSignatureEnable
+	readOnlyDevice.RootHashSigningKey = readOnlyConfig.RootHashSigningKey
+	readOnlyDevice.RootHashSigningCert = readOnlyConfig.RootHashSigningCert
+	readOnlyDevice.HashAlgorithm = readOnlyConfig.HashAlgorithm
+	readOnlyDevice.DataBlockSize = readOnlyConfig.DataBlockSize
+	readOnlyDevice.HashBlockSize = readOnlyConfig.HashBlockSize
 
 	// linear mappings need to know the size of the disk in blocks ahead of time
 	deviceSizeStr, stderr, err := shell.Execute("blockdev", "--getsz", readOnlyDevice.BackingDevice)
@@ -0,0 +1,77 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package installutils
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"microsoft.com/pkggen/imagegen/configuration"
+	"microsoft.com/pkggen/internal/logger"
+	"microsoft.com/pkggen/internal/shell"
+)
+
+// checkPackageOwnedAdditionalFiles warns about, or fails on, additional files which replace a file of an
+// installed package, as set by the AdditionalFilesCheck. Such files are overwritten when the package is updated.
+// Files marked with ReplacesPackageFile are skipped.
+func checkPackageOwnedAdditionalFiles(installRoot string, config configuration.SystemConfig) (err error) {
+	if config.AdditionalFilesCheck == "" {
+		return
+	}
+
+	var destinations []string
+	for _, additionalFile := range config.AdditionalFiles {
+		if !additionalFile.ReplacesPackageFile {
+			destinations = append(destinations, additionalFile.Path)
+		}
+	}
+	sort.Strings(destinations)
+
+	var packageOwned []string
+	for _, destination := range destinations {
+		stdout, stderr, queryErr := shell.Execute("rpm", "--root", installRoot, "-qf", "--queryformat", "%{NAME}\n", destination)
+		owners, parseErr := parseRpmFileOwners(stdout, stderr, queryErr)
+		if parseErr != nil {
+			return fmt.Errorf("failed to query the owner of additional file (%s): %w", destination, parseErr)
+		}
+		if len(owners) == 0 {
+			continue
+		}
+
+		owned := fmt.Sprintf("%s (%s)", destination, strings.Join(owners, ", "))
+		logger.Log.Warnf("Additional file replaces a file of an installed package, it will be overwritten when the package is updated: %s", owned)
+		packageOwned = append(packageOwned, owned)
+	}
+
+	if len(packageOwned) != 0 && config.AdditionalFilesCheck == configuration.AdditionalFilesCheckError {
+		return fmt.Errorf("%d additional file(s) replace files of installed packages, set [ReplacesPackageFile] if this is intended: %s", len(packageOwned), strings.Join(packageOwned, ", "))
+	}
+	return
+}
+
+// parseRpmFileOwners returns the packages owning a file from the result of "rpm -qf --queryformat '%{NAME}\n'".
+// Files which don't exist or belong to no package have no owners.
+func parseRpmFileOwners(stdout, stderr string, queryErr error) (owners []string, err error) {
+	const (
+		notOwnedMessage = "is not owned by any package"
+		missingMessage  = "No such file or directory"
+	)
+
+	if queryErr != nil {
+		output := stdout + stderr
+		if strings.Contains(output, notOwnedMessage) || strings.Contains(output, missingMessage) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%v: %w", strings.TrimSpace(stderr), queryErr)
+	}
+
+	for _, line := range strings.Split(stdout, "\n") {
+		owner := strings.TrimSpace(line)
+		if owner != "" {
+			owners = append(owners, owner)
+		}
+	}
+	return
+}
@@ -0,0 +1,114 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package installutils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"microsoft.com/pkggen/imagegen/configuration"
+	"microsoft.com/pkggen/internal/file"
+	"microsoft.com/pkggen/internal/logger"
+)
+
+// installAuthorizedKeys adds the configured SSH public keys to each user's ~/.ssh/authorized_keys,
+// keeping the keys already there. The users may come from the base image or from [Users].
+func installAuthorizedKeys(installRoot string, authorizedKeys configuration.AuthorizedKeys) (err error) {
+	const (
+		sshDirMode            = 0700
+		authorizedKeysMode    = 0600
+		sshDirName            = ".ssh"
+		authorizedKeysName    = "authorized_keys"
+		authorizedKeysHeading = "# Added from the image configuration's AuthorizedKeys"
+	)
+
+	if len(authorizedKeys) == 0 {
+		return
+	}
+
+	ReportAction("Installing SSH authorized keys")
+
+	passwd, err := readAccountFile(installRoot, passwdFile)
+	if err != nil {
+		return
+	}
+
+	for _, user := range authorizedKeys.Users() {
+		entry, found := findAccount(passwd, user)
+		if !found {
+			return fmt.Errorf("cannot install [AuthorizedKeys] for user (%s): it does not exist in the image", user)
+		}
+		if entry.home == "" || !filepath.IsAbs(entry.home) {
+			return fmt.Errorf("cannot install [AuthorizedKeys] for user (%s): it has no home directory", user)
+		}
+
+		sshDir := filepath.Join(installRoot, entry.home, sshDirName)
+		err = os.MkdirAll(sshDir, sshDirMode)
+		if err != nil {
+			return
+		}
+
+		authorizedKeysPath := filepath.Join(sshDir, authorizedKeysName)
+		existingKeys := ""
+		if exists, _ := file.PathExists(authorizedKeysPath); exists {
+			var content []byte
+			content, err = os.ReadFile(authorizedKeysPath)
+			if err != nil {
+				return
+			}
+			existingKeys = string(content)
+		}
+
+		logger.Log.Infof("Adding %d SSH authorized key(s) for user (%s)", len(authorizedKeys[user]), user)
+		err = file.Write(renderAuthorizedKeys(existingKeys, authorizedKeysHeading, authorizedKeys[user]), authorizedKeysPath)
+		if err != nil {
+			return
+		}
+
+		// sshd ignores the keys unless the user owns them and nobody else can write to them
+		for path, mode := range map[string]os.FileMode{sshDir: sshDirMode, authorizedKeysPath: authorizedKeysMode} {
+			err = os.Chmod(path, mode)
+			if err != nil {
+				return
+			}
+			err = os.Lchown(path, entry.id, entry.gid)
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	return
+}
+
+// renderAuthorizedKeys appends the keys missing from an existing authorized_keys file
+func renderAuthorizedKeys(existing, heading string, keys []string) string {
+	present := make(map[string]bool)
+	for _, line := range strings.Split(existing, "\n") {
+		present[strings.TrimSpace(line)] = true
+	}
+
+	var builder strings.Builder
+	builder.WriteString(existing)
+	if existing != "" && !strings.HasSuffix(existing, "\n") {
+		builder.WriteString("\n")
+	}
+
+	headingWritten := false
+	for _, key := range keys {
+		key = strings.TrimSpace(key)
+		if present[key] {
+			continue
+		}
+		if !headingWritten {
+			builder.WriteString(heading + "\n")
+			headingWritten = true
+		}
+		builder.WriteString(key + "\n")
+		present[key] = true
+	}
+	return builder.String()
+}
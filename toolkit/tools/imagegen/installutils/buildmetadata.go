@@ -0,0 +1,108 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package installutils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"microsoft.com/pkggen/imagegen/configuration"
+	"microsoft.com/pkggen/internal/exe"
+	"microsoft.com/pkggen/internal/logger"
+)
+
+// BuildMetadataPath is where the build metadata is written inside the image
+const BuildMetadataPath = "/etc/imager-build.json"
+
+// buildMetadata is the JSON document tying an image back to the build which produced it
+type buildMetadata struct {
+	ConfigName  string `json:"ConfigName"`
+	ConfigHash  string `json:"ConfigHash"`
+	ToolVersion string `json:"ToolVersion"`
+	BuildTime   string `json:"BuildTime"`
+}
+
+// ConfigFilesHash returns "sha256:" followed by the SHA-256 of the raw bytes of the config files, in order.
+// For a single file, it is the digest sha256sum prints.
+func ConfigFilesHash(configFiles []string) (configHash string, err error) {
+	hash := sha256.New()
+	for _, configFile := range configFiles {
+		err = hashFile(hash, configFile)
+		if err != nil {
+			return
+		}
+	}
+
+	configHash = "sha256:" + hex.EncodeToString(hash.Sum(nil))
+	return
+}
+
+// hashFile writes the contents of a file to hash
+func hashFile(hash io.Writer, path string) (err error) {
+	fileToHash, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to hash config file (%s):\n%w", path, err)
+	}
+	defer fileToHash.Close()
+
+	_, err = io.Copy(hash, fileToHash)
+	return
+}
+
+// writeBuildMetadata records the system config's name, the hash of the config files, the toolkit version and
+// the build time in the install root. The build time comes from SOURCE_DATE_EPOCH when it is set, and a
+// reproducible build without SOURCE_DATE_EPOCH uses its fixed timestamp of 0.
+func writeBuildMetadata(installRoot string, config configuration.SystemConfig, configHash string) (err error) {
+	if !config.BuildMetadata {
+		return
+	}
+
+	ReportAction("Writing build metadata")
+
+	buildTime := time.Now().UTC()
+	if _, isSet := os.LookupEnv(sourceDateEpochEnv); isSet || config.Reproducible {
+		var epoch int64
+		epoch, err = SourceDateEpoch()
+		if err != nil {
+			return
+		}
+		buildTime = time.Unix(epoch, 0).UTC()
+	}
+
+	metadata, err := renderBuildMetadata(config.Name, configHash, exe.ToolkitVersion, buildTime)
+	if err != nil {
+		return
+	}
+
+	metadataPath := filepath.Join(installRoot, BuildMetadataPath)
+	err = os.MkdirAll(filepath.Dir(metadataPath), os.ModePerm)
+	if err != nil {
+		return
+	}
+
+	logger.Log.Debugf("Writing build metadata to (%s)", metadataPath)
+	return os.WriteFile(metadataPath, metadata, 0644)
+}
+
+// renderBuildMetadata returns the build metadata document
+func renderBuildMetadata(configName, configHash, toolVersion string, buildTime time.Time) (metadata []byte, err error) {
+	metadata, err = json.MarshalIndent(buildMetadata{
+		ConfigName:  configName,
+		ConfigHash:  configHash,
+		ToolVersion: toolVersion,
+		BuildTime:   buildTime.Format(time.RFC3339),
+	}, "", "  ")
+	if err != nil {
+		return
+	}
+
+	metadata = append(metadata, '\n')
+	return
+}
@@ -0,0 +1,275 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package installutils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"microsoft.com/pkggen/imagegen/configuration"
+	"microsoft.com/pkggen/internal/logger"
+	"microsoft.com/pkggen/internal/shell"
+)
+
+const (
+	// ChangeReportTempPath is where the change report is written while building an image,
+	// the imager moves it next to the output image once the build is complete
+	ChangeReportTempPath = "/tmp/changereport/changes.json"
+
+	changeReportRpmQueryFS = "\t"
+)
+
+// changeSnapshot records the state of an install root, so two snapshots can be compared
+type changeSnapshot struct {
+	// packages maps each installed package's name.arch to its version-release
+	packages map[string]string
+	// files maps each path, relative to the install root, to a description of its type, mode and contents
+	files map[string]string
+}
+
+// changeReport is the JSON document describing the difference between two snapshots
+type changeReport struct {
+	Packages packageChanges `json:"Packages"`
+	Files    *fileChanges   `json:"Files,omitempty"`
+}
+
+type packageChanges struct {
+	Added   []string        `json:"Added"`
+	Removed []string        `json:"Removed"`
+	Changed []packageChange `json:"Changed"`
+}
+
+type packageChange struct {
+	Name   string `json:"Name"`
+	Before string `json:"Before"`
+	After  string `json:"After"`
+}
+
+type fileChanges struct {
+	Added   []string `json:"Added"`
+	Removed []string `json:"Removed"`
+	Changed []string `json:"Changed"`
+}
+
+// takeChangeSnapshot records the installed packages and, if includeFiles is set, every file under installRoot.
+func takeChangeSnapshot(installRoot string, includeFiles bool) (snapshot changeSnapshot, err error) {
+	snapshot.packages, err = snapshotPackages(installRoot)
+	if err != nil {
+		return
+	}
+
+	if includeFiles {
+		snapshot.files, err = snapshotFiles(installRoot)
+	}
+	return
+}
+
+func snapshotPackages(installRoot string) (packages map[string]string, err error) {
+	queryFormat := strings.Join([]string{"%{NAME}.%{ARCH}", "%{VERSION}-%{RELEASE}"}, changeReportRpmQueryFS) + "\n"
+	stdout, stderr, err := shell.Execute("rpm", "--root", installRoot, "-qa", "--qf", queryFormat)
+	if err != nil {
+		logger.Log.Warn(stderr)
+		err = fmt.Errorf("failed to query installed packages: %w", err)
+		return
+	}
+
+	packages = make(map[string]string)
+	for _, line := range strings.Split(stdout, "\n") {
+		fields := strings.Split(line, changeReportRpmQueryFS)
+		if len(fields) == 2 {
+			packages[fields[0]] = fields[1]
+		}
+	}
+	return
+}
+
+func snapshotFiles(installRoot string) (files map[string]string, err error) {
+	skippedDirs := pseudoFilesystemDirs(installRoot)
+	files = make(map[string]string)
+
+	err = filepath.WalkDir(installRoot, func(path string, entry fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+
+		if entry.IsDir() && skippedDirs[path] {
+			return filepath.SkipDir
+		}
+
+		relativePath, err := filepath.Rel(installRoot, path)
+		if err != nil {
+			return err
+		}
+
+		files["/"+relativePath], err = describeFile(path, entry)
+		return err
+	})
+	if err != nil {
+		err = fmt.Errorf("failed to record the files under (%s): %w", installRoot, err)
+	}
+	return
+}
+
+// describeFile returns a string which changes whenever the file's type, mode, owner or contents change.
+func describeFile(path string, entry fs.DirEntry) (description string, err error) {
+	info, err := entry.Info()
+	if err != nil {
+		return
+	}
+
+	description = info.Mode().String()
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		description = fmt.Sprintf("%s %d:%d", description, stat.Uid, stat.Gid)
+	}
+	switch {
+	case info.Mode()&fs.ModeSymlink != 0:
+		var target string
+		target, err = os.Readlink(path)
+		description = fmt.Sprintf("%s -> %s", description, target)
+	case info.Mode().IsRegular():
+		var digest string
+		digest, err = fileSha256(path)
+		description = fmt.Sprintf("%s %s", description, digest)
+	}
+	return
+}
+
+func fileSha256(path string) (digest string, err error) {
+	contents, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer contents.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, contents)
+	if err != nil {
+		return
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// diffChangeSnapshots compares two snapshots. The file changes are only reported if both snapshots recorded files.
+func diffChangeSnapshots(before, after changeSnapshot) (report changeReport) {
+	report.Packages.Added, report.Packages.Removed = diffKeys(before.packages, after.packages)
+	report.Packages.Changed = []packageChange{}
+	for _, name := range changedKeys(before.packages, after.packages) {
+		report.Packages.Changed = append(report.Packages.Changed, packageChange{
+			Name:   name,
+			Before: before.packages[name],
+			After:  after.packages[name],
+		})
+	}
+
+	if before.files != nil && after.files != nil {
+		report.Files = &fileChanges{}
+		report.Files.Added, report.Files.Removed = diffKeys(before.files, after.files)
+		report.Files.Changed = changedKeys(before.files, after.files)
+	}
+	return
+}
+
+// diffKeys returns the sorted keys only found in after, and those only found in before.
+func diffKeys(before, after map[string]string) (added, removed []string) {
+	added, removed = []string{}, []string{}
+	for key := range after {
+		if _, found := before[key]; !found {
+			added = append(added, key)
+		}
+	}
+	for key := range before {
+		if _, found := after[key]; !found {
+			removed = append(removed, key)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return
+}
+
+// changedKeys returns the sorted keys found in both maps with different values.
+func changedKeys(before, after map[string]string) (changed []string) {
+	changed = []string{}
+	for key, value := range after {
+		if beforeValue, found := before[key]; found && beforeValue != value {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	return
+}
+
+// ChangeRecorder snapshots the install root when it is created, and writes the change report once the build
+// is done changing the install root.
+type ChangeRecorder struct {
+	installRoot string
+	settings    configuration.ChangeReport
+	before      changeSnapshot
+	after       changeSnapshot
+}
+
+// newChangeRecorder takes the initial snapshot of installRoot, if a change report is enabled.
+func newChangeRecorder(installRoot string, settings configuration.ChangeReport) (recorder *ChangeRecorder, err error) {
+	recorder = &ChangeRecorder{
+		installRoot: installRoot,
+		settings:    settings,
+	}
+	if !settings.Enable {
+		return
+	}
+
+	ReportAction("Recording the base image for the change report")
+	recorder.before, err = takeChangeSnapshot(installRoot, settings.IncludeFiles)
+	return
+}
+
+// recordPackages records the final set of packages, it must run while the RPM database is still in the image.
+func (c *ChangeRecorder) recordPackages() (err error) {
+	if !c.settings.Enable {
+		return
+	}
+
+	c.after.packages, err = snapshotPackages(c.installRoot)
+	return
+}
+
+// WriteReport compares the install root against the initial snapshot and writes the report to outputPath.
+// It runs once nothing else changes the files of the install root, the packages are the ones recordPackages found.
+func (c *ChangeRecorder) WriteReport(outputPath string) (err error) {
+	if !c.settings.Enable {
+		return
+	}
+
+	ReportAction("Generating change report")
+	if c.settings.IncludeFiles {
+		c.after.files, err = snapshotFiles(c.installRoot)
+		if err != nil {
+			return
+		}
+	}
+
+	report, err := json.MarshalIndent(diffChangeSnapshots(c.before, c.after), "", "  ")
+	if err != nil {
+		return
+	}
+
+	err = os.MkdirAll(filepath.Dir(outputPath), os.ModePerm)
+	if err != nil {
+		return
+	}
+
+	logger.Log.Infof("Writing change report to (%s)", outputPath)
+	err = os.WriteFile(outputPath, report, 0644)
+	return
+}
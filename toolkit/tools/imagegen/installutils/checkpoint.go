@@ -0,0 +1,194 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package installutils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"microsoft.com/pkggen/imagegen/configuration"
+	"microsoft.com/pkggen/internal/file"
+	"microsoft.com/pkggen/internal/logger"
+	"microsoft.com/pkggen/internal/shell"
+)
+
+// checkpointTarArgs preserve ownership, permissions, ACLs and extended attributes, including SELinux labels
+var checkpointTarArgs = []string{"--xattrs", "--xattrs-include=*", "--acls", "--selinux", "--numeric-owner"}
+
+// PackageCheckpoint saves the install root once its packages are installed, and restores it instead of installing
+// them again on a later build with the same package inputs. It is meant to iterate quickly on the steps which
+// follow, such as the additional files and the post install scripts. A nil PackageCheckpoint does nothing.
+//   - Dir is the directory holding the checkpoints
+//   - Key identifies the package inputs, see PackageCheckpointKey
+type PackageCheckpoint struct {
+	Dir string
+	Key string
+}
+
+// checkpointInputs are the inputs of the package installation a checkpoint is keyed on
+type checkpointInputs struct {
+	Packages               []string
+	PackageInstallGroups   [][]string
+	LocalPackages          []string
+	UpdateExistingPackages bool
+	UpdateRepo             string
+	TdnfOptions            map[string]string
+	BaseRootfs             string
+	RepoMetadata           []string
+}
+
+// PackageCheckpointKey returns the hash of the inputs of the package installation, so a checkpoint is only
+// restored for the same packages from the same repositories.
+// - packagesToInstall are the packages installed into the image, including the kernel
+// - config is the SystemConfig from a config file
+// - repoMetadataFiles are the repomd.xml files of the repositories the packages are installed from, missing files are skipped
+func PackageCheckpointKey(packagesToInstall []string, config configuration.SystemConfig, repoMetadataFiles []string) (key string, err error) {
+	inputs := checkpointInputs{
+		Packages:               packagesToInstall,
+		PackageInstallGroups:   config.PackageInstallGroups,
+		UpdateExistingPackages: config.UpdateExistingPackages,
+		UpdateRepo:             config.UpdateRepo,
+		TdnfOptions:            config.GetTdnfOptions(),
+	}
+
+	// Local packages are hashed since they are usually rebuilt in place
+	for _, localPackage := range config.LocalPackages {
+		var hash string
+		hash, err = file.GenerateSHA256(localPackage)
+		if err != nil {
+			return
+		}
+		inputs.LocalPackages = append(inputs.LocalPackages, fmt.Sprintf("%s:%s", filepath.Base(localPackage), hash))
+	}
+
+	// A base rootfs can be large, so it is identified by its size and modification time rather than hashed
+	if config.BaseRootfsTarball != "" {
+		var info os.FileInfo
+		info, err = os.Stat(config.BaseRootfsTarball)
+		if err != nil {
+			return
+		}
+		inputs.BaseRootfs = fmt.Sprintf("%s:%d:%d", config.BaseRootfsTarball, info.Size(), info.ModTime().UnixNano())
+	} else if config.BaseRootfsDir != "" {
+		inputs.BaseRootfs, err = baseRootfsDirKey(config.BaseRootfsDir)
+		if err != nil {
+			return
+		}
+	}
+
+	for _, repoMetadataFile := range repoMetadataFiles {
+		var exists bool
+		exists, err = file.PathExists(repoMetadataFile)
+		if err != nil {
+			return
+		}
+		if !exists {
+			continue
+		}
+
+		var hash string
+		hash, err = file.GenerateSHA256(repoMetadataFile)
+		if err != nil {
+			return
+		}
+		inputs.RepoMetadata = append(inputs.RepoMetadata, hash)
+	}
+
+	// encoding/json sorts map keys, so the same inputs always hash the same
+	encodedInputs, err := json.Marshal(inputs)
+	if err != nil {
+		return
+	}
+	hash := sha256.Sum256(encodedInputs)
+	key = hex.EncodeToString(hash[:])
+	return
+}
+
+// baseRootfsDirKey identifies a base rootfs directory by the path, mode, size and modification time of every
+// entry under it, as a change deep in the tree doesn't update the modification time of the directory itself.
+func baseRootfsDirKey(baseRootfsDir string) (key string, err error) {
+	hash := sha256.New()
+	err = filepath.Walk(baseRootfsDir, func(path string, info os.FileInfo, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+
+		relPath, err := filepath.Rel(baseRootfsDir, path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(hash, "%s:%o:%d:%d\n", relPath, info.Mode(), info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	if err != nil {
+		err = fmt.Errorf("failed to walk the base rootfs directory (%s):\n%w", baseRootfsDir, err)
+		return
+	}
+
+	key = fmt.Sprintf("%s:%s", baseRootfsDir, hex.EncodeToString(hash.Sum(nil)))
+	return
+}
+
+// path returns the path of the checkpoint's archive
+func (c *PackageCheckpoint) path() string {
+	return filepath.Join(c.Dir, fmt.Sprintf("packages-%s.tar", c.Key))
+}
+
+// Restore extracts the checkpoint into the install root, if it exists.
+func (c *PackageCheckpoint) Restore(installRoot string) (restored bool, err error) {
+	const squashErrors = false
+
+	if c == nil {
+		return
+	}
+
+	checkpointPath := c.path()
+	exists, err := file.PathExists(checkpointPath)
+	if err != nil || !exists {
+		logger.Log.Infof("No package checkpoint (%s) to restore, installing the packages", checkpointPath)
+		return
+	}
+
+	ReportAction("Restoring the package checkpoint")
+	logger.Log.Infof("Restoring the package checkpoint (%s) into (%s)", checkpointPath, installRoot)
+
+	tarArgs := append(append([]string{}, checkpointTarArgs...), "-xpf", checkpointPath, "-C", installRoot)
+	err = shell.ExecuteLive(squashErrors, "tar", tarArgs...)
+	if err != nil {
+		return false, fmt.Errorf("failed to restore the package checkpoint (%s): %w", checkpointPath, err)
+	}
+
+	return true, nil
+}
+
+// Save archives the install root as the checkpoint. The chroot's virtual file systems are left out.
+func (c *PackageCheckpoint) Save(installRoot string) (err error) {
+	const squashErrors = false
+
+	if c == nil {
+		return
+	}
+
+	ReportAction("Saving the package checkpoint")
+
+	checkpointPath := c.path()
+	logger.Log.Infof("Saving the package checkpoint of (%s) to (%s)", installRoot, checkpointPath)
+
+	// Write to a temporary file first, an interrupted build must not leave a partial checkpoint behind
+	partialPath := checkpointPath + ".partial"
+	tarArgs := append(append([]string{}, checkpointTarArgs...),
+		"--exclude=./dev/*", "--exclude=./proc/*", "--exclude=./sys/*", "--exclude=./run/*",
+		"-cpf", partialPath, "-C", installRoot, ".")
+	err = shell.ExecuteLive(squashErrors, "tar", tarArgs...)
+	if err != nil {
+		os.Remove(partialPath)
+		return fmt.Errorf("failed to save the package checkpoint (%s): %w", checkpointPath, err)
+	}
+
+	return os.Rename(partialPath, checkpointPath)
+}
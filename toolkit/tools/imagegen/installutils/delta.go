@@ -0,0 +1,116 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package installutils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"microsoft.com/pkggen/internal/jsonutils"
+	"microsoft.com/pkggen/internal/logger"
+	"microsoft.com/pkggen/internal/shell"
+)
+
+const (
+	// DeltaMetadataExtension is appended to the name of a zstd patch artifact for its metadata file
+	DeltaMetadataExtension = ".json"
+
+	// zstdPatchWindowLog is the zstd window the patch is created with, the largest zstd supports. The patch
+	// can only reference the first 2^31 bytes (2GiB) of the base image, and applying it needs the same --long value.
+	zstdPatchWindowLog = 31
+	// maxZstdPatchBaseSize is the size of the largest base image a zstd patch can reference in full
+	maxZstdPatchBaseSize = 1 << zstdPatchWindowLog
+)
+
+// DeltaMetadata describes a zstd patch artifact, so an updater can check it applies the patch
+// to the right base and got the expected result.
+type DeltaMetadata struct {
+	Algorithm  string `json:"Algorithm"`
+	WindowLog  int    `json:"WindowLog"`
+	FromSha256 string `json:"FromSha256"`
+	FromSize   int64  `json:"FromSize"`
+	ToSha256   string `json:"ToSha256"`
+	ToSize     int64  `json:"ToSize"`
+}
+
+// createZstdPatchArtifact writes a zstd patch turning deltaBaseImage into the partition at devPath,
+// along with its metadata.
+func createZstdPatchArtifact(workDirPath, devPath, deltaBaseImage, name string) (err error) {
+	const squashErrors = true
+
+	fullPath := filepath.Join(workDirPath, name)
+
+	baseInfo, err := os.Stat(deltaBaseImage)
+	if err != nil {
+		return fmt.Errorf("failed to read the base of the zstd patch (%s): %w", deltaBaseImage, err)
+	}
+	err = checkZstdPatchBaseSize(deltaBaseImage, baseInfo.Size())
+	if err != nil {
+		return
+	}
+
+	zstdArgs := []string{
+		"-f",
+		"-19",
+		fmt.Sprintf("--long=%d", zstdPatchWindowLog),
+		fmt.Sprintf("--patch-from=%s", deltaBaseImage),
+		devPath,
+		"-o", fullPath,
+	}
+	err = shell.ExecuteLive(squashErrors, "zstd", zstdArgs...)
+	if err != nil {
+		return fmt.Errorf("failed to create a zstd patch from (%s): %w", deltaBaseImage, err)
+	}
+
+	metadata, err := newDeltaMetadata(deltaBaseImage, devPath)
+	if err != nil {
+		return
+	}
+	logger.Log.Infof("Created zstd patch (%s) from (%s) sha256 %s to sha256 %s", fullPath, deltaBaseImage, metadata.FromSha256, metadata.ToSha256)
+
+	return jsonutils.WriteJSONFile(fullPath+DeltaMetadataExtension, metadata)
+}
+
+// checkZstdPatchBaseSize returns an error if a zstd patch can't reference all of a base image of baseSize bytes
+func checkZstdPatchBaseSize(deltaBaseImage string, baseSize int64) (err error) {
+	if baseSize > maxZstdPatchBaseSize {
+		return fmt.Errorf("[DeltaBaseImage] (%s) is %d bytes, a zstd patch can only reference the first %d bytes of its base", deltaBaseImage, baseSize, int64(maxZstdPatchBaseSize))
+	}
+	return
+}
+
+// newDeltaMetadata hashes the base and target of a zstd patch
+func newDeltaMetadata(fromPath, toPath string) (metadata DeltaMetadata, err error) {
+	metadata = DeltaMetadata{
+		Algorithm: "zstd",
+		WindowLog: zstdPatchWindowLog,
+	}
+
+	metadata.FromSha256, metadata.FromSize, err = sha256File(fromPath)
+	if err != nil {
+		return
+	}
+	metadata.ToSha256, metadata.ToSize, err = sha256File(toPath)
+	return
+}
+
+// sha256File returns the sha256 and size of a file or block device
+func sha256File(path string) (digest string, size int64, err error) {
+	source, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer source.Close()
+
+	hasher := sha256.New()
+	size, err = io.Copy(hasher, source)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to hash (%s): %w", path, err)
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), size, nil
+}
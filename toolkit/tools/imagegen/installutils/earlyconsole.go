@@ -0,0 +1,87 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package installutils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"microsoft.com/pkggen/imagegen/configuration"
+	"microsoft.com/pkggen/internal/file"
+	"microsoft.com/pkggen/internal/safechroot"
+)
+
+const (
+	keymapsDir                 = "usr/lib/kbd/keymaps"
+	consoleFontsDir            = "usr/lib/kbd/consolefonts"
+	vconsoleConfFile           = "etc/vconsole.conf"
+	earlyConsoleDracutConfFile = "etc/dracut.conf.d/90-imager-vconsole.conf"
+)
+
+var (
+	keymapSuffixes      = []string{".map", ".map.gz"}
+	consoleFontSuffixes = []string{".psf", ".psf.gz", ".psfu", ".psfu.gz", ".fnt", ".fnt.gz"}
+)
+
+// earlyConsoleDracutConf adds dracut's i18n module to the initramfs. With i18n_install_all="no" it only copies
+// /etc/vconsole.conf and the keymap and font it names, instead of every kbd file.
+const earlyConsoleDracutConf = `# Generated from the image configuration's EarlyConsole settings
+add_dracutmodules+=" i18n "
+i18n_install_all="no"
+`
+
+// configureEarlyConsole sets up the console keymap and font from the first initramfs stage: they are set in
+// /etc/vconsole.conf, included in the initramfs of every installed kernel and passed as rd.vconsole kernel
+// arguments. The kernel arguments are added to grub.cfg with the rest of the kernel command line.
+func configureEarlyConsole(installChroot *safechroot.Chroot, settings configuration.EarlyConsole) (err error) {
+	if settings.IsEmpty() {
+		return
+	}
+
+	ReportAction("Configuring the early boot console")
+
+	installRoot := installChroot.RootDir()
+	consoleSettings := map[string]string{}
+
+	if settings.Keymap != "" {
+		found, findErr := findKbdFile(filepath.Join(installRoot, keymapsDir), settings.Keymap, keymapSuffixes)
+		if findErr != nil {
+			return findErr
+		}
+		if !found {
+			return fmt.Errorf("cannot set early console keymap (%s): no such keymap under /%s, make sure the 'kbd' package is in the package lists", settings.Keymap, keymapsDir)
+		}
+		consoleSettings["KEYMAP"] = settings.Keymap
+	}
+
+	if settings.Font != "" {
+		found, findErr := findKbdFile(filepath.Join(installRoot, consoleFontsDir), settings.Font, consoleFontSuffixes)
+		if findErr != nil {
+			return findErr
+		}
+		if !found {
+			return fmt.Errorf("cannot set early console font (%s): no such font under /%s, make sure the 'kbd' package is in the package lists", settings.Font, consoleFontsDir)
+		}
+		consoleSettings["FONT"] = settings.Font
+	}
+
+	// vconsole.conf uses the same KEY="value" assignments as /etc/default/grub
+	vconsoleConfPath := filepath.Join(installRoot, vconsoleConfFile)
+	vconsoleConf, err := os.ReadFile(vconsoleConfPath)
+	if err != nil && !os.IsNotExist(err) {
+		return
+	}
+	err = file.Write(renderGrubDefaults(string(vconsoleConf), consoleSettings), vconsoleConfPath)
+	if err != nil {
+		return
+	}
+
+	dracutConfPath := filepath.Join(installRoot, earlyConsoleDracutConfFile)
+	err = os.MkdirAll(filepath.Dir(dracutConfPath), firmwareDirMode)
+	if err != nil {
+		return
+	}
+	return file.Write(earlyConsoleDracutConf, dracutConfPath)
+}
@@ -0,0 +1,118 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package installutils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+
+	"microsoft.com/pkggen/imagegen/configuration"
+	"microsoft.com/pkggen/internal/file"
+	"microsoft.com/pkggen/internal/logger"
+	"microsoft.com/pkggen/internal/shell"
+)
+
+const (
+	// espNearlyFullPercent is the ESP usage above which a warning is logged, leaving
+	// little room for bootloader and firmware updates
+	espNearlyFullPercent = 90
+)
+
+// CustomizeEsp merges files into the EFI system partition mounted at /boot/efi and sets its FAT label.
+// Files already installed in the ESP, ie by the bootloader packages, are never overwritten.
+func CustomizeEsp(installRoot, espDevice string, esp configuration.Esp) (err error) {
+	const espDir = "boot/efi"
+
+	if esp.IsEmpty() {
+		return
+	}
+
+	if espDevice == "" {
+		return fmt.Errorf("no EFI system partition is mounted at /%s", espDir)
+	}
+
+	if len(esp.Files) != 0 {
+		ReportAction("Merging files into the ESP")
+
+		err = mergeEspFiles(filepath.Join(installRoot, espDir), esp.Files)
+		if err != nil {
+			return
+		}
+	}
+
+	if esp.Label != "" {
+		ReportActionf("Setting the ESP label to (%s)", esp.Label)
+
+		_, stderr, err := shell.Execute("fatlabel", espDevice, esp.Label)
+		if err != nil {
+			return fmt.Errorf("failed to set the label of (%s): %v: %w", espDevice, stderr, err)
+		}
+	}
+
+	return
+}
+
+// mergeEspFiles copies files into the ESP after checking none of them exists yet and that they fit
+// in its free space.
+func mergeEspFiles(espPath string, espFiles []configuration.EspFile) (err error) {
+	var stat unix.Statfs_t
+	err = unix.Statfs(espPath, &stat)
+	if err != nil {
+		return fmt.Errorf("failed to query the free space of the ESP (%s): %w", espPath, err)
+	}
+	blockSize := uint64(stat.Bsize)
+
+	var neededBlocks uint64
+	for _, espFile := range espFiles {
+		// The ESP is mounted as vfat, so this lookup is case insensitive like the FAT file system itself
+		exists, existsErr := file.PathExists(filepath.Join(espPath, espFile.Path))
+		if existsErr != nil {
+			return existsErr
+		}
+		if exists {
+			return fmt.Errorf("(%s) is already installed in the ESP, files are merged without overwriting", espFile.Path)
+		}
+
+		info, statErr := os.Stat(espFile.Source)
+		if statErr != nil {
+			return statErr
+		}
+		neededBlocks += (uint64(info.Size()) + blockSize - 1) / blockSize
+	}
+
+	nearlyFull, err := espSpaceIsValid(stat.Blocks, stat.Bavail, neededBlocks)
+	if err != nil {
+		return
+	}
+	if nearlyFull {
+		logger.Log.Warnf("The ESP will be more than %d%% full, leaving little room for bootloader and firmware updates", espNearlyFullPercent)
+	}
+
+	for _, espFile := range espFiles {
+		logger.Log.Infof("Merging (%s) into the ESP as (%s)", espFile.Source, espFile.Path)
+		err = file.CopyAndChangeMode(espFile.Source, filepath.Join(espPath, espFile.Path), bootDirectoryDirMode, bootDirectoryFileMode)
+		if err != nil {
+			return fmt.Errorf("failed to merge (%s) into the ESP: %w", espFile.Source, err)
+		}
+	}
+
+	return
+}
+
+// espSpaceIsValid returns an error if neededBlocks do not fit in the ESP's availableBlocks, and whether
+// the ESP usage goes above espNearlyFullPercent once they are written. Directory entries are not
+// accounted for.
+func espSpaceIsValid(totalBlocks, availableBlocks, neededBlocks uint64) (nearlyFull bool, err error) {
+	if neededBlocks > availableBlocks {
+		err = fmt.Errorf("the files merged into the ESP need %d blocks but only %d are free", neededBlocks, availableBlocks)
+		return
+	}
+
+	usedBlocks := totalBlocks - availableBlocks + neededBlocks
+	nearlyFull = usedBlocks*100 > totalBlocks*espNearlyFullPercent
+	return
+}
@@ -0,0 +1,124 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package installutils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"microsoft.com/pkggen/imagegen/configuration"
+	"microsoft.com/pkggen/internal/file"
+	"microsoft.com/pkggen/internal/logger"
+	"microsoft.com/pkggen/internal/safechroot"
+)
+
+const (
+	firmwareDir            = "lib/firmware"
+	firmwareDracutConfFile = "etc/dracut.conf.d/90-imager-firmware.conf"
+	firmwareDirMode        = 0755
+	firmwareFileMode       = 0644
+)
+
+// installFirmware copies firmware files into /lib/firmware and lists the firmware requested for the
+// initramfs in a dracut.conf.d file, so it is included whenever the initramfs is regenerated,
+// by regenerateInitramfs or on the booted system.
+func installFirmware(installChroot *safechroot.Chroot, firmware configuration.Firmware) (err error) {
+	if len(firmware.Files) == 0 && len(firmware.Initramfs) == 0 {
+		return
+	}
+
+	ReportAction("Installing firmware")
+
+	installRoot := installChroot.RootDir()
+	installFirmwareDir := filepath.Join(installRoot, firmwareDir)
+
+	var firmwarePaths []string
+	for _, firmwareFile := range firmware.Files {
+		logger.Log.Infof("Installing firmware (%s) as (%s)", firmwareFile.Source, firmwareFile.Path)
+		err = file.CopyAndChangeMode(firmwareFile.Source, filepath.Join(installFirmwareDir, firmwareFile.Path), firmwareDirMode, firmwareFileMode)
+		if err != nil {
+			return fmt.Errorf("failed to install firmware (%s): %w", firmwareFile.Source, err)
+		}
+		firmwarePaths = append(firmwarePaths, firmwareFile.Path)
+	}
+
+	for _, path := range firmware.Initramfs {
+		exists, existsErr := file.PathExists(filepath.Join(installFirmwareDir, path))
+		if existsErr != nil {
+			return existsErr
+		}
+		if !exists {
+			return fmt.Errorf("firmware (%s) requested for the initramfs is not installed in /%s", path, firmwareDir)
+		}
+		firmwarePaths = append(firmwarePaths, path)
+	}
+
+	warnIfFirmwareUnreferenced(installChroot, firmwarePaths)
+
+	if len(firmware.Initramfs) == 0 {
+		return
+	}
+
+	dracutConfPath := filepath.Join(installRoot, firmwareDracutConfFile)
+	err = os.MkdirAll(filepath.Dir(dracutConfPath), firmwareDirMode)
+	if err != nil {
+		return
+	}
+	return file.Write(renderFirmwareDracutConf(firmware.Initramfs), dracutConfPath)
+}
+
+// renderFirmwareDracutConf lists the firmware to include in the initramfs as dracut install_items
+func renderFirmwareDracutConf(firmwarePaths []string) string {
+	items := make([]string, 0, len(firmwarePaths))
+	for _, path := range firmwarePaths {
+		items = append(items, "/"+filepath.Join(firmwareDir, path))
+	}
+	return fmt.Sprintf("install_items+=\" %s \"\n", strings.Join(items, " "))
+}
+
+// warnIfFirmwareUnreferenced logs a warning for each firmware no kernel module of an installed kernel asks for,
+// including the modules built into the kernel. Module files are usually compressed, so they are queried with modinfo.
+func warnIfFirmwareUnreferenced(installChroot *safechroot.Chroot, firmwarePaths []string) {
+	modulesRoot := filepath.Join(installChroot.RootDir(), "lib/modules")
+	kernelDirs, err := os.ReadDir(modulesRoot)
+	if err != nil && !os.IsNotExist(err) {
+		logger.Log.Warnf("Unable to list the installed kernels under (%s): %v", modulesRoot, err)
+		return
+	}
+
+	var referenced []string
+	for _, kernelDir := range kernelDirs {
+		if !kernelDir.IsDir() {
+			continue
+		}
+
+		modulesDir := filepath.Join(modulesRoot, kernelDir.Name())
+		modulePaths := make(map[string]bool)
+		filepath.Walk(modulesDir, func(path string, info os.FileInfo, walkErr error) error {
+			if walkErr != nil || info.IsDir() || kernelModuleFileName(path) == "" {
+				return nil
+			}
+			relativePath, relErr := filepath.Rel(modulesDir, path)
+			if relErr == nil {
+				modulePaths[relativePath] = true
+			}
+			return nil
+		})
+
+		firmware, firmwareErr := kernelModuleFirmware(installChroot, modulesDir, modulePaths)
+		if firmwareErr != nil {
+			logger.Log.Warnf("Unable to check which firmware the kernel modules ask for: %v", firmwareErr)
+			return
+		}
+		referenced = append(referenced, firmware...)
+	}
+
+	for _, path := range firmwarePaths {
+		if !isReferencedFirmware(path, referenced) {
+			logger.Log.Warnf("Firmware (%s) is not referenced by any kernel module under (%s)", path, modulesRoot)
+		}
+	}
+}
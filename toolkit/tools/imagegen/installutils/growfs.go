@@ -0,0 +1,105 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package installutils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"microsoft.com/pkggen/internal/file"
+	"microsoft.com/pkggen/internal/safechroot"
+)
+
+const (
+	growFsMarkerFile  = "etc/imager/grow-filesystems"
+	growFsScriptFile  = "usr/lib/imager/grow-filesystems"
+	growFsService     = "imager-grow-filesystems.service"
+	growFsServiceFile = "usr/lib/systemd/system/" + growFsService
+	growFsDirMode     = 0755
+	growFsScriptMode  = 0755
+)
+
+// growFsScript resizes the file system mounted at each mount point listed in the marker to fill its partition,
+// then removes the marker so it only runs on first boot
+const growFsScript = `#!/bin/sh
+# Generated by the image builder from the [GrowFsOnBoot] partition settings
+set -e
+
+marker=/` + growFsMarkerFile + `
+while read -r mountpoint; do
+    [ -n "$mountpoint" ] || continue
+    device="$(findmnt -n -o SOURCE --target "$mountpoint")"
+    echo "Growing the file system of $mountpoint ($device)"
+    resize2fs "$device"
+done < "$marker"
+rm -f "$marker"
+`
+
+// growFsServiceUnit runs the grow script once the file systems are mounted, as long as the marker exists
+const growFsServiceUnit = `[Unit]
+Description=Grow file systems to fill their partitions on first boot
+ConditionPathExists=/` + growFsMarkerFile + `
+After=local-fs.target
+Before=sysinit.target
+DefaultDependencies=no
+
+[Service]
+Type=oneshot
+ExecStart=/` + growFsScriptFile + `
+
+[Install]
+WantedBy=sysinit.target
+`
+
+// configureGrowFsOnBoot writes the marker listing the file systems to grow on first boot, and installs and
+// enables the service growing them. The file systems are left shrunk by [FsSize] or [FsPadding] in the image.
+func configureGrowFsOnBoot(installChroot *safechroot.Chroot, mountPoints []string) (err error) {
+	if len(mountPoints) == 0 {
+		return
+	}
+
+	ReportAction("Configuring file systems to grow on first boot")
+
+	installRoot := installChroot.RootDir()
+	resize2fsFound := false
+	for _, resize2fsPath := range []string{"usr/sbin/resize2fs", "sbin/resize2fs"} {
+		if exists, _ := file.PathExists(filepath.Join(installRoot, resize2fsPath)); exists {
+			resize2fsFound = true
+		}
+	}
+	if !resize2fsFound {
+		return fmt.Errorf("cannot grow file systems on first boot: resize2fs is not installed, add the 'e2fsprogs' package to the package lists")
+	}
+
+	files := map[string]string{
+		growFsMarkerFile:  renderGrowFsMarker(mountPoints),
+		growFsScriptFile:  growFsScript,
+		growFsServiceFile: growFsServiceUnit,
+	}
+	for path, contents := range files {
+		installPath := filepath.Join(installRoot, path)
+		err = os.MkdirAll(filepath.Dir(installPath), growFsDirMode)
+		if err != nil {
+			return
+		}
+		err = file.Write(contents, installPath)
+		if err != nil {
+			return
+		}
+	}
+
+	err = os.Chmod(filepath.Join(installRoot, growFsScriptFile), growFsScriptMode)
+	if err != nil {
+		return
+	}
+
+	return enableService(installChroot, growFsService)
+}
+
+// renderGrowFsMarker lists the mount points whose file systems are grown on first boot, one per line
+func renderGrowFsMarker(mountPoints []string) string {
+	return strings.Join(mountPoints, "\n") + "\n"
+}
@@ -0,0 +1,142 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package installutils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"microsoft.com/pkggen/internal/file"
+	"microsoft.com/pkggen/internal/logger"
+	"microsoft.com/pkggen/internal/safechroot"
+	"microsoft.com/pkggen/internal/shell"
+)
+
+const (
+	grubEditenvTool = "usr/bin/grub2-editenv"
+	grubEnvFile     = "boot/grub2/grubenv"
+	// grubEnvLoadLine loads grubenv in a grub.cfg installed from the template, which doesn't otherwise read it
+	grubEnvLoadLine = "load_env -f $bootprefix/grub2/grubenv"
+	// grubEnvLoadAnchor is the template line grubenv is loaded after, so its variables can't override mariner.cfg's
+	grubEnvLoadAnchor = "load_env -f $bootprefix/mariner.cfg"
+)
+
+// InstallGrubEnv sets the GrubEnv variables in the boot partition's grubenv with grub2-editenv, then checks
+// grub2-editenv lists them back with the same values. It runs after grub.cfg is final, and loads grubenv
+// from grub.cfg if it doesn't already.
+func InstallGrubEnv(installChroot *safechroot.Chroot, grubEnv map[string]string) (err error) {
+	const grubCfgFile = "boot/grub2/grub.cfg"
+
+	if len(grubEnv) == 0 {
+		return
+	}
+
+	ReportAction("Setting grubenv variables")
+
+	installRoot := installChroot.RootDir()
+	exists, err := file.PathExists(filepath.Join(installRoot, grubEditenvTool))
+	if err != nil {
+		return
+	}
+	if !exists {
+		return fmt.Errorf("cannot use [GrubEnv]: /%s is not installed, add the package providing it to the package lists", grubEditenvTool)
+	}
+
+	editenvArgs := []string{"/" + grubEnvFile, "set"}
+	for _, name := range sortedGrubEnvNames(grubEnv) {
+		editenvArgs = append(editenvArgs, fmt.Sprintf("%s=%s", name, grubEnv[name]))
+	}
+
+	var listed string
+	err = installChroot.UnsafeRun(func() error {
+		_, stderr, err := shell.Execute("grub2-editenv", editenvArgs...)
+		if err != nil {
+			return fmt.Errorf("failed to run grub2-editenv: %v: %w", stderr, err)
+		}
+
+		listed, stderr, err = shell.Execute("grub2-editenv", "/"+grubEnvFile, "list")
+		if err != nil {
+			return fmt.Errorf("failed to list grubenv: %v: %w", stderr, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	err = checkGrubEnv(grubEnv, parseGrubEnvList(listed))
+	if err != nil {
+		return
+	}
+
+	grubCfgPath := filepath.Join(installRoot, grubCfgFile)
+	grubCfg, err := os.ReadFile(grubCfgPath)
+	if err != nil {
+		return
+	}
+
+	updatedGrubCfg, ok := addGrubEnvLoad(string(grubCfg))
+	if !ok {
+		logger.Log.Warnf("grub.cfg doesn't load grubenv and has no (%s) line to load it after, the [GrubEnv] variables must be loaded by grub.cfg itself", grubEnvLoadAnchor)
+		return
+	}
+
+	return file.Write(updatedGrubCfg, grubCfgPath)
+}
+
+func sortedGrubEnvNames(grubEnv map[string]string) (names []string) {
+	for name := range grubEnv {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// parseGrubEnvList parses the "name=value" lines printed by 'grub2-editenv list'.
+func parseGrubEnvList(listed string) (grubEnv map[string]string) {
+	grubEnv = make(map[string]string)
+	for _, line := range strings.Split(listed, "\n") {
+		i := strings.Index(line, "=")
+		if i <= 0 {
+			continue
+		}
+		grubEnv[line[:i]] = line[i+1:]
+	}
+	return
+}
+
+// checkGrubEnv returns an error if a requested variable is missing from, or differs in, the written grubenv.
+func checkGrubEnv(requested, written map[string]string) (err error) {
+	for _, name := range sortedGrubEnvNames(requested) {
+		value, ok := written[name]
+		if !ok {
+			return fmt.Errorf("grubenv variable (%s) is missing after grub2-editenv", name)
+		}
+		if value != requested[name] {
+			return fmt.Errorf("grubenv variable (%s) is (%s) after grub2-editenv, expected (%s)", name, value, requested[name])
+		}
+	}
+	return
+}
+
+// addGrubEnvLoad loads grubenv after mariner.cfg in a grub.cfg installed from the template. It returns the
+// grub.cfg unchanged and true if it already mentions grubenv, and false if it has no line to load it after.
+func addGrubEnvLoad(grubCfg string) (updatedGrubCfg string, ok bool) {
+	if strings.Contains(grubCfg, "grubenv") {
+		return grubCfg, true
+	}
+
+	lines := strings.Split(grubCfg, "\n")
+	for i, line := range lines {
+		if strings.TrimSpace(line) == grubEnvLoadAnchor {
+			lines = append(lines[:i+1], append([]string{grubEnvLoadLine}, lines[i+1:]...)...)
+			return strings.Join(lines, "\n"), true
+		}
+	}
+
+	return grubCfg, false
+}
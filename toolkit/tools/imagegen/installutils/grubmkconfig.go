@@ -0,0 +1,159 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package installutils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"microsoft.com/pkggen/imagegen/configuration"
+	"microsoft.com/pkggen/internal/file"
+	"microsoft.com/pkggen/internal/logger"
+	"microsoft.com/pkggen/internal/safechroot"
+	"microsoft.com/pkggen/internal/shell"
+)
+
+const (
+	grubMkconfigTool = "usr/sbin/grub2-mkconfig"
+	marinerCfgFile   = "boot/mariner.cfg"
+	systemdCfgFile   = "boot/systemd.cfg"
+)
+
+// RegenerateGrubCfg writes the kernel command line of the grub.cfg installed by InstallGrubCfg, including
+// the root device and verity arguments, into /etc/default/grub along with the configured defaults, then
+// regenerates grub.cfg with grub2-mkconfig.
+func RegenerateGrubCfg(installChroot *safechroot.Chroot, grubMkconfig configuration.GrubMkconfig) (err error) {
+	const grubCfgFile = "boot/grub2/grub.cfg"
+
+	if !grubMkconfig.Enable {
+		return
+	}
+
+	ReportAction("Regenerating grub.cfg with grub2-mkconfig")
+
+	installRoot := installChroot.RootDir()
+	exists, err := file.PathExists(filepath.Join(installRoot, grubMkconfigTool))
+	if err != nil {
+		return
+	}
+	if !exists {
+		return fmt.Errorf("cannot use [GrubMkconfig]: /%s is not installed, add the package providing it to the package lists", grubMkconfigTool)
+	}
+
+	grubCfg, err := os.ReadFile(filepath.Join(installRoot, grubCfgFile))
+	if err != nil {
+		return
+	}
+	grubEnv, err := readGrubCmdlineEnv(installRoot)
+	if err != nil {
+		return
+	}
+	cmdline, err := grubCfgKernelArgs(string(grubCfg), grubEnv)
+	if err != nil {
+		return
+	}
+
+	settings := map[string]string{configuration.GrubCmdlineLinuxVar: cmdline}
+	for name, value := range grubMkconfig.Defaults {
+		settings[name] = value
+	}
+
+	installGrubDefaultsFile := filepath.Join(installRoot, grubDefaultsFile)
+	grubDefaults, err := os.ReadFile(installGrubDefaultsFile)
+	if err != nil && !os.IsNotExist(err) {
+		return
+	}
+	err = os.MkdirAll(filepath.Dir(installGrubDefaultsFile), os.ModePerm)
+	if err != nil {
+		return
+	}
+	logger.Log.Infof("Setting %s to (%s)", configuration.GrubCmdlineLinuxVar, cmdline)
+	err = file.Write(renderGrubDefaults(string(grubDefaults), settings), installGrubDefaultsFile)
+	if err != nil {
+		return
+	}
+
+	return installChroot.UnsafeRun(func() error {
+		_, stderr, err := shell.Execute("grub2-mkconfig", "-o", "/"+grubCfgFile)
+		if err != nil {
+			return fmt.Errorf("failed to run grub2-mkconfig: %v: %w", stderr, err)
+		}
+		return nil
+	})
+}
+
+// readGrubCmdlineEnv reads the variables grub.cfg loads its kernel arguments from, as the template does:
+// mariner_cmdline from /boot/mariner.cfg, and systemd_cmdline from /boot/systemd.cfg or its default.
+func readGrubCmdlineEnv(installRoot string) (grubEnv map[string]string, err error) {
+	grubEnv = map[string]string{"systemd_cmdline": "net.ifnames=0"}
+
+	for _, envFile := range []string{marinerCfgFile, systemdCfgFile} {
+		lines, readErr := file.ReadLines(filepath.Join(installRoot, envFile))
+		if os.IsNotExist(readErr) {
+			continue
+		}
+		if readErr != nil {
+			return nil, readErr
+		}
+
+		for _, line := range lines {
+			keyValue := strings.SplitN(line, "=", 2)
+			if len(keyValue) == 2 && !strings.HasPrefix(strings.TrimSpace(line), "#") {
+				grubEnv[strings.TrimSpace(keyValue[0])] = strings.TrimSpace(keyValue[1])
+			}
+		}
+	}
+	return
+}
+
+// grubCfgKernelArgs returns the arguments of the first linux command of grub.cfg, expanding the grub
+// variables it references from grubEnv and the 'set' commands of grub.cfg.
+func grubCfgKernelArgs(grubCfg string, grubEnv map[string]string) (cmdline string, err error) {
+	const (
+		linuxCommand = "linux"
+		setCommand   = "set "
+	)
+
+	vars := make(map[string]string, len(grubEnv))
+	for name, value := range grubEnv {
+		vars[name] = value
+	}
+
+	for _, line := range strings.Split(grubCfg, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, setCommand) {
+			keyValue := strings.SplitN(strings.TrimPrefix(line, setCommand), "=", 2)
+			if len(keyValue) == 2 {
+				vars[keyValue[0]] = keyValue[1]
+			}
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != linuxCommand {
+			continue
+		}
+
+		// Skip the kernel path
+		var args []string
+		for _, arg := range fields[2:] {
+			arg = expandGrubVars(arg, vars)
+			if arg != "" {
+				args = append(args, arg)
+			}
+		}
+		return strings.Join(args, " "), nil
+	}
+
+	return "", fmt.Errorf("grub.cfg has no linux command to read the kernel command line from")
+}
+
+// expandGrubVars replaces the $name and ${name} references of a grub word with their values
+func expandGrubVars(word string, vars map[string]string) string {
+	return strings.TrimSpace(os.Expand(word, func(name string) string {
+		return vars[name]
+	}))
+}
@@ -0,0 +1,197 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package installutils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"microsoft.com/pkggen/imagegen/configuration"
+	"microsoft.com/pkggen/internal/file"
+	"microsoft.com/pkggen/internal/logger"
+)
+
+const (
+	grubDir           = "boot/grub2"
+	grubThemesDir     = "boot/grub2/themes"
+	grubDefaultsFile  = "etc/default/grub"
+	grubThemeVar      = "GRUB_THEME"
+	grubBackgroundVar = "GRUB_BACKGROUND"
+)
+
+// grubDefaultsLineRegex matches a 'KEY=value' line in /etc/default/grub
+var grubDefaultsLineRegex = regexp.MustCompile(`^\s*([A-Z_]+)=`)
+
+// InstallGrubTheme copies a grub theme and background image into /boot/grub2 and loads them from grub.cfg.
+// If the image has an /etc/default/grub, GRUB_THEME and GRUB_BACKGROUND are set as well so the theme
+// is kept when grub2-mkconfig regenerates grub.cfg.
+func InstallGrubTheme(installRoot string, grubTheme configuration.GrubTheme) (err error) {
+	const grubCfgFile = "boot/grub2/grub.cfg"
+
+	if !grubTheme.IsEnabled() {
+		return
+	}
+
+	ReportAction("Installing grub theme")
+
+	// Paths as seen by the booted system, used for /etc/default/grub
+	themeFile, backgroundFile := "", ""
+
+	if grubTheme.Directory != "" {
+		themeDir := filepath.Join(grubThemesDir, grubTheme.GetThemeName())
+		err = copyGrubThemeDir(grubTheme.Directory, filepath.Join(installRoot, themeDir))
+		if err != nil {
+			return fmt.Errorf("failed to copy grub theme (%s): %w", grubTheme.Directory, err)
+		}
+		themeFile = filepath.Join(themeDir, configuration.GrubThemeFile)
+	}
+
+	if grubTheme.Background != "" {
+		backgroundPath := filepath.Join(grubDir, filepath.Base(grubTheme.Background))
+		err = file.CopyAndChangeMode(grubTheme.Background, filepath.Join(installRoot, backgroundPath), bootDirectoryDirMode, bootDirectoryFileMode)
+		if err != nil {
+			return fmt.Errorf("failed to copy grub background (%s): %w", grubTheme.Background, err)
+		}
+		backgroundFile = backgroundPath
+	}
+
+	installGrubCfgFile := filepath.Join(installRoot, grubCfgFile)
+	grubCfg, err := os.ReadFile(installGrubCfgFile)
+	if err != nil {
+		return
+	}
+	err = file.Write(renderGrubCfgTheme(string(grubCfg), grubTheme), installGrubCfgFile)
+	if err != nil {
+		return
+	}
+
+	installGrubDefaultsFile := filepath.Join(installRoot, grubDefaultsFile)
+	exists, err := file.PathExists(installGrubDefaultsFile)
+	if err != nil || !exists {
+		logger.Log.Debugf("No %s in the image, the grub theme is only set in grub.cfg", grubDefaultsFile)
+		return
+	}
+
+	grubDefaults, err := os.ReadFile(installGrubDefaultsFile)
+	if err != nil {
+		return
+	}
+	settings := map[string]string{}
+	if themeFile != "" {
+		settings[grubThemeVar] = "/" + themeFile
+	}
+	if backgroundFile != "" {
+		settings[grubBackgroundVar] = "/" + backgroundFile
+	}
+	return file.Write(renderGrubDefaults(string(grubDefaults), settings), installGrubDefaultsFile)
+}
+
+// GrubThemeFiles lists every file of a grub theme directory, relative to it
+func GrubThemeFiles(themeDir string) (relPaths []string, err error) {
+	err = filepath.Walk(themeDir, func(path string, info os.FileInfo, walkErr error) (err error) {
+		if walkErr != nil || info.IsDir() {
+			return walkErr
+		}
+
+		relPath, err := filepath.Rel(themeDir, path)
+		if err != nil {
+			return
+		}
+		relPaths = append(relPaths, relPath)
+		return
+	})
+	return
+}
+
+// copyGrubThemeDir copies every file of a theme directory, keeping its layout
+func copyGrubThemeDir(srcDir, dstDir string) (err error) {
+	relPaths, err := GrubThemeFiles(srcDir)
+	if err != nil {
+		return
+	}
+
+	for _, relPath := range relPaths {
+		err = file.CopyAndChangeMode(filepath.Join(srcDir, relPath), filepath.Join(dstDir, relPath), bootDirectoryDirMode, bootDirectoryFileMode)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// renderGrubCfgTheme switches grub.cfg to the graphical terminal and loads the theme and background
+// before the first menu entry. Paths go through $bootprefix so they also resolve from a separate boot partition.
+func renderGrubCfgTheme(grubCfg string, grubTheme configuration.GrubTheme) string {
+	const menuEntryPrefix = "menuentry "
+
+	themeLines := []string{
+		"insmod all_video",
+		"insmod gfxterm",
+		"set gfxmode=auto",
+		"terminal_output gfxterm",
+	}
+	if grubTheme.Directory != "" {
+		// Theme images are almost always PNG, load it even when the background is in another format
+		themeLines = append(themeLines,
+			"insmod png",
+			fmt.Sprintf("set theme=$bootprefix/grub2/themes/%s/%s", grubTheme.GetThemeName(), configuration.GrubThemeFile),
+			"export theme",
+		)
+	}
+	if grubTheme.Background != "" {
+		themeLines = append(themeLines,
+			fmt.Sprintf("insmod %s", grubTheme.GetBackgroundModule()),
+			fmt.Sprintf("background_image $bootprefix/grub2/%s", filepath.Base(grubTheme.Background)),
+		)
+	}
+
+	lines := strings.Split(grubCfg, "\n")
+	insertAt := len(lines)
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), menuEntryPrefix) {
+			insertAt = i
+			break
+		}
+	}
+
+	newLines := append([]string{}, lines[:insertAt]...)
+	newLines = append(newLines, themeLines...)
+	return strings.Join(append(newLines, lines[insertAt:]...), "\n")
+}
+
+// renderGrubDefaults sets variables in /etc/default/grub, replacing existing assignments and
+// appending the rest in name order.
+func renderGrubDefaults(grubDefaults string, settings map[string]string) string {
+	var lines []string
+	if strings.TrimSpace(grubDefaults) != "" {
+		lines = strings.Split(strings.TrimRight(grubDefaults, "\n"), "\n")
+	}
+	written := map[string]bool{}
+	for i, line := range lines {
+		match := grubDefaultsLineRegex.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		if value, ok := settings[match[1]]; ok {
+			lines[i] = fmt.Sprintf("%s=\"%s\"", match[1], value)
+			written[match[1]] = true
+		}
+	}
+
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !written[key] {
+			lines = append(lines, fmt.Sprintf("%s=\"%s\"", key, settings[key]))
+		}
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
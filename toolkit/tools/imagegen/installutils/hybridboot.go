@@ -0,0 +1,90 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package installutils
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"microsoft.com/pkggen/internal/file"
+	"microsoft.com/pkggen/internal/safechroot"
+	"microsoft.com/pkggen/internal/shell"
+)
+
+const (
+	// grubPcModulesDir holds the BIOS grub modules grub2-install embeds into the BIOS boot partition
+	grubPcModulesDir = "/usr/lib/grub/i386-pc"
+	// espBootDir is the removable media path UEFI firmware loads from, relative to the ESP
+	espBootDir = "EFI/BOOT"
+)
+
+// installHybridBootloader installs both the BIOS and the UEFI bootloaders onto a GPT disk, so the image
+// boots on either firmware once it is written to a USB drive. BIOS firmware loads grub's core image
+// from the BIOS boot partition, UEFI firmware loads shim from the ESP. Both end up reading the same grub.cfg.
+func installHybridBootloader(installChroot *safechroot.Chroot, encryptEnabled bool, efiPath, bootUUID, bootPrefix, bootDevPath string) (err error) {
+	err = checkHybridBootloaderComponents(grubPcModulesDir, efiPath)
+	if err != nil {
+		return
+	}
+
+	err = installLegacyBootloader(installChroot, bootDevPath, encryptEnabled)
+	if err != nil {
+		return fmt.Errorf("failed to install the BIOS bootloader: %w", err)
+	}
+
+	err = installEfiBootloader(encryptEnabled, efiPath, bootUUID, bootPrefix)
+	if err != nil {
+		return fmt.Errorf("failed to install the UEFI bootloader: %w", err)
+	}
+
+	return setProtectiveMbrBootFlag(bootDevPath)
+}
+
+// checkHybridBootloaderComponents fails if the BIOS grub modules are missing from the build environment,
+// or if shim and grub are missing from the image's ESP.
+func checkHybridBootloaderComponents(grubModulesDir, efiPath string) (err error) {
+	var missing []string
+
+	exists, err := file.DirExists(grubModulesDir)
+	if err != nil {
+		return
+	}
+	if !exists {
+		missing = append(missing, fmt.Sprintf("BIOS grub modules (%s), install 'grub2-pc' in the build environment", grubModulesDir))
+	}
+
+	efiBinaries := []struct {
+		name    string
+		pkgName string
+	}{
+		{name: "bootx64.efi", pkgName: "shim"},
+		{name: "grubx64.efi", pkgName: "grub2-efi-binary"},
+	}
+	for _, binary := range efiBinaries {
+		binaryPath := filepath.Join(efiPath, espBootDir, binary.name)
+		exists, err = file.PathExists(binaryPath)
+		if err != nil {
+			return
+		}
+		if !exists {
+			missing = append(missing, fmt.Sprintf("UEFI bootloader (/%s/%s), add '%s' to the package lists", espBootDir, binary.name, binary.pkgName))
+		}
+	}
+
+	if len(missing) != 0 {
+		return fmt.Errorf("hybrid boot is missing bootloader components: %s", strings.Join(missing, "; "))
+	}
+	return
+}
+
+// setProtectiveMbrBootFlag marks the protective MBR's partition as active. Some BIOS firmware only boots
+// USB drives which have an active MBR partition, and ignore GPT disks without one.
+func setProtectiveMbrBootFlag(diskDevPath string) (err error) {
+	_, stderr, err := shell.Execute("parted", diskDevPath, "--script", "disk_set", "pmbr_boot", "on")
+	if err != nil {
+		return fmt.Errorf("failed to set the protective MBR boot flag on (%s): %v: %w", diskDevPath, stderr, err)
+	}
+	return
+}
@@ -0,0 +1,298 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package installutils
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"microsoft.com/pkggen/imagegen/configuration"
+	"microsoft.com/pkggen/imagegen/diskutils"
+	"microsoft.com/pkggen/internal/file"
+	"microsoft.com/pkggen/internal/logger"
+	"microsoft.com/pkggen/internal/safechroot"
+	"microsoft.com/pkggen/internal/shell"
+)
+
+const (
+	// initramfsWorkDir is where an initramfs is unpacked, inside the install chroot so the script can edit it
+	initramfsWorkDir = "/var/tmp/imager-initramfs"
+	// earlyCpioMarker is the file dracut puts in an uncompressed early microcode archive
+	earlyCpioMarker = "early_cpio"
+
+	initramfsDirMode  = 0755
+	initramfsFileMode = 0644
+)
+
+// initramfsCompression is a compression format an initramfs may use
+type initramfsCompression struct {
+	name  string
+	magic []byte
+	// tool decompresses with "-dc" and compresses with "-c"
+	tool string
+}
+
+var initramfsCompressions = []initramfsCompression{
+	{name: "gzip", magic: []byte{0x1f, 0x8b}, tool: "gzip"},
+	{name: "xz", magic: []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, tool: "xz"},
+	{name: "zstd", magic: []byte{0x28, 0xb5, 0x2f, 0xfd}, tool: "zstd"},
+	{name: "none", magic: []byte("07070")},
+}
+
+// regenerateInitramfs rebuilds the initramfs of every installed kernel with dracut if a step changed the
+// dracut configuration, so it picks up all of the changes at once. An encrypted image's initramfs also
+// gets the modules and files needed to unlock the root.
+func regenerateInitramfs(installChroot *safechroot.Chroot, config configuration.SystemConfig) (err error) {
+	const (
+		libModDir          = "/lib/modules"
+		initrdPrefix       = "/boot/initrd.img-"
+		cryptTabPath       = "/etc/crypttab"
+		cryptDracutModules = "dm crypt crypt-gpg crypt-loop lvm"
+	)
+
+	if config.EarlyConsole.IsEmpty() && len(config.KernelModules.Include) == 0 && len(config.Firmware.Initramfs) == 0 && !config.Encryption.Enable {
+		return
+	}
+
+	ReportAction("Regenerating the initramfs")
+
+	var extraArgs []string
+	if config.Encryption.Enable {
+		extraArgs = []string{
+			"--fstab",
+			"--add", cryptDracutModules,
+			"-I", fmt.Sprintf("%s %s", cryptTabPath, diskutils.DefaultKeyFilePath),
+		}
+	}
+
+	return installChroot.UnsafeRun(func() (err error) {
+		initrdImages, err := filepath.Glob(initrdPrefix + "*")
+		if err != nil {
+			return
+		}
+		if len(initrdImages) == 0 {
+			return fmt.Errorf("unable to find an initrd image")
+		}
+
+		for _, initrdImage := range initrdImages {
+			kernel := strings.TrimPrefix(initrdImage, initrdPrefix)
+			logger.Log.Infof("Regenerating initramfs (%s)", initrdImage)
+
+			dracutArgs := []string{"-f", "--no-hostonly", "--kmoddir", filepath.Join(libModDir, kernel)}
+			dracutArgs = append(dracutArgs, extraArgs...)
+			dracutArgs = append(dracutArgs, initrdImage, kernel)
+			_, stderr, dracutErr := shell.Execute("dracut", dracutArgs...)
+			if dracutErr != nil {
+				return fmt.Errorf("failed to regenerate initramfs (%s): %v: %w", initrdImage, stderr, dracutErr)
+			}
+		}
+		return
+	})
+}
+
+// customizeInitramfs unpacks the initramfs of every installed kernel, adds the configured files, runs the
+// configured script against it and repacks it with the same compression. The initramfs keeps its path, so
+// the boot configuration still points to it.
+func customizeInitramfs(installChroot *safechroot.Chroot, customization configuration.InitramfsCustomization) (err error) {
+	const initrdPattern = "boot/initrd.img-*"
+
+	if customization.IsEmpty() {
+		return
+	}
+
+	ReportAction("Customizing the initramfs")
+
+	installRoot := installChroot.RootDir()
+	initrdImages, err := filepath.Glob(filepath.Join(installRoot, initrdPattern))
+	if err != nil {
+		return
+	}
+	if len(initrdImages) == 0 {
+		return fmt.Errorf("unable to find an initramfs to customize")
+	}
+
+	for _, initrdImage := range initrdImages {
+		err = customizeSingleInitramfs(installChroot, initrdImage, customization)
+		if err != nil {
+			return fmt.Errorf("failed to customize initramfs (%s): %w", filepath.Base(initrdImage), err)
+		}
+	}
+	return
+}
+
+// customizeSingleInitramfs unpacks, edits and repacks one initramfs
+func customizeSingleInitramfs(installChroot *safechroot.Chroot, initrdImage string, customization configuration.InitramfsCustomization) (err error) {
+	const squashErrors = false
+
+	workDir := filepath.Join(installChroot.RootDir(), initramfsWorkDir)
+	archive := workDir + ".cpio"
+	err = os.RemoveAll(workDir)
+	if err != nil {
+		return
+	}
+	err = os.MkdirAll(workDir, os.ModePerm)
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(workDir)
+	defer os.Remove(archive)
+
+	compression, err := detectInitramfsCompression(initrdImage)
+	if err != nil {
+		return
+	}
+	logger.Log.Infof("Unpacking %s compressed initramfs (%s)", compression.name, initrdImage)
+
+	err = decompressInitramfs(compression, initrdImage, archive)
+	if err != nil {
+		return
+	}
+	_, stderr, err := shell.Execute("cpio", "--quiet", "-idm", "--no-absolute-filenames", "-D", workDir, "-F", archive)
+	if err != nil {
+		return fmt.Errorf("failed to unpack: %v: %w", stderr, err)
+	}
+
+	// An uncompressed archive may only be the early microcode archive, followed by the compressed initramfs
+	exists, err := file.PathExists(filepath.Join(workDir, earlyCpioMarker))
+	if err != nil {
+		return
+	}
+	if exists {
+		return fmt.Errorf("initramfs starts with an early microcode archive, which is not supported")
+	}
+
+	for _, initramfsFile := range customization.Files {
+		logger.Log.Infof("Adding (%s) to the initramfs as (%s)", initramfsFile.Source, initramfsFile.Path)
+		err = file.CopyAndChangeMode(initramfsFile.Source, filepath.Join(workDir, initramfsFile.Path), initramfsDirMode, initramfsFileMode)
+		if err != nil {
+			return
+		}
+	}
+
+	if customization.Script != "" {
+		ReportActionf("Running initramfs script: %s", filepath.Base(customization.Script))
+		err = installChroot.AddFiles(safechroot.FileToCopy{Src: customization.Script, Dest: customization.Script})
+		if err != nil {
+			return
+		}
+
+		err = installChroot.UnsafeRun(func() error {
+			defer os.Remove(customization.Script)
+			return shell.ExecuteLive(squashErrors, shell.ShellProgram, customization.Script, initramfsWorkDir)
+		})
+		if err != nil {
+			return fmt.Errorf("initramfs script (%s) failed: %w", customization.Script, err)
+		}
+	}
+
+	fileList, err := initramfsFileList(workDir)
+	if err != nil {
+		return
+	}
+	_, stderr, err = shell.ExecuteWithStdin(fileList, "cpio", "--quiet", "-o", "-H", "newc", "-D", workDir, "-O", archive)
+	if err != nil {
+		return fmt.Errorf("failed to repack: %v: %w", stderr, err)
+	}
+
+	logger.Log.Infof("Repacking initramfs (%s) with %s compression", initrdImage, compression.name)
+	return compressInitramfs(compression, archive, initrdImage)
+}
+
+// detectInitramfsCompression identifies the compression of an initramfs from its first bytes
+func detectInitramfsCompression(initrdImage string) (compression initramfsCompression, err error) {
+	const headerSize = 8
+
+	initrd, err := os.Open(initrdImage)
+	if err != nil {
+		return
+	}
+	defer initrd.Close()
+
+	header := make([]byte, headerSize)
+	n, err := initrd.Read(header)
+	if err != nil {
+		return
+	}
+
+	for _, candidate := range initramfsCompressions {
+		if bytes.HasPrefix(header[:n], candidate.magic) {
+			return candidate, nil
+		}
+	}
+	return compression, fmt.Errorf("unknown initramfs format, header (%x)", header[:n])
+}
+
+// decompressInitramfs writes the cpio archive of an initramfs to archive
+func decompressInitramfs(compression initramfsCompression, initrdImage, archive string) (err error) {
+	if compression.tool == "" {
+		return file.Copy(initrdImage, archive)
+	}
+
+	output, err := os.Create(archive)
+	if err != nil {
+		return
+	}
+	defer output.Close()
+
+	stderr, err := shell.ExecuteWithStdout(output, compression.tool, "-dc", initrdImage)
+	if err != nil {
+		return fmt.Errorf("failed to decompress: %v: %w", stderr, err)
+	}
+	return
+}
+
+// compressInitramfs replaces an initramfs with a compressed cpio archive, keeping its mode
+func compressInitramfs(compression initramfsCompression, archive, initrdImage string) (err error) {
+	info, err := os.Stat(initrdImage)
+	if err != nil {
+		return
+	}
+
+	output, err := os.OpenFile(initrdImage, os.O_WRONLY|os.O_TRUNC, info.Mode())
+	if err != nil {
+		return
+	}
+	defer output.Close()
+
+	program, args := compression.tool, []string{"-c", archive}
+	if program == "" {
+		program, args = "cat", []string{archive}
+	}
+	stderr, err := shell.ExecuteWithStdout(output, program, args...)
+	if err != nil {
+		return fmt.Errorf("failed to compress: %v: %w", stderr, err)
+	}
+	return output.Sync()
+}
+
+// initramfsFileList lists every path of an unpacked initramfs relative to its root, in the
+// order cpio archives them
+func initramfsFileList(rootDir string) (fileList string, err error) {
+	var paths []string
+	err = filepath.Walk(rootDir, func(path string, info os.FileInfo, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if path == rootDir {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(rootDir, path)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, relPath)
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	sort.Strings(paths)
+	return strings.Join(paths, "\n") + "\n", nil
+}
@@ -0,0 +1,205 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package installutils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"microsoft.com/pkggen/imagegen/configuration"
+	"microsoft.com/pkggen/internal/logger"
+	"microsoft.com/pkggen/internal/pkgjson"
+	"microsoft.com/pkggen/internal/shell"
+)
+
+// rpmNoProviderPrefix starts the line 'rpm -q --whatprovides' prints for a capability nothing provides
+const rpmNoProviderPrefix = "no package provides "
+
+// installedPackageNames returns the names of the packages installed in the install root
+func installedPackageNames(installRoot string) (names map[string]bool, err error) {
+	stdout, err := rpmQuery(installRoot, "-qa", "--qf", "%{NAME}\n")
+	if err != nil {
+		return
+	}
+
+	return parseRpmNames(stdout), nil
+}
+
+// verifyInstallOptions checks the InstallOptions took effect on the packages installed since preinstalled was
+// listed. They are passed to tdnf as "--setopt" options, which a tdnf that doesn't know them ignores.
+//   - requested are the packages the configuration installs, with optional version constraints
+func verifyInstallOptions(installRoot string, options configuration.InstallOptions, requested []string, preinstalled map[string]bool) (err error) {
+	if options.IsEmpty() {
+		return
+	}
+
+	ReportAction("Verifying the install options took effect")
+
+	installed, err := installedPackageNames(installRoot)
+	if err != nil {
+		return
+	}
+
+	var newPackages []string
+	for name := range installed {
+		if !preinstalled[name] {
+			newPackages = append(newPackages, name)
+		}
+	}
+	sort.Strings(newPackages)
+	if len(newPackages) == 0 {
+		return
+	}
+
+	if options.NoDocs {
+		err = verifyNoDocs(installRoot, newPackages)
+		if err != nil {
+			return
+		}
+	}
+
+	if options.NoWeakDeps {
+		err = verifyNoWeakDeps(installRoot, newPackages, requested)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// verifyNoDocs fails if a documentation file of one of the packages is on disk
+func verifyNoDocs(installRoot string, packages []string) (err error) {
+	args := append([]string{"--root", installRoot, "-qd"}, packages...)
+	stdout, stderr, err := shell.Execute("rpm", args...)
+	if err != nil {
+		logger.Log.Warn(stderr)
+		return fmt.Errorf("failed to list the documentation files of the installed packages:\n%w", err)
+	}
+
+	for _, docFile := range strings.Split(stdout, "\n") {
+		// Packages without documentation print "(contains no files)"
+		if !strings.HasPrefix(docFile, "/") {
+			continue
+		}
+
+		_, statErr := os.Lstat(filepath.Join(installRoot, docFile))
+		if statErr == nil {
+			return fmt.Errorf("[InstallOptions] [NoDocs] did not take effect, documentation file (%s) is installed; the tdnf of the build environment may not support the 'tsflags' option", docFile)
+		}
+	}
+
+	return
+}
+
+// verifyNoWeakDeps fails if one of the packages is only installed because an installed package recommends it:
+// it was not requested, and no installed package requires it.
+func verifyNoWeakDeps(installRoot string, packages, requested []string) (err error) {
+	recommendsOutput, err := rpmQuery(installRoot, "-qa", "--qf", "[%{RECOMMENDNAME}\n]")
+	if err != nil {
+		return
+	}
+	recommended, err := whatProvides(installRoot, capabilityNames(recommendsOutput))
+	if err != nil || len(recommended) == 0 {
+		return
+	}
+
+	requiresOutput, err := rpmQuery(installRoot, "-qa", "--requires")
+	if err != nil {
+		return
+	}
+	required, err := whatProvides(installRoot, capabilityNames(requiresOutput))
+	if err != nil {
+		return
+	}
+
+	requestedNames := make([]string, 0, len(requested))
+	for _, pkg := range requested {
+		pkgVer, parseErr := pkgjson.PackagesListEntryToPackageVer(pkg)
+		if parseErr != nil {
+			return parseErr
+		}
+		requestedNames = append(requestedNames, pkgVer.Name)
+	}
+	requestedProviders, err := whatProvides(installRoot, requestedNames)
+	if err != nil {
+		return
+	}
+
+	weakDeps := weakOnlyPackages(packages, recommended, required, requestedProviders)
+	if len(weakDeps) != 0 {
+		return fmt.Errorf("[InstallOptions] [NoWeakDeps] did not take effect, packages (%s) are only installed as weak dependencies; the tdnf of the build environment may not support the 'install_weak_deps' option", strings.Join(weakDeps, ", "))
+	}
+
+	return
+}
+
+// weakOnlyPackages returns the packages which are recommended, but neither required nor requested
+func weakOnlyPackages(packages []string, recommended, required, requested map[string]bool) (weakDeps []string) {
+	for _, name := range packages {
+		if recommended[name] && !required[name] && !requested[name] {
+			weakDeps = append(weakDeps, name)
+		}
+	}
+	return
+}
+
+// rpmQuery runs an rpm query against the install root's database
+func rpmQuery(installRoot string, args ...string) (stdout string, err error) {
+	stdout, stderr, err := shell.Execute("rpm", append([]string{"--root", installRoot}, args...)...)
+	if err != nil {
+		logger.Log.Warn(stderr)
+		err = fmt.Errorf("failed to query the installed packages (rpm %s):\n%w", strings.Join(args, " "), err)
+	}
+	return
+}
+
+// whatProvides returns the names of the installed packages providing any of the capabilities
+func whatProvides(installRoot string, capabilities []string) (names map[string]bool, err error) {
+	if len(capabilities) == 0 {
+		return map[string]bool{}, nil
+	}
+
+	// rpm fails if any capability has no provider, so a failure is only reported without any output
+	args := append([]string{"--root", installRoot, "-q", "--qf", "%{NAME}\n", "--whatprovides"}, capabilities...)
+	stdout, stderr, queryErr := shell.Execute("rpm", args...)
+	if queryErr != nil && stdout == "" {
+		logger.Log.Warn(stderr)
+		err = fmt.Errorf("failed to query the providers of (%s):\n%w", strings.Join(capabilities, ", "), queryErr)
+		return
+	}
+
+	return parseRpmNames(stdout), nil
+}
+
+// capabilityNames returns the unique capability names of an rpm dependency listing, without their versions.
+// rpmlib() features are left out, no package provides them.
+func capabilityNames(output string) (names []string) {
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "rpmlib(") || strings.HasPrefix(fields[0], "(") || seen[fields[0]] {
+			continue
+		}
+		seen[fields[0]] = true
+		names = append(names, fields[0])
+	}
+	sort.Strings(names)
+	return
+}
+
+// parseRpmNames returns the package names listed one per line, skipping the lines of missing providers
+func parseRpmNames(output string) (names map[string]bool) {
+	names = make(map[string]bool)
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, rpmNoProviderPrefix) {
+			names[line] = true
+		}
+	}
+	return
+}
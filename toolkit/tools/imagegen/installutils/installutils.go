@@ -65,6 +65,31 @@ func GetRequiredPackagesForInstall() []*pkgjson.PackageVer {
 	return packageList
 }
 
+// GetRequiredPackagesForSystemConfig returns the packages the install tools need to build a specific system config,
+// on top of GetRequiredPackagesForInstall. They are installed into the build environment, not the image, and must be
+// part of any offline package cache.
+func GetRequiredPackagesForSystemConfig(systemConfig configuration.SystemConfig) []*pkgjson.PackageVer {
+	packageList := GetRequiredPackagesForInstall()
+
+	for _, script := range systemConfig.PostInstallScripts {
+		if script.NetworkIsolated() {
+			// unshare is needed to drop the network namespace for isolated scripts
+			packageList = append(packageList, &pkgjson.PackageVer{Name: "util-linux"})
+			break
+		}
+	}
+
+	if systemConfig.ReadOnlyVerityRoot.Enable {
+		// veritysetup (and its dependencies) manage the verity disk
+		packageList = append(packageList, &pkgjson.PackageVer{Name: "device-mapper"}, &pkgjson.PackageVer{Name: "veritysetup"})
+		if systemConfig.ReadOnlyVerityRoot.RootHashSigningKey != "" {
+			packageList = append(packageList, &pkgjson.PackageVer{Name: "openssl"})
+		}
+	}
+
+	return packageList
+}
+
 // CreateMountPointPartitionMap creates a map between the mountpoint supplied in the config file and the device path
 // of the partition
 // - partDevPathMap is a map of partition IDs to partition device paths
@@ -98,6 +123,48 @@ func CreateMountPointPartitionMap(partDevPathMap, partIDToFsTypeMap map[string]s
 	return
 }
 
+// CheckVerityBootPartitions returns an error if a disk with a read-only verity root is missing the partitions
+// its boot flow needs, before anything is installed: the separate /boot partition holding the initramfs with the
+// verity files, and the EFI system partition of a UEFI boot.
+// - mountPointMap is the map of mountpoint to partition device path
+// - config is the SystemConfig from a config file
+func CheckVerityBootPartitions(mountPointMap map[string]string, config configuration.SystemConfig) (err error) {
+	const (
+		bootMountPoint = "/boot"
+		espMountPoint  = "/boot/efi"
+	)
+
+	if !config.ReadOnlyVerityRoot.Enable {
+		return
+	}
+
+	requiredMountPoints := []string{bootMountPoint}
+	if config.BootType == configuration.BootTypeEfi || config.BootType == configuration.BootTypeHybrid {
+		requiredMountPoints = append(requiredMountPoints, espMountPoint)
+	}
+
+	for _, mountPoint := range requiredMountPoints {
+		devPath, ok := mountPointMap[mountPoint]
+		if !ok || devPath == "" {
+			return fmt.Errorf("a read-only verity root requires a partition mounted at (%s), but none was found on the disk", mountPoint)
+		}
+		if devPath == mountPointMap[rootMountPoint] {
+			return fmt.Errorf("a read-only verity root requires (%s) on a separate partition from the root, but both are on (%s)", mountPoint, devPath)
+		}
+
+		var exists bool
+		exists, err = file.PathExists(devPath)
+		if err != nil {
+			return
+		}
+		if !exists {
+			return fmt.Errorf("the partition mounted at (%s) for the read-only verity root was not found at (%s)", mountPoint, devPath)
+		}
+	}
+
+	return
+}
+
 // sortMountPoints will return a slice of mount points sorted either forward (for mounting)
 // or backwards (for unmounting)
 // - mountPointMap is the map of mountpoint to partition device path
@@ -309,6 +376,11 @@ func PackageNamesFromSingleSystemConfig(systemConfig configuration.SystemConfig)
 		logger.Log.Tracef("packages %v", packages)
 		finalPkgList = append(finalPkgList, packages.Packages...)
 	}
+	finalPkgList = append(finalPkgList, systemConfig.Firmware.Packages...)
+	if systemConfig.Kdump.Enable {
+		finalPkgList = append(finalPkgList, configuration.KdumpPackage)
+	}
+	finalPkgList = append(finalPkgList, systemConfig.SecureBoot.GetPackages()...)
 	logger.Log.Tracef("finalPkgList = %v", finalPkgList)
 	return
 }
@@ -358,6 +430,7 @@ func PackageNamesFromConfig(config configuration.Config) (packageList []*pkgjson
 		if err != nil {
 			return
 		}
+		packagesToInstall = append(packagesToInstall, systemCfg.PackageInstallGroupPackages()...)
 
 		packages := make([]*pkgjson.PackageVer, 0, len(packagesToInstall))
 		for _, pkg := range packagesToInstall {
@@ -377,6 +450,130 @@ func PackageNamesFromConfig(config configuration.Config) (packageList []*pkgjson
 	return
 }
 
+// ValidatePostConditions checks the finished install root against the configured post-conditions, and
+// returns an error listing every condition which is not met.
+// - installRoot is the path of the install root
+// - installChroot is the chroot of the install root, nil for data-only images which only check files
+// - postConditions are the conditions to check
+func ValidatePostConditions(installRoot string, installChroot *safechroot.Chroot, postConditions configuration.PostConditions) (err error) {
+	const squashErrors = true
+
+	if postConditions.IsEmpty() {
+		return
+	}
+
+	ReportAction("Validating image post-conditions")
+
+	var failures []string
+	for _, path := range postConditions.FilesExist {
+		if !imageFileExists(installRoot, installChroot, path) {
+			failures = append(failures, fmt.Sprintf("file (%s) does not exist", path))
+		}
+	}
+
+	if installChroot != nil {
+		for _, pkg := range postConditions.Packages {
+			runErr := installChroot.UnsafeRun(func() error {
+				return shell.ExecuteLive(squashErrors, "rpm", "-q", pkg)
+			})
+			if runErr != nil {
+				failures = append(failures, fmt.Sprintf("package (%s) is not installed", pkg))
+			}
+		}
+
+		for _, service := range postConditions.ServicesEnabled {
+			runErr := installChroot.UnsafeRun(func() error {
+				return shell.ExecuteLive(squashErrors, "systemctl", "is-enabled", service)
+			})
+			if runErr != nil {
+				failures = append(failures, fmt.Sprintf("service (%s) is not enabled", service))
+			}
+		}
+	}
+
+	if len(failures) != 0 {
+		return fmt.Errorf("%d post-condition(s) not met: %s", len(failures), strings.Join(failures, "; "))
+	}
+
+	logger.Log.Infof("All image post-conditions are met")
+	return
+}
+
+// imageFileExists returns true if a path exists in the image. With a chroot the path is tested inside it, so
+// absolute symlinks such as /etc/localtime resolve against the image instead of the build host. Without one
+// a symlink itself counts as the file, since its target can't be resolved.
+func imageFileExists(installRoot string, installChroot *safechroot.Chroot, path string) bool {
+	const squashErrors = true
+
+	if installChroot != nil {
+		err := installChroot.UnsafeRun(func() error {
+			return shell.ExecuteLive(squashErrors, "test", "-e", path)
+		})
+		return err == nil
+	}
+
+	_, err := os.Lstat(filepath.Join(installRoot, path))
+	return err == nil
+}
+
+// PopulateDataOnlyInstallRoot copies the additional files of a data-only system config onto its mounted
+// partitions. There is no operating system in the install root, so nothing is run inside it.
+// - installRoot is the path of the mounted partitions
+// - config is the SystemConfig, which must have DataOnly set
+func PopulateDataOnlyInstallRoot(installRoot string, config configuration.SystemConfig) (err error) {
+	ReportAction("Copying additional files")
+
+	for srcFile, dstFile := range config.AdditionalFiles {
+		dstPath := filepath.Join(installRoot, dstFile.Path)
+		err = file.Copy(srcFile, dstPath)
+		if err != nil {
+			return fmt.Errorf("failed to copy additional file (%s): %w", srcFile, err)
+		}
+
+		err = setDataOnlyFilePermissions(dstPath, dstFile)
+		if err != nil {
+			return fmt.Errorf("failed to set permissions on additional file (%s): %w", dstFile.Path, err)
+		}
+	}
+
+	return
+}
+
+// setDataOnlyFilePermissions applies the ownership and mode of an additional file from the host, the owner and
+// group are numeric IDs since there are no user databases to resolve names against.
+func setDataOnlyFilePermissions(dstPath string, dstFile configuration.AdditionalFile) (err error) {
+	if dstFile.Mode != "" {
+		var mode uint64
+		mode, err = dstFile.FileMode()
+		if err != nil {
+			return
+		}
+		err = os.Chmod(dstPath, os.FileMode(mode))
+		if err != nil {
+			return
+		}
+	}
+
+	if dstFile.Owner != "" || dstFile.Group != "" {
+		uid, gid := -1, -1
+		if dstFile.Owner != "" {
+			uid, err = strconv.Atoi(dstFile.Owner)
+			if err != nil {
+				return
+			}
+		}
+		if dstFile.Group != "" {
+			gid, err = strconv.Atoi(dstFile.Group)
+			if err != nil {
+				return
+			}
+		}
+		err = os.Lchown(dstPath, uid, gid)
+	}
+
+	return
+}
+
 // PopulateInstallRoot fills the installroot with packages and configures the image for boot
 // - installChroot is a pointer to the install Chroot object
 // - packagesToInstall is a slice of packages to install
@@ -388,19 +585,45 @@ func PackageNamesFromConfig(config configuration.Config) (packageList []*pkgjson
 // - encryptedRoot stores information about the encrypted root device if root encryption is enabled
 // - diffDiskBuild is a flag that denotes whether this is a diffdisk build or not
 // - hidepidEnabled is a flag that denotes whether /proc will be mounted with the hidepid option
-func PopulateInstallRoot(installChroot *safechroot.Chroot, packagesToInstall []string, config configuration.SystemConfig, installMap, mountPointToFsTypeMap, mountPointToMountArgsMap map[string]string, isRootFS bool, encryptedRoot diskutils.EncryptedRootDevice, diffDiskBuild, hidepidEnabled bool) (err error) {
-	const (
-		filesystemPkg = "filesystem"
-	)
-
+// - checkpoint saves the install root once the packages are installed, or restores it instead of installing them, nil to disable
+// It returns the recorder of the change report, which is written once the image is final.
+func PopulateInstallRoot(installChroot *safechroot.Chroot, packagesToInstall []string, config configuration.SystemConfig, configHash string, installMap, mountPointToFsTypeMap, mountPointToMountArgsMap map[string]string, isRootFS bool, encryptedRoot diskutils.EncryptedRootDevice, diffDiskBuild, hidepidEnabled bool, checkpoint *PackageCheckpoint) (changes *ChangeRecorder, err error) {
 	defer stopGPGAgent(installChroot)
 
 	ReportAction("Initializing RPM Database")
 
 	installRoot := filepath.Join(rootMountPoint, installChroot.RootDir())
 
+	// Start from the base rootfs, if any, so the packages are installed on top of it
+	if config.BaseRootfsTarball != "" {
+		err = extractBaseRootfs(installRoot, config.BaseRootfsTarball)
+		if err != nil {
+			return
+		}
+	} else if config.BaseRootfsDir != "" {
+		err = copyBaseRootfsDir(installRoot, config.BaseRootfsDir)
+		if err != nil {
+			return
+		}
+	}
+
 	// Initialize RPM Database so we can install RPMs into the installroot
-	err = initializeRpmDatabase(installRoot, diffDiskBuild)
+	err = initializeRpmDatabase(installRoot, diffDiskBuild, NewTdnfSettings(config))
+	if err != nil {
+		return
+	}
+
+	// The base image may bring its own repositories, so they are checked once it is in place
+	if config.RequireRepoGpgCheck {
+		ReportAction("Verifying repositories check package signatures")
+		err = verifyRepoGpgCheck("/", installRoot)
+		if err != nil {
+			return
+		}
+	}
+
+	// Everything from here on is reported as a change on top of the base image
+	changes, err = newChangeRecorder(installRoot, config.ChangeReport)
 	if err != nil {
 		return
 	}
@@ -419,34 +642,28 @@ func PopulateInstallRoot(installChroot *safechroot.Chroot, packagesToInstall []s
 		}()
 	}
 
-	// Calculate how many packages need to be installed so an accurate percent complete can be reported
-	totalPackages, err := calculateTotalPackages(packagesToInstall, installRoot)
-	if err != nil {
-		return
-	}
-
-	// Keep a running total of how many packages have been installed through all the `TdnfInstallWithProgress` invocations
-	packagesInstalled := 0
+	hostname := config.Hostname
+	writeHostname := !isRootFS && mountPointToFsTypeMap[rootMountPoint] != overlay
 
-	// Install filesystem package first
-	packagesInstalled, err = TdnfInstallWithProgress(filesystemPkg, installRoot, packagesInstalled, totalPackages, true)
+	restored, err := checkpoint.Restore(installRoot)
 	if err != nil {
 		return
 	}
-
-	hostname := config.Hostname
-	if !isRootFS && mountPointToFsTypeMap[rootMountPoint] != overlay {
-		// Add /etc/hostname
-		err = updateHostname(installChroot.RootDir(), hostname)
+	if restored {
+		// The hostname is not part of the checkpoint's key, so it is written again
+		if writeHostname {
+			err = updateHostname(installChroot.RootDir(), hostname)
+			if err != nil {
+				return
+			}
+		}
+	} else {
+		err = installPackages(installChroot, packagesToInstall, config, writeHostname)
 		if err != nil {
 			return
 		}
-	}
 
-	// Install packages one-by-one to avoid exhausting memory
-	// on low resource systems
-	for _, pkg := range packagesToInstall {
-		packagesInstalled, err = TdnfInstallWithProgress(pkg, installRoot, packagesInstalled, totalPackages, true)
+		err = checkpoint.Save(installRoot)
 		if err != nil {
 			return
 		}
@@ -458,9 +675,20 @@ func PopulateInstallRoot(installChroot *safechroot.Chroot, packagesToInstall []s
 		return
 	}
 
+	// Remove the base image's accounts before adding new ones, so their names and IDs can be reused
+	err = removeUsers(installChroot, config.RemoveUsers)
+	if err != nil {
+		return
+	}
+
+	err = removeGroups(installChroot, config.RemoveGroups)
+	if err != nil {
+		return
+	}
+
 	if !isRootFS {
 		// Configure system files
-		err = configureSystemFiles(installChroot, hostname, installMap, mountPointToFsTypeMap, mountPointToMountArgsMap, encryptedRoot, hidepidEnabled)
+		err = configureSystemFiles(installChroot, hostname, installMap, mountPointToFsTypeMap, mountPointToMountArgsMap, encryptedRoot, config.PartitionSettings, hidepidEnabled)
 		if err != nil {
 			return
 		}
@@ -472,141 +700,606 @@ func PopulateInstallRoot(installChroot *safechroot.Chroot, packagesToInstall []s
 		}
 	}
 
+	err = configureLoginDefs(installRoot, config.LoginDefs)
+	if err != nil {
+		return
+	}
+
 	// Add users
 	err = addUsers(installChroot, config.Users)
 	if err != nil {
 		return
 	}
 
-	// Add machine-id
-	err = addMachineID(installChroot)
+	err = installAuthorizedKeys(installRoot, config.AuthorizedKeys)
 	if err != nil {
 		return
 	}
 
-	// Configure for encryption
-	if config.Encryption.Enable {
-		err = updateInitramfsForEncrypt(installChroot)
-		if err != nil {
-			return
-		}
+	// Now that all users and groups exist, set the ownership of the additional files
+	err = setAdditionalFilesPermissions(installChroot, config)
+	if err != nil {
+		return
 	}
 
-	if config.RemoveRpmDb {
-		// When the RemoveRpmDb flag is true, generate a list of installed packages since they cannot be queiried at runtime
-		logger.Log.Info("Generating manifest with package information since RemoveRpmDb is enabled.")
-		generateContainerManifests(installChroot)
+	// Add machine-id
+	err = addMachineID(installChroot)
+	if err != nil {
+		return
 	}
 
-	// Run post-install scripts from within the installroot chroot
-	err = runPostInstallScripts(installChroot, config)
-	return
-}
-
-func generateContainerManifests(installChroot *safechroot.Chroot) {
-	installRoot := filepath.Join(rootMountPoint, installChroot.RootDir())
-	rpmDir := filepath.Join(installRoot, rpmDependenciesDirectory)
-	rpmManifestDir := filepath.Join(installRoot, rpmManifestDirectory)
-	manifest1Path := filepath.Join(rpmManifestDir, "container-manifest-1")
-	manifest2Path := filepath.Join(rpmManifestDir, "container-manifest-2")
-
-	os.MkdirAll(rpmManifestDir, os.ModePerm)
-
-	shell.ExecuteAndLogToFile(manifest1Path, "rpm", "--dbpath", rpmDir, "-qa")
-	shell.ExecuteAndLogToFile(manifest2Path, "rpm", "--dbpath", rpmDir, "-qa", "--qf", "%{NAME}\t%{VERSION}-%{RELEASE}\t%{INSTALLTIME}\t%{BUILDTIME}\t%{VENDOR}\t(none)\t%{SIZE}\t%{ARCH}\t%{EPOCHNUM}\t%{SOURCERPM}\n")
-
-	return
-}
-
-func initializeRpmDatabase(installRoot string, diffDiskBuild bool) (err error) {
-	if !diffDiskBuild {
-		var (
-			stdout string
-			stderr string
-		)
-
-		stdout, stderr, err = shell.Execute("rpm", "--root", installRoot, "--initdb")
-		if err != nil {
-			logger.Log.Warnf("Failed to create rpm database: %v", err)
-			logger.Log.Warn(stdout)
-			logger.Log.Warn(stderr)
-			return err
-		}
+	// Configure kernel modules before any initramfs is regenerated so it picks up the blacklist
+	err = configureKernelModules(installRoot, config.KernelModules)
+	if err != nil {
+		return
 	}
-	err = initializeTdnfConfiguration(installRoot)
-	return
-}
-
-// TdnfInstall installs a package into the current environment without calculating progress
-func TdnfInstall(packageName, installRoot string) (packagesInstalled int, err error) {
-	packagesInstalled, err = TdnfInstallWithProgress(packageName, installRoot, 0, 0, false)
-	return
-}
-
-// TdnfInstallWithProgress installs a package in the current environment while optionally reporting progress
-func TdnfInstallWithProgress(packageName, installRoot string, currentPackagesInstalled, totalPackages int, reportProgress bool) (packagesInstalled int, err error) {
-	packagesInstalled = currentPackagesInstalled
-
-	onStdout := func(args ...interface{}) {
-		const tdnfInstallPrefix = "Installing/Updating: "
-
-		// Only process lines that match tdnfInstallPrefix
-		if len(args) == 0 {
-			return
-		}
-
-		line := args[0].(string)
-		if !strings.HasPrefix(line, tdnfInstallPrefix) {
-			return
-		}
-
-		status := fmt.Sprintf("Installing: %s", strings.TrimPrefix(line, tdnfInstallPrefix))
-		if reportProgress {
-			ReportAction(status)
-		} else {
-			// ReportAction() logs at debug level
-			logger.Log.Debug(status)
-		}
-
-		packagesInstalled++
 
-		if reportProgress {
-			// Calculate and report what percentage of packages have been installed
-			percentOfPackagesInstalled := float32(packagesInstalled) / float32(totalPackages)
-			progress := int(percentOfPackagesInstalled * 100)
-			ReportPercentComplete(progress)
-		}
+	err = configureTimezone(installRoot, config.Timezone)
+	if err != nil {
+		return
 	}
 
-	err = shell.ExecuteLiveWithCallback(onStdout, logger.Log.Warn, true, "tdnf", "-v", "install", packageName, "--installroot", installRoot, "--nogpgcheck", "--assumeyes")
+	err = configureNtpServers(installChroot, config.NtpServers)
 	if err != nil {
-		logger.Log.Warnf("Failed to tdnf install: %v. Package name: %v", err, packageName)
+		return
 	}
 
-	return
-}
-
-// initializeTdnfConfiguration installs the 'mariner-release' package
-// into the clean RPM root. The package is used by tdnf to properly set
-// the default values for its variables and internal configuration.
-func initializeTdnfConfiguration(installRoot string) (err error) {
-	const (
-		squashErrors   = false
-		releasePackage = "mariner-release"
-	)
-
-	logger.Log.Debugf("Downloading '%s' package to a clean RPM root under '%s'.", releasePackage, installRoot)
+	err = configureNetworkConnections(installChroot, config.NetworkConnections)
+	if err != nil {
+		return
+	}
 
-	err = shell.ExecuteLive(squashErrors, "tdnf", "download", "--alldeps", "--destdir", installRoot, releasePackage)
+	err = configureResolved(installChroot, config.Resolved)
 	if err != nil {
-		logger.Log.Errorf("Failed to prepare the RPM database on downloading the 'mariner-release' package: %v", err)
 		return
 	}
 
-	rpmSearch := filepath.Join(installRoot, "*.rpm")
-	rpmFiles, err := filepath.Glob(rpmSearch)
+	err = configureGrowFsOnBoot(installChroot, config.GetGrowFsOnBootMountPoints())
 	if err != nil {
-		logger.Log.Errorf("Failed to prepare the RPM database while searching for RPM files: %v", err)
+		return
+	}
+
+	err = configureLocale(installChroot, config.Locale)
+	if err != nil {
+		return
+	}
+
+	err = configureKeymap(installRoot, config.Keymap)
+	if err != nil {
+		return
+	}
+
+	err = configureEarlyConsole(installChroot, config.EarlyConsole)
+	if err != nil {
+		return
+	}
+
+	err = configureOsRelease(installRoot, config.OsRelease)
+	if err != nil {
+		return
+	}
+
+	err = configureDefaultTarget(installRoot, config.GetDefaultTargetUnit())
+	if err != nil {
+		return
+	}
+
+	// Select the default kernel before any initramfs is regenerated, every installed kernel gets one
+	err = configureDefaultKernel(installChroot, config.DefaultKernel)
+	if err != nil {
+		return
+	}
+
+	err = configureSysctl(installRoot, config.Sysctl)
+	if err != nil {
+		return
+	}
+
+	err = configureJournald(installRoot, config.Journald)
+	if err != nil {
+		return
+	}
+
+	err = installLogrotateRules(installRoot, config.LogrotateRules)
+	if err != nil {
+		return
+	}
+
+	err = configureFirewall(installChroot, config.Firewall)
+	if err != nil {
+		return
+	}
+
+	err = configureCloudInit(installRoot, config.CloudInit)
+	if err != nil {
+		return
+	}
+
+	err = configurePam(installRoot, config.Pam)
+	if err != nil {
+		return
+	}
+
+	err = configureSudoers(installChroot, config.SudoersRules)
+	if err != nil {
+		return
+	}
+
+	err = installUdevRules(installRoot, config.UdevRules)
+	if err != nil {
+		return
+	}
+
+	err = installAuditRules(installChroot, config.AuditRules)
+	if err != nil {
+		return
+	}
+
+	err = installSystemdDropIns(installRoot, config.SystemdDropIns)
+	if err != nil {
+		return
+	}
+
+	err = configureReadOnlyRoot(installRoot, config.ReadOnlyRoot)
+	if err != nil {
+		return
+	}
+
+	// Once every package installed its modules, and before the firmware is installed
+	err = configureMinimalKernelModules(installChroot, config, mountPointToFsTypeMap)
+	if err != nil {
+		return
+	}
+
+	err = installFirmware(installChroot, config.Firmware)
+	if err != nil {
+		return
+	}
+
+	err = configureKdump(installChroot, config.Kdump)
+	if err != nil {
+		return
+	}
+
+	if config.RemoveRpmDb {
+		// When the RemoveRpmDb flag is true, generate a list of installed packages since they cannot be queiried at runtime
+		logger.Log.Info("Generating manifest with package information since RemoveRpmDb is enabled.")
+		generateContainerManifests(installChroot)
+	}
+
+	// Written before the post-install scripts so they can read it
+	err = writeBuildMetadata(installRoot, config, configHash)
+	if err != nil {
+		return
+	}
+
+	// Run post-install scripts from within the installroot chroot
+	err = runPostInstallScripts(installChroot, config)
+	if err != nil {
+		return
+	}
+
+	err = linkResolvConf(installRoot, config.Resolved.ResolvConf)
+	if err != nil {
+		return
+	}
+
+	err = configureReadOnlyEtc(installRoot, config.ReadOnlyEtc)
+	if err != nil {
+		return
+	}
+
+	// Regenerated once, after every step and post-install script which changes the dracut configuration
+	err = regenerateInitramfs(installChroot, config)
+	if err != nil {
+		return
+	}
+
+	// Edited last, so no other step regenerates the initramfs afterwards
+	err = customizeInitramfs(installChroot, config.InitramfsCustomization)
+	if err != nil {
+		return
+	}
+
+	// Describe the final set of packages, the RPM database is only removed once this function returns
+	err = GenerateSbom(installRoot, config.Name, SbomTempPath, config.SbomFormat)
+	if err != nil {
+		return
+	}
+
+	err = changes.recordPackages()
+	if err != nil {
+		return
+	}
+
+	if config.MinimizeImage {
+		err = minimizeInstallRoot(installRoot)
+	}
+	return
+}
+
+// minimizeInstallRoot removes package caches, logs and temporary files left behind by the install.
+// Log files are truncated rather than deleted so services which expect them keep working, except for
+// the binary journal files, which journald considers corrupted once truncated.
+func minimizeInstallRoot(installRoot string) (err error) {
+	var (
+		// Directories whose contents are removed entirely
+		clearedDirs = []string{"var/cache/tdnf", "var/cache/dnf", "var/cache/yum", "var/cache/ldconfig", "tmp", "var/tmp"}
+		// Directories whose regular files are removed, keeping the directories journald writes into
+		removedFileDirs = []string{"var/log/journal"}
+		// Directories whose regular files are truncated
+		truncatedDirs = []string{"var/log"}
+	)
+
+	ReportAction("Minimizing image")
+
+	for _, dir := range clearedDirs {
+		fullDir := filepath.Join(installRoot, dir)
+		entries, readErr := os.ReadDir(fullDir)
+		if os.IsNotExist(readErr) {
+			continue
+		} else if readErr != nil {
+			return readErr
+		}
+
+		for _, entry := range entries {
+			logger.Log.Debugf("Removing (%s)", filepath.Join("/", dir, entry.Name()))
+			err = os.RemoveAll(filepath.Join(fullDir, entry.Name()))
+			if err != nil {
+				return
+			}
+		}
+		logger.Log.Infof("Cleared (/%s)", dir)
+	}
+
+	for _, dir := range removedFileDirs {
+		err = filepath.Walk(filepath.Join(installRoot, dir), func(path string, info os.FileInfo, walkErr error) error {
+			if walkErr != nil {
+				return walkErr
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			logger.Log.Debugf("Removing (%s)", strings.TrimPrefix(path, installRoot))
+			return os.Remove(path)
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return
+		}
+		err = nil
+		logger.Log.Infof("Removed files under (/%s)", dir)
+	}
+
+	for _, dir := range truncatedDirs {
+		err = filepath.Walk(filepath.Join(installRoot, dir), func(path string, info os.FileInfo, walkErr error) error {
+			if walkErr != nil {
+				return walkErr
+			}
+			if !info.Mode().IsRegular() || info.Size() == 0 {
+				return nil
+			}
+			logger.Log.Debugf("Truncating (%s)", strings.TrimPrefix(path, installRoot))
+			return os.Truncate(path, 0)
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return
+		}
+		err = nil
+		logger.Log.Infof("Truncated log files under (/%s)", dir)
+	}
+
+	return
+}
+
+func generateContainerManifests(installChroot *safechroot.Chroot) {
+	installRoot := filepath.Join(rootMountPoint, installChroot.RootDir())
+	rpmDir := filepath.Join(installRoot, rpmDependenciesDirectory)
+	rpmManifestDir := filepath.Join(installRoot, rpmManifestDirectory)
+	manifest1Path := filepath.Join(rpmManifestDir, "container-manifest-1")
+	manifest2Path := filepath.Join(rpmManifestDir, "container-manifest-2")
+
+	os.MkdirAll(rpmManifestDir, os.ModePerm)
+
+	shell.ExecuteAndLogToFile(manifest1Path, "rpm", "--dbpath", rpmDir, "-qa")
+	shell.ExecuteAndLogToFile(manifest2Path, "rpm", "--dbpath", rpmDir, "-qa", "--qf", "%{NAME}\t%{VERSION}-%{RELEASE}\t%{INSTALLTIME}\t%{BUILDTIME}\t%{VENDOR}\t(none)\t%{SIZE}\t%{ARCH}\t%{EPOCHNUM}\t%{SOURCERPM}\n")
+
+	return
+}
+
+// installPackages installs the image's packages into the install root, starting with the filesystem package.
+// - writeHostname is true if /etc/hostname is written once the filesystem package is installed
+func installPackages(installChroot *safechroot.Chroot, packagesToInstall []string, config configuration.SystemConfig, writeHostname bool) (err error) {
+	const (
+		filesystemPkg = "filesystem"
+	)
+
+	installRoot := filepath.Join(rootMountPoint, installChroot.RootDir())
+	tdnf := NewTdnfSettings(config)
+
+	// Copy the caller's list before appending so its backing array is never written to
+	allPackages := append(append([]string{}, packagesToInstall...), config.PackageInstallGroupPackages()...)
+
+	// Calculate how many packages need to be installed so an accurate percent complete can be reported
+	totalPackages, err := calculateTotalPackages(append(append([]string{}, allPackages...), config.LocalPackages...), installRoot, tdnf)
+	if err != nil {
+		return
+	}
+
+	// A base rootfs may bring packages installed without the install options
+	var preinstalled map[string]bool
+	if !config.InstallOptions.IsEmpty() {
+		preinstalled, err = installedPackageNames(installRoot)
+		if err != nil {
+			return
+		}
+	}
+
+	// Keep a running total of how many packages have been installed through all the `TdnfInstallWithProgress` invocations
+	packagesInstalled := 0
+
+	// Install filesystem package first
+	packagesInstalled, err = TdnfInstallWithProgress(filesystemPkg, installRoot, tdnf, packagesInstalled, totalPackages, true)
+	if err != nil {
+		return
+	}
+
+	if writeHostname {
+		// Add /etc/hostname
+		err = updateHostname(installChroot.RootDir(), config.Hostname)
+		if err != nil {
+			return
+		}
+	}
+
+	// Install packages one-by-one to avoid exhausting memory
+	// on low resource systems
+	for _, pkg := range packagesToInstall {
+		packagesInstalled, err = TdnfInstallWithProgress(pkg, installRoot, tdnf, packagesInstalled, totalPackages, true)
+		if err != nil {
+			return
+		}
+	}
+
+	// Install each ordered group as its own transaction, so a group's scriptlets
+	// run before any of the later groups' packages are present
+	for i, group := range config.PackageInstallGroups {
+		logger.Log.Infof("Installing package group %d: %v", i, group)
+		packagesInstalled, err = TdnfInstallGroupWithProgress(group, installRoot, tdnf, packagesInstalled, totalPackages, true)
+		if err != nil {
+			return
+		}
+	}
+
+	packagesInstalled, err = installLocalPackages(installRoot, config.LocalPackages, tdnf, packagesInstalled, totalPackages)
+	if err != nil {
+		return
+	}
+
+	if config.UpdateExistingPackages {
+		err = updateInstalledPackages(installRoot, config.UpdateRepo, tdnf)
+		if err != nil {
+			return
+		}
+	}
+
+	if config.StrictPackageVersions {
+		err = verifyPackagePins(installChroot, allPackages)
+		if err != nil {
+			return
+		}
+	}
+
+	err = verifyInstallOptions(installRoot, config.InstallOptions, allPackages, preinstalled)
+	return
+}
+
+// extractBaseRootfs extracts a rootfs tarball into the install root, preserving ownership, permissions and extended attributes.
+func extractBaseRootfs(installRoot, tarballPath string) (err error) {
+	const squashErrors = false
+
+	ReportAction("Extracting base rootfs")
+	logger.Log.Infof("Extracting base rootfs (%s) into (%s)", tarballPath, installRoot)
+
+	err = shell.ExecuteLive(squashErrors, "tar", "--xattrs", "--xattrs-include=*", "--numeric-owner", "-xpf", tarballPath, "-C", installRoot)
+	if err != nil {
+		err = fmt.Errorf("failed to extract base rootfs (%s): %w", tarballPath, err)
+	}
+	return
+}
+
+// copyBaseRootfsDir copies an already extracted rootfs, such as the layers of a container image,
+// into the install root, preserving ownership, permissions and extended attributes. The files are
+// cloned copy-on-write when the install root is on the same file system and it supports reflinks.
+func copyBaseRootfsDir(installRoot, rootfsDir string) (err error) {
+	const squashErrors = false
+
+	ReportAction("Copying base rootfs")
+	logger.Log.Infof("Copying base rootfs (%s) into (%s)", rootfsDir, installRoot)
+
+	err = shell.ExecuteLive(squashErrors, "cp", "-a", "--reflink=auto", "--no-target-directory", rootfsDir, installRoot)
+	if err != nil {
+		err = fmt.Errorf("failed to copy base rootfs (%s): %w", rootfsDir, err)
+	}
+	return
+}
+
+func initializeRpmDatabase(installRoot string, diffDiskBuild bool, tdnf TdnfSettings) (err error) {
+	if !diffDiskBuild {
+		var (
+			stdout string
+			stderr string
+		)
+
+		stdout, stderr, err = shell.Execute("rpm", "--root", installRoot, "--initdb")
+		if err != nil {
+			logger.Log.Warnf("Failed to create rpm database: %v", err)
+			logger.Log.Warn(stdout)
+			logger.Log.Warn(stderr)
+			return err
+		}
+	}
+	err = initializeTdnfConfiguration(installRoot, tdnf)
+	return
+}
+
+// TdnfSettings are the tdnf settings used by every tdnf invocation while building the image. They are
+// passed on the command line, so they are not written into any tdnf configuration file and do not
+// persist into the final image.
+type TdnfSettings struct {
+	// Options are tdnf configuration options, such as download timeouts
+	Options map[string]string
+	// RequireGpgCheck checks package signatures instead of passing --nogpgcheck, so the repositories'
+	// gpgcheck settings take effect
+	RequireGpgCheck bool
+}
+
+// NewTdnfSettings returns the build time tdnf settings of a system config
+func NewTdnfSettings(config configuration.SystemConfig) TdnfSettings {
+	return TdnfSettings{
+		Options:         config.GetTdnfOptions(),
+		RequireGpgCheck: config.RequireRepoGpgCheck,
+	}
+}
+
+// optionArgs returns the "--setopt" arguments for the tdnf options
+func (t TdnfSettings) optionArgs() (args []string) {
+	names := make([]string, 0, len(t.Options))
+	for name := range t.Options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		args = append(args, fmt.Sprintf("--setopt=%s=%s", name, t.Options[name]))
+	}
+	return
+}
+
+// TdnfInstall installs a package into the current environment without calculating progress
+func TdnfInstall(packageName, installRoot string, tdnf TdnfSettings) (packagesInstalled int, err error) {
+	packagesInstalled, err = TdnfInstallWithProgress(packageName, installRoot, tdnf, 0, 0, false)
+	return
+}
+
+// TdnfInstallWithProgress installs a package in the current environment while optionally reporting progress
+func TdnfInstallWithProgress(packageName, installRoot string, tdnf TdnfSettings, currentPackagesInstalled, totalPackages int, reportProgress bool) (packagesInstalled int, err error) {
+	return TdnfInstallGroupWithProgress([]string{packageName}, installRoot, tdnf, currentPackagesInstalled, totalPackages, reportProgress)
+}
+
+// TdnfInstallGroupWithProgress installs several packages in a single transaction in the current environment while optionally reporting progress
+func TdnfInstallGroupWithProgress(packageNames []string, installRoot string, tdnf TdnfSettings, currentPackagesInstalled, totalPackages int, reportProgress bool) (packagesInstalled int, err error) {
+	packagesInstalled = currentPackagesInstalled
+
+	onStdout := func(args ...interface{}) {
+		const tdnfInstallPrefix = "Installing/Updating: "
+
+		// Only process lines that match tdnfInstallPrefix
+		if len(args) == 0 {
+			return
+		}
+
+		line := args[0].(string)
+		if !strings.HasPrefix(line, tdnfInstallPrefix) {
+			return
+		}
+
+		status := fmt.Sprintf("Installing: %s", strings.TrimPrefix(line, tdnfInstallPrefix))
+		if reportProgress {
+			ReportAction(status)
+		} else {
+			// ReportAction() logs at debug level
+			logger.Log.Debug(status)
+		}
+
+		packagesInstalled++
+
+		if reportProgress {
+			// Calculate and report what percentage of packages have been installed
+			percentOfPackagesInstalled := float32(packagesInstalled) / float32(totalPackages)
+			progress := int(percentOfPackagesInstalled * 100)
+			ReportPercentComplete(progress)
+		}
+	}
+
+	tdnfArgs := append(tdnf.optionArgs(), "-v", "install")
+	tdnfArgs = append(tdnfArgs, packageNames...)
+	tdnfArgs = append(tdnfArgs, "--installroot", installRoot, "--assumeyes")
+	tdnfArgs = append(tdnfArgs, tdnf.gpgCheckArgs()...)
+	err = shell.ExecuteLiveWithCallback(onStdout, logger.Log.Warn, true, "tdnf", tdnfArgs...)
+	if err != nil {
+		logger.Log.Warnf("Failed to tdnf install: %v. Package names: %v", err, packageNames)
+	}
+
+	return
+}
+
+// updateInstalledPackages updates every installed package in a single transaction. If updateRepo
+// is set, only that repository is enabled so updates from the other repositories are not applied.
+func updateInstalledPackages(installRoot, updateRepo string, tdnf TdnfSettings) (err error) {
+	const squashErrors = false
+
+	tdnfArgs := append(tdnf.optionArgs(), "-v", "update", "--installroot", installRoot, "--assumeyes")
+	tdnfArgs = append(tdnfArgs, tdnf.gpgCheckArgs()...)
+	if updateRepo != "" {
+		ReportActionf("Updating installed packages from repository: %s", updateRepo)
+		tdnfArgs = append(tdnfArgs, "--disablerepo=*", fmt.Sprintf("--enablerepo=%s", updateRepo))
+	} else {
+		ReportAction("Updating installed packages")
+	}
+
+	err = shell.ExecuteLive(squashErrors, "tdnf", tdnfArgs...)
+	if err != nil {
+		err = fmt.Errorf("failed to update installed packages: %w", err)
+	}
+	return
+}
+
+// RepoIDsInDirectory returns the IDs of all repositories defined by the .repo files in a directory.
+func RepoIDsInDirectory(repoDir string) (repoIDs []string, err error) {
+	repoFiles, err := filepath.Glob(filepath.Join(repoDir, "*.repo"))
+	if err != nil {
+		return
+	}
+
+	for _, repoFile := range repoFiles {
+		var lines []string
+		lines, err = file.ReadLines(repoFile)
+		if err != nil {
+			return
+		}
+
+		for _, line := range lines {
+			line = strings.TrimSpace(line)
+			if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+				repoIDs = append(repoIDs, strings.TrimSpace(line[1:len(line)-1]))
+			}
+		}
+	}
+	return
+}
+
+// initializeTdnfConfiguration installs the 'mariner-release' package
+// into the clean RPM root. The package is used by tdnf to properly set
+// the default values for its variables and internal configuration.
+func initializeTdnfConfiguration(installRoot string, tdnf TdnfSettings) (err error) {
+	const (
+		squashErrors   = false
+		releasePackage = "mariner-release"
+	)
+
+	logger.Log.Debugf("Downloading '%s' package to a clean RPM root under '%s'.", releasePackage, installRoot)
+
+	tdnfArgs := append(tdnf.optionArgs(), "download", "--alldeps", "--destdir", installRoot, releasePackage)
+	err = shell.ExecuteLive(squashErrors, "tdnf", tdnfArgs...)
+	if err != nil {
+		logger.Log.Errorf("Failed to prepare the RPM database on downloading the 'mariner-release' package: %v", err)
+		return
+	}
+
+	rpmSearch := filepath.Join(installRoot, "*.rpm")
+	rpmFiles, err := filepath.Glob(rpmSearch)
+	if err != nil {
+		logger.Log.Errorf("Failed to prepare the RPM database while searching for RPM files: %v", err)
 		return
 	}
 
@@ -615,187 +1308,1455 @@ func initializeTdnfConfiguration(installRoot string) (err error) {
 		for _, file := range rpmFiles {
 			err = os.Remove(file)
 			if err != nil {
-				logger.Log.Errorf("Failed to prepare the RPM database on removing leftover file (%s): %v", file, err)
+				logger.Log.Errorf("Failed to prepare the RPM database on removing leftover file (%s): %v", file, err)
+				return
+			}
+		}
+	}()
+
+	logger.Log.Debugf("Installing 'mariner-release' package to a clean RPM root under '%s'.", installRoot)
+
+	rpmArgs := []string{"-i", "--root", installRoot}
+	rpmArgs = append(rpmArgs, rpmFiles...)
+	err = shell.ExecuteLive(squashErrors, "rpm", rpmArgs...)
+	if err != nil {
+		logger.Log.Errorf("Failed to prepare the RPM database on installing the 'mariner-release' package: %v", err)
+		return
+	}
+
+	return
+}
+
+func configureSystemFiles(installChroot *safechroot.Chroot, hostname string, installMap, mountPointToFsTypeMap, mountPointToMountArgsMap map[string]string, encryptedRoot diskutils.EncryptedRootDevice, partitionSettings []configuration.PartitionSetting, hidepidEnabled bool) (err error) {
+	// Update hosts file
+	err = updateHosts(installChroot.RootDir(), hostname)
+	if err != nil {
+		return
+	}
+
+	// Update fstab
+	err = updateFstab(installChroot.RootDir(), installMap, mountPointToFsTypeMap, mountPointToMountArgsMap, hidepidEnabled)
+	if err != nil {
+		return
+	}
+
+	// Update crypttab
+	err = updateCrypttab(installChroot.RootDir(), installMap, encryptedRoot, partitionSettings)
+	if err != nil {
+		return
+	}
+
+	// Update integritytab
+	err = updateIntegritytab(installChroot.RootDir(), installMap, partitionSettings)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func calculateTotalPackages(packages []string, installRoot string, tdnf TdnfSettings) (totalPackages int, err error) {
+	allPackageNames := make(map[string]bool)
+	const tdnfAssumeNoStdErr = "Error(1032) : Operation aborted.\n"
+
+	// For every package calculate what dependencies would also be installed from it.
+	for _, pkg := range packages {
+		var (
+			stdout string
+			stderr string
+		)
+
+		// Issue an install request but stop right before actually performing the install (assumeno)
+		tdnfArgs := append(tdnf.optionArgs(), "install", "--assumeno", pkg, "--installroot", installRoot)
+		tdnfArgs = append(tdnfArgs, tdnf.gpgCheckArgs()...)
+		stdout, stderr, err = shell.Execute("tdnf", tdnfArgs...)
+		if err != nil {
+			// tdnf aborts the process when it detects an install with --assumeno.
+			if stderr == tdnfAssumeNoStdErr {
+				err = nil
+			} else {
+				logger.Log.Error(stderr)
+				return
+			}
+		}
+
+		splitStdout := strings.Split(stdout, "\n")
+
+		// Search for the list of packages to be installed,
+		// it will be prefixed with a line "Installing:" and will
+		// end with an empty line.
+		inPackageList := false
+		for _, line := range splitStdout {
+			const (
+				packageListPrefix    = "Installing:"
+				packageNameDelimiter = " "
+			)
+
+			const (
+				packageNameIndex      = iota
+				extraInformationIndex = iota
+				totalPackageNameParts = iota
+			)
+
+			if !inPackageList {
+				inPackageList = strings.HasPrefix(line, packageListPrefix)
+				continue
+			} else if strings.TrimSpace(line) == "" {
+				break
+			}
+
+			// Each package to be installed will list its name, followed by a space and then various extra information
+			pkgSplit := strings.SplitN(line, packageNameDelimiter, totalPackageNameParts)
+			if len(pkgSplit) != totalPackageNameParts {
+				err = fmt.Errorf("unexpected TDNF package name output: %s", line)
+				return
+			}
+
+			allPackageNames[pkgSplit[packageNameIndex]] = true
+		}
+	}
+
+	totalPackages = len(allPackageNames)
+	logger.Log.Debugf("All packages to be installed (%d): %v", totalPackages, allPackageNames)
+	return
+}
+
+// addMachineID creates the /etc/machine-id file in the installChroot
+func addMachineID(installChroot *safechroot.Chroot) (err error) {
+	// From https://www.freedesktop.org/software/systemd/man/machine-id.html:
+	// For operating system images which are created once and used on multiple
+	// machines, for example for containers or in the cloud, /etc/machine-id
+	// should be an empty file in the generic file system image. An ID will be
+	// generated during boot and saved to this file if possible.
+
+	const (
+		machineIDFile      = "/etc/machine-id"
+		machineIDFilePerms = 0444
+	)
+
+	ReportAction("Configuring machine id")
+
+	err = installChroot.UnsafeRun(func() error {
+		return file.Create(machineIDFile, machineIDFilePerms)
+	})
+	return
+}
+
+// configureKernelModules writes the modules-load.d and modprobe.d files requested by the kernel module configuration
+func configureKernelModules(installRoot string, kernelModules configuration.KernelModules) (err error) {
+	const (
+		modulesLoadFile     = "etc/modules-load.d/imager.conf"
+		modprobeFile        = "etc/modprobe.d/imager-blacklist.conf"
+		configDirectoryMode = 0755
+	)
+
+	if len(kernelModules.Load) == 0 && len(kernelModules.Blacklist) == 0 {
+		return
+	}
+
+	ReportAction("Configuring kernel modules")
+
+	for _, module := range append(append([]string{}, kernelModules.Load...), kernelModules.Blacklist...) {
+		warnIfKernelModuleMissing(installRoot, module)
+	}
+
+	if len(kernelModules.Load) != 0 {
+		modulesLoadPath := filepath.Join(installRoot, modulesLoadFile)
+		err = os.MkdirAll(filepath.Dir(modulesLoadPath), configDirectoryMode)
+		if err != nil {
+			return
+		}
+
+		err = file.Write(strings.Join(kernelModules.Load, "\n")+"\n", modulesLoadPath)
+		if err != nil {
+			logger.Log.Warnf("Failed to write kernel modules to load")
+			return
+		}
+	}
+
+	if len(kernelModules.Blacklist) != 0 {
+		modprobePath := filepath.Join(installRoot, modprobeFile)
+		err = os.MkdirAll(filepath.Dir(modprobePath), configDirectoryMode)
+		if err != nil {
+			return
+		}
+
+		var blacklist strings.Builder
+		for _, module := range kernelModules.Blacklist {
+			// "blacklist" only stops aliases from loading the module, "install" also stops explicit loads
+			fmt.Fprintf(&blacklist, "blacklist %s\ninstall %s /bin/false\n", module, module)
+		}
+
+		err = file.Write(blacklist.String(), modprobePath)
+		if err != nil {
+			logger.Log.Warnf("Failed to write kernel module blacklist")
+			return
+		}
+	}
+
+	return
+}
+
+// configureTimezone points /etc/localtime at the requested zoneinfo file and records the zone in /etc/timezone.
+func configureTimezone(installRoot, timezone string) (err error) {
+	const (
+		zoneinfoDir   = "/usr/share/zoneinfo"
+		localtimeFile = "etc/localtime"
+		timezoneFile  = "etc/timezone"
+	)
+
+	if timezone == "" {
+		return
+	}
+
+	ReportActionf("Setting timezone to %s", timezone)
+
+	if exists, _ := file.DirExists(filepath.Join(installRoot, zoneinfoDir)); !exists {
+		return fmt.Errorf("cannot set timezone (%s): %s is missing from the image, add the 'tzdata' package to the package lists", timezone, zoneinfoDir)
+	}
+
+	zonePath := filepath.Join(zoneinfoDir, timezone)
+	if exists, _ := file.PathExists(filepath.Join(installRoot, zonePath)); !exists {
+		return fmt.Errorf("cannot set timezone (%s): no such zone under %s", timezone, zoneinfoDir)
+	}
+
+	err = replaceWithSymlink(installRoot, localtimeFile, zonePath)
+	if err != nil {
+		return
+	}
+
+	err = file.Write(timezone+"\n", filepath.Join(installRoot, timezoneFile))
+	return
+}
+
+// configureNtpServers points the image's time sync service at the given NTP servers and enables it.
+// chrony is used when it is installed, otherwise systemd-timesyncd.
+func configureNtpServers(installChroot *safechroot.Chroot, servers []string) (err error) {
+	const (
+		chronyConfFile    = "etc/chrony.conf"
+		chronyService     = "chronyd.service"
+		timesyncdConfFile = "etc/systemd/timesyncd.conf.d/90-imager.conf"
+		timesyncdService  = "systemd-timesyncd.service"
+	)
+
+	if len(servers) == 0 {
+		return
+	}
+
+	ReportAction("Configuring NTP servers")
+
+	installRoot := installChroot.RootDir()
+	chronyConfPath := filepath.Join(installRoot, chronyConfFile)
+	timesyncdUnitPath := filepath.Join(installRoot, "usr/lib/systemd/system", timesyncdService)
+
+	var service string
+	if exists, _ := file.PathExists(chronyConfPath); exists {
+		var chronyConf []byte
+		chronyConf, err = os.ReadFile(chronyConfPath)
+		if err != nil {
+			return
+		}
+
+		err = file.Write(renderChronyConf(string(chronyConf), servers), chronyConfPath)
+		if err != nil {
+			return
+		}
+		service = chronyService
+	} else if exists, _ := file.PathExists(timesyncdUnitPath); exists {
+		timesyncdConfPath := filepath.Join(installRoot, timesyncdConfFile)
+		err = os.MkdirAll(filepath.Dir(timesyncdConfPath), os.ModePerm)
+		if err != nil {
+			return
+		}
+
+		err = file.Write(renderTimesyncdConf(servers), timesyncdConfPath)
+		if err != nil {
+			return
+		}
+		service = timesyncdService
+	} else {
+		return fmt.Errorf("cannot configure NTP servers: neither chrony nor systemd-timesyncd is installed, add the 'chrony' package to the package lists")
+	}
+
+	return enableService(installChroot, service)
+}
+
+// enableService enables a systemd unit inside the install chroot.
+func enableService(installChroot *safechroot.Chroot, service string) (err error) {
+	const squashErrors = false
+
+	logger.Log.Infof("Enabling %s", service)
+	return installChroot.UnsafeRun(func() error {
+		return shell.ExecuteLive(squashErrors, "systemctl", "enable", service)
+	})
+}
+
+// renderChronyConf comments out the server and pool directives of an existing chrony.conf
+// and adds one server directive per configured server.
+func renderChronyConf(chronyConf string, servers []string) string {
+	var builder strings.Builder
+	for _, line := range strings.Split(strings.TrimRight(chronyConf, "\n"), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 0 && (fields[0] == "server" || fields[0] == "pool") {
+			line = "# " + line
+		}
+		builder.WriteString(line + "\n")
+	}
+
+	builder.WriteString("\n# Generated from the image configuration's NtpServers settings\n")
+	for _, server := range servers {
+		builder.WriteString(fmt.Sprintf("server %s iburst\n", server))
+	}
+	return builder.String()
+}
+
+func renderTimesyncdConf(servers []string) string {
+	var builder strings.Builder
+	builder.WriteString("# Generated from the image configuration's NtpServers settings\n")
+	builder.WriteString("[Time]\n")
+	builder.WriteString(fmt.Sprintf("NTP=%s\n", strings.Join(servers, " ")))
+	return builder.String()
+}
+
+// configureDefaultTarget points /etc/systemd/system/default.target at the requested target unit.
+func configureDefaultTarget(installRoot, target string) (err error) {
+	const defaultTargetFile = "etc/systemd/system/default.target"
+
+	if target == "" {
+		return
+	}
+
+	ReportActionf("Setting default target to %s", target)
+
+	unitDirs := []string{
+		"/etc/systemd/system",
+		"/usr/lib/systemd/system",
+		"/lib/systemd/system",
+	}
+
+	unitPath := ""
+	for _, unitDir := range unitDirs {
+		candidate := filepath.Join(unitDir, target)
+		if exists, _ := file.PathExists(filepath.Join(installRoot, candidate)); exists {
+			unitPath = candidate
+			break
+		}
+	}
+	if unitPath == "" {
+		return fmt.Errorf("cannot set default target (%s): no such unit under %s", target, strings.Join(unitDirs, ", "))
+	}
+
+	return replaceWithSymlink(installRoot, defaultTargetFile, unitPath)
+}
+
+// replaceWithSymlink replaces a file of the image with a symlink to the target, a path inside the image.
+// The link target is absolute so the link is valid once the image boots.
+// - linkFile is the path of the link, relative to the install root
+func replaceWithSymlink(installRoot, linkFile, target string) (err error) {
+	linkPath := filepath.Join(installRoot, linkFile)
+	err = os.MkdirAll(filepath.Dir(linkPath), os.ModePerm)
+	if err != nil {
+		return
+	}
+
+	err = os.RemoveAll(linkPath)
+	if err != nil {
+		return
+	}
+
+	err = os.Symlink(target, linkPath)
+	if err != nil {
+		logger.Log.Warnf("Failed to link %s to %s", linkFile, target)
+	}
+	return
+}
+
+// configureDefaultKernel points /boot/mariner.cfg, which grub loads the kernel and initramfs names from,
+// at the requested kernel. Each kernel package links it to itself when installed, so without a default
+// kernel the image boots whichever kernel was installed last.
+// - defaultKernel is either a kernel version, such as 5.15.80.1-1.cm2, or the name of an installed kernel package
+func configureDefaultKernel(installChroot *safechroot.Chroot, defaultKernel string) (err error) {
+	const (
+		bootDir         = "/boot"
+		marinerCfgFile  = "mariner.cfg"
+		kernelCfgPrefix = "linux-"
+		kernelCfgSuffix = ".cfg"
+	)
+
+	installBootDir := filepath.Join(installChroot.RootDir(), bootDir)
+	kernelCfgPaths, err := filepath.Glob(filepath.Join(installBootDir, kernelCfgPrefix+"*"+kernelCfgSuffix))
+	if err != nil {
+		return
+	}
+
+	var installedVersions []string
+	for _, kernelCfgPath := range kernelCfgPaths {
+		kernelCfg := filepath.Base(kernelCfgPath)
+		installedVersions = append(installedVersions, strings.TrimSuffix(strings.TrimPrefix(kernelCfg, kernelCfgPrefix), kernelCfgSuffix))
+	}
+
+	if defaultKernel == "" {
+		if len(installedVersions) > 1 {
+			logger.Log.Warnf("Multiple kernels are installed (%v) and no [DefaultKernel] is set, the last one installed boots by default", installedVersions)
+		}
+		return
+	}
+
+	ReportActionf("Setting default kernel to %s", defaultKernel)
+
+	version := ""
+	for _, installedVersion := range installedVersions {
+		if installedVersion == defaultKernel {
+			version = installedVersion
+		}
+	}
+
+	// Otherwise look for the kernel config installed by a package of that name
+	if version == "" {
+		var packageFiles string
+		queryErr := installChroot.UnsafeRun(func() (err error) {
+			packageFiles, _, err = shell.Execute("rpm", "-ql", defaultKernel)
+			return
+		})
+		if queryErr == nil {
+			version = kernelVersionFromPackageFiles(packageFiles)
+		}
+	}
+
+	if version == "" {
+		return fmt.Errorf("default kernel (%s) is not an installed kernel version or kernel package, installed kernel versions are: %v", defaultKernel, installedVersions)
+	}
+
+	marinerCfgPath := filepath.Join(installBootDir, marinerCfgFile)
+	err = os.RemoveAll(marinerCfgPath)
+	if err != nil {
+		return
+	}
+
+	// Use a relative link, as the kernel packages do, so it also works from a separate boot partition
+	err = os.Symlink(kernelCfgPrefix+version+kernelCfgSuffix, marinerCfgPath)
+	return
+}
+
+// kernelVersionFromPackageFiles returns the kernel version of the /boot/linux-<version>.cfg file in a
+// package's file list, or an empty string if the package has no kernel config.
+func kernelVersionFromPackageFiles(packageFiles string) string {
+	const (
+		kernelCfgPrefix = "/boot/linux-"
+		kernelCfgSuffix = ".cfg"
+	)
+
+	for _, packageFile := range strings.Split(packageFiles, "\n") {
+		packageFile = strings.TrimSpace(packageFile)
+		if strings.HasPrefix(packageFile, kernelCfgPrefix) && strings.HasSuffix(packageFile, kernelCfgSuffix) && !strings.Contains(packageFile[len(kernelCfgPrefix):], "/") {
+			return strings.TrimSuffix(strings.TrimPrefix(packageFile, kernelCfgPrefix), kernelCfgSuffix)
+		}
+	}
+	return ""
+}
+
+// configureLocale writes the system locale into /etc/locale.conf, compiling it with localedef
+// first if it is not one of the image's prebuilt locales.
+func configureLocale(installChroot *safechroot.Chroot, locale string) (err error) {
+	const (
+		localeConfFile = "etc/locale.conf"
+		i18nDir        = "usr/share/i18n"
+	)
+
+	if locale == "" {
+		return
+	}
+
+	ReportActionf("Setting locale to %s", locale)
+	installRoot := installChroot.RootDir()
+
+	var availableLocales string
+	err = installChroot.UnsafeRun(func() (err error) {
+		availableLocales, _, err = shell.Execute("locale", "-a")
+		return
+	})
+	if err != nil {
+		return fmt.Errorf("cannot set locale (%s): failed to list the image's locales: %w", locale, err)
+	}
+
+	if !localeIsAvailable(availableLocales, locale) {
+		inputName, charmap := localedefArgs(locale)
+		if exists, _ := file.PathExists(filepath.Join(installRoot, i18nDir, "locales", strings.Split(inputName, "@")[0])); !exists {
+			return fmt.Errorf("cannot set locale (%s): no locale definition found under /%s, add the 'glibc-i18n' package to the package lists", locale, i18nDir)
+		}
+
+		args := []string{"-i", inputName}
+		if charmap != "" {
+			args = append(args, "-f", charmap)
+		}
+		args = append(args, locale)
+
+		logger.Log.Infof("Generating locale (%s)", locale)
+		err = installChroot.UnsafeRun(func() error {
+			_, stderr, err := shell.Execute("localedef", args...)
+			if err != nil {
+				logger.Log.Warnf("Failed to generate locale: %v", stderr)
+			}
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("cannot set locale (%s): %w", locale, err)
+		}
+	}
+
+	err = file.Write(fmt.Sprintf("LANG=%s\n", locale), filepath.Join(installRoot, localeConfFile))
+	return
+}
+
+// localeIsAvailable checks a "locale -a" listing for the requested locale. The listing uses
+// glibc's normalized charset names, e.g. "en_US.utf8" for "en_US.UTF-8".
+func localeIsAvailable(availableLocales, locale string) bool {
+	normalizedLocale := normalizeLocale(locale)
+	for _, available := range strings.Split(availableLocales, "\n") {
+		if normalizeLocale(strings.TrimSpace(available)) == normalizedLocale {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeLocale(locale string) string {
+	inputName, charmap := localedefArgs(locale)
+	if charmap == "" {
+		return inputName
+	}
+
+	charmap = strings.ToLower(strings.ReplaceAll(charmap, "-", ""))
+	base, modifier := inputName, ""
+	if i := strings.Index(inputName, "@"); i >= 0 {
+		base, modifier = inputName[:i], inputName[i:]
+	}
+	return fmt.Sprintf("%s.%s%s", base, charmap, modifier)
+}
+
+// localedefArgs splits a locale name into the localedef input definition and charmap,
+// e.g. "de_DE.UTF-8@euro" is compiled from "de_DE@euro" with the "UTF-8" charmap.
+func localedefArgs(locale string) (inputName, charmap string) {
+	inputName = locale
+	modifier := ""
+	if i := strings.Index(inputName, "@"); i >= 0 {
+		inputName, modifier = inputName[:i], inputName[i:]
+	}
+	if i := strings.Index(inputName, "."); i >= 0 {
+		inputName, charmap = inputName[:i], inputName[i+1:]
+	}
+	inputName += modifier
+	return
+}
+
+// findKbdFile returns true if a kbd data file, such as a keymap or a console font, with the given name and one
+// of the suffixes is found under dir
+func findKbdFile(dir, name string, suffixes []string) (found bool, err error) {
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if info.IsDir() {
+			return nil
+		}
+		for _, suffix := range suffixes {
+			if info.Name() == name+suffix {
+				found = true
+				return filepath.SkipDir
+			}
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	return
+}
+
+// configureKeymap writes the console keymap into /etc/vconsole.conf.
+func configureKeymap(installRoot, keymap string) (err error) {
+	if keymap == "" {
+		return
+	}
+
+	ReportActionf("Setting keymap to %s", keymap)
+
+	found, err := findKbdFile(filepath.Join(installRoot, keymapsDir), keymap, keymapSuffixes)
+	if err != nil {
+		return
+	}
+	if !found {
+		return fmt.Errorf("cannot set keymap (%s): no such keymap under /%s, make sure the 'kbd' package is in the package lists", keymap, keymapsDir)
+	}
+
+	err = file.Write(fmt.Sprintf("KEYMAP=%s\n", keymap), filepath.Join(installRoot, vconsoleConfFile))
+	return
+}
+
+// configureSysctl writes the kernel parameters into a sysctl.d drop-in, which systemd-sysctl applies at boot.
+// The 99- prefix sorts the file after the ones shipped by packages, so these settings override theirs. Only
+// /etc/sysctl.conf, which systemd links as 99-sysctl.conf, sorts after it.
+func configureSysctl(installRoot string, settings map[string]string) (err error) {
+	const sysctlConfFile = "etc/sysctl.d/99-customizer.conf"
+
+	if len(settings) == 0 {
+		return
+	}
+
+	ReportAction("Configuring sysctl settings")
+
+	sysctlConfPath := filepath.Join(installRoot, sysctlConfFile)
+	err = os.MkdirAll(filepath.Dir(sysctlConfPath), os.ModePerm)
+	if err != nil {
+		return
+	}
+
+	err = file.Write(renderSysctlConf(settings), sysctlConfPath)
+	return
+}
+
+func renderSysctlConf(settings map[string]string) string {
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var builder strings.Builder
+	builder.WriteString("# Generated from the image configuration's Sysctl settings\n")
+	for _, key := range keys {
+		builder.WriteString(fmt.Sprintf("%s = %s\n", key, strings.TrimSpace(settings[key])))
+	}
+	return builder.String()
+}
+
+// configureJournald writes the journal storage settings into a journald.conf drop-in.
+func configureJournald(installRoot string, settings configuration.Journald) (err error) {
+	const journaldConfFile = "etc/systemd/journald.conf.d/90-imager.conf"
+
+	if settings.IsEmpty() {
+		return
+	}
+
+	ReportAction("Configuring journald")
+
+	journaldConfPath := filepath.Join(installRoot, journaldConfFile)
+	err = os.MkdirAll(filepath.Dir(journaldConfPath), os.ModePerm)
+	if err != nil {
+		return
+	}
+
+	err = file.Write(renderJournaldConf(settings), journaldConfPath)
+	return
+}
+
+func renderJournaldConf(settings configuration.Journald) string {
+	var builder strings.Builder
+	builder.WriteString("# Generated from the image configuration's Journald settings\n")
+	builder.WriteString("[Journal]\n")
+	if settings.Storage != "" {
+		builder.WriteString(fmt.Sprintf("Storage=%s\n", settings.Storage))
+	}
+	if settings.SystemMaxUse != "" {
+		builder.WriteString(fmt.Sprintf("SystemMaxUse=%s\n", settings.SystemMaxUse))
+	}
+	if settings.RuntimeMaxUse != "" {
+		builder.WriteString(fmt.Sprintf("RuntimeMaxUse=%s\n", settings.RuntimeMaxUse))
+	}
+	return builder.String()
+}
+
+// installLogrotateRules writes each logrotate policy into /etc/logrotate.d. logrotate must be installed in the image.
+func installLogrotateRules(installRoot string, rules []configuration.LogrotateRule) (err error) {
+	const (
+		logrotateBinary       = "usr/sbin/logrotate"
+		logrotateDir          = "etc/logrotate.d"
+		logrotateRuleFileMode = 0644
+	)
+
+	if len(rules) == 0 {
+		return
+	}
+
+	ReportAction("Installing logrotate rules")
+
+	exists, err := file.PathExists(filepath.Join(installRoot, logrotateBinary))
+	if err != nil {
+		return
+	}
+	if !exists {
+		return fmt.Errorf("[LogrotateRules] are set but logrotate is not installed in the image, add the 'logrotate' package")
+	}
+
+	logrotateDirPath := filepath.Join(installRoot, logrotateDir)
+	err = os.MkdirAll(logrotateDirPath, os.ModePerm)
+	if err != nil {
+		return
+	}
+
+	for _, rule := range rules {
+		rulePath := filepath.Join(logrotateDirPath, rule.Name)
+		logger.Log.Debugf("Installing logrotate rule (%s)", rulePath)
+		err = file.Write(renderLogrotateRule(rule), rulePath)
+		if err != nil {
+			return
+		}
+
+		// logrotate skips configuration files which others may write to
+		err = os.Chmod(rulePath, logrotateRuleFileMode)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// renderLogrotateRule writes a logrotate policy block. With a [Frequency] the size limit is set with
+// "maxsize", so logs are also rotated by time; without one "size" rotates them by size alone.
+func renderLogrotateRule(rule configuration.LogrotateRule) string {
+	var directives []string
+	if rule.Frequency != "" {
+		directives = append(directives, rule.Frequency)
+	}
+	if rule.Rotate != 0 {
+		directives = append(directives, fmt.Sprintf("rotate %d", rule.Rotate))
+	}
+	if rule.MaxSize != "" {
+		sizeDirective := "size"
+		if rule.Frequency != "" {
+			sizeDirective = "maxsize"
+		}
+		directives = append(directives, fmt.Sprintf("%s %s", sizeDirective, rule.MaxSize))
+	}
+	if rule.Compress {
+		directives = append(directives, "compress")
+	}
+	if rule.MissingOk {
+		directives = append(directives, "missingok")
+	}
+	if rule.NotIfEmpty {
+		directives = append(directives, "notifempty")
+	}
+	if rule.CopyTruncate {
+		directives = append(directives, "copytruncate")
+	}
+	directives = append(directives, rule.Options...)
+
+	var builder strings.Builder
+	builder.WriteString("# Generated from the image configuration's LogrotateRules\n")
+	builder.WriteString(strings.Join(rule.Paths, " ") + " {\n")
+	for _, directive := range directives {
+		builder.WriteString(fmt.Sprintf("    %s\n", strings.TrimSpace(directive)))
+	}
+	builder.WriteString("}\n")
+	return builder.String()
+}
+
+// configureFirewall sets up the firewall with firewalld when it is installed, otherwise with nftables.
+// firewalld is configured offline through its zones, nftables gets a complete ruleset.
+func configureFirewall(installChroot *safechroot.Chroot, settings configuration.Firewall) (err error) {
+	const (
+		squashErrors          = false
+		systemdUnitDir        = "usr/lib/systemd/system"
+		firewalldService      = "firewalld.service"
+		nftablesService       = "nftables.service"
+		rulesetFileMode       = 0600
+		defaultNftablesConfig = "/etc/sysconfig/nftables.conf"
+	)
+
+	if settings.IsEmpty() {
+		return
+	}
+
+	ReportAction("Configuring firewall")
+
+	installRoot := installChroot.RootDir()
+	if exists, _ := file.PathExists(filepath.Join(installRoot, systemdUnitDir, firewalldService)); exists {
+		if settings.Ruleset != "" {
+			return fmt.Errorf("cannot install the firewall [Ruleset]: it is an nftables ruleset, but the image uses firewalld")
+		}
+
+		var firewallArgs [][]string
+		if settings.Zone != "" {
+			firewallArgs = append(firewallArgs, []string{fmt.Sprintf("--set-default-zone=%s", settings.Zone)})
+		}
+		for _, port := range settings.AllowedPorts {
+			args := []string{fmt.Sprintf("--add-port=%s", port)}
+			if settings.Zone != "" {
+				args = append(args, fmt.Sprintf("--zone=%s", settings.Zone))
+			}
+			firewallArgs = append(firewallArgs, args)
+		}
+
+		for _, args := range firewallArgs {
+			err = installChroot.UnsafeRun(func() error {
+				return shell.ExecuteLive(squashErrors, "firewall-offline-cmd", args...)
+			})
+			if err != nil {
+				return fmt.Errorf("failed to configure firewalld (%s): %w", strings.Join(args, " "), err)
+			}
+		}
+
+		return enableService(installChroot, firewalldService)
+	}
+
+	nftablesUnitPath := filepath.Join(installRoot, systemdUnitDir, nftablesService)
+	if exists, _ := file.PathExists(nftablesUnitPath); !exists {
+		return fmt.Errorf("cannot configure the firewall: neither firewalld nor nftables is installed, add the 'nftables' or 'firewalld' package to the package lists")
+	}
+
+	if settings.Zone != "" {
+		return fmt.Errorf("cannot configure the firewall [Zone]: zones are a firewalld feature, but the image uses nftables")
+	}
+
+	nftablesUnit, err := os.ReadFile(nftablesUnitPath)
+	if err != nil {
+		return
+	}
+
+	rulesetPath := nftablesRulesetPath(string(nftablesUnit))
+	if rulesetPath == "" {
+		rulesetPath = defaultNftablesConfig
+	}
+
+	ruleset := renderNftablesRuleset(settings.AllowedPorts)
+	if settings.Ruleset != "" {
+		var rulesetContents []byte
+		rulesetContents, err = os.ReadFile(settings.Ruleset)
+		if err != nil {
+			return
+		}
+		ruleset = string(rulesetContents)
+	}
+
+	fullRulesetPath := filepath.Join(installRoot, rulesetPath)
+	logger.Log.Debugf("Writing nftables ruleset to (%s)", rulesetPath)
+	err = os.MkdirAll(filepath.Dir(fullRulesetPath), os.ModePerm)
+	if err != nil {
+		return
+	}
+
+	err = file.Write(ruleset, fullRulesetPath)
+	if err != nil {
+		return
+	}
+
+	err = os.Chmod(fullRulesetPath, rulesetFileMode)
+	if err != nil {
+		return
+	}
+
+	return enableService(installChroot, nftablesService)
+}
+
+// nftablesRulesetPath returns the ruleset file the nftables unit loads with "nft -f", or an empty string if none is found.
+func nftablesRulesetPath(nftablesUnit string) string {
+	const execStartPrefix = "ExecStart="
+
+	for _, line := range strings.Split(nftablesUnit, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, execStartPrefix) {
+			continue
+		}
+
+		fields := strings.Fields(strings.TrimPrefix(line, execStartPrefix))
+		for i := 0; i+1 < len(fields); i++ {
+			if fields[i] == "-f" && filepath.IsAbs(fields[i+1]) {
+				return fields[i+1]
+			}
+		}
+	}
+
+	return ""
+}
+
+// renderNftablesRuleset returns a ruleset dropping all incoming traffic other than replies,
+// loopback, ICMP and the allowed ports. Outgoing traffic is not filtered.
+func renderNftablesRuleset(allowedPorts []string) string {
+	const protocolSeparator = "/"
+
+	portsByProtocol := make(map[string][]string)
+	for _, port := range allowedPorts {
+		portAndProtocol := strings.SplitN(port, protocolSeparator, 2)
+		portsByProtocol[portAndProtocol[1]] = append(portsByProtocol[portAndProtocol[1]], portAndProtocol[0])
+	}
+
+	protocols := make([]string, 0, len(portsByProtocol))
+	for protocol := range portsByProtocol {
+		protocols = append(protocols, protocol)
+	}
+	sort.Strings(protocols)
+
+	var builder strings.Builder
+	builder.WriteString("#!/usr/sbin/nft -f\n")
+	builder.WriteString("# Generated from the image configuration's Firewall settings\n")
+	builder.WriteString("flush ruleset\n\n")
+	builder.WriteString("table inet filter {\n")
+	builder.WriteString("\tchain input {\n")
+	builder.WriteString("\t\ttype filter hook input priority 0; policy drop;\n")
+	builder.WriteString("\t\tct state established,related accept\n")
+	builder.WriteString("\t\tct state invalid drop\n")
+	builder.WriteString("\t\tiifname \"lo\" accept\n")
+	builder.WriteString("\t\tmeta l4proto { icmp, ipv6-icmp } accept\n")
+	for _, protocol := range protocols {
+		builder.WriteString(fmt.Sprintf("\t\t%s dport { %s } accept\n", protocol, strings.Join(portsByProtocol[protocol], ", ")))
+	}
+	builder.WriteString("\t}\n\n")
+	builder.WriteString("\tchain forward {\n")
+	builder.WriteString("\t\ttype filter hook forward priority 0; policy drop;\n")
+	builder.WriteString("\t}\n\n")
+	builder.WriteString("\tchain output {\n")
+	builder.WriteString("\t\ttype filter hook output priority 0; policy accept;\n")
+	builder.WriteString("\t}\n")
+	builder.WriteString("}\n")
+	return builder.String()
+}
+
+// configureCloudInit writes the cloud-init datasources and settings, and installs the cloud-init
+// config files, into /etc/cloud/cloud.cfg.d.
+func configureCloudInit(installRoot string, settings configuration.CloudInit) (err error) {
+	const (
+		cloudCfgFile  = "etc/cloud/cloud.cfg"
+		cloudCfgDDir  = "etc/cloud/cloud.cfg.d"
+		cloudFileMode = 0644
+	)
+
+	if settings.IsEmpty() {
+		return
+	}
+
+	ReportAction("Configuring cloud-init")
+
+	if exists, _ := file.PathExists(filepath.Join(installRoot, cloudCfgFile)); !exists {
+		return fmt.Errorf("cannot configure cloud-init: /%s is missing from the image, add the 'cloud-init' package to the package lists", cloudCfgFile)
+	}
+
+	cloudCfgDPath := filepath.Join(installRoot, cloudCfgDDir)
+	err = os.MkdirAll(cloudCfgDPath, os.ModePerm)
+	if err != nil {
+		return
+	}
+
+	for _, configFile := range settings.ConfigFiles {
+		configPath := filepath.Join(cloudCfgDPath, filepath.Base(configFile))
+		logger.Log.Debugf("Installing cloud-init config (%s)", configPath)
+		err = file.Copy(configFile, configPath)
+		if err != nil {
+			return
+		}
+
+		err = os.Chmod(configPath, cloudFileMode)
+		if err != nil {
+			return
+		}
+	}
+
+	if len(settings.Datasources) == 0 && len(settings.Config) == 0 {
+		return
+	}
+
+	document, err := settings.GetConfigDocument()
+	if err != nil {
+		return
+	}
+
+	return os.WriteFile(filepath.Join(cloudCfgDPath, configuration.CloudInitConfigName), document, cloudFileMode)
+}
+
+// installUdevRules writes the udev rules files into /etc/udev/rules.d. Rules files from the build machine
+// are checked with the same syntax checks as inline rules before they are installed.
+func installUdevRules(installRoot string, rules []configuration.UdevRule) (err error) {
+	const (
+		udevRulesDir      = "etc/udev/rules.d"
+		udevRulesFileMode = 0644
+	)
+
+	if len(rules) == 0 {
+		return
+	}
+
+	ReportAction("Installing udev rules")
+
+	udevRulesDirPath := filepath.Join(installRoot, udevRulesDir)
+	err = os.MkdirAll(udevRulesDirPath, os.ModePerm)
+	if err != nil {
+		return
+	}
+
+	for _, rule := range rules {
+		contents := strings.Join(rule.Rules, "\n") + "\n"
+		if rule.Path != "" {
+			var fileContents []byte
+			fileContents, err = os.ReadFile(rule.Path)
+			if err != nil {
 				return
 			}
+
+			contents = string(fileContents)
+			err = configuration.ValidateUdevRules(contents)
+			if err != nil {
+				return fmt.Errorf("invalid udev rules file (%s): %w", rule.Path, err)
+			}
 		}
-	}()
 
-	logger.Log.Debugf("Installing 'mariner-release' package to a clean RPM root under '%s'.", installRoot)
+		rulePath := filepath.Join(udevRulesDirPath, rule.Name)
+		logger.Log.Debugf("Installing udev rules (%s)", rulePath)
+		err = file.Write(contents, rulePath)
+		if err != nil {
+			return
+		}
 
-	rpmArgs := []string{"-i", "--root", installRoot}
-	rpmArgs = append(rpmArgs, rpmFiles...)
-	err = shell.ExecuteLive(squashErrors, "rpm", rpmArgs...)
+		err = os.Chmod(rulePath, udevRulesFileMode)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// installAuditRules writes the audit rules files to /etc/audit/rules.d and enables auditd,
+// which merges them with augenrules when it starts. The rules are only checked for their syntax:
+// "auditctl -R" would load them into the build machine's kernel.
+func installAuditRules(installChroot *safechroot.Chroot, rules []configuration.AuditRule) (err error) {
+	const (
+		auditRulesDir      = "etc/audit/rules.d"
+		auditdUnit         = "usr/lib/systemd/system/auditd.service"
+		auditdService      = "auditd.service"
+		auditRulesFileMode = 0600
+	)
+
+	if len(rules) == 0 {
+		return
+	}
+
+	ReportAction("Installing audit rules")
+
+	installRoot := installChroot.RootDir()
+	if exists, _ := file.PathExists(filepath.Join(installRoot, auditdUnit)); !exists {
+		return fmt.Errorf("cannot install [AuditRules]: auditd is not installed, add the audit package to the package lists")
+	}
+
+	auditRulesDirPath := filepath.Join(installRoot, auditRulesDir)
+	err = os.MkdirAll(auditRulesDirPath, os.ModePerm)
+	if err != nil {
+		return
+	}
+
+	for _, rule := range rules {
+		contents := strings.Join(rule.Rules, "\n") + "\n"
+		if rule.Path != "" {
+			var fileContents []byte
+			fileContents, err = os.ReadFile(rule.Path)
+			if err != nil {
+				return
+			}
+
+			contents = string(fileContents)
+			err = configuration.ValidateAuditRules(contents)
+			if err != nil {
+				return fmt.Errorf("invalid audit rules file (%s): %w", rule.Path, err)
+			}
+		}
+
+		rulePath := filepath.Join(auditRulesDirPath, rule.Name)
+		logger.Log.Debugf("Installing audit rules (%s)", rulePath)
+		err = file.Write(contents, rulePath)
+		if err != nil {
+			return
+		}
+
+		err = os.Chmod(rulePath, auditRulesFileMode)
+		if err != nil {
+			return
+		}
+	}
+
+	return enableService(installChroot, auditdService)
+}
+
+// configureReadOnlyRoot marks the root filesystem read-only in fstab and installs a oneshot service which
+// mounts a writable overlay over each of the configured directories early in boot.
+func configureReadOnlyRoot(installRoot string, readOnlyRoot configuration.ReadOnlyRoot) (err error) {
+	const (
+		fstabPath      = "etc/fstab"
+		overlayScript  = "/usr/libexec/imager/mount-root-overlays"
+		overlayService = "/etc/systemd/system/imager-root-overlays.service"
+	)
+
+	if !readOnlyRoot.Enable {
+		return
+	}
+
+	ReportAction("Configuring read-only root")
+
+	fullFstabPath := filepath.Join(installRoot, fstabPath)
+	fstab, err := file.ReadLines(fullFstabPath)
+	if err != nil {
+		return
+	}
+
+	fstab, err = setRootFstabReadOnly(fstab)
+	if err != nil {
+		return
+	}
+
+	err = file.Write(strings.Join(fstab, "\n")+"\n", fullFstabPath)
+	if err != nil {
+		return
+	}
+
+	if len(readOnlyRoot.WritableDirs) == 0 {
+		return
+	}
+
+	return installOverlayService(installRoot, overlayScript, renderRootOverlayScript(readOnlyRoot), overlayService, renderRootOverlayService(readOnlyRoot, overlayScript))
+}
+
+// installOverlayService writes an overlay script and the oneshot service running it, and starts the
+// service with local-fs.target.
+func installOverlayService(installRoot, overlayScript, script, overlayService, service string) (err error) {
+	const (
+		overlayWantsDir = "etc/systemd/system/local-fs.target.wants"
+		scriptFileMode  = 0755
+	)
+
+	scriptPath := filepath.Join(installRoot, overlayScript)
+	err = os.MkdirAll(filepath.Dir(scriptPath), os.ModePerm)
 	if err != nil {
-		logger.Log.Errorf("Failed to prepare the RPM database on installing the 'mariner-release' package: %v", err)
 		return
 	}
 
-	return
-}
+	err = file.Write(script, scriptPath)
+	if err != nil {
+		return
+	}
 
-func configureSystemFiles(installChroot *safechroot.Chroot, hostname string, installMap, mountPointToFsTypeMap, mountPointToMountArgsMap map[string]string, encryptedRoot diskutils.EncryptedRootDevice, hidepidEnabled bool) (err error) {
-	// Update hosts file
-	err = updateHosts(installChroot.RootDir(), hostname)
+	err = os.Chmod(scriptPath, scriptFileMode)
 	if err != nil {
 		return
 	}
 
-	// Update fstab
-	err = updateFstab(installChroot.RootDir(), installMap, mountPointToFsTypeMap, mountPointToMountArgsMap, hidepidEnabled)
+	err = file.Write(service, filepath.Join(installRoot, overlayService))
 	if err != nil {
 		return
 	}
 
-	// Update crypttab
-	err = updateCrypttab(installChroot.RootDir(), installMap, encryptedRoot)
+	wantsDir := filepath.Join(installRoot, overlayWantsDir)
+	err = os.MkdirAll(wantsDir, os.ModePerm)
 	if err != nil {
 		return
 	}
 
-	return
+	return os.Symlink(overlayService, filepath.Join(wantsDir, filepath.Base(overlayService)))
 }
 
-func calculateTotalPackages(packages []string, installRoot string) (totalPackages int, err error) {
-	allPackageNames := make(map[string]bool)
-	const tdnfAssumeNoStdErr = "Error(1032) : Operation aborted.\n"
+// setRootFstabReadOnly adds the 'ro' option to the fstab entry of the root mount point.
+func setRootFstabReadOnly(fstab []string) (updated []string, err error) {
+	const (
+		mountPointField = 1
+		optionsField    = 3
+		readOnlyOption  = "ro"
+		readWriteOption = "rw"
+	)
 
-	// For every package calculate what dependencies would also be installed from it.
-	for _, pkg := range packages {
-		var (
-			stdout string
-			stderr string
-		)
+	foundRoot := false
+	for _, line := range fstab {
+		fields := strings.Fields(line)
+		if len(fields) <= optionsField || strings.HasPrefix(fields[0], "#") || fields[mountPointField] != "/" {
+			updated = append(updated, line)
+			continue
+		}
 
-		// Issue an install request but stop right before actually performing the install (assumeno)
-		stdout, stderr, err = shell.Execute("tdnf", "install", "--assumeno", "--nogpgcheck", pkg, "--installroot", installRoot)
-		if err != nil {
-			// tdnf aborts the process when it detects an install with --assumeno.
-			if stderr == tdnfAssumeNoStdErr {
-				err = nil
-			} else {
-				logger.Log.Error(stderr)
-				return
+		var options []string
+		for _, option := range strings.Split(fields[optionsField], ",") {
+			if option != readOnlyOption && option != readWriteOption {
+				options = append(options, option)
 			}
 		}
+		fields[optionsField] = strings.Join(append(options, readOnlyOption), ",")
 
-		splitStdout := strings.Split(stdout, "\n")
-
-		// Search for the list of packages to be installed,
-		// it will be prefixed with a line "Installing:" and will
-		// end with an empty line.
-		inPackageList := false
-		for _, line := range splitStdout {
-			const (
-				packageListPrefix    = "Installing:"
-				packageNameDelimiter = " "
-			)
+		updated = append(updated, strings.Join(fields, " "))
+		foundRoot = true
+	}
 
-			const (
-				packageNameIndex      = iota
-				extraInformationIndex = iota
-				totalPackageNameParts = iota
-			)
+	if !foundRoot {
+		err = fmt.Errorf("no fstab entry found for the root mount point")
+	}
+	return
+}
 
-			if !inPackageList {
-				inPackageList = strings.HasPrefix(line, packageListPrefix)
-				continue
-			} else if strings.TrimSpace(line) == "" {
-				break
-			}
+// renderRootOverlayScript returns the script mounting the writable overlays. The upper and work directories
+// mirror each writable directory's path under the overlay root, which keeps them stable across config changes.
+func renderRootOverlayScript(readOnlyRoot configuration.ReadOnlyRoot) string {
+	const tmpfsOverlayName = "imager-overlays"
+
+	var builder strings.Builder
+	builder.WriteString("#!/bin/sh\n")
+	builder.WriteString("# Generated from the image configuration's ReadOnlyRoot settings\n")
+	builder.WriteString("set -e\n")
+	renderOverlayMounts(&builder, tmpfsOverlayName, readOnlyRoot.OverlaySize, readOnlyRoot.PersistentDir, readOnlyRoot.WritableDirs)
+	return builder.String()
+}
 
-			// Each package to be installed will list its name, followed by a space and then various extra information
-			pkgSplit := strings.SplitN(line, packageNameDelimiter, totalPackageNameParts)
-			if len(pkgSplit) != totalPackageNameParts {
-				err = fmt.Errorf("unexpected TDNF package name output: %s", line)
-				return
-			}
+// renderOverlayMounts writes the commands mounting a writable overlay over each directory. The changes are kept
+// in a tmpfs under /run named tmpfsOverlayName, or under persistentDir when it is set.
+func renderOverlayMounts(builder *strings.Builder, tmpfsOverlayName, overlaySize, persistentDir string, dirs []string) {
+	const (
+		tmpfsOverlayParent   = "/run"
+		persistentOverlayDir = ".overlays"
+	)
 
-			allPackageNames[pkgSplit[packageNameIndex]] = true
+	overlayRoot := filepath.Join(tmpfsOverlayParent, tmpfsOverlayName)
+	if persistentDir != "" {
+		overlayRoot = filepath.Join(persistentDir, persistentOverlayDir)
+		builder.WriteString(fmt.Sprintf("mkdir -p '%s'\n", overlayRoot))
+	} else {
+		tmpfsOptions := "mode=0755"
+		if overlaySize != "" {
+			tmpfsOptions = fmt.Sprintf("%s,size=%s", tmpfsOptions, overlaySize)
 		}
+		builder.WriteString(fmt.Sprintf("mkdir -p '%s'\n", overlayRoot))
+		builder.WriteString(fmt.Sprintf("mount -t tmpfs -o %s %s '%s'\n", tmpfsOptions, tmpfsOverlayName, overlayRoot))
 	}
 
-	totalPackages = len(allPackageNames)
-	logger.Log.Debugf("All packages to be installed (%d): %v", totalPackages, allPackageNames)
-	return
+	for _, dir := range dirs {
+		upperDir := filepath.Join(overlayRoot, dir, "upper")
+		workDir := filepath.Join(overlayRoot, dir, "work")
+		builder.WriteString(fmt.Sprintf("mkdir -p '%s' '%s' '%s'\n", dir, upperDir, workDir))
+		builder.WriteString(fmt.Sprintf("mount -t overlay overlay -o 'lowerdir=%s,upperdir=%s,workdir=%s' '%s'\n", dir, upperDir, workDir, dir))
+	}
 }
 
-// addMachineID creates the /etc/machine-id file in the installChroot
-func addMachineID(installChroot *safechroot.Chroot) (err error) {
-	// From https://www.freedesktop.org/software/systemd/man/machine-id.html:
-	// For operating system images which are created once and used on multiple
-	// machines, for example for containers or in the cloud, /etc/machine-id
-	// should be an empty file in the generic file system image. An ID will be
-	// generated during boot and saved to this file if possible.
+// renderRootOverlayService returns the oneshot unit running the overlay script before local-fs.target.
+func renderRootOverlayService(readOnlyRoot configuration.ReadOnlyRoot, scriptPath string) string {
+	return renderOverlayService("ReadOnlyRoot", "Writable overlays on the read-only root filesystem", readOnlyRoot.PersistentDir, scriptPath)
+}
+
+// renderOverlayService returns a oneshot unit running an overlay script before local-fs.target.
+func renderOverlayService(settingName, description, persistentDir, scriptPath string) string {
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("# Generated from the image configuration's %s settings\n", settingName))
+	builder.WriteString("[Unit]\n")
+	builder.WriteString(fmt.Sprintf("Description=%s\n", description))
+	builder.WriteString("DefaultDependencies=no\n")
+	builder.WriteString("After=local-fs-pre.target\n")
+	builder.WriteString("Before=local-fs.target\n")
+	if persistentDir != "" {
+		builder.WriteString(fmt.Sprintf("RequiresMountsFor=%s\n", persistentDir))
+	}
+	builder.WriteString("\n[Service]\n")
+	builder.WriteString("Type=oneshot\n")
+	builder.WriteString("RemainAfterExit=yes\n")
+	builder.WriteString(fmt.Sprintf("ExecStart=%s\n", scriptPath))
+	builder.WriteString("\n[Install]\n")
+	builder.WriteString("WantedBy=local-fs.target\n")
+	return builder.String()
+}
 
+// configureSudoers writes the sudoers rules into a sudoers.d drop-in. The rules are checked with visudo
+// inside the image before the drop-in is put in place, so a bad rule can't lock sudo out.
+func configureSudoers(installChroot *safechroot.Chroot, rules []configuration.SudoersRule) (err error) {
 	const (
-		machineIDFile      = "/etc/machine-id"
-		machineIDFilePerms = 0444
+		squashErrors     = false
+		sudoersDir       = "/etc/sudoers.d"
+		sudoersFileName  = "90-imager"
+		sudoersFileMode  = 0440
+		stagedFilePrefix = "."
 	)
 
-	ReportAction("Configuring machine id")
+	if len(rules) == 0 {
+		return
+	}
+
+	ReportAction("Configuring sudoers rules")
+
+	sudoersDirPath := filepath.Join(installChroot.RootDir(), sudoersDir)
+	exists, err := file.DirExists(sudoersDirPath)
+	if err != nil {
+		return
+	}
+	if !exists {
+		return fmt.Errorf("cannot configure sudoers rules: %s is missing from the image, add the 'sudo' package to the package lists", sudoersDir)
+	}
+
+	// sudo ignores files in sudoers.d whose names contain a '.', so the staged file is never live
+	stagedFile := filepath.Join(sudoersDir, stagedFilePrefix+sudoersFileName)
+	stagedFilePath := filepath.Join(installChroot.RootDir(), stagedFile)
+	err = file.Write(renderSudoers(rules), stagedFilePath)
+	if err != nil {
+		return
+	}
+	defer os.Remove(stagedFilePath)
+
+	err = os.Chmod(stagedFilePath, sudoersFileMode)
+	if err != nil {
+		return
+	}
 
 	err = installChroot.UnsafeRun(func() error {
-		return file.Create(machineIDFile, machineIDFilePerms)
+		return shell.ExecuteLive(squashErrors, "visudo", "-c", "-f", stagedFile)
 	})
+	if err != nil {
+		return fmt.Errorf("sudoers rules failed visudo validation: %w", err)
+	}
+
+	err = os.Rename(stagedFilePath, filepath.Join(sudoersDirPath, sudoersFileName))
 	return
 }
 
-func updateInitramfsForEncrypt(installChroot *safechroot.Chroot) (err error) {
-	err = installChroot.UnsafeRun(func() (err error) {
-		const (
-			libModDir     = "/lib/modules"
-			dracutModules = "dm crypt crypt-gpg crypt-loop lvm"
-			initrdPrefix  = "/boot/initrd.img-"
-			cryptTabPath  = "/etc/crypttab"
-		)
+func renderSudoers(rules []configuration.SudoersRule) string {
+	const noPasswordTag = "NOPASSWD: "
 
-		initrdPattern := fmt.Sprintf("%v%v", initrdPrefix, "*")
-		initrdImageSlice, err := filepath.Glob(initrdPattern)
-		if err != nil {
-			logger.Log.Warnf("Unable to get initrd image: %v", err)
-			return
+	var builder strings.Builder
+	builder.WriteString("# Generated from the image configuration's SudoersRules\n")
+	for _, rule := range rules {
+		subject := rule.User
+		if rule.Group != "" {
+			subject = "%" + rule.Group
 		}
 
-		// Assume only one initrd image present
-		if len(initrdImageSlice) != 1 {
-			logger.Log.Warn("Unable to find one initrd image")
-			logger.Log.Warnf("Initrd images found: %v", initrdImageSlice)
-			err = fmt.Errorf("unable to find one intird image: %v", initrdImageSlice)
-			return
+		tag := ""
+		if rule.NoPassword {
+			tag = noPasswordTag
 		}
 
-		initrdImage := initrdImageSlice[0]
+		builder.WriteString(fmt.Sprintf("%s ALL=(%s) %s%s\n", subject, rule.GetRunAs(), tag, strings.Join(rule.GetCommands(), ", ")))
+	}
+	return builder.String()
+}
+
+// configureOsRelease applies the os-release overrides on top of the base distribution's os-release.
+// Keys which are not overridden are kept in their original order.
+func configureOsRelease(installRoot string, overrides map[string]string) (err error) {
+	const (
+		usrOsReleaseFile = "usr/lib/os-release"
+		etcOsReleaseFile = "etc/os-release"
+	)
 
-		// Get the kernel version
-		kernel := strings.TrimPrefix(initrdImage, initrdPrefix)
+	if len(overrides) == 0 {
+		return
+	}
 
-		// Construct list of files to install in initramfs
-		installFiles := fmt.Sprintf("%v %v", cryptTabPath, diskutils.DefaultKeyFilePath)
+	ReportAction("Configuring os-release")
 
-		// Regenerate initramfs via Dracut
-		dracutArgs := []string{
-			"-f",
-			"--no-hostonly",
-			"--fstab",
-			"--kmoddir", filepath.Join(libModDir, kernel),
-			"--add", dracutModules,
-			"-I", installFiles,
-			initrdImage, kernel,
-		}
-		_, stderr, err := shell.Execute("dracut", dracutArgs...)
+	usrOsReleasePath := filepath.Join(installRoot, usrOsReleaseFile)
+	etcOsReleasePath := filepath.Join(installRoot, etcOsReleaseFile)
 
+	// Prefer the vendor copy, /etc/os-release is usually a link to it
+	baseLines, err := file.ReadLines(usrOsReleasePath)
+	if err != nil {
+		baseLines, err = file.ReadLines(etcOsReleasePath)
 		if err != nil {
-			logger.Log.Warnf("Unable to execute dracut: %v", stderr)
-			return
+			logger.Log.Warnf("No base os-release found in the image, writing only the configured keys")
+			baseLines, err = []string{}, nil
 		}
+	}
+
+	content := renderOsRelease(baseLines, overrides)
 
+	err = os.MkdirAll(filepath.Dir(usrOsReleasePath), os.ModePerm)
+	if err != nil {
 		return
-	})
+	}
+	err = file.Write(content, usrOsReleasePath)
+	if err != nil {
+		return
+	}
 
+	// Replace /etc/os-release unless it already resolves to the vendor copy
+	if linkTarget, linkErr := os.Readlink(etcOsReleasePath); linkErr == nil && filepath.Base(linkTarget) == filepath.Base(usrOsReleaseFile) {
+		return
+	}
+	err = os.RemoveAll(etcOsReleasePath)
+	if err != nil {
+		return
+	}
+	err = file.Write(content, etcOsReleasePath)
 	return
 }
 
+// renderOsRelease merges the os-release overrides into the base os-release lines
+func renderOsRelease(baseLines []string, overrides map[string]string) string {
+	var (
+		builder strings.Builder
+		written = make(map[string]bool)
+	)
+
+	writeKey := func(key string) {
+		builder.WriteString(fmt.Sprintf("%s=%s\n", key, quoteOsReleaseValue(overrides[key])))
+		written[key] = true
+	}
+
+	for _, line := range baseLines {
+		key := strings.SplitN(line, "=", 2)[0]
+		if _, overridden := overrides[key]; overridden && !strings.HasPrefix(line, "#") {
+			writeKey(key)
+			continue
+		}
+		builder.WriteString(line + "\n")
+	}
+
+	newKeys := []string{}
+	for key := range overrides {
+		if !written[key] {
+			newKeys = append(newKeys, key)
+		}
+	}
+	sort.Strings(newKeys)
+	for _, key := range newKeys {
+		writeKey(key)
+	}
+
+	return builder.String()
+}
+
+// quoteOsReleaseValue double quotes a value, escaping the characters with a special meaning to the shell
+func quoteOsReleaseValue(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`, "`", "\\`")
+	return fmt.Sprintf(`"%s"`, replacer.Replace(value))
+}
+
+// warnIfKernelModuleMissing logs a warning if a module can not be found for any kernel installed under installRoot.
+func warnIfKernelModuleMissing(installRoot, module string) {
+	// modprobe treats '-' and '_' as equivalent in module names
+	moduleFileNames := map[string]bool{
+		strings.ReplaceAll(module, "-", "_"): true,
+		strings.ReplaceAll(module, "_", "-"): true,
+	}
+
+	modulesRoot := filepath.Join(installRoot, "lib/modules")
+	found := false
+	filepath.Walk(modulesRoot, func(path string, info os.FileInfo, walkErr error) error {
+		if walkErr != nil || found || info.IsDir() {
+			return nil
+		}
+		name := info.Name()
+		if idx := strings.Index(name, ".ko"); idx > 0 && moduleFileNames[name[:idx]] {
+			found = true
+		}
+		if name == "modules.builtin" {
+			// Modules built into the kernel are listed by path, ie "kernel/drivers/vfio/vfio.ko"
+			builtinModules, readErr := file.ReadLines(path)
+			if readErr != nil {
+				return nil
+			}
+			for _, builtinModule := range builtinModules {
+				builtinName := strings.TrimSuffix(filepath.Base(builtinModule), ".ko")
+				if moduleFileNames[builtinName] {
+					found = true
+				}
+			}
+		}
+		return nil
+	})
+
+	if !found {
+		logger.Log.Warnf("Kernel module (%s) was not found under (%s)", module, modulesRoot)
+	}
+}
+
 func updateFstab(installRoot string, installMap, mountPointToFsTypeMap, mountPointToMountArgsMap map[string]string, hidepidEnabled bool) (err error) {
 	const (
 		doPseudoFsMount = true
@@ -804,7 +2765,12 @@ func updateFstab(installRoot string, installMap, mountPointToFsTypeMap, mountPoi
 
 	for mountPoint, devicePath := range installMap {
 		if mountPoint != "" && devicePath != NullDevice {
-			err = addEntryToFstab(installRoot, mountPoint, devicePath, mountPointToFsTypeMap[mountPoint], mountPointToMountArgsMap[mountPoint], !doPseudoFsMount)
+			mountArgs := mountPointToMountArgsMap[mountPoint]
+			if mountArgs == "" {
+				mountArgs = configuration.DefaultMountOptions(mountPoint)
+			}
+
+			err = addEntryToFstab(installRoot, mountPoint, devicePath, mountPointToFsTypeMap[mountPoint], mountArgs, !doPseudoFsMount)
 			if err != nil {
 				return
 			}
@@ -878,21 +2844,145 @@ func addEntryToFstab(installRoot, mountPoint, devicePath, fsType, mountArgs stri
 	return
 }
 
-func updateCrypttab(installRoot string, installMap map[string]string, encryptedRoot diskutils.EncryptedRootDevice) (err error) {
+func updateCrypttab(installRoot string, installMap map[string]string, encryptedRoot diskutils.EncryptedRootDevice, partitionSettings []configuration.PartitionSetting) (err error) {
 	ReportAction("Configuring Crypttab")
 
-	for _, devicePath := range installMap {
-		if diskutils.IsEncryptedDevice(devicePath) {
-			err = addEntryToCrypttab(installRoot, devicePath, encryptedRoot)
-			if err != nil {
-				return
+	for mountPoint, devicePath := range installMap {
+		if !diskutils.IsEncryptedDevice(devicePath) {
+			continue
+		}
+
+		// Encrypted data partitions are mapped directly by their LUKS UUID, the root is behind LVM
+		if luksUUID := diskutils.GetLuksUUIDFromMappedPath(devicePath); luksUUID != "" {
+			encryption := partitionEncryptionForMountPoint(partitionSettings, mountPoint)
+			source := fmt.Sprintf("UUID=%s", luksUUID)
+			if encryption.DetachedHeader != "" {
+				// The partition holds no LUKS header, so it can only be found by its partition UUID
+				source, err = detachedHeaderCrypttabSource(devicePath)
+				if err != nil {
+					return
+				}
 			}
+			err = addDataPartitionToCrypttab(installRoot, luksUUID, source, encryption)
+		} else {
+			err = addEntryToCrypttab(installRoot, devicePath, encryptedRoot)
+		}
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// partitionEncryptionForMountPoint returns the encryption settings of the partition mounted at mountPoint
+func partitionEncryptionForMountPoint(partitionSettings []configuration.PartitionSetting, mountPoint string) (encryption configuration.PartitionEncryption) {
+	for _, partitionSetting := range partitionSettings {
+		if partitionSetting.MountPoint == mountPoint {
+			return partitionSetting.Encryption
+		}
+	}
+	return
+}
+
+// detachedHeaderCrypttabSource returns the crypttab source of an open LUKS volume with a detached header
+func detachedHeaderCrypttabSource(mappedPath string) (source string, err error) {
+	partDevPath, err := diskutils.GetMappedBackingDevice(mappedPath)
+	if err != nil {
+		return
+	}
+
+	partUUID, err := GetPartUUID(partDevPath)
+	if err != nil {
+		return
+	}
+
+	source = fmt.Sprintf("PARTUUID=%s", strings.TrimSpace(partUUID))
+	return
+}
+
+// Add an encrypted data partition mapping to crypttab. The volume is unlocked at boot by
+// systemd-cryptsetup, either through an enrolled TPM2 token or by prompting for the passphrase.
+func addDataPartitionToCrypttab(installRoot, luksUUID, source string, encryption configuration.PartitionEncryption) (err error) {
+	const (
+		cryptTabPath   = "/etc/crypttab"
+		defaultOptions = "luks,discard"
+		tpm2Option     = "tpm2-device=auto"
+		headerOption   = "header="
+		noKeyFile      = "none"
+	)
+
+	options := defaultOptions
+	if encryption.Tpm2Unlock {
+		options = fmt.Sprintf("%s,%s", options, tpm2Option)
+	}
+	if encryption.HeaderPath != "" {
+		options = fmt.Sprintf("%s,%s%s", options, headerOption, encryption.HeaderPath)
+	}
+
+	fullCryptTabPath := filepath.Join(installRoot, cryptTabPath)
+	blockDevice := diskutils.GetLuksMappingName(luksUUID)
+
+	newEntry := fmt.Sprintf("%v %v %v %v\n", blockDevice, source, noKeyFile, options)
+	err = file.Append(newEntry, fullCryptTabPath)
+	if err != nil {
+		logger.Log.Warnf("Failed to append crypttab")
+		return
+	}
+	return
+}
+
+// updateIntegritytab adds an /etc/integritytab entry for every dm-integrity data partition, so
+// systemd-integritysetup opens them at boot.
+func updateIntegritytab(installRoot string, installMap map[string]string, partitionSettings []configuration.PartitionSetting) (err error) {
+	const integritytabPath = "/etc/integritytab"
+
+	for mountPoint, devicePath := range installMap {
+		name := diskutils.GetIntegrityNameFromMappedPath(devicePath)
+		if name == "" {
+			continue
+		}
+
+		ReportAction("Configuring Integritytab")
+
+		// dm-integrity keeps no UUID of its own, so the volume is found by its partition UUID
+		var partDevPath, partUUID string
+		partDevPath, err = diskutils.GetMappedBackingDevice(devicePath)
+		if err != nil {
+			return
+		}
+
+		partUUID, err = GetPartUUID(partDevPath)
+		if err != nil {
+			return
+		}
+
+		integrity := partitionIntegrityForMountPoint(partitionSettings, mountPoint)
+		err = file.Append(renderIntegritytabEntry(name, strings.TrimSpace(partUUID), integrity), filepath.Join(installRoot, integritytabPath))
+		if err != nil {
+			logger.Log.Warnf("Failed to append integritytab")
+			return
 		}
 	}
 
 	return
 }
 
+// partitionIntegrityForMountPoint returns the integrity settings of the partition mounted at mountPoint
+func partitionIntegrityForMountPoint(partitionSettings []configuration.PartitionSetting, mountPoint string) (integrity configuration.PartitionIntegrity) {
+	for _, partitionSetting := range partitionSettings {
+		if partitionSetting.MountPoint == mountPoint {
+			return partitionSetting.Integrity
+		}
+	}
+	return
+}
+
+func renderIntegritytabEntry(name, partUUID string, integrity configuration.PartitionIntegrity) string {
+	const noKeyFile = "-"
+	return fmt.Sprintf("%s PARTUUID=%s %s integrity-algorithm=%s\n", name, partUUID, noKeyFile, integrity.GetAlgorithm())
+}
+
 // Add an encryption mapping to crypttab
 func addEntryToCrypttab(installRoot string, devicePath string, encryptedRoot diskutils.EncryptedRootDevice) (err error) {
 	const (
@@ -923,21 +3013,47 @@ func addEntryToCrypttab(installRoot string, devicePath string, encryptedRoot dis
 // - bootUUID is the UUID for the boot partition
 // - encryptedRoot holds the encrypted root information if encrypted root is enabled
 // - kernelCommandLine contains additional kernel parameters which may be optionally set
+// - rescueEntry optionally adds a second menu entry after the default one
+// - grubPassword optionally requires a superuser password to edit menu entries or open the grub shell
+// - grubCfgTemplate is an optional grub.cfg to use in place of the default one, using the same placeholders
 // Note: this boot partition could be different than the boot partition specified in the bootloader.
 // This boot partition specifically indicates where to find the kernel, config files, and initrd
-func InstallGrubCfg(installRoot, rootDevice, bootUUID, bootPrefix string, encryptedRoot diskutils.EncryptedRootDevice, kernelCommandLine configuration.KernelCommandLine, readOnlyRoot diskutils.VerityDevice) (err error) {
+func InstallGrubCfg(installRoot, rootDevice, bootUUID, bootPrefix string, encryptedRoot diskutils.EncryptedRootDevice, kernelCommandLine configuration.KernelCommandLine, readOnlyRoot diskutils.VerityDevice, rescueEntry configuration.RescueBootEntry, grubPassword configuration.GrubPassword, grubCfgTemplate string) (err error) {
 	const (
 		assetGrubcfgFile = "/installer/grub2/grub.cfg"
 		grubCfgFile      = "boot/grub2/grub.cfg"
 	)
 
+	sourceGrubCfgFile := assetGrubcfgFile
+	if grubCfgTemplate != "" {
+		logger.Log.Infof("Using grub.cfg template (%s)", grubCfgTemplate)
+		sourceGrubCfgFile = grubCfgTemplate
+	}
+
 	// Copy the bootloader's grub.cfg and set the file permission
 	installGrubCfgFile := filepath.Join(installRoot, grubCfgFile)
-	err = file.CopyAndChangeMode(assetGrubcfgFile, installGrubCfgFile, bootDirectoryDirMode, bootDirectoryFileMode)
+	err = file.CopyAndChangeMode(sourceGrubCfgFile, installGrubCfgFile, bootDirectoryDirMode, bootDirectoryFileMode)
 	if err != nil {
 		return
 	}
 
+	// The rescue entry is added before the placeholders are filled in, so it gets the same values as the default entry
+	if rescueEntry.Enable {
+		err = addGrubCfgRescueEntry(installGrubCfgFile, rescueEntry)
+		if err != nil {
+			logger.Log.Warnf("Failed to add rescue entry to grub.cfg: %v", err)
+			return
+		}
+	}
+
+	if grubPassword.IsEnabled() {
+		err = addGrubCfgPassword(installGrubCfgFile, grubPassword, rescueEntry)
+		if err != nil {
+			logger.Log.Warnf("Failed to add password protection to grub.cfg: %v", err)
+			return
+		}
+	}
+
 	// Add in bootUUID
 	err = setGrubCfgBootUUID(bootUUID, installGrubCfgFile)
 	if err != nil {
@@ -999,6 +3115,160 @@ func InstallGrubCfg(installRoot, rootDevice, bootUUID, bootPrefix string, encryp
 		return
 	}
 
+	if grubCfgTemplate != "" {
+		var renderedGrubCfg []byte
+		renderedGrubCfg, err = os.ReadFile(installGrubCfgFile)
+		if err != nil {
+			return
+		}
+
+		err = validateRenderedGrubCfg(string(renderedGrubCfg))
+		if err != nil {
+			err = fmt.Errorf("invalid grub.cfg rendered from template (%s): %w", grubCfgTemplate, err)
+			return
+		}
+	}
+
+	return
+}
+
+// UpdateCmdlineTxt applies the image's kernel command line to any cmdline.txt found on the boot partition,
+// for boards whose firmware boots the kernel directly from the command line in that file instead of through grub.
+// The file's existing parameters are kept, except for root=, and the same parameters placed on grub.cfg's linux
+// line are appended. A cmdline.txt containing grub.cfg placeholders is filled in instead.
+// - installRoot is the base install directory
+// - rootDevice holds the root partition
+// - encryptedRoot holds the encrypted root information if encrypted root is enabled
+// - kernelCommandLine contains additional kernel parameters which may be optionally set
+// - readOnlyRoot holds the verity root information if a read-only verity root is enabled
+func UpdateCmdlineTxt(installRoot, rootDevice string, encryptedRoot diskutils.EncryptedRootDevice, kernelCommandLine configuration.KernelCommandLine, readOnlyRoot diskutils.VerityDevice) (err error) {
+	cmdlineTxtFiles := []string{
+		"boot/cmdline.txt",
+		"boot/efi/cmdline.txt",
+		"boot/firmware/cmdline.txt",
+	}
+
+	for _, cmdlineTxtFile := range cmdlineTxtFiles {
+		installCmdlineTxtFile := filepath.Join(installRoot, cmdlineTxtFile)
+		var exists bool
+		exists, err = file.PathExists(installCmdlineTxtFile)
+		if err != nil {
+			return
+		}
+		if !exists {
+			continue
+		}
+
+		ReportActionf("Configuring /%s", cmdlineTxtFile)
+		err = updateCmdlineTxtFile(installCmdlineTxtFile, rootDevice, encryptedRoot, kernelCommandLine, readOnlyRoot)
+		if err != nil {
+			return fmt.Errorf("failed to update (/%s): %w", cmdlineTxtFile, err)
+		}
+	}
+
+	return
+}
+
+func updateCmdlineTxtFile(cmdlineTxtPath, rootDevice string, encryptedRoot diskutils.EncryptedRootDevice, kernelCommandLine configuration.KernelCommandLine, readOnlyRoot diskutils.VerityDevice) (err error) {
+	cmdlineTxt, err := os.ReadFile(cmdlineTxtPath)
+	if err != nil {
+		return
+	}
+
+	err = file.Write(renderCmdlineTxtTemplate(string(cmdlineTxt)), cmdlineTxtPath)
+	if err != nil {
+		return
+	}
+
+	// cmdline.txt uses the same placeholders as grub.cfg's linux line
+	err = setGrubCfgRootDevice(rootDevice, cmdlineTxtPath, encryptedRoot.LuksUUID)
+	if err != nil {
+		return
+	}
+
+	err = setGrubCfgLuksUUID(cmdlineTxtPath, encryptedRoot.LuksUUID)
+	if err != nil {
+		return
+	}
+
+	err = setGrubCfgLVM(cmdlineTxtPath, encryptedRoot.LuksUUID)
+	if err != nil {
+		return
+	}
+
+	err = setGrubCfgIMA(cmdlineTxtPath, kernelCommandLine)
+	if err != nil {
+		return
+	}
+
+	err = setGrubCfgReadOnlyVerityRoot(cmdlineTxtPath, readOnlyRoot)
+	if err != nil {
+		return
+	}
+
+	err = setGrubCfgSELinux(cmdlineTxtPath, kernelCommandLine)
+	if err != nil {
+		return
+	}
+
+	err = setGrubCfgAdditionalCmdLine(cmdlineTxtPath, kernelCommandLine)
+	if err != nil {
+		return
+	}
+
+	// The firmware only reads the first line, so collapse the parameters left by empty placeholders into one line
+	cmdlineTxt, err = os.ReadFile(cmdlineTxtPath)
+	if err != nil {
+		return
+	}
+
+	return file.Write(strings.Join(strings.Fields(string(cmdlineTxt)), " ")+"\n", cmdlineTxtPath)
+}
+
+// renderCmdlineTxtTemplate turns the parameters of an existing cmdline.txt into a template using grub.cfg's
+// placeholders. Files that already contain placeholders are returned unchanged.
+func renderCmdlineTxtTemplate(cmdlineTxt string) string {
+	const (
+		placeholderPrefix  = "{{"
+		rootPrefix         = "root="
+		cmdlineTxtTemplate = "{{.LuksUUID}} {{.LVM}} {{.IMAPolicy}} {{.ReadOnlyVerityRoot}} {{.SELinux}} rd.auto=1 root={{.RootPartition}} {{.ExtraCommandLine}}"
+	)
+
+	if strings.Contains(cmdlineTxt, placeholderPrefix) {
+		return cmdlineTxt
+	}
+
+	var args []string
+	for _, arg := range strings.Fields(cmdlineTxt) {
+		if !strings.HasPrefix(arg, rootPrefix) {
+			args = append(args, arg)
+		}
+	}
+
+	return strings.Join(append(args, cmdlineTxtTemplate), " ")
+}
+
+// validateRenderedGrubCfg checks a grub.cfg rendered from a user template can boot a kernel and has
+// no placeholders left that the installer does not know about.
+func validateRenderedGrubCfg(grubCfg string) (err error) {
+	const placeholderPrefix = "{{"
+
+	hasLinuxLine := false
+	for i, line := range strings.Split(grubCfg, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 1 && (fields[0] == "linux" || fields[0] == "linuxefi") {
+			hasLinuxLine = true
+		}
+
+		if strings.Contains(line, placeholderPrefix) {
+			return fmt.Errorf("unknown placeholder on line %d: %s", i+1, strings.TrimSpace(line))
+		}
+	}
+
+	if !hasLinuxLine {
+		return fmt.Errorf("no 'linux' line found to boot a kernel")
+	}
+
 	return
 }
 
@@ -1575,8 +3845,15 @@ func selinuxRelabelFiles(systemConfig configuration.SystemConfig, installChroot
 		}
 		return err
 	})
+	if err != nil {
+		return
+	}
 
-	return
+	mountPoints := make([]string, 0, len(mountPointToFsTypeMap))
+	for mountPoint := range mountPointToFsTypeMap {
+		mountPoints = append(mountPoints, mountPoint)
+	}
+	return selinuxLabelMountPointDirs(installChroot, fileContextPath, mountPoints, listOfMountsToLabel)
 }
 
 func sed(find, replace, delimiter, file string) (err error) {
@@ -1610,27 +3887,30 @@ func getPackagesFromJSON(file string) (pkgList PackageList, err error) {
 // This boot partition specifically indicates where to find the main grub cfg
 func InstallBootloader(installChroot *safechroot.Chroot, encryptEnabled bool, bootType, bootUUID, bootPrefix, bootDevPath string) (err error) {
 	const (
-		efiMountPoint  = "/boot/efi"
-		efiBootType    = "efi"
-		legacyBootType = "legacy"
-		noneBootType   = "none"
+		efiMountPoint = "/boot/efi"
 	)
 
 	ReportAction("Configuring bootloader")
 
 	switch bootType {
-	case legacyBootType:
+	case configuration.BootTypeLegacy:
 		err = installLegacyBootloader(installChroot, bootDevPath, encryptEnabled)
 		if err != nil {
 			return
 		}
-	case efiBootType:
+	case configuration.BootTypeEfi:
 		efiPath := filepath.Join(installChroot.RootDir(), efiMountPoint)
 		err = installEfiBootloader(encryptEnabled, efiPath, bootUUID, bootPrefix)
 		if err != nil {
 			return
 		}
-	case noneBootType:
+	case configuration.BootTypeHybrid:
+		efiPath := filepath.Join(installChroot.RootDir(), efiMountPoint)
+		err = installHybridBootloader(installChroot, encryptEnabled, efiPath, bootUUID, bootPrefix, bootDevPath)
+		if err != nil {
+			return
+		}
+	case configuration.BootTypeNone:
 		// Nothing to do here
 	default:
 		err = fmt.Errorf("unknown boot type: %v", bootType)
@@ -1763,13 +4043,96 @@ func installEfiBootloader(encryptEnabled bool, installRoot, bootUUID, bootPrefix
 	return
 }
 
+// ConfigureSystemdBoot writes the systemd-boot loader.conf settings into the image's ESP.
+// The settings are only applied if systemd-boot was installed onto the ESP.
+func ConfigureSystemdBoot(installRoot string, systemdBoot configuration.SystemdBoot) (err error) {
+	const (
+		efiMountPoint     = "boot/efi"
+		systemdBootGlob   = "EFI/systemd/systemd-boot*.efi"
+		loaderConfigFile  = "loader/loader.conf"
+		loaderDirMode     = 0700
+		loaderConfigPerms = 0600
+	)
+
+	if !systemdBoot.IsEnabled() {
+		return
+	}
+
+	efiPath := filepath.Join(installRoot, efiMountPoint)
+	systemdBootBinaries, err := filepath.Glob(filepath.Join(efiPath, systemdBootGlob))
+	if err != nil {
+		return
+	}
+	if len(systemdBootBinaries) == 0 {
+		logger.Log.Warnf("[SystemdBoot] is set, but systemd-boot was not found under (%s). Skipping loader.conf", efiPath)
+		return
+	}
+
+	ReportAction("Configuring systemd-boot")
+
+	loaderConfigPath := filepath.Join(efiPath, loaderConfigFile)
+	err = os.MkdirAll(filepath.Dir(loaderConfigPath), loaderDirMode)
+	if err != nil {
+		return
+	}
+
+	// Keep the settings a package already wrote, such as "editor" or "console-mode"
+	existingConfig, err := os.ReadFile(loaderConfigPath)
+	if err != nil && !os.IsNotExist(err) {
+		return
+	}
+
+	err = file.Write(renderLoaderConf(string(existingConfig), systemdBoot), loaderConfigPath)
+	if err != nil {
+		return
+	}
+
+	err = os.Chmod(loaderConfigPath, loaderConfigPerms)
+	return
+}
+
+// renderLoaderConf returns the loader.conf with the "default" and "timeout" keys set by SystemdBoot replaced,
+// and every other line of existingConfig left as is.
+func renderLoaderConf(existingConfig string, systemdBoot configuration.SystemdBoot) string {
+	settings := make(map[string]string)
+	if systemdBoot.DefaultEntry != "" {
+		settings["default"] = systemdBoot.DefaultEntry
+	}
+	if systemdBoot.Timeout != "" {
+		settings["timeout"] = systemdBoot.Timeout
+	}
+
+	var loaderConfig strings.Builder
+	for _, line := range strings.Split(strings.TrimSuffix(existingConfig, "\n"), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && settings[fields[0]] != "" {
+			continue
+		}
+		if line != "" || loaderConfig.Len() != 0 {
+			fmt.Fprintf(&loaderConfig, "%s\n", line)
+		}
+	}
+
+	for _, key := range []string{"default", "timeout"} {
+		if value := settings[key]; value != "" {
+			fmt.Fprintf(&loaderConfig, "%s %s\n", key, value)
+		}
+	}
+	return loaderConfig.String()
+}
+
 func copyAdditionalFiles(installChroot *safechroot.Chroot, config configuration.SystemConfig) (err error) {
 	ReportAction("Copying additional files")
 
+	err = checkPackageOwnedAdditionalFiles(installChroot.RootDir(), config)
+	if err != nil {
+		return
+	}
+
 	for srcFile, dstFile := range config.AdditionalFiles {
 		fileToCopy := safechroot.FileToCopy{
 			Src:  srcFile,
-			Dest: dstFile,
+			Dest: dstFile.Path,
 		}
 
 		err = installChroot.AddFiles(fileToCopy)
@@ -1781,6 +4144,45 @@ func copyAdditionalFiles(installChroot *safechroot.Chroot, config configuration.
 	return
 }
 
+// setAdditionalFilesPermissions applies the requested ownership and mode to the copied additional files.
+// It must run after users and groups are created so names resolve against the image's /etc/passwd and /etc/group.
+func setAdditionalFilesPermissions(installChroot *safechroot.Chroot, config configuration.SystemConfig) (err error) {
+	const squashErrors = false
+
+	for _, dstFile := range config.AdditionalFiles {
+		if !dstFile.HasPermissions() {
+			continue
+		}
+
+		ReportActionf("Setting permissions on additional file: %s", dstFile.Path)
+
+		if dstFile.Owner != "" || dstFile.Group != "" {
+			owner := dstFile.Owner
+			if dstFile.Group != "" {
+				owner = fmt.Sprintf("%s:%s", owner, dstFile.Group)
+			}
+
+			err = installChroot.UnsafeRun(func() error {
+				return shell.ExecuteLive(squashErrors, "chown", owner, dstFile.Path)
+			})
+			if err != nil {
+				return fmt.Errorf("failed to set owner of additional file (%s): %w", dstFile.Path, err)
+			}
+		}
+
+		if dstFile.Mode != "" {
+			err = installChroot.UnsafeRun(func() error {
+				return shell.ExecuteLive(squashErrors, "chmod", dstFile.Mode, dstFile.Path)
+			})
+			if err != nil {
+				return fmt.Errorf("failed to set mode of additional file (%s): %w", dstFile.Path, err)
+			}
+		}
+	}
+
+	return
+}
+
 // cleanupRpmDatabase removes RPM database if the image does not require a package manager.
 // rootPrefix is prepended to the RPM database path - useful when RPM database resides in a chroot and cleanupRpmDatabase can't be called from within the chroot.
 func cleanupRpmDatabase(rootPrefix string) (err error) {
@@ -1797,39 +4199,65 @@ func cleanupRpmDatabase(rootPrefix string) (err error) {
 }
 
 func runPostInstallScripts(installChroot *safechroot.Chroot, config configuration.SystemConfig) (err error) {
-	const squashErrors = false
+	const (
+		squashErrors         = false
+		inlineScriptFmt      = "/tmp/imager-inline-script-%d.sh"
+		inlineScriptFileMode = 0755
+	)
 
-	for _, script := range config.PostInstallScripts {
-		// Copy the script from this chroot into the install chroot before running it
+	for i, script := range config.PostInstallScripts {
 		scriptPath := script.Path
-		fileToCopy := safechroot.FileToCopy{
-			Src:  scriptPath,
-			Dest: scriptPath,
-		}
-
-		installChroot.AddFiles(fileToCopy)
-		if err != nil {
-			return
-		}
+		scriptName := path.Base(script.Path)
+		if script.Inline != "" {
+			// Inline scripts are written straight into the install chroot as an executable
+			scriptPath = fmt.Sprintf(inlineScriptFmt, i)
+			scriptName = fmt.Sprintf("inline script #%d", i+1)
+			fullScriptPath := filepath.Join(installChroot.RootDir(), scriptPath)
+			err = os.MkdirAll(filepath.Dir(fullScriptPath), os.ModePerm)
+			if err != nil {
+				return
+			}
 
-		ReportActionf("Running post-install script: %s", path.Base(script.Path))
-		logger.Log.Infof("Running post-install script: %s", script.Path)
-		err = installChroot.UnsafeRun(func() error {
-			err := shell.ExecuteLive(squashErrors, shell.ShellProgram, "-c", fmt.Sprintf("%s %s", scriptPath, script.Args))
+			err = file.Write(script.InlineScript(), fullScriptPath)
+			if err != nil {
+				return
+			}
 
+			err = os.Chmod(fullScriptPath, inlineScriptFileMode)
 			if err != nil {
-				return err
+				return
+			}
+		} else {
+			// Copy the script from this chroot into the install chroot before running it
+			fileToCopy := safechroot.FileToCopy{
+				Src:  scriptPath,
+				Dest: scriptPath,
 			}
 
-			err = os.Remove(scriptPath)
+			err = installChroot.AddFiles(fileToCopy)
 			if err != nil {
-				logger.Log.Errorf("Failed to cleanup post-install script (%s). Error: %s", scriptPath, err)
+				return
 			}
+		}
 
-			return err
-		})
+		ReportActionf("Running post-install script: %s", scriptName)
+		command := fmt.Sprintf("%s %s", scriptPath, script.Args)
+		if script.NetworkIsolated() {
+			logger.Log.Infof("Running post-install script without network access: %s", scriptName)
+			err = installChroot.RunWithoutNetwork(squashErrors, shell.ShellProgram, "-c", command)
+		} else {
+			logger.Log.Infof("Running post-install script: %s", scriptName)
+			err = installChroot.UnsafeRun(func() error {
+				return shell.ExecuteLive(squashErrors, shell.ShellProgram, "-c", command)
+			})
+		}
+		if err != nil {
+			return
+		}
 
+		err = os.Remove(filepath.Join(installChroot.RootDir(), scriptPath))
 		if err != nil {
+			logger.Log.Errorf("Failed to cleanup post-install script (%s). Error: %s", scriptPath, err)
 			return
 		}
 	}
@@ -1837,6 +4265,119 @@ func runPostInstallScripts(installChroot *safechroot.Chroot, config configuratio
 	return
 }
 
+func addGrubCfgRescueEntry(grubPath string, rescueEntry configuration.RescueBootEntry) (err error) {
+	grubCfg, err := os.ReadFile(grubPath)
+	if err != nil {
+		return
+	}
+
+	rescueGrubCfg, err := renderGrubCfgRescueEntry(string(grubCfg), rescueEntry)
+	if err != nil {
+		return
+	}
+
+	logger.Log.Debugf("Adding rescue entry ('%s') to '%s'", rescueEntry.GetTitle(), grubPath)
+	return file.Write(rescueGrubCfg, grubPath)
+}
+
+// renderGrubCfgRescueEntry copies the first menuentry of a grub.cfg to the end of the file, renamed and with
+// the rescue parameters appended to its linux line. The default entry is left untouched.
+func renderGrubCfgRescueEntry(grubCfg string, rescueEntry configuration.RescueBootEntry) (rescueGrubCfg string, err error) {
+	const (
+		menuEntryPrefix = "menuentry "
+		timeoutPrefix   = "set timeout="
+	)
+
+	lines := strings.Split(grubCfg, "\n")
+	start, end := -1, -1
+	for i, line := range lines {
+		trimmedLine := strings.TrimSpace(line)
+		if start < 0 {
+			if strings.HasPrefix(trimmedLine, menuEntryPrefix) {
+				start = i
+			}
+			continue
+		}
+		if trimmedLine == "}" {
+			end = i
+			break
+		}
+	}
+	if start < 0 || end < 0 {
+		err = fmt.Errorf("no menuentry found to base the rescue entry on")
+		return
+	}
+
+	rescueLines := []string{"", fmt.Sprintf("menuentry \"%s\" {", rescueEntry.GetTitle())}
+	for _, line := range lines[start+1 : end+1] {
+		if strings.HasPrefix(strings.TrimSpace(line), "linux ") {
+			line = fmt.Sprintf("%s %s", strings.TrimRight(line, " "), rescueEntry.GetCommandLine())
+		}
+		rescueLines = append(rescueLines, line)
+	}
+
+	if rescueEntry.MenuTimeout > 0 {
+		for i, line := range lines {
+			if strings.HasPrefix(line, timeoutPrefix) {
+				lines[i] = fmt.Sprintf("%s%d", timeoutPrefix, rescueEntry.MenuTimeout)
+			}
+		}
+	}
+
+	rescueGrubCfg = strings.TrimRight(strings.Join(lines, "\n"), "\n") + "\n" + strings.Join(rescueLines, "\n") + "\n"
+	return
+}
+
+func addGrubCfgPassword(grubPath string, grubPassword configuration.GrubPassword, rescueEntry configuration.RescueBootEntry) (err error) {
+	grubCfg, err := os.ReadFile(grubPath)
+	if err != nil {
+		return
+	}
+
+	logger.Log.Debugf("Adding grub superuser ('%s') to '%s'", grubPassword.Username, grubPath)
+	return file.Write(renderGrubCfgPassword(string(grubCfg), grubPassword, rescueEntry), grubPath)
+}
+
+// renderGrubCfgPassword adds a grub superuser to the top of a grub.cfg. Once superusers are set grub requires
+// the password to edit an entry, open the grub shell or boot a restricted entry. Every entry is marked
+// --unrestricted so the image still boots unattended, except the rescue entry, which stays password protected.
+func renderGrubCfgPassword(grubCfg string, grubPassword configuration.GrubPassword, rescueEntry configuration.RescueBootEntry) string {
+	const (
+		menuEntryPrefix  = "menuentry "
+		unrestrictedFlag = "--unrestricted"
+	)
+
+	rescueMenuEntry := ""
+	if rescueEntry.Enable {
+		rescueMenuEntry = fmt.Sprintf("menuentry \"%s\"", rescueEntry.GetTitle())
+	}
+
+	lines := strings.Split(grubCfg, "\n")
+	for i, line := range lines {
+		trimmedLine := strings.TrimSpace(line)
+		if !strings.HasPrefix(trimmedLine, menuEntryPrefix) || strings.Contains(line, unrestrictedFlag) {
+			continue
+		}
+		if rescueMenuEntry != "" && strings.HasPrefix(trimmedLine, rescueMenuEntry) {
+			continue
+		}
+
+		bodyStart := strings.LastIndex(line, "{")
+		if bodyStart < 0 {
+			continue
+		}
+		lines[i] = fmt.Sprintf("%s%s %s", line[:bodyStart], unrestrictedFlag, line[bodyStart:])
+	}
+
+	passwordLines := []string{
+		fmt.Sprintf("set superusers=\"%s\"", grubPassword.Username),
+		"export superusers",
+		fmt.Sprintf("password_pbkdf2 %s %s", grubPassword.Username, grubPassword.PasswordHash),
+	}
+
+	return strings.Join(append(passwordLines, lines...), "\n")
+}
+
 func setGrubCfgAdditionalCmdLine(grubPath string, kernelCommandline configuration.KernelCommandLine) (err error) {
 	const (
 		extraPattern = "{{.ExtraCommandLine}}"
@@ -2071,6 +4612,7 @@ func ExtractPartitionArtifacts(setupChrootDirPath, workDirPath string, diskIndex
 		ext4ArtifactType  = "ext4"
 		diffArtifactType  = "diff"
 		rdiffArtifactType = "rdiff"
+		zstdPatchType     = "zstdpatch"
 	)
 	// Scan each partition for Artifacts
 	for i, partition := range disk.Partitions {
@@ -2108,6 +4650,21 @@ func ExtractPartitionArtifacts(setupChrootDirPath, workDirPath string, diskIndex
 						break
 					}
 				}
+
+			case zstdPatchType:
+				for _, setting := range systemConfig.PartitionSettings {
+					if setting.ID == partition.ID {
+						if setting.DeltaBaseImage == "" {
+							return fmt.Errorf("partition (%s) has a %s artifact but no [DeltaBaseImage]", partition.ID, zstdPatchType)
+						}
+						finalName := fmt.Sprintf("disk%d.partition%d.zstdpatch", diskIndex, i)
+						err = createZstdPatchArtifact(workDirPath, devPath, setting.DeltaBaseImage, finalName)
+						if err != nil {
+							return err
+						}
+						break
+					}
+				}
 			}
 		}
 	}
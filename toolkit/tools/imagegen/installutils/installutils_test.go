@@ -4,13 +4,32 @@
 package installutils
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
 	"runtime"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"microsoft.com/pkggen/imagegen/configuration"
+	"microsoft.com/pkggen/internal/logger"
 	"microsoft.com/pkggen/internal/pkgjson"
 )
 
+func TestMain(m *testing.M) {
+	logger.InitStderrLog()
+	os.Exit(m.Run())
+}
+
 func TestShouldReturnCorrectRequiredPackagesForArch(t *testing.T) {
 	arm64RequiredPackages := []*pkgjson.PackageVer{}
 	amd64RequiredPackages := []*pkgjson.PackageVer{{Name: "grub2-pc"}}
@@ -26,3 +45,1736 @@ func TestShouldReturnCorrectRequiredPackagesForArch(t *testing.T) {
 		assert.Fail(t, "unknown GOARCH detected: "+arch)
 	}
 }
+
+func TestShouldRenderSpdxSbom(t *testing.T) {
+	const rpmOutput = "zlib\t1.2.11\t3.cm1\tx86_64\tzlib and Boost\nbash\t5.0\t1.cm1\tx86_64\tGPLv3+\ngpg-pubkey\t3135ce90\t5e6fda74\t(none)\tpubkey\n"
+
+	packages, err := parseSbomPackages(rpmOutput)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(packages))
+	assert.Equal(t, "bash", packages[0].Name)
+	assert.Equal(t, "zlib", packages[1].Name)
+
+	sbom, err := renderSbom(packages, "core-efi", configuration.SbomFormatSPDX, time.Unix(0, 0).UTC())
+	assert.NoError(t, err)
+
+	var document spdxDocument
+	assert.NoError(t, json.Unmarshal(sbom, &document))
+	assert.Equal(t, "SPDX-2.3", document.SPDXVersion)
+	assert.Equal(t, "1970-01-01T00:00:00Z", document.CreationInfo.Created)
+	assert.Equal(t, "5.0-1.cm1", document.Packages[0].VersionInfo)
+	assert.Equal(t, "GPL-3.0-or-later", document.Packages[0].LicenseDeclared)
+	assert.Equal(t, "Zlib AND BSL-1.0", document.Packages[1].LicenseDeclared)
+	assert.Equal(t, "pkg:rpm/mariner/bash@5.0-1.cm1?arch=x86_64", document.Packages[0].ExternalRefs[0].ReferenceLocator)
+}
+
+func TestShouldRenderCycloneDXSbom(t *testing.T) {
+	packages := []sbomPackage{{Name: "bash", Version: "5.0", Release: "1.cm1", Arch: "x86_64", License: "GPLv3+"}}
+
+	sbom, err := renderSbom(packages, "core-efi", configuration.SbomFormatCycloneDX, time.Unix(0, 0).UTC())
+	assert.NoError(t, err)
+
+	var document cycloneDXDocument
+	assert.NoError(t, json.Unmarshal(sbom, &document))
+	assert.Equal(t, "CycloneDX", document.BOMFormat)
+	assert.Equal(t, "5.0-1.cm1", document.Components[0].Version)
+	assert.Equal(t, "GPL-3.0-or-later", document.Components[0].Licenses[0].Expression)
+}
+
+func TestShouldConvertRpmLicensesToSpdx(t *testing.T) {
+	tests := []struct {
+		rpmLicense string
+		expected   string
+	}{
+		{rpmLicense: "", expected: ""},
+		{rpmLicense: "MIT", expected: "MIT"},
+		{rpmLicense: "Apache-2.0 OR MIT", expected: "Apache-2.0 OR MIT"},
+		{rpmLicense: "ASL 2.0", expected: "Apache-2.0"},
+		{rpmLicense: "GPLv2+ and LGPLv2+", expected: "GPL-2.0-or-later AND LGPL-2.0-or-later"},
+		{rpmLicense: "(GPLv2 or BSD) and Public Domain", expected: "(GPL-2.0-only OR BSD-3-Clause) AND LicenseRef-Public-Domain"},
+		{rpmLicense: "GPLv3+ with exceptions", expected: "LicenseRef-GPLv3-with-exceptions"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.rpmLicense, func(t *testing.T) {
+			assert.Equal(t, test.expected, spdxLicenseExpression(test.rpmLicense))
+		})
+	}
+}
+
+func TestShouldFailParsingMalformedSbomPackages(t *testing.T) {
+	_, err := parseSbomPackages("bash\t5.0\n")
+	assert.Error(t, err)
+}
+
+func TestShouldWriteTpm2CrypttabEntryForDataPartition(t *testing.T) {
+	installRoot := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(installRoot, "etc"), os.ModePerm))
+
+	encryption := configuration.PartitionEncryption{Enable: true, Password: "pass", Tpm2Unlock: true}
+	err := addDataPartitionToCrypttab(installRoot, "1234-abcd", "UUID=1234-abcd", encryption)
+	assert.NoError(t, err)
+
+	crypttab, err := os.ReadFile(filepath.Join(installRoot, "etc/crypttab"))
+	assert.NoError(t, err)
+	assert.Equal(t, "luks-1234-abcd UUID=1234-abcd none luks,discard,tpm2-device=auto\n", string(crypttab))
+}
+
+func TestShouldWriteDetachedHeaderCrypttabEntryForDataPartition(t *testing.T) {
+	installRoot := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(installRoot, "etc"), os.ModePerm))
+
+	encryption := configuration.PartitionEncryption{Enable: true, Password: "pass", DetachedHeader: "data.header", HeaderPath: "/etc/luks/data.header"}
+	err := addDataPartitionToCrypttab(installRoot, "1234-abcd", "PARTUUID=5678-ef", encryption)
+	assert.NoError(t, err)
+
+	crypttab, err := os.ReadFile(filepath.Join(installRoot, "etc/crypttab"))
+	assert.NoError(t, err)
+	assert.Equal(t, "luks-1234-abcd PARTUUID=5678-ef none luks,discard,header=/etc/luks/data.header\n", string(crypttab))
+}
+
+func TestShouldRenderIntegritytabEntry(t *testing.T) {
+	integrity := configuration.PartitionIntegrity{Enable: true}
+
+	assert.Equal(t, "integrity-data PARTUUID=5678-ef - integrity-algorithm=crc32c\n", renderIntegritytabEntry("integrity-data", "5678-ef", integrity))
+}
+
+func TestShouldFindNormalizedLocale(t *testing.T) {
+	const availableLocales = "C\nC.utf8\nPOSIX\nen_US.utf8\nde_DE.iso885915@euro\n"
+
+	assert.True(t, localeIsAvailable(availableLocales, "en_US.UTF-8"))
+	assert.True(t, localeIsAvailable(availableLocales, "C.UTF-8"))
+	assert.True(t, localeIsAvailable(availableLocales, "de_DE.ISO-8859-15@euro"))
+	assert.False(t, localeIsAvailable(availableLocales, "fr_FR.UTF-8"))
+}
+
+func TestShouldSplitLocaleForLocaledef(t *testing.T) {
+	inputName, charmap := localedefArgs("de_DE.UTF-8@euro")
+	assert.Equal(t, "de_DE@euro", inputName)
+	assert.Equal(t, "UTF-8", charmap)
+
+	inputName, charmap = localedefArgs("en_US")
+	assert.Equal(t, "en_US", inputName)
+	assert.Equal(t, "", charmap)
+}
+
+func TestShouldMinimizeInstallRoot(t *testing.T) {
+	installRoot := t.TempDir()
+	cachedPackage := filepath.Join(installRoot, "var/cache/tdnf/mariner-official-base/packages/bash.rpm")
+	logFile := filepath.Join(installRoot, "var/log/tdnf.log")
+	journalFile := filepath.Join(installRoot, "var/log/journal/0123456789abcdef/system.journal")
+	keptFile := filepath.Join(installRoot, "etc/hostname")
+
+	for _, path := range []string{cachedPackage, logFile, journalFile, keptFile} {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), os.ModePerm))
+		assert.NoError(t, os.WriteFile(path, []byte("contents"), 0644))
+	}
+
+	assert.NoError(t, minimizeInstallRoot(installRoot))
+
+	_, err := os.Stat(filepath.Join(installRoot, "var/cache/tdnf/mariner-official-base"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(installRoot, "var/cache/tdnf"))
+	assert.NoError(t, err)
+
+	info, err := os.Stat(logFile)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), info.Size())
+
+	_, err = os.Stat(journalFile)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Dir(journalFile))
+	assert.NoError(t, err)
+
+	info, err = os.Stat(keptFile)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len("contents")), info.Size())
+}
+
+func TestShouldSortTdnfOptionArgs(t *testing.T) {
+	tdnf := TdnfSettings{Options: map[string]string{"timeout": "60", "retries": "5"}}
+
+	assert.Equal(t, []string{"--setopt=retries=5", "--setopt=timeout=60"}, tdnf.optionArgs())
+}
+
+func TestShouldMergeOsReleaseOverrides(t *testing.T) {
+	baseLines := []string{
+		`NAME="Common Base Linux Mariner"`,
+		`VERSION="1.0.20211027"`,
+		`ID=mariner`,
+		`VERSION_ID=1.0`,
+		`HOME_URL="https://aka.ms/cbl-mariner"`,
+	}
+	overrides := map[string]string{
+		"NAME":       `Contoso "Edge" OS`,
+		"ID":         "contoso",
+		"VERSION_ID": "2.1",
+		"ID_LIKE":    "mariner",
+	}
+
+	expected := `NAME="Contoso \"Edge\" OS"` + "\n" +
+		`VERSION="1.0.20211027"` + "\n" +
+		`ID="contoso"` + "\n" +
+		`VERSION_ID="2.1"` + "\n" +
+		`HOME_URL="https://aka.ms/cbl-mariner"` + "\n" +
+		`ID_LIKE="mariner"` + "\n"
+
+	assert.Equal(t, expected, renderOsRelease(baseLines, overrides))
+}
+
+func TestShouldFindRepoIDsInDirectory(t *testing.T) {
+	repoDir := t.TempDir()
+
+	err := os.WriteFile(filepath.Join(repoDir, "local.repo"), []byte("[local-repo]\nname=Local\nbaseurl=file:///mnt/cdrom/RPMS\n\n[ security-delta ]\nname=Delta\n"), 0644)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(repoDir, "notes.txt"), []byte("[not-a-repo]\n"), 0644)
+	assert.NoError(t, err)
+
+	repoIDs, err := RepoIDsInDirectory(repoDir)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"local-repo", "security-delta"}, repoIDs)
+}
+
+func TestShouldRenderGrubCfgRescueEntry(t *testing.T) {
+	grubCfg := "set timeout=0\n" +
+		"set rootdevice={{.RootPartition}}\n" +
+		"\n" +
+		"menuentry \"CBL-Mariner\" {\n" +
+		"\tlinux $bootprefix/$mariner_linux {{.ReadOnlyVerityRoot}} root=$rootdevice {{.ExtraCommandLine}}\n" +
+		"\tinitrd $bootprefix/$mariner_initrd\n" +
+		"}"
+	rescueEntry := configuration.RescueBootEntry{
+		Enable:      true,
+		CommandLine: "rd.break=pre-mount",
+		MenuTimeout: 5,
+	}
+
+	expected := "set timeout=5\n" +
+		"set rootdevice={{.RootPartition}}\n" +
+		"\n" +
+		"menuentry \"CBL-Mariner\" {\n" +
+		"\tlinux $bootprefix/$mariner_linux {{.ReadOnlyVerityRoot}} root=$rootdevice {{.ExtraCommandLine}}\n" +
+		"\tinitrd $bootprefix/$mariner_initrd\n" +
+		"}\n" +
+		"\n" +
+		"menuentry \"CBL-Mariner (rescue)\" {\n" +
+		"\tlinux $bootprefix/$mariner_linux {{.ReadOnlyVerityRoot}} root=$rootdevice {{.ExtraCommandLine}} rd.break=pre-mount\n" +
+		"\tinitrd $bootprefix/$mariner_initrd\n" +
+		"}\n"
+
+	rescueGrubCfg, err := renderGrubCfgRescueEntry(grubCfg, rescueEntry)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, rescueGrubCfg)
+}
+
+func TestShouldRenderCmdlineTxtTemplate(t *testing.T) {
+	cmdlineTxt := "console=serial0,115200 console=tty1 root=/dev/mmcblk0p2 rootwait\n"
+	expected := "console=serial0,115200 console=tty1 rootwait {{.LuksUUID}} {{.LVM}} {{.IMAPolicy}} {{.ReadOnlyVerityRoot}} {{.SELinux}} rd.auto=1 root={{.RootPartition}} {{.ExtraCommandLine}}"
+
+	assert.Equal(t, expected, renderCmdlineTxtTemplate(cmdlineTxt))
+}
+
+func TestShouldKeepCmdlineTxtWithPlaceholders(t *testing.T) {
+	cmdlineTxt := "console=serial0,115200 root={{.RootPartition}} {{.ReadOnlyVerityRoot}} rootwait\n"
+
+	assert.Equal(t, cmdlineTxt, renderCmdlineTxtTemplate(cmdlineTxt))
+}
+
+func TestShouldRenderGrubCfgPassword(t *testing.T) {
+	grubCfg := "set timeout=0\n" +
+		"menuentry \"CBL-Mariner\" {\n" +
+		"\tlinux $bootprefix/$mariner_linux root=$rootdevice\n" +
+		"}\n" +
+		"menuentry \"CBL-Mariner (rescue)\" {\n" +
+		"\tlinux $bootprefix/$mariner_linux root=$rootdevice systemd.unit=emergency.target\n" +
+		"}\n"
+	grubPassword := configuration.GrubPassword{
+		Username:     "kioskadmin",
+		PasswordHash: "grub.pbkdf2.sha512.10000.AB12.CD34",
+	}
+	rescueEntry := configuration.RescueBootEntry{Enable: true}
+
+	expected := "set superusers=\"kioskadmin\"\n" +
+		"export superusers\n" +
+		"password_pbkdf2 kioskadmin grub.pbkdf2.sha512.10000.AB12.CD34\n" +
+		"set timeout=0\n" +
+		"menuentry \"CBL-Mariner\" --unrestricted {\n" +
+		"\tlinux $bootprefix/$mariner_linux root=$rootdevice\n" +
+		"}\n" +
+		"menuentry \"CBL-Mariner (rescue)\" {\n" +
+		"\tlinux $bootprefix/$mariner_linux root=$rootdevice systemd.unit=emergency.target\n" +
+		"}\n"
+
+	assert.Equal(t, expected, renderGrubCfgPassword(grubCfg, grubPassword, rescueEntry))
+}
+
+func TestShouldValidateRenderedGrubCfg(t *testing.T) {
+	validGrubCfg := "set timeout=3\nmenuentry \"Custom\" {\n\tlinux /boot/vmlinuz root=PARTUUID=1234 quiet\n\tinitrd /boot/initrd.img\n}\n"
+	assert.NoError(t, validateRenderedGrubCfg(validGrubCfg))
+
+	noLinuxGrubCfg := "set timeout=3\nmenuentry \"Custom\" {\n\tchainloader +1\n}\n"
+	err := validateRenderedGrubCfg(noLinuxGrubCfg)
+	assert.EqualError(t, err, "no 'linux' line found to boot a kernel")
+
+	unknownPlaceholderGrubCfg := "menuentry \"Custom\" {\n\tlinux /boot/vmlinuz {{.RootHash}}\n}\n"
+	err = validateRenderedGrubCfg(unknownPlaceholderGrubCfg)
+	assert.EqualError(t, err, "unknown placeholder on line 2: linux /boot/vmlinuz {{.RootHash}}")
+}
+
+func TestShouldAddVerityToolsToRequiredPackages(t *testing.T) {
+	systemConfig := configuration.SystemConfig{
+		ReadOnlyVerityRoot: configuration.ReadOnlyVerityRoot{
+			Enable:              true,
+			RootHashSigningKey:  "verity.key",
+			RootHashSigningCert: "verity.crt",
+		},
+	}
+
+	requiredPackages := GetRequiredPackagesForSystemConfig(systemConfig)
+	requiredNames := []string{}
+	for _, requiredPackage := range requiredPackages {
+		requiredNames = append(requiredNames, requiredPackage.Name)
+	}
+
+	assert.Equal(t, len(GetRequiredPackagesForInstall())+3, len(requiredPackages))
+	assert.Subset(t, requiredNames, []string{"device-mapper", "veritysetup", "openssl"})
+}
+
+func TestShouldRenderSortedSysctlConf(t *testing.T) {
+	settings := map[string]string{
+		"vm.swappiness":       "10",
+		"net.ipv4.ip_forward": "1",
+	}
+
+	expected := "# Generated from the image configuration's Sysctl settings\n" +
+		"net.ipv4.ip_forward = 1\n" +
+		"vm.swappiness = 10\n"
+
+	assert.Equal(t, expected, renderSysctlConf(settings))
+}
+
+func TestShouldRenderChronyConf(t *testing.T) {
+	chronyConf := "# Use public servers\npool 2.pool.ntp.org iburst\ndriftfile /var/lib/chrony/drift\n"
+
+	expected := "# Use public servers\n" +
+		"# pool 2.pool.ntp.org iburst\n" +
+		"driftfile /var/lib/chrony/drift\n" +
+		"\n# Generated from the image configuration's NtpServers settings\n" +
+		"server time.windows.com iburst\n" +
+		"server 10.0.0.1 iburst\n"
+
+	assert.Equal(t, expected, renderChronyConf(chronyConf, []string{"time.windows.com", "10.0.0.1"}))
+}
+
+func TestShouldRenderTimesyncdConf(t *testing.T) {
+	expected := "# Generated from the image configuration's NtpServers settings\n" +
+		"[Time]\n" +
+		"NTP=time.windows.com 10.0.0.1\n"
+
+	assert.Equal(t, expected, renderTimesyncdConf([]string{"time.windows.com", "10.0.0.1"}))
+}
+
+func TestShouldFindNftablesRulesetPath(t *testing.T) {
+	const nftablesUnit = "[Service]\nType=oneshot\nExecStart=/sbin/nft -f /etc/sysconfig/nftables.conf\nExecStop=/sbin/nft flush ruleset\n"
+
+	assert.Equal(t, "/etc/sysconfig/nftables.conf", nftablesRulesetPath(nftablesUnit))
+	assert.Equal(t, "", nftablesRulesetPath("[Service]\nExecStart=/sbin/nft -f\n"))
+	assert.Equal(t, "", nftablesRulesetPath("[Service]\nExecStart=\n"))
+}
+
+func TestShouldRenderNftablesRuleset(t *testing.T) {
+	ruleset := renderNftablesRuleset([]string{"22/tcp", "60000-61000/udp", "443/tcp"})
+
+	assert.Contains(t, ruleset, "type filter hook input priority 0; policy drop;\n")
+	assert.Contains(t, ruleset, "\t\ttcp dport { 22, 443 } accept\n\t\tudp dport { 60000-61000 } accept\n")
+	assert.NotContains(t, ruleset, "sctp")
+}
+
+func TestShouldRenderJournaldConf(t *testing.T) {
+	settings := configuration.Journald{Storage: "persistent", SystemMaxUse: "200M"}
+
+	expected := "# Generated from the image configuration's Journald settings\n" +
+		"[Journal]\n" +
+		"Storage=persistent\n" +
+		"SystemMaxUse=200M\n"
+
+	assert.Equal(t, expected, renderJournaldConf(settings))
+}
+
+func TestShouldLinkDefaultTarget(t *testing.T) {
+	installRoot := t.TempDir()
+	targetUnit := filepath.Join(installRoot, "usr/lib/systemd/system/multi-user.target")
+	defaultTarget := filepath.Join(installRoot, "etc/systemd/system/default.target")
+
+	assert.NoError(t, os.MkdirAll(filepath.Dir(targetUnit), os.ModePerm))
+	assert.NoError(t, os.WriteFile(targetUnit, []byte("[Unit]\n"), 0644))
+	assert.NoError(t, os.MkdirAll(filepath.Dir(defaultTarget), os.ModePerm))
+	assert.NoError(t, os.Symlink("/usr/lib/systemd/system/graphical.target", defaultTarget))
+
+	assert.NoError(t, configureDefaultTarget(installRoot, "multi-user.target"))
+
+	linkTarget, err := os.Readlink(defaultTarget)
+	assert.NoError(t, err)
+	assert.Equal(t, "/usr/lib/systemd/system/multi-user.target", linkTarget)
+
+	assert.Error(t, configureDefaultTarget(installRoot, "graphical.target"))
+}
+
+func TestShouldNormalizeTimestamps(t *testing.T) {
+	const epoch = 1700000000
+
+	installRoot := t.TempDir()
+	regularFile := filepath.Join(installRoot, "etc/hostname")
+	symlink := filepath.Join(installRoot, "etc/localtime")
+	skippedFile := filepath.Join(installRoot, "proc/version")
+
+	for _, path := range []string{regularFile, skippedFile} {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), os.ModePerm))
+		assert.NoError(t, os.WriteFile(path, []byte("contents"), 0644))
+	}
+	assert.NoError(t, os.Symlink("/usr/share/zoneinfo/UTC", symlink))
+
+	assert.NoError(t, NormalizeTimestamps(installRoot, epoch))
+
+	for _, path := range []string{regularFile, symlink, filepath.Dir(regularFile), installRoot} {
+		info, err := os.Lstat(path)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(epoch), info.ModTime().Unix(), path)
+	}
+
+	info, err := os.Lstat(skippedFile)
+	assert.NoError(t, err)
+	assert.NotEqual(t, int64(epoch), info.ModTime().Unix())
+}
+
+func TestShouldRenderSudoers(t *testing.T) {
+	rules := []configuration.SudoersRule{
+		{Group: "wheel"},
+		{
+			User:       "operator",
+			RunAs:      "root",
+			Commands:   []string{"/usr/bin/systemctl restart kiosk.service", "/usr/sbin/reboot"},
+			NoPassword: true,
+		},
+	}
+
+	expected := "# Generated from the image configuration's SudoersRules\n" +
+		"%wheel ALL=(ALL) ALL\n" +
+		"operator ALL=(root) NOPASSWD: /usr/bin/systemctl restart kiosk.service, /usr/sbin/reboot\n"
+
+	assert.Equal(t, expected, renderSudoers(rules))
+}
+
+func TestShouldFindKernelVersionInPackageFiles(t *testing.T) {
+	packageFiles := "/boot/.vmlinuz-5.15.80.1-1.cm2.hmac\n" +
+		"/boot/System.map-5.15.80.1-1.cm2\n" +
+		"/boot/linux-5.15.80.1-1.cm2.cfg\n" +
+		"/boot/vmlinuz-5.15.80.1-1.cm2\n"
+
+	assert.Equal(t, "5.15.80.1-1.cm2", kernelVersionFromPackageFiles(packageFiles))
+	assert.Equal(t, "", kernelVersionFromPackageFiles("/usr/bin/bash\n"))
+}
+
+func TestShouldDiffChangeSnapshots(t *testing.T) {
+	before := changeSnapshot{
+		packages: map[string]string{
+			"bash.x86_64":    "5.1.8-1.cm2",
+			"openssl.x86_64": "1.1.1k-20.cm2",
+			"vim.x86_64":     "9.0.0-1.cm2",
+		},
+		files: map[string]string{
+			"/etc/hostname": "-rw-r--r-- aaaa",
+			"/etc/motd":     "-rw-r--r-- bbbb",
+		},
+	}
+	after := changeSnapshot{
+		packages: map[string]string{
+			"bash.x86_64":    "5.1.8-1.cm2",
+			"openssl.x86_64": "1.1.1k-21.cm2",
+			"nginx.x86_64":   "1.22.0-1.cm2",
+		},
+		files: map[string]string{
+			"/etc/hostname":    "-rw-r--r-- cccc",
+			"/etc/nginx/nginx": "-rw-r--r-- dddd",
+		},
+	}
+
+	report := diffChangeSnapshots(before, after)
+	assert.Equal(t, []string{"nginx.x86_64"}, report.Packages.Added)
+	assert.Equal(t, []string{"vim.x86_64"}, report.Packages.Removed)
+	assert.Equal(t, []packageChange{{Name: "openssl.x86_64", Before: "1.1.1k-20.cm2", After: "1.1.1k-21.cm2"}}, report.Packages.Changed)
+	assert.Equal(t, &fileChanges{
+		Added:   []string{"/etc/nginx/nginx"},
+		Removed: []string{"/etc/motd"},
+		Changed: []string{"/etc/hostname"},
+	}, report.Files)
+
+	before.files = nil
+	assert.Nil(t, diffChangeSnapshots(before, after).Files)
+}
+
+func TestShouldSnapshotFiles(t *testing.T) {
+	installRoot := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(installRoot, "etc"), os.ModePerm))
+	assert.NoError(t, os.MkdirAll(filepath.Join(installRoot, "proc/1"), os.ModePerm))
+	assert.NoError(t, os.WriteFile(filepath.Join(installRoot, "etc/hostname"), []byte("kiosk\n"), 0644))
+	assert.NoError(t, os.Symlink("/usr/share/zoneinfo/UTC", filepath.Join(installRoot, "etc/localtime")))
+
+	files, err := snapshotFiles(installRoot)
+	assert.NoError(t, err)
+	assert.Contains(t, files, "/etc/hostname")
+	assert.Contains(t, files, "/etc/localtime")
+	assert.Contains(t, files["/etc/localtime"], "-> /usr/share/zoneinfo/UTC")
+	assert.NotContains(t, files, "/proc/1")
+	assert.Contains(t, files["/etc/hostname"], fmt.Sprintf(" %d:%d ", os.Getuid(), os.Getgid()))
+
+	before := files["/etc/hostname"]
+	assert.NoError(t, os.WriteFile(filepath.Join(installRoot, "etc/hostname"), []byte("kiosk2\n"), 0644))
+	files, err = snapshotFiles(installRoot)
+	assert.NoError(t, err)
+	assert.NotEqual(t, before, files["/etc/hostname"])
+}
+
+func TestShouldSetRootFstabReadOnly(t *testing.T) {
+	fstab := []string{
+		"# comment / ext4 defaults 0 0",
+		"UUID=1234 / ext4 defaults,rw 0 1",
+		"UUID=5678 /boot ext4 defaults 0 2",
+	}
+
+	updated, err := setRootFstabReadOnly(fstab)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"# comment / ext4 defaults 0 0",
+		"UUID=1234 / ext4 defaults,ro 0 1",
+		"UUID=5678 /boot ext4 defaults 0 2",
+	}, updated)
+
+	_, err = setRootFstabReadOnly(fstab[2:])
+	assert.Error(t, err)
+}
+
+func TestShouldRenderRootOverlayScript(t *testing.T) {
+	readOnlyRoot := configuration.ReadOnlyRoot{
+		Enable:       true,
+		WritableDirs: []string{"/var"},
+		OverlaySize:  "20%",
+	}
+
+	expected := "#!/bin/sh\n" +
+		"# Generated from the image configuration's ReadOnlyRoot settings\n" +
+		"set -e\n" +
+		"mkdir -p '/run/imager-overlays'\n" +
+		"mount -t tmpfs -o mode=0755,size=20% imager-overlays '/run/imager-overlays'\n" +
+		"mkdir -p '/var' '/run/imager-overlays/var/upper' '/run/imager-overlays/var/work'\n" +
+		"mount -t overlay overlay -o 'lowerdir=/var,upperdir=/run/imager-overlays/var/upper,workdir=/run/imager-overlays/var/work' '/var'\n"
+	assert.Equal(t, expected, renderRootOverlayScript(readOnlyRoot))
+
+	readOnlyRoot.OverlaySize = ""
+	readOnlyRoot.PersistentDir = "/data"
+	script := renderRootOverlayScript(readOnlyRoot)
+	assert.NotContains(t, script, "tmpfs")
+	assert.Contains(t, script, "upperdir=/data/.overlays/var/upper")
+	assert.Contains(t, renderRootOverlayService(readOnlyRoot, "/script"), "RequiresMountsFor=/data\n")
+}
+
+func TestShouldRenderBuildMetadata(t *testing.T) {
+	buildTime := time.Unix(1700000000, 0).UTC()
+
+	metadata, err := renderBuildMetadata("kiosk", "sha256:0123", "2.0.20231114", buildTime)
+	assert.NoError(t, err)
+
+	var parsed buildMetadata
+	assert.NoError(t, json.Unmarshal(metadata, &parsed))
+	assert.Equal(t, "kiosk", parsed.ConfigName)
+	assert.Equal(t, "sha256:0123", parsed.ConfigHash)
+	assert.Equal(t, "2.0.20231114", parsed.ToolVersion)
+	assert.Equal(t, "2023-11-14T22:13:20Z", parsed.BuildTime)
+}
+
+func TestShouldHashRawConfigFiles(t *testing.T) {
+	configDir := t.TempDir()
+	configFile := filepath.Join(configDir, "kiosk.json")
+	overlayFile := filepath.Join(configDir, "overlay.json")
+	err := os.WriteFile(configFile, []byte("{\"SystemConfigs\": []}\n"), 0644)
+	assert.NoError(t, err)
+	err = os.WriteFile(overlayFile, []byte("{}\n"), 0644)
+	assert.NoError(t, err)
+
+	// The same digest as sha256sum prints for the file
+	configHash, err := ConfigFilesHash([]string{configFile})
+	assert.NoError(t, err)
+	assert.Equal(t, "sha256:339adb6b52beb07a15ab6ed810960314baccfa94f5f0cc3da88ed1555f189cf2", configHash)
+
+	layeredHash, err := ConfigFilesHash([]string{configFile, overlayFile})
+	assert.NoError(t, err)
+	assert.Equal(t, "sha256:f30b252583d22de23bfe4bdb6ea637b1fa80b7c5e8e7a4f377bea6e34b550fb0", layeredHash)
+
+	_, err = ConfigFilesHash([]string{filepath.Join(configDir, "missing.json")})
+	assert.Error(t, err)
+}
+
+func TestShouldCollectPackagePins(t *testing.T) {
+	pins, err := packagePins([]string{"core-packages-base-image", "openssl=1.1.1k-21", "curl>=7.76", "zlib = 1:1.2.11-4"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"openssl": "1.1.1k-21", "zlib": "1:1.2.11-4"}, pins)
+
+	_, err = packagePins([]string{"openssl=1.1.1k-21", "openssl=1.1.1k-22"})
+	assert.Error(t, err)
+}
+
+func TestShouldMatchPinnedVersions(t *testing.T) {
+	assert.True(t, pinMatches("1.1.1k", "(none)", "1.1.1k", "21.cm2"))
+	assert.True(t, pinMatches("1.1.1k-21", "(none)", "1.1.1k", "21.cm2"))
+	assert.True(t, pinMatches("1.1.1k-21.cm2", "0", "1.1.1k", "21.cm2"))
+	assert.True(t, pinMatches("1:1.2.11-4", "1", "1.2.11", "4.cm2"))
+	assert.False(t, pinMatches("1.1.1k-2", "(none)", "1.1.1k", "21.cm2"))
+	assert.False(t, pinMatches("1.1.1", "(none)", "1.1.1k", "21.cm2"))
+	assert.False(t, pinMatches("1.2.11-4", "1", "1.2.11", "4.cm2"))
+}
+
+func TestShouldAddPamFaillockAroundPamUnix(t *testing.T) {
+	systemAuth := "# Begin /etc/pam.d/system-auth\n" +
+		"auth      required    pam_env.so\n" +
+		"auth      required    pam_unix.so nullok\n" +
+		"# End /etc/pam.d/system-auth\n"
+
+	expected := "# Begin /etc/pam.d/system-auth\n" +
+		"auth required pam_faillock.so preauth\n" +
+		"auth      required    pam_env.so\n" +
+		"auth [success=1 default=bad] pam_unix.so nullok\n" +
+		"auth [default=die] pam_faillock.so authfail\n" +
+		"auth required pam_faillock.so authsucc\n" +
+		"# End /etc/pam.d/system-auth\n"
+
+	edited, err := addPamFaillockAuth(systemAuth)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, edited)
+
+	editedAgain, err := addPamFaillockAuth(edited)
+	assert.NoError(t, err)
+	assert.Equal(t, edited, editedAgain)
+
+	sufficientAuth, err := addPamFaillockAuth("auth sufficient pam_unix.so\nauth required pam_deny.so\n")
+	assert.NoError(t, err)
+	assert.Contains(t, sufficientAuth, "auth sufficient pam_faillock.so authsucc\n")
+
+	_, err = addPamFaillockAuth("auth required pam_sss.so\n")
+	assert.Error(t, err)
+}
+
+func TestShouldAddPamPwqualityBeforePamUnix(t *testing.T) {
+	systemPassword := "password  required    pam_unix.so sha512 shadow\n"
+
+	edited, err := addPamPwquality(systemPassword)
+	assert.NoError(t, err)
+	assert.Equal(t, "password requisite pam_pwquality.so\npassword  required    pam_unix.so sha512 shadow use_authtok\n", edited)
+
+	account, err := addPamFaillockAccount("account   required    pam_unix.so\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "account required pam_faillock.so\naccount   required    pam_unix.so\n", account)
+}
+
+func TestShouldRenderFaillockConf(t *testing.T) {
+	existing := "# deny = 3\ndeny = 3\n# unlock_time = 600\n"
+	settings := configuration.PamFaillock{Deny: 5, UnlockTime: 900}
+
+	expected := "# deny = 3\n" +
+		"# deny = 3\n" +
+		"# unlock_time = 600\n" +
+		"# Generated from the image configuration's Pam settings\n" +
+		"deny = 5\n" +
+		"unlock_time = 900\n"
+	assert.Equal(t, expected, renderFaillockConf(existing, settings))
+	assert.Equal(t, "# Generated from the image configuration's Pam settings\nminlen = 14\nretry = 3\n", renderPwqualityConf(map[string]string{"retry": "3", "minlen": "14"}))
+}
+
+func TestShouldParseAccountFiles(t *testing.T) {
+	passwd := "root:x:0:0:root:/root:/bin/bash\n" +
+		"vendor:x:1000:1000::/home/vendor:/bin/bash\n"
+
+	entries, err := parseAccountFile(passwd)
+	assert.NoError(t, err)
+	assert.Equal(t, []accountEntry{{name: "root", id: 0, gid: 0, home: "/root"}, {name: "vendor", id: 1000, gid: 1000, home: "/home/vendor"}}, entries)
+
+	entry, found := findAccount(entries, "vendor")
+	assert.True(t, found)
+	assert.Equal(t, 1000, entry.id)
+
+	groups, err := parseAccountFile("wheel:x:10:vendor\n")
+	assert.NoError(t, err)
+	assert.Equal(t, []accountEntry{{name: "wheel", id: 10, gid: -1}}, groups)
+
+	_, err = parseAccountFile("vendor:x:abc:1000::/home/vendor:/bin/bash\n")
+	assert.Error(t, err)
+}
+
+func TestShouldParseLoginDefsID(t *testing.T) {
+	loginDefs := "# UID_MIN 100\n" +
+		"UID_MIN\t\t\t  500\n" +
+		"GID_MIN\t\t\t  abc\n" +
+		"UID_MIN\t\t\t  2000\n"
+
+	uidMin, err := parseLoginDefsID(loginDefs, "UID_MIN")
+	assert.NoError(t, err)
+	assert.Equal(t, 2000, uidMin)
+
+	_, err = parseLoginDefsID(loginDefs, "GID_MIN")
+	assert.Error(t, err)
+
+	uidMin, err = parseLoginDefsID("PASS_MAX_DAYS 90\n", "UID_MIN")
+	assert.NoError(t, err)
+	assert.Equal(t, defaultFirstRegularAccountID, uidMin)
+}
+
+func TestShouldRenderAuthorizedKeys(t *testing.T) {
+	const heading = "# Added keys"
+
+	assert.Equal(t, "# Added keys\nssh-ed25519 AAAA one\n", renderAuthorizedKeys("", heading, []string{"ssh-ed25519 AAAA one"}))
+
+	existing := "ssh-ed25519 AAAA one"
+	expected := "ssh-ed25519 AAAA one\n# Added keys\nssh-ed25519 BBBB two\n"
+	assert.Equal(t, expected, renderAuthorizedKeys(existing, heading, []string{"ssh-ed25519 AAAA one", "ssh-ed25519 BBBB two", "ssh-ed25519 BBBB two"}))
+	assert.Equal(t, "ssh-ed25519 AAAA one\n", renderAuthorizedKeys(existing, heading, []string{"ssh-ed25519 AAAA one"}))
+}
+
+func TestShouldRenderGrubCfgTheme(t *testing.T) {
+	grubCfg := "set bootprefix=/boot\n" +
+		"menuentry \"CBL-Mariner\" {\n" +
+		"\tlinux $bootprefix/$mariner_linux root=$rootdevice\n" +
+		"}\n"
+	grubTheme := configuration.GrubTheme{
+		Directory:  "/tmp/grubtheme/contoso",
+		Background: "/tmp/grubtheme/splash.jpg",
+	}
+
+	expected := "set bootprefix=/boot\n" +
+		"insmod all_video\n" +
+		"insmod gfxterm\n" +
+		"set gfxmode=auto\n" +
+		"terminal_output gfxterm\n" +
+		"insmod png\n" +
+		"set theme=$bootprefix/grub2/themes/contoso/theme.txt\n" +
+		"export theme\n" +
+		"insmod jpeg\n" +
+		"background_image $bootprefix/grub2/splash.jpg\n" +
+		"menuentry \"CBL-Mariner\" {\n" +
+		"\tlinux $bootprefix/$mariner_linux root=$rootdevice\n" +
+		"}\n"
+	assert.Equal(t, expected, renderGrubCfgTheme(grubCfg, grubTheme))
+}
+
+func TestShouldRenderGrubDefaults(t *testing.T) {
+	grubDefaults := "GRUB_TIMEOUT=5\n#GRUB_THEME=\"/boot/grub2/themes/old/theme.txt\"\nGRUB_THEME=\"/boot/grub2/themes/old/theme.txt\"\n"
+	settings := map[string]string{
+		"GRUB_THEME":      "/boot/grub2/themes/contoso/theme.txt",
+		"GRUB_BACKGROUND": "/boot/grub2/splash.jpg",
+	}
+
+	expected := "GRUB_TIMEOUT=5\n" +
+		"#GRUB_THEME=\"/boot/grub2/themes/old/theme.txt\"\n" +
+		"GRUB_THEME=\"/boot/grub2/themes/contoso/theme.txt\"\n" +
+		"GRUB_BACKGROUND=\"/boot/grub2/splash.jpg\"\n"
+	assert.Equal(t, expected, renderGrubDefaults(grubDefaults, settings))
+}
+
+func TestShouldParseRepoGpgCheck(t *testing.T) {
+	lines := []string{
+		"[mariner-official-base]",
+		"gpgcheck=1",
+		"[local-repo]",
+		"# gpgcheck=1",
+		"gpgcheck = 0",
+		"[mariner-preview]",
+		"enabled=0",
+		"gpgcheck=0",
+		"[defaults]",
+	}
+
+	expected := []repoGpgCheck{
+		{id: "mariner-official-base", enabled: true, gpgCheck: true},
+		{id: "local-repo", enabled: true, gpgCheck: false},
+		{id: "mariner-preview", enabled: false, gpgCheck: false},
+		{id: "defaults", enabled: true, gpgCheck: true},
+	}
+	assert.Equal(t, expected, parseRepoGpgCheck(lines))
+}
+
+func TestShouldFailVerifyingRepoWithoutGpgCheck(t *testing.T) {
+	buildRoot := t.TempDir()
+	baseImageRoot := t.TempDir()
+	for _, root := range []string{buildRoot, baseImageRoot} {
+		err := os.MkdirAll(filepath.Join(root, repoFilesDir), os.ModePerm)
+		assert.NoError(t, err)
+	}
+
+	err := os.WriteFile(filepath.Join(buildRoot, repoFilesDir, "mariner.repo"), []byte("[mariner]\ngpgcheck=1\n"), 0644)
+	assert.NoError(t, err)
+	assert.NoError(t, verifyRepoGpgCheck(buildRoot, baseImageRoot))
+
+	baseImageRepoFile := filepath.Join(baseImageRoot, repoFilesDir, "vendor.repo")
+	err = os.WriteFile(baseImageRepoFile, []byte("[vendor]\ngpgcheck=0\n"), 0644)
+	assert.NoError(t, err)
+	err = verifyRepoGpgCheck(buildRoot, baseImageRoot)
+	assert.EqualError(t, err, "1 enabled repository(s) do not check package signatures (gpgcheck=0): vendor ("+baseImageRepoFile+")")
+}
+
+func TestShouldRenderFirmwareDracutConf(t *testing.T) {
+	expected := "install_items+=\" /lib/firmware/iwlwifi-cc-a0-77.ucode /lib/firmware/amd-ucode/microcode_amd.bin \"\n"
+	assert.Equal(t, expected, renderFirmwareDracutConf([]string{"iwlwifi-cc-a0-77.ucode", "amd-ucode/microcode_amd.bin"}))
+}
+
+func TestShouldRenderLogrotateRule(t *testing.T) {
+	rule := configuration.LogrotateRule{
+		Name:       "contoso-agent",
+		Paths:      []string{"/var/log/contoso/*.log", "/var/log/contoso-agent.log"},
+		Frequency:  "daily",
+		Rotate:     7,
+		MaxSize:    "100M",
+		Compress:   true,
+		NotIfEmpty: true,
+		Options:    []string{"create 0640 root root"},
+	}
+
+	expected := "# Generated from the image configuration's LogrotateRules\n" +
+		"/var/log/contoso/*.log /var/log/contoso-agent.log {\n" +
+		"    daily\n" +
+		"    rotate 7\n" +
+		"    maxsize 100M\n" +
+		"    compress\n" +
+		"    notifempty\n" +
+		"    create 0640 root root\n" +
+		"}\n"
+	assert.Equal(t, expected, renderLogrotateRule(rule))
+
+	rule.Frequency = ""
+	assert.Contains(t, renderLogrotateRule(rule), "    size 100M\n")
+}
+
+func TestShouldCheckEspSpace(t *testing.T) {
+	nearlyFull, err := espSpaceIsValid(1000, 500, 100)
+	assert.NoError(t, err)
+	assert.False(t, nearlyFull)
+
+	nearlyFull, err = espSpaceIsValid(1000, 150, 100)
+	assert.NoError(t, err)
+	assert.True(t, nearlyFull)
+
+	_, err = espSpaceIsValid(1000, 50, 100)
+	assert.Error(t, err)
+	assert.Equal(t, "the files merged into the ESP need 100 blocks but only 50 are free", err.Error())
+}
+
+func TestShouldFailMergingExistingEspFile(t *testing.T) {
+	espPath := t.TempDir()
+	err := os.MkdirAll(filepath.Join(espPath, "EFI/BOOT"), os.ModePerm)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(espPath, "EFI/BOOT/bootx64.efi"), []byte("shim"), 0600)
+	assert.NoError(t, err)
+
+	source := filepath.Join(t.TempDir(), "bootx64.efi")
+	err = os.WriteFile(source, []byte("loader"), 0600)
+	assert.NoError(t, err)
+
+	err = mergeEspFiles(espPath, []configuration.EspFile{{Source: source, Path: "EFI/BOOT/bootx64.efi"}})
+	assert.Error(t, err)
+	assert.Equal(t, "(EFI/BOOT/bootx64.efi) is already installed in the ESP, files are merged without overwriting", err.Error())
+
+	err = mergeEspFiles(espPath, []configuration.EspFile{{Source: source, Path: "EFI/contoso/loader.efi"}})
+	assert.NoError(t, err)
+	contents, err := os.ReadFile(filepath.Join(espPath, "EFI/contoso/loader.efi"))
+	assert.NoError(t, err)
+	assert.Equal(t, "loader", string(contents))
+}
+
+func TestShouldHashDeltaBaseAndTarget(t *testing.T) {
+	tmpDir := t.TempDir()
+	fromPath := filepath.Join(tmpDir, "from.raw")
+	toPath := filepath.Join(tmpDir, "to.raw")
+	err := os.WriteFile(fromPath, []byte("old"), 0600)
+	assert.NoError(t, err)
+	err = os.WriteFile(toPath, []byte("new!"), 0600)
+	assert.NoError(t, err)
+
+	metadata, err := newDeltaMetadata(fromPath, toPath)
+	assert.NoError(t, err)
+	assert.Equal(t, DeltaMetadata{
+		Algorithm:  "zstd",
+		WindowLog:  31,
+		FromSha256: "cba06b5736faf67e54b07b561eae94395e774c517a7d910a54369e1263ccfbd4",
+		FromSize:   3,
+		ToSha256:   "bdd1e524e5c90bee91a4f1ac4a087ca0012e36235ab24b5136d2a6388e7ad58b",
+		ToSize:     4,
+	}, metadata)
+}
+
+func TestShouldCheckZstdPatchBaseSize(t *testing.T) {
+	assert.NoError(t, checkZstdPatchBaseSize("base.ext4", 1<<31))
+
+	err := checkZstdPatchBaseSize("base.ext4", 1<<31+1)
+	assert.Error(t, err)
+	assert.Equal(t, "[DeltaBaseImage] (base.ext4) is 2147483649 bytes, a zstd patch can only reference the first 2147483648 bytes of its base", err.Error())
+}
+
+func TestShouldRenderKdumpConf(t *testing.T) {
+	kdumpConf := "# kdump.conf\n" +
+		"#nfs my.server.com:/export/tmp\n" +
+		"ext4 /dev/vg/lv_kdump\n" +
+		"path /var/crash\n" +
+		"core_collector makedumpfile -l --message-level 7 -d 31\n"
+	kdump := configuration.Kdump{
+		Enable:      true,
+		CrashKernel: "256M",
+		Path:        "/crash",
+		Target:      "nfs server:/export/crash",
+	}
+
+	expected := "# kdump.conf\n" +
+		"#nfs my.server.com:/export/tmp\n" +
+		"#ext4 /dev/vg/lv_kdump\n" +
+		"#path /var/crash\n" +
+		"core_collector makedumpfile -l --message-level 7 -d 31\n" +
+		"\n" +
+		"# Set by the image configuration's Kdump\n" +
+		"nfs server:/export/crash\n" +
+		"path /crash\n"
+	assert.Equal(t, expected, renderKdumpConf(kdumpConf, kdump))
+}
+
+func TestShouldReadGrubCfgKernelArgs(t *testing.T) {
+	grubCfg := "set timeout=0\n" +
+		"set bootprefix=/boot\n" +
+		"set rootdevice=PARTUUID=1234\n" +
+		"menuentry \"CBL-Mariner\" {\n" +
+		"\tlinux $bootprefix/$mariner_linux   rd.auto=1 root=$rootdevice $mariner_cmdline $systemd_cmdline console=ttyS0\n" +
+		"}\n"
+	grubEnv := map[string]string{
+		"mariner_linux":   "vmlinuz-5.10",
+		"mariner_cmdline": "init=/lib/systemd/systemd ro loglevel=3",
+		"systemd_cmdline": "",
+	}
+
+	cmdline, err := grubCfgKernelArgs(grubCfg, grubEnv)
+	assert.NoError(t, err)
+	assert.Equal(t, "rd.auto=1 root=PARTUUID=1234 init=/lib/systemd/systemd ro loglevel=3 console=ttyS0", cmdline)
+
+	_, err = grubCfgKernelArgs("set timeout=0\n", grubEnv)
+	assert.Error(t, err)
+}
+
+func TestShouldRenderNewGrubDefaults(t *testing.T) {
+	settings := map[string]string{
+		"GRUB_TIMEOUT":       "5",
+		"GRUB_CMDLINE_LINUX": "root=PARTUUID=1234 ro",
+	}
+
+	expected := "GRUB_CMDLINE_LINUX=\"root=PARTUUID=1234 ro\"\n" +
+		"GRUB_TIMEOUT=\"5\"\n"
+	assert.Equal(t, expected, renderGrubDefaults("", settings))
+}
+
+func TestShouldDetectInitramfsCompression(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	headers := map[string][]byte{
+		"gzip": {0x1f, 0x8b, 0x08, 0x00},
+		"zstd": {0x28, 0xb5, 0x2f, 0xfd, 0x04},
+		"none": []byte("070701000"),
+	}
+	for name, header := range headers {
+		initrdPath := filepath.Join(tmpDir, name)
+		err := os.WriteFile(initrdPath, header, 0600)
+		assert.NoError(t, err)
+
+		compression, err := detectInitramfsCompression(initrdPath)
+		assert.NoError(t, err)
+		assert.Equal(t, name, compression.name)
+	}
+
+	unknownPath := filepath.Join(tmpDir, "unknown")
+	err := os.WriteFile(unknownPath, []byte("BZh91AY"), 0600)
+	assert.NoError(t, err)
+	_, err = detectInitramfsCompression(unknownPath)
+	assert.Error(t, err)
+}
+
+func TestShouldListInitramfsFiles(t *testing.T) {
+	rootDir := t.TempDir()
+	err := os.MkdirAll(filepath.Join(rootDir, "usr/lib/firmware"), os.ModePerm)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(rootDir, "usr/lib/firmware/contoso.bin"), []byte("fw"), 0644)
+	assert.NoError(t, err)
+	err = os.Symlink("usr/lib", filepath.Join(rootDir, "lib"))
+	assert.NoError(t, err)
+
+	fileList, err := initramfsFileList(rootDir)
+	assert.NoError(t, err)
+	assert.Equal(t, "lib\nusr\nusr/lib\nusr/lib/firmware\nusr/lib/firmware/contoso.bin\n", fileList)
+}
+
+func TestShouldRenderNetworkdFiles(t *testing.T) {
+	connections := []configuration.NetworkConnection{
+		{Name: "eth0", MACAddress: "00:15:5d:01:02:03", Addresses: []string{"192.168.1.10/24"}, Gateway: "192.168.1.1", Dns: []string{"192.168.1.1"}},
+		{Name: "eth0.100", Type: configuration.NetworkConnectionTypeVlan, Dhcp: true, VlanID: 100, Parent: "eth0"},
+		{Name: "bond0", Type: configuration.NetworkConnectionTypeBond, Dhcp: true, BondMode: "active-backup", Members: []string{"eth1"}},
+	}
+
+	files := renderNetworkdFiles(connections)
+	assert.Equal(t, map[string]string{
+		"10-imager-eth0.network":     "[Match]\nMACAddress=00:15:5d:01:02:03\n\n[Network]\nAddress=192.168.1.10/24\nGateway=192.168.1.1\nDNS=192.168.1.1\nVLAN=eth0.100\n",
+		"10-imager-eth0.100.netdev":  "[NetDev]\nName=eth0.100\nKind=vlan\n\n[VLAN]\nId=100\n",
+		"10-imager-eth0.100.network": "[Match]\nName=eth0.100\n\n[Network]\nDHCP=yes\n",
+		"10-imager-bond0.netdev":     "[NetDev]\nName=bond0\nKind=bond\n\n[Bond]\nMode=active-backup\n",
+		"10-imager-bond0.network":    "[Match]\nName=bond0\n\n[Network]\nDHCP=yes\n",
+		"10-imager-eth1.network":     "[Match]\nName=eth1\n\n[Network]\nBond=bond0\n",
+	}, files)
+}
+
+func TestShouldRenderNetworkdUnconfiguredVlanParent(t *testing.T) {
+	connections := []configuration.NetworkConnection{
+		{Name: "eth0.7", Type: configuration.NetworkConnectionTypeVlan, Dhcp: true, VlanID: 7, Parent: "eth0"},
+	}
+
+	files := renderNetworkdFiles(connections)
+	assert.Equal(t, "[Match]\nName=eth0\n\n[Network]\nVLAN=eth0.7\n", files["10-imager-eth0.network"])
+}
+
+func TestShouldRenderNetworkManagerKeyfiles(t *testing.T) {
+	connections := []configuration.NetworkConnection{
+		{Name: "eth0", Addresses: []string{"192.168.1.10/24", "fd00::10/64"}, Gateway: "192.168.1.1", Dns: []string{"192.168.1.1", "fd00::1"}},
+		{Name: "bond0", Type: configuration.NetworkConnectionTypeBond, Dhcp: true, BondMode: "802.3ad", Members: []string{"eth1"}},
+	}
+
+	files := renderNetworkManagerKeyfiles(connections)
+	assert.Equal(t, map[string]string{
+		"eth0.nmconnection": "[connection]\nid=eth0\ntype=ethernet\ninterface-name=eth0\n" +
+			"\n[ipv4]\nmethod=manual\naddress1=192.168.1.10/24,192.168.1.1\ndns=192.168.1.1;\n" +
+			"\n[ipv6]\nmethod=manual\naddress1=fd00::10/64\ndns=fd00::1;\n",
+		"bond0.nmconnection": "[connection]\nid=bond0\ntype=bond\ninterface-name=bond0\n" +
+			"\n[bond]\nmode=802.3ad\n" +
+			"\n[ipv4]\nmethod=auto\n" +
+			"\n[ipv6]\nmethod=auto\n",
+		"eth1.nmconnection": "[connection]\nid=eth1\ntype=ethernet\ninterface-name=eth1\nmaster=bond0\nslave-type=bond\n",
+	}, files)
+}
+
+func TestShouldParseRpmFileOwners(t *testing.T) {
+	owners, err := parseRpmFileOwners("filesystem\nsetup\n", "", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"filesystem", "setup"}, owners)
+
+	queryErr := fmt.Errorf("exit status 1")
+	owners, err = parseRpmFileOwners("file /etc/contoso.conf is not owned by any package\n", "", queryErr)
+	assert.NoError(t, err)
+	assert.Empty(t, owners)
+
+	owners, err = parseRpmFileOwners("", "error: file /etc/missing.conf: No such file or directory\n", queryErr)
+	assert.NoError(t, err)
+	assert.Empty(t, owners)
+
+	_, err = parseRpmFileOwners("", "error: cannot open Packages database\n", queryErr)
+	assert.Error(t, err)
+}
+
+func TestShouldGroupMountPointsByParent(t *testing.T) {
+	mountPoints := []string{"/", "/var/log", "/boot/efi", "/var", "/boot", "/home", "/variable", ""}
+
+	byParent := mountPointsByParent(mountPoints)
+	assert.Equal(t, map[string][]string{
+		"/":     {"/boot", "/home", "/var", "/variable"},
+		"/boot": {"/boot/efi"},
+		"/var":  {"/var/log"},
+	}, byParent)
+}
+
+func TestShouldRenderLoginDefs(t *testing.T) {
+	const loginDefs = "# PASS_MAX_DAYS\tMaximum number of days a password may be used.\nPASS_MAX_DAYS\t99999\nPASS_MIN_DAYS\t0\n#UMASK\t022\n"
+
+	rendered := renderLoginDefs(loginDefs, []string{"UMASK", "PASS_MAX_DAYS"}, []string{"027", "90"})
+	assert.Equal(t, "# PASS_MAX_DAYS\tMaximum number of days a password may be used.\nPASS_MAX_DAYS\t90\nPASS_MIN_DAYS\t0\n#UMASK\t022\n"+
+		"\n# Generated from the image configuration's LoginDefs settings\nUMASK\t027\n", rendered)
+}
+
+func TestShouldFailHybridBootWithoutEfiBinaries(t *testing.T) {
+	grubModulesDir := t.TempDir()
+	efiPath := t.TempDir()
+
+	err := os.MkdirAll(filepath.Join(efiPath, "EFI/BOOT"), os.ModePerm)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(efiPath, "EFI/BOOT/bootx64.efi"), []byte("shim"), 0600)
+	assert.NoError(t, err)
+
+	err = checkHybridBootloaderComponents(grubModulesDir, efiPath)
+	assert.Error(t, err)
+	assert.Equal(t, "hybrid boot is missing bootloader components: UEFI bootloader (/EFI/BOOT/grubx64.efi), add 'grub2-efi-binary' to the package lists", err.Error())
+
+	err = os.WriteFile(filepath.Join(efiPath, "EFI/BOOT/grubx64.efi"), []byte("grub"), 0600)
+	assert.NoError(t, err)
+	err = checkHybridBootloaderComponents(grubModulesDir, efiPath)
+	assert.NoError(t, err)
+}
+
+func TestShouldRenderGrowFsMarker(t *testing.T) {
+	assert.Equal(t, "/\n/var\n", renderGrowFsMarker([]string{"/", "/var"}))
+	assert.Contains(t, growFsServiceUnit, "ConditionPathExists=/etc/imager/grow-filesystems\n")
+	assert.Contains(t, growFsScript, "marker=/etc/imager/grow-filesystems\n")
+}
+
+func TestShouldFindKbdFile(t *testing.T) {
+	fontsDir := t.TempDir()
+	err := os.MkdirAll(filepath.Join(fontsDir, "partialfonts"), os.ModePerm)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(fontsDir, "eurlatgr.psfu.gz"), []byte("font"), 0644)
+	assert.NoError(t, err)
+
+	found, err := findKbdFile(fontsDir, "eurlatgr", consoleFontSuffixes)
+	assert.NoError(t, err)
+	assert.True(t, found)
+
+	found, err = findKbdFile(fontsDir, "eurlat", consoleFontSuffixes)
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	found, err = findKbdFile(filepath.Join(fontsDir, "missing"), "eurlatgr", consoleFontSuffixes)
+	assert.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestShouldCheckRpmLead(t *testing.T) {
+	rpmDir := t.TempDir()
+	rpmPath := filepath.Join(rpmDir, "good.rpm")
+	err := os.WriteFile(rpmPath, append([]byte{0xed, 0xab, 0xee, 0xdb}, []byte("rest")...), 0644)
+	assert.NoError(t, err)
+	assert.NoError(t, checkLocalPackages([]string{rpmPath}))
+
+	textPath := filepath.Join(rpmDir, "bad.rpm")
+	err = os.WriteFile(textPath, []byte("not an rpm"), 0644)
+	assert.NoError(t, err)
+	err = checkLocalPackages([]string{rpmPath, textPath})
+	assert.Error(t, err)
+	assert.Equal(t, "local package ("+textPath+") is not an RPM file", err.Error())
+
+	assert.Error(t, checkLocalPackages([]string{filepath.Join(rpmDir, "missing.rpm")}))
+}
+
+func TestShouldParseRpmRequires(t *testing.T) {
+	const output = "/bin/sh\nglibc >= 2.35\nlibc.so.6()(64bit)\n(foo or bar)\nrpmlib(CompressedFileNames) <= 3.0.4-1\n\n"
+
+	requires, err := parseRpmRequires(output)
+	assert.NoError(t, err)
+	assert.Equal(t, []*pkgjson.PackageVer{
+		{Name: "glibc", Condition: ">=", Version: "2.35"},
+		{Name: "libc.so.6()(64bit)"},
+	}, requires)
+}
+
+func TestShouldParseRpmProvides(t *testing.T) {
+	const output = "mytool = 1.0-1\nmytool(x86-64) = 1.0-1\nlibmytool.so.1()(64bit)\n\n"
+
+	provided := parseRpmProvides(output)
+	assert.Equal(t, map[string]bool{
+		"mytool":                  true,
+		"mytool(x86-64)":          true,
+		"libmytool.so.1()(64bit)": true,
+	}, provided)
+}
+
+func TestShouldRenderLoaderConf(t *testing.T) {
+	tests := []struct {
+		name           string
+		existingConfig string
+		systemdBoot    configuration.SystemdBoot
+		expected       string
+	}{
+		{
+			name:        "new file",
+			systemdBoot: configuration.SystemdBoot{DefaultEntry: "mariner.conf", Timeout: "5"},
+			expected:    "default mariner.conf\ntimeout 5\n",
+		},
+		{
+			name:           "replaces the configured keys only",
+			existingConfig: "# Written by systemd-boot\ndefault old.conf\ntimeout 0\neditor no\n",
+			systemdBoot:    configuration.SystemdBoot{DefaultEntry: "mariner.conf"},
+			expected:       "# Written by systemd-boot\ntimeout 0\neditor no\ndefault mariner.conf\n",
+		},
+		{
+			name:           "keeps a file without a trailing newline",
+			existingConfig: "console-mode max\ntimeout 3",
+			systemdBoot:    configuration.SystemdBoot{Timeout: "menu-force"},
+			expected:       "console-mode max\ntimeout menu-force\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, renderLoaderConf(test.existingConfig, test.systemdBoot))
+		})
+	}
+}
+
+func TestShouldFindWeakOnlyPackages(t *testing.T) {
+	const requiresOutput = "/bin/sh\nglibc >= 2.35\nlibc.so.6()(64bit)\nrpmlib(PayloadIsZstd) <= 5.4.18-1\n(foo if bar)\nglibc\n"
+	assert.Equal(t, []string{"/bin/sh", "glibc", "libc.so.6()(64bit)"}, capabilityNames(requiresOutput))
+
+	const providersOutput = "glibc\nbash\nno package provides libmissing.so.1\n\n"
+	assert.Equal(t, map[string]bool{"glibc": true, "bash": true}, parseRpmNames(providersOutput))
+
+	packages := []string{"bash", "bash-completion", "man-db", "vim"}
+	recommended := map[string]bool{"bash-completion": true, "man-db": true, "vim": true}
+	required := map[string]bool{"bash": true, "man-db": true}
+	requested := map[string]bool{"vim": true}
+	assert.Equal(t, []string{"bash-completion"}, weakOnlyPackages(packages, recommended, required, requested))
+}
+
+func TestShouldRenderResolvedConf(t *testing.T) {
+	settings := configuration.Resolved{
+		DNS:        []string{"10.0.0.53", "1.1.1.1:853#cloudflare-dns.com"},
+		Domains:    []string{"corp.example.com"},
+		DNSOverTLS: "opportunistic",
+	}
+
+	expected := "# Generated from the image configuration's Resolved settings\n" +
+		"[Resolve]\n" +
+		"DNS=10.0.0.53 1.1.1.1:853#cloudflare-dns.com\n" +
+		"Domains=corp.example.com\n" +
+		"DNSOverTLS=opportunistic\n"
+	assert.Equal(t, expected, renderResolvedConf(settings))
+}
+
+func TestShouldLinkResolvConf(t *testing.T) {
+	installRoot := t.TempDir()
+	err := os.MkdirAll(filepath.Join(installRoot, "etc"), os.ModePerm)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(installRoot, "etc/resolv.conf"), []byte("nameserver 10.0.0.1\n"), 0644)
+	assert.NoError(t, err)
+
+	err = linkResolvConf(installRoot, configuration.ResolvConfStub)
+	assert.NoError(t, err)
+	target, err := os.Readlink(filepath.Join(installRoot, "etc/resolv.conf"))
+	assert.NoError(t, err)
+	assert.Equal(t, "../run/systemd/resolve/stub-resolv.conf", target)
+
+	err = linkResolvConf(installRoot, configuration.ResolvConfUplink)
+	assert.NoError(t, err)
+	target, err = os.Readlink(filepath.Join(installRoot, "etc/resolv.conf"))
+	assert.NoError(t, err)
+	assert.Equal(t, "../run/systemd/resolve/resolv.conf", target)
+}
+
+func TestShouldCheckListedGrubEnv(t *testing.T) {
+	listed := parseGrubEnvList("saved_entry=0\nboot_counter=3\nboot_success=0\n")
+	assert.Equal(t, map[string]string{"saved_entry": "0", "boot_counter": "3", "boot_success": "0"}, listed)
+
+	assert.NoError(t, checkGrubEnv(map[string]string{"boot_counter": "3", "boot_success": "0"}, listed))
+
+	err := checkGrubEnv(map[string]string{"boot_counter": "2"}, listed)
+	assert.Error(t, err)
+	assert.Equal(t, "grubenv variable (boot_counter) is (3) after grub2-editenv, expected (2)", err.Error())
+
+	err = checkGrubEnv(map[string]string{"boot_indeterminate": "0"}, listed)
+	assert.Error(t, err)
+	assert.Equal(t, "grubenv variable (boot_indeterminate) is missing after grub2-editenv", err.Error())
+}
+
+func TestShouldAddGrubEnvLoad(t *testing.T) {
+	const grubCfg = "set bootprefix=/boot\nload_env -f $bootprefix/mariner.cfg\nset rootdevice=PARTUUID=1\n"
+
+	updatedGrubCfg, ok := addGrubEnvLoad(grubCfg)
+	assert.True(t, ok)
+	assert.Equal(t, "set bootprefix=/boot\nload_env -f $bootprefix/mariner.cfg\nload_env -f $bootprefix/grub2/grubenv\nset rootdevice=PARTUUID=1\n", updatedGrubCfg)
+
+	unchangedGrubCfg, ok := addGrubEnvLoad(updatedGrubCfg)
+	assert.True(t, ok)
+	assert.Equal(t, updatedGrubCfg, unchangedGrubCfg)
+
+	_, ok = addGrubEnvLoad("menuentry \"custom\" {\n}\n")
+	assert.False(t, ok)
+}
+
+func TestShouldOnlyExtractRootfsArtifactForTarArtifacts(t *testing.T) {
+	disk := configuration.Disk{
+		Artifacts: []configuration.Artifact{{Name: "core", Type: "vhdx"}},
+	}
+	assert.False(t, hasArtifactType(disk.Artifacts, tarArtifactType))
+	assert.NoError(t, ExtractRootfsArtifact(t.TempDir(), 0, disk, nil, nil, nil))
+
+	disk.Artifacts = append(disk.Artifacts, configuration.Artifact{Name: "core-rootfs", Type: "tar", Compression: "gz"})
+	assert.True(t, hasArtifactType(disk.Artifacts, tarArtifactType))
+
+	err := ExtractRootfsArtifact(t.TempDir(), 0, disk, nil, nil, map[string]*Overlay{"/": {}})
+	assert.Error(t, err)
+	assert.Equal(t, "a tar artifact can't be created for a disk with overlay partitions", err.Error())
+}
+
+func TestShouldRenderEtcOverlayScript(t *testing.T) {
+	readOnlyEtc := configuration.ReadOnlyEtc{
+		Enable:       true,
+		WritableDirs: []string{"/etc/ssh"},
+	}
+
+	expected := "#!/bin/sh\n" +
+		"# Generated from the image configuration's ReadOnlyEtc settings\n" +
+		"set -e\n" +
+		"mount --bind /etc /etc\n" +
+		"mount -o remount,bind,ro /etc\n" +
+		"mkdir -p '/run/imager-etc-overlays'\n" +
+		"mount -t tmpfs -o mode=0755 imager-etc-overlays '/run/imager-etc-overlays'\n" +
+		"mkdir -p '/etc/ssh' '/run/imager-etc-overlays/etc/ssh/upper' '/run/imager-etc-overlays/etc/ssh/work'\n" +
+		"mount -t overlay overlay -o 'lowerdir=/etc/ssh,upperdir=/run/imager-etc-overlays/etc/ssh/upper,workdir=/run/imager-etc-overlays/etc/ssh/work' '/etc/ssh'\n"
+	assert.Equal(t, expected, renderEtcOverlayScript(readOnlyEtc))
+
+	readOnlyEtc.WritableDirs = nil
+	assert.NotContains(t, renderEtcOverlayScript(readOnlyEtc), "overlay")
+}
+
+func TestShouldCheckEtcBootWrites(t *testing.T) {
+	installRoot := t.TempDir()
+	err := os.MkdirAll(filepath.Join(installRoot, "usr/sbin"), os.ModePerm)
+	assert.NoError(t, err)
+	err = os.MkdirAll(filepath.Join(installRoot, "etc/ssh"), os.ModePerm)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(installRoot, "usr/sbin/sshd"), []byte{}, 0755)
+	assert.NoError(t, err)
+
+	readOnlyEtc := configuration.ReadOnlyEtc{Enable: true}
+	err = checkEtcBootWrites(installRoot, readOnlyEtc)
+	assert.Error(t, err)
+	assert.Equal(t, "(/etc/ssh) must be in the [ReadOnlyEtc] [WritableDirs], sshd generates its host keys there at first boot", err.Error())
+
+	readOnlyEtc.WritableDirs = []string{"/etc/ssh"}
+	assert.NoError(t, checkEtcBootWrites(installRoot, readOnlyEtc))
+
+	readOnlyEtc.WritableDirs = nil
+	err = os.WriteFile(filepath.Join(installRoot, "etc/ssh/ssh_host_ed25519_key"), []byte("key"), 0600)
+	assert.NoError(t, err)
+	assert.NoError(t, checkEtcBootWrites(installRoot, readOnlyEtc))
+}
+
+func TestShouldCheckVerityBootPartitions(t *testing.T) {
+	devDir := t.TempDir()
+	rootDev := filepath.Join(devDir, "sda3")
+	bootDev := filepath.Join(devDir, "sda2")
+	espDev := filepath.Join(devDir, "sda1")
+	for _, dev := range []string{rootDev, bootDev, espDev} {
+		err := os.WriteFile(dev, nil, 0600)
+		assert.NoError(t, err)
+	}
+
+	config := configuration.SystemConfig{
+		BootType:           configuration.BootTypeEfi,
+		ReadOnlyVerityRoot: configuration.ReadOnlyVerityRoot{Enable: true},
+	}
+
+	err := CheckVerityBootPartitions(map[string]string{"/": rootDev, "/boot": bootDev, "/boot/efi": espDev}, config)
+	assert.NoError(t, err)
+
+	err = CheckVerityBootPartitions(map[string]string{"/": rootDev, "/boot": bootDev}, config)
+	assert.Error(t, err)
+	assert.Equal(t, "a read-only verity root requires a partition mounted at (/boot/efi), but none was found on the disk", err.Error())
+
+	err = CheckVerityBootPartitions(map[string]string{"/": rootDev, "/boot": rootDev, "/boot/efi": espDev}, config)
+	assert.Error(t, err)
+	assert.Equal(t, fmt.Sprintf("a read-only verity root requires (/boot) on a separate partition from the root, but both are on (%s)", rootDev), err.Error())
+
+	missingDev := filepath.Join(devDir, "sda4")
+	err = CheckVerityBootPartitions(map[string]string{"/": rootDev, "/boot": missingDev, "/boot/efi": espDev}, config)
+	assert.Error(t, err)
+	assert.Equal(t, fmt.Sprintf("the partition mounted at (/boot) for the read-only verity root was not found at (%s)", missingDev), err.Error())
+
+	config.BootType = configuration.BootTypeLegacy
+	err = CheckVerityBootPartitions(map[string]string{"/": rootDev, "/boot": bootDev}, config)
+	assert.NoError(t, err)
+
+	config.ReadOnlyVerityRoot.Enable = false
+	err = CheckVerityBootPartitions(map[string]string{"/": rootDev}, config)
+	assert.NoError(t, err)
+}
+
+func TestShouldReadMokCertificate(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "Contoso MOK"},
+		NotBefore:    time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:     time.Date(2034, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+
+	certDir := t.TempDir()
+	derPath := filepath.Join(certDir, "contoso.der")
+	pemPath := filepath.Join(certDir, "contoso.pem")
+	keyPath := filepath.Join(certDir, "contoso.key")
+	err = os.WriteFile(derPath, der, 0600)
+	assert.NoError(t, err)
+	err = os.WriteFile(pemPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	assert.NoError(t, err)
+	err = os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("key")}), 0600)
+	assert.NoError(t, err)
+
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	readDer, err := readMokCertificate(derPath, now)
+	assert.NoError(t, err)
+	assert.Equal(t, der, readDer)
+
+	readDer, err = readMokCertificate(pemPath, now)
+	assert.NoError(t, err)
+	assert.Equal(t, der, readDer)
+
+	_, err = readMokCertificate(keyPath, now)
+	assert.Error(t, err)
+	assert.Equal(t, fmt.Sprintf("MOK certificate (%s) is a PEM 'PRIVATE KEY', expected a 'CERTIFICATE'", keyPath), err.Error())
+
+	err = os.WriteFile(derPath, []byte("not a certificate"), 0600)
+	assert.NoError(t, err)
+	_, err = readMokCertificate(derPath, now)
+	assert.Error(t, err)
+}
+
+func TestShouldRenderMokEnrollScript(t *testing.T) {
+	certificates := []string{"/usr/share/imager/mok/00-contoso.der", "/usr/share/imager/mok/01-fabrikam.der"}
+
+	assert.Equal(t, "#!/bin/sh\n# Generated from the image configuration's SecureBoot settings\nset -e\n"+
+		"mokutil --import /usr/share/imager/mok/00-contoso.der /usr/share/imager/mok/01-fabrikam.der --hash-file /usr/share/imager/mok/password.hash\n"+
+		"mkdir -p /var/lib/imager\ntouch /var/lib/imager/mok-enrollment-requested\n",
+		renderMokEnrollScript(certificates, "/usr/share/imager/mok/password.hash"))
+
+	assert.Equal(t, "#!/bin/sh\n# Generated from the image configuration's SecureBoot settings\nset -e\n"+
+		"mokutil --import /usr/share/imager/mok/00-contoso.der --root-pw\n"+
+		"mkdir -p /var/lib/imager\ntouch /var/lib/imager/mok-enrollment-requested\n",
+		renderMokEnrollScript(certificates[:1], ""))
+}
+
+func TestShouldParseMokPasswordHash(t *testing.T) {
+	passwordHash, err := parseMokPasswordHash("input password: input password again: $6$salt$hash\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "$6$salt$hash\n", passwordHash)
+
+	passwordHash, err = parseMokPasswordHash("$6$salt$hash\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "$6$salt$hash\n", passwordHash)
+
+	_, err = parseMokPasswordHash("input password: input password again: ")
+	assert.Error(t, err)
+}
+
+func TestShouldGetEfiBootloaderNames(t *testing.T) {
+	shimName, grubName, err := efiBootloaderNames("amd64")
+	assert.NoError(t, err)
+	assert.Equal(t, "bootx64.efi", shimName)
+	assert.Equal(t, "grubx64.efi", grubName)
+
+	shimName, grubName, err = efiBootloaderNames("arm64")
+	assert.NoError(t, err)
+	assert.Equal(t, "bootaa64.efi", shimName)
+	assert.Equal(t, "grubaa64.efi", grubName)
+
+	_, _, err = efiBootloaderNames("riscv64")
+	assert.Error(t, err)
+}
+
+func TestShouldKeyPackageCheckpointOnPackageInputs(t *testing.T) {
+	inputDir := t.TempDir()
+	localPackage := filepath.Join(inputDir, "agent-1.0-1.x86_64.rpm")
+	repoMetadata := filepath.Join(inputDir, "repomd.xml")
+	err := os.WriteFile(localPackage, []byte("agent 1.0"), 0600)
+	assert.NoError(t, err)
+	err = os.WriteFile(repoMetadata, []byte("<repomd/>"), 0600)
+	assert.NoError(t, err)
+
+	config := configuration.SystemConfig{
+		Hostname:      "edge",
+		LocalPackages: []string{localPackage},
+	}
+	packages := []string{"kernel", "core-packages-base-image"}
+	repoMetadataFiles := []string{repoMetadata, filepath.Join(inputDir, "missing.xml")}
+
+	key, err := PackageCheckpointKey(packages, config, repoMetadataFiles)
+	assert.NoError(t, err)
+	assert.Len(t, key, 64)
+
+	// The hostname is written again after a restore, so it isn't part of the key
+	config.Hostname = "edge-2"
+	sameKey, err := PackageCheckpointKey(packages, config, repoMetadataFiles)
+	assert.NoError(t, err)
+	assert.Equal(t, key, sameKey)
+
+	otherKey, err := PackageCheckpointKey([]string{"kernel"}, config, repoMetadataFiles)
+	assert.NoError(t, err)
+	assert.NotEqual(t, key, otherKey)
+
+	config.InstallOptions.NoDocs = true
+	otherKey, err = PackageCheckpointKey(packages, config, repoMetadataFiles)
+	assert.NoError(t, err)
+	assert.NotEqual(t, key, otherKey)
+	config.InstallOptions.NoDocs = false
+
+	err = os.WriteFile(localPackage, []byte("agent 1.1"), 0600)
+	assert.NoError(t, err)
+	otherKey, err = PackageCheckpointKey(packages, config, repoMetadataFiles)
+	assert.NoError(t, err)
+	assert.NotEqual(t, key, otherKey)
+
+	err = os.WriteFile(localPackage, []byte("agent 1.0"), 0600)
+	assert.NoError(t, err)
+	err = os.WriteFile(repoMetadata, []byte("<repomd revision=\"2\"/>"), 0600)
+	assert.NoError(t, err)
+	otherKey, err = PackageCheckpointKey(packages, config, repoMetadataFiles)
+	assert.NoError(t, err)
+	assert.NotEqual(t, key, otherKey)
+}
+
+func TestShouldKeyPackageCheckpointOnBaseRootfsDirContents(t *testing.T) {
+	baseRootfsDir := t.TempDir()
+	nestedFile := filepath.Join(baseRootfsDir, "usr", "lib", "os-release")
+	err := os.MkdirAll(filepath.Dir(nestedFile), 0755)
+	assert.NoError(t, err)
+	err = os.WriteFile(nestedFile, []byte("ID=mariner\n"), 0644)
+	assert.NoError(t, err)
+
+	config := configuration.SystemConfig{BaseRootfsDir: baseRootfsDir}
+	key, err := PackageCheckpointKey(nil, config, nil)
+	assert.NoError(t, err)
+
+	// Rewriting a nested file leaves the top-level directory untouched, but must change the key
+	err = os.WriteFile(nestedFile, []byte("ID=mariner\nVERSION_ID=2.0\n"), 0644)
+	assert.NoError(t, err)
+	otherKey, err := PackageCheckpointKey(nil, config, nil)
+	assert.NoError(t, err)
+	assert.NotEqual(t, key, otherKey)
+}
+
+func TestShouldSkipNilPackageCheckpoint(t *testing.T) {
+	var checkpoint *PackageCheckpoint
+
+	restored, err := checkpoint.Restore(t.TempDir())
+	assert.NoError(t, err)
+	assert.False(t, restored)
+
+	err = checkpoint.Save(t.TempDir())
+	assert.NoError(t, err)
+}
+
+func TestShouldNotRestoreMissingPackageCheckpoint(t *testing.T) {
+	checkpoint := &PackageCheckpoint{Dir: t.TempDir(), Key: "0123"}
+
+	restored, err := checkpoint.Restore(t.TempDir())
+	assert.NoError(t, err)
+	assert.False(t, restored)
+	assert.Equal(t, filepath.Join(checkpoint.Dir, "packages-0123.tar"), checkpoint.path())
+}
+
+func TestShouldInstallSystemdDropIns(t *testing.T) {
+	installRoot := t.TempDir()
+	unitDir := filepath.Join(installRoot, "usr/lib/systemd/system")
+	err := os.MkdirAll(unitDir, os.ModePerm)
+	assert.NoError(t, err)
+	for _, unit := range []string{"sshd.service", "getty@.service"} {
+		err = os.WriteFile(filepath.Join(unitDir, unit), []byte("[Service]\n"), 0644)
+		assert.NoError(t, err)
+	}
+
+	dropInFile := filepath.Join(t.TempDir(), "timeout.conf")
+	err = os.WriteFile(dropInFile, []byte("[Service]\nTimeoutStopSec=5\n"), 0644)
+	assert.NoError(t, err)
+
+	dropIns := []configuration.SystemdDropIn{
+		{Unit: "sshd.service", Lines: []string{"[Service]", "Restart=always"}},
+		{Unit: "getty@tty1.service", Name: "10-timeout.conf", Path: dropInFile},
+	}
+	err = installSystemdDropIns(installRoot, dropIns)
+	assert.NoError(t, err)
+
+	contents, err := os.ReadFile(filepath.Join(installRoot, "etc/systemd/system/sshd.service.d/override.conf"))
+	assert.NoError(t, err)
+	assert.Equal(t, "[Service]\nRestart=always\n", string(contents))
+
+	contents, err = os.ReadFile(filepath.Join(installRoot, "etc/systemd/system/getty@tty1.service.d/10-timeout.conf"))
+	assert.NoError(t, err)
+	assert.Equal(t, "[Service]\nTimeoutStopSec=5\n", string(contents))
+
+	err = installSystemdDropIns(installRoot, []configuration.SystemdDropIn{{Unit: "agent.service", Lines: []string{"[Service]", "Restart=always"}}})
+	assert.Error(t, err)
+	assert.Equal(t, "cannot install the [SystemdDropIns] of (agent.service): the unit is not installed in the image", err.Error())
+
+	err = os.WriteFile(dropInFile, []byte("TimeoutStopSec=5\n"), 0644)
+	assert.NoError(t, err)
+	err = installSystemdDropIns(installRoot, []configuration.SystemdDropIn{{Unit: "sshd.service", Path: dropInFile}})
+	assert.Error(t, err)
+	assert.Equal(t, fmt.Sprintf("invalid systemd drop-in file (%s): line 1: setting (TimeoutStopSec) must be in a section such as '[Service]'", dropInFile), err.Error())
+}
+
+func TestShouldFindSystemdUnitSymlink(t *testing.T) {
+	installRoot := t.TempDir()
+	unitDir := filepath.Join(installRoot, "etc/systemd/system")
+	err := os.MkdirAll(unitDir, os.ModePerm)
+	assert.NoError(t, err)
+
+	// The target only exists in the image, it must not be resolved on the build machine
+	err = os.Symlink("/usr/lib/systemd/system/missing-on-host.service", filepath.Join(unitDir, "agent.service"))
+	assert.NoError(t, err)
+
+	unitPath, err := findSystemdUnit(installRoot, "agent.service")
+	assert.NoError(t, err)
+	assert.Equal(t, "/etc/systemd/system/agent.service", unitPath)
+
+	unitPath, err = findSystemdUnit(installRoot, "other.service")
+	assert.NoError(t, err)
+	assert.Empty(t, unitPath)
+}
+
+// writeKernelModuleTree creates the module files and depmod indexes of a kernel under modulesDir
+func writeKernelModuleTree(t *testing.T, modulesDir string) {
+	modules := []string{
+		"kernel/fs/ext4/ext4.ko.xz",
+		"kernel/fs/jbd2/jbd2.ko.xz",
+		"kernel/lib/crc16.ko.xz",
+		"kernel/crypto/crc32c_generic.ko.xz",
+		"kernel/drivers/block/virtio_blk.ko.xz",
+		"kernel/drivers/gpu/drm/nouveau/nouveau.ko.xz",
+		"kernel/drivers/md/dm-verity.ko.xz",
+		"kernel/fs/overlayfs/overlay.ko.xz",
+	}
+	for _, module := range modules {
+		modulePath := filepath.Join(modulesDir, module)
+		err := os.MkdirAll(filepath.Dir(modulePath), os.ModePerm)
+		assert.NoError(t, err)
+		err = os.WriteFile(modulePath, []byte{}, 0644)
+		assert.NoError(t, err)
+	}
+
+	dep := "kernel/fs/ext4/ext4.ko.xz: kernel/fs/jbd2/jbd2.ko.xz kernel/lib/crc16.ko.xz\n" +
+		"kernel/fs/jbd2/jbd2.ko.xz:\n" +
+		"kernel/lib/crc16.ko.xz:\n" +
+		"kernel/crypto/crc32c_generic.ko.xz:\n" +
+		"kernel/drivers/block/virtio_blk.ko.xz:\n" +
+		"kernel/drivers/gpu/drm/nouveau/nouveau.ko.xz:\n" +
+		"kernel/drivers/md/dm-verity.ko.xz:\n" +
+		"kernel/fs/overlayfs/overlay.ko.xz:\n"
+	err := os.WriteFile(filepath.Join(modulesDir, "modules.dep"), []byte(dep), 0644)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(modulesDir, "modules.softdep"), []byte("# Soft dependencies\nsoftdep ext4 pre: crc32c_generic missing\n"), 0644)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(modulesDir, "modules.builtin"), []byte("kernel/drivers/md/dm-mod.ko\n"), 0644)
+	assert.NoError(t, err)
+}
+
+func TestShouldResolveKernelModuleDependencies(t *testing.T) {
+	modulesDir := filepath.Join(t.TempDir(), "lib/modules/5.15.80.1-1.cm2")
+	writeKernelModuleTree(t, modulesDir)
+
+	index, err := readKernelModuleIndex(modulesDir)
+	assert.NoError(t, err)
+
+	modulePaths, err := index.resolve([]string{"ext4", "dm-mod", "dm_verity"}, []string{"overlay", "nls_cp437"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{
+		"kernel/fs/ext4/ext4.ko.xz":          true,
+		"kernel/fs/jbd2/jbd2.ko.xz":          true,
+		"kernel/lib/crc16.ko.xz":             true,
+		"kernel/crypto/crc32c_generic.ko.xz": true,
+		"kernel/drivers/md/dm-verity.ko.xz":  true,
+		"kernel/fs/overlayfs/overlay.ko.xz":  true,
+	}, modulePaths)
+
+	_, err = index.resolve([]string{"ext4", "virtio_net"}, nil)
+	assert.Error(t, err)
+	assert.Equal(t, "kernel module (virtio_net) is not available", err.Error())
+}
+
+func TestShouldPruneKernelModules(t *testing.T) {
+	modulesDir := filepath.Join(t.TempDir(), "lib/modules/5.15.80.1-1.cm2")
+	writeKernelModuleTree(t, modulesDir)
+
+	index, err := readKernelModuleIndex(modulesDir)
+	assert.NoError(t, err)
+	modulePaths, err := index.resolve([]string{"virtio_blk", "ext4"}, nil)
+	assert.NoError(t, err)
+
+	pruned, err := pruneKernelModules(modulesDir, modulePaths)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, pruned)
+
+	for _, module := range []string{"kernel/drivers/block/virtio_blk.ko.xz", "kernel/fs/jbd2/jbd2.ko.xz", "kernel/crypto/crc32c_generic.ko.xz"} {
+		assert.FileExists(t, filepath.Join(modulesDir, module))
+	}
+	assert.NoFileExists(t, filepath.Join(modulesDir, "kernel/drivers/gpu/drm/nouveau/nouveau.ko.xz"))
+	assert.FileExists(t, filepath.Join(modulesDir, "modules.dep"))
+}
+
+func TestShouldFindStorageDriver(t *testing.T) {
+	modulesDir := filepath.Join(t.TempDir(), "lib/modules/5.15.80.1-1.cm2")
+	writeKernelModuleTree(t, modulesDir)
+
+	index, err := readKernelModuleIndex(modulesDir)
+	assert.NoError(t, err)
+
+	modulePaths, err := index.resolve([]string{"ext4", "virtio_blk"}, nil)
+	assert.NoError(t, err)
+	assert.True(t, index.hasStorageDriver(modulePaths))
+
+	// dm-mod is built in, but is no disk driver
+	modulePaths, err = index.resolve([]string{"ext4", "dm_verity"}, nil)
+	assert.NoError(t, err)
+	assert.False(t, index.hasStorageDriver(modulePaths))
+
+	// Nor are the libraries of the storage subsystems
+	assert.False(t, index.hasStorageDriver(map[string]bool{"kernel/drivers/scsi/scsi_mod.ko.xz": true}))
+
+	index.builtin["sd_mod"] = "kernel/drivers/scsi/sd_mod.ko"
+	assert.True(t, index.hasStorageDriver(modulePaths))
+}
+
+func TestShouldParseBuiltinModuleFirmware(t *testing.T) {
+	modinfo := []byte("i915.license=GPL\x00i915.firmware=i915/tgl_dmc_ver2_12.bin\x00ext4.license=GPL\x00rtl8169.firmware=rtl_nic/rtl8168d-1.fw\x00")
+	assert.Equal(t, []string{"i915/tgl_dmc_ver2_12.bin", "rtl_nic/rtl8168d-1.fw"}, parseBuiltinModuleFirmware(modinfo))
+	assert.Empty(t, parseBuiltinModuleFirmware(nil))
+}
+
+func TestShouldPruneFirmware(t *testing.T) {
+	firmwareRoot := filepath.Join(t.TempDir(), "lib/firmware")
+	for _, path := range []string{"iwlwifi-cc-a0-77.ucode.xz", "iwlwifi-ty-a0-gf-a0-59.ucode", "nvidia/tu104/gsp.bin", "amd-ucode/microcode_amd.bin", "rtl_nic/rtl8168d-1.fw"} {
+		err := os.MkdirAll(filepath.Dir(filepath.Join(firmwareRoot, path)), os.ModePerm)
+		assert.NoError(t, err)
+		err = os.WriteFile(filepath.Join(firmwareRoot, path), []byte("fw"), 0644)
+		assert.NoError(t, err)
+	}
+	err := os.Symlink("rtl_nic/rtl8168d-1.fw", filepath.Join(firmwareRoot, "rtl8168d-1.fw"))
+	assert.NoError(t, err)
+
+	pruned, err := pruneFirmware(firmwareRoot, []string{"iwlwifi-cc-a0-*.ucode", "rtl8168d-1.fw"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, pruned)
+
+	for _, path := range []string{"iwlwifi-cc-a0-77.ucode.xz", "amd-ucode/microcode_amd.bin", "rtl8168d-1.fw", "rtl_nic/rtl8168d-1.fw"} {
+		assert.FileExists(t, filepath.Join(firmwareRoot, path))
+	}
+	assert.NoFileExists(t, filepath.Join(firmwareRoot, "iwlwifi-ty-a0-gf-a0-59.ucode"))
+	assert.NoFileExists(t, filepath.Join(firmwareRoot, "nvidia/tu104/gsp.bin"))
+}
+
+func TestShouldIncludeBootCriticalKernelModules(t *testing.T) {
+	config := configuration.SystemConfig{
+		ReadOnlyVerityRoot: configuration.ReadOnlyVerityRoot{Enable: true},
+	}
+	mountPointToFsTypeMap := map[string]string{
+		"/":         "ext4",
+		"/boot/efi": "fat32",
+		"/var":      "xfs",
+	}
+
+	modules := bootCriticalKernelModules(config, mountPointToFsTypeMap)
+	assert.Equal(t, []string{"dm_mod", "dm_verity", "ext4", "overlay", "vfat", "xfs"}, modules)
+}
+
+func TestShouldRenderKernelModulesDracutConf(t *testing.T) {
+	drivers := normalizeKernelModuleNames([]string{"virtio-blk", "ext4", "virtio_blk"})
+	assert.Equal(t, []string{"ext4", "virtio_blk"}, drivers)
+
+	assert.Equal(t, "# Generated from the image configuration's KernelModules settings\n"+
+		"drivers+=\" ext4 virtio_blk \"\n"+
+		"omit_drivers+=\" nouveau \"\n", renderKernelModulesDracutConf(drivers, []string{"nouveau"}))
+	assert.Equal(t, "# Generated from the image configuration's KernelModules settings\n"+
+		"drivers+=\" ext4 virtio_blk \"\n", renderKernelModulesDracutConf(drivers, nil))
+}
@@ -0,0 +1,84 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package installutils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"microsoft.com/pkggen/imagegen/configuration"
+	"microsoft.com/pkggen/internal/file"
+	"microsoft.com/pkggen/internal/logger"
+	"microsoft.com/pkggen/internal/safechroot"
+)
+
+const (
+	kdumpConfFile = "etc/kdump.conf"
+	kdumpService  = "kdump"
+)
+
+// configureKdump points /etc/kdump.conf at the configured dump target and enables kdump.service.
+// The crashkernel reservation is added to the kernel command line along with the bootloader.
+func configureKdump(installChroot *safechroot.Chroot, kdump configuration.Kdump) (err error) {
+	if !kdump.Enable {
+		return
+	}
+
+	ReportAction("Configuring kdump")
+
+	kdumpConfPath := filepath.Join(installChroot.RootDir(), kdumpConfFile)
+	exists, err := file.PathExists(kdumpConfPath)
+	if err != nil {
+		return
+	}
+	if !exists {
+		return fmt.Errorf("cannot configure [Kdump]: /%s is missing, is %s installed?", kdumpConfFile, configuration.KdumpPackage)
+	}
+
+	if kdump.Path != "" || kdump.Target != "" {
+		kdumpConf, readErr := os.ReadFile(kdumpConfPath)
+		if readErr != nil {
+			return readErr
+		}
+
+		logger.Log.Debugf("Setting the kdump target to (%s) and path to (%s)", kdump.Target, kdump.Path)
+		err = file.Write(renderKdumpConf(string(kdumpConf), kdump), kdumpConfPath)
+		if err != nil {
+			return
+		}
+	}
+
+	return enableService(installChroot, kdumpService)
+}
+
+// renderKdumpConf comments out the path and dump target directives of an existing kdump.conf
+// which are replaced by the configuration, and appends the new ones.
+func renderKdumpConf(kdumpConf string, kdump configuration.Kdump) string {
+	const pathDirective = "path"
+
+	lines := strings.Split(strings.TrimRight(kdumpConf, "\n"), "\n")
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		replacedPath := kdump.Path != "" && fields[0] == pathDirective
+		replacedTarget := kdump.Target != "" && configuration.IsKdumpTargetType(fields[0])
+		if replacedPath || replacedTarget {
+			lines[i] = "#" + line
+		}
+	}
+
+	lines = append(lines, "", "# Set by the image configuration's Kdump")
+	if kdump.Target != "" {
+		lines = append(lines, kdump.Target)
+	}
+	if kdump.Path != "" {
+		lines = append(lines, fmt.Sprintf("%s %s", pathDirective, kdump.Path))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
@@ -0,0 +1,560 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package installutils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"microsoft.com/pkggen/imagegen/configuration"
+	"microsoft.com/pkggen/internal/file"
+	"microsoft.com/pkggen/internal/logger"
+	"microsoft.com/pkggen/internal/safechroot"
+	"microsoft.com/pkggen/internal/shell"
+	"microsoft.com/pkggen/internal/sliceutils"
+)
+
+const (
+	// kernelModulesDracutFile restricts the drivers of the initramfs, also when it is regenerated on the booted system
+	kernelModulesDracutFile = "etc/dracut.conf.d/90-imager-kernel-modules.conf"
+)
+
+var (
+	// fsTypeKernelModules are the modules needed to mount a file system type, when not named after it
+	fsTypeKernelModules = map[string][]string{
+		"ext2":       {"ext4"},
+		"ext3":       {"ext4"},
+		"fat16":      {"vfat"},
+		"fat32":      {"vfat"},
+		"linux-swap": nil,
+		"swap":       nil,
+		"tmpfs":      nil,
+	}
+	// espKernelModules are the code pages vfat mounts the ESP with by default, they may be built into the kernel
+	espKernelModules = []string{"nls_cp437", "nls_iso8859_1"}
+	// storageDriverDirs are the directories of a kernel's modules holding the drivers of block devices
+	storageDriverDirs = []string{"kernel/drivers/ata/", "kernel/drivers/block/", "kernel/drivers/mmc/", "kernel/drivers/nvme/", "kernel/drivers/scsi/", "kernel/drivers/usb/storage/"}
+	// storageCoreKernelModules are the libraries under storageDriverDirs which drive no disk on their own
+	storageCoreKernelModules = map[string]bool{"libata": true, "mmc_core": true, "nvme_core": true, "scsi_common": true, "scsi_mod": true}
+	// microcodeFirmwareDirs hold CPU microcode, which the kernel loads without a module asking for it
+	microcodeFirmwareDirs = []string{"amd-ucode", "intel-ucode"}
+	// firmwareCompressionSuffixes are the suffixes of compressed firmware, which is requested without them
+	firmwareCompressionSuffixes = []string{".xz", ".zst"}
+)
+
+// kernelModuleIndex is the dependency information depmod generated for the modules of a kernel
+type kernelModuleIndex struct {
+	// paths are the paths of the module files, relative to /lib/modules/<kernel>, by module name
+	paths map[string]string
+	// depends are the modules a module needs, by module name
+	depends map[string][]string
+	// softdeps are the modules modprobe loads along with a module, by module name
+	softdeps map[string][]string
+	// builtin are the paths the modules built into the kernel would have, by module name
+	builtin map[string]string
+}
+
+// normalizeKernelModuleName returns the name of a module with '_' in place of '-', modprobe treats them as equivalent
+func normalizeKernelModuleName(module string) string {
+	return strings.ReplaceAll(module, "-", "_")
+}
+
+// kernelModuleFileName returns the name of the module in a file, or an empty name if it is not a module
+func kernelModuleFileName(path string) string {
+	name := filepath.Base(path)
+	idx := strings.Index(name, ".ko")
+	if idx <= 0 {
+		return ""
+	}
+
+	switch name[idx:] {
+	case ".ko", ".ko.xz", ".ko.gz", ".ko.zst":
+		return normalizeKernelModuleName(name[:idx])
+	default:
+		return ""
+	}
+}
+
+// readKernelModuleIndex reads modules.dep, modules.softdep and modules.builtin from a kernel's module directory.
+func readKernelModuleIndex(modulesDir string) (index kernelModuleIndex, err error) {
+	index = kernelModuleIndex{
+		paths:    make(map[string]string),
+		depends:  make(map[string][]string),
+		softdeps: make(map[string][]string),
+		builtin:  make(map[string]string),
+	}
+
+	// Each line is a module followed by the modules it depends on, ie "kernel/fs/ext4/ext4.ko.xz: kernel/fs/jbd2/jbd2.ko.xz"
+	depLines, err := file.ReadLines(filepath.Join(modulesDir, "modules.dep"))
+	if err != nil {
+		return
+	}
+	for _, line := range depLines {
+		colonIndex := strings.Index(line, ":")
+		if colonIndex < 0 {
+			continue
+		}
+
+		modulePath := strings.TrimSpace(line[:colonIndex])
+		name := kernelModuleFileName(modulePath)
+		if name == "" {
+			continue
+		}
+		index.paths[name] = modulePath
+		for _, dependency := range strings.Fields(line[colonIndex+1:]) {
+			index.depends[name] = append(index.depends[name], kernelModuleFileName(dependency))
+		}
+	}
+
+	// Soft dependencies are optional, ie "softdep ext4 pre: crc32c"
+	softdepLines, err := readOptionalLines(filepath.Join(modulesDir, "modules.softdep"))
+	if err != nil {
+		return
+	}
+	for _, line := range softdepLines {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || fields[0] != "softdep" {
+			continue
+		}
+		name := normalizeKernelModuleName(fields[1])
+		for _, dependency := range fields[2:] {
+			if strings.HasSuffix(dependency, ":") {
+				continue
+			}
+			index.softdeps[name] = append(index.softdeps[name], normalizeKernelModuleName(dependency))
+		}
+	}
+
+	// Built-in modules are listed by path, ie "kernel/drivers/vfio/vfio.ko"
+	builtinLines, err := readOptionalLines(filepath.Join(modulesDir, "modules.builtin"))
+	if err != nil {
+		return
+	}
+	for _, line := range builtinLines {
+		modulePath := strings.TrimSpace(line)
+		if name := kernelModuleFileName(modulePath); name != "" {
+			index.builtin[name] = modulePath
+		}
+	}
+
+	return
+}
+
+// readOptionalLines returns the lines of a file, or no lines if it does not exist
+func readOptionalLines(path string) (lines []string, err error) {
+	exists, err := file.PathExists(path)
+	if err != nil || !exists {
+		return
+	}
+	return file.ReadLines(path)
+}
+
+// resolve returns the paths of the module files needed by the required and optional modules, including their
+// dependencies and the soft dependencies which are available. Built-in modules need no file.
+// A required module which is neither a file nor built into the kernel is an error, an optional one is skipped.
+func (index *kernelModuleIndex) resolve(required, optional []string) (modulePaths map[string]bool, err error) {
+	modulePaths = make(map[string]bool)
+	visited := make(map[string]bool)
+
+	var visit func(module string)
+	visit = func(module string) {
+		if visited[module] {
+			return
+		}
+		visited[module] = true
+
+		modulePath, found := index.paths[module]
+		if !found {
+			return
+		}
+		modulePaths[modulePath] = true
+
+		for _, dependency := range index.depends[module] {
+			visit(dependency)
+		}
+		for _, dependency := range index.softdeps[module] {
+			visit(dependency)
+		}
+	}
+
+	for _, module := range required {
+		module = normalizeKernelModuleName(module)
+		_, isBuiltin := index.builtin[module]
+		if _, found := index.paths[module]; !found && !isBuiltin {
+			return nil, fmt.Errorf("kernel module (%s) is not available", module)
+		}
+		visit(module)
+	}
+
+	for _, module := range optional {
+		visit(normalizeKernelModuleName(module))
+	}
+
+	return
+}
+
+// hasStorageDriver returns true if one of the module files or one of the modules built into the kernel drives
+// a disk, so the initramfs can find the root partition.
+func (index *kernelModuleIndex) hasStorageDriver(modulePaths map[string]bool) bool {
+	candidates := make([]string, 0, len(modulePaths)+len(index.builtin))
+	for modulePath := range modulePaths {
+		candidates = append(candidates, modulePath)
+	}
+	for _, modulePath := range index.builtin {
+		candidates = append(candidates, modulePath)
+	}
+
+	for _, modulePath := range candidates {
+		if storageCoreKernelModules[kernelModuleFileName(modulePath)] {
+			continue
+		}
+		for _, dir := range storageDriverDirs {
+			if strings.HasPrefix(modulePath, dir) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// bootCriticalKernelModules returns the modules the image can't boot without, which are included in the initramfs
+// whether they are listed in [Include] or not: the file systems of its partitions and the device-mapper targets.
+func bootCriticalKernelModules(config configuration.SystemConfig, mountPointToFsTypeMap map[string]string) (modules []string) {
+	critical := make(map[string]bool)
+
+	for _, fsType := range mountPointToFsTypeMap {
+		if fsType == "" {
+			continue
+		}
+		fsModules, found := fsTypeKernelModules[fsType]
+		if !found {
+			fsModules = []string{fsType}
+		}
+		for _, module := range fsModules {
+			critical[normalizeKernelModuleName(module)] = true
+		}
+	}
+
+	if config.ReadOnlyVerityRoot.Enable {
+		// The writable directories of a verity root are overlays
+		critical["dm_mod"] = true
+		critical["dm_verity"] = true
+		critical[overlay] = true
+	}
+
+	if config.Encryption.Enable || config.HasEncryptedPartitions() {
+		critical["dm_mod"] = true
+		critical["dm_crypt"] = true
+	}
+
+	if config.HasIntegrityPartitions() {
+		critical["dm_mod"] = true
+		critical["dm_integrity"] = true
+	}
+
+	if config.ReadOnlyRoot.Enable || config.ReadOnlyEtc.Enable {
+		critical[overlay] = true
+	}
+
+	for module := range critical {
+		modules = append(modules, module)
+	}
+	sort.Strings(modules)
+	return
+}
+
+// configureMinimalKernelModules configures dracut to build the initramfs of every installed kernel with only the
+// modules listed in [Include] and the boot-critical ones. If [Prune] is set, it also removes the modules nothing needs from
+// /lib/modules, and the firmware none of the remaining modules asks for from /lib/firmware.
+func configureMinimalKernelModules(installChroot *safechroot.Chroot, config configuration.SystemConfig, mountPointToFsTypeMap map[string]string) (err error) {
+	const libModulesDir = "lib/modules"
+
+	kernelModules := config.KernelModules
+	if len(kernelModules.Include) == 0 {
+		return
+	}
+
+	ReportAction("Configuring a minimal set of kernel modules")
+
+	installRoot := installChroot.RootDir()
+	depFiles, err := filepath.Glob(filepath.Join(installRoot, libModulesDir, "*", "modules.dep"))
+	if err != nil {
+		return
+	}
+	if len(depFiles) == 0 {
+		return fmt.Errorf("[KernelModules] [Include] requires a kernel to be installed, found no modules.dep under (/%s)", libModulesDir)
+	}
+
+	criticalModules := bootCriticalKernelModules(config, mountPointToFsTypeMap)
+	logger.Log.Infof("Including the boot-critical kernel modules (%s)", strings.Join(criticalModules, " "))
+	for _, module := range normalizeKernelModuleNames(kernelModules.Blacklist) {
+		if sliceutils.Find(criticalModules, module) >= 0 {
+			return fmt.Errorf("kernel module (%s) is needed to boot the image and may not be blacklisted", module)
+		}
+	}
+
+	drivers := normalizeKernelModuleNames(append(append([]string{}, kernelModules.Include...), criticalModules...))
+	optionalModules := append(append([]string{}, kernelModules.Load...), espKernelModules...)
+
+	// The firmware requested for the initramfs is kept even if no module asks for it
+	referencedFirmware := append([]string{}, config.Firmware.Initramfs...)
+
+	for _, depFile := range depFiles {
+		modulesDir := filepath.Dir(depFile)
+		kernel := filepath.Base(modulesDir)
+
+		var index kernelModuleIndex
+		index, err = readKernelModuleIndex(modulesDir)
+		if err != nil {
+			return
+		}
+
+		var initramfsPaths map[string]bool
+		initramfsPaths, err = index.resolve(drivers, nil)
+		if err != nil {
+			return fmt.Errorf("failed to include the kernel modules of kernel (%s): %w", kernel, err)
+		}
+
+		// The disk's driver can't be derived from the configuration, so it must be one of the listed modules
+		if len(config.PartitionSettings) != 0 && !index.hasStorageDriver(initramfsPaths) {
+			return fmt.Errorf("the initramfs of kernel (%s) would have no driver for the disk, add its storage driver (such as sd_mod, ahci, nvme, virtio_blk or hv_storvsc) to [Include]", kernel)
+		}
+
+		if !kernelModules.Prune {
+			continue
+		}
+
+		var modulePaths map[string]bool
+		modulePaths, err = index.resolve(drivers, optionalModules)
+		if err != nil {
+			return fmt.Errorf("failed to include the kernel modules of kernel (%s): %w", kernel, err)
+		}
+
+		var pruned int
+		pruned, err = pruneKernelModules(modulesDir, modulePaths)
+		if err != nil {
+			return
+		}
+		logger.Log.Infof("Pruned %d kernel modules of kernel (%s), kept %d", pruned, kernel, len(modulePaths))
+
+		err = installChroot.UnsafeRun(func() (err error) {
+			_, stderr, err := shell.Execute("depmod", "-a", kernel)
+			if err != nil {
+				err = fmt.Errorf("failed to regenerate the module dependencies of kernel (%s): %v: %w", kernel, stderr, err)
+			}
+			return
+		})
+		if err != nil {
+			return
+		}
+
+		var firmware []string
+		firmware, err = kernelModuleFirmware(installChroot, modulesDir, modulePaths)
+		if err != nil {
+			return
+		}
+		referencedFirmware = append(referencedFirmware, firmware...)
+	}
+
+	if kernelModules.Prune {
+		var pruned int
+		pruned, err = pruneFirmware(filepath.Join(installRoot, firmwareDir), referencedFirmware)
+		if err != nil {
+			return
+		}
+		logger.Log.Infof("Pruned %d firmware files no kernel module asks for", pruned)
+	}
+
+	dracutConfPath := filepath.Join(installRoot, kernelModulesDracutFile)
+	err = os.MkdirAll(filepath.Dir(dracutConfPath), os.ModePerm)
+	if err != nil {
+		return
+	}
+	return file.Write(renderKernelModulesDracutConf(drivers, normalizeKernelModuleNames(kernelModules.Blacklist)), dracutConfPath)
+}
+
+// normalizeKernelModuleNames returns the sorted, normalized names of modules without duplicates
+func normalizeKernelModuleNames(modules []string) (names []string) {
+	seen := make(map[string]bool)
+	for _, module := range modules {
+		name := normalizeKernelModuleName(module)
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return
+}
+
+// pruneKernelModules removes the module files of a kernel's module directory which are not in modulePaths.
+// The module directory's modules.* indexes must be regenerated with depmod afterwards.
+func pruneKernelModules(modulesDir string, modulePaths map[string]bool) (pruned int, err error) {
+	err = filepath.Walk(modulesDir, func(path string, info os.FileInfo, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if info.IsDir() || kernelModuleFileName(path) == "" {
+			return nil
+		}
+
+		relativePath, relErr := filepath.Rel(modulesDir, path)
+		if relErr != nil {
+			return relErr
+		}
+		if modulePaths[relativePath] {
+			return nil
+		}
+
+		logger.Log.Debugf("Pruning kernel module (%s)", path)
+		pruned++
+		return os.Remove(path)
+	})
+	return
+}
+
+// kernelModuleFirmware returns the firmware the module files of a kernel and its built-in modules ask for.
+// Entries may be glob patterns.
+func kernelModuleFirmware(installChroot *safechroot.Chroot, modulesDir string, modulePaths map[string]bool) (firmware []string, err error) {
+	builtinModinfo, err := os.ReadFile(filepath.Join(modulesDir, "modules.builtin.modinfo"))
+	if err != nil && !os.IsNotExist(err) {
+		return
+	}
+	firmware = parseBuiltinModuleFirmware(builtinModinfo)
+
+	if len(modulePaths) == 0 {
+		return firmware, nil
+	}
+
+	// Module files are queried with modinfo, as they are usually compressed
+	chrootModulesDir := "/" + strings.TrimPrefix(modulesDir, installChroot.RootDir())
+	modinfoArgs := []string{"-F", "firmware"}
+	for modulePath := range modulePaths {
+		modinfoArgs = append(modinfoArgs, filepath.Join(chrootModulesDir, modulePath))
+	}
+	sort.Strings(modinfoArgs[2:])
+
+	err = installChroot.UnsafeRun(func() (err error) {
+		stdout, stderr, err := shell.Execute("modinfo", modinfoArgs...)
+		if err != nil {
+			return fmt.Errorf("failed to list the firmware of the kernel modules under (%s): %v: %w", chrootModulesDir, stderr, err)
+		}
+		for _, line := range strings.Split(stdout, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				firmware = append(firmware, line)
+			}
+		}
+		return
+	})
+	return
+}
+
+// parseBuiltinModuleFirmware returns the firmware listed in a kernel's modules.builtin.modinfo, which holds
+// NUL separated "<module>.<key>=<value>" entries.
+func parseBuiltinModuleFirmware(modinfo []byte) (firmware []string) {
+	for _, entry := range strings.Split(string(modinfo), "\x00") {
+		equalsIndex := strings.Index(entry, "=")
+		if equalsIndex < 0 || !strings.HasSuffix(entry[:equalsIndex], ".firmware") {
+			continue
+		}
+		firmware = append(firmware, entry[equalsIndex+1:])
+	}
+	return
+}
+
+// pruneFirmware removes the files of the firmware directory which don't match one of the referenced firmware,
+// apart from CPU microcode. Firmware may be stored compressed, and a symlink that is kept also keeps its target.
+func pruneFirmware(firmwareRoot string, referenced []string) (pruned int, err error) {
+	exists, err := file.PathExists(firmwareRoot)
+	if err != nil || !exists {
+		return
+	}
+
+	var candidates []string
+	kept := make(map[string]bool)
+	err = filepath.Walk(firmwareRoot, func(path string, info os.FileInfo, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		relativePath, relErr := filepath.Rel(firmwareRoot, path)
+		if relErr != nil {
+			return relErr
+		}
+		if !isReferencedFirmware(relativePath, referenced) {
+			candidates = append(candidates, relativePath)
+			return nil
+		}
+
+		kept[relativePath] = true
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, linkErr := os.Readlink(path)
+			if linkErr != nil {
+				return linkErr
+			}
+			if !filepath.IsAbs(target) {
+				kept[filepath.Join(filepath.Dir(relativePath), target)] = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	for _, relativePath := range candidates {
+		if kept[relativePath] {
+			continue
+		}
+
+		logger.Log.Debugf("Pruning firmware (%s)", relativePath)
+		err = os.Remove(filepath.Join(firmwareRoot, relativePath))
+		if err != nil {
+			return
+		}
+		pruned++
+	}
+	return
+}
+
+// isReferencedFirmware returns true if a file, relative to the firmware directory, is CPU microcode or matches
+// one of the referenced firmware once its compression suffix is removed.
+func isReferencedFirmware(relativePath string, referenced []string) bool {
+	for _, dir := range microcodeFirmwareDirs {
+		if strings.HasPrefix(relativePath, dir+"/") {
+			return true
+		}
+	}
+
+	name := relativePath
+	for _, suffix := range firmwareCompressionSuffixes {
+		name = strings.TrimSuffix(name, suffix)
+	}
+
+	for _, pattern := range referenced {
+		if matched, _ := filepath.Match(pattern, name); matched || pattern == name {
+			return true
+		}
+	}
+	return false
+}
+
+// renderKernelModulesDracutConf returns the dracut configuration building the initramfs with only the drivers,
+// as "dracut --drivers" does, and leaving out the omitted drivers.
+func renderKernelModulesDracutConf(drivers, omitDrivers []string) string {
+	var builder strings.Builder
+	builder.WriteString("# Generated from the image configuration's KernelModules settings\n")
+	builder.WriteString(fmt.Sprintf("drivers+=\" %s \"\n", strings.Join(drivers, " ")))
+	if len(omitDrivers) != 0 {
+		builder.WriteString(fmt.Sprintf("omit_drivers+=\" %s \"\n", strings.Join(omitDrivers, " ")))
+	}
+	return builder.String()
+}
@@ -0,0 +1,145 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package installutils
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"microsoft.com/pkggen/internal/logger"
+	"microsoft.com/pkggen/internal/pkgjson"
+	"microsoft.com/pkggen/internal/shell"
+)
+
+// rpmLeadMagic is the magic number every RPM file starts with.
+var rpmLeadMagic = []byte{0xed, 0xab, 0xee, 0xdb}
+
+// installLocalPackages installs the LocalPackages RPM files in a single transaction, so they can depend on
+// each other. Their dependencies are resolved from the configured repositories.
+func installLocalPackages(installRoot string, localPackages []string, tdnf TdnfSettings, currentPackagesInstalled, totalPackages int) (packagesInstalled int, err error) {
+	packagesInstalled = currentPackagesInstalled
+	if len(localPackages) == 0 {
+		return
+	}
+
+	err = checkLocalPackages(localPackages)
+	if err != nil {
+		return
+	}
+
+	logger.Log.Infof("Installing local packages: %v", localPackages)
+	return TdnfInstallGroupWithProgress(localPackages, installRoot, tdnf, packagesInstalled, totalPackages, true)
+}
+
+// checkLocalPackages makes sure every local package exists and is an RPM file, so a mistyped path
+// fails with a clear error rather than as a tdnf package lookup.
+func checkLocalPackages(localPackages []string) (err error) {
+	for _, localPackage := range localPackages {
+		err = checkRpmFile(localPackage)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// checkRpmFile checks that the file at rpmPath starts with the RPM lead.
+func checkRpmFile(rpmPath string) (err error) {
+	rpmFile, err := os.Open(rpmPath)
+	if err != nil {
+		return fmt.Errorf("failed to open local package (%s):\n%w", rpmPath, err)
+	}
+	defer rpmFile.Close()
+
+	lead := make([]byte, len(rpmLeadMagic))
+	_, err = io.ReadFull(rpmFile, lead)
+	if err != nil || !bytes.Equal(lead, rpmLeadMagic) {
+		return fmt.Errorf("local package (%s) is not an RPM file", rpmPath)
+	}
+
+	return nil
+}
+
+// LocalPackageRequires returns the dependencies of a set of local RPM files, so they can be fetched alongside them.
+// The set is installed in a single transaction, so requires provided by another file of the set are left out.
+func LocalPackageRequires(localPackages []string) (requires []*pkgjson.PackageVer, err error) {
+	if len(localPackages) == 0 {
+		return
+	}
+
+	requiresOutput, err := queryLocalPackages("--requires", localPackages)
+	if err != nil {
+		return
+	}
+
+	providesOutput, err := queryLocalPackages("--provides", localPackages)
+	if err != nil {
+		return
+	}
+
+	allRequires, err := parseRpmRequires(requiresOutput)
+	if err != nil {
+		return
+	}
+
+	provided := parseRpmProvides(providesOutput)
+	for _, require := range allRequires {
+		if !provided[require.Name] {
+			requires = append(requires, require)
+		}
+	}
+
+	return
+}
+
+// queryLocalPackages runs 'rpm -qp' with the given query option over all local RPM files.
+func queryLocalPackages(queryOption string, localPackages []string) (stdout string, err error) {
+	args := append([]string{"-qp", queryOption}, localPackages...)
+	stdout, stderr, err := shell.Execute("rpm", args...)
+	if err != nil {
+		logger.Log.Warn(stderr)
+		err = fmt.Errorf("failed to query the %s of local packages (%v):\n%w", strings.TrimPrefix(queryOption, "--"), localPackages, err)
+	}
+
+	return
+}
+
+// parseRpmProvides returns the names of the capabilities in the output of 'rpm --provides'.
+func parseRpmProvides(output string) (provided map[string]bool) {
+	provided = make(map[string]bool)
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 {
+			provided[fields[0]] = true
+		}
+	}
+
+	return
+}
+
+// parseRpmRequires converts the output of 'rpm --requires' into package versions. rpmlib()
+// features, file dependencies and rich dependencies can't be expressed as a package version, so they are skipped.
+func parseRpmRequires(output string) (requires []*pkgjson.PackageVer, err error) {
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "rpmlib(") || strings.HasPrefix(line, "/") || strings.HasPrefix(line, "(") {
+			continue
+		}
+
+		var packageVer *pkgjson.PackageVer
+		packageVer, err = pkgjson.PackagesListEntryToPackageVer(line)
+		if err != nil {
+			err = fmt.Errorf("failed to parse requirement (%s):\n%w", line, err)
+			return
+		}
+
+		requires = append(requires, packageVer)
+	}
+
+	return
+}
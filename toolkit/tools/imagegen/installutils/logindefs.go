@@ -0,0 +1,74 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package installutils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"microsoft.com/pkggen/imagegen/configuration"
+	"microsoft.com/pkggen/internal/file"
+)
+
+// configureLoginDefs sets the LoginDefs values in the image's /etc/login.defs, keeping the rest of the file
+// as the base image has it. It runs before the users are added, so they get the new password aging defaults.
+func configureLoginDefs(installRoot string, settings configuration.LoginDefs) (err error) {
+	if settings.IsEmpty() {
+		return
+	}
+
+	ReportAction("Configuring login.defs")
+
+	loginDefsPath := filepath.Join(installRoot, loginDefsFile)
+	exists, err := file.PathExists(loginDefsPath)
+	if err != nil {
+		return
+	}
+	if !exists {
+		return fmt.Errorf("cannot configure [LoginDefs]: the image has no /%s, add the 'shadow-utils' package to the package lists", loginDefsFile)
+	}
+
+	loginDefs, err := os.ReadFile(loginDefsPath)
+	if err != nil {
+		return
+	}
+
+	keys, values := settings.Settings()
+	return file.Write(renderLoginDefs(string(loginDefs), keys, values), loginDefsPath)
+}
+
+// renderLoginDefs replaces the value of each key set in login.defs and appends the keys which are not set.
+// Commented out keys are left alone.
+func renderLoginDefs(loginDefs string, keys, values []string) string {
+	written := make(map[string]bool, len(keys))
+
+	lines := strings.Split(strings.TrimRight(loginDefs, "\n"), "\n")
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		for j, key := range keys {
+			if fields[0] == key {
+				lines[i] = fmt.Sprintf("%s\t%s", key, values[j])
+				written[key] = true
+			}
+		}
+	}
+
+	var missing []string
+	for i, key := range keys {
+		if !written[key] {
+			missing = append(missing, fmt.Sprintf("%s\t%s", key, values[i]))
+		}
+	}
+	if len(missing) != 0 {
+		lines = append(lines, "", "# Generated from the image configuration's LoginDefs settings")
+		lines = append(lines, missing...)
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
@@ -0,0 +1,95 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package installutils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"microsoft.com/pkggen/internal/logger"
+	"microsoft.com/pkggen/internal/safechroot"
+	"microsoft.com/pkggen/internal/shell"
+)
+
+// mountPointLabelDir is where a filesystem is bind mounted inside the install chroot to label the
+// mount point directories hidden under the partitions mounted on it
+const mountPointLabelDir = "/tmp/imager-mountpoint-labels"
+
+// selinuxLabelMountPointDirs labels the directories separate partitions, such as /var, are mounted on.
+// setfiles only sees the root of the mounted partition, while the directory underneath is what is
+// labeled when the partition is not mounted yet, early in boot. Only mount points on one of the
+// labeledMounts are labeled.
+func selinuxLabelMountPointDirs(installChroot *safechroot.Chroot, fileContextPath string, mountPoints, labeledMounts []string) (err error) {
+	const squashErrors = false
+
+	labeled := make(map[string]bool, len(labeledMounts))
+	for _, mountPoint := range labeledMounts {
+		labeled[mountPoint] = true
+	}
+
+	for parent, children := range mountPointsByParent(mountPoints) {
+		if !labeled[parent] {
+			continue
+		}
+
+		logger.Log.Debugf("Labeling mount point directories %v on (%s)", children, parent)
+
+		err = installChroot.UnsafeRun(func() (err error) {
+			err = os.MkdirAll(mountPointLabelDir, os.ModePerm)
+			if err != nil {
+				return
+			}
+			defer os.Remove(mountPointLabelDir)
+
+			// A non-recursive bind mount shows the directories under the partitions mounted on parent
+			err = shell.ExecuteLive(squashErrors, "mount", "--bind", parent, mountPointLabelDir)
+			if err != nil {
+				return fmt.Errorf("failed to bind mount (%s): %w", parent, err)
+			}
+			defer func() {
+				umountErr := shell.ExecuteLive(squashErrors, "umount", mountPointLabelDir)
+				if umountErr != nil && err == nil {
+					err = fmt.Errorf("failed to unmount (%s): %w", mountPointLabelDir, umountErr)
+				}
+			}()
+
+			args := []string{"-m", "-r", mountPointLabelDir, fileContextPath}
+			for _, child := range children {
+				args = append(args, filepath.Join(mountPointLabelDir, strings.TrimPrefix(child, parent)))
+			}
+			return shell.ExecuteLive(squashErrors, "setfiles", args...)
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// mountPointsByParent maps each mount point other than "/" to the mount point of the filesystem
+// it is mounted on
+func mountPointsByParent(mountPoints []string) (byParent map[string][]string) {
+	byParent = make(map[string][]string)
+
+	sorted := append([]string{}, mountPoints...)
+	sort.Strings(sorted)
+	for _, mountPoint := range sorted {
+		if mountPoint == "/" || mountPoint == "" {
+			continue
+		}
+
+		parent := "/"
+		for _, candidate := range sorted {
+			if candidate != "/" && strings.HasPrefix(mountPoint, candidate+"/") && len(candidate) > len(parent) {
+				parent = candidate
+			}
+		}
+		byParent[parent] = append(byParent[parent], mountPoint)
+	}
+	return
+}
@@ -0,0 +1,240 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package installutils
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"microsoft.com/pkggen/imagegen/configuration"
+	"microsoft.com/pkggen/internal/file"
+	"microsoft.com/pkggen/internal/logger"
+	"microsoft.com/pkggen/internal/safechroot"
+)
+
+const (
+	networkManagerService     = "NetworkManager.service"
+	networkManagerKeyfileDir  = "etc/NetworkManager/system-connections"
+	networkManagerKeyfileMode = 0600
+	networkdService           = "systemd-networkd.service"
+	networkdConfDir           = "etc/systemd/network"
+	networkdConfFileMode      = 0644
+	// networkdFilePrefix sorts the generated files before the catch-all DHCP configuration of the image
+	networkdFilePrefix = "10-imager-"
+)
+
+// configureNetworkConnections writes the network connections as NetworkManager keyfiles when NetworkManager
+// is installed, otherwise as systemd-networkd configuration, and enables the service.
+func configureNetworkConnections(installChroot *safechroot.Chroot, connections []configuration.NetworkConnection) (err error) {
+	const systemdUnitDir = "usr/lib/systemd/system"
+
+	if len(connections) == 0 {
+		return
+	}
+
+	ReportAction("Configuring network connections")
+
+	installRoot := installChroot.RootDir()
+
+	var (
+		service  string
+		confDir  string
+		confMode os.FileMode
+		files    map[string]string
+	)
+	if exists, _ := file.PathExists(filepath.Join(installRoot, systemdUnitDir, networkManagerService)); exists {
+		service, confDir, confMode = networkManagerService, networkManagerKeyfileDir, networkManagerKeyfileMode
+		files = renderNetworkManagerKeyfiles(connections)
+	} else if exists, _ := file.PathExists(filepath.Join(installRoot, systemdUnitDir, networkdService)); exists {
+		service, confDir, confMode = networkdService, networkdConfDir, networkdConfFileMode
+		files = renderNetworkdFiles(connections)
+	} else {
+		return fmt.Errorf("cannot configure network connections: neither NetworkManager nor systemd-networkd is installed, add the 'systemd-networkd' or 'NetworkManager' package to the package lists")
+	}
+
+	fileNames := make([]string, 0, len(files))
+	for fileName := range files {
+		fileNames = append(fileNames, fileName)
+	}
+	sort.Strings(fileNames)
+
+	for _, fileName := range fileNames {
+		confPath := filepath.Join(installRoot, confDir, fileName)
+		logger.Log.Infof("Writing network configuration (/%s)", filepath.Join(confDir, fileName))
+		err = os.MkdirAll(filepath.Dir(confPath), os.ModePerm)
+		if err != nil {
+			return
+		}
+
+		err = file.Write(files[fileName], confPath)
+		if err != nil {
+			return
+		}
+
+		err = os.Chmod(confPath, confMode)
+		if err != nil {
+			return
+		}
+	}
+
+	return enableService(installChroot, service)
+}
+
+// splitAddressesByFamily returns the IPv4 and IPv6 addresses of a connection
+func splitAddressesByFamily(addresses []string) (ipv4, ipv6 []string) {
+	for _, address := range addresses {
+		ip, _, err := net.ParseCIDR(address)
+		if err == nil && ip.To4() != nil {
+			ipv4 = append(ipv4, address)
+		} else {
+			ipv6 = append(ipv6, address)
+		}
+	}
+	return
+}
+
+// renderNetworkdFiles returns the systemd-networkd .network and .netdev files of the connections by file name.
+// VLAN parents and bond members which aren't configured themselves get a .network file linking them.
+func renderNetworkdFiles(connections []configuration.NetworkConnection) (files map[string]string) {
+	files = make(map[string]string)
+
+	configured := make(map[string]bool)
+	vlansOf := make(map[string][]string)
+	for _, connection := range connections {
+		configured[connection.Name] = true
+		if connection.GetType() == configuration.NetworkConnectionTypeVlan {
+			vlansOf[connection.Parent] = append(vlansOf[connection.Parent], connection.Name)
+		}
+	}
+
+	for _, connection := range connections {
+		switch connection.GetType() {
+		case configuration.NetworkConnectionTypeVlan:
+			files[networkdFilePrefix+connection.Name+".netdev"] = fmt.Sprintf("[NetDev]\nName=%s\nKind=vlan\n\n[VLAN]\nId=%d\n", connection.Name, connection.VlanID)
+		case configuration.NetworkConnectionTypeBond:
+			files[networkdFilePrefix+connection.Name+".netdev"] = fmt.Sprintf("[NetDev]\nName=%s\nKind=bond\n\n[Bond]\nMode=%s\n", connection.Name, connection.BondMode)
+			for _, member := range connection.Members {
+				files[networkdFilePrefix+member+".network"] = fmt.Sprintf("[Match]\nName=%s\n\n[Network]\nBond=%s\n", member, connection.Name)
+			}
+		}
+
+		var builder strings.Builder
+		builder.WriteString("[Match]\n")
+		if connection.MACAddress != "" {
+			builder.WriteString(fmt.Sprintf("MACAddress=%s\n", connection.MACAddress))
+		} else {
+			builder.WriteString(fmt.Sprintf("Name=%s\n", connection.Name))
+		}
+		builder.WriteString("\n[Network]\n")
+		if connection.Dhcp {
+			builder.WriteString("DHCP=yes\n")
+		}
+		for _, address := range connection.Addresses {
+			builder.WriteString(fmt.Sprintf("Address=%s\n", address))
+		}
+		if connection.Gateway != "" {
+			builder.WriteString(fmt.Sprintf("Gateway=%s\n", connection.Gateway))
+		}
+		for _, server := range connection.Dns {
+			builder.WriteString(fmt.Sprintf("DNS=%s\n", server))
+		}
+		for _, vlan := range vlansOf[connection.Name] {
+			builder.WriteString(fmt.Sprintf("VLAN=%s\n", vlan))
+		}
+		files[networkdFilePrefix+connection.Name+".network"] = builder.String()
+	}
+
+	for parent, vlans := range vlansOf {
+		if configured[parent] {
+			continue
+		}
+
+		var builder strings.Builder
+		builder.WriteString(fmt.Sprintf("[Match]\nName=%s\n\n[Network]\n", parent))
+		for _, vlan := range vlans {
+			builder.WriteString(fmt.Sprintf("VLAN=%s\n", vlan))
+		}
+		files[networkdFilePrefix+parent+".network"] = builder.String()
+	}
+
+	return
+}
+
+// renderNetworkManagerKeyfiles returns the NetworkManager keyfiles of the connections by file name.
+// Bond members get a keyfile adding them to the bond.
+func renderNetworkManagerKeyfiles(connections []configuration.NetworkConnection) (files map[string]string) {
+	files = make(map[string]string)
+
+	for _, connection := range connections {
+		connectionType := connection.GetType()
+
+		var builder strings.Builder
+		builder.WriteString(fmt.Sprintf("[connection]\nid=%s\ntype=%s\n", connection.Name, connectionType))
+		if connection.MACAddress == "" {
+			builder.WriteString(fmt.Sprintf("interface-name=%s\n", connection.Name))
+		}
+
+		switch connectionType {
+		case configuration.NetworkConnectionTypeEthernet:
+			if connection.MACAddress != "" {
+				builder.WriteString(fmt.Sprintf("\n[ethernet]\nmac-address=%s\n", connection.MACAddress))
+			}
+		case configuration.NetworkConnectionTypeVlan:
+			builder.WriteString(fmt.Sprintf("\n[vlan]\nid=%d\nparent=%s\n", connection.VlanID, connection.Parent))
+		case configuration.NetworkConnectionTypeBond:
+			builder.WriteString(fmt.Sprintf("\n[bond]\nmode=%s\n", connection.BondMode))
+			for _, member := range connection.Members {
+				files[member+".nmconnection"] = fmt.Sprintf("[connection]\nid=%s\ntype=ethernet\ninterface-name=%s\nmaster=%s\nslave-type=bond\n", member, member, connection.Name)
+			}
+		}
+
+		ipv4Addresses, ipv6Addresses := splitAddressesByFamily(connection.Addresses)
+		builder.WriteString(renderNetworkManagerIPSection("ipv4", "disabled", connection, ipv4Addresses))
+		builder.WriteString(renderNetworkManagerIPSection("ipv6", "ignore", connection, ipv6Addresses))
+
+		files[connection.Name+".nmconnection"] = builder.String()
+	}
+
+	return
+}
+
+// renderNetworkManagerIPSection returns the [ipv4] or [ipv6] section of a keyfile. The gateway and DNS servers
+// are only added to the section of their IP version.
+func renderNetworkManagerIPSection(section, unusedMethod string, connection configuration.NetworkConnection, addresses []string) string {
+	isSectionFamily := func(address string) bool {
+		ip := net.ParseIP(address)
+		return ip != nil && (ip.To4() != nil) == (section == "ipv4")
+	}
+
+	method := unusedMethod
+	if connection.Dhcp {
+		method = "auto"
+	} else if len(addresses) != 0 {
+		method = "manual"
+	}
+
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("\n[%s]\nmethod=%s\n", section, method))
+	for i, address := range addresses {
+		if i == 0 && isSectionFamily(connection.Gateway) {
+			address = fmt.Sprintf("%s,%s", address, connection.Gateway)
+		}
+		builder.WriteString(fmt.Sprintf("address%d=%s\n", i+1, address))
+	}
+
+	var servers []string
+	for _, server := range connection.Dns {
+		if isSectionFamily(server) {
+			servers = append(servers, server)
+		}
+	}
+	if len(servers) != 0 {
+		builder.WriteString(fmt.Sprintf("dns=%s;\n", strings.Join(servers, ";")))
+	}
+	return builder.String()
+}
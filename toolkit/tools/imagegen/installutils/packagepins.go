@@ -0,0 +1,122 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package installutils
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"microsoft.com/pkggen/internal/logger"
+	"microsoft.com/pkggen/internal/pkgjson"
+	"microsoft.com/pkggen/internal/safechroot"
+	"microsoft.com/pkggen/internal/shell"
+)
+
+// packagePins returns the exact version pinned for each "name=version" entry of a package list,
+// keyed by package name. Entries without a condition or with a range condition are not pins.
+func packagePins(packages []string) (pins map[string]string, err error) {
+	pins = make(map[string]string)
+	for _, pkg := range packages {
+		pkgVer, parseErr := pkgjson.PackagesListEntryToPackageVer(pkg)
+		if parseErr != nil {
+			return nil, parseErr
+		}
+		if pkgVer.Condition != "=" {
+			continue
+		}
+		if existing, ok := pins[pkgVer.Name]; ok && existing != pkgVer.Version {
+			return nil, fmt.Errorf("package (%s) is pinned to both (%s) and (%s)", pkgVer.Name, existing, pkgVer.Version)
+		}
+		pins[pkgVer.Name] = pkgVer.Version
+	}
+	return
+}
+
+// pinMatches reports whether an installed package satisfies a pinned version.
+// The pin may be "version", "version-release" or "epoch:version-release"; a release
+// without the distribution tag (e.g. "4" for "4.cm2") also matches.
+func pinMatches(pin, epoch, version, release string) bool {
+	if epoch == "" || epoch == "(none)" {
+		epoch = "0"
+	}
+	pinEpoch := "0"
+	if i := strings.Index(pin, ":"); i >= 0 {
+		pinEpoch, pin = pin[:i], pin[i+1:]
+	}
+	if pinEpoch != epoch {
+		return false
+	}
+
+	pinParts := strings.SplitN(pin, "-", 2)
+	if pinParts[0] != version {
+		return false
+	}
+	if len(pinParts) == 1 {
+		return true
+	}
+	pinRelease := pinParts[1]
+	return pinRelease == release || strings.HasPrefix(release, pinRelease+".")
+}
+
+// verifyPackagePins checks that every pinned package is installed at its exact pinned version,
+// listing all unmet pins in the returned error
+func verifyPackagePins(installChroot *safechroot.Chroot, packages []string) (err error) {
+	const (
+		squashErrors = true
+		queryFormat  = "%{EPOCH}|%{VERSION}|%{RELEASE}\n"
+	)
+
+	pins, err := packagePins(packages)
+	if err != nil {
+		return
+	}
+	if len(pins) == 0 {
+		return
+	}
+
+	ReportAction("Verifying pinned package versions")
+
+	names := make([]string, 0, len(pins))
+	for name := range pins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var failures []string
+	for _, name := range names {
+		var stdout string
+		runErr := installChroot.UnsafeRun(func() (err error) {
+			stdout, _, err = shell.Execute("rpm", "-q", "--queryformat", queryFormat, name)
+			return
+		})
+		if runErr != nil {
+			failures = append(failures, fmt.Sprintf("package (%s) pinned to (%s) is not installed", name, pins[name]))
+			continue
+		}
+
+		var installed []string
+		matched := false
+		for _, line := range strings.Split(strings.TrimSpace(stdout), "\n") {
+			fields := strings.Split(line, "|")
+			if len(fields) != 3 {
+				continue
+			}
+			installed = append(installed, fmt.Sprintf("%s-%s", fields[1], fields[2]))
+			if pinMatches(pins[name], fields[0], fields[1], fields[2]) {
+				matched = true
+			}
+		}
+		if !matched {
+			failures = append(failures, fmt.Sprintf("package (%s) pinned to (%s) but (%s) is installed", name, pins[name], strings.Join(installed, ", ")))
+		}
+	}
+
+	if len(failures) != 0 {
+		return fmt.Errorf("%d package version pin(s) not met: %s", len(failures), strings.Join(failures, "; "))
+	}
+
+	logger.Log.Infof("All %d pinned package versions are installed", len(pins))
+	return
+}
@@ -0,0 +1,303 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package installutils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"microsoft.com/pkggen/imagegen/configuration"
+	"microsoft.com/pkggen/internal/file"
+)
+
+const (
+	pamDir             = "etc/pam.d"
+	pamAuthFile        = "system-auth"
+	pamAccountFile     = "system-account"
+	pamPasswordFile    = "system-password"
+	pwqualityModule    = "pam_pwquality.so"
+	faillockModule     = "pam_faillock.so"
+	pamUnixModule      = "pam_unix.so"
+	pwqualityDropInDir = "etc/security/pwquality.conf.d"
+	faillockConfFile   = "etc/security/faillock.conf"
+)
+
+// pamModuleDirs are the directories PAM modules are installed to
+var pamModuleDirs = []string{"usr/lib64/security", "usr/lib/security", "lib64/security", "lib/security"}
+
+// pamLine is a parsed rule of a /etc/pam.d file
+type pamLine struct {
+	pamType string
+	control string
+	module  string
+	rest    string
+}
+
+// parsePamLine splits a PAM rule into its type, control and module. Controls may be
+// a bracketed list of actions, such as "[success=1 default=bad]".
+func parsePamLine(line string) (parsed pamLine, ok bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) < 3 {
+		return
+	}
+	parsed.pamType = strings.TrimPrefix(fields[0], "-")
+
+	remainder := strings.TrimSpace(strings.TrimPrefix(line, fields[0]))
+	if strings.HasPrefix(remainder, "[") {
+		end := strings.Index(remainder, "]")
+		if end < 0 {
+			return
+		}
+		parsed.control = remainder[:end+1]
+		remainder = remainder[end+1:]
+	} else {
+		parsed.control = fields[1]
+		remainder = strings.TrimPrefix(remainder, fields[1])
+	}
+
+	parsed.rest = strings.TrimSpace(remainder)
+	restFields := strings.Fields(parsed.rest)
+	if len(restFields) == 0 {
+		return
+	}
+	parsed.module = filepath.Base(restFields[0])
+	ok = true
+	return
+}
+
+// findPamLine returns the index of the first rule of the given type, optionally using the given module, or -1
+func findPamLine(lines []string, pamType, module string) int {
+	for i, line := range lines {
+		parsed, ok := parsePamLine(line)
+		if ok && parsed.pamType == pamType && (module == "" || parsed.module == module) {
+			return i
+		}
+	}
+	return -1
+}
+
+func insertLines(lines []string, index int, newLines ...string) []string {
+	result := make([]string, 0, len(lines)+len(newLines))
+	result = append(result, lines[:index]...)
+	result = append(result, newLines...)
+	return append(result, lines[index:]...)
+}
+
+// configurePam applies the password quality and faillock settings, adding the modules
+// to the system-wide PAM stacks if they are not already used.
+func configurePam(installRoot string, settings configuration.Pam) (err error) {
+	if settings.IsEmpty() {
+		return
+	}
+
+	ReportAction("Configuring PAM")
+
+	if len(settings.PasswordQuality) != 0 {
+		err = requirePamModule(installRoot, pwqualityModule, "libpwquality")
+		if err != nil {
+			return
+		}
+
+		pwqualityConfPath := filepath.Join(installRoot, pwqualityDropInDir, "90-imager.conf")
+		err = os.MkdirAll(filepath.Dir(pwqualityConfPath), os.ModePerm)
+		if err != nil {
+			return
+		}
+		err = file.Write(renderPwqualityConf(settings.PasswordQuality), pwqualityConfPath)
+		if err != nil {
+			return
+		}
+
+		err = editPamFile(installRoot, pamPasswordFile, addPamPwquality)
+		if err != nil {
+			return
+		}
+	}
+
+	if settings.Faillock.IsEnabled() {
+		err = requirePamModule(installRoot, faillockModule, "pam")
+		if err != nil {
+			return
+		}
+
+		faillockConfPath := filepath.Join(installRoot, faillockConfFile)
+		existingConf := ""
+		if exists, _ := file.PathExists(faillockConfPath); exists {
+			var content []byte
+			content, err = os.ReadFile(faillockConfPath)
+			if err != nil {
+				return
+			}
+			existingConf = string(content)
+		}
+		err = file.Write(renderFaillockConf(existingConf, settings.Faillock), faillockConfPath)
+		if err != nil {
+			return
+		}
+
+		err = editPamFile(installRoot, pamAuthFile, addPamFaillockAuth)
+		if err != nil {
+			return
+		}
+
+		accountFile := pamAccountFile
+		if exists, _ := file.PathExists(filepath.Join(installRoot, pamDir, accountFile)); !exists {
+			accountFile = pamAuthFile
+		}
+		err = editPamFile(installRoot, accountFile, addPamFaillockAccount)
+	}
+
+	return
+}
+
+// requirePamModule returns an error naming the package to install if a PAM module is missing from the image
+func requirePamModule(installRoot, module, packageName string) (err error) {
+	for _, dir := range pamModuleDirs {
+		if exists, _ := file.PathExists(filepath.Join(installRoot, dir, module)); exists {
+			return
+		}
+	}
+	return fmt.Errorf("PAM module (%s) is not installed, add (%s) to the package lists", module, packageName)
+}
+
+func editPamFile(installRoot, pamFile string, edit func(string) (string, error)) (err error) {
+	pamFilePath := filepath.Join(installRoot, pamDir, pamFile)
+	content, err := os.ReadFile(pamFilePath)
+	if err != nil {
+		return fmt.Errorf("failed to read PAM configuration (%s): %w", pamFile, err)
+	}
+
+	edited, err := edit(string(content))
+	if err != nil {
+		return fmt.Errorf("failed to update PAM configuration (%s): %w", pamFile, err)
+	}
+
+	return file.Write(edited, pamFilePath)
+}
+
+func renderPwqualityConf(options map[string]string) string {
+	names := make([]string, 0, len(options))
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var builder strings.Builder
+	builder.WriteString("# Generated from the image configuration's Pam settings\n")
+	for _, name := range names {
+		builder.WriteString(fmt.Sprintf("%s = %s\n", name, options[name]))
+	}
+	return builder.String()
+}
+
+// renderFaillockConf comments out the existing settings overridden by the configuration and appends them.
+// even_deny_root is never set, so root can not be locked out.
+func renderFaillockConf(existing string, settings configuration.PamFaillock) string {
+	overridden := map[string]bool{"deny": true}
+	var builder strings.Builder
+
+	values := []string{fmt.Sprintf("deny = %d", settings.Deny)}
+	if settings.UnlockTime != 0 {
+		overridden["unlock_time"] = true
+		values = append(values, fmt.Sprintf("unlock_time = %d", settings.UnlockTime))
+	}
+	if settings.FailInterval != 0 {
+		overridden["fail_interval"] = true
+		values = append(values, fmt.Sprintf("fail_interval = %d", settings.FailInterval))
+	}
+
+	if existing != "" {
+		for _, line := range strings.Split(strings.TrimRight(existing, "\n"), "\n") {
+			key := strings.TrimSpace(strings.SplitN(line, "=", 2)[0])
+			if overridden[key] {
+				line = "# " + line
+			}
+			builder.WriteString(line + "\n")
+		}
+	}
+
+	builder.WriteString("# Generated from the image configuration's Pam settings\n")
+	for _, value := range values {
+		builder.WriteString(value + "\n")
+	}
+	return builder.String()
+}
+
+// addPamPwquality checks new passwords with pam_pwquality before pam_unix stores them
+func addPamPwquality(content string) (edited string, err error) {
+	lines := strings.Split(content, "\n")
+	if findPamLine(lines, "password", pwqualityModule) >= 0 {
+		return content, nil
+	}
+
+	first := findPamLine(lines, "password", "")
+	if first < 0 {
+		return "", fmt.Errorf("no password rules found")
+	}
+
+	// pam_unix must reuse the password accepted by pam_pwquality instead of prompting again
+	unix := findPamLine(lines, "password", pamUnixModule)
+	if unix >= 0 && !strings.Contains(lines[unix], "use_authtok") {
+		lines[unix] = strings.TrimRight(lines[unix], " \t") + " use_authtok"
+	}
+
+	lines = insertLines(lines, first, fmt.Sprintf("password requisite %s", pwqualityModule))
+	return strings.Join(lines, "\n"), nil
+}
+
+// addPamFaillockAuth records failed logins around pam_unix. A failed pam_unix jumps to the
+// "authfail" rule which denies the login, a successful one skips it.
+func addPamFaillockAuth(content string) (edited string, err error) {
+	lines := strings.Split(content, "\n")
+	if findPamLine(lines, "auth", faillockModule) >= 0 {
+		return content, nil
+	}
+
+	unix := findPamLine(lines, "auth", pamUnixModule)
+	if unix < 0 {
+		return "", fmt.Errorf("no auth rule for (%s) found", pamUnixModule)
+	}
+	unixLine, _ := parsePamLine(lines[unix])
+
+	// Keep pam_unix's original effect on success, e.g. ending the stack if it was sufficient
+	successControl := "required"
+	if unixLine.control == "sufficient" {
+		successControl = "sufficient"
+	}
+
+	lines[unix] = fmt.Sprintf("auth [success=1 default=bad] %s", unixLine.rest)
+	lines = insertLines(lines, unix+1,
+		fmt.Sprintf("auth [default=die] %s authfail", faillockModule),
+		fmt.Sprintf("auth %s %s authsucc", successControl, faillockModule),
+	)
+
+	first := findPamLine(lines, "auth", "")
+	lines = insertLines(lines, first, fmt.Sprintf("auth required %s preauth", faillockModule))
+	return strings.Join(lines, "\n"), nil
+}
+
+// addPamFaillockAccount denies locked accounts during account management
+func addPamFaillockAccount(content string) (edited string, err error) {
+	lines := strings.Split(content, "\n")
+	if findPamLine(lines, "account", faillockModule) >= 0 {
+		return content, nil
+	}
+
+	rule := fmt.Sprintf("account required %s", faillockModule)
+	first := findPamLine(lines, "account", "")
+	if first < 0 {
+		return strings.TrimRight(content, "\n") + "\n" + rule + "\n", nil
+	}
+
+	lines = insertLines(lines, first, rule)
+	return strings.Join(lines, "\n"), nil
+}
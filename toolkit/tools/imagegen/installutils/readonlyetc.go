@@ -0,0 +1,120 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package installutils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"microsoft.com/pkggen/imagegen/configuration"
+	"microsoft.com/pkggen/internal/file"
+	"microsoft.com/pkggen/internal/logger"
+)
+
+// etcBootWrite is a path under /etc the system writes to at boot, if its condition is met in the image.
+type etcBootWrite struct {
+	dir    string
+	reason string
+	needed func(installRoot string) (bool, error)
+}
+
+// etcBootWrites are the paths which must stay writable when /etc is read-only
+var etcBootWrites = []etcBootWrite{
+	{
+		dir:    "/etc/ssh",
+		reason: "sshd generates its host keys there at first boot",
+		needed: func(installRoot string) (bool, error) {
+			sshdInstalled, err := file.PathExists(filepath.Join(installRoot, "usr/sbin/sshd"))
+			if err != nil || !sshdInstalled {
+				return false, err
+			}
+			hostKeys, err := filepath.Glob(filepath.Join(installRoot, "etc/ssh/ssh_host_*_key"))
+			return len(hostKeys) == 0, err
+		},
+	},
+}
+
+// configureReadOnlyEtc installs a oneshot service which bind mounts /etc read-only early in boot, then
+// mounts a writable overlay over each of the configured directories. It runs once nothing else writes
+// to /etc during the build, so it can check the paths the system writes to at boot are writable.
+func configureReadOnlyEtc(installRoot string, readOnlyEtc configuration.ReadOnlyEtc) (err error) {
+	const (
+		overlayScript  = "/usr/libexec/imager/mount-etc-overlays"
+		overlayService = "/etc/systemd/system/imager-etc-overlays.service"
+	)
+
+	if !readOnlyEtc.Enable {
+		return
+	}
+
+	ReportAction("Configuring read-only /etc")
+
+	err = checkEtcBootWrites(installRoot, readOnlyEtc)
+	if err != nil {
+		return
+	}
+
+	// The writable directories are created in the image, they can't be created once /etc is read-only
+	for _, dir := range readOnlyEtc.WritableDirs {
+		err = os.MkdirAll(filepath.Join(installRoot, dir), os.ModePerm)
+		if err != nil {
+			return
+		}
+	}
+
+	script := renderEtcOverlayScript(readOnlyEtc)
+	service := renderOverlayService("ReadOnlyEtc", "Read-only /etc with writable overlays", readOnlyEtc.PersistentDir, overlayScript)
+	return installOverlayService(installRoot, overlayScript, script, overlayService, service)
+}
+
+// checkEtcBootWrites returns an error if the system writes to a path under /etc at boot which isn't
+// in one of the writable directories.
+func checkEtcBootWrites(installRoot string, readOnlyEtc configuration.ReadOnlyEtc) (err error) {
+	const machineIDFile = "etc/machine-id"
+
+	for _, bootWrite := range etcBootWrites {
+		if readOnlyEtc.IsWritable(bootWrite.dir) {
+			continue
+		}
+
+		var needed bool
+		needed, err = bootWrite.needed(installRoot)
+		if err != nil {
+			return
+		}
+		if needed {
+			return fmt.Errorf("(%s) must be in the [ReadOnlyEtc] [WritableDirs], %s", bootWrite.dir, bootWrite.reason)
+		}
+	}
+
+	// systemd copes with a read-only machine-id by using a new transient one at each boot
+	machineID, err := os.ReadFile(filepath.Join(installRoot, machineIDFile))
+	if err != nil && !os.IsNotExist(err) {
+		return
+	}
+	err = nil
+	if strings.TrimSpace(string(machineID)) == "" && !readOnlyEtc.IsWritable("/"+machineIDFile) {
+		logger.Log.Warnf("/%s is generated at first boot but /etc is read-only, the machine ID will change at every boot", machineIDFile)
+	}
+
+	return
+}
+
+// renderEtcOverlayScript returns the script making /etc read-only with a bind mount and mounting the writable overlays.
+func renderEtcOverlayScript(readOnlyEtc configuration.ReadOnlyEtc) string {
+	const tmpfsOverlayName = "imager-etc-overlays"
+
+	var builder strings.Builder
+	builder.WriteString("#!/bin/sh\n")
+	builder.WriteString("# Generated from the image configuration's ReadOnlyEtc settings\n")
+	builder.WriteString("set -e\n")
+	builder.WriteString("mount --bind /etc /etc\n")
+	builder.WriteString("mount -o remount,bind,ro /etc\n")
+	if len(readOnlyEtc.WritableDirs) != 0 {
+		renderOverlayMounts(&builder, tmpfsOverlayName, readOnlyEtc.OverlaySize, readOnlyEtc.PersistentDir, readOnlyEtc.WritableDirs)
+	}
+	return builder.String()
+}
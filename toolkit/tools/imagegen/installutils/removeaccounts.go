@@ -0,0 +1,262 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package installutils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"microsoft.com/pkggen/internal/logger"
+	"microsoft.com/pkggen/internal/safechroot"
+	"microsoft.com/pkggen/internal/shell"
+)
+
+const (
+	// defaultFirstRegularAccountID is the first UID and GID of a regular account if login.defs doesn't set them
+	defaultFirstRegularAccountID = 1000
+	passwdFile                   = "etc/passwd"
+	groupFile                    = "etc/group"
+	loginDefsFile                = "etc/login.defs"
+)
+
+var (
+	// criticalOwnershipDirs are the directories which may not contain files owned by a removed user
+	criticalOwnershipDirs = []string{"etc", "usr", "boot"}
+
+	errOwnedFileFound = errors.New("owned file found")
+)
+
+// accountEntry is an entry of /etc/passwd or /etc/group
+type accountEntry struct {
+	name string
+	id   int
+	// gid is the primary group of a user, unused for groups
+	gid int
+	// home is the home directory of a user, unused for groups
+	home string
+}
+
+// parseAccountFile reads the name, ID and, for users, the primary group of each entry of an /etc/passwd or /etc/group file
+func parseAccountFile(content string) (entries []accountEntry, err error) {
+	const (
+		nameField = 0
+		idField   = 2
+		gidField  = 3
+		homeField = 5
+	)
+
+	for i, line := range strings.Split(content, "\n") {
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, ":")
+		if len(fields) <= idField {
+			return nil, fmt.Errorf("line %d has too few fields", i+1)
+		}
+
+		entry := accountEntry{name: fields[nameField], gid: -1}
+		entry.id, err = strconv.Atoi(fields[idField])
+		if err != nil {
+			return nil, fmt.Errorf("line %d has an invalid ID (%s)", i+1, fields[idField])
+		}
+		if len(fields) > gidField {
+			if gid, convertErr := strconv.Atoi(fields[gidField]); convertErr == nil {
+				entry.gid = gid
+			}
+		}
+		if len(fields) > homeField {
+			entry.home = fields[homeField]
+		}
+		entries = append(entries, entry)
+	}
+	return
+}
+
+func readAccountFile(installRoot, accountFile string) (entries []accountEntry, err error) {
+	content, err := os.ReadFile(filepath.Join(installRoot, accountFile))
+	if err != nil {
+		return
+	}
+
+	entries, err = parseAccountFile(string(content))
+	if err != nil {
+		err = fmt.Errorf("failed to parse (/%s): %w", accountFile, err)
+	}
+	return
+}
+
+// readFirstRegularAccountID returns the first ID of a regular account, set by the key (UID_MIN or GID_MIN)
+// in the image's /etc/login.defs. Lower IDs belong to system accounts.
+func readFirstRegularAccountID(installRoot, key string) (id int, err error) {
+	content, err := os.ReadFile(filepath.Join(installRoot, loginDefsFile))
+	if os.IsNotExist(err) {
+		return defaultFirstRegularAccountID, nil
+	}
+	if err != nil {
+		return
+	}
+
+	return parseLoginDefsID(string(content), key)
+}
+
+// parseLoginDefsID returns the ID set by the key in login.defs, or defaultFirstRegularAccountID if it isn't set.
+// As with the shadow utilities, the last setting of the key wins.
+func parseLoginDefsID(loginDefs, key string) (id int, err error) {
+	id = defaultFirstRegularAccountID
+	for _, line := range strings.Split(loginDefs, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != key {
+			continue
+		}
+
+		id, err = strconv.Atoi(fields[1])
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s (%s) in (/%s)", key, fields[1], loginDefsFile)
+		}
+	}
+	return
+}
+
+func findAccount(entries []accountEntry, name string) (entry accountEntry, found bool) {
+	for _, entry = range entries {
+		if entry.name == name {
+			return entry, true
+		}
+	}
+	return
+}
+
+// removeUsers deletes users shipped by the base image, along with their home directories.
+// System accounts and users owning files in /etc, /usr or /boot are never removed.
+func removeUsers(installChroot *safechroot.Chroot, users []string) (err error) {
+	const squashErrors = false
+
+	if len(users) == 0 {
+		return
+	}
+
+	installRoot := installChroot.RootDir()
+	passwd, err := readAccountFile(installRoot, passwdFile)
+	if err != nil {
+		return
+	}
+	uidMin, err := readFirstRegularAccountID(installRoot, "UID_MIN")
+	if err != nil {
+		return
+	}
+
+	for _, user := range users {
+		entry, found := findAccount(passwd, user)
+		if !found {
+			return fmt.Errorf("cannot remove user (%s): it does not exist in the image", user)
+		}
+		if entry.id < uidMin {
+			return fmt.Errorf("cannot remove user (%s): UID %d is below UID_MIN (%d), it belongs to a system account", user, entry.id, uidMin)
+		}
+
+		ownedFile, findErr := findFileOwnedBy(installRoot, entry.id)
+		if findErr != nil {
+			return findErr
+		}
+		if ownedFile != "" {
+			return fmt.Errorf("cannot remove user (%s): it owns (%s)", user, ownedFile)
+		}
+
+		logger.Log.Infof("Removing user (%s)", user)
+		ReportActionf("Removing user: %s", user)
+		err = installChroot.UnsafeRun(func() error {
+			return shell.ExecuteLive(squashErrors, "userdel", "--remove", user)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to remove user (%s): %w", user, err)
+		}
+	}
+
+	return
+}
+
+// removeGroups deletes groups shipped by the base image. System groups and the
+// primary groups of the remaining users are never removed.
+func removeGroups(installChroot *safechroot.Chroot, groups []string) (err error) {
+	const squashErrors = false
+
+	if len(groups) == 0 {
+		return
+	}
+
+	installRoot := installChroot.RootDir()
+	groupEntries, err := readAccountFile(installRoot, groupFile)
+	if err != nil {
+		return
+	}
+	passwd, err := readAccountFile(installRoot, passwdFile)
+	if err != nil {
+		return
+	}
+	gidMin, err := readFirstRegularAccountID(installRoot, "GID_MIN")
+	if err != nil {
+		return
+	}
+
+	for _, group := range groups {
+		entry, found := findAccount(groupEntries, group)
+		if !found {
+			return fmt.Errorf("cannot remove group (%s): it does not exist in the image", group)
+		}
+		if entry.id < gidMin {
+			return fmt.Errorf("cannot remove group (%s): GID %d is below GID_MIN (%d), it belongs to a system group", group, entry.id, gidMin)
+		}
+		for _, user := range passwd {
+			if user.gid == entry.id {
+				return fmt.Errorf("cannot remove group (%s): it is the primary group of user (%s)", group, user.name)
+			}
+		}
+
+		logger.Log.Infof("Removing group (%s)", group)
+		ReportActionf("Removing group: %s", group)
+		err = installChroot.UnsafeRun(func() error {
+			return shell.ExecuteLive(squashErrors, "groupdel", group)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to remove group (%s): %w", group, err)
+		}
+	}
+
+	return
+}
+
+// findFileOwnedBy returns the first file in the critical directories owned by the given UID, relative to the install root
+func findFileOwnedBy(installRoot string, uid int) (ownedFile string, err error) {
+	for _, dir := range criticalOwnershipDirs {
+		err = filepath.Walk(filepath.Join(installRoot, dir), func(path string, info os.FileInfo, walkErr error) error {
+			if walkErr != nil {
+				if os.IsNotExist(walkErr) {
+					return nil
+				}
+				return walkErr
+			}
+
+			stat, ok := info.Sys().(*syscall.Stat_t)
+			if ok && int(stat.Uid) == uid {
+				ownedFile = "/" + strings.TrimPrefix(path, filepath.Clean(installRoot)+"/")
+				return errOwnedFileFound
+			}
+			return nil
+		})
+
+		if errors.Is(err, errOwnedFileFound) {
+			return ownedFile, nil
+		}
+		if err != nil {
+			return
+		}
+	}
+	return
+}
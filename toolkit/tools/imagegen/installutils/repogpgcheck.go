@@ -0,0 +1,109 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package installutils
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"microsoft.com/pkggen/internal/file"
+	"microsoft.com/pkggen/internal/logger"
+)
+
+const (
+	// repoFilesDir is where tdnf reads the repository definitions from
+	repoFilesDir = "etc/yum.repos.d"
+)
+
+// repoGpgCheck is the signature checking setting of one repository in a .repo file
+type repoGpgCheck struct {
+	id       string
+	enabled  bool
+	gpgCheck bool
+}
+
+// gpgCheckArgs returns the tdnf arguments controlling package signature checks
+func (t TdnfSettings) gpgCheckArgs() []string {
+	if t.RequireGpgCheck {
+		return nil
+	}
+	return []string{"--nogpgcheck"}
+}
+
+// verifyRepoGpgCheck fails if an enabled repository defined under one of the given roots does not
+// check package signatures. The roots are the build environment tdnf runs in and the install root,
+// which holds the repositories of a base image.
+func verifyRepoGpgCheck(roots ...string) (err error) {
+	var unsigned []string
+
+	for _, root := range roots {
+		repoFiles, globErr := filepath.Glob(filepath.Join(root, repoFilesDir, "*.repo"))
+		if globErr != nil {
+			return globErr
+		}
+
+		for _, repoFile := range repoFiles {
+			lines, readErr := file.ReadLines(repoFile)
+			if readErr != nil {
+				return fmt.Errorf("failed to read repository file (%s): %w", repoFile, readErr)
+			}
+
+			for _, repo := range parseRepoGpgCheck(lines) {
+				logger.Log.Infof("Inspected repository (%s) in (%s): enabled=%t, gpgcheck=%t", repo.id, repoFile, repo.enabled, repo.gpgCheck)
+				if repo.enabled && !repo.gpgCheck {
+					unsigned = append(unsigned, fmt.Sprintf("%s (%s)", repo.id, repoFile))
+				}
+			}
+		}
+	}
+
+	if len(unsigned) != 0 {
+		return fmt.Errorf("%d enabled repository(s) do not check package signatures (gpgcheck=0): %s", len(unsigned), strings.Join(unsigned, ", "))
+	}
+	return
+}
+
+// parseRepoGpgCheck reads the enabled and gpgcheck settings of every repository in a .repo file.
+// Both default to on, as they do in tdnf.
+func parseRepoGpgCheck(lines []string) (repos []repoGpgCheck) {
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			repos = append(repos, repoGpgCheck{
+				id:       strings.TrimSpace(line[1 : len(line)-1]),
+				enabled:  true,
+				gpgCheck: true,
+			})
+			continue
+		}
+
+		if len(repos) == 0 || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		keyValue := strings.SplitN(line, "=", 2)
+		if len(keyValue) != 2 {
+			continue
+		}
+
+		repo := &repos[len(repos)-1]
+		switch strings.TrimSpace(keyValue[0]) {
+		case "enabled":
+			repo.enabled = repoBoolValue(keyValue[1])
+		case "gpgcheck":
+			repo.gpgCheck = repoBoolValue(keyValue[1])
+		}
+	}
+	return
+}
+
+// repoBoolValue returns the value of a boolean .repo setting
+func repoBoolValue(value string) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "0", "false", "no", "off":
+		return false
+	}
+	return true
+}
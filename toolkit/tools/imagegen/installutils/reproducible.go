@@ -0,0 +1,93 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package installutils
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+	"microsoft.com/pkggen/internal/logger"
+)
+
+const (
+	// sourceDateEpochEnv is the standard variable tools read a fixed build timestamp from
+	sourceDateEpochEnv = "SOURCE_DATE_EPOCH"
+	// e2fsprogsFakeTimeEnv makes mke2fs use a fixed time for the filesystem creation and check times
+	e2fsprogsFakeTimeEnv = "E2FSPROGS_FAKE_TIME"
+)
+
+// SourceDateEpoch returns the build timestamp set in SOURCE_DATE_EPOCH, or 0 if it is not set.
+func SourceDateEpoch() (epoch int64, err error) {
+	value, isSet := os.LookupEnv(sourceDateEpochEnv)
+	if !isSet {
+		return
+	}
+
+	epoch, err = strconv.ParseInt(value, 10, 64)
+	if err != nil || epoch < 0 {
+		err = fmt.Errorf("invalid %s (%s), must be a non-negative number of seconds since the epoch", sourceDateEpochEnv, value)
+	}
+	return
+}
+
+// ConfigureReproducibleBuild exports the build timestamp from SOURCE_DATE_EPOCH to every tool run during
+// the build which supports a fixed timestamp. It must be called before any partition is formatted.
+func ConfigureReproducibleBuild() (err error) {
+	epoch, err := SourceDateEpoch()
+	if err != nil {
+		return
+	}
+
+	logger.Log.Infof("Building reproducibly with timestamp %d", epoch)
+	for _, env := range []string{sourceDateEpochEnv, e2fsprogsFakeTimeEnv} {
+		err = os.Setenv(env, strconv.FormatInt(epoch, 10))
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// NormalizeTimestamps sets the access and modification times of every file in the install root to epoch.
+// Symlinks are updated themselves rather than their targets. The pseudo filesystems a chroot mounts into
+// the install root are skipped.
+func NormalizeTimestamps(installRoot string, epoch int64) (err error) {
+	ReportAction("Normalizing file timestamps")
+
+	skippedDirs := pseudoFilesystemDirs(installRoot)
+	timestamp := unix.NsecToTimespec(epoch * 1e9)
+	times := []unix.Timespec{timestamp, timestamp}
+
+	err = filepath.WalkDir(installRoot, func(path string, entry fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+
+		if entry.IsDir() && skippedDirs[path] {
+			return filepath.SkipDir
+		}
+
+		return unix.UtimesNanoAt(unix.AT_FDCWD, path, times, unix.AT_SYMLINK_NOFOLLOW)
+	})
+	if err != nil {
+		err = fmt.Errorf("failed to normalize timestamps under (%s): %w", installRoot, err)
+	}
+
+	return
+}
+
+// pseudoFilesystemDirs returns the directories a chroot mounts pseudo filesystems on under installRoot,
+// which must be skipped when walking the install root.
+func pseudoFilesystemDirs(installRoot string) (dirs map[string]bool) {
+	dirs = make(map[string]bool)
+	for _, dir := range []string{"dev", "proc", "sys", "run"} {
+		dirs[filepath.Join(installRoot, dir)] = true
+	}
+	return
+}
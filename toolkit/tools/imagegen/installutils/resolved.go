@@ -0,0 +1,97 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package installutils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"microsoft.com/pkggen/imagegen/configuration"
+	"microsoft.com/pkggen/internal/file"
+	"microsoft.com/pkggen/internal/logger"
+	"microsoft.com/pkggen/internal/safechroot"
+)
+
+const (
+	resolvedService  = "systemd-resolved.service"
+	resolvedConfFile = "etc/systemd/resolved.conf.d/90-imager.conf"
+	resolvConfFile   = "etc/resolv.conf"
+)
+
+// resolvConfTargets are the files systemd-resolved maintains for each ResolvConf mode
+var resolvConfTargets = map[string]string{
+	configuration.ResolvConfStub:   "../run/systemd/resolve/stub-resolv.conf",
+	configuration.ResolvConfUplink: "../run/systemd/resolve/resolv.conf",
+}
+
+// configureResolved writes the systemd-resolved settings into a resolved.conf drop-in and enables the service.
+// /etc/resolv.conf is only replaced by linkResolvConf, once nothing else needs name resolution during the build.
+func configureResolved(installChroot *safechroot.Chroot, settings configuration.Resolved) (err error) {
+	const systemdUnitDir = "usr/lib/systemd/system"
+
+	if settings.IsEmpty() {
+		return
+	}
+
+	ReportAction("Configuring systemd-resolved")
+
+	installRoot := installChroot.RootDir()
+	if exists, _ := file.PathExists(filepath.Join(installRoot, systemdUnitDir, resolvedService)); !exists {
+		return fmt.Errorf("cannot configure systemd-resolved: it is not installed, add the 'systemd-resolved' package to the package lists")
+	}
+
+	resolvedConfPath := filepath.Join(installRoot, resolvedConfFile)
+	err = os.MkdirAll(filepath.Dir(resolvedConfPath), os.ModePerm)
+	if err != nil {
+		return
+	}
+
+	err = file.Write(renderResolvedConf(settings), resolvedConfPath)
+	if err != nil {
+		return
+	}
+
+	return enableService(installChroot, resolvedService)
+}
+
+func renderResolvedConf(settings configuration.Resolved) string {
+	var builder strings.Builder
+	builder.WriteString("# Generated from the image configuration's Resolved settings\n")
+	builder.WriteString("[Resolve]\n")
+	if len(settings.DNS) != 0 {
+		builder.WriteString(fmt.Sprintf("DNS=%s\n", strings.Join(settings.DNS, " ")))
+	}
+	if len(settings.FallbackDNS) != 0 {
+		builder.WriteString(fmt.Sprintf("FallbackDNS=%s\n", strings.Join(settings.FallbackDNS, " ")))
+	}
+	if len(settings.Domains) != 0 {
+		builder.WriteString(fmt.Sprintf("Domains=%s\n", strings.Join(settings.Domains, " ")))
+	}
+	if settings.DNSSEC != "" {
+		builder.WriteString(fmt.Sprintf("DNSSEC=%s\n", settings.DNSSEC))
+	}
+	if settings.DNSOverTLS != "" {
+		builder.WriteString(fmt.Sprintf("DNSOverTLS=%s\n", settings.DNSOverTLS))
+	}
+	return builder.String()
+}
+
+// linkResolvConf points /etc/resolv.conf at the file systemd-resolved maintains for the ResolvConf mode.
+// The link dangles inside the build chroot, so it is made after the post-install scripts have run.
+func linkResolvConf(installRoot, resolvConf string) (err error) {
+	if resolvConf == "" {
+		return
+	}
+
+	resolvConfPath := filepath.Join(installRoot, resolvConfFile)
+	logger.Log.Infof("Linking /%s to (%s)", resolvConfFile, resolvConfTargets[resolvConf])
+	err = os.Remove(resolvConfPath)
+	if err != nil && !os.IsNotExist(err) {
+		return
+	}
+
+	return os.Symlink(resolvConfTargets[resolvConf], resolvConfPath)
+}
@@ -0,0 +1,82 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package installutils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"microsoft.com/pkggen/imagegen/configuration"
+	"microsoft.com/pkggen/internal/logger"
+	"microsoft.com/pkggen/internal/shell"
+)
+
+// tarArtifactType is the disk artifact type archiving the disk's file systems as a root filesystem tarball
+const tarArtifactType = "tar"
+
+// ExtractRootfsArtifact archives the file systems of a finished disk as "disk<N>.rootfs.tar" when the disk has a
+// tar artifact, for use as a container base or a chroot. The partitions are mounted read-only at their mount
+// points and archived with their ownership, permissions, ACLs and extended attributes, including SELinux labels.
+// - workDirPath is the directory the archive is written to
+// - mountPointMap is the map of mountpoints to partition device paths
+// - mountPointToFsTypeMap is the map of mountpoints to file system types
+// - mountPointToOverlayMap is the map of mountpoints to overlay devices, which can't be archived
+func ExtractRootfsArtifact(workDirPath string, diskIndex int, disk configuration.Disk, mountPointMap, mountPointToFsTypeMap map[string]string, mountPointToOverlayMap map[string]*Overlay) (err error) {
+	const (
+		squashErrors = false
+		readOnlyArgs = "ro"
+	)
+
+	if !hasArtifactType(disk.Artifacts, tarArtifactType) {
+		return
+	}
+
+	if len(mountPointToOverlayMap) != 0 {
+		return fmt.Errorf("a %s artifact can't be created for a disk with overlay partitions", tarArtifactType)
+	}
+
+	ReportAction("Archiving the root filesystem")
+
+	mountDir, err := os.MkdirTemp("", "rootfs-artifact-")
+	if err != nil {
+		return
+	}
+	// Not RemoveAll, the directory must be empty once everything is unmounted
+	defer os.Remove(mountDir)
+
+	mountPointToMountArgsMap := make(map[string]string, len(mountPointMap))
+	for mountPoint := range mountPointMap {
+		mountPointToMountArgsMap[mountPoint] = readOnlyArgs
+	}
+
+	installMap, err := CreateInstallRoot(mountDir, mountPointMap, mountPointToFsTypeMap, mountPointToMountArgsMap, nil)
+	defer func() {
+		cleanupErr := DestroyInstallRoot(mountDir, installMap, nil)
+		if err == nil {
+			err = cleanupErr
+		}
+	}()
+	if err != nil {
+		return fmt.Errorf("failed to mount the disk's file systems: %w", err)
+	}
+
+	output := filepath.Join(workDirPath, fmt.Sprintf("disk%d.rootfs.tar", diskIndex))
+	logger.Log.Infof("Archiving the disk's file systems to (%s)", output)
+	err = shell.ExecuteLive(squashErrors, "tar", "--xattrs", "--xattrs-include=*", "--acls", "--selinux", "--numeric-owner", "-cpf", output, "-C", mountDir, ".")
+	if err != nil {
+		return fmt.Errorf("failed to archive the root filesystem: %w", err)
+	}
+
+	return
+}
+
+func hasArtifactType(artifacts []configuration.Artifact, artifactType string) bool {
+	for _, artifact := range artifacts {
+		if artifact.Type == artifactType {
+			return true
+		}
+	}
+	return false
+}
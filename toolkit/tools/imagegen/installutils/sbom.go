@@ -0,0 +1,372 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package installutils
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"microsoft.com/pkggen/imagegen/configuration"
+	"microsoft.com/pkggen/internal/logger"
+	"microsoft.com/pkggen/internal/shell"
+)
+
+const (
+	// SbomTempPath is where the software bill of materials is written while building an image,
+	// the imager moves it next to the output image once the build is complete
+	SbomTempPath = "/tmp/sbom/sbom.json"
+
+	sbomToolName   = "imager"
+	sbomNoAssert   = "NOASSERTION"
+	sbomRpmQueryFS = "\t"
+
+	// gpgPubkeyPackage is the name of the pseudo-packages rpm lists for every imported GPG key
+	gpgPubkeyPackage = "gpg-pubkey"
+
+	spdxLicenseRefPrefix = "LicenseRef-"
+)
+
+var (
+	// rpmToSpdxLicenses maps the short license names used by RPM spec files to their SPDX identifiers
+	rpmToSpdxLicenses = map[string]string{
+		"AGPLv3":               "AGPL-3.0-only",
+		"AGPLv3+":              "AGPL-3.0-or-later",
+		"ASL 1.1":              "Apache-1.1",
+		"ASL 2.0":              "Apache-2.0",
+		"Artistic 2.0":         "Artistic-2.0",
+		"BSD":                  "BSD-3-Clause",
+		"BSD with advertising": "BSD-4-Clause",
+		"Boost":                "BSL-1.0",
+		"GFDL":                 "GFDL-1.1-or-later",
+		"GPL+":                 "GPL-1.0-or-later",
+		"GPLv2":                "GPL-2.0-only",
+		"GPLv2+":               "GPL-2.0-or-later",
+		"GPLv3":                "GPL-3.0-only",
+		"GPLv3+":               "GPL-3.0-or-later",
+		"LGPLv2":               "LGPL-2.0-only",
+		"LGPLv2+":              "LGPL-2.0-or-later",
+		"LGPLv2.1":             "LGPL-2.1-only",
+		"LGPLv2.1+":            "LGPL-2.1-or-later",
+		"LGPLv3":               "LGPL-3.0-only",
+		"LGPLv3+":              "LGPL-3.0-or-later",
+		"MPLv1.1":              "MPL-1.1",
+		"MPLv2.0":              "MPL-2.0",
+		"OpenLDAP":             "OLDAP-2.8",
+		"PSF":                  "PSF-2.0",
+		"Python":               "Python-2.0",
+		"zlib":                 "Zlib",
+	}
+
+	// spdxLicenseIDRegex matches a term that already is an SPDX license identifier, optionally with the "or later" suffix
+	spdxLicenseIDRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9.-]*\+?$`)
+	// spdxLicenseRefInvalidRegex matches the characters a LicenseRef may not contain
+	spdxLicenseRefInvalidRegex = regexp.MustCompile(`[^A-Za-z0-9.-]+`)
+)
+
+// sbomPackage describes a single installed RPM
+type sbomPackage struct {
+	Name    string
+	Version string
+	Release string
+	Arch    string
+	License string
+}
+
+// purl returns the package URL identifying the RPM
+func (p *sbomPackage) purl() string {
+	return fmt.Sprintf("pkg:rpm/mariner/%s@%s-%s?arch=%s", p.Name, p.Version, p.Release, p.Arch)
+}
+
+type spdxDocument struct {
+	SPDXVersion       string            `json:"spdxVersion"`
+	DataLicense       string            `json:"dataLicense"`
+	SPDXID            string            `json:"SPDXID"`
+	Name              string            `json:"name"`
+	DocumentNamespace string            `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo  `json:"creationInfo"`
+	Packages          []spdxPackageInfo `json:"packages"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackageInfo struct {
+	Name             string            `json:"name"`
+	SPDXID           string            `json:"SPDXID"`
+	VersionInfo      string            `json:"versionInfo"`
+	DownloadLocation string            `json:"downloadLocation"`
+	LicenseConcluded string            `json:"licenseConcluded"`
+	LicenseDeclared  string            `json:"licenseDeclared"`
+	CopyrightText    string            `json:"copyrightText"`
+	ExternalRefs     []spdxExternalRef `json:"externalRefs"`
+}
+
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type cycloneDXDocument struct {
+	BOMFormat   string               `json:"bomFormat"`
+	SpecVersion string               `json:"specVersion"`
+	Version     int                  `json:"version"`
+	Metadata    cycloneDXMetadata    `json:"metadata"`
+	Components  []cycloneDXComponent `json:"components"`
+}
+
+type cycloneDXMetadata struct {
+	Timestamp string          `json:"timestamp"`
+	Tools     []cycloneDXTool `json:"tools"`
+}
+
+type cycloneDXTool struct {
+	Name string `json:"name"`
+}
+
+type cycloneDXComponent struct {
+	Type       string              `json:"type"`
+	Name       string              `json:"name"`
+	Version    string              `json:"version"`
+	PURL       string              `json:"purl"`
+	Licenses   []cycloneDXLicense  `json:"licenses,omitempty"`
+	Properties []cycloneDXProperty `json:"properties"`
+}
+
+type cycloneDXLicense struct {
+	Expression string `json:"expression"`
+}
+
+type cycloneDXProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// GenerateSbom writes a software bill of materials describing every RPM installed under installRoot to outputPath.
+func GenerateSbom(installRoot, imageName, outputPath string, format configuration.SbomFormat) (err error) {
+	if format == configuration.SbomFormatNone {
+		return
+	}
+
+	ReportActionf("Generating %s software bill of materials", format)
+
+	packages, err := installedSbomPackages(installRoot)
+	if err != nil {
+		return
+	}
+
+	sbom, err := renderSbom(packages, imageName, format, time.Now().UTC())
+	if err != nil {
+		return
+	}
+
+	err = os.MkdirAll(filepath.Dir(outputPath), os.ModePerm)
+	if err != nil {
+		return
+	}
+
+	logger.Log.Infof("Writing software bill of materials with (%d) packages to (%s)", len(packages), outputPath)
+	err = os.WriteFile(outputPath, sbom, 0644)
+	return
+}
+
+// installedSbomPackages queries the RPM database under installRoot for all installed packages.
+func installedSbomPackages(installRoot string) (packages []sbomPackage, err error) {
+	queryFormat := strings.Join([]string{"%{NAME}", "%{VERSION}", "%{RELEASE}", "%{ARCH}", "%{LICENSE}"}, sbomRpmQueryFS) + "\n"
+	stdout, stderr, err := shell.Execute("rpm", "--root", installRoot, "-qa", "--qf", queryFormat)
+	if err != nil {
+		logger.Log.Warn(stderr)
+		err = fmt.Errorf("failed to query installed packages: %w", err)
+		return
+	}
+
+	return parseSbomPackages(stdout)
+}
+
+// parseSbomPackages parses the output of the rpm query used by installedSbomPackages, sorted by name.
+func parseSbomPackages(rpmOutput string) (packages []sbomPackage, err error) {
+	const expectedFields = 5
+
+	for _, line := range strings.Split(rpmOutput, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		fields := strings.Split(line, sbomRpmQueryFS)
+		if len(fields) != expectedFields {
+			err = fmt.Errorf("unexpected rpm query output: %s", line)
+			return
+		}
+
+		// Imported GPG keys are listed as packages by rpm, but are not software installed in the image
+		if fields[0] == gpgPubkeyPackage {
+			continue
+		}
+
+		packages = append(packages, sbomPackage{
+			Name:    fields[0],
+			Version: fields[1],
+			Release: fields[2],
+			Arch:    fields[3],
+			License: fields[4],
+		})
+	}
+
+	sort.Slice(packages, func(i, j int) bool {
+		if packages[i].Name != packages[j].Name {
+			return packages[i].Name < packages[j].Name
+		}
+		return packages[i].purl() < packages[j].purl()
+	})
+	return
+}
+
+// renderSbom converts the package list into the requested SBOM format.
+func renderSbom(packages []sbomPackage, imageName string, format configuration.SbomFormat, created time.Time) (sbom []byte, err error) {
+	const timestampFormat = "2006-01-02T15:04:05Z"
+
+	var document interface{}
+	switch format {
+	case configuration.SbomFormatSPDX:
+		document = newSpdxDocument(packages, imageName, created.Format(timestampFormat))
+	case configuration.SbomFormatCycloneDX:
+		document = newCycloneDXDocument(packages, created.Format(timestampFormat))
+	default:
+		err = fmt.Errorf("unsupported SBOM format (%s)", format)
+		return
+	}
+
+	return json.MarshalIndent(document, "", "  ")
+}
+
+func newSpdxDocument(packages []sbomPackage, imageName, created string) (document spdxDocument) {
+	// Derive the namespace from the contents so the same image always gets the same namespace
+	contentHash := sha256.New()
+	for _, pkg := range packages {
+		fmt.Fprintln(contentHash, pkg.purl())
+	}
+
+	document = spdxDocument{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              imageName,
+		DocumentNamespace: fmt.Sprintf("https://spdx.org/spdxdocs/%s-%x", imageName, contentHash.Sum(nil)),
+		CreationInfo: spdxCreationInfo{
+			Created:  created,
+			Creators: []string{fmt.Sprintf("Tool: %s", sbomToolName)},
+		},
+		Packages: []spdxPackageInfo{},
+	}
+
+	for i, pkg := range packages {
+		license := spdxLicenseExpression(pkg.License)
+		if license == "" {
+			license = sbomNoAssert
+		}
+
+		document.Packages = append(document.Packages, spdxPackageInfo{
+			Name:             pkg.Name,
+			SPDXID:           fmt.Sprintf("SPDXRef-Package-%d", i),
+			VersionInfo:      fmt.Sprintf("%s-%s", pkg.Version, pkg.Release),
+			DownloadLocation: sbomNoAssert,
+			LicenseConcluded: sbomNoAssert,
+			LicenseDeclared:  license,
+			CopyrightText:    sbomNoAssert,
+			ExternalRefs: []spdxExternalRef{
+				{
+					ReferenceCategory: "PACKAGE-MANAGER",
+					ReferenceType:     "purl",
+					ReferenceLocator:  pkg.purl(),
+				},
+			},
+		})
+	}
+	return
+}
+
+func newCycloneDXDocument(packages []sbomPackage, created string) (document cycloneDXDocument) {
+	document = cycloneDXDocument{
+		BOMFormat:   "CycloneDX",
+		SpecVersion: "1.4",
+		Version:     1,
+		Metadata: cycloneDXMetadata{
+			Timestamp: created,
+			Tools:     []cycloneDXTool{{Name: sbomToolName}},
+		},
+		Components: []cycloneDXComponent{},
+	}
+
+	for _, pkg := range packages {
+		component := cycloneDXComponent{
+			Type:    "library",
+			Name:    pkg.Name,
+			Version: fmt.Sprintf("%s-%s", pkg.Version, pkg.Release),
+			PURL:    pkg.purl(),
+			Properties: []cycloneDXProperty{
+				{Name: "rpm:arch", Value: pkg.Arch},
+			},
+		}
+		if license := spdxLicenseExpression(pkg.License); license != "" {
+			component.Licenses = []cycloneDXLicense{{Expression: license}}
+		}
+
+		document.Components = append(document.Components, component)
+	}
+	return
+}
+
+// spdxLicenseExpression converts the License tag of an RPM into an SPDX license expression. The "and" and "or"
+// operators are upper-cased, known RPM short names are replaced by their SPDX identifiers and any other term
+// which is not an SPDX identifier already becomes a LicenseRef. An empty license stays empty.
+func spdxLicenseExpression(rpmLicense string) string {
+	var (
+		expression []string
+		term       []string
+	)
+
+	flushTerm := func() {
+		if len(term) > 0 {
+			expression = append(expression, spdxLicenseID(strings.Join(term, " ")))
+			term = nil
+		}
+	}
+
+	fields := strings.Fields(strings.NewReplacer("(", " ( ", ")", " ) ").Replace(rpmLicense))
+	for _, field := range fields {
+		switch strings.ToLower(field) {
+		case "and", "or":
+			flushTerm()
+			expression = append(expression, strings.ToUpper(field))
+		case "(", ")":
+			flushTerm()
+			expression = append(expression, field)
+		default:
+			term = append(term, field)
+		}
+	}
+	flushTerm()
+
+	return strings.NewReplacer("( ", "(", " )", ")").Replace(strings.Join(expression, " "))
+}
+
+// spdxLicenseID returns the SPDX identifier of a single RPM license term.
+func spdxLicenseID(term string) string {
+	if id, found := rpmToSpdxLicenses[term]; found {
+		return id
+	}
+	if spdxLicenseIDRegex.MatchString(term) {
+		return term
+	}
+	return spdxLicenseRefPrefix + strings.Trim(spdxLicenseRefInvalidRegex.ReplaceAllString(term, "-"), "-")
+}
@@ -0,0 +1,255 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package installutils
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"microsoft.com/pkggen/imagegen/configuration"
+	"microsoft.com/pkggen/internal/file"
+	"microsoft.com/pkggen/internal/logger"
+	"microsoft.com/pkggen/internal/safechroot"
+	"microsoft.com/pkggen/internal/shell"
+)
+
+const (
+	// mokDir is where the Machine Owner Keys are staged in the image for enrollment at first boot
+	mokDir = "/usr/share/imager/mok"
+	// mokEnrolledMarker is created once the enrollment was requested, so it is only requested once
+	mokEnrolledMarker = "/var/lib/imager/mok-enrollment-requested"
+)
+
+// efiBootloaderNames returns the file names of shim and grub in the ESP's fallback boot directory for an architecture.
+func efiBootloaderNames(arch string) (shimName, grubName string, err error) {
+	switch arch {
+	case "amd64":
+		return "bootx64.efi", "grubx64.efi", nil
+	case "arm64":
+		return "bootaa64.efi", "grubaa64.efi", nil
+	default:
+		return "", "", fmt.Errorf("secure boot is not supported on (%s)", arch)
+	}
+}
+
+// ConfigureSecureBoot puts the signed shim and grub onto the ESP mounted at /boot/efi, and stages the Machine Owner
+// Keys with a oneshot service requesting their enrollment at first boot. The enrollment is confirmed in MokManager,
+// which shim starts at the next boot.
+func ConfigureSecureBoot(installChroot *safechroot.Chroot, secureBoot configuration.SecureBoot) (err error) {
+	const espDir = "boot/efi"
+
+	if !secureBoot.Enable {
+		return
+	}
+
+	ReportAction("Configuring secure boot")
+
+	shimName, grubName, err := efiBootloaderNames(runtime.GOARCH)
+	if err != nil {
+		return
+	}
+
+	efiBootPath := filepath.Join(installChroot.RootDir(), espDir, espBootDir)
+	bootloaders := []struct {
+		name    string
+		source  string
+		pkgName string
+	}{
+		{name: shimName, source: secureBoot.ShimSource, pkgName: configuration.ShimPackage},
+		{name: grubName, source: secureBoot.GrubSource, pkgName: configuration.GrubEfiPackage},
+	}
+	for _, bootloader := range bootloaders {
+		bootloaderPath := filepath.Join(efiBootPath, bootloader.name)
+		if bootloader.source != "" {
+			logger.Log.Infof("Installing the signed (%s) as (/%s/%s)", bootloader.source, espBootDir, bootloader.name)
+			err = file.CopyAndChangeMode(bootloader.source, bootloaderPath, bootDirectoryDirMode, bootDirectoryFileMode)
+			if err != nil {
+				return fmt.Errorf("failed to install the signed bootloader (%s): %w", bootloader.source, err)
+			}
+			continue
+		}
+
+		var exists bool
+		exists, err = file.PathExists(bootloaderPath)
+		if err != nil {
+			return
+		}
+		if !exists {
+			return fmt.Errorf("(/%s/%s) is missing from the ESP, is %s installed?", espBootDir, bootloader.name, bootloader.pkgName)
+		}
+	}
+
+	if len(secureBoot.MokCertificates) == 0 {
+		return
+	}
+
+	return stageMokEnrollment(installChroot, secureBoot)
+}
+
+// stageMokEnrollment copies the MOK certificates into the image as DER files and installs the first boot service
+// which imports them with mokutil.
+func stageMokEnrollment(installChroot *safechroot.Chroot, secureBoot configuration.SecureBoot) (err error) {
+	const (
+		passwordHashFile     = "password.hash"
+		passwordHashFileMode = 0600
+		enrollScript         = "/usr/libexec/imager/enroll-mok"
+		enrollService        = "imager-enroll-mok.service"
+		scriptFileMode       = 0755
+	)
+
+	installRoot := installChroot.RootDir()
+	err = os.MkdirAll(filepath.Join(installRoot, mokDir), os.ModePerm)
+	if err != nil {
+		return
+	}
+
+	var stagedCertificates []string
+	for i, certificatePath := range secureBoot.MokCertificates {
+		var der []byte
+		der, err = readMokCertificate(certificatePath, time.Now())
+		if err != nil {
+			return
+		}
+
+		name := strings.TrimSuffix(filepath.Base(certificatePath), filepath.Ext(certificatePath))
+		stagedCertificate := filepath.Join(mokDir, fmt.Sprintf("%02d-%s.der", i, name))
+		logger.Log.Infof("Staging (%s) for MOK enrollment as (%s)", certificatePath, stagedCertificate)
+		err = os.WriteFile(filepath.Join(installRoot, stagedCertificate), der, bootDirectoryFileMode)
+		if err != nil {
+			return
+		}
+		stagedCertificates = append(stagedCertificates, stagedCertificate)
+	}
+
+	// Only the password's hash is kept in the image, without it mokutil asks for root's password at enrollment
+	hashFile := ""
+	if secureBoot.MokPassword != "" {
+		var passwordHash string
+		err = installChroot.UnsafeRun(func() (err error) {
+			// The password is typed twice on stdin, so it does not show up in the process list
+			var stdout, stderr string
+			input := fmt.Sprintf("%s\n%s\n", secureBoot.MokPassword, secureBoot.MokPassword)
+			stdout, stderr, err = shell.ExecuteWithStdin(input, "mokutil", "--generate-hash")
+			if err != nil {
+				return fmt.Errorf("failed to hash the MOK password: %v: %w", stderr, err)
+			}
+			passwordHash, err = parseMokPasswordHash(stdout)
+			return
+		})
+		if err != nil {
+			return
+		}
+
+		hashFile = filepath.Join(mokDir, passwordHashFile)
+		err = os.WriteFile(filepath.Join(installRoot, hashFile), []byte(passwordHash), passwordHashFileMode)
+		if err != nil {
+			return
+		}
+	}
+
+	scriptPath := filepath.Join(installRoot, enrollScript)
+	err = os.MkdirAll(filepath.Dir(scriptPath), os.ModePerm)
+	if err != nil {
+		return
+	}
+	err = file.Write(renderMokEnrollScript(stagedCertificates, hashFile), scriptPath)
+	if err != nil {
+		return
+	}
+	err = os.Chmod(scriptPath, scriptFileMode)
+	if err != nil {
+		return
+	}
+
+	err = file.Write(renderMokEnrollService(enrollScript), filepath.Join(installRoot, "etc/systemd/system", enrollService))
+	if err != nil {
+		return
+	}
+
+	return enableService(installChroot, enrollService)
+}
+
+// parseMokPasswordHash returns the crypt(3) hash printed by "mokutil --generate-hash", after the password
+// prompts it prints when reading the password from stdin.
+func parseMokPasswordHash(output string) (passwordHash string, err error) {
+	hashStart := strings.Index(output, "$")
+	if hashStart < 0 {
+		return "", fmt.Errorf("mokutil printed no MOK password hash")
+	}
+	return strings.TrimSpace(output[hashStart:]) + "\n", nil
+}
+
+// readMokCertificate returns the DER encoding of a PEM or DER certificate, after checking it parses as an X.509
+// certificate. An expired certificate is only logged, as UEFI does not check the validity period of keys.
+func readMokCertificate(certificatePath string, now time.Time) (der []byte, err error) {
+	const pemCertificateType = "CERTIFICATE"
+
+	contents, err := os.ReadFile(certificatePath)
+	if err != nil {
+		return
+	}
+
+	der = contents
+	block, rest := pem.Decode(contents)
+	if block != nil {
+		if block.Type != pemCertificateType {
+			return nil, fmt.Errorf("MOK certificate (%s) is a PEM '%s', expected a '%s'", certificatePath, block.Type, pemCertificateType)
+		}
+		if len(strings.TrimSpace(string(rest))) != 0 {
+			return nil, fmt.Errorf("MOK certificate (%s) must hold a single certificate", certificatePath)
+		}
+		der = block.Bytes
+	}
+
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse MOK certificate (%s): %w", certificatePath, err)
+	}
+
+	if now.After(certificate.NotAfter) {
+		logger.Log.Warnf("MOK certificate (%s) expired on %s", certificatePath, certificate.NotAfter.Format(time.RFC3339))
+	}
+
+	return
+}
+
+// renderMokEnrollScript returns the script requesting the enrollment of the staged certificates, confirmed
+// with the password of hashFile or root's password if it is empty.
+func renderMokEnrollScript(certificates []string, hashFile string) string {
+	var builder strings.Builder
+	builder.WriteString("#!/bin/sh\n")
+	builder.WriteString("# Generated from the image configuration's SecureBoot settings\n")
+	builder.WriteString("set -e\n")
+	builder.WriteString(fmt.Sprintf("mokutil --import %s", strings.Join(certificates, " ")))
+	if hashFile != "" {
+		builder.WriteString(fmt.Sprintf(" --hash-file %s\n", hashFile))
+	} else {
+		builder.WriteString(" --root-pw\n")
+	}
+	builder.WriteString(fmt.Sprintf("mkdir -p %s\n", filepath.Dir(mokEnrolledMarker)))
+	builder.WriteString(fmt.Sprintf("touch %s\n", mokEnrolledMarker))
+	return builder.String()
+}
+
+// renderMokEnrollService returns the oneshot unit running the enrollment script once on a UEFI system.
+func renderMokEnrollService(scriptPath string) string {
+	var builder strings.Builder
+	builder.WriteString("# Generated from the image configuration's SecureBoot settings\n")
+	builder.WriteString("[Unit]\n")
+	builder.WriteString("Description=Request the enrollment of the image's Machine Owner Keys\n")
+	builder.WriteString("ConditionPathIsDirectory=/sys/firmware/efi/efivars\n")
+	builder.WriteString(fmt.Sprintf("ConditionPathExists=!%s\n", mokEnrolledMarker))
+	builder.WriteString("\n[Service]\n")
+	builder.WriteString("Type=oneshot\n")
+	builder.WriteString(fmt.Sprintf("ExecStart=%s\n", scriptPath))
+	builder.WriteString("\n[Install]\n")
+	builder.WriteString("WantedBy=multi-user.target\n")
+	return builder.String()
+}
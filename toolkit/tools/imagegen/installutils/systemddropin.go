@@ -0,0 +1,110 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package installutils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"microsoft.com/pkggen/imagegen/configuration"
+	"microsoft.com/pkggen/internal/file"
+	"microsoft.com/pkggen/internal/logger"
+)
+
+// systemdUnitDirs are the directories of the image systemd loads unit files from, by decreasing priority
+var systemdUnitDirs = []string{"etc/systemd/system", "usr/lib/systemd/system", "lib/systemd/system"}
+
+// installSystemdDropIns writes the drop-ins to /etc/systemd/system/<unit>.d, once the units they apply to are
+// checked to be installed in the image. Drop-in files from the build machine are checked for their syntax.
+func installSystemdDropIns(installRoot string, dropIns []configuration.SystemdDropIn) (err error) {
+	const (
+		dropInDir      = "etc/systemd/system"
+		dropInFileMode = 0644
+	)
+
+	if len(dropIns) == 0 {
+		return
+	}
+
+	ReportAction("Installing systemd drop-ins")
+
+	for _, dropIn := range dropIns {
+		var unitPath string
+		unitPath, err = findSystemdUnit(installRoot, dropIn.Unit)
+		if err != nil {
+			return
+		}
+		if unitPath == "" {
+			return fmt.Errorf("cannot install the [SystemdDropIns] of (%s): the unit is not installed in the image", dropIn.Unit)
+		}
+		logger.Log.Debugf("Found unit (%s) at (%s)", dropIn.Unit, unitPath)
+
+		contents := strings.Join(dropIn.Lines, "\n") + "\n"
+		if dropIn.Path != "" {
+			var fileContents []byte
+			fileContents, err = os.ReadFile(dropIn.Path)
+			if err != nil {
+				return
+			}
+
+			contents = string(fileContents)
+			err = configuration.ValidateSystemdDropIn(contents)
+			if err != nil {
+				return fmt.Errorf("invalid systemd drop-in file (%s): %w", dropIn.Path, err)
+			}
+		}
+
+		dropInDirPath := filepath.Join(installRoot, dropInDir, dropIn.Unit+".d")
+		err = os.MkdirAll(dropInDirPath, os.ModePerm)
+		if err != nil {
+			return
+		}
+
+		dropInPath := filepath.Join(dropInDirPath, dropIn.GetName())
+		logger.Log.Debugf("Installing systemd drop-in (%s)", dropInPath)
+		err = file.Write(contents, dropInPath)
+		if err != nil {
+			return
+		}
+
+		err = os.Chmod(dropInPath, dropInFileMode)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// findSystemdUnit returns the path of a unit's file in the image, or an empty path if it is not installed.
+// An instance of a template unit, such as "getty@tty1.service", is installed if its template is.
+func findSystemdUnit(installRoot, unit string) (unitPath string, err error) {
+	unitNames := []string{unit}
+	if atIndex := strings.Index(unit, "@"); atIndex >= 0 {
+		template := unit[:atIndex+1] + unit[strings.LastIndex(unit, "."):]
+		if template != unit {
+			unitNames = append(unitNames, template)
+		}
+	}
+
+	for _, unitName := range unitNames {
+		for _, unitDir := range systemdUnitDirs {
+			// Lstat, a unit may be a symlink with an absolute target in the image
+			_, err = os.Lstat(filepath.Join(installRoot, unitDir, unitName))
+			if os.IsNotExist(err) {
+				err = nil
+				continue
+			}
+			if err != nil {
+				return
+			}
+			unitPath = filepath.Join("/", unitDir, unitName)
+			return
+		}
+	}
+
+	return
+}
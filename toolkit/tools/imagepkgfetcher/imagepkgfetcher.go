@@ -22,8 +22,10 @@ import (
 var (
 	app = kingpin.New("imagepkgfetcher", "A tool to download a provided list of packages into a given directory.")
 
-	configFile = exe.InputFlag(app, "Path to the image config file.")
-	outDir     = exe.OutputDirFlag(app, "Directory to download packages into.")
+	configFile     = exe.InputFlag(app, "Path to the image config file.")
+	configOverlays = app.Flag("config-overlay", "Path to a config file deep merged over the image config, in order. May be repeated.").ExistingFiles()
+	configVars     = exe.ConfigVarsFlag(app)
+	outDir         = exe.OutputDirFlag(app, "Directory to download packages into.")
 
 	baseDirPath    = app.Flag("base-dir", "Base directory for relative file paths from the config. Defaults to config's directory.").ExistingDir()
 	existingRpmDir = app.Flag("rpm-dir", "Directory that contains already built RPMs. Should contain top level directories for architecture.").Required().ExistingDir()
@@ -76,7 +78,7 @@ func main() {
 		// If an input summary file was provided, simply restore the cache using the file.
 		err = repoutils.RestoreClonedRepoContents(cloner, *inputSummaryFile)
 	} else {
-		err = cloneSystemConfigs(cloner, *configFile, *baseDirPath, *externalOnly, *inputGraph)
+		err = cloneSystemConfigs(cloner, append([]string{*configFile}, *configOverlays...), *baseDirPath, *configVars, *externalOnly, *inputGraph)
 	}
 
 	if err != nil {
@@ -95,10 +97,10 @@ func main() {
 	}
 }
 
-func cloneSystemConfigs(cloner repocloner.RepoCloner, configFile, baseDirPath string, externalOnly bool, inputGraph string) (err error) {
+func cloneSystemConfigs(cloner repocloner.RepoCloner, configFiles []string, baseDirPath string, configVariables map[string]string, externalOnly bool, inputGraph string) (err error) {
 	const cloneDeps = true
 
-	cfg, err := configuration.LoadWithAbsolutePaths(configFile, baseDirPath)
+	cfg, _, err := configuration.LoadLayeredWithAbsolutePaths(configFiles, baseDirPath, configVariables)
 	if err != nil {
 		return
 	}
@@ -111,6 +113,16 @@ func cloneSystemConfigs(cloner repocloner.RepoCloner, configFile, baseDirPath st
 	// Add kernel packages from KernelOptions
 	packageVersionsInConfig = append(packageVersionsInConfig, installutils.KernelPackages(cfg)...)
 
+	// Local packages are installed from their files, but their dependencies come from the repositories
+	for _, systemConfig := range cfg.SystemConfigs {
+		var requires []*pkgjson.PackageVer
+		requires, err = installutils.LocalPackageRequires(systemConfig.LocalPackages)
+		if err != nil {
+			return
+		}
+		packageVersionsInConfig = append(packageVersionsInConfig, requires...)
+	}
+
 	if externalOnly {
 		packageVersionsInConfig, err = filterExternalPackagesOnly(packageVersionsInConfig, inputGraph)
 		if err != nil {
@@ -118,8 +130,10 @@ func cloneSystemConfigs(cloner repocloner.RepoCloner, configFile, baseDirPath st
 		}
 	}
 
-	// Add any packages required by the install tools
-	packageVersionsInConfig = append(packageVersionsInConfig, installutils.GetRequiredPackagesForInstall()...)
+	// Add any packages required by the install tools, so an offline build can use this cache alone
+	for _, systemConfig := range cfg.SystemConfigs {
+		packageVersionsInConfig = append(packageVersionsInConfig, installutils.GetRequiredPackagesForSystemConfig(systemConfig)...)
+	}
 
 	logger.Log.Infof("Cloning: %v", packageVersionsInConfig)
 	err = cloner.Clone(cloneDeps, packageVersionsInConfig...)
@@ -6,9 +6,13 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
+	"io"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strconv"
 
 	"gopkg.in/alecthomas/kingpin.v2"
 	"microsoft.com/pkggen/imagegen/configuration"
@@ -18,22 +22,38 @@ import (
 	"microsoft.com/pkggen/internal/file"
 	"microsoft.com/pkggen/internal/logger"
 	"microsoft.com/pkggen/internal/safechroot"
+	"microsoft.com/pkggen/internal/shell"
+	"microsoft.com/pkggen/internal/systemdependency"
 )
 
 var (
 	app             = kingpin.New("imager", "Tool to create and install images.")
 	buildDir        = app.Flag("build-dir", "Directory to store temporary files while building.").ExistingDir()
 	configFile      = exe.InputFlag(app, "Path to the image config file.")
+	configOverlays  = app.Flag("config-overlay", "Path to a config file deep merged over the image config, in order. May be repeated.").ExistingFiles()
+	configVars      = exe.ConfigVarsFlag(app)
+	effectiveConfig = app.Flag("effective-config", "Path to write the image config to once the overlays are merged, for debugging.").String()
 	localRepo       = app.Flag("local-repo", "Path to local RPM repo").ExistingDir()
 	tdnfTar         = app.Flag("tdnf-worker", "Path to tdnf worker tarball").ExistingFile()
 	repoFile        = app.Flag("repo-file", "Full path to local.repo.").ExistingFile()
+	extraLocalRepos = app.Flag("extra-local-repo", "Path to an additional local RPM repo, bind mounted read-only for offline builds. May be repeated.").ExistingDirs()
 	assets          = app.Flag("assets", "Path to assets directory.").ExistingDir()
 	baseDirPath     = app.Flag("base-dir", "Base directory for relative file paths from the config. Defaults to config's directory.").ExistingDir()
 	outputDir       = app.Flag("output-dir", "Path to directory to place final image.").ExistingDir()
 	liveInstallFlag = app.Flag("live-install", "Enable to perform a live install to the disk specified in config file.").Bool()
 	emitProgress    = app.Flag("emit-progress", "Write progress updates to stdout, such as percent complete and current action.").Bool()
+	skipFsCheck     = app.Flag("skip-fs-check", "Skip the filesystem integrity check of the finished image. Only intended for trusted development builds.").Bool()
+	debugHook       = app.Flag("debug-hook", "Shell command run against the populated install root before it is torn down. The install root path is passed in $IMAGER_INSTALL_ROOT. Only intended for development.").String()
+	debugPause      = app.Flag("debug-pause", "Pause for input once the install root is populated, before it is torn down. Only intended for development.").Bool()
+	partitions      = app.Flag("partition", "Only extract the artifacts of the partition with this index, ID, name or mount point. May be repeated.").Strings()
+	checkpointDir   = app.Flag("checkpoint-dir", "Directory to save the install root to once its packages are installed, and to restore it from on a later build with the same package inputs. Only intended for development.").ExistingDir()
+	baseRootfs      = app.Flag("base-rootfs-tarball", "Rootfs tarball to build the image on when the config doesn't set [BaseRootfsTarball] or [BaseRootfsDir], such as a shared base of several image variants.").ExistingFile()
+	baseRootfsDir   = app.Flag("base-rootfs-dir", "Extracted rootfs directory to build the image on when the config doesn't set [BaseRootfsTarball] or [BaseRootfsDir], such as a shared base of several image variants.").ExistingDir()
 	logFile         = exe.LogFileFlag(app)
 	logLevel        = exe.LogLevelFlag(app)
+	logColor        = exe.LogColorFlag(app)
+
+	nice, ioniceClass, ioniceLevel = exe.ResourceLimitFlags(app)
 )
 
 const (
@@ -48,6 +68,63 @@ const (
 	// sshPubKeysTempDirectory is the directory where installutils expects to pick up ssh public key files to add into
 	// the install directory
 	sshPubKeysTempDirectory = "/tmp/sshpubkeys"
+
+	// sbomFileName is the name of the software bill of materials placed next to the output image
+	sbomFileName = "sbom.json"
+
+	// changeReportFileName is the name of the change report placed next to the output image
+	changeReportFileName = "changes.json"
+
+	// baseRootfsTempDirectory is the directory where installutils expects to pick up the base rootfs tarball
+	baseRootfsTempDirectory = "/tmp/baserootfs"
+
+	// veritySigningTempDirectory is the directory where the verity root hash signing key and certificate are placed
+	veritySigningTempDirectory = "/tmp/veritysigning"
+
+	// grubCfgTemplateTempDirectory is the directory where installutils expects to pick up the grub.cfg template
+	grubCfgTemplateTempDirectory = "/tmp/grubcfgtemplate"
+
+	// grubThemeTempDirectory is the directory where installutils expects to pick up the grub theme and background
+	grubThemeTempDirectory = "/tmp/grubtheme"
+
+	// firmwareTempDirectory is the directory where installutils expects to pick up the firmware files
+	firmwareTempDirectory = "/tmp/firmware"
+
+	// initramfsTempDirectory is the directory where installutils expects to pick up the initramfs files and script
+	initramfsTempDirectory = "/tmp/initramfs"
+
+	// espTempDirectory is the directory where installutils expects to pick up the files merged into the ESP
+	espTempDirectory = "/tmp/esp"
+
+	// localPackagesTempDirectory is the directory where installutils expects to pick up the local RPM files
+	localPackagesTempDirectory = "/tmp/localpackages"
+
+	// systemdDropInsTempDirectory is the directory where installutils expects to pick up the systemd drop-in files
+	systemdDropInsTempDirectory = "/tmp/systemddropins"
+
+	// secureBootTempDirectory is the directory where installutils expects to pick up the signed bootloaders and MOK certificates
+	secureBootTempDirectory = "/tmp/secureboot"
+
+	// udevRulesTempDirectory is the directory where installutils expects to pick up the udev rules files
+	udevRulesTempDirectory = "/tmp/udevrules"
+
+	// auditRulesTempDirectory is the directory where installutils expects to pick up the audit rules files
+	auditRulesTempDirectory = "/tmp/auditrules"
+
+	// firewallRulesetTempDirectory is the directory where installutils expects to pick up the nftables ruleset
+	firewallRulesetTempDirectory = "/tmp/firewallruleset"
+
+	// cloudInitTempDirectory is the directory where installutils expects to pick up the cloud-init config files
+	cloudInitTempDirectory = "/tmp/cloudinit"
+
+	// extraLocalReposMountPoint is where the additional local RPM repos are mounted in the setup chroot
+	extraLocalReposMountPoint = "/mnt/extrarepos"
+
+	// checkpointMountPoint is where the checkpoint directory is mounted in the setup chroot
+	checkpointMountPoint = "/mnt/checkpoint"
+
+	// baseRootfsDirMountPoint is where a base rootfs directory is mounted in the setup chroot
+	baseRootfsDirMountPoint = "/mnt/baserootfs"
 )
 
 func main() {
@@ -57,24 +134,74 @@ func main() {
 	kingpin.MustParse(app.Parse(os.Args[1:]))
 
 	logger.InitBestEffort(*logFile, *logLevel)
+	if !*logColor {
+		logger.SetStderrColors(false)
+	}
 
 	if *emitProgress {
 		installutils.EnableEmittingProgress()
 	}
 
+	err := systemdependency.CheckCapabilities(systemdependency.ImageBuildCapabilities)
+	logger.PanicOnError(err, "Unable to build an image with the current privileges")
+
+	limits := shell.ResourceLimits{Nice: *nice, IoniceClass: *ioniceClass, IoniceLevel: *ioniceLevel}
+	err = limits.IsValid()
+	logger.PanicOnError(err, "Invalid resource limits")
+
 	// Parse Config
-	config, err := configuration.LoadWithAbsolutePaths(*configFile, *baseDirPath)
+	config, err := loadConfig()
 	logger.PanicOnError(err, "Failed to load configuration file (%s) with base directory (%s)", *configFile, *baseDirPath)
 
 	// Currently only process 1 system config
 	systemConfig := config.SystemConfigs[defaultSystemConfig]
 
-	err = buildSystemConfig(systemConfig, config.Disks, *outputDir, *buildDir)
+	if *baseRootfs != "" || *baseRootfsDir != "" {
+		if *baseRootfs != "" && *baseRootfsDir != "" {
+			logger.Log.Fatalf("--base-rootfs-tarball and --base-rootfs-dir can't be used together")
+		}
+		if systemConfig.BaseRootfsTarball != "" || systemConfig.BaseRootfsDir != "" {
+			logger.Log.Fatalf("--base-rootfs-tarball and --base-rootfs-dir can't be used, system configuration (%s) already sets a base rootfs", systemConfig.Name)
+		}
+		systemConfig.BaseRootfsTarball = *baseRootfs
+		systemConfig.BaseRootfsDir = *baseRootfsDir
+	}
+
+	err = config.FilterPartitionArtifacts(systemConfig.PartitionSettings, *partitions)
+	logger.PanicOnError(err, "Failed to select the partitions to extract")
+
+	if systemConfig.Reproducible {
+		err = installutils.ConfigureReproducibleBuild()
+		logger.PanicOnError(err, "Failed to configure a reproducible build")
+	}
+
+	// The raw config files are hashed, so the hash can be checked against the files in source control
+	configHash, err := installutils.ConfigFilesHash(append([]string{*configFile}, *configOverlays...))
+	logger.PanicOnError(err, "Failed to hash the configuration files")
+
+	err = buildSystemConfig(systemConfig, configHash, config.Disks, *outputDir, *buildDir, limits)
 	logger.PanicOnError(err, "Failed to build system configuration")
 
 }
 
-func buildSystemConfig(systemConfig configuration.SystemConfig, disks []configuration.Disk, outputDir, buildDir string) (err error) {
+// loadConfig loads the image config, merged with any config overlays, and writes the merged config
+// to the --effective-config path if it is set
+func loadConfig() (config configuration.Config, err error) {
+	const effectiveConfigFileMode = 0644
+
+	configFiles := append([]string{*configFile}, *configOverlays...)
+	config, merged, err := configuration.LoadLayeredWithAbsolutePaths(configFiles, *baseDirPath, *configVars)
+	if *effectiveConfig != "" && len(merged) != 0 {
+		logger.Log.Infof("Writing effective config to (%s)", *effectiveConfig)
+		writeErr := os.WriteFile(*effectiveConfig, append(merged, '\n'), effectiveConfigFileMode)
+		if writeErr != nil && err == nil {
+			err = writeErr
+		}
+	}
+	return
+}
+
+func buildSystemConfig(systemConfig configuration.SystemConfig, configHash string, disks []configuration.Disk, outputDir, buildDir string, limits shell.ResourceLimits) (err error) {
 	logger.Log.Infof("Building system configuration (%s)", systemConfig.Name)
 
 	const (
@@ -105,6 +232,18 @@ func buildSystemConfig(systemConfig configuration.SystemConfig, disks []configur
 		extraDirectories       []string
 	)
 
+	err = validateExtraLocalRepos()
+	if err != nil {
+		return
+	}
+
+	if systemConfig.UpdateRepo != "" {
+		err = validateUpdateRepo(systemConfig.UpdateRepo, repoFileMountPoint)
+		if err != nil {
+			return
+		}
+	}
+
 	// Get list of packages to install into image
 	packagesToInstall, err := installutils.PackageNamesFromSingleSystemConfig(systemConfig)
 	if err != nil {
@@ -136,7 +275,7 @@ func buildSystemConfig(systemConfig configuration.SystemConfig, disks []configur
 	} else {
 		logger.Log.Info("Creating raw disk in build directory")
 		diskConfig := disks[defaultDiskIndex]
-		diskDevPath, partIDToDevPathMap, partIDToFsTypeMap, isLoopDevice, encryptedRoot, readOnlyRoot, err = setupDisk(buildDir, defaultTempDiskName, *liveInstallFlag, diskConfig, systemConfig.Encryption, systemConfig.ReadOnlyVerityRoot)
+		diskDevPath, partIDToDevPathMap, partIDToFsTypeMap, isLoopDevice, encryptedRoot, readOnlyRoot, err = setupDisk(buildDir, defaultTempDiskName, *liveInstallFlag, diskConfig, systemConfig.Encryption, systemConfig.ReadOnlyVerityRoot, systemConfig.PartitionSettings, limits)
 		if err != nil {
 			return
 		}
@@ -151,25 +290,47 @@ func buildSystemConfig(systemConfig configuration.SystemConfig, disks []configur
 			defer readOnlyRoot.CleanupVerityDevice()
 		}
 
+		// Close the dm-integrity mappings on any exit, before the loopback device is detached
+		if systemConfig.HasIntegrityPartitions() {
+			defer func() {
+				closeErr := diskutils.CloseIntegrityPartitions()
+				if closeErr != nil {
+					logger.Log.Warn("Failed to close integrity partitions")
+					if err == nil {
+						err = closeErr
+					}
+				}
+			}()
+		}
+
 		// Add additional system settings for root encryption
 		err = setupDiskEncryption(&systemConfig, &encryptedRoot, buildDir)
 		if err != nil {
 			return
 		}
 
-		// Select the best kernel package for this environment
-		kernelPkg, err = installutils.SelectKernelPackage(systemConfig, *liveInstallFlag)
-		if err != nil {
-			logger.Log.Errorf("Failed to select a suitable kernel to install in config (%s)", systemConfig.Name)
-			return
-		}
+		// A data-only disk has no operating system, so there is no kernel to install
+		if !systemConfig.DataOnly {
+			// Select the best kernel package for this environment
+			kernelPkg, err = installutils.SelectKernelPackage(systemConfig, *liveInstallFlag)
+			if err != nil {
+				logger.Log.Errorf("Failed to select a suitable kernel to install in config (%s)", systemConfig.Name)
+				return
+			}
 
-		logger.Log.Infof("Selected (%s) for the kernel", kernelPkg)
-		packagesToInstall = append([]string{kernelPkg}, packagesToInstall...)
+			logger.Log.Infof("Selected (%s) for the kernel", kernelPkg)
+			packagesToInstall = append([]string{kernelPkg}, packagesToInstall...)
+		}
 	}
 
 	setupChrootDir := filepath.Join(buildDir, setupRoot)
 
+	checkpoint, err := newPackageCheckpoint(packagesToInstall, systemConfig)
+	if err != nil {
+		logger.Log.Error("Failed to compute the package checkpoint key")
+		return
+	}
+
 	// Create Parition to Mountpoint map
 	mountPointMap, mountPointToFsTypeMap, mountPointToMountArgsMap, diffDiskBuild := installutils.CreateMountPointPartitionMap(partIDToDevPathMap, partIDToFsTypeMap, systemConfig)
 	if diffDiskBuild {
@@ -182,12 +343,42 @@ func buildSystemConfig(systemConfig configuration.SystemConfig, disks []configur
 		}
 	}
 
+	// Fail before anything is installed if the verity boot flow can't find its partitions
+	if !isRootFS {
+		err = installutils.CheckVerityBootPartitions(mountPointMap, systemConfig)
+		if err != nil {
+			return
+		}
+	}
+
 	if isOfflineInstall {
+		repoDir := filepath.Dir(*repoFile)
+		if len(*extraLocalRepos) > 0 {
+			// Use a copy of the repo files so the definitions of the extra repos are not written to the host
+			repoDir, err = createRepoDirWithExtraLocalRepos(buildDir)
+			if err != nil {
+				return
+			}
+			defer os.RemoveAll(repoDir)
+		}
+
 		// Create setup chroot
 		additionalExtraMountPoints := []*safechroot.MountPoint{
 			safechroot.NewMountPoint(*assets, assetsMountPoint, "", safechroot.BindMountPointFlags, ""),
 			safechroot.NewMountPoint(*localRepo, localRepoMountPoint, "", safechroot.BindMountPointFlags, ""),
-			safechroot.NewMountPoint(filepath.Dir(*repoFile), repoFileMountPoint, "", safechroot.BindMountPointFlags, ""),
+			safechroot.NewMountPoint(repoDir, repoFileMountPoint, "", safechroot.BindMountPointFlags, ""),
+		}
+		for i, extraLocalRepo := range *extraLocalRepos {
+			mountPoint := filepath.Join(extraLocalReposMountPoint, strconv.Itoa(i))
+			additionalExtraMountPoints = append(additionalExtraMountPoints, safechroot.NewMountPoint(extraLocalRepo, mountPoint, "", safechroot.ReadOnlyBindMountPointFlags, ""))
+		}
+		if checkpoint != nil {
+			additionalExtraMountPoints = append(additionalExtraMountPoints, safechroot.NewMountPoint(checkpoint.Dir, checkpointMountPoint, "", safechroot.BindMountPointFlags, ""))
+			checkpoint.Dir = checkpointMountPoint
+		}
+		if systemConfig.BaseRootfsDir != "" {
+			additionalExtraMountPoints = append(additionalExtraMountPoints, safechroot.NewMountPoint(systemConfig.BaseRootfsDir, baseRootfsDirMountPoint, "", safechroot.ReadOnlyBindMountPointFlags, ""))
+			systemConfig.BaseRootfsDir = baseRootfsDirMountPoint
 		}
 		extraMountPoints = append(extraMountPoints, additionalExtraMountPoints...)
 
@@ -207,8 +398,12 @@ func buildSystemConfig(systemConfig configuration.SystemConfig, disks []configur
 			return
 		}
 
+		if *debugHook != "" || *debugPause {
+			logger.Log.Infof("Offline build: the install root will be available on the host under (%s)", setupChroot.RootDir())
+		}
+
 		err = setupChroot.Run(func() error {
-			return buildImage(mountPointMap, mountPointToFsTypeMap, mountPointToMountArgsMap, mountPointToOverlayMap, packagesToInstall, systemConfig, diskDevPath, isRootFS, encryptedRoot, readOnlyRoot, diffDiskBuild)
+			return buildImage(mountPointMap, mountPointToFsTypeMap, mountPointToMountArgsMap, mountPointToOverlayMap, packagesToInstall, systemConfig, configHash, diskDevPath, isRootFS, encryptedRoot, readOnlyRoot, diffDiskBuild, checkpoint)
 		})
 		if err != nil {
 			logger.Log.Error("Failed to build image")
@@ -221,12 +416,50 @@ func buildSystemConfig(systemConfig configuration.SystemConfig, disks []configur
 			return
 		}
 
+		if systemConfig.SbomFormat != configuration.SbomFormatNone {
+			err = file.Move(filepath.Join(setupChrootDir, installutils.SbomTempPath), filepath.Join(outputDir, sbomFileName))
+			if err != nil {
+				logger.Log.Error("Failed to move the software bill of materials out of the setup chroot")
+				return
+			}
+		}
+
+		if systemConfig.ChangeReport.Enable {
+			err = file.Move(filepath.Join(setupChrootDir, installutils.ChangeReportTempPath), filepath.Join(outputDir, changeReportFileName))
+			if err != nil {
+				logger.Log.Error("Failed to move the change report out of the setup chroot")
+				return
+			}
+		}
+
+		if !isRootFS {
+			err = resizeFileSystems(disks[defaultDiskIndex], partIDToDevPathMap)
+			if err != nil {
+				logger.Log.Error("Failed to resize filesystems")
+				return
+			}
+
+			// Check the filesystems as soon as they are final, before anything is extracted or copied out of them
+			err = checkFileSystems(partIDToDevPathMap, partIDToFsTypeMap)
+			if err != nil {
+				logger.Log.Error("Failed filesystem check of the finished image")
+				return
+			}
+		}
+
 		// Create any partition-based artifacts
 		err = installutils.ExtractPartitionArtifacts(setupChrootDir, outputDir, defaultDiskIndex, disks[defaultDiskIndex], systemConfig, partIDToDevPathMap, mountPointToOverlayMap)
 		if err != nil {
 			return
 		}
 
+		if !isRootFS {
+			err = installutils.ExtractRootfsArtifact(outputDir, defaultDiskIndex, disks[defaultDiskIndex], mountPointMap, mountPointToFsTypeMap, mountPointToOverlayMap)
+			if err != nil {
+				return
+			}
+		}
+
 		// Copy disk artifact if necessary.
 		// Currently only supports one disk config
 		if !isRootFS {
@@ -240,11 +473,46 @@ func buildSystemConfig(systemConfig configuration.SystemConfig, disks []configur
 			}
 		}
 	} else {
-		err = buildImage(mountPointMap, mountPointToFsTypeMap, mountPointToMountArgsMap, mountPointToOverlayMap, packagesToInstall, systemConfig, diskDevPath, isRootFS, encryptedRoot, readOnlyRoot, diffDiskBuild)
+		if len(*extraLocalRepos) > 0 {
+			logger.Log.Warnf("Ignoring extra local repos (%v), they are only used by offline builds", *extraLocalRepos)
+		}
+
+		err = buildImage(mountPointMap, mountPointToFsTypeMap, mountPointToMountArgsMap, mountPointToOverlayMap, packagesToInstall, systemConfig, configHash, diskDevPath, isRootFS, encryptedRoot, readOnlyRoot, diffDiskBuild, checkpoint)
 		if err != nil {
 			logger.Log.Error("Failed to build image")
 			return
 		}
+
+		if systemConfig.SbomFormat != configuration.SbomFormatNone {
+			err = file.Move(installutils.SbomTempPath, filepath.Join(outputDir, sbomFileName))
+			if err != nil {
+				logger.Log.Error("Failed to move the software bill of materials")
+				return
+			}
+		}
+
+		if systemConfig.ChangeReport.Enable {
+			err = file.Move(installutils.ChangeReportTempPath, filepath.Join(outputDir, changeReportFileName))
+			if err != nil {
+				logger.Log.Error("Failed to move the change report")
+				return
+			}
+		}
+
+		if !isRootFS {
+			err = resizeFileSystems(disks[defaultDiskIndex], partIDToDevPathMap)
+			if err != nil {
+				logger.Log.Error("Failed to resize filesystems")
+				return
+			}
+
+			// Check the filesystems as soon as they are final, before anything is extracted or copied out of them
+			err = checkFileSystems(partIDToDevPathMap, partIDToFsTypeMap)
+			if err != nil {
+				logger.Log.Error("Failed filesystem check of the finished image")
+				return
+			}
+		}
 	}
 
 	// Cleanup encrypted disks
@@ -254,6 +522,36 @@ func buildSystemConfig(systemConfig configuration.SystemConfig, disks []configur
 			logger.Log.Warn("Failed to cleanup encrypted disks")
 			return
 		}
+	} else if systemConfig.HasEncryptedPartitions() {
+		err = diskutils.CloseEncryptedPartitions()
+		if err != nil {
+			logger.Log.Warn("Failed to close encrypted partitions")
+			return
+		}
+	}
+
+	err = moveDetachedHeaders(systemConfig, outputDir)
+	if err != nil {
+		logger.Log.Error("Failed to move the detached LUKS headers")
+		return
+	}
+
+	return
+}
+
+// moveDetachedHeaders places the detached LUKS headers of encrypted partitions next to the output image.
+func moveDetachedHeaders(systemConfig configuration.SystemConfig, outputDir string) (err error) {
+	for _, partitionSetting := range systemConfig.PartitionSettings {
+		headerName := partitionSetting.Encryption.DetachedHeader
+		if headerName == "" {
+			continue
+		}
+
+		logger.Log.Infof("Writing detached LUKS header of partition (%s) to (%s)", partitionSetting.ID, filepath.Join(outputDir, headerName))
+		err = file.Move(filepath.Join(diskutils.DetachedHeaderDir, headerName), filepath.Join(outputDir, headerName))
+		if err != nil {
+			return
+		}
 	}
 
 	return
@@ -270,10 +568,10 @@ func setupDiskEncryption(systemConfig *configuration.SystemConfig, encryptedRoot
 
 		// Copy the default keyfile into the image
 		if len(systemConfig.AdditionalFiles) == 0 {
-			systemConfig.AdditionalFiles = make(map[string]string)
+			systemConfig.AdditionalFiles = make(map[string]configuration.AdditionalFile)
 		}
 
-		systemConfig.AdditionalFiles[encryptedRoot.HostKeyFile] = diskutils.DefaultKeyFilePath
+		systemConfig.AdditionalFiles[encryptedRoot.HostKeyFile] = configuration.AdditionalFile{Path: diskutils.DefaultKeyFilePath}
 		logger.Log.Infof("Adding default key file to systemConfig additional files")
 	}
 
@@ -306,26 +604,26 @@ func setupRootFS(outputDir, installRoot string) (extraMountPoints []*safechroot.
 	return
 }
 
-func setupDisk(outputDir, diskName string, liveInstallFlag bool, diskConfig configuration.Disk, rootEncryption configuration.RootEncryption, readOnlyRootConfig configuration.ReadOnlyVerityRoot) (diskDevPath string, partIDToDevPathMap, partIDToFsTypeMap map[string]string, isLoopDevice bool, encryptedRoot diskutils.EncryptedRootDevice, readOnlyRoot diskutils.VerityDevice, err error) {
+func setupDisk(outputDir, diskName string, liveInstallFlag bool, diskConfig configuration.Disk, rootEncryption configuration.RootEncryption, readOnlyRootConfig configuration.ReadOnlyVerityRoot, partitionSettings []configuration.PartitionSetting, limits shell.ResourceLimits) (diskDevPath string, partIDToDevPathMap, partIDToFsTypeMap map[string]string, isLoopDevice bool, encryptedRoot diskutils.EncryptedRootDevice, readOnlyRoot diskutils.VerityDevice, err error) {
 	const (
 		realDiskType = "path"
 	)
 	if diskConfig.TargetDisk.Type == realDiskType {
 		if liveInstallFlag {
 			diskDevPath = diskConfig.TargetDisk.Value
-			partIDToDevPathMap, partIDToFsTypeMap, encryptedRoot, readOnlyRoot, err = setupRealDisk(diskDevPath, diskConfig, rootEncryption, readOnlyRootConfig)
+			partIDToDevPathMap, partIDToFsTypeMap, encryptedRoot, readOnlyRoot, err = setupRealDisk(diskDevPath, diskConfig, rootEncryption, readOnlyRootConfig, partitionSettings, limits)
 		} else {
 			err = fmt.Errorf("target Disk Type is set but --live-install option is not set. Please check your config or enable the --live-install option")
 			return
 		}
 	} else {
-		diskDevPath, partIDToDevPathMap, partIDToFsTypeMap, encryptedRoot, readOnlyRoot, err = setupLoopDeviceDisk(outputDir, diskName, diskConfig, rootEncryption, readOnlyRootConfig)
+		diskDevPath, partIDToDevPathMap, partIDToFsTypeMap, encryptedRoot, readOnlyRoot, err = setupLoopDeviceDisk(outputDir, diskName, diskConfig, rootEncryption, readOnlyRootConfig, partitionSettings, limits)
 		isLoopDevice = true
 	}
 	return
 }
 
-func setupLoopDeviceDisk(outputDir, diskName string, diskConfig configuration.Disk, rootEncryption configuration.RootEncryption, readOnlyRootConfig configuration.ReadOnlyVerityRoot) (diskDevPath string, partIDToDevPathMap, partIDToFsTypeMap map[string]string, encryptedRoot diskutils.EncryptedRootDevice, readOnlyRoot diskutils.VerityDevice, err error) {
+func setupLoopDeviceDisk(outputDir, diskName string, diskConfig configuration.Disk, rootEncryption configuration.RootEncryption, readOnlyRootConfig configuration.ReadOnlyVerityRoot, partitionSettings []configuration.PartitionSetting, limits shell.ResourceLimits) (diskDevPath string, partIDToDevPathMap, partIDToFsTypeMap map[string]string, encryptedRoot diskutils.EncryptedRootDevice, readOnlyRoot diskutils.VerityDevice, err error) {
 	defer func() {
 		// Detach the loopback device on failure
 		if err != nil && diskDevPath != "" {
@@ -349,7 +647,7 @@ func setupLoopDeviceDisk(outputDir, diskName string, diskConfig configuration.Di
 		return
 	}
 
-	partIDToDevPathMap, partIDToFsTypeMap, encryptedRoot, readOnlyRoot, err = setupRealDisk(diskDevPath, diskConfig, rootEncryption, readOnlyRootConfig)
+	partIDToDevPathMap, partIDToFsTypeMap, encryptedRoot, readOnlyRoot, err = setupRealDisk(diskDevPath, diskConfig, rootEncryption, readOnlyRootConfig, partitionSettings, limits)
 	if err != nil {
 		logger.Log.Errorf("Failed to setup loopback disk partitions (%s)", rawDisk)
 		return
@@ -358,14 +656,14 @@ func setupLoopDeviceDisk(outputDir, diskName string, diskConfig configuration.Di
 	return
 }
 
-func setupRealDisk(diskDevPath string, diskConfig configuration.Disk, rootEncryption configuration.RootEncryption, readOnlyRootConfig configuration.ReadOnlyVerityRoot) (partIDToDevPathMap, partIDToFsTypeMap map[string]string, encryptedRoot diskutils.EncryptedRootDevice, readOnlyRoot diskutils.VerityDevice, err error) {
+func setupRealDisk(diskDevPath string, diskConfig configuration.Disk, rootEncryption configuration.RootEncryption, readOnlyRootConfig configuration.ReadOnlyVerityRoot, partitionSettings []configuration.PartitionSetting, limits shell.ResourceLimits) (partIDToDevPathMap, partIDToFsTypeMap map[string]string, encryptedRoot diskutils.EncryptedRootDevice, readOnlyRoot diskutils.VerityDevice, err error) {
 	const (
 		defaultBlockSize = diskutils.MiB
 		noMaxSize        = 0
 	)
 
 	// Set up partitions
-	partIDToDevPathMap, partIDToFsTypeMap, encryptedRoot, readOnlyRoot, err = diskutils.CreatePartitions(diskDevPath, diskConfig, rootEncryption, readOnlyRootConfig)
+	partIDToDevPathMap, partIDToFsTypeMap, encryptedRoot, readOnlyRoot, err = diskutils.CreatePartitions(diskDevPath, diskConfig, rootEncryption, readOnlyRootConfig, partitionSettings, limits)
 	if err != nil {
 		logger.Log.Errorf("Failed to create partitions on disk (%s)", diskDevPath)
 		return
@@ -381,6 +679,163 @@ func setupRealDisk(diskDevPath string, diskConfig configuration.Disk, rootEncryp
 	return
 }
 
+// resizeFileSystems resizes any file system with a requested [FsSize] or [FsPadding] now that its contents are final
+func resizeFileSystems(diskConfig configuration.Disk, partIDToDevPathMap map[string]string) (err error) {
+	for _, partition := range diskConfig.Partitions {
+		fsSize := partition.FsSize
+		if partition.FsPadding != 0 {
+			fsSize, err = diskutils.GetPaddedFileSystemSize(partIDToDevPathMap[partition.ID], partition.FsPadding)
+			if err != nil {
+				return fmt.Errorf("partition '%s': %w", partition.ID, err)
+			}
+		}
+		if fsSize == 0 {
+			continue
+		}
+
+		installutils.ReportActionf("Resizing filesystem of partition '%s' to %d MiB", partition.ID, fsSize)
+		err = diskutils.ResizeSinglePartitionFileSystem(partIDToDevPathMap[partition.ID], fsSize)
+		if err != nil {
+			return fmt.Errorf("partition '%s': %w", partition.ID, err)
+		}
+	}
+	return
+}
+
+// checkFileSystems verifies the integrity of every filesystem on the finished disk, unless disabled with --skip-fs-check.
+func checkFileSystems(partIDToDevPathMap, partIDToFsTypeMap map[string]string) (err error) {
+	if *skipFsCheck {
+		logger.Log.Warn("Skipping filesystem integrity check of the finished image (--skip-fs-check)")
+		return
+	}
+
+	installutils.ReportAction("Checking filesystems")
+	for partID, devPath := range partIDToDevPathMap {
+		err = diskutils.CheckSinglePartition(devPath, partIDToFsTypeMap[partID])
+		if err != nil {
+			return fmt.Errorf("partition '%s': %w", partID, err)
+		}
+	}
+	return
+}
+
+// extraLocalRepoID returns the ID of the repository of the extra local repo at index
+func extraLocalRepoID(index int) string {
+	return fmt.Sprintf("extra-local-repo-%d", index)
+}
+
+// newPackageCheckpoint returns the package checkpoint of the build if --checkpoint-dir is set, keyed on the
+// packages to install and the metadata of the local repos they are installed from.
+func newPackageCheckpoint(packagesToInstall []string, systemConfig configuration.SystemConfig) (checkpoint *installutils.PackageCheckpoint, err error) {
+	const repoMetadataFile = "repodata/repomd.xml"
+
+	if *checkpointDir == "" {
+		return
+	}
+
+	if systemConfig.DataOnly {
+		logger.Log.Warnf("Ignoring the checkpoint directory (%s), a data-only disk installs no packages", *checkpointDir)
+		return
+	}
+
+	var repoMetadataFiles []string
+	for _, repoDir := range append([]string{*localRepo}, *extraLocalRepos...) {
+		if repoDir != "" {
+			repoMetadataFiles = append(repoMetadataFiles, filepath.Join(repoDir, repoMetadataFile))
+		}
+	}
+
+	key, err := installutils.PackageCheckpointKey(packagesToInstall, systemConfig, repoMetadataFiles)
+	if err != nil {
+		return
+	}
+
+	// The directory is bind mounted into the setup chroot of offline builds, which needs an absolute path
+	dir, err := filepath.Abs(*checkpointDir)
+	if err != nil {
+		return
+	}
+
+	logger.Log.Infof("Package checkpoint key is (%s)", key)
+	checkpoint = &installutils.PackageCheckpoint{Dir: dir, Key: key}
+	return
+}
+
+// validateExtraLocalRepos checks that every extra local repo can be read.
+func validateExtraLocalRepos() (err error) {
+	for _, extraLocalRepo := range *extraLocalRepos {
+		_, err = os.ReadDir(extraLocalRepo)
+		if err != nil {
+			return fmt.Errorf("failed to read extra local repo (%s): %w", extraLocalRepo, err)
+		}
+	}
+	return
+}
+
+// createRepoDirWithExtraLocalRepos creates a directory holding a copy of the repo files next to --repo-file,
+// plus one repo file for each of the extra local repos, pointing at their read-only mount points.
+func createRepoDirWithExtraLocalRepos(buildDir string) (repoDir string, err error) {
+	const extraLocalRepoTemplate = `[%s]
+name=Extra local repo %d
+baseurl=file://%s
+enabled=1
+gpgcheck=0
+skip_if_unavailable=False
+`
+
+	repoDir, err = os.MkdirTemp(buildDir, "imager-repos-")
+	if err != nil {
+		return
+	}
+
+	existingRepoFiles, err := filepath.Glob(filepath.Join(filepath.Dir(*repoFile), "*.repo"))
+	if err != nil {
+		return
+	}
+	for _, existingRepoFile := range existingRepoFiles {
+		err = file.Copy(existingRepoFile, filepath.Join(repoDir, filepath.Base(existingRepoFile)))
+		if err != nil {
+			return
+		}
+	}
+
+	for i := range *extraLocalRepos {
+		repoID := extraLocalRepoID(i)
+		mountPoint := filepath.Join(extraLocalReposMountPoint, strconv.Itoa(i))
+		err = file.Write(fmt.Sprintf(extraLocalRepoTemplate, repoID, i, mountPoint), filepath.Join(repoDir, repoID+".repo"))
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// validateUpdateRepo checks that the repository updates are scoped to is defined in the
+// repository files the build will use.
+func validateUpdateRepo(updateRepo, defaultRepoDir string) (err error) {
+	repoDir := defaultRepoDir
+	if *repoFile != "" {
+		repoDir = filepath.Dir(*repoFile)
+	}
+
+	repoIDs, err := installutils.RepoIDsInDirectory(repoDir)
+	if err != nil {
+		return fmt.Errorf("failed to read repository files in (%s): %w", repoDir, err)
+	}
+	for i := range *extraLocalRepos {
+		repoIDs = append(repoIDs, extraLocalRepoID(i))
+	}
+
+	for _, repoID := range repoIDs {
+		if repoID == updateRepo {
+			return
+		}
+	}
+
+	return fmt.Errorf("[UpdateRepo] (%s) is not defined by any repository file in (%s), found: %v", updateRepo, repoDir, repoIDs)
+}
+
 // fixupExtraFilesIntoChroot will copy extra files needed for the build
 // into the chroot and alter the extra files in the config to point at their new paths.
 func fixupExtraFilesIntoChroot(installChroot *safechroot.Chroot, config *configuration.SystemConfig) (err error) {
@@ -400,7 +855,7 @@ func fixupExtraFilesIntoChroot(installChroot *safechroot.Chroot, config *configu
 		}
 	}
 
-	fixedUpAdditionalFiles := make(map[string]string)
+	fixedUpAdditionalFiles := make(map[string]configuration.AdditionalFile)
 	for srcFile, dstFile := range config.AdditionalFiles {
 		newFilePath := filepath.Join(additionalFilesTempDirectory, srcFile)
 
@@ -415,6 +870,11 @@ func fixupExtraFilesIntoChroot(installChroot *safechroot.Chroot, config *configu
 	config.AdditionalFiles = fixedUpAdditionalFiles
 
 	for i, script := range config.PostInstallScripts {
+		// Inline scripts are part of the config itself
+		if script.Path == "" {
+			continue
+		}
+
 		newFilePath := filepath.Join(postInstallScriptTempDirectory, script.Path)
 
 		fileToCopy := safechroot.FileToCopy{
@@ -426,12 +886,254 @@ func fixupExtraFilesIntoChroot(installChroot *safechroot.Chroot, config *configu
 		filesToCopy = append(filesToCopy, fileToCopy)
 	}
 
+	if config.BaseRootfsTarball != "" {
+		newFilePath := filepath.Join(baseRootfsTempDirectory, filepath.Base(config.BaseRootfsTarball))
+
+		fileToCopy := safechroot.FileToCopy{
+			Src:  config.BaseRootfsTarball,
+			Dest: newFilePath,
+		}
+
+		config.BaseRootfsTarball = newFilePath
+		filesToCopy = append(filesToCopy, fileToCopy)
+	}
+
+	if config.ReadOnlyVerityRoot.RootHashSigningKey != "" {
+		signingKey := filepath.Join(veritySigningTempDirectory, "roothash.key")
+		signingCert := filepath.Join(veritySigningTempDirectory, "roothash.crt")
+
+		filesToCopy = append(filesToCopy,
+			safechroot.FileToCopy{Src: config.ReadOnlyVerityRoot.RootHashSigningKey, Dest: signingKey},
+			safechroot.FileToCopy{Src: config.ReadOnlyVerityRoot.RootHashSigningCert, Dest: signingCert},
+		)
+		config.ReadOnlyVerityRoot.RootHashSigningKey = signingKey
+		config.ReadOnlyVerityRoot.RootHashSigningCert = signingCert
+	}
+
+	if config.GrubCfgTemplate != "" {
+		newFilePath := filepath.Join(grubCfgTemplateTempDirectory, filepath.Base(config.GrubCfgTemplate))
+
+		fileToCopy := safechroot.FileToCopy{
+			Src:  config.GrubCfgTemplate,
+			Dest: newFilePath,
+		}
+
+		config.GrubCfgTemplate = newFilePath
+		filesToCopy = append(filesToCopy, fileToCopy)
+	}
+
+	if config.GrubTheme.Directory != "" {
+		newDirPath := filepath.Join(grubThemeTempDirectory, config.GrubTheme.GetThemeName())
+
+		// FileToCopy only copies single files, so list every file of the theme
+		themeFiles, themeErr := installutils.GrubThemeFiles(config.GrubTheme.Directory)
+		if themeErr != nil {
+			err = fmt.Errorf("failed to read grub theme directory (%s): %w", config.GrubTheme.Directory, themeErr)
+			return
+		}
+
+		for _, relPath := range themeFiles {
+			filesToCopy = append(filesToCopy, safechroot.FileToCopy{
+				Src:  filepath.Join(config.GrubTheme.Directory, relPath),
+				Dest: filepath.Join(newDirPath, relPath),
+			})
+		}
+		config.GrubTheme.Directory = newDirPath
+	}
+
+	if config.GrubTheme.Background != "" {
+		newFilePath := filepath.Join(grubThemeTempDirectory, filepath.Base(config.GrubTheme.Background))
+
+		fileToCopy := safechroot.FileToCopy{
+			Src:  config.GrubTheme.Background,
+			Dest: newFilePath,
+		}
+
+		config.GrubTheme.Background = newFilePath
+		filesToCopy = append(filesToCopy, fileToCopy)
+	}
+
+	for i, firmwareFile := range config.Firmware.Files {
+		// The paths under /lib/firmware are unique, so they also keep the copies apart
+		newFilePath := filepath.Join(firmwareTempDirectory, firmwareFile.Path)
+
+		fileToCopy := safechroot.FileToCopy{
+			Src:  firmwareFile.Source,
+			Dest: newFilePath,
+		}
+
+		config.Firmware.Files[i].Source = newFilePath
+		filesToCopy = append(filesToCopy, fileToCopy)
+	}
+
+	for i, initramfsFile := range config.InitramfsCustomization.Files {
+		// The paths in the initramfs are unique, so they also keep the copies apart
+		newFilePath := filepath.Join(initramfsTempDirectory, "files", initramfsFile.Path)
+
+		fileToCopy := safechroot.FileToCopy{
+			Src:  initramfsFile.Source,
+			Dest: newFilePath,
+		}
+
+		config.InitramfsCustomization.Files[i].Source = newFilePath
+		filesToCopy = append(filesToCopy, fileToCopy)
+	}
+
+	if config.InitramfsCustomization.Script != "" {
+		newFilePath := filepath.Join(initramfsTempDirectory, filepath.Base(config.InitramfsCustomization.Script))
+
+		fileToCopy := safechroot.FileToCopy{
+			Src:  config.InitramfsCustomization.Script,
+			Dest: newFilePath,
+		}
+
+		config.InitramfsCustomization.Script = newFilePath
+		filesToCopy = append(filesToCopy, fileToCopy)
+	}
+
+	for i, localPackage := range config.LocalPackages {
+		// The index keeps apart local packages with the same file name, which is kept for rpm
+		newFilePath := filepath.Join(localPackagesTempDirectory, strconv.Itoa(i), filepath.Base(localPackage))
+
+		fileToCopy := safechroot.FileToCopy{
+			Src:  localPackage,
+			Dest: newFilePath,
+		}
+
+		config.LocalPackages[i] = newFilePath
+		filesToCopy = append(filesToCopy, fileToCopy)
+	}
+
+	for i, espFile := range config.Esp.Files {
+		// The paths in the ESP are unique, so they also keep the copies apart
+		newFilePath := filepath.Join(espTempDirectory, espFile.Path)
+
+		fileToCopy := safechroot.FileToCopy{
+			Src:  espFile.Source,
+			Dest: newFilePath,
+		}
+
+		config.Esp.Files[i].Source = newFilePath
+		filesToCopy = append(filesToCopy, fileToCopy)
+	}
+
+	if config.SecureBoot.ShimSource != "" {
+		newFilePath := filepath.Join(secureBootTempDirectory, "shim", filepath.Base(config.SecureBoot.ShimSource))
+
+		fileToCopy := safechroot.FileToCopy{
+			Src:  config.SecureBoot.ShimSource,
+			Dest: newFilePath,
+		}
+
+		config.SecureBoot.ShimSource = newFilePath
+		filesToCopy = append(filesToCopy, fileToCopy)
+	}
+
+	if config.SecureBoot.GrubSource != "" {
+		newFilePath := filepath.Join(secureBootTempDirectory, "grub", filepath.Base(config.SecureBoot.GrubSource))
+
+		fileToCopy := safechroot.FileToCopy{
+			Src:  config.SecureBoot.GrubSource,
+			Dest: newFilePath,
+		}
+
+		config.SecureBoot.GrubSource = newFilePath
+		filesToCopy = append(filesToCopy, fileToCopy)
+	}
+
+	for i, certificate := range config.SecureBoot.MokCertificates {
+		// The index keeps apart certificates with the same file name, which names the staged certificate
+		newFilePath := filepath.Join(secureBootTempDirectory, "mok", strconv.Itoa(i), filepath.Base(certificate))
+
+		fileToCopy := safechroot.FileToCopy{
+			Src:  certificate,
+			Dest: newFilePath,
+		}
+
+		config.SecureBoot.MokCertificates[i] = newFilePath
+		filesToCopy = append(filesToCopy, fileToCopy)
+	}
+
+	for i, rule := range config.UdevRules {
+		if rule.Path == "" {
+			continue
+		}
+
+		newFilePath := filepath.Join(udevRulesTempDirectory, rule.Path)
+
+		fileToCopy := safechroot.FileToCopy{
+			Src:  rule.Path,
+			Dest: newFilePath,
+		}
+
+		config.UdevRules[i].Path = newFilePath
+		filesToCopy = append(filesToCopy, fileToCopy)
+	}
+
+	for i, rule := range config.AuditRules {
+		if rule.Path == "" {
+			continue
+		}
+
+		newFilePath := filepath.Join(auditRulesTempDirectory, rule.Path)
+
+		fileToCopy := safechroot.FileToCopy{
+			Src:  rule.Path,
+			Dest: newFilePath,
+		}
+
+		config.AuditRules[i].Path = newFilePath
+		filesToCopy = append(filesToCopy, fileToCopy)
+	}
+
+	for i, dropIn := range config.SystemdDropIns {
+		if dropIn.Path == "" {
+			continue
+		}
+
+		// The unit and drop-in name are unique, so they also keep the copies apart
+		newFilePath := filepath.Join(systemdDropInsTempDirectory, dropIn.Unit+".d", dropIn.GetName())
+
+		fileToCopy := safechroot.FileToCopy{
+			Src:  dropIn.Path,
+			Dest: newFilePath,
+		}
+
+		config.SystemdDropIns[i].Path = newFilePath
+		filesToCopy = append(filesToCopy, fileToCopy)
+	}
+
+	if config.Firewall.Ruleset != "" {
+		newFilePath := filepath.Join(firewallRulesetTempDirectory, filepath.Base(config.Firewall.Ruleset))
+
+		fileToCopy := safechroot.FileToCopy{
+			Src:  config.Firewall.Ruleset,
+			Dest: newFilePath,
+		}
+
+		config.Firewall.Ruleset = newFilePath
+		filesToCopy = append(filesToCopy, fileToCopy)
+	}
+
+	for i, configFile := range config.CloudInit.ConfigFiles {
+		// The file names are unique, they are the names the files are installed under
+		newFilePath := filepath.Join(cloudInitTempDirectory, filepath.Base(configFile))
+
+		fileToCopy := safechroot.FileToCopy{
+			Src:  configFile,
+			Dest: newFilePath,
+		}
+
+		config.CloudInit.ConfigFiles[i] = newFilePath
+		filesToCopy = append(filesToCopy, fileToCopy)
+	}
+
 	err = installChroot.AddFiles(filesToCopy...)
 	return
 }
 
 func cleanupExtraFiles() (err error) {
-	dirsToRemove := []string{additionalFilesTempDirectory, postInstallScriptTempDirectory, sshPubKeysTempDirectory}
+	dirsToRemove := []string{additionalFilesTempDirectory, postInstallScriptTempDirectory, sshPubKeysTempDirectory, baseRootfsTempDirectory, veritySigningTempDirectory, grubCfgTemplateTempDirectory, grubThemeTempDirectory, firmwareTempDirectory, initramfsTempDirectory, espTempDirectory, localPackagesTempDirectory, secureBootTempDirectory, udevRulesTempDirectory, auditRulesTempDirectory, systemdDropInsTempDirectory, firewallRulesetTempDirectory, cloudInitTempDirectory}
 
 	for _, dir := range dirsToRemove {
 		logger.Log.Infof("Cleaning up directory %s", dir)
@@ -451,7 +1153,7 @@ func cleanupExtraFilesInChroot(chroot *safechroot.Chroot) (err error) {
 	})
 	return
 }
-func buildImage(mountPointMap, mountPointToFsTypeMap, mountPointToMountArgsMap map[string]string, mountPointToOverlayMap map[string]*installutils.Overlay, packagesToInstall []string, systemConfig configuration.SystemConfig, diskDevPath string, isRootFS bool, encryptedRoot diskutils.EncryptedRootDevice, readOnlyRoot diskutils.VerityDevice, diffDiskBuild bool) (err error) {
+func buildImage(mountPointMap, mountPointToFsTypeMap, mountPointToMountArgsMap map[string]string, mountPointToOverlayMap map[string]*installutils.Overlay, packagesToInstall []string, systemConfig configuration.SystemConfig, configHash, diskDevPath string, isRootFS bool, encryptedRoot diskutils.EncryptedRootDevice, readOnlyRoot diskutils.VerityDevice, diffDiskBuild bool, checkpoint *installutils.PackageCheckpoint) (err error) {
 	const (
 		installRoot       = "/installroot"
 		verityWorkingDir  = "verityworkingdir"
@@ -474,9 +1176,31 @@ func buildImage(mountPointMap, mountPointToFsTypeMap, mountPointToMountArgsMap m
 		defer installutils.DestroyInstallRoot(installRoot, installMap, mountPointToOverlayMap)
 	}
 
-	// Install any tools required for the setup root to function
+	// A data-only disk only needs its partitions, which are already formatted, and the additional files
+	if systemConfig.DataOnly {
+		err = installutils.PopulateDataOnlyInstallRoot(installRoot, systemConfig)
+		if err != nil {
+			err = fmt.Errorf("failed to populate data-only image contents: %w", err)
+			return
+		}
+
+		err = runDebugHook(installRoot)
+		if err != nil {
+			err = fmt.Errorf("debug hook failed: %w", err)
+			return
+		}
+
+		err = installutils.ValidatePostConditions(installRoot, nil, systemConfig.Validate)
+		if err != nil {
+			return
+		}
+
+		return normalizeTimestamps(installRoot, systemConfig)
+	}
+
+	// Install any tools required for the setup root to function, this is either the setuproot chroot or the live installer
 	setupChrootPackages := []string{}
-	toolingPackages := installutils.GetRequiredPackagesForInstall()
+	toolingPackages := installutils.GetRequiredPackagesForSystemConfig(systemConfig)
 	for _, toolingPackage := range toolingPackages {
 		setupChrootPackages = append(setupChrootPackages, toolingPackage.Name)
 	}
@@ -484,15 +1208,8 @@ func buildImage(mountPointMap, mountPointToFsTypeMap, mountPointToMountArgsMap m
 	logger.Log.Infof("HidepidDisabled is %v.", systemConfig.HidepidDisabled)
 	hidepidEnabled := !systemConfig.HidepidDisabled
 
-	if systemConfig.ReadOnlyVerityRoot.Enable {
-		// We will need the veritysetup package (and its dependencies) to manage the verity disk, add them to our
-		// image setup environment (setuproot chroot or live installer).
-		verityPackages := []string{"device-mapper", "veritysetup"}
-		setupChrootPackages = append(setupChrootPackages, verityPackages...)
-	}
-
 	for _, setupChrootPackage := range setupChrootPackages {
-		_, err = installutils.TdnfInstall(setupChrootPackage, rootDir)
+		_, err = installutils.TdnfInstall(setupChrootPackage, rootDir, installutils.NewTdnfSettings(systemConfig))
 		if err != nil {
 			err = fmt.Errorf("failed to install required setup chroot package '%s': %w", setupChrootPackage, err)
 			return
@@ -511,7 +1228,7 @@ func buildImage(mountPointMap, mountPointToFsTypeMap, mountPointToMountArgsMap m
 	defer installChroot.Close(leaveChrootOnDisk)
 
 	// Populate image contents
-	err = installutils.PopulateInstallRoot(installChroot, packagesToInstall, systemConfig, installMap, mountPointToFsTypeMap, mountPointToMountArgsMap, isRootFS, encryptedRoot, diffDiskBuild, hidepidEnabled)
+	changes, err := installutils.PopulateInstallRoot(installChroot, packagesToInstall, systemConfig, configHash, installMap, mountPointToFsTypeMap, mountPointToMountArgsMap, isRootFS, encryptedRoot, diffDiskBuild, hidepidEnabled, checkpoint)
 	if err != nil {
 		err = fmt.Errorf("failed to populate image contents: %s", err)
 		return
@@ -534,20 +1251,44 @@ func buildImage(mountPointMap, mountPointToFsTypeMap, mountPointToMountArgsMap m
 			}
 		}
 
+	}
+
+	err = runDebugHook(installChroot.RootDir())
+	if err != nil {
+		err = fmt.Errorf("debug hook failed: %w", err)
+		return
+	}
+
+	err = installutils.ValidatePostConditions(installChroot.RootDir(), installChroot, systemConfig.Validate)
+	if err != nil {
+		err = fmt.Errorf("image failed validation: %w", err)
+		return
+	}
+
+	err = normalizeTimestamps(installChroot.RootDir(), systemConfig)
+	if err != nil {
+		return
+	}
+
+	if !isRootFS {
 		// Snapshot the root filesystem as a read-only verity disk and update the initramfs.
 		if systemConfig.ReadOnlyVerityRoot.Enable {
+			// Look for the initramfs first, the root can't be written to once it is switched to read-only
 			var initramfsPathList []string
+			initramfsPathList, err = filepath.Glob(filepath.Join(installRoot, "/boot/initrd.img*"))
+			if err != nil || len(initramfsPathList) == 0 {
+				return fmt.Errorf("could not find an initramfs (%v): %w", initramfsPathList, err)
+			}
 			err = readOnlyRoot.SwitchDeviceToReadOnly(mountPointMap["/"], mountPointToMountArgsMap["/"])
 			if err != nil {
 				err = fmt.Errorf("failed to switch root to read-only: %w", err)
 				return
 			}
+			// The signing key and certificate may have been copied into the setup chroot since the device was prepared
+			readOnlyRoot.RootHashSigningKey = systemConfig.ReadOnlyVerityRoot.RootHashSigningKey
+			readOnlyRoot.RootHashSigningCert = systemConfig.ReadOnlyVerityRoot.RootHashSigningCert
 			installutils.ReportAction("Hashing root for read-only with dm-verity, this may take a long time if error correction is enabled")
-			initramfsPathList, err = filepath.Glob(filepath.Join(installRoot, "/boot/initrd.img*"))
-			if err != nil || len(initramfsPathList) != 1 {
-				return fmt.Errorf("could not find single initramfs (%v): %w", initramfsPathList, err)
-			}
-			err = readOnlyRoot.AddRootVerityFilesToInitramfs(verityWorkingDir, initramfsPathList[0])
+			err = readOnlyRoot.AddRootVerityFilesToInitramfs(verityWorkingDir, initramfsPathList)
 			if err != nil {
 				err = fmt.Errorf("failed to include read-only root files in initramfs: %w", err)
 				return
@@ -555,12 +1296,64 @@ func buildImage(mountPointMap, mountPointToFsTypeMap, mountPointToMountArgsMap m
 		}
 	}
 
+	// Nothing changes the image anymore, so the report describes the image that is shipped
+	err = changes.WriteReport(installutils.ChangeReportTempPath)
+	if err != nil {
+		err = fmt.Errorf("failed to write the change report: %w", err)
+	}
+	return
+}
+
+// normalizeTimestamps sets every file's timestamps to SOURCE_DATE_EPOCH for reproducible builds. It runs last,
+// once nothing else will change the install root.
+func normalizeTimestamps(installRootDir string, systemConfig configuration.SystemConfig) (err error) {
+	if !systemConfig.Reproducible {
+		return
+	}
+
+	epoch, err := installutils.SourceDateEpoch()
+	if err != nil {
+		return
+	}
+
+	return installutils.NormalizeTimestamps(installRootDir, epoch)
+}
+
+// runDebugHook gives the user a chance to inspect the fully populated install root
+// before the root is switched to read-only and torn down.
+func runDebugHook(installRootDir string) (err error) {
+	const installRootEnvVar = "IMAGER_INSTALL_ROOT"
+
+	if *debugHook != "" {
+		logger.Log.Infof("Running debug hook against install root (%s)", installRootDir)
+		cmd := exec.Command("/bin/sh", "-c", *debugHook)
+		cmd.Env = append(os.Environ(), fmt.Sprintf("%s=%s", installRootEnvVar, installRootDir))
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		err = cmd.Run()
+		if err != nil {
+			return
+		}
+	}
+
+	if *debugPause {
+		logger.Log.Warnf("Paused with the install root populated at (%s), press Enter to continue", installRootDir)
+		_, err = bufio.NewReader(os.Stdin).ReadString('\n')
+		// Without a terminal, such as in CI, stdin is closed and there is nobody to wait for
+		if err == io.EOF {
+			logger.Log.Warn("Stdin is closed, not pausing")
+			err = nil
+		}
+	}
+
 	return
 }
 
 func configureDiskBootloader(systemConfig configuration.SystemConfig, installChroot *safechroot.Chroot, diskDevPath string, installMap map[string]string, encryptedRoot diskutils.EncryptedRootDevice, readOnlyRoot diskutils.VerityDevice) (err error) {
 	const rootMountPoint = "/"
 	const bootMountPoint = "/boot"
+	const espMountPoint = "/boot/efi"
 
 	var rootDevice string
 
@@ -612,11 +1405,53 @@ func configureDiskBootloader(systemConfig configuration.SystemConfig, installChr
 		rootDevice = fmt.Sprintf("PARTUUID=%v", partUUID)
 	}
 
-	err = installutils.InstallGrubCfg(installChroot.RootDir(), rootDevice, bootUUID, bootPrefix, encryptedRoot, systemConfig.KernelCommandLine, readOnlyRoot)
+	err = installutils.InstallGrubCfg(installChroot.RootDir(), rootDevice, bootUUID, bootPrefix, encryptedRoot, systemConfig.GetKernelCommandLine(), readOnlyRoot, systemConfig.RescueBootEntry, systemConfig.GrubPassword, systemConfig.GrubCfgTemplate)
 	if err != nil {
 		err = fmt.Errorf("failed to install main grub config file: %s", err)
 		return
 	}
 
+	err = installutils.InstallGrubTheme(installChroot.RootDir(), systemConfig.GrubTheme)
+	if err != nil {
+		err = fmt.Errorf("failed to install grub theme: %w", err)
+		return
+	}
+
+	err = installutils.RegenerateGrubCfg(installChroot, systemConfig.GrubMkconfig)
+	if err != nil {
+		err = fmt.Errorf("failed to regenerate grub.cfg: %w", err)
+		return
+	}
+
+	err = installutils.InstallGrubEnv(installChroot, systemConfig.GrubEnv)
+	if err != nil {
+		err = fmt.Errorf("failed to set grubenv variables: %w", err)
+		return
+	}
+
+	err = installutils.UpdateCmdlineTxt(installChroot.RootDir(), rootDevice, encryptedRoot, systemConfig.GetKernelCommandLine(), readOnlyRoot)
+	if err != nil {
+		err = fmt.Errorf("failed to configure cmdline.txt: %w", err)
+		return
+	}
+
+	err = installutils.ConfigureSystemdBoot(installChroot.RootDir(), systemConfig.SystemdBoot)
+	if err != nil {
+		err = fmt.Errorf("failed to configure systemd-boot: %w", err)
+		return
+	}
+
+	err = installutils.ConfigureSecureBoot(installChroot, systemConfig.SecureBoot)
+	if err != nil {
+		err = fmt.Errorf("failed to configure secure boot: %w", err)
+		return
+	}
+
+	err = installutils.CustomizeEsp(installChroot.RootDir(), installMap[espMountPoint], systemConfig.Esp)
+	if err != nil {
+		err = fmt.Errorf("failed to customize the ESP: %w", err)
+		return
+	}
+
 	return
 }
@@ -50,6 +50,27 @@ func LogLevelFlag(k *kingpin.Application) *string {
 	return k.Flag(logger.LevelsFlag, logger.LevelsHelp).PlaceHolder(logger.LevelsPlaceholder).Enum(logger.Levels()...)
 }
 
+// LogColorFlag registers a log color flag for k and returns the passed value.
+// The flag defaults to true and may be disabled with "--no-log-color".
+func LogColorFlag(k *kingpin.Application) *bool {
+	return k.Flag(logger.ColorFlag, logger.ColorFlagHelp).Default("true").Bool()
+}
+
+// ResourceLimitFlags registers the flags lowering the CPU and I/O priority of heavy external commands, such as
+// qemu-img and mkfs, for k and returns the passed values
+func ResourceLimitFlags(k *kingpin.Application) (nice *int, ioniceClass *string, ioniceLevel *int) {
+	nice = k.Flag("nice", "Niceness (0-19) of heavy commands such as qemu-img and mkfs.").Default("0").Int()
+	ioniceClass = k.Flag("ionice-class", "I/O scheduling class of heavy commands such as qemu-img and mkfs.").Enum("idle", "best-effort")
+	ioniceLevel = k.Flag("ionice-level", "Priority (0-7) of heavy commands in the best-effort I/O scheduling class, 7 being the lowest.").Default("7").Int()
+	return
+}
+
+// ConfigVarsFlag registers the flag setting the variables referenced by image config files for k
+// and returns the passed values
+func ConfigVarsFlag(k *kingpin.Application) *map[string]string {
+	return k.Flag("config-var", "Value of a variable referenced as ${NAME} by the image config, as NAME=VALUE. Variables not set are read from the environment. May be repeated.").PlaceHolder("NAME=VALUE").StringMap()
+}
+
 // PlaceHolderize takes a list of available inputs and returns a corresponding placeholder
 func PlaceHolderize(thing []string) string {
 	return fmt.Sprintf("(%s)", strings.Join(thing, "|"))
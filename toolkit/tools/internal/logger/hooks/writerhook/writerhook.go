@@ -19,10 +19,11 @@ type WriterHook struct {
 	formatter logrus.Formatter
 }
 
-// NewWriterHook returns new WriterHook
+// NewWriterHook returns new WriterHook. Without useColors the output is plain text even on a terminal.
 func NewWriterHook(writer io.Writer, level logrus.Level, useColors bool) *WriterHook {
 	formatter := &logrus.TextFormatter{
-		ForceColors: useColors,
+		ForceColors:   useColors,
+		DisableColors: !useColors,
 	}
 
 	return &WriterHook{
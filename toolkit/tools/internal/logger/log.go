@@ -44,6 +44,15 @@ const (
 	// FileFlagHelp is the suggested help message for the logfile flag
 	FileFlagHelp = "Path to the image's log file."
 
+	// ColorFlag is the suggested name for the flag toggling colorized stderr output
+	ColorFlag = "log-color"
+
+	// ColorFlagHelp is the suggested help message for the log color flag
+	ColorFlagHelp = "Colorize the log output written to stderr. Use --no-log-color for plain output (e.g. in CI logs)."
+
+	// noColorEnvVar disables colorized stderr output when set to any non-empty value (see https://no-color.org).
+	noColorEnvVar = "NO_COLOR"
+
 	defaultLogFileLevel   = log.DebugLevel
 	defaultStderrLogLevel = log.InfoLevel
 )
@@ -69,9 +78,10 @@ func InitLogFile(filePath string) (err error) {
 	return
 }
 
-// InitStderrLog initializes the logger to print to stderr
+// InitStderrLog initializes the logger to print to stderr.
+// Colors are used unless the NO_COLOR environment variable is set.
 func InitStderrLog() {
-	const useColors = true
+	useColors := os.Getenv(noColorEnvVar) == ""
 
 	Log = log.New()
 
@@ -163,6 +173,15 @@ func ReplaceStderrFormatter(newFormatter log.Formatter) (oldFormatter log.Format
 	return stderrHook.ReplaceFormatter(newFormatter)
 }
 
+// SetStderrColors switches the stderr output between colorized and plain text and returns the old formatter.
+// The old formatter may be passed to ReplaceStderrFormatter to restore the previous behavior.
+func SetStderrColors(useColors bool) (oldFormatter log.Formatter) {
+	return stderrHook.ReplaceFormatter(&log.TextFormatter{
+		ForceColors:   useColors,
+		DisableColors: !useColors,
+	})
+}
+
 func setHookLogLevel(hook *writerhook.WriterHook, level string) (err error) {
 	logLevel, err := log.ParseLevel(level)
 	if err != nil {
@@ -17,3 +17,15 @@ func Run(function func() error, attempts int, sleep time.Duration) (err error) {
 	}
 	return err
 }
+
+// RunUnlessFatal runs function up to attempts times like Run, but stops retrying as soon as
+// isFatal reports that the returned error can not be fixed by another attempt.
+func RunUnlessFatal(function func() error, isFatal func(error) bool, attempts int, sleep time.Duration) (err error) {
+	for i := 0; i < attempts; i++ {
+		time.Sleep(time.Duration(i) * sleep)
+		if err = function(); err == nil || isFatal(err) {
+			break
+		}
+	}
+	return err
+}
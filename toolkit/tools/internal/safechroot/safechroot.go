@@ -25,6 +25,9 @@ import (
 // BindMountPointFlags is a set of flags to do a bind mount.
 const BindMountPointFlags = unix.MS_BIND | unix.MS_MGC_VAL
 
+// ReadOnlyBindMountPointFlags is a set of flags to do a read-only bind mount.
+const ReadOnlyBindMountPointFlags = BindMountPointFlags | unix.MS_RDONLY
+
 // FileToCopy represents a file to copy into a chroot using AddFiles. Dest is relative to the chroot directory.
 type FileToCopy struct {
 	Src  string
@@ -322,6 +325,16 @@ func (c *Chroot) UnsafeRun(toRun func() error) (err error) {
 	return
 }
 
+// RunWithoutNetwork runs a program inside the Chroot in a new network namespace, leaving it
+// without any network access. The program is started from outside the Chroot through unshare,
+// so it must not be called from within Run or UnsafeRun.
+func (c *Chroot) RunWithoutNetwork(squashErrors bool, program string, args ...string) (err error) {
+	unshareArgs := []string{"--net", "--", "chroot", c.rootDir, program}
+	unshareArgs = append(unshareArgs, args...)
+
+	return shell.ExecuteLive(squashErrors, "unshare", unshareArgs...)
+}
+
 // RootDir returns the Chroot's root directory.
 func (c *Chroot) RootDir() string {
 	return c.rootDir
@@ -530,6 +543,15 @@ func (c *Chroot) createMountPoints(allMountPoints []*MountPoint) (err error) {
 		}
 
 		mountPoint.isMounted = true
+
+		// The kernel ignores MS_RDONLY on the initial bind mount, it only applies on a remount
+		if mountPoint.flags&ReadOnlyBindMountPointFlags == ReadOnlyBindMountPointFlags {
+			err = unix.Mount("", fullPath, "", mountPoint.flags|unix.MS_REMOUNT, "")
+			if err != nil {
+				logger.Log.Errorf("Read-only remount failed on (%s). Error: %s", fullPath, err)
+				return
+			}
+		}
 	}
 
 	return
@@ -0,0 +1,88 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package shell
+
+import (
+	"fmt"
+	"strconv"
+)
+
+const (
+	// IoniceClassIdle only gives a command disk time when no other process needs it
+	IoniceClassIdle = "idle"
+	// IoniceClassBestEffort gives a command disk time by its IoniceLevel
+	IoniceClassBestEffort = "best-effort"
+
+	maxNice        = 19
+	maxIoniceLevel = 7
+)
+
+// ResourceLimits lowers the CPU and I/O scheduling priority of the heavy commands started with ExecuteLimited.
+//   - Nice: The niceness, 0 (unchanged) to 19 (lowest priority)
+//   - IoniceClass: The I/O scheduling class, IoniceClassIdle or IoniceClassBestEffort, or empty to leave it unchanged
+//   - IoniceLevel: The best-effort priority, 0 (highest) to 7 (lowest)
+type ResourceLimits struct {
+	Nice        int
+	IoniceClass string
+	IoniceLevel int
+}
+
+// IsValid returns an error if the ResourceLimits are not valid
+func (r *ResourceLimits) IsValid() (err error) {
+	if r.Nice < 0 || r.Nice > maxNice {
+		return fmt.Errorf("invalid niceness (%d), must be in the range 0-%d", r.Nice, maxNice)
+	}
+
+	switch r.IoniceClass {
+	case "", IoniceClassIdle, IoniceClassBestEffort:
+	default:
+		return fmt.Errorf("invalid ionice class (%s), must be '%s' or '%s'", r.IoniceClass, IoniceClassIdle, IoniceClassBestEffort)
+	}
+
+	if r.IoniceLevel < 0 || r.IoniceLevel > maxIoniceLevel {
+		return fmt.Errorf("invalid ionice level (%d), must be in the range 0-%d", r.IoniceLevel, maxIoniceLevel)
+	}
+
+	return
+}
+
+// ExecuteLimited runs the provided command like Execute, with the priority set by the resource limits.
+// It is meant for commands which read or write whole disks, such as qemu-img and mkfs.
+func ExecuteLimited(limits ResourceLimits, program string, args ...string) (stdout, stderr string, err error) {
+	program, args = limits.command(program, args)
+	return Execute(program, args...)
+}
+
+// ExecuteLiveWithCallbackLimited runs the provided command like ExecuteLiveWithCallback, with the priority set by the resource limits.
+func ExecuteLiveWithCallbackLimited(limits ResourceLimits, onStdout, onStderr func(...interface{}), printOutputOnError bool, program string, args ...string) (err error) {
+	program, args = limits.command(program, args)
+	return ExecuteLiveWithCallback(onStdout, onStderr, printOutputOnError, program, args...)
+}
+
+// command wraps a command in nice and ionice as needed to apply the resource limits
+func (r *ResourceLimits) command(program string, args []string) (limitedProgram string, limitedArgs []string) {
+	const (
+		ioniceIdleClass       = "3"
+		ioniceBestEffortClass = "2"
+	)
+
+	var prefix []string
+	if r.Nice != 0 {
+		prefix = append(prefix, "nice", "-n", strconv.Itoa(r.Nice))
+	}
+
+	switch r.IoniceClass {
+	case IoniceClassIdle:
+		prefix = append(prefix, "ionice", "-c", ioniceIdleClass)
+	case IoniceClassBestEffort:
+		prefix = append(prefix, "ionice", "-c", ioniceBestEffortClass, "-n", strconv.Itoa(r.IoniceLevel))
+	}
+
+	if len(prefix) == 0 {
+		return program, args
+	}
+
+	limitedArgs = append(prefix[1:], program)
+	return prefix[0], append(limitedArgs, args...)
+}
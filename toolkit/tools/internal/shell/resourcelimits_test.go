@@ -0,0 +1,99 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package shell
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldLimitCommand(t *testing.T) {
+	tests := []struct {
+		name            string
+		limits          ResourceLimits
+		expectedProgram string
+		expectedArgs    []string
+	}{
+		{
+			name:            "none",
+			limits:          ResourceLimits{},
+			expectedProgram: "qemu-img",
+			expectedArgs:    []string{"convert", "disk.raw"},
+		},
+		{
+			name:            "nice only",
+			limits:          ResourceLimits{Nice: 10},
+			expectedProgram: "nice",
+			expectedArgs:    []string{"-n", "10", "qemu-img", "convert", "disk.raw"},
+		},
+		{
+			name:            "idle",
+			limits:          ResourceLimits{IoniceClass: IoniceClassIdle},
+			expectedProgram: "ionice",
+			expectedArgs:    []string{"-c", "3", "qemu-img", "convert", "disk.raw"},
+		},
+		{
+			name:            "best-effort with level",
+			limits:          ResourceLimits{Nice: 19, IoniceClass: IoniceClassBestEffort, IoniceLevel: 7},
+			expectedProgram: "nice",
+			expectedArgs:    []string{"-n", "19", "ionice", "-c", "2", "-n", "7", "qemu-img", "convert", "disk.raw"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.NoError(t, test.limits.IsValid())
+
+			program, args := test.limits.command("qemu-img", []string{"convert", "disk.raw"})
+			assert.Equal(t, test.expectedProgram, program)
+			assert.Equal(t, test.expectedArgs, args)
+		})
+	}
+}
+
+func TestShouldFailInvalidResourceLimits(t *testing.T) {
+	tests := []struct {
+		name          string
+		limits        ResourceLimits
+		expectedError string
+	}{
+		{
+			name:          "negative niceness",
+			limits:        ResourceLimits{Nice: -1},
+			expectedError: "invalid niceness (-1), must be in the range 0-19",
+		},
+		{
+			name:          "niceness too high",
+			limits:        ResourceLimits{Nice: 20},
+			expectedError: "invalid niceness (20), must be in the range 0-19",
+		},
+		{
+			name:          "unknown ionice class",
+			limits:        ResourceLimits{IoniceClass: "realtime"},
+			expectedError: "invalid ionice class (realtime), must be 'idle' or 'best-effort'",
+		},
+		{
+			name:          "negative ionice level",
+			limits:        ResourceLimits{IoniceClass: IoniceClassBestEffort, IoniceLevel: -1},
+			expectedError: "invalid ionice level (-1), must be in the range 0-7",
+		},
+		{
+			name:          "ionice level too high",
+			limits:        ResourceLimits{IoniceClass: IoniceClassBestEffort, IoniceLevel: 8},
+			expectedError: "invalid ionice level (8), must be in the range 0-7",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.limits.IsValid()
+			assert.Error(t, err)
+			assert.Equal(t, test.expectedError, err.Error())
+		})
+	}
+
+	validLimits := ResourceLimits{Nice: 19, IoniceClass: IoniceClassBestEffort, IoniceLevel: 7}
+	assert.NoError(t, validLimits.IsValid())
+}
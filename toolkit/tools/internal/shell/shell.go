@@ -6,6 +6,7 @@ package shell
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"strings"
@@ -116,6 +117,26 @@ func ExecuteWithStdin(input, program string, args ...string) (stdout, stderr str
 	return outBuf.String(), errBuf.String(), err
 }
 
+// ExecuteWithStdout runs the command and writes its stdout to output as it is produced, for output
+// too large to buffer such as a stream of image data.
+func ExecuteWithStdout(output io.Writer, program string, args ...string) (stderr string, err error) {
+	var errBuf bytes.Buffer
+
+	cmd := exec.Command(program, args...)
+	cmd.Stdout = output
+	cmd.Stderr = &errBuf
+
+	err = trackAndStartProcess(cmd)
+	if err != nil {
+		return
+	}
+
+	defer untrackProcess(cmd)
+
+	err = cmd.Wait()
+	return errBuf.String(), err
+}
+
 // ExecuteLive runs a command in the shell and logs it in real-time
 func ExecuteLive(squashErrors bool, program string, args ...string) (err error) {
 	var (
@@ -0,0 +1,96 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package systemdependency
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	procSelfStatus = "/proc/self/status"
+	procSelfUIDMap = "/proc/self/uid_map"
+
+	// initialUIDMap is the uid_map of a process in the initial user namespace
+	initialUIDMap = "0 0 4294967295"
+)
+
+// Capability is a Linux capability and what the build uses it for
+type Capability struct {
+	Bit     uint
+	Name    string
+	UsedFor string
+}
+
+// ImageBuildCapabilities are the capabilities needed to build an image
+var ImageBuildCapabilities = []Capability{
+	{Bit: unix.CAP_SYS_ADMIN, Name: "CAP_SYS_ADMIN", UsedFor: "attaching loop devices and mounting partitions and the chroot's /dev, /proc and /sys"},
+	{Bit: unix.CAP_SYS_CHROOT, Name: "CAP_SYS_CHROOT", UsedFor: "entering the install chroot"},
+	{Bit: unix.CAP_MKNOD, Name: "CAP_MKNOD", UsedFor: "creating device nodes in the install root"},
+	{Bit: unix.CAP_CHOWN, Name: "CAP_CHOWN", UsedFor: "installing files owned by other users"},
+	{Bit: unix.CAP_DAC_OVERRIDE, Name: "CAP_DAC_OVERRIDE", UsedFor: "writing files regardless of their permissions"},
+	{Bit: unix.CAP_FOWNER, Name: "CAP_FOWNER", UsedFor: "setting the mode of files owned by other users"},
+	{Bit: unix.CAP_SETFCAP, Name: "CAP_SETFCAP", UsedFor: "installing packages with file capabilities"},
+}
+
+// CheckCapabilities returns an error naming each of the capabilities the current process lacks, and
+// what it is needed for, so a build without enough privileges fails before it starts instead of midway.
+// Capabilities held inside a user namespace, such as in a rootless container, are not enough to attach
+// loop devices, so running in one is reported as well.
+func CheckCapabilities(capabilities []Capability) (err error) {
+	status, err := os.ReadFile(procSelfStatus)
+	if err != nil {
+		return fmt.Errorf("failed to read the process capabilities: %w", err)
+	}
+	effective, err := parseEffectiveCapabilities(string(status))
+	if err != nil {
+		return
+	}
+
+	var problems []string
+	for _, missing := range MissingCapabilities(effective, capabilities) {
+		problems = append(problems, fmt.Sprintf("%s, needed for %s", missing.Name, missing.UsedFor))
+	}
+
+	uidMap, readErr := os.ReadFile(procSelfUIDMap)
+	if readErr == nil && IsUserNamespace(string(uidMap)) {
+		problems = append(problems, "the build runs in a user namespace (rootless), where loop devices can't be attached; run it as root on the host or in a privileged container")
+	}
+
+	if len(problems) != 0 {
+		return fmt.Errorf("insufficient privileges to build an image:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return
+}
+
+// MissingCapabilities returns the capabilities which are not in the effective capability set
+func MissingCapabilities(effective uint64, capabilities []Capability) (missing []Capability) {
+	for _, capability := range capabilities {
+		if effective&(1<<capability.Bit) == 0 {
+			missing = append(missing, capability)
+		}
+	}
+	return
+}
+
+// IsUserNamespace returns true if a /proc/<pid>/uid_map is not the one of the initial user namespace
+func IsUserNamespace(uidMap string) bool {
+	return strings.Join(strings.Fields(uidMap), " ") != initialUIDMap
+}
+
+// parseEffectiveCapabilities reads the CapEff line of a /proc/<pid>/status file
+func parseEffectiveCapabilities(status string) (effective uint64, err error) {
+	const capEffPrefix = "CapEff:"
+
+	for _, line := range strings.Split(status, "\n") {
+		if strings.HasPrefix(line, capEffPrefix) {
+			return strconv.ParseUint(strings.TrimSpace(strings.TrimPrefix(line, capEffPrefix)), 16, 64)
+		}
+	}
+	return 0, fmt.Errorf("no %s line in %s", capEffPrefix, procSelfStatus)
+}
@@ -0,0 +1,34 @@
+// Copyright Microsoft Corporation.
+// Licensed under the MIT License.
+
+package systemdependency
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldParseEffectiveCapabilities(t *testing.T) {
+	status := "Name:\timager\nCapInh:\t0000000000000000\nCapEff:\t00000000a80425fb\nCapBnd:\t000001ffffffffff\n"
+
+	effective, err := parseEffectiveCapabilities(status)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0xa80425fb), effective)
+}
+
+func TestShouldFindMissingCapabilities(t *testing.T) {
+	// The default capabilities of a docker container, which lack CAP_SYS_ADMIN
+	const dockerDefault = 0xa80425fb
+
+	missing := MissingCapabilities(dockerDefault, ImageBuildCapabilities)
+	assert.Len(t, missing, 1)
+	assert.Equal(t, "CAP_SYS_ADMIN", missing[0].Name)
+
+	assert.Empty(t, MissingCapabilities(^uint64(0), ImageBuildCapabilities))
+}
+
+func TestShouldDetectUserNamespace(t *testing.T) {
+	assert.False(t, IsUserNamespace("         0          0 4294967295\n"))
+	assert.True(t, IsUserNamespace("         0       1000          1\n"))
+}
@@ -319,7 +319,7 @@ func (im *IsoMaker) copyAndRenameAdditionalFiles(configFilesAbsDirPath string) {
 	for i := range im.config.SystemConfigs {
 		systemConfig := &im.config.SystemConfigs[i]
 
-		absAdditionalFiles := make(map[string]string)
+		absAdditionalFiles := make(map[string]configuration.AdditionalFile)
 		for localAbsFilePath, installedSystemAbsFilePath := range systemConfig.AdditionalFiles {
 			isoRelativeFilePath := im.copyFileToConfigRoot(configFilesAbsDirPath, additionalFilesSubDirName, localAbsFilePath)
 			absAdditionalFiles[isoRelativeFilePath] = installedSystemAbsFilePath
@@ -353,6 +353,10 @@ func (im *IsoMaker) copyAndRenamePostInstallScripts(configFilesAbsDirPath string
 
 	for _, systemConfig := range im.config.SystemConfigs {
 		for i, localScriptAbsFilePath := range systemConfig.PostInstallScripts {
+			if localScriptAbsFilePath.Path == "" {
+				continue
+			}
+
 			isoScriptRelativeFilePath := im.copyFileToConfigRoot(configFilesAbsDirPath, postInstallScriptsSubDirName, localScriptAbsFilePath.Path)
 
 			systemConfig.PostInstallScripts[i].Path = isoScriptRelativeFilePath
@@ -0,0 +1,143 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package formats
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+
+	"microsoft.com/pkggen/internal/logger"
+	"microsoft.com/pkggen/internal/shell"
+)
+
+const (
+	// AzureVhdType represents a fixed size VHD meeting Azure's upload requirements
+	AzureVhdType = "vhd-azure"
+
+	// azureVhdAlignment is the alignment Azure requires for the virtual size of a VHD
+	azureVhdAlignment = 1024 * 1024
+
+	vhdFooterSize           = 512
+	vhdFooterCookie         = "conectix"
+	vhdFooterCurrentSizeOff = 48
+	vhdFooterDiskTypeOff    = 60
+	vhdDiskTypeFixed        = 2
+)
+
+// AzureVhd implements Converter interface to convert a RAW image into a fixed size VHD
+// whose virtual size is rounded up to a whole number of MiB, as Azure requires
+type AzureVhd struct {
+	qemuImg QemuImgSettings
+}
+
+// Convert converts the image in the Azure VHD format
+func (v *AzureVhd) Convert(input, output string, isInputFile bool) (err error) {
+	const (
+		qemuVhdType = "vpc"
+	)
+
+	if !isInputFile {
+		return fmt.Errorf("azure vhd conversion requires a RAW file as an input")
+	}
+
+	inputInfo, err := os.Stat(input)
+	if err != nil {
+		return
+	}
+
+	rawSize := inputInfo.Size()
+	alignedSize := alignAzureVhdSize(rawSize)
+
+	// Pad a sparse copy of the image instead of the input, which may be used by other artifacts
+	source := input
+	if alignedSize != rawSize {
+		logger.Log.Infof("Rounding the virtual size of (%s) up from %d to %d bytes for Azure", output, rawSize, alignedSize)
+		source = output + ".raw"
+		defer os.Remove(source)
+
+		_, stderr, copyErr := shell.Execute("cp", "--sparse=always", input, source)
+		if copyErr != nil {
+			return fmt.Errorf("failed to copy (%s) for padding: %v: %w", input, stderr, copyErr)
+		}
+		err = os.Truncate(source, alignedSize)
+		if err != nil {
+			return
+		}
+	}
+
+	err = v.qemuImg.convert(source, output, "-o", "subformat=fixed,force_size=on", "-O", qemuVhdType)
+	if err != nil {
+		return
+	}
+
+	err = validateAzureVhd(output, alignedSize)
+	if err != nil {
+		return
+	}
+
+	logger.Log.Infof("Created Azure VHD (%s) with a fixed virtual size of %d MiB", output, alignedSize/azureVhdAlignment)
+	return
+}
+
+// Extension returns the filetype extension produced by this converter.
+func (v *AzureVhd) Extension() string {
+	return VhdType
+}
+
+// NewAzureVhd returns a new Azure VHD format encoder converting with the given qemu-img settings
+func NewAzureVhd(qemuImg QemuImgSettings) *AzureVhd {
+	return &AzureVhd{qemuImg: qemuImg}
+}
+
+// alignAzureVhdSize rounds a size in bytes up to a whole number of MiB
+func alignAzureVhdSize(size int64) int64 {
+	return (size + azureVhdAlignment - 1) / azureVhdAlignment * azureVhdAlignment
+}
+
+// validateAzureVhd checks the VHD is fixed size, has the expected MiB aligned virtual size and
+// consists of exactly the disk data followed by the footer
+func validateAzureVhd(path string, expectedSize int64) (err error) {
+	vhdFile, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer vhdFile.Close()
+
+	info, err := vhdFile.Stat()
+	if err != nil {
+		return
+	}
+	if info.Size() != expectedSize+vhdFooterSize {
+		return fmt.Errorf("azure vhd (%s) is %d bytes, expected %d bytes of disk data and a %d byte footer", path, info.Size(), expectedSize, vhdFooterSize)
+	}
+
+	footer := make([]byte, vhdFooterSize)
+	_, err = vhdFile.ReadAt(footer, info.Size()-vhdFooterSize)
+	if err != nil && err != io.EOF {
+		return
+	}
+
+	return validateAzureVhdFooter(footer, expectedSize)
+}
+
+// validateAzureVhdFooter checks a VHD footer describes a fixed size disk of the expected MiB aligned size
+func validateAzureVhdFooter(footer []byte, expectedSize int64) (err error) {
+	if len(footer) != vhdFooterSize || string(footer[:len(vhdFooterCookie)]) != vhdFooterCookie {
+		return fmt.Errorf("azure vhd has no valid VHD footer")
+	}
+
+	diskType := binary.BigEndian.Uint32(footer[vhdFooterDiskTypeOff:])
+	if diskType != vhdDiskTypeFixed {
+		return fmt.Errorf("azure vhd has disk type (%d), must be fixed (%d)", diskType, vhdDiskTypeFixed)
+	}
+
+	currentSize := int64(binary.BigEndian.Uint64(footer[vhdFooterCurrentSizeOff:]))
+	if currentSize != expectedSize || currentSize%azureVhdAlignment != 0 {
+		return fmt.Errorf("azure vhd has a virtual size of %d bytes, must be %d bytes and a whole number of MiB", currentSize, expectedSize)
+	}
+
+	return
+}
@@ -0,0 +1,142 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package formats
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testAzureVhdFooter returns a VHD footer of the given disk type and virtual size
+func testAzureVhdFooter(diskType uint32, currentSize int64) []byte {
+	footer := make([]byte, vhdFooterSize)
+	copy(footer, vhdFooterCookie)
+	binary.BigEndian.PutUint64(footer[vhdFooterCurrentSizeOff:], uint64(currentSize))
+	binary.BigEndian.PutUint32(footer[vhdFooterDiskTypeOff:], diskType)
+	return footer
+}
+
+func TestShouldAlignAzureVhdSize(t *testing.T) {
+	tests := []struct {
+		name     string
+		size     int64
+		expected int64
+	}{
+		{name: "empty", size: 0, expected: 0},
+		{name: "one byte", size: 1, expected: azureVhdAlignment},
+		{name: "one byte short of a MiB", size: azureVhdAlignment - 1, expected: azureVhdAlignment},
+		{name: "exactly a MiB", size: azureVhdAlignment, expected: azureVhdAlignment},
+		{name: "one byte over a MiB", size: azureVhdAlignment + 1, expected: 2 * azureVhdAlignment},
+		{name: "aligned to a sector only", size: 4096*azureVhdAlignment + 512, expected: 4097 * azureVhdAlignment},
+		{name: "already aligned", size: 30 * 1024 * azureVhdAlignment, expected: 30 * 1024 * azureVhdAlignment},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, alignAzureVhdSize(test.size))
+		})
+	}
+}
+
+func TestShouldValidateAzureVhdFooter(t *testing.T) {
+	const expectedSize = 4 * azureVhdAlignment
+
+	corruptedCookie := testAzureVhdFooter(vhdDiskTypeFixed, expectedSize)
+	copy(corruptedCookie, "conectiX")
+
+	tests := []struct {
+		name          string
+		footer        []byte
+		expectedSize  int64
+		expectedError string
+	}{
+		{
+			name:         "valid",
+			footer:       testAzureVhdFooter(vhdDiskTypeFixed, expectedSize),
+			expectedSize: expectedSize,
+		},
+		{
+			name:          "corrupted cookie",
+			footer:        corruptedCookie,
+			expectedSize:  expectedSize,
+			expectedError: "azure vhd has no valid VHD footer",
+		},
+		{
+			name:          "truncated",
+			footer:        testAzureVhdFooter(vhdDiskTypeFixed, expectedSize)[:vhdFooterSize-1],
+			expectedSize:  expectedSize,
+			expectedError: "azure vhd has no valid VHD footer",
+		},
+		{
+			name:          "all zeroes",
+			footer:        make([]byte, vhdFooterSize),
+			expectedSize:  expectedSize,
+			expectedError: "azure vhd has no valid VHD footer",
+		},
+		{
+			name:          "dynamic disk",
+			footer:        testAzureVhdFooter(3, expectedSize),
+			expectedSize:  expectedSize,
+			expectedError: "azure vhd has disk type (3), must be fixed (2)",
+		},
+		{
+			name:          "different size",
+			footer:        testAzureVhdFooter(vhdDiskTypeFixed, expectedSize+azureVhdAlignment),
+			expectedSize:  expectedSize,
+			expectedError: "azure vhd has a virtual size of 5242880 bytes, must be 4194304 bytes and a whole number of MiB",
+		},
+		{
+			name:          "unaligned size",
+			footer:        testAzureVhdFooter(vhdDiskTypeFixed, expectedSize+512),
+			expectedSize:  expectedSize + 512,
+			expectedError: "azure vhd has a virtual size of 4194816 bytes, must be 4194816 bytes and a whole number of MiB",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateAzureVhdFooter(test.footer, test.expectedSize)
+			if test.expectedError == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+				assert.Equal(t, test.expectedError, err.Error())
+			}
+		})
+	}
+}
+
+func TestShouldValidateAzureVhd(t *testing.T) {
+	const expectedSize = azureVhdAlignment
+
+	tests := []struct {
+		name          string
+		dataSize      int64
+		footer        []byte
+		expectedError bool
+	}{
+		{name: "valid", dataSize: expectedSize, footer: testAzureVhdFooter(vhdDiskTypeFixed, expectedSize)},
+		{name: "missing footer", dataSize: expectedSize, expectedError: true},
+		{name: "short disk data", dataSize: expectedSize - 512, footer: testAzureVhdFooter(vhdDiskTypeFixed, expectedSize), expectedError: true},
+		{name: "corrupted footer", dataSize: expectedSize, footer: make([]byte, vhdFooterSize), expectedError: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			vhdPath := filepath.Join(t.TempDir(), "image.vhd")
+			assert.NoError(t, os.WriteFile(vhdPath, append(make([]byte, test.dataSize), test.footer...), 0644))
+
+			err := validateAzureVhd(vhdPath, expectedSize)
+			if test.expectedError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -3,8 +3,24 @@
 
 package formats
 
+import "io"
+
 // Converter allows to save the raw disk image as a different image format
 type Converter interface {
 	Convert(input, output string, isInputFile bool) error
 	Extension() string
 }
+
+// SidecarConverter is implemented by converters which write more files next to their output,
+// which must be published along with it
+type SidecarConverter interface {
+	Converter
+	Sidecars(output string) []string
+}
+
+// StreamConverter is implemented by converters which write their output sequentially,
+// so it can be streamed into a pipe instead of a seekable file
+type StreamConverter interface {
+	Converter
+	ConvertToStream(input string, output io.Writer, isInputFile bool) error
+}
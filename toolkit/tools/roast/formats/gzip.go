@@ -20,6 +20,17 @@ type Gzip struct {
 
 // Convert converts the image in the Gzip format
 func (g *Gzip) Convert(input, output string, isInputFile bool) (err error) {
+	dstFile, err := os.Create(output)
+	if err != nil {
+		return
+	}
+	defer dstFile.Close()
+
+	return g.ConvertToStream(input, dstFile, isInputFile)
+}
+
+// ConvertToStream compresses the image in the Gzip format into a stream
+func (g *Gzip) ConvertToStream(input string, output io.Writer, isInputFile bool) (err error) {
 	if !isInputFile {
 		return fmt.Errorf("gz compression requires a file as an input")
 	}
@@ -30,17 +41,14 @@ func (g *Gzip) Convert(input, output string, isInputFile bool) (err error) {
 	}
 	defer srcFile.Close()
 
-	dstFile, err := os.Create(output)
+	gzipWriter := pgzip.NewWriter(output)
+
+	_, err = io.Copy(gzipWriter, srcFile)
 	if err != nil {
+		gzipWriter.Close()
 		return
 	}
-	defer dstFile.Close()
-
-	gzipWriter := pgzip.NewWriter(dstFile)
-	defer gzipWriter.Close()
-
-	_, err = io.Copy(gzipWriter, srcFile)
-	return
+	return gzipWriter.Close()
 }
 
 // Extension returns the filetype extension produced by this converter.
@@ -32,6 +32,7 @@ const (
 
 // Ova implements Converter interface to convert a RAW image into an OVA file
 type Ova struct {
+	qemuImg QemuImgSettings
 }
 
 func filePathFromEnv(variable string) (path string, err error) {
@@ -110,7 +111,7 @@ func (o *Ova) Convert(input, output string, isInputFile bool) (err error) {
 
 	logger.Log.Infof(`Converting "%s" to "%s"`, input, vmdkFilePath)
 
-	err = shell.ExecuteLiveWithCallback(logger.Log.Info, logger.Log.Warn, false, "qemu-img", "convert", "-f", "raw", input, "-O", "vmdk", vmdkFilePath)
+	err = o.qemuImg.convert("-f", "raw", input, "-O", "vmdk", vmdkFilePath)
 	if err != nil {
 		return err
 	}
@@ -205,7 +206,7 @@ func (o *Ova) Extension() string {
 	return OvaType
 }
 
-// NewOva returns a new .OVA format encoder
-func NewOva() *Ova {
-	return &Ova{}
+// NewOva returns a new .OVA format encoder converting with the given qemu-img settings
+func NewOva(qemuImg QemuImgSettings) *Ova {
+	return &Ova{qemuImg: qemuImg}
 }
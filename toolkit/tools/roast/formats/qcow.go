@@ -5,8 +5,6 @@ package formats
 
 import (
 	"fmt"
-
-	"microsoft.com/pkggen/internal/shell"
 )
 
 const (
@@ -16,20 +14,20 @@ const (
 
 // Qcow implements Converter interface to convert a RAW image into a qcow2 file
 type Qcow struct {
+	qemuImg QemuImgSettings
 }
 
 // Convert converts the image in the qcow2 format
 func (v *Qcow) Convert(input, output string, isInputFile bool) (err error) {
 	const (
 		outputFormat = "qcow2"
-		squashErrors = false
 	)
 
 	if !isInputFile {
 		return fmt.Errorf("qcow2 conversion requires a RAW file as an input")
 	}
 
-	err = shell.ExecuteLive(squashErrors, "qemu-img", "convert", "-O", outputFormat, input, output)
+	err = v.qemuImg.convert("-O", outputFormat, input, output)
 	return
 }
 
@@ -38,7 +36,7 @@ func (v *Qcow) Extension() string {
 	return QcowType
 }
 
-// NewQcow returns a new qcow format encoder
-func NewQcow() *Qcow {
-	return &Qcow{}
+// NewQcow returns a new qcow format encoder converting with the given qemu-img settings
+func NewQcow(qemuImg QemuImgSettings) *Qcow {
+	return &Qcow{qemuImg: qemuImg}
 }
@@ -0,0 +1,114 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package formats
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"microsoft.com/pkggen/internal/logger"
+	"microsoft.com/pkggen/internal/retry"
+	"microsoft.com/pkggen/internal/shell"
+)
+
+const (
+	// DefaultQemuImgAttempts is the number of times a qemu-img conversion is attempted by default
+	DefaultQemuImgAttempts = 3
+	// DefaultQemuImgRetryDelay is the default base delay between qemu-img conversion attempts
+	DefaultQemuImgRetryDelay = 5 * time.Second
+)
+
+var (
+	// qemu-img errors which will not go away by retrying the conversion
+	qemuImgFatalErrors = []string{
+		"Could not open",
+		"No such file or directory",
+		"Unknown file format",
+		"Unknown driver",
+		"Invalid parameter",
+		"Invalid options",
+		"not supported by",
+	}
+)
+
+// QemuImgSettings are the settings of the qemu-img conversions of a converter
+//   - Attempts: The number of times a conversion is attempted before giving up
+//   - RetryDelay: The base delay between conversion attempts
+//   - Coroutines: The number of parallel coroutines a conversion uses, or 0 for qemu-img's default
+//   - Limits: The CPU and I/O scheduling priority of qemu-img
+type QemuImgSettings struct {
+	Attempts   int
+	RetryDelay time.Duration
+	Coroutines int
+	Limits     shell.ResourceLimits
+}
+
+// qemuImgError holds the stderr of a failed qemu-img invocation
+type qemuImgError struct {
+	err    error
+	stderr string
+}
+
+func (e *qemuImgError) Error() string {
+	return fmt.Sprintf("%v: %s", e.err, strings.TrimSpace(e.stderr))
+}
+
+func (e *qemuImgError) Unwrap() error {
+	return e.err
+}
+
+// convert runs "qemu-img convert" with the given arguments, retrying conversions which
+// fail due to transient errors such as I/O failures under heavy load.
+func (q *QemuImgSettings) convert(args ...string) (err error) {
+	const printOutputOnError = false
+
+	convertArgs := []string{"convert"}
+	if q.Coroutines != 0 {
+		convertArgs = append(convertArgs, "-m", strconv.Itoa(q.Coroutines))
+	}
+	args = append(convertArgs, args...)
+
+	attempt := 0
+	err = retry.RunUnlessFatal(func() error {
+		attempt++
+		if attempt > 1 {
+			logger.Log.Warnf("Retrying qemu-img conversion (attempt %d of %d)", attempt, q.Attempts)
+		}
+
+		// Log the output as it comes, while keeping stderr to tell transient failures apart
+		var stderr strings.Builder
+		onStderr := func(args ...interface{}) {
+			logger.Log.Warn(args...)
+			fmt.Fprintln(&stderr, args...)
+		}
+
+		runErr := shell.ExecuteLiveWithCallbackLimited(q.Limits, logger.Log.Debug, onStderr, printOutputOnError, "qemu-img", args...)
+		if runErr != nil {
+			logger.Log.Warnf("qemu-img conversion failed: %v", runErr)
+			return &qemuImgError{err: runErr, stderr: stderr.String()}
+		}
+		return nil
+	}, isFatalQemuImgError, q.Attempts, q.RetryDelay)
+
+	return
+}
+
+// isFatalQemuImgError returns true if a qemu-img failure can not be fixed by retrying.
+func isFatalQemuImgError(err error) bool {
+	var qemuErr *qemuImgError
+	if !errors.As(err, &qemuErr) {
+		return false
+	}
+
+	for _, fatalError := range qemuImgFatalErrors {
+		if strings.Contains(qemuErr.stderr, fatalError) {
+			logger.Log.Debugf("qemu-img error is not transient, will not retry")
+			return true
+		}
+	}
+	return false
+}
@@ -5,6 +5,8 @@ package formats
 
 import (
 	"fmt"
+	"io"
+	"os"
 
 	"microsoft.com/pkggen/internal/file"
 )
@@ -25,6 +27,22 @@ func (r *Raw) Convert(input, output string, isInputFile bool) (err error) {
 	return
 }
 
+// ConvertToStream writes the RAW image into a stream
+func (r *Raw) ConvertToStream(input string, output io.Writer, isInputFile bool) (err error) {
+	if !isInputFile {
+		return fmt.Errorf("raw conversion requires a RAW file as an input")
+	}
+
+	srcFile, err := os.Open(input)
+	if err != nil {
+		return
+	}
+	defer srcFile.Close()
+
+	_, err = io.Copy(output, srcFile)
+	return
+}
+
 // Extension returns the filetype extension produced by this converter.
 func (r *Raw) Extension() string {
 	return RawType
@@ -0,0 +1,68 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package formats
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"microsoft.com/pkggen/internal/file"
+	"microsoft.com/pkggen/internal/shell"
+)
+
+// TarType represents an uncompressed tar archive of the image's root filesystem
+const TarType = "tar"
+
+// rootfsTarArgs preserve ownership, permissions, ACLs and extended attributes, including SELinux labels
+var rootfsTarArgs = []string{"--xattrs", "--xattrs-include=*", "--acls", "--selinux", "--numeric-owner", "-cp"}
+
+// Tar implements Converter interface to archive a root filesystem. A rootfs directory is archived
+// directly, a file input is a root filesystem archive the imager already produced for a disk.
+type Tar struct {
+}
+
+// Convert archives the root filesystem in the tar format
+func (t *Tar) Convert(input, output string, isInputFile bool) (err error) {
+	const squashErrors = false
+
+	if isInputFile {
+		return file.Copy(input, output)
+	}
+
+	tarArgs := append(append([]string{}, rootfsTarArgs...), "-f", output, "-C", input, ".")
+	return shell.ExecuteLive(squashErrors, "tar", tarArgs...)
+}
+
+// ConvertToStream archives the root filesystem in the tar format into a stream
+func (t *Tar) ConvertToStream(input string, output io.Writer, isInputFile bool) (err error) {
+	if isInputFile {
+		var srcFile *os.File
+		srcFile, err = os.Open(input)
+		if err != nil {
+			return
+		}
+		defer srcFile.Close()
+
+		_, err = io.Copy(output, srcFile)
+		return
+	}
+
+	tarArgs := append(append([]string{}, rootfsTarArgs...), "-f", "-", "-C", input, ".")
+	stderr, err := shell.ExecuteWithStdout(output, "tar", tarArgs...)
+	if err != nil {
+		err = fmt.Errorf("failed to stream tar archive: %v: %w", stderr, err)
+	}
+	return
+}
+
+// Extension returns the filetype extension produced by this converter.
+func (t *Tar) Extension() string {
+	return TarType
+}
+
+// NewTar returns a new Tar format encoder
+func NewTar() *Tar {
+	return &Tar{}
+}
@@ -4,6 +4,9 @@
 package formats
 
 import (
+	"fmt"
+	"io"
+
 	"microsoft.com/pkggen/internal/shell"
 	"microsoft.com/pkggen/internal/systemdependency"
 )
@@ -33,6 +36,26 @@ func (t *TarGzip) Convert(input, output string, isInputFile bool) (err error) {
 	return
 }
 
+// ConvertToStream archives the image in the tar.gz format into a stream
+func (t *TarGzip) ConvertToStream(input string, output io.Writer, isInputFile bool) (err error) {
+	tool, err := systemdependency.GzipTool()
+	if err != nil {
+		return
+	}
+
+	var stderr string
+	if isInputFile {
+		stderr, err = shell.ExecuteWithStdout(output, "tar", "-I", tool, "-cf", "-", input)
+	} else {
+		stderr, err = shell.ExecuteWithStdout(output, "tar", "-I", tool, "-cf", "-", "-C", input, ".")
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to stream tar.gz archive: %v: %w", stderr, err)
+	}
+
+	return
+}
+
 // Extension returns the filetype extension produced by this converter.
 func (t *TarGzip) Extension() string {
 	return TarGzipType
@@ -3,7 +3,12 @@
 
 package formats
 
-import "microsoft.com/pkggen/internal/shell"
+import (
+	"fmt"
+	"io"
+
+	"microsoft.com/pkggen/internal/shell"
+)
 
 // TarXzType represents the tar.xz format
 const TarXzType = "tar.xz"
@@ -19,6 +24,15 @@ func (t *TarXz) Convert(input, output string, isInputFile bool) (err error) {
 	return
 }
 
+// ConvertToStream archives the image in the tar.xz format into a stream
+func (t *TarXz) ConvertToStream(input string, output io.Writer, isInputFile bool) (err error) {
+	stderr, err := shell.ExecuteWithStdout(output, "tar", "-cJf", "-", input)
+	if err != nil {
+		err = fmt.Errorf("failed to stream tar.xz archive: %v: %w", stderr, err)
+	}
+	return
+}
+
 // Extension returns the filetype extension produced by this converter.
 func (t *TarXz) Extension() string {
 	return TarXzType
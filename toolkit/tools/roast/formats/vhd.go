@@ -5,8 +5,6 @@ package formats
 
 import (
 	"fmt"
-
-	"microsoft.com/pkggen/internal/shell"
 )
 
 const (
@@ -20,13 +18,13 @@ const (
 // Vhd implements Converter interface to convert a RAW image into a VHD(x) file
 type Vhd struct {
 	generation2 bool
+	qemuImg     QemuImgSettings
 }
 
 // Convert converts the image in the VHD(x) format
 func (v *Vhd) Convert(input, output string, isInputFile bool) (err error) {
 	const (
-		qemuVhdType  = "vpc"
-		squashErrors = false
+		qemuVhdType = "vpc"
 	)
 
 	if !isInputFile {
@@ -34,7 +32,7 @@ func (v *Vhd) Convert(input, output string, isInputFile bool) (err error) {
 	}
 
 	var format string
-	args := []string{"convert", input, output}
+	args := []string{input, output}
 
 	if v.generation2 {
 		format = VhdxType
@@ -45,7 +43,7 @@ func (v *Vhd) Convert(input, output string, isInputFile bool) (err error) {
 
 	args = append(args, "-O", format)
 
-	err = shell.ExecuteLive(squashErrors, "qemu-img", args...)
+	err = v.qemuImg.convert(args...)
 	return
 }
 
@@ -58,9 +56,10 @@ func (v *Vhd) Extension() string {
 	return VhdType
 }
 
-// NewVhd returns a new Vhd(x) format encoder
-func NewVhd(generation2 bool) *Vhd {
+// NewVhd returns a new Vhd(x) format encoder converting with the given qemu-img settings
+func NewVhd(generation2 bool, qemuImg QemuImgSettings) *Vhd {
 	return &Vhd{
 		generation2: generation2,
+		qemuImg:     qemuImg,
 	}
 }
@@ -0,0 +1,43 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package formats
+
+import (
+	"fmt"
+)
+
+const (
+	// VmdkType represents the streamOptimized vmdk virtual drive format
+	VmdkType = "vmdk"
+)
+
+// Vmdk implements Converter interface to convert a RAW image into a streamOptimized vmdk file,
+// the compressed vmdk subformat vSphere imports
+type Vmdk struct {
+	qemuImg QemuImgSettings
+}
+
+// Convert converts the image in the vmdk format
+func (v *Vmdk) Convert(input, output string, isInputFile bool) (err error) {
+	if !isInputFile {
+		return fmt.Errorf("vmdk conversion requires a RAW file as an input")
+	}
+
+	return convertToStreamOptimizedVmdk(v.qemuImg, input, output)
+}
+
+// Extension returns the filetype extension produced by this converter.
+func (v *Vmdk) Extension() string {
+	return VmdkType
+}
+
+// NewVmdk returns a new vmdk format encoder converting with the given qemu-img settings
+func NewVmdk(qemuImg QemuImgSettings) *Vmdk {
+	return &Vmdk{qemuImg: qemuImg}
+}
+
+// convertToStreamOptimizedVmdk converts a RAW image into a streamOptimized vmdk
+func convertToStreamOptimizedVmdk(qemuImg QemuImgSettings, input, output string) (err error) {
+	return qemuImg.convert("-f", "raw", "-O", "vmdk", "-o", "subformat=streamOptimized", input, output)
+}
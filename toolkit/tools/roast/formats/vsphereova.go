@@ -0,0 +1,400 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+// Conversion to the vSphere OVA format requires external tools:
+// - qemu-img (for converting RAW image to a streamOptimized VMDK)
+
+package formats
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"microsoft.com/pkggen/internal/logger"
+)
+
+const (
+	// VsphereOvaType represents an OVA with a generated OVF descriptor and a streamOptimized VMDK, for vSphere
+	VsphereOvaType = "vsphere-ova"
+
+	vsphereOvaExtension = "ova"
+	// DefaultOvaOsType is the vSphere guest OS type written to the OVF descriptor by default
+	DefaultOvaOsType = "other5xLinux64Guest"
+	// DefaultOvaNetwork is the network the virtual machine's adapter connects to by default
+	DefaultOvaNetwork = "VM Network"
+	// DefaultOvaCPUs is the default number of virtual CPUs of the virtual machine
+	DefaultOvaCPUs = 2
+	// DefaultOvaMemoryMiB is the default memory of the virtual machine in MiB
+	DefaultOvaMemoryMiB = 2048
+
+	// OvaFirmwareEfi boots the virtual machine with UEFI
+	OvaFirmwareEfi = "efi"
+	// OvaFirmwareBios boots the virtual machine with a legacy BIOS
+	OvaFirmwareBios = "bios"
+
+	// ovfLinux64OsID is the CIM operating system ID of a 64-bit Linux
+	ovfLinux64OsID = 101
+	ovaFileMode    = 0644
+)
+
+// OvaSettings describe the virtual machine of a vSphere OVA.
+//   - OsType: the vSphere guest OS type written to the OVF descriptor
+//   - Network: the name of the network the virtual machine's adapter connects to
+//   - Firmware: the firmware the virtual machine boots with, OvaFirmwareEfi or OvaFirmwareBios
+//   - CPUs: the number of virtual CPUs
+//   - MemoryMiB: the memory in MiB
+type OvaSettings struct {
+	OsType    string
+	Network   string
+	Firmware  string
+	CPUs      int
+	MemoryMiB int
+}
+
+// ovfTemplate is an OVF 1.0 descriptor of a virtual machine with one disk and one network adapter.
+// Its arguments are, in order: the disk file name, the disk file size, the disk capacity, the network name,
+// the virtual machine name, the OS type, the CPUs, the memory in MiB and the firmware.
+const ovfTemplate = `<?xml version="1.0" encoding="UTF-8"?>
+<Envelope xmlns="http://schemas.dmtf.org/ovf/envelope/1" xmlns:ovf="http://schemas.dmtf.org/ovf/envelope/1" xmlns:rasd="http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/CIM_ResourceAllocationSettingData" xmlns:vssd="http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/CIM_VirtualSystemSettingData" xmlns:vmw="http://www.vmware.com/schema/ovf">
+  <References>
+    <File ovf:href="%[1]s" ovf:id="file1" ovf:size="%[2]d"/>
+  </References>
+  <DiskSection>
+    <Info>Virtual disk information</Info>
+    <Disk ovf:capacity="%[3]d" ovf:capacityAllocationUnits="byte" ovf:diskId="vmdisk1" ovf:fileRef="file1" ovf:format="http://www.vmware.com/interfaces/specifications/vmdk.html#streamOptimized"/>
+  </DiskSection>
+  <NetworkSection>
+    <Info>The list of logical networks</Info>
+    <Network ovf:name="%[4]s">
+      <Description>The %[4]s network</Description>
+    </Network>
+  </NetworkSection>
+  <VirtualSystem ovf:id="%[5]s">
+    <Info>A virtual machine</Info>
+    <Name>%[5]s</Name>
+    <OperatingSystemSection ovf:id="%[10]d" vmw:osType="%[6]s">
+      <Info>The kind of installed guest operating system</Info>
+    </OperatingSystemSection>
+    <VirtualHardwareSection>
+      <Info>Virtual hardware requirements</Info>
+      <System>
+        <vssd:ElementName>Virtual Hardware Family</vssd:ElementName>
+        <vssd:InstanceID>0</vssd:InstanceID>
+        <vssd:VirtualSystemIdentifier>%[5]s</vssd:VirtualSystemIdentifier>
+        <vssd:VirtualSystemType>vmx-13</vssd:VirtualSystemType>
+      </System>
+      <Item>
+        <rasd:AllocationUnits>hertz * 10^6</rasd:AllocationUnits>
+        <rasd:Description>Number of Virtual CPUs</rasd:Description>
+        <rasd:ElementName>%[7]d virtual CPU(s)</rasd:ElementName>
+        <rasd:InstanceID>1</rasd:InstanceID>
+        <rasd:ResourceType>3</rasd:ResourceType>
+        <rasd:VirtualQuantity>%[7]d</rasd:VirtualQuantity>
+      </Item>
+      <Item>
+        <rasd:AllocationUnits>byte * 2^20</rasd:AllocationUnits>
+        <rasd:Description>Memory Size</rasd:Description>
+        <rasd:ElementName>%[8]dMB of memory</rasd:ElementName>
+        <rasd:InstanceID>2</rasd:InstanceID>
+        <rasd:ResourceType>4</rasd:ResourceType>
+        <rasd:VirtualQuantity>%[8]d</rasd:VirtualQuantity>
+      </Item>
+      <Item>
+        <rasd:Address>0</rasd:Address>
+        <rasd:Description>SCSI Controller</rasd:Description>
+        <rasd:ElementName>SCSI controller 0</rasd:ElementName>
+        <rasd:InstanceID>3</rasd:InstanceID>
+        <rasd:ResourceSubType>VirtualSCSI</rasd:ResourceSubType>
+        <rasd:ResourceType>6</rasd:ResourceType>
+      </Item>
+      <Item>
+        <rasd:AddressOnParent>0</rasd:AddressOnParent>
+        <rasd:ElementName>Hard disk 1</rasd:ElementName>
+        <rasd:HostResource>ovf:/disk/vmdisk1</rasd:HostResource>
+        <rasd:InstanceID>4</rasd:InstanceID>
+        <rasd:Parent>3</rasd:Parent>
+        <rasd:ResourceType>17</rasd:ResourceType>
+      </Item>
+      <Item>
+        <rasd:AddressOnParent>7</rasd:AddressOnParent>
+        <rasd:AutomaticAllocation>true</rasd:AutomaticAllocation>
+        <rasd:Connection>%[4]s</rasd:Connection>
+        <rasd:Description>VmxNet3 ethernet adapter on "%[4]s"</rasd:Description>
+        <rasd:ElementName>Network adapter 1</rasd:ElementName>
+        <rasd:InstanceID>5</rasd:InstanceID>
+        <rasd:ResourceSubType>VmxNet3</rasd:ResourceSubType>
+        <rasd:ResourceType>10</rasd:ResourceType>
+      </Item>
+      <vmw:Config ovf:required="false" vmw:key="firmware" vmw:value="%[9]s"/>
+    </VirtualHardwareSection>
+  </VirtualSystem>
+</Envelope>
+`
+
+// ovfEnvelope holds the parts of an OVF descriptor checked when validating an OVA
+type ovfEnvelope struct {
+	Files []struct {
+		Href string `xml:"href,attr"`
+		Size int64  `xml:"size,attr"`
+	} `xml:"References>File"`
+}
+
+// VsphereOva implements Converter interface to convert a RAW image into an OVA vSphere can import.
+// Unlike Ova, it needs no VMX template or ovftool: the OVF descriptor is generated.
+type VsphereOva struct {
+	settings OvaSettings
+	qemuImg  QemuImgSettings
+}
+
+// Convert converts the image in the vSphere OVA format
+func (o *VsphereOva) Convert(input, output string, isInputFile bool) (err error) {
+	if !isInputFile {
+		return fmt.Errorf("vSphere OVA conversion requires a RAW file as an input")
+	}
+
+	switch o.settings.Firmware {
+	case OvaFirmwareEfi, OvaFirmwareBios:
+	case "":
+		return fmt.Errorf("the OVA firmware can't be derived from the image's boot type, set it to '%s' or '%s'", OvaFirmwareEfi, OvaFirmwareBios)
+	default:
+		return fmt.Errorf("unsupported OVA firmware (%s), must be '%s' or '%s'", o.settings.Firmware, OvaFirmwareEfi, OvaFirmwareBios)
+	}
+
+	name := strings.TrimSuffix(filepath.Base(output), filepath.Ext(output))
+	vmdkFilePath := strings.TrimSuffix(output, filepath.Ext(output)) + "-disk1.vmdk"
+	defer os.Remove(vmdkFilePath)
+
+	logger.Log.Infof(`Converting "%s" to "%s"`, input, vmdkFilePath)
+	err = convertToStreamOptimizedVmdk(o.qemuImg, input, vmdkFilePath)
+	if err != nil {
+		return
+	}
+
+	inputInfo, err := os.Stat(input)
+	if err != nil {
+		return
+	}
+	vmdkInfo, err := os.Stat(vmdkFilePath)
+	if err != nil {
+		return
+	}
+
+	vmdkFileName := filepath.Base(vmdkFilePath)
+	ovfFileName := name + ".ovf"
+	ovf := renderOvf(name, vmdkFileName, vmdkInfo.Size(), inputInfo.Size(), o.settings)
+
+	vmdkDigest, err := sha256File(vmdkFilePath)
+	if err != nil {
+		return
+	}
+	manifest := renderOvaManifest([]string{ovfFileName, vmdkFileName}, []string{sha256Hex([]byte(ovf)), vmdkDigest})
+
+	err = writeOva(output, name, ovf, manifest, vmdkFilePath)
+	if err != nil {
+		return fmt.Errorf("failed to write OVA (%s): %w", output, err)
+	}
+
+	err = validateOva(output)
+	if err != nil {
+		return fmt.Errorf("OVA (%s) is not valid: %w", output, err)
+	}
+
+	logger.Log.Infof(`Created OVA file "%s"`, output)
+	return
+}
+
+// Extension returns the filetype extension produced by this converter.
+func (o *VsphereOva) Extension() string {
+	return vsphereOvaExtension
+}
+
+// NewVsphereOva returns a new vSphere OVA format encoder for a virtual machine with the given settings,
+// converting the disk with the given qemu-img settings
+func NewVsphereOva(settings OvaSettings, qemuImg QemuImgSettings) *VsphereOva {
+	return &VsphereOva{settings: settings, qemuImg: qemuImg}
+}
+
+// renderOvf generates the OVF descriptor of a virtual machine booting the given disk
+func renderOvf(name, vmdkFileName string, vmdkSize, diskCapacity int64, settings OvaSettings) string {
+	return fmt.Sprintf(ovfTemplate,
+		xmlEscape(vmdkFileName), vmdkSize, diskCapacity, xmlEscape(settings.Network), xmlEscape(name),
+		xmlEscape(settings.OsType), settings.CPUs, settings.MemoryMiB, settings.Firmware, ovfLinux64OsID)
+}
+
+// renderOvaManifest lists the SHA256 digest of each file of the OVA, in the format vSphere checks
+func renderOvaManifest(fileNames, digests []string) string {
+	var manifest strings.Builder
+	for i, fileName := range fileNames {
+		manifest.WriteString(fmt.Sprintf("SHA256(%s)= %s\n", fileName, digests[i]))
+	}
+	return manifest.String()
+}
+
+// writeOva writes the OVF descriptor, the manifest and the disk into a ustar archive, in that order,
+// since importers expect the descriptor first
+func writeOva(output, name, ovf, manifest, vmdkFilePath string) (err error) {
+	ovaFile, err := os.Create(output)
+	if err != nil {
+		return
+	}
+	defer ovaFile.Close()
+
+	tarWriter := tar.NewWriter(ovaFile)
+	for _, entry := range []struct{ name, contents string }{{name + ".ovf", ovf}, {name + ".mf", manifest}} {
+		err = tarWriter.WriteHeader(&tar.Header{Name: entry.name, Mode: ovaFileMode, Size: int64(len(entry.contents)), Format: tar.FormatUSTAR})
+		if err != nil {
+			return
+		}
+		_, err = tarWriter.Write([]byte(entry.contents))
+		if err != nil {
+			return
+		}
+	}
+
+	vmdkFile, err := os.Open(vmdkFilePath)
+	if err != nil {
+		return
+	}
+	defer vmdkFile.Close()
+	vmdkInfo, err := vmdkFile.Stat()
+	if err != nil {
+		return
+	}
+	err = tarWriter.WriteHeader(&tar.Header{Name: filepath.Base(vmdkFilePath), Mode: ovaFileMode, Size: vmdkInfo.Size(), Format: tar.FormatUSTAR})
+	if err != nil {
+		return
+	}
+	_, err = io.Copy(tarWriter, vmdkFile)
+	if err != nil {
+		return
+	}
+
+	return tarWriter.Close()
+}
+
+// validateOva reads back an OVA and checks it starts with the OVF descriptor and the manifest,
+// that the descriptor parses and every file it references is in the archive with the right size,
+// and that every file matches its digest in the manifest.
+func validateOva(ovaPath string) (err error) {
+	ovaFile, err := os.Open(ovaPath)
+	if err != nil {
+		return
+	}
+	defer ovaFile.Close()
+
+	var (
+		names    []string
+		ovf      []byte
+		manifest []byte
+		sizes    = map[string]int64{}
+		digests  = map[string]string{}
+	)
+
+	tarReader := tar.NewReader(ovaFile)
+	for {
+		header, nextErr := tarReader.Next()
+		if nextErr == io.EOF {
+			break
+		}
+		if nextErr != nil {
+			return nextErr
+		}
+
+		names = append(names, header.Name)
+		sizes[header.Name] = header.Size
+
+		hash := sha256.New()
+		var contents bytes.Buffer
+		writer := io.Writer(hash)
+		isDescriptor := strings.HasSuffix(header.Name, ".ovf") || strings.HasSuffix(header.Name, ".mf")
+		if isDescriptor {
+			writer = io.MultiWriter(hash, &contents)
+		}
+		_, err = io.Copy(writer, tarReader)
+		if err != nil {
+			return
+		}
+		digests[header.Name] = hex.EncodeToString(hash.Sum(nil))
+
+		switch filepath.Ext(header.Name) {
+		case ".ovf":
+			ovf = contents.Bytes()
+		case ".mf":
+			manifest = contents.Bytes()
+		}
+	}
+
+	if len(names) < 2 || filepath.Ext(names[0]) != ".ovf" || filepath.Ext(names[1]) != ".mf" {
+		return fmt.Errorf("the archive must start with the .ovf descriptor and the .mf manifest, found (%s)", strings.Join(names, ", "))
+	}
+
+	var envelope ovfEnvelope
+	err = xml.Unmarshal(ovf, &envelope)
+	if err != nil {
+		return fmt.Errorf("failed to parse the OVF descriptor (%s): %w", names[0], err)
+	}
+	for _, reference := range envelope.Files {
+		size, ok := sizes[reference.Href]
+		if !ok {
+			return fmt.Errorf("file (%s) referenced by the OVF descriptor is missing", reference.Href)
+		}
+		if size != reference.Size {
+			return fmt.Errorf("file (%s) has a size of %d bytes, the OVF descriptor expects %d", reference.Href, size, reference.Size)
+		}
+	}
+
+	checked := 0
+	for _, line := range strings.Split(strings.TrimSpace(string(manifest)), "\n") {
+		openIndex, closeIndex := strings.Index(line, "("), strings.Index(line, ")= ")
+		if !strings.HasPrefix(line, "SHA256(") || openIndex < 0 || closeIndex < openIndex {
+			return fmt.Errorf("invalid manifest line (%s)", line)
+		}
+		fileName, digest := line[openIndex+1:closeIndex], line[closeIndex+len(")= "):]
+		if digests[fileName] != digest {
+			return fmt.Errorf("file (%s) does not match its digest in the manifest", fileName)
+		}
+		checked++
+	}
+	if checked != len(names)-1 {
+		return fmt.Errorf("the manifest lists %d files, but the archive holds %d besides the manifest", checked, len(names)-1)
+	}
+
+	return
+}
+
+// sha256File returns the hex encoded SHA256 digest of a file
+func sha256File(path string) (digest string, err error) {
+	fileToHash, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer fileToHash.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, fileToHash)
+	if err != nil {
+		return
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// sha256Hex returns the hex encoded SHA256 digest of data
+func sha256Hex(data []byte) string {
+	digest := sha256.Sum256(data)
+	return hex.EncodeToString(digest[:])
+}
+
+// xmlEscape escapes text for use in an XML attribute or element
+func xmlEscape(text string) string {
+	var escaped strings.Builder
+	xml.EscapeText(&escaped, []byte(text))
+	return escaped.String()
+}
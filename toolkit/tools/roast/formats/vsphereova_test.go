@@ -0,0 +1,191 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package formats
+
+import (
+	"archive/tar"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testOvaSettings = OvaSettings{
+	OsType:    DefaultOvaOsType,
+	Network:   DefaultOvaNetwork,
+	Firmware:  OvaFirmwareEfi,
+	CPUs:      DefaultOvaCPUs,
+	MemoryMiB: DefaultOvaMemoryMiB,
+}
+
+// ovaEntry is a file of a test OVA
+type ovaEntry struct {
+	name     string
+	contents string
+}
+
+// writeTestOva writes the entries into a ustar archive, in order
+func writeTestOva(t *testing.T, entries []ovaEntry) string {
+	ovaPath := filepath.Join(t.TempDir(), "image.ova")
+	ovaFile, err := os.Create(ovaPath)
+	assert.NoError(t, err)
+	defer ovaFile.Close()
+
+	tarWriter := tar.NewWriter(ovaFile)
+	for _, entry := range entries {
+		err = tarWriter.WriteHeader(&tar.Header{Name: entry.name, Mode: ovaFileMode, Size: int64(len(entry.contents)), Format: tar.FormatUSTAR})
+		assert.NoError(t, err)
+		_, err = tarWriter.Write([]byte(entry.contents))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, tarWriter.Close())
+	return ovaPath
+}
+
+func TestShouldRenderOvf(t *testing.T) {
+	tests := []struct {
+		name             string
+		settings         OvaSettings
+		expectedContents []string
+	}{
+		{
+			name:     "efi",
+			settings: testOvaSettings,
+			expectedContents: []string{
+				`<File ovf:href="image-disk1.vmdk" ovf:id="file1" ovf:size="1024"/>`,
+				`<Disk ovf:capacity="4096" ovf:capacityAllocationUnits="byte"`,
+				`<OperatingSystemSection ovf:id="101" vmw:osType="other5xLinux64Guest">`,
+				`<rasd:VirtualQuantity>2</rasd:VirtualQuantity>`,
+				`<rasd:VirtualQuantity>2048</rasd:VirtualQuantity>`,
+				`<vmw:Config ovf:required="false" vmw:key="firmware" vmw:value="efi"/>`,
+			},
+		},
+		{
+			name:     "bios with more resources",
+			settings: OvaSettings{OsType: "vmwarePhoton64Guest", Network: "Lab", Firmware: OvaFirmwareBios, CPUs: 8, MemoryMiB: 16384},
+			expectedContents: []string{
+				`vmw:osType="vmwarePhoton64Guest"`,
+				`<Network ovf:name="Lab">`,
+				`<rasd:VirtualQuantity>8</rasd:VirtualQuantity>`,
+				`<rasd:VirtualQuantity>16384</rasd:VirtualQuantity>`,
+				`vmw:value="bios"`,
+			},
+		},
+		{
+			name:     "escaped network",
+			settings: OvaSettings{OsType: DefaultOvaOsType, Network: `Lab & "Test"`, Firmware: OvaFirmwareEfi, CPUs: 1, MemoryMiB: 512},
+			expectedContents: []string{
+				`<Network ovf:name="Lab &amp; &#34;Test&#34;">`,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ovf := renderOvf("image", "image-disk1.vmdk", 1024, 4096, test.settings)
+			for _, expected := range test.expectedContents {
+				assert.Contains(t, ovf, expected)
+			}
+
+			var envelope ovfEnvelope
+			assert.NoError(t, xml.Unmarshal([]byte(ovf), &envelope))
+			assert.Len(t, envelope.Files, 1)
+			assert.Equal(t, "image-disk1.vmdk", envelope.Files[0].Href)
+			assert.Equal(t, int64(1024), envelope.Files[0].Size)
+		})
+	}
+}
+
+func TestShouldRenderOvaManifest(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    []string
+		digests  []string
+		expected string
+	}{
+		{
+			name:     "no files",
+			expected: "",
+		},
+		{
+			name:     "descriptor and disk",
+			files:    []string{"image.ovf", "image-disk1.vmdk"},
+			digests:  []string{"aa", "bb"},
+			expected: "SHA256(image.ovf)= aa\nSHA256(image-disk1.vmdk)= bb\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, renderOvaManifest(test.files, test.digests))
+		})
+	}
+}
+
+func TestShouldValidateOva(t *testing.T) {
+	const vmdk = "disk contents"
+	ovf := renderOvf("image", "image-disk1.vmdk", int64(len(vmdk)), 4096, testOvaSettings)
+	validManifest := renderOvaManifest([]string{"image.ovf", "image-disk1.vmdk"}, []string{sha256Hex([]byte(ovf)), sha256Hex([]byte(vmdk))})
+
+	tests := []struct {
+		name          string
+		entries       []ovaEntry
+		expectedError string
+	}{
+		{
+			name:    "valid",
+			entries: []ovaEntry{{"image.ovf", ovf}, {"image.mf", validManifest}, {"image-disk1.vmdk", vmdk}},
+		},
+		{
+			name:          "manifest first",
+			entries:       []ovaEntry{{"image.mf", validManifest}, {"image.ovf", ovf}, {"image-disk1.vmdk", vmdk}},
+			expectedError: "the archive must start with the .ovf descriptor and the .mf manifest, found (image.mf, image.ovf, image-disk1.vmdk)",
+		},
+		{
+			name:          "invalid descriptor",
+			entries:       []ovaEntry{{"image.ovf", "<Envelope>"}, {"image.mf", validManifest}, {"image-disk1.vmdk", vmdk}},
+			expectedError: "failed to parse the OVF descriptor (image.ovf): XML syntax error on line 1: unexpected EOF",
+		},
+		{
+			name:          "missing disk",
+			entries:       []ovaEntry{{"image.ovf", ovf}, {"image.mf", renderOvaManifest([]string{"image.ovf"}, []string{sha256Hex([]byte(ovf))})}},
+			expectedError: "file (image-disk1.vmdk) referenced by the OVF descriptor is missing",
+		},
+		{
+			name:          "truncated disk",
+			entries:       []ovaEntry{{"image.ovf", ovf}, {"image.mf", validManifest}, {"image-disk1.vmdk", vmdk[1:]}},
+			expectedError: "file (image-disk1.vmdk) has a size of 12 bytes, the OVF descriptor expects 13",
+		},
+		{
+			name:          "corrupted disk",
+			entries:       []ovaEntry{{"image.ovf", ovf}, {"image.mf", validManifest}, {"image-disk1.vmdk", strings.ToUpper(vmdk)}},
+			expectedError: "file (image-disk1.vmdk) does not match its digest in the manifest",
+		},
+		{
+			name:          "invalid manifest line",
+			entries:       []ovaEntry{{"image.ovf", ovf}, {"image.mf", "MD5(image.ovf) 00\n"}, {"image-disk1.vmdk", vmdk}},
+			expectedError: "invalid manifest line (MD5(image.ovf) 00)",
+		},
+		{
+			name:          "disk missing from the manifest",
+			entries:       []ovaEntry{{"image.ovf", ovf}, {"image.mf", renderOvaManifest([]string{"image.ovf"}, []string{sha256Hex([]byte(ovf))})}, {"image-disk1.vmdk", vmdk}},
+			expectedError: "the manifest lists 1 files, but the archive holds 2 besides the manifest",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateOva(writeTestOva(t, test.entries))
+			if test.expectedError == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+				assert.Equal(t, test.expectedError, err.Error())
+			}
+		})
+	}
+}
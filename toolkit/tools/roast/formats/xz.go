@@ -20,8 +20,17 @@ type Xz struct {
 
 // Convert converts the image in the xz format
 func (x *Xz) Convert(input, output string, isInputFile bool) (err error) {
-	const squashErrors = false
+	dstFile, err := os.Create(output)
+	if err != nil {
+		return
+	}
+	defer dstFile.Close()
+
+	return x.ConvertToStream(input, dstFile, isInputFile)
+}
 
+// ConvertToStream compresses the image in the xz format into a stream
+func (x *Xz) ConvertToStream(input string, output io.Writer, isInputFile bool) (err error) {
 	if !isInputFile {
 		return fmt.Errorf("xz compression requires a file as an input")
 	}
@@ -32,21 +41,17 @@ func (x *Xz) Convert(input, output string, isInputFile bool) (err error) {
 	}
 	defer srcFile.Close()
 
-	dstFile, err := os.Create(output)
+	xzWriter, err := xz.NewWriter(output)
 	if err != nil {
 		return
 	}
-	defer dstFile.Close()
 
-	xzWriter, err := xz.NewWriter(dstFile)
+	_, err = io.Copy(xzWriter, srcFile)
 	if err != nil {
+		xzWriter.Close()
 		return
 	}
-	defer xzWriter.Close()
-
-	_, err = io.Copy(xzWriter, srcFile)
-
-	return
+	return xzWriter.Close()
 }
 
 // Extension returns the filetype extension produced by this converter.
@@ -0,0 +1,47 @@
+// Copyright (c) Microsoft Corporation.
+// Licensed under the MIT License.
+
+package formats
+
+import (
+	"fmt"
+
+	"microsoft.com/pkggen/internal/file"
+)
+
+// ZstdPatchType represents the zstd patch delta format
+const ZstdPatchType = "zstdpatch"
+
+// zstdPatchMetadataExtension is appended to the name of a zstd patch for its metadata file, matching installutils
+const zstdPatchMetadataExtension = ".json"
+
+// ZstdPatch implements Converter interface for zstd patch partition deltas
+type ZstdPatch struct {
+}
+
+// Convert copies the zstd patch and its metadata file
+func (z *ZstdPatch) Convert(input, output string, isInputFile bool) (err error) {
+	if !isInputFile {
+		return fmt.Errorf("zstd patch conversion requires a zstd patch file as an input")
+	}
+	err = file.Copy(input, output)
+	if err != nil {
+		return
+	}
+	return file.Copy(input+zstdPatchMetadataExtension, output+zstdPatchMetadataExtension)
+}
+
+// Sidecars returns the metadata file written next to the zstd patch, which is needed to apply it
+func (z *ZstdPatch) Sidecars(output string) []string {
+	return []string{output + zstdPatchMetadataExtension}
+}
+
+// Extension returns the filetype extension produced by this converter.
+func (z *ZstdPatch) Extension() string {
+	return ZstdPatchType
+}
+
+// NewZstdPatch returns a new zstd patch format encoder
+func NewZstdPatch() *ZstdPatch {
+	return &ZstdPatch{}
+}
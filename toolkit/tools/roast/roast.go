@@ -6,20 +6,39 @@
 package main
 
 import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
 	"fmt"
+	"hash"
+	"io"
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 
 	"gopkg.in/alecthomas/kingpin.v2"
 	"microsoft.com/pkggen/imagegen/configuration"
 	"microsoft.com/pkggen/internal/exe"
 	"microsoft.com/pkggen/internal/file"
 	"microsoft.com/pkggen/internal/logger"
+	"microsoft.com/pkggen/internal/shell"
 	"microsoft.com/pkggen/roast/formats"
 )
 
-const defaultWorkerCount = "10"
+const (
+	defaultWorkerCount = "10"
+
+	// maxQemuImgCoroutines is the most parallel coroutines qemu-img accepts
+	maxQemuImgCoroutines = 16
+
+	// stdoutOutputDir streams the artifact to stdout instead of writing it to a directory
+	stdoutOutputDir = "-"
+
+	// Digests of the artifact checksum files, also used as their extension
+	sha256Digest = "sha256"
+	sha512Digest = "sha512"
+)
 
 type convertRequest struct {
 	inputPath   string
@@ -33,39 +52,102 @@ type convertResult struct {
 	convertedFile string
 }
 
+// converterOptions are the settings of the converters, taken from the command line
+type converterOptions struct {
+	ova     formats.OvaSettings
+	qemuImg formats.QemuImgSettings
+}
+
 var (
 	app = kingpin.New("roast", "A tool to convert raw disk file into another image type")
 
 	logFile  = exe.LogFileFlag(app)
 	logLevel = exe.LogLevelFlag(app)
+	logColor = exe.LogColorFlag(app)
 
 	inputDir  = exe.InputDirFlag(app, "A directory containing a .RAW image or a rootfs directory")
-	outputDir = exe.OutputDirFlag(app, "A destination directory for the output image")
+	outputDir = exe.OutputDirFlag(app, "A destination directory for the output image, or '-' to stream the only artifact to stdout")
 
-	configFile = app.Flag("config", "Path to the image config file.").Required().ExistingFile()
-	tmpDir     = app.Flag("tmp-dir", "Directory to store temporary files while converting.").Required().String()
+	configFile     = app.Flag("config", "Path to the image config file.").Required().ExistingFile()
+	configOverlays = app.Flag("config-overlay", "Path to a config file deep merged over the image config, in order. May be repeated.").ExistingFiles()
+	configVars     = exe.ConfigVarsFlag(app)
+	tmpDir         = app.Flag("tmp-dir", "Directory to store temporary files while converting.").Required().String()
 
 	releaseVersion = app.Flag("release-version", "Release version to add to the output artifact name").String()
 
 	workers = app.Flag("workers", "Number of concurrent goroutines to convert with.").Default(defaultWorkerCount).Int()
 
 	imageTag = app.Flag("image-tag", "Tag (text) appended to the image name. Empty by default.").String()
+
+	partitions = app.Flag("partition", "Only convert the artifacts of the partition with this index, ID, name or mount point. May be repeated.").Strings()
+
+	checksums = app.Flag("checksum", "Write a checksum file with this digest next to each artifact, in the format 'sha256sum -c' reads. May be repeated.").Enums(sha256Digest, sha512Digest)
+
+	qemuImgAttempts   = app.Flag("qemu-img-attempts", "Number of times to attempt a qemu-img conversion before failing.").Default(strconv.Itoa(formats.DefaultQemuImgAttempts)).Int()
+	qemuImgRetryDelay = app.Flag("qemu-img-retry-delay", "Base delay between qemu-img conversion attempts.").Default(formats.DefaultQemuImgRetryDelay.String()).Duration()
+	qemuImgCoroutines = app.Flag("qemu-img-coroutines", "Number of parallel coroutines (1-16) of each qemu-img conversion. Defaults to qemu-img's own default.").Int()
+
+	ovaOsType    = app.Flag("ova-os-type", "vSphere guest OS type written to the OVF descriptor of vsphere-ova artifacts.").Default(formats.DefaultOvaOsType).String()
+	ovaNetwork   = app.Flag("ova-network", "Network the adapter of vsphere-ova artifacts connects to.").Default(formats.DefaultOvaNetwork).String()
+	ovaFirmware  = app.Flag("ova-firmware", "Firmware vsphere-ova artifacts boot with. Derived from the image's BootType by default.").Enum(formats.OvaFirmwareEfi, formats.OvaFirmwareBios)
+	ovaCPUs      = app.Flag("ova-cpus", "Number of virtual CPUs of vsphere-ova artifacts.").Default(strconv.Itoa(formats.DefaultOvaCPUs)).Int()
+	ovaMemoryMiB = app.Flag("ova-memory", "Memory of vsphere-ova artifacts, in MiB.").Default(strconv.Itoa(formats.DefaultOvaMemoryMiB)).Int()
+
+	nice, ioniceClass, ioniceLevel = exe.ResourceLimitFlags(app)
 )
 
 func main() {
 	app.Version(exe.ToolkitVersion)
 	kingpin.MustParse(app.Parse(os.Args[1:]))
 	logger.InitBestEffort(*logFile, *logLevel)
+	if !*logColor {
+		logger.SetStderrColors(false)
+	}
 
 	if *workers <= 0 {
 		logger.Log.Panicf("Value in --workers must be greater than zero. Found %d", *workers)
 	}
 
+	if *qemuImgAttempts <= 0 {
+		logger.Log.Panicf("Value in --qemu-img-attempts must be greater than zero. Found %d", *qemuImgAttempts)
+	}
+
+	if *qemuImgCoroutines < 0 || *qemuImgCoroutines > maxQemuImgCoroutines {
+		logger.Log.Panicf("Value in --qemu-img-coroutines must be in the range 1-%d. Found %d", maxQemuImgCoroutines, *qemuImgCoroutines)
+	}
+
+	limits := shell.ResourceLimits{Nice: *nice, IoniceClass: *ioniceClass, IoniceLevel: *ioniceLevel}
+	err := limits.IsValid()
+	logger.PanicOnError(err, "Invalid resource limits")
+
+	if *ovaCPUs <= 0 || *ovaMemoryMiB <= 0 {
+		logger.Log.Panicf("Values in --ova-cpus and --ova-memory must be greater than zero. Found %d and %d", *ovaCPUs, *ovaMemoryMiB)
+	}
+	options := converterOptions{
+		ova: formats.OvaSettings{
+			OsType:    *ovaOsType,
+			Network:   *ovaNetwork,
+			CPUs:      *ovaCPUs,
+			MemoryMiB: *ovaMemoryMiB,
+		},
+		qemuImg: formats.QemuImgSettings{
+			Attempts:   *qemuImgAttempts,
+			RetryDelay: *qemuImgRetryDelay,
+			Coroutines: *qemuImgCoroutines,
+			Limits:     limits,
+		},
+	}
+
 	inDirPath, err := filepath.Abs(*inputDir)
 	if err != nil {
 		logger.Log.Panicf("Error when calculating input directory path: %s", err)
 	}
 
+	streamToStdout := *outputDir == stdoutOutputDir
+	if streamToStdout && len(*checksums) != 0 {
+		logger.Log.Panicf("--checksum can not be used when streaming the artifact to stdout")
+	}
+
 	outDirPath, err := filepath.Abs(*outputDir)
 	if err != nil {
 		logger.Log.Panicf("Error when calculating absolute output path: %s", err)
@@ -76,25 +158,70 @@ func main() {
 		logger.Log.Panicf("Error when calculating absolute temporary path: %s", err)
 	}
 
-	err = os.MkdirAll(outDirPath, os.ModePerm)
-	if err != nil {
-		logger.Log.Panicf("Error when creating output directory. Error: %s", err)
+	// A streamed artifact is only staged in the temporary directory
+	if streamToStdout {
+		tmpDirPath, err = filepath.Abs(*tmpDir)
+		if err != nil {
+			logger.Log.Panicf("Error when calculating absolute temporary path: %s", err)
+		}
+	} else {
+		err = os.MkdirAll(outDirPath, os.ModePerm)
+		if err != nil {
+			logger.Log.Panicf("Error when creating output directory. Error: %s", err)
+		}
 	}
 
-	config, err := configuration.Load(*configFile)
+	config, _, err := configuration.LoadLayered(append([]string{*configFile}, *configOverlays...), *configVars)
 	if err != nil {
 		logger.Log.Panicf("Failed loading image configuration. Error: %s", err)
 	}
 
-	err = generateImageArtifacts(*workers, inDirPath, outDirPath, *releaseVersion, *imageTag, tmpDirPath, config)
+	if len(config.SystemConfigs) > 0 {
+		options.ova.Firmware, err = ovaFirmwareForBootType(config.SystemConfigs[0].BootType, *ovaFirmware)
+		if err != nil {
+			logger.Log.Panicf("Failed selecting the firmware of vsphere-ova artifacts. Error: %s", err)
+		}
+
+		err = config.FilterPartitionArtifacts(config.SystemConfigs[0].PartitionSettings, *partitions)
+		if err != nil {
+			logger.Log.Panicf("Failed selecting the partitions to convert. Error: %s", err)
+		}
+	}
+
+	if streamToStdout {
+		err = streamImageArtifact(inDirPath, *releaseVersion, *imageTag, tmpDirPath, config, options, os.Stdout)
+	} else {
+		err = generateImageArtifacts(*workers, inDirPath, outDirPath, *releaseVersion, *imageTag, tmpDirPath, config, options)
+	}
 	if err != nil {
 		logger.Log.Panic(err)
 	}
 }
 
-func generateImageArtifacts(workers int, inDir, outDir, releaseVersion, imageTag, tmpDir string, config configuration.Config) (err error) {
-	const defaultSystemConfig = 0
+// ovaFirmwareForBootType returns the firmware vsphere-ova artifacts boot with: the requested one, which must be able to boot
+// an image of the given boot type, or else the one matching the boot type. No firmware matches a boot type of none.
+func ovaFirmwareForBootType(bootType, requested string) (firmware string, err error) {
+	switch bootType {
+	case configuration.BootTypeLegacy:
+		firmware = formats.OvaFirmwareBios
+	case configuration.BootTypeEfi, configuration.BootTypeHybrid:
+		firmware = formats.OvaFirmwareEfi
+	}
+
+	switch {
+	case requested == "":
+	case bootType == configuration.BootTypeHybrid:
+		// A hybrid image boots with either firmware
+		firmware = requested
+	case firmware != "" && requested != firmware:
+		err = fmt.Errorf("firmware (%s) can't boot an image with [BootType] (%s), use (%s)", requested, bootType, firmware)
+	default:
+		firmware = requested
+	}
+	return
+}
 
+func generateImageArtifacts(workers int, inDir, outDir, releaseVersion, imageTag, tmpDir string, config configuration.Config, options converterOptions) (err error) {
 	err = os.MkdirAll(tmpDir, os.ModePerm)
 	if err != nil {
 		return
@@ -105,13 +232,8 @@ func generateImageArtifacts(workers int, inDir, outDir, releaseVersion, imageTag
 		return
 	}
 
-	numberOfArtifacts := 0
-	for _, disk := range config.Disks {
-		numberOfArtifacts += len(disk.Artifacts)
-		for _, partition := range disk.Partitions {
-			numberOfArtifacts += len(partition.Artifacts)
-		}
-	}
+	requests := artifactRequests(inDir, config)
+	numberOfArtifacts := len(requests)
 
 	logger.Log.Infof("Converting (%d) artifacts", numberOfArtifacts)
 
@@ -120,48 +242,185 @@ func generateImageArtifacts(workers int, inDir, outDir, releaseVersion, imageTag
 
 	// Start the workers now so they begin working as soon as a new job is buffered.
 	for i := 0; i < workers; i++ {
-		go artifactConverterWorker(convertRequests, convertedResults, releaseVersion, tmpDir, imageTag, outDir)
+		go artifactConverterWorker(convertRequests, convertedResults, releaseVersion, tmpDir, imageTag, outDir, *checksums, options)
+	}
+
+	for _, request := range requests {
+		convertRequests <- request
 	}
 
+	close(convertRequests)
+
+	failedArtifacts := []string{}
+	for i := 0; i < numberOfArtifacts; i++ {
+		result := <-convertedResults
+		if result.convertedFile == "" {
+			failedArtifacts = append(failedArtifacts, result.artifactName)
+		} else {
+			logger.Log.Infof("[%d/%d] Converted (%s) -> (%s)", (i + 1), numberOfArtifacts, result.originalPath, result.convertedFile)
+		}
+	}
+
+	if len(failedArtifacts) != 0 {
+		err = fmt.Errorf("failed to generate the following artifacts: %v", failedArtifacts)
+		return
+	}
+
+	err = copySbomForArtifacts(inDir, outDir, releaseVersion, imageTag, config)
+	return
+}
+
+// artifactRequests lists the conversions of every disk and partition artifact in the configuration.
+func artifactRequests(inDir string, config configuration.Config) (requests []*convertRequest) {
+	const defaultSystemConfig = 0
+
 	for i, disk := range config.Disks {
 		for _, artifact := range disk.Artifacts {
-			inputName, isFile := diskArtifactInput(i, disk)
-			convertRequests <- &convertRequest{
+			inputName, isFile := diskArtifactInput(i, disk, artifact)
+			requests = append(requests, &convertRequest{
 				inputPath:   filepath.Join(inDir, inputName),
 				isInputFile: isFile,
 				artifact:    artifact,
-			}
+			})
 		}
 
 		for j, partition := range disk.Partitions {
 			for _, artifact := range partition.Artifacts {
 				// Currently only process 1 system config
 				inputName, isFile := partitionArtifactInput(i, j, retrievePartitionSettings(&config.SystemConfigs[defaultSystemConfig], partition.ID))
-				convertRequests <- &convertRequest{
+				requests = append(requests, &convertRequest{
 					inputPath:   filepath.Join(inDir, inputName),
 					isInputFile: isFile,
 					artifact:    artifact,
-				}
+				})
 			}
 		}
 	}
+	return
+}
 
-	close(convertRequests)
+// streamImageArtifact converts the configuration's only artifact and writes it to output instead of a file.
+// Only the last step, the compression if there is one, is streamed. A type conversion followed by a
+// compression is staged in tmpDir. Formats which need a seekable output file, such as those written by
+// qemu-img, can't be streamed.
+func streamImageArtifact(inDir, releaseVersion, imageTag, tmpDir string, config configuration.Config, options converterOptions, output io.Writer) (err error) {
+	if len(config.Disks) > 1 {
+		return fmt.Errorf("this program currently only supports one disk")
+	}
 
-	failedArtifacts := []string{}
-	for i := 0; i < numberOfArtifacts; i++ {
-		result := <-convertedResults
-		if result.convertedFile == "" {
-			failedArtifacts = append(failedArtifacts, result.artifactName)
-		} else {
-			logger.Log.Infof("[%d/%d] Converted (%s) -> (%s)", (i + 1), numberOfArtifacts, result.originalPath, result.convertedFile)
+	requests := artifactRequests(inDir, config)
+	if len(requests) != 1 {
+		return fmt.Errorf("streaming to stdout requires exactly one artifact, found (%d)", len(requests))
+	}
+	req := requests[0]
+
+	streamFormat := req.artifact.Compression
+	if streamFormat == "" {
+		streamFormat = req.artifact.Type
+	}
+	if streamFormat == "" {
+		return fmt.Errorf("artifact (%s) has no type or compression", req.artifact.Name)
+	}
+
+	converter, err := converterFactory(streamFormat, options)
+	if err != nil {
+		return
+	}
+	streamConverter, ok := converter.(formats.StreamConverter)
+	if !ok {
+		return fmt.Errorf("artifact (%s) can't be streamed to stdout, (%s) output must be written to a seekable file; use a raw, gz, xz, tar.gz or tar.xz artifact or compression instead", req.artifact.Name, streamFormat)
+	}
+
+	virtualSize, err := imageVirtualSize(req.inputPath, req.isInputFile)
+	if err != nil {
+		return
+	}
+	err = req.artifact.CheckSize(0, virtualSize)
+	if err != nil {
+		return
+	}
+
+	inputPath := req.inputPath
+	isInputFile := req.isInputFile
+	if req.artifact.Compression != "" && req.artifact.Type != "" {
+		err = os.MkdirAll(tmpDir, os.ModePerm)
+		if err != nil {
+			return
+		}
+
+		fullArtifactName := req.artifact.Name
+		if releaseVersion != "" {
+			fullArtifactName = fullArtifactName + "-" + releaseVersion
+		}
+
+		const appendExtension = false
+		var sidecars []string
+		inputPath, sidecars, err = convertArtifact(fullArtifactName, tmpDir, req.artifact.Type, imageTag, inputPath, isInputFile, appendExtension, options)
+		if err != nil {
+			return fmt.Errorf("failed to convert artifact (%s) to type (%s): %w", req.artifact.Name, req.artifact.Type, err)
+		}
+		defer os.Remove(inputPath)
+		if len(sidecars) != 0 {
+			removeFiles(sidecars)
+			return fmt.Errorf("artifact (%s) can't be streamed to stdout, (%s) output comes with files which must be published next to it", req.artifact.Name, req.artifact.Type)
 		}
+		isInputFile = true
 	}
 
-	if len(failedArtifacts) != 0 {
-		err = fmt.Errorf("failed to generate the following artifacts: %v", failedArtifacts)
+	logger.Log.Infof("Streaming artifact (%s) as (%s) to stdout", req.artifact.Name, streamFormat)
+	// The artifact is already written once its size is known, so MaxSize only fails the build afterwards
+	countingOutput := &sizeCountingWriter{writer: output}
+	err = streamConverter.ConvertToStream(inputPath, countingOutput, isInputFile)
+	if err != nil {
+		return fmt.Errorf("failed to stream artifact (%s): %w", req.artifact.Name, err)
+	}
+
+	err = req.artifact.CheckSize(countingOutput.size, virtualSize)
+	if err != nil {
+		return
 	}
 
+	logger.Log.Info("Software bill of materials, if any, are not copied when streaming to stdout")
+	return
+}
+
+// copySbomForArtifacts places the software bill of materials produced by the imager, if any, next to each artifact.
+func copySbomForArtifacts(inDir, outDir, releaseVersion, imageTag string, config configuration.Config) (err error) {
+	const (
+		sbomFileName  = "sbom.json"
+		sbomExtension = ".sbom.json"
+	)
+
+	sbomPath := filepath.Join(inDir, sbomFileName)
+	exists, err := file.PathExists(sbomPath)
+	if err != nil || !exists {
+		return
+	}
+
+	var artifacts []configuration.Artifact
+	for _, disk := range config.Disks {
+		artifacts = append(artifacts, disk.Artifacts...)
+		for _, partition := range disk.Partitions {
+			artifacts = append(artifacts, partition.Artifacts...)
+		}
+	}
+
+	for _, artifact := range artifacts {
+		sbomName := artifact.Name
+		if releaseVersion != "" {
+			sbomName = sbomName + "-" + releaseVersion
+		}
+		if imageTag != "" {
+			sbomName = sbomName + "-" + imageTag
+		}
+
+		artifactSbomPath := filepath.Join(outDir, sbomName+sbomExtension)
+		logger.Log.Infof("Copying software bill of materials to (%s)", artifactSbomPath)
+		err = file.Copy(sbomPath, artifactSbomPath)
+		if err != nil {
+			return fmt.Errorf("failed to copy software bill of materials to (%s): %w", artifactSbomPath, err)
+		}
+	}
 	return
 }
 
@@ -176,7 +435,7 @@ func retrievePartitionSettings(systemConfig *configuration.SystemConfig, searche
 	return
 }
 
-func artifactConverterWorker(convertRequests chan *convertRequest, convertedResults chan *convertResult, releaseVersion, tmpDir, imageTag, outDir string) {
+func artifactConverterWorker(convertRequests chan *convertRequest, convertedResults chan *convertResult, releaseVersion, tmpDir, imageTag, outDir string, digests []string, options converterOptions) {
 	const (
 		initrdArtifactType = "initrd"
 	)
@@ -199,10 +458,22 @@ func artifactConverterWorker(convertRequests chan *convertRequest, convertedResu
 
 		workingArtifactPath := req.inputPath
 		isInputFile := req.isInputFile
+		var sidecars []string
+
+		// Check the virtual size first, an image which is too large is not worth converting
+		virtualSize, err := imageVirtualSize(req.inputPath, req.isInputFile)
+		if err == nil {
+			err = req.artifact.CheckSize(0, virtualSize)
+		}
+		if err != nil {
+			logger.Log.Errorf("Failed to check the size of artifact (%s). Error: %s", req.artifact.Name, err)
+			convertedResults <- result
+			continue
+		}
 
 		if req.artifact.Type != "" {
 			const appendExtension = false
-			outputFile, err := convertArtifact(fullArtifactName, tmpDir, req.artifact.Type, imageTag, workingArtifactPath, isInputFile, appendExtension)
+			outputFile, typeSidecars, err := convertArtifact(fullArtifactName, tmpDir, req.artifact.Type, imageTag, workingArtifactPath, isInputFile, appendExtension, options)
 			if err != nil {
 				logger.Log.Errorf("Failed to convert artifact (%s) to type (%s). Error: %s", req.artifact.Name, req.artifact.Type, err)
 				convertedResults <- result
@@ -210,13 +481,15 @@ func artifactConverterWorker(convertRequests chan *convertRequest, convertedResu
 			}
 			isInputFile = true
 			workingArtifactPath = outputFile
+			sidecars = typeSidecars
 		}
 
 		if req.artifact.Compression != "" {
 			const appendExtension = true
-			outputFile, err := convertArtifact(fullArtifactName, tmpDir, req.artifact.Compression, imageTag, workingArtifactPath, isInputFile, appendExtension)
+			outputFile, _, err := convertArtifact(fullArtifactName, tmpDir, req.artifact.Compression, imageTag, workingArtifactPath, isInputFile, appendExtension, options)
 			if err != nil {
 				logger.Log.Errorf("Failed to compress (%s) using (%s). Error: %s", workingArtifactPath, req.artifact.Compression, err)
+				removeFiles(sidecars)
 				convertedResults <- result
 				continue
 			}
@@ -225,12 +498,27 @@ func artifactConverterWorker(convertRequests chan *convertRequest, convertedResu
 
 		if workingArtifactPath == req.inputPath {
 			logger.Log.Errorf("Artifact (%s) has no type or compression", req.artifact.Name)
+		} else if err = checkArtifactFileSize(req.artifact, workingArtifactPath, virtualSize); err != nil {
+			logger.Log.Errorf("Artifact (%s) is too large. Error: %s", req.artifact.Name, err)
+			os.Remove(workingArtifactPath)
+			removeFiles(sidecars)
 		} else {
-			finalFile := filepath.Join(outDir, filepath.Base(workingArtifactPath))
-			err := file.Move(workingArtifactPath, finalFile)
-			if err != nil {
-				logger.Log.Errorf("Failed to move (%s) to (%s). Error: %s", workingArtifactPath, finalFile, err)
-			} else {
+			// The sidecars, such as the metadata of a patch, are published along with the artifact
+			var finalFile string
+			for _, producedFile := range append(append([]string{}, sidecars...), workingArtifactPath) {
+				finalFile = filepath.Join(outDir, filepath.Base(producedFile))
+				err = file.Move(producedFile, finalFile)
+				if err != nil {
+					logger.Log.Errorf("Failed to move (%s) to (%s). Error: %s", producedFile, finalFile, err)
+					break
+				}
+				err = writeChecksumFiles(finalFile, digests)
+				if err != nil {
+					logger.Log.Errorf("Failed to write checksums of (%s). Error: %s", finalFile, err)
+					break
+				}
+			}
+			if err == nil {
 				result.convertedFile = finalFile
 			}
 		}
@@ -239,8 +527,107 @@ func artifactConverterWorker(convertRequests chan *convertRequest, convertedResu
 	}
 }
 
-func convertArtifact(artifactName, outDir, format, imageTag, input string, isInputFile, appendExtension bool) (outputFile string, err error) {
-	typeConverter, err := converterFactory(format)
+// removeFiles removes the temporary files of an artifact which is not published
+func removeFiles(paths []string) {
+	for _, path := range paths {
+		os.Remove(path)
+	}
+}
+
+// imageVirtualSize returns the size of the image an artifact is converted from: the apparent size of a
+// raw disk or partition file, which counts its sparse holes, or the total size of a rootfs directory's files.
+func imageVirtualSize(inputPath string, isInputFile bool) (virtualSize uint64, err error) {
+	if isInputFile {
+		var info os.FileInfo
+		info, err = os.Stat(inputPath)
+		if err != nil {
+			return
+		}
+		return uint64(info.Size()), nil
+	}
+
+	err = filepath.Walk(inputPath, func(_ string, info os.FileInfo, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if info.Mode().IsRegular() {
+			virtualSize += uint64(info.Size())
+		}
+		return nil
+	})
+	return
+}
+
+// checkArtifactFileSize checks a converted artifact file against its MaxSize.
+func checkArtifactFileSize(artifact configuration.Artifact, artifactPath string, virtualSize uint64) (err error) {
+	info, err := os.Stat(artifactPath)
+	if err != nil {
+		return
+	}
+
+	return artifact.CheckSize(uint64(info.Size()), virtualSize)
+}
+
+// sizeCountingWriter counts the bytes written through it.
+type sizeCountingWriter struct {
+	writer io.Writer
+	size   uint64
+}
+
+func (w *sizeCountingWriter) Write(p []byte) (n int, err error) {
+	n, err = w.writer.Write(p)
+	w.size += uint64(n)
+	return
+}
+
+// writeChecksumFiles writes a "<artifact>.<digest>" file for each digest next to an artifact, holding
+// "<hex>  <file name>" as sha256sum and sha512sum write it. The artifact is only read once.
+func writeChecksumFiles(artifactPath string, digests []string) (err error) {
+	if len(digests) == 0 {
+		return
+	}
+
+	hashes := make(map[string]hash.Hash, len(digests))
+	writers := make([]io.Writer, 0, len(digests))
+	for _, digest := range digests {
+		if _, ok := hashes[digest]; ok {
+			continue
+		}
+		switch digest {
+		case sha256Digest:
+			hashes[digest] = sha256.New()
+		case sha512Digest:
+			hashes[digest] = sha512.New()
+		default:
+			return fmt.Errorf("unsupported checksum digest: %s", digest)
+		}
+		writers = append(writers, hashes[digest])
+	}
+
+	artifactFile, err := os.Open(artifactPath)
+	if err != nil {
+		return
+	}
+	defer artifactFile.Close()
+
+	_, err = io.Copy(io.MultiWriter(writers...), artifactFile)
+	if err != nil {
+		return
+	}
+
+	for digest, digestHash := range hashes {
+		checksumPath := fmt.Sprintf("%s.%s", artifactPath, digest)
+		logger.Log.Infof("Writing %s checksum to (%s)", digest, checksumPath)
+		err = file.Write(fmt.Sprintf("%s  %s\n", hex.EncodeToString(digestHash.Sum(nil)), filepath.Base(artifactPath)), checksumPath)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func convertArtifact(artifactName, outDir, format, imageTag, input string, isInputFile, appendExtension bool, options converterOptions) (outputFile string, sidecars []string, err error) {
+	typeConverter, err := converterFactory(format, options)
 	if err != nil {
 		return
 	}
@@ -264,19 +651,30 @@ func convertArtifact(artifactName, outDir, format, imageTag, input string, isInp
 	outputFile = fmt.Sprintf("%s%s%s", outputPath, imageTag, newExt)
 
 	err = typeConverter.Convert(input, outputFile, isInputFile)
+	if err != nil {
+		return
+	}
+
+	if sidecarConverter, ok := typeConverter.(formats.SidecarConverter); ok {
+		sidecars = sidecarConverter.Sidecars(outputFile)
+	}
 	return
 }
 
-func converterFactory(formatType string) (converter formats.Converter, err error) {
+func converterFactory(formatType string, options converterOptions) (converter formats.Converter, err error) {
 	switch formatType {
 	case formats.RawType:
 		converter = formats.NewRaw()
+	case formats.TarType:
+		converter = formats.NewTar()
 	case formats.Ext4Type:
 		converter = formats.NewExt4()
 	case formats.DiffType:
 		converter = formats.NewDiff()
 	case formats.RdiffType:
 		converter = formats.NewRdiff()
+	case formats.ZstdPatchType:
+		converter = formats.NewZstdPatch()
 	case formats.GzipType:
 		converter = formats.NewGzip()
 	case formats.TarGzipType:
@@ -287,16 +685,22 @@ func converterFactory(formatType string) (converter formats.Converter, err error
 		converter = formats.NewTarXz()
 	case formats.VhdType:
 		const gen2 = false
-		converter = formats.NewVhd(gen2)
+		converter = formats.NewVhd(gen2, options.qemuImg)
 	case formats.VhdxType:
 		const gen2 = true
-		converter = formats.NewVhd(gen2)
+		converter = formats.NewVhd(gen2, options.qemuImg)
+	case formats.AzureVhdType:
+		converter = formats.NewAzureVhd(options.qemuImg)
 	case formats.InitrdType:
 		converter = formats.NewInitrd()
 	case formats.OvaType:
-		converter = formats.NewOva()
+		converter = formats.NewOva(options.qemuImg)
 	case formats.QcowType:
-		converter = formats.NewQcow()
+		converter = formats.NewQcow(options.qemuImg)
+	case formats.VmdkType:
+		converter = formats.NewVmdk(options.qemuImg)
+	case formats.VsphereOvaType:
+		converter = formats.NewVsphereOva(options.ova, options.qemuImg)
 	default:
 		err = fmt.Errorf("unsupported output format: %s", formatType)
 	}
@@ -304,12 +708,16 @@ func converterFactory(formatType string) (converter formats.Converter, err error
 	return
 }
 
-func diskArtifactInput(diskIndex int, disk configuration.Disk) (input string, isFile bool) {
+func diskArtifactInput(diskIndex int, disk configuration.Disk, artifact configuration.Artifact) (input string, isFile bool) {
 	const rootfsPrefix = "rootfs"
 
 	// If there are no paritions, this is a rootfs
 	if len(disk.Partitions) == 0 {
 		input = rootfsPrefix
+	} else if artifact.Type == formats.TarType {
+		// The imager archives the disk's mounted file systems for a tar artifact
+		input = fmt.Sprintf("disk%d.rootfs.tar", diskIndex)
+		isFile = true
 	} else {
 		input = fmt.Sprintf("disk%d.raw", diskIndex)
 		isFile = true
@@ -324,6 +732,8 @@ func partitionArtifactInput(diskIndex, partitionIndex int, partitionSetting *con
 		input = fmt.Sprintf("disk%d.partition%d.diff", diskIndex, partitionIndex)
 	} else if partitionSetting.RdiffBaseImage != "" {
 		input = fmt.Sprintf("disk%d.partition%d.rdiff", diskIndex, partitionIndex)
+	} else if partitionSetting.DeltaBaseImage != "" {
+		input = fmt.Sprintf("disk%d.partition%d.zstdpatch", diskIndex, partitionIndex)
 	} else {
 		input = fmt.Sprintf("disk%d.partition%d.raw", diskIndex, partitionIndex)
 	}